@@ -0,0 +1,160 @@
+// Package chaos injects faults into RAIS for operational drills: delayed
+// decodes, failed S3 fetches, and dropped cache entries.  The hooks are always
+// present, but do nothing unless RAIS and its plugins are built with the
+// "chaos" tag, e.g., "make TAGS=chaos".  Faults are then controlled at runtime
+// via the admin API (see Route); they're all off until something turns them
+// on.
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// Logger is used to report changes to the fault settings
+var Logger = logger.Named("rais/chaos", logger.Debug)
+
+// Settings describes which faults are injected, and how often.  Percentages
+// are from 0 to 100.
+type Settings struct {
+	DecodeDelayPercent float64
+	DecodeDelay        time.Duration
+	S3FailPercent      float64
+	CacheDropPercent   float64
+}
+
+// settingsJSON is how Settings are reported, with a readable delay
+type settingsJSON struct {
+	Enabled            bool
+	DecodeDelayPercent float64
+	DecodeDelay        string
+	S3FailPercent      float64
+	CacheDropPercent   float64
+}
+
+var m sync.RWMutex
+var current Settings
+
+// Current returns the fault settings in effect
+func Current() Settings {
+	m.RLock()
+	defer m.RUnlock()
+	return current
+}
+
+// Set replaces the fault settings
+func Set(s Settings) {
+	m.Lock()
+	current = s
+	m.Unlock()
+}
+
+// roll returns true for roughly pct percent of calls
+func roll(pct float64) bool {
+	return pct > 0 && rand.Float64()*100 < pct
+}
+
+// DelayDecode sleeps for the configured delay on the configured percentage of
+// decodes
+func DelayDecode() {
+	if !Enabled {
+		return
+	}
+	var s = Current()
+	if roll(s.DecodeDelayPercent) {
+		time.Sleep(s.DecodeDelay)
+	}
+}
+
+// FailS3 returns an error for the configured percentage of S3 fetches
+func FailS3() error {
+	if Enabled && roll(Current().S3FailPercent) {
+		return fmt.Errorf("chaos: injected S3 failure")
+	}
+	return nil
+}
+
+// DropCache returns true for the configured percentage of cache hits, which
+// callers should treat as misses
+func DropCache() bool {
+	return Enabled && roll(Current().CacheDropPercent)
+}
+
+// parseSettings applies the form values in req to s: "decode-delay-percent",
+// "decode-delay" (a duration like "2s"), "s3-fail-percent", and
+// "cache-drop-percent".  Values not in the request are left alone, and
+// "reset" turns every fault off first.
+func parseSettings(req *http.Request, s Settings) (Settings, error) {
+	var err = req.ParseForm()
+	if err != nil {
+		return s, err
+	}
+	if req.Form.Get("reset") != "" {
+		s = Settings{}
+	}
+
+	var percents = map[string]*float64{
+		"decode-delay-percent": &s.DecodeDelayPercent,
+		"s3-fail-percent":      &s.S3FailPercent,
+		"cache-drop-percent":   &s.CacheDropPercent,
+	}
+	for name, p := range percents {
+		var val = req.Form.Get(name)
+		if val == "" {
+			continue
+		}
+		var pct, err = strconv.ParseFloat(val, 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return s, fmt.Errorf("%s must be a number from 0 to 100", name)
+		}
+		*p = pct
+	}
+
+	if val := req.Form.Get("decode-delay"); val != "" {
+		var d, err = time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return s, fmt.Errorf("decode-delay must be a duration, such as \"2s\"")
+		}
+		s.DecodeDelay = d
+	}
+	return s, nil
+}
+
+// Route handles the admin API: GET reports the fault settings as JSON, and
+// POST changes them, taking the values described in parseSettings
+func Route(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var s, err = parseSettings(req, Current())
+		if err != nil {
+			http.Error(w, "Invalid chaos settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		Set(s)
+		Logger.Warnf("Chaos settings changed: %g%% of decodes delayed by %s, %g%% of S3 fetches fail, "+
+			"%g%% of cache hits dropped", s.DecodeDelayPercent, s.DecodeDelay, s.S3FailPercent, s.CacheDropPercent)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var s = Current()
+	var data, _ = json.Marshal(settingsJSON{
+		Enabled:            Enabled,
+		DecodeDelayPercent: s.DecodeDelayPercent,
+		DecodeDelay:        s.DecodeDelay.String(),
+		S3FailPercent:      s.S3FailPercent,
+		CacheDropPercent:   s.CacheDropPercent,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
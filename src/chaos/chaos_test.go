@@ -0,0 +1,59 @@
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func post(vals url.Values) *http.Request {
+	var req = httptest.NewRequest("POST", "/admin/chaos", strings.NewReader(vals.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestParseSettings(t *testing.T) {
+	var s, err = parseSettings(post(url.Values{"decode-delay-percent": {"25"}, "decode-delay": {"2s"}}), Settings{S3FailPercent: 5})
+	assert.NilError(err, "valid settings", t)
+	assert.Equal(Settings{DecodeDelayPercent: 25, DecodeDelay: 2 * time.Second, S3FailPercent: 5}, s, "settings", t)
+
+	s, err = parseSettings(post(url.Values{"reset": {"1"}, "cache-drop-percent": {"50"}}), s)
+	assert.NilError(err, "reset", t)
+	assert.Equal(Settings{CacheDropPercent: 50}, s, "reset clears everything else", t)
+
+	_, err = parseSettings(post(url.Values{"s3-fail-percent": {"101"}}), s)
+	assert.True(err != nil, "percent over 100", t)
+	_, err = parseSettings(post(url.Values{"decode-delay": {"soon"}}), s)
+	assert.True(err != nil, "invalid duration", t)
+}
+
+func TestRoll(t *testing.T) {
+	assert.False(roll(0), "zero percent never fires", t)
+	assert.True(roll(100), "100 percent always fires", t)
+}
+
+func TestRoute(t *testing.T) {
+	defer Set(Settings{})
+	var w = httptest.NewRecorder()
+	Route(w, post(url.Values{"s3-fail-percent": {"10"}}))
+	assert.Equal(http.StatusOK, w.Code, "POST", t)
+	assert.Equal(10.0, Current().S3FailPercent, "setting applied", t)
+	assert.True(strings.Contains(w.Body.String(), `"S3FailPercent":10`), "settings reported", t)
+
+	w = httptest.NewRecorder()
+	Route(w, post(url.Values{"s3-fail-percent": {"x"}}))
+	assert.Equal(http.StatusBadRequest, w.Code, "invalid POST", t)
+
+	w = httptest.NewRecorder()
+	Route(w, httptest.NewRequest("DELETE", "/admin/chaos", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code, "DELETE", t)
+
+	if !Enabled {
+		assert.NilError(FailS3(), "hooks do nothing without the chaos tag", t)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !chaos
+// +build !chaos
+
+package chaos
+
+// Enabled is false unless RAIS is built with the "chaos" tag, so the fault
+// hooks compile down to nothing in normal builds
+const Enabled = false
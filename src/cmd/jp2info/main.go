@@ -4,6 +4,7 @@
 	"fmt"
 	"os"
 	"rais/src/jp2info"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 )
@@ -48,6 +49,21 @@ func printScanResults(i *jp2info.Info, err error) {
 }
 
 func printInfo(i *jp2info.Info) {
-	fmt.Printf("dim:%dx%d tiles:%dx%d levels:%d %s\n",
-		i.Width, i.Height, i.TileWidth(), i.TileHeight(), i.Levels, i.ColorSpace.String())
+	var markers []string
+	if i.TLM {
+		markers = append(markers, fmt.Sprintf("TLM(%d tile-parts)", len(i.TileParts)))
+	}
+	if i.PLM {
+		markers = append(markers, "PLM")
+	}
+	if i.PLT {
+		markers = append(markers, "PLT")
+	}
+	if len(markers) == 0 {
+		markers = append(markers, "none")
+	}
+
+	fmt.Printf("%s dim:%dx%d tiles:%dx%d levels:%d %s pointers:%s\n",
+		i.Container, i.Width, i.Height, i.TileWidth(), i.TileHeight(), i.Levels, i.ColorSpace.String(),
+		strings.Join(markers, ","))
 }
@@ -3,6 +3,7 @@
 import (
 	"net/http"
 	"rais/src/iiif"
+	"strings"
 )
 
 func (s *serverStats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -32,3 +33,32 @@ func adminPurgeCache(w http.ResponseWriter, req *http.Request) {
 
 	w.Write([]byte("OK"))
 }
+
+// RefreshRoute handles "POST /admin/refresh/{id}", dropping everything RAIS
+// has cached for a single image.  If the "preload" form value is set, the
+// image's header is read immediately so the first viewer request is fast.
+func (ih *ImageHandler) RefreshRoute(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id = iiif.URLToID(strings.TrimPrefix(req.URL.EscapedPath(), "/admin/refresh/"))
+	if id == "" {
+		http.Error(w, "an image ID is required", http.StatusBadRequest)
+		return
+	}
+
+	expireCachedImage(id)
+
+	if req.PostFormValue("preload") != "" {
+		var _, e = ih.getInfo(id, ih.getIIIFPath(id))
+		if e != nil {
+			http.Error(w, e.Message, e.Code)
+			return
+		}
+	}
+
+	w.Write([]byte("OK"))
+}
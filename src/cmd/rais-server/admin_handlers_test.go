@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRefreshRoute(t *testing.T) {
+	var oldCache, oldPlugins = infoCache, expireCachedImagePlugins
+	defer func() { infoCache, expireCachedImagePlugins = oldCache, oldPlugins }()
+	infoCache, _ = newMemoryInfoCache(10, 0)
+	expireCachedImagePlugins = []func(iiif.ID){func(id iiif.ID) { infoCache.Remove(id) }}
+
+	var h = NewImageHandler(rootDir(), "/iiif")
+	var id = iiif.ID("docker/images/testfile/test-world-link.jp2")
+	h.getInfo(id, h.getIIIFPath(id))
+	assert.Equal(1, infoCache.Len(), "info is cached", t)
+
+	var post = func(form url.Values) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var req = httptest.NewRequest("POST", "/admin/refresh/"+id.Escaped(), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.RefreshRoute(w, req)
+		return w
+	}
+
+	var w = post(nil)
+	assert.Equal(200, w.Code, "refresh status", t)
+	assert.Equal(0, infoCache.Len(), "info is expired", t)
+
+	w = post(url.Values{"preload": {"1"}})
+	assert.Equal(200, w.Code, "refresh with preload status", t)
+	assert.Equal(1, infoCache.Len(), "info is re-read", t)
+
+	w = httptest.NewRecorder()
+	h.RefreshRoute(w, httptest.NewRequest("GET", "/admin/refresh/"+id.Escaped(), nil))
+	assert.Equal(405, w.Code, "refresh requires POST", t)
+}
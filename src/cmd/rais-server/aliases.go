@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+)
+
+// readAliases parses an alias file: one old ID and its replacement per line,
+// separated by whitespace.  IDs are written the way they appear in URLs, so
+// an ID containing spaces or slashes must be escaped.  Blank lines and lines
+// starting with "#" are ignored.
+//
+// When foldCase is true, old IDs are folded to lowercase so they match
+// requests normalized for CaseInsensitiveIDs.  Aliases which lead back to an
+// ID already in their chain are rejected, as they'd redirect forever.
+func readAliases(fp string, foldCase bool) (map[iiif.ID]iiif.ID, error) {
+	var f, err = os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var aliases = make(map[iiif.ID]iiif.ID)
+	var scanner = bufio.NewScanner(f)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var fields = strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an old and new ID", lineNum)
+		}
+		var ids [2]iiif.ID
+		for i, field := range fields {
+			var id, err = url.PathUnescape(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid ID %q: %s", lineNum, field, err)
+			}
+			ids[i] = iiif.ID(id)
+		}
+		if foldCase {
+			ids[0] = foldID(ids[0])
+		}
+		if ids[0] == ids[1] || foldCase && ids[0] == foldID(ids[1]) {
+			return nil, fmt.Errorf("line %d: %q is aliased to itself", lineNum, ids[0])
+		}
+		if _, ok := aliases[ids[0]]; ok {
+			return nil, fmt.Errorf("line %d: %q is aliased more than once", lineNum, ids[0])
+		}
+		aliases[ids[0]] = ids[1]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for id := range aliases {
+		var seen = map[iiif.ID]bool{id: true}
+		for next, ok := aliases[id]; ok; next, ok = aliases[next] {
+			if foldCase {
+				next = foldID(next)
+			}
+			if seen[next] {
+				return nil, fmt.Errorf("%q is part of an alias cycle", id)
+			}
+			seen[next] = true
+		}
+	}
+
+	return aliases, nil
+}
+
+// aliasFor returns the ID which replaces the given one, checking the alias
+// file first and then any IDAlias plugins.  ok is false if the ID hasn't been
+// replaced.
+func (ih *ImageHandler) aliasFor(id iiif.ID) (newID iiif.ID, ok bool) {
+	newID, ok = ih.Aliases[id]
+	if ok {
+		return newID, true
+	}
+
+	for _, alias := range idAliasPlugins {
+		var newID, err = alias(id)
+		if err == nil {
+			return newID, newID != id
+		}
+		if err != plugins.ErrSkipped {
+			Logger.Warnf("Error trying to use plugin to alias iiif.ID: %s", err)
+		}
+	}
+	return "", false
+}
+
+// redirectAlias sends a 301 to the same request under the image's new ID if
+// the requested ID has been replaced.  Returns true if a redirect was sent.
+func (ih *ImageHandler) redirectAlias(w http.ResponseWriter, req *http.Request, u *iiif.URL) bool {
+	var newID, ok = ih.aliasFor(u.ID)
+	if !ok {
+		return false
+	}
+
+	var path = newID.Escaped() + "/info.json"
+	if !u.Info {
+		path = newID.Escaped() + strings.TrimPrefix(requestPath(u), u.ID.Escaped())
+	}
+	http.Redirect(w, req, ih.WebPathPrefix+"/"+path, http.StatusMovedPermanently)
+	return true
+}
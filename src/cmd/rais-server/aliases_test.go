@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestReadAliases(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-aliases-")
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "aliases.txt")
+
+	ioutil.WriteFile(fp, []byte("# migrated\nold%2Fa.jp2   new%2Fa.jp2\n\nold%20b new-b\n"), 0644)
+	var aliases, err = readAliases(fp, false)
+	assert.NilError(err, "reading valid alias file", t)
+	assert.Equal(2, len(aliases), "alias count", t)
+	assert.Equal(iiif.ID("new/a.jp2"), aliases["old/a.jp2"], "escaped slashes", t)
+	assert.Equal(iiif.ID("new-b"), aliases["old b"], "escaped space", t)
+
+	ioutil.WriteFile(fp, []byte("a b c\n"), 0644)
+	_, err = readAliases(fp, false)
+	assert.True(err != nil, "too many fields is an error", t)
+
+	ioutil.WriteFile(fp, []byte("a b\na c\n"), 0644)
+	_, err = readAliases(fp, false)
+	assert.True(err != nil, "duplicate alias is an error", t)
+
+	ioutil.WriteFile(fp, []byte("a b\nb c\nc a\n"), 0644)
+	_, err = readAliases(fp, false)
+	assert.True(err != nil, "alias cycle is an error", t)
+
+	ioutil.WriteFile(fp, []byte("a b\nb c\n"), 0644)
+	_, err = readAliases(fp, false)
+	assert.NilError(err, "alias chain without a cycle", t)
+
+	ioutil.WriteFile(fp, []byte("Old.jp2 New.jp2\n"), 0644)
+	aliases, err = readAliases(fp, true)
+	assert.NilError(err, "reading with case folding", t)
+	assert.Equal(iiif.ID("New.jp2"), aliases["old.jp2"], "old ID is folded", t)
+
+	ioutil.WriteFile(fp, []byte("A b\nB a\n"), 0644)
+	_, err = readAliases(fp, true)
+	assert.True(err != nil, "alias cycle differing only by case is an error", t)
+
+	ioutil.WriteFile(fp, []byte("A a\n"), 0644)
+	_, err = readAliases(fp, true)
+	assert.True(err != nil, "alias to itself differing only by case is an error", t)
+}
+
+func TestAliasRedirect(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.Aliases = map[iiif.ID]iiif.ID{"old.jp2": "docker/images/testfile/test-world-link.jp2"}
+	var newID = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/old.jp2/info.json", nil))
+	assert.Equal(http.StatusMovedPermanently, w.Code, "info request for old ID", t)
+	assert.Equal("/iiif/"+newID+"/info.json", w.Header().Get("Location"), "info redirect location", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/old.jp2/full/max/0/default.jpg", nil))
+	assert.Equal(http.StatusMovedPermanently, w.Code, "image request for old ID", t)
+	assert.Equal("/iiif/"+newID+"/full/max/0/default.jpg", w.Header().Get("Location"), "image redirect location", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+newID+"/full/80,/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "new ID is served", t)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// AuthTokenPath is where the IIIF Auth API's access token service lives on
+// the public server
+const AuthTokenPath = "/auth/token"
+
+// AuthService protects images whose IDs start with any of Prefixes, using the
+// IIIF Authentication API 1.0.  An external login application is responsible
+// for authenticating users; it must set a cookie named CookieName containing a
+// token signed with Secret (see signAuthToken).  RAIS validates that cookie on
+// image requests, and exchanges it for access tokens which viewers send with
+// info.json requests.
+type AuthService struct {
+	Prefixes    []string
+	LoginURL    string
+	LogoutURL   string
+	Label       string
+	Header      string
+	Description string
+	CookieName  string
+	Secret      []byte
+	TokenTTL    time.Duration
+
+	// Degraded lists prefixes for which unauthorized users get reduced
+	// resolution access rather than none at all
+	Degraded []DegradedTier
+}
+
+// DegradedTier limits unauthorized users to a resolution which fits within
+// MaxSize pixels on each side for restricted IDs starting with Prefix
+type DegradedTier struct {
+	Prefix  string
+	MaxSize int
+}
+
+// readAuthService builds the auth service from the Auth* settings, returning
+// nil if no IDs are restricted
+func readAuthService() (*AuthService, error) {
+	var a = &AuthService{
+		LoginURL:    viper.GetString("AuthLoginURL"),
+		LogoutURL:   viper.GetString("AuthLogoutURL"),
+		Label:       viper.GetString("AuthLabel"),
+		Header:      viper.GetString("AuthHeader"),
+		Description: viper.GetString("AuthDescription"),
+		CookieName:  viper.GetString("AuthCookieName"),
+		Secret:      []byte(viper.GetString("AuthSecret")),
+		TokenTTL:    viper.GetDuration("AuthTokenTTL"),
+	}
+	for _, prefix := range strings.Split(viper.GetString("AuthPrefixes"), ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" {
+			a.Prefixes = append(a.Prefixes, prefix)
+		}
+	}
+
+	if len(a.Prefixes) == 0 {
+		return nil, nil
+	}
+	var err = viper.UnmarshalKey("AuthDegraded", &a.Degraded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AuthDegraded: %s", err)
+	}
+	for _, tier := range a.Degraded {
+		if tier.MaxSize < 1 {
+			return nil, fmt.Errorf("AuthDegraded entry %q must have a positive MaxSize", tier.Prefix)
+		}
+	}
+	if a.LoginURL == "" {
+		return nil, errors.New("AuthLoginURL must be set when AuthPrefixes is used")
+	}
+	if len(a.Secret) == 0 {
+		return nil, errors.New("AuthSecret must be set when AuthPrefixes is used")
+	}
+	if a.CookieName == "" {
+		return nil, errors.New("AuthCookieName must not be empty")
+	}
+	if a.TokenTTL <= 0 {
+		return nil, errors.New("AuthTokenTTL must be positive")
+	}
+	return a, nil
+}
+
+// restricts returns true if the given ID requires authorization
+func (a *AuthService) restricts(id iiif.ID) bool {
+	if a == nil {
+		return false
+	}
+	for _, prefix := range a.Prefixes {
+		if strings.HasPrefix(string(id), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// degradedSize returns the largest width or height unauthorized users may
+// see for the given ID, or 0 if they get no access at all
+func (a *AuthService) degradedSize(id iiif.ID) int {
+	if a == nil {
+		return 0
+	}
+	for _, tier := range a.Degraded {
+		if strings.HasPrefix(string(id), tier.Prefix) {
+			return tier.MaxSize
+		}
+	}
+	return 0
+}
+
+// degradedLimit returns the largest full-image size, preserving aspect ratio,
+// which fits in a square of the given size.  Images already smaller than the
+// square are left alone.
+func degradedLimit(w, h, size int) image.Rectangle {
+	var full = image.Rect(0, 0, w, h)
+	if w <= size && h <= size {
+		return full
+	}
+	return iiif.Size{Type: iiif.STBestFit, W: size, H: size}.GetResize(full)
+}
+
+// degradeInfo restricts the info document to what unauthorized users may
+// request: maximum dimensions, sizes, and scale factors are limited to the
+// degraded resolution
+func degradeInfo(info *iiif.Info, limit image.Rectangle) {
+	var lw, lh = limit.Dx(), limit.Dy()
+	if info.Profile.MaxWidth == 0 || info.Profile.MaxWidth > lw {
+		info.Profile.MaxWidth = lw
+	}
+	if info.Profile.MaxHeight == 0 || info.Profile.MaxHeight > lh {
+		info.Profile.MaxHeight = lh
+	}
+
+	var sizes []iiif.ImageSize
+	for _, sz := range info.Sizes {
+		if sz.Width <= lw && sz.Height <= lh {
+			sizes = append(sizes, sz)
+		}
+	}
+	info.Sizes = sizes
+
+	var tiles []iiif.TileSize
+	for _, ts := range info.Tiles {
+		var sf []int
+		for _, s := range ts.ScaleFactors {
+			if (info.Width+s-1)/s <= lw && (info.Height+s-1)/s <= lh {
+				sf = append(sf, s)
+			}
+		}
+		if len(sf) > 0 {
+			ts.ScaleFactors = sf
+			tiles = append(tiles, ts)
+		}
+	}
+	info.Tiles = tiles
+}
+
+// exceedsDegraded returns true if the request's output resolution is finer
+// than the degraded limit allows, regardless of how small the output is.  A
+// one-pixel allowance covers rounding in region and size calculations.
+func exceedsDegraded(info *iiif.Info, crop, scale, limit image.Rectangle) bool {
+	if crop.Dx() == 0 || crop.Dy() == 0 {
+		return false
+	}
+	var rw = int64(scale.Dx()) * int64(info.Width) / int64(crop.Dx())
+	var rh = int64(scale.Dy()) * int64(info.Height) / int64(crop.Dy())
+	return rw > int64(limit.Dx())+1 || rh > int64(limit.Dy())+1
+}
+
+// service returns the login service description for info.json, pointing
+// clients at the token service under baseURL
+func (a *AuthService) service(baseURL string) iiif.Service {
+	var s = iiif.Service{
+		Context:     iiif.AuthContext,
+		ID:          a.LoginURL,
+		Profile:     iiif.AuthLoginProfile,
+		Label:       a.Label,
+		Header:      a.Header,
+		Description: a.Description,
+		Service:     []iiif.Service{{ID: baseURL + AuthTokenPath, Profile: iiif.AuthTokenProfile}},
+	}
+	if a.LogoutURL != "" {
+		s.Service = append(s.Service, iiif.Service{ID: a.LogoutURL, Profile: iiif.AuthLogoutProfile})
+	}
+	return s
+}
+
+// signAuthToken returns a token valid until the given time, in the form
+// "<unix expiry>.<hex HMAC-SHA256 of the expiry>"
+func (a *AuthService) signAuthToken(expires time.Time) string {
+	var exp = strconv.FormatInt(expires.Unix(), 10)
+	var mac = hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// tokenExpiry returns the token's expiration time if its signature is valid
+// and it hasn't yet expired
+func (a *AuthService) tokenExpiry(token string, now time.Time) (time.Time, bool) {
+	var parts = strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return time.Time{}, false
+	}
+	var unix, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var expires = time.Unix(unix, 0)
+	if !hmac.Equal([]byte(a.signAuthToken(expires)), []byte(token)) || !now.Before(expires) {
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// cookieExpiry returns the expiration of the request's login cookie if it's
+// present and valid
+func (a *AuthService) cookieExpiry(req *http.Request, now time.Time) (time.Time, bool) {
+	var c, err = req.Cookie(a.CookieName)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return a.tokenExpiry(c.Value, now)
+}
+
+// authorized returns true if the request carries a valid access token or
+// login cookie.  Viewers send access tokens with info.json requests, while
+// browsers send cookies with image requests.
+func (a *AuthService) authorized(req *http.Request) bool {
+	var now = time.Now()
+	var bearer = req.Header.Get("Authorization")
+	if strings.HasPrefix(bearer, "Bearer ") {
+		var _, ok = a.tokenExpiry(strings.TrimPrefix(bearer, "Bearer "), now)
+		if ok {
+			return true
+		}
+	}
+
+	var _, ok = a.cookieExpiry(req, now)
+	return ok
+}
+
+// denyInfo responds to an unauthorized info.json request with a 401 and the
+// info document, so viewers can find the login service
+func denyInfo(w http.ResponseWriter, info *iiif.Info, version int) {
+	var data, err = marshalInfo(info, version)
+	if err != nil {
+		http.Error(w, err.Message, err.Code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(data)
+}
+
+// tokenResponse holds the fields of an access token service response: either
+// a token and its lifetime, or an error and description
+type tokenResponse struct {
+	MessageID   string `json:"messageId,omitempty"`
+	AccessToken string `json:"accessToken,omitempty"`
+	ExpiresIn   int    `json:"expiresIn,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// TokenRoute is the IIIF Auth API access token service.  A valid login cookie
+// is exchanged for an access token good for TokenTTL or until the cookie
+// expires, whichever is sooner.  When the messageId and origin parameters are
+// present, the response is an HTML page which posts the token to the viewer's
+// window; otherwise it's plain JSON.
+func (a *AuthService) TokenRoute(w http.ResponseWriter, req *http.Request) {
+	var now = time.Now()
+	var query = req.URL.Query()
+	var tr = tokenResponse{MessageID: query.Get("messageId")}
+	var status = http.StatusOK
+
+	var expires, ok = a.cookieExpiry(req, now)
+	if ok {
+		if max := now.Add(a.TokenTTL); expires.After(max) {
+			expires = max
+		}
+		tr.AccessToken = a.signAuthToken(expires)
+		tr.ExpiresIn = int(expires.Sub(now).Seconds())
+	} else {
+		status = http.StatusUnauthorized
+		tr.Error, tr.Description = "missingCredentials", "no valid login cookie was sent"
+		if _, err := req.Cookie(a.CookieName); err == nil {
+			tr.Error, tr.Description = "invalidCredentials", "the login cookie is invalid or expired"
+		}
+	}
+
+	var data, err = json.Marshal(tr)
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+
+	var origin = query.Get("origin")
+	if tr.MessageID == "" || origin == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(status)
+		w.Write(data)
+		return
+	}
+
+	// json.Marshal escapes "<" and ">", so neither value can break out of the
+	// script tag
+	var originJSON, _ = json.Marshal(origin)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html><html><body><script>window.parent.postMessage(%s, %s);</script></body></html>",
+		data, originJSON)
+}
+
+// deny responds to an unauthorized request with a 401; info requests get the
+// info document as well
+func (ih *ImageHandler) deny(w http.ResponseWriter, req *http.Request, u *iiif.URL, info *iiif.Info) {
+	if u.Info {
+		denyInfo(w, info, ih.infoVersion(req))
+	} else {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+	}
+}
+
+// denyProxied refuses an unauthorized request for a proxied ID.  We have no
+// local info to degrade, so restricted proxied images are all or nothing, but
+// info requests still get a stub document advertising the login service.
+func (ih *ImageHandler) denyProxied(w http.ResponseWriter, req *http.Request, u *iiif.URL, infoID, baseURL string) {
+	var info = iiif.NewInfo()
+	info.ID = infoID
+	if ih.Auth.restricts(u.ID) {
+		info.Service = append(info.Service, ih.Auth.service(baseURL))
+	}
+	ih.deny(w, req, u, info)
+}
+
+// serveDegraded handles restricted requests from unauthorized users.  If the
+// ID has no degraded tier, or the request needs more resolution than the tier
+// allows, a 401 is sent.  Requests for "max" or "full" size are redirected to an
+// explicit size so they can't share cached or in-flight results with
+// authorized requests.  Otherwise the info is restricted to the degraded
+// resolution and true is returned so the request can be served normally.
+func (ih *ImageHandler) serveDegraded(w http.ResponseWriter, req *http.Request, u *iiif.URL, info *iiif.Info) bool {
+	var size = ih.Auth.degradedSize(u.ID)
+	if size == 0 {
+		ih.deny(w, req, u, info)
+		return false
+	}
+
+	var limit = degradedLimit(info.Width, info.Height, size)
+	if u.Info {
+		degradeInfo(info, limit)
+		return true
+	}
+
+	// Size is checked against the server's usual constraints first so that
+	// requests a login would allow get a 401 rather than a 501
+	var crop, scale, err = img.Dimensions(u, info.Width, info.Height, ih.constraints(info))
+	degradeInfo(info, limit)
+	if u.Size.Type == iiif.STMax || u.Size.Type == iiif.STFull {
+		crop, scale, err = img.Dimensions(u, info.Width, info.Height, ih.constraints(info))
+		if err == nil {
+			var path = ih.WebPathPrefix + "/" + u.CanonicalPath(info.Width, info.Height, crop, scale)
+			http.Redirect(w, req, path, http.StatusSeeOther)
+			return false
+		}
+	}
+	if err != nil {
+		// Out-of-bounds requests are rejected normally by Command
+		return true
+	}
+	if exceedsDegraded(info, crop, scale, limit) {
+		http.Error(w, "Authorization required for this resolution", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// authPlugins hold the AuthorizeID functions exposed by plugins
+var authPlugins []func(iiif.ID, *http.Request) (plugins.AuthDecision, error)
+
+// authDecisions caches plugins' authorization decisions so policy services
+// aren't consulted for every tile.  It's only set up when an authorization
+// plugin is loaded and AuthCacheLen is positive.
+var authDecisions *lru.Cache
+
+// authDecisionTTL is how long a cached decision is trusted
+var authDecisionTTL time.Duration
+
+// authKey identifies a cached decision: the image and the credentials which
+// came with the request
+type authKey struct {
+	id      iiif.ID
+	subject string
+}
+
+// cachedDecision is a decision and when it stops being trusted
+type cachedDecision struct {
+	decision plugins.AuthDecision
+	expires  time.Time
+}
+
+// setupAuthCache creates the authorization decision cache and hooks it into
+// cache purging and expiration, so decisions can be invalidated for one image
+// or all of them via the admin cache purge endpoint
+func setupAuthCache(size int, ttl time.Duration) {
+	var err error
+	authDecisions, err = lru.New(size)
+	if err != nil {
+		Logger.Fatalf("Unable to start authorization cache: %s", err)
+	}
+	authDecisionTTL = ttl
+	purgeCachePlugins = append(purgeCachePlugins, authDecisions.Purge)
+	expireCachedImagePlugins = append(expireCachedImagePlugins, expireAuthDecisions)
+}
+
+// expireAuthDecisions forgets all cached decisions for the given image
+func expireAuthDecisions(id iiif.ID) {
+	for _, k := range authDecisions.Keys() {
+		if k.(authKey).id == id {
+			authDecisions.Remove(k)
+		}
+	}
+}
+
+// authSubject returns the parts of the request which identify who's asking:
+// the Authorization and Cookie headers
+func authSubject(req *http.Request) string {
+	return req.Header.Get("Authorization") + "\x00" + req.Header.Get("Cookie")
+}
+
+// pluginAuthorization asks authorization plugins about the request, returning
+// the first decision made and true, or false if no plugin handles the ID.
+// Decisions are cached per ID and credentials.  A plugin error is logged and
+// treated as a denial, and isn't cached.
+func pluginAuthorization(id iiif.ID, req *http.Request) (plugins.AuthDecision, bool) {
+	if len(authPlugins) == 0 {
+		return "", false
+	}
+
+	var key = authKey{id: id, subject: authSubject(req)}
+	if authDecisions != nil {
+		stats.AuthCache.Get()
+		if v, ok := authDecisions.Get(key); ok {
+			var cd = v.(cachedDecision)
+			if time.Now().Before(cd.expires) {
+				stats.AuthCache.Hit()
+				return cd.decision, cd.decision != ""
+			}
+			authDecisions.Remove(key)
+		}
+	}
+
+	var decision plugins.AuthDecision
+	for _, plug := range authPlugins {
+		var d, err = plug(id, req)
+		if err == plugins.ErrSkipped {
+			continue
+		}
+		if err != nil {
+			Logger.Errorf("Error authorizing %q: %s", id, err)
+			return plugins.AuthDeny, true
+		}
+		decision = d
+		break
+	}
+
+	if authDecisions != nil {
+		stats.AuthCache.Set()
+		authDecisions.Add(key, cachedDecision{decision: decision, expires: time.Now().Add(authDecisionTTL)})
+	}
+	return decision, decision != ""
+}
+
+// authorize returns the authorization decision for a request: an
+// authorization plugin's, if one handles the ID, or else the built-in auth
+// service's.  Unauthorized requests for IDs the auth service restricts are
+// degraded, which denies them unless the ID has a degraded tier.
+func (ih *ImageHandler) authorize(id iiif.ID, req *http.Request) plugins.AuthDecision {
+	if d, ok := pluginAuthorization(id, req); ok {
+		return d
+	}
+	if ih.Auth.restricts(id) && !ih.Auth.authorized(req) {
+		return plugins.AuthDegraded
+	}
+	return plugins.AuthAllow
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestAuthPlugins(t *testing.T) {
+	var oldPurge, oldExpire = purgeCachePlugins, expireCachedImagePlugins
+	defer func() {
+		authPlugins, authDecisions = nil, nil
+		purgeCachePlugins, expireCachedImagePlugins = oldPurge, oldExpire
+	}()
+
+	var calls int
+	var decision = plugins.AuthDeny
+	authPlugins = []func(iiif.ID, *http.Request) (plugins.AuthDecision, error){
+		func(id iiif.ID, req *http.Request) (plugins.AuthDecision, error) {
+			if id == "bad" {
+				return "", errors.New("policy service is down")
+			}
+			if id[0] == 'x' {
+				return "", plugins.ErrSkipped
+			}
+			calls++
+			return decision, nil
+		},
+	}
+	setupAuthCache(10, time.Hour)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "session=a")
+	assert.Equal(plugins.AuthDeny, ih.authorize("foo", req), "plugin decision", t)
+	decision = plugins.AuthAllow
+	assert.Equal(plugins.AuthDeny, ih.authorize("foo", req), "cached decision", t)
+	assert.Equal(1, calls, "plugin is only asked once", t)
+
+	var req2 = httptest.NewRequest("GET", "/", nil)
+	req2.Header.Set("Cookie", "session=b")
+	assert.Equal(plugins.AuthAllow, ih.authorize("foo", req2), "different credentials aren't shared", t)
+
+	expireCachedImage("foo")
+	assert.Equal(plugins.AuthAllow, ih.authorize("foo", req), "expired decision is asked again", t)
+	assert.Equal(3, calls, "plugin was asked after expiration", t)
+
+	assert.Equal(plugins.AuthAllow, ih.authorize("xyz", req), "skipped IDs use the built-in service", t)
+	assert.Equal(plugins.AuthDeny, ih.authorize("bad", req), "plugin errors deny", t)
+
+	authDecisionTTL = -time.Second
+	decision = plugins.AuthDegraded
+	ih.authorize("bar", req)
+	assert.Equal(plugins.AuthDegraded, ih.authorize("bar", req), "stale decision isn't trusted", t)
+	assert.Equal(5, calls, "plugin was asked after the TTL", t)
+
+	// Degraded without a tier is a denial
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/full/0/default.jpg"
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(http.StatusUnauthorized, w.Code, "degraded request without a tier", t)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func testAuthService() *AuthService {
+	return &AuthService{
+		Prefixes:   []string{"docker/images/testfile/"},
+		LoginURL:   "https://example.org/login",
+		CookieName: "rais-auth",
+		Secret:     []byte("secret"),
+		TokenTTL:   time.Hour,
+	}
+}
+
+func TestAuthTokenExpiry(t *testing.T) {
+	var a = testAuthService()
+	var now = time.Now()
+	var token = a.signAuthToken(now.Add(time.Minute))
+
+	var _, ok = a.tokenExpiry(token, now)
+	assert.True(ok, "signed token is valid", t)
+	_, ok = a.tokenExpiry(token, now.Add(2*time.Minute))
+	assert.False(ok, "expired token is invalid", t)
+	_, ok = a.tokenExpiry(token+"0", now)
+	assert.False(ok, "tampered token is invalid", t)
+
+	var other = testAuthService()
+	other.Secret = []byte("other")
+	_, ok = other.tokenExpiry(token, now)
+	assert.False(ok, "token signed with another secret is invalid", t)
+}
+
+func TestAuthTokenRoute(t *testing.T) {
+	var a = testAuthService()
+	var req = httptest.NewRequest("GET", AuthTokenPath, nil)
+	var w = httptest.NewRecorder()
+	a.TokenRoute(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code, "no cookie", t)
+	assert.True(strings.Contains(w.Body.String(), "missingCredentials"), "missing credentials error", t)
+
+	req = httptest.NewRequest("GET", AuthTokenPath, nil)
+	req.AddCookie(&http.Cookie{Name: "rais-auth", Value: a.signAuthToken(time.Now().Add(24 * time.Hour))})
+	w = httptest.NewRecorder()
+	a.TokenRoute(w, req)
+	assert.Equal(http.StatusOK, w.Code, "valid cookie", t)
+
+	var tr tokenResponse
+	json.Unmarshal(w.Body.Bytes(), &tr)
+	var _, ok = a.tokenExpiry(tr.AccessToken, time.Now())
+	assert.True(ok, "access token is valid", t)
+	assert.True(tr.ExpiresIn <= 3600, "token lifetime is capped at TokenTTL", t)
+
+	req = httptest.NewRequest("GET", AuthTokenPath+"?messageId=1&origin=https://viewer.example.org", nil)
+	req.AddCookie(&http.Cookie{Name: "rais-auth", Value: a.signAuthToken(time.Now().Add(time.Hour))})
+	w = httptest.NewRecorder()
+	a.TokenRoute(w, req)
+	assert.True(strings.Contains(w.Body.String(), `postMessage({"messageId":"1","accessToken":`), "postMessage response", t)
+	assert.True(strings.Contains(w.Body.String(), `"https://viewer.example.org");`), "postMessage origin", t)
+}
+
+func TestAuthIIIFRoute(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.BaseURL, _ = url.Parse("http://example.com")
+	ih.Auth = testAuthService()
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/"
+
+	var req = httptest.NewRequest("GET", path+"info.json", nil)
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code, "info.json without a token", t)
+	assert.True(strings.Contains(w.Body.String(), `"@id":"http://example.com/auth/token"`), "token service advertised", t)
+
+	req = httptest.NewRequest("GET", path+"info.json", nil)
+	req.Header.Set("Authorization", "Bearer "+ih.Auth.signAuthToken(time.Now().Add(time.Minute)))
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, req)
+	assert.Equal(http.StatusOK, w.Code, "info.json with a token", t)
+	assert.True(strings.Contains(w.Body.String(), `"profile":"http://iiif.io/api/auth/1/login"`), "login service advertised", t)
+
+	req = httptest.NewRequest("GET", path+"full/64,/0/default.jpg", nil)
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code, "image without a cookie", t)
+
+	req = httptest.NewRequest("GET", path+"full/64,/0/default.jpg", nil)
+	req.AddCookie(&http.Cookie{Name: "rais-auth", Value: ih.Auth.signAuthToken(time.Now().Add(time.Minute))})
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, req)
+	assert.Equal(http.StatusOK, w.Code, "image with a cookie", t)
+}
+
+func TestAuthDegraded(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.BaseURL, _ = url.Parse("http://example.com")
+	ih.Auth = testAuthService()
+	ih.Auth.Degraded = []DegradedTier{{Prefix: "docker/", MaxSize: 200}}
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/"
+
+	var get = func(p string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, httptest.NewRequest("GET", path+p, nil))
+		return w
+	}
+
+	var w = get("info.json")
+	assert.Equal(http.StatusOK, w.Code, "degraded info.json", t)
+	var info iiif.Info
+	json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Equal(200, info.Profile.MaxWidth, "degraded max width", t)
+	assert.Equal(100, info.Profile.MaxHeight, "degraded max height", t)
+
+	assert.Equal(http.StatusOK, get("full/200,/0/default.jpg").Code, "image within the limit", t)
+	assert.Equal(http.StatusOK, get("0,0,400,200/100,/0/default.jpg").Code, "region within the limit", t)
+	assert.Equal(http.StatusUnauthorized, get("full/400,/0/default.jpg").Code, "image over the limit", t)
+	assert.Equal(http.StatusUnauthorized, get("0,0,200,200/200,/0/default.jpg").Code, "region over the limit", t)
+
+	w = get("full/max/0/default.jpg")
+	assert.Equal(http.StatusSeeOther, w.Code, "max is redirected", t)
+	assert.Equal("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/200,/0/default.jpg",
+		w.Header().Get("Location"), "max redirect location", t)
+}
@@ -4,14 +4,31 @@
 package main
 
 import (
+	"fmt"
 	"rais/src/iiif"
+	"rais/src/openjpeg"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/spf13/viper"
 )
 
-var infoCache *lru.Cache
-var tileCache *lru.TwoQueueCache
+var infoCache infoCacher
+var tileCache tileCacher
+var tileCacheDisk *diskTileCache
+var thumbnailCache tileCacher
+var deepZoomCache tileCacher
+var infoDocCache *lru.Cache
+var infoDocTTL time.Duration
+var sidecarCache *lru.Cache
+var sidecarTTL time.Duration
+var proxyCache *lru.TwoQueueCache
+
+// decodeLimit and renderRequests keep a popular image from tying up all the
+// server's resources: identical requests share a single render, and distinct
+// requests for one source file are limited to a configurable concurrency
+var decodeLimit *decodeLimiter
+var renderRequests = newCoalescer()
 
 // setupCaches looks for config for caching and sets up the tile/info caches
 // appropriately.  If they exist, we put their cache expiration functions into
@@ -21,29 +38,214 @@ func setupCaches() {
 	var err error
 	icl := viper.GetInt("InfoCacheLen")
 	if icl > 0 {
-		infoCache, err = lru.New(icl)
+		infoCache, err = newInfoCache(icl)
 		if err != nil {
 			Logger.Fatalf("Unable to start info cache: %s", err)
 		}
 		stats.InfoCache.Enabled = true
 		purgeCachePlugins = append(purgeCachePlugins, infoCache.Purge)
 		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { infoCache.Remove(id) })
+
+		// Sidecar settings are cached alongside the info data and expire with
+		// it, so editing a sidecar takes effect when the image's info would be
+		// re-read anyway
+		sidecarCache, err = lru.New(icl)
+		if err != nil {
+			Logger.Fatalf("Unable to start sidecar cache: %s", err)
+		}
+		sidecarTTL, _ = time.ParseDuration(viper.GetString("InfoCacheTTL"))
+		purgeCachePlugins = append(purgeCachePlugins, sidecarCache.Purge)
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { sidecarCache.Remove(id) })
 	}
 
 	tcl := viper.GetInt("TileCacheLen")
 	if tcl > 0 {
-		Logger.Debugf("Creating a tile cache to hold up to %d tiles", tcl)
-		tileCache, err = lru.New2Q(tcl)
+		tileCache, err = newTileCache(tcl)
 		if err != nil {
-			Logger.Fatalf("Unable to start info cache: %s", err)
+			Logger.Fatalf("Unable to start tile cache: %s", err)
 		}
+		tileCacheMaxBytes = viper.GetInt("TileCacheMaxBytes")
 		stats.TileCache.Enabled = true
+
+		var diskPath = viper.GetString("TileCacheDiskPath")
+		if diskPath != "" {
+			var diskLen = viper.GetInt("TileCacheDiskLen")
+			if diskLen <= 0 {
+				Logger.Fatalf("TileCacheDiskLen must be set to use a disk tile cache")
+			}
+			Logger.Debugf("Creating an on-disk tile cache tier at %q (size %d)", diskPath, diskLen)
+			tileCacheDisk, err = newDiskTileCache(diskPath, diskLen)
+			if err != nil {
+				Logger.Fatalf("Unable to start disk tile cache: %s", err)
+			}
+			tileCache = &tieredTileCache{fast: tileCache, slow: tileCacheDisk, slowStats: &stats.TileCacheDisk}
+			stats.TileCacheDisk.Enabled = true
+		}
 		purgeCachePlugins = append(purgeCachePlugins, tileCache.Purge)
 		// Unfortunately, the tile cache is keyed by the entire IIIF request, not the
 		// ID (obviously).  Since we can't get a list of all cached tiles for a given
 		// image, we have to purge the whole cache.
 		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { tileCache.Purge() })
 	}
+
+	var thcl = viper.GetInt("ThumbnailCacheLen")
+	if thcl > 0 {
+		thumbnailCache, err = newTileCache(thcl)
+		if err != nil {
+			Logger.Fatalf("Unable to start thumbnail cache: %s", err)
+		}
+		stats.ThumbnailCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, thumbnailCache.Purge)
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { thumbnailCache.Purge() })
+	}
+
+	var dzcl = viper.GetInt("DeepZoomCacheLen")
+	if dzcl > 0 {
+		deepZoomCache, err = newTileCache(dzcl)
+		if err != nil {
+			Logger.Fatalf("Unable to start Deep Zoom tile cache: %s", err)
+		}
+		stats.DeepZoomCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, deepZoomCache.Purge)
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { deepZoomCache.Purge() })
+	}
+
+	var idcl = viper.GetInt("InfoDocCacheLen")
+	if idcl > 0 {
+		Logger.Debugf("Creating an info document cache to hold up to %d documents", idcl)
+		infoDocCache, err = lru.New(idcl)
+		if err != nil {
+			Logger.Fatalf("Unable to start info document cache: %s", err)
+		}
+		// Documents are built from info data, so they expire along with it
+		infoDocTTL, err = time.ParseDuration(viper.GetString("InfoCacheTTL"))
+		if err != nil {
+			Logger.Fatalf("Malformed InfoCacheTTL: %s", err)
+		}
+		stats.InfoDocCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, infoDocCache.Purge)
+		expireCachedImagePlugins = append(expireCachedImagePlugins, expireInfoDocs)
+	}
+
+	var pcl = viper.GetInt("ProxyCacheLen")
+	if pcl > 0 {
+		Logger.Debugf("Creating a proxy cache to hold up to %d upstream responses", pcl)
+		proxyCache, err = lru.New2Q(pcl)
+		if err != nil {
+			Logger.Fatalf("Unable to start proxy cache: %s", err)
+		}
+		stats.ProxyCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, proxyCache.Purge)
+		// As with tiles, proxied responses are keyed by the full upstream path
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { proxyCache.Purge() })
+	}
+
+	var ccl = viper.GetInt("ChecksumCacheLen")
+	if ccl > 0 {
+		Logger.Debugf("Creating a checksum cache to hold up to %d entries", ccl)
+		checksumCache, err = lru.New(ccl)
+		if err != nil {
+			Logger.Fatalf("Unable to start checksum cache: %s", err)
+		}
+		purgeCachePlugins = append(purgeCachePlugins, checksumCache.Purge)
+	}
+
+	// The JP2 header cache is keyed by file path, not ID, so like the tile
+	// cache it has to be purged entirely when a single image is expired
+	purgeCachePlugins = append(purgeCachePlugins, openjpeg.PurgeHeaderCache)
+	expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { openjpeg.PurgeHeaderCache() })
+
+	var dlpi = viper.GetInt("DecodeLimitPerImage")
+	if dlpi > 0 {
+		Logger.Debugf("Limiting concurrent decodes to %d per image", dlpi)
+		decodeLimit = newDecodeLimiter(dlpi)
+	}
+}
+
+// newInfoCache returns the info cache backend described by the configuration
+func newInfoCache(size int) (infoCacher, error) {
+	var ttlString = viper.GetString("InfoCacheTTL")
+	var ttl, err = time.ParseDuration(ttlString)
+	if err != nil {
+		return nil, fmt.Errorf("malformed InfoCacheTTL (%q): %s", ttlString, err)
+	}
+
+	var backend = viper.GetString("InfoCacheBackend")
+	switch backend {
+	case "memory":
+		Logger.Debugf("Creating an in-memory info cache (size %d, TTL %s)", size, ttl)
+		return newMemoryInfoCache(size, ttl)
+	case "disk":
+		var dir = viper.GetString("InfoCachePath")
+		if dir == "" {
+			return nil, fmt.Errorf("InfoCachePath must be set to use the disk backend")
+		}
+		Logger.Debugf("Creating an on-disk info cache at %q (size %d, TTL %s)", dir, size, ttl)
+		return newDiskInfoCache(dir, size, ttl)
+	case "redis":
+		var addr = viper.GetString("InfoCacheRedisAddr")
+		if addr == "" {
+			return nil, fmt.Errorf("InfoCacheRedisAddr must be set to use the redis backend")
+		}
+		Logger.Debugf("Creating a redis info cache at %q (TTL %s)", addr, ttl)
+		return newRedisInfoCache(addr, viper.GetString("InfoCacheRedisPassword"), viper.GetInt("InfoCacheRedisDB"), ttl)
+	}
+
+	return nil, fmt.Errorf("unknown InfoCacheBackend %q", backend)
+}
+
+// infoDocKey identifies one of an image's info documents.  An image can have
+// several: info.json in each IIIF version and for each server URL, and its
+// Deep Zoom descriptor.
+type infoDocKey struct {
+	id      iiif.ID
+	variant string
+}
+
+// cachedInfoDoc is an info document and its expiration time.  A zero expires
+// value means the document never expires.
+type cachedInfoDoc struct {
+	data    []byte
+	expires time.Time
+}
+
+// infoDoc returns the info document for id and variant from the info
+// document cache, calling build to generate and store it on a miss
+func infoDoc(id iiif.ID, variant string, build func() ([]byte, *HandlerError)) ([]byte, *HandlerError) {
+	if infoDocCache == nil {
+		return build()
+	}
+
+	var key = infoDocKey{id, variant}
+	stats.InfoDocCache.Get()
+	if v, ok := infoDocCache.Get(key); ok {
+		var doc = v.(cachedInfoDoc)
+		if doc.expires.IsZero() || time.Now().Before(doc.expires) {
+			stats.InfoDocCache.Hit()
+			return doc.data, nil
+		}
+		infoDocCache.Remove(key)
+	}
+
+	var data, e = build()
+	if e == nil {
+		var doc = cachedInfoDoc{data: data}
+		if infoDocTTL > 0 {
+			doc.expires = time.Now().Add(infoDocTTL)
+		}
+		stats.InfoDocCache.Set()
+		infoDocCache.Add(key, doc)
+	}
+	return data, e
+}
+
+// expireInfoDocs removes all cached info documents for a single IIIF ID
+func expireInfoDocs(id iiif.ID) {
+	for _, k := range infoDocCache.Keys() {
+		if k.(infoDocKey).id == id {
+			infoDocCache.Remove(k)
+		}
+	}
 }
 
 // purgeCaches removes all cached data
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strconv"
+	"strings"
+)
+
+// requestPath returns the IIIF path as requested, with the ID escaped the way
+// canonical paths escape it, so the two can be compared
+func requestPath(u *iiif.URL) string {
+	var parts = strings.Split(u.Path, "/")
+	if len(parts) < 4 {
+		return u.Path
+	}
+	return u.ID.Escaped() + "/" + strings.Join(parts[len(parts)-4:], "/")
+}
+
+// renderKey identifies a rendered image for caching and for deduplicating
+// work: its canonical IIIF path, so equivalent requests share a key, plus the
+// JPEG quality when it isn't the default and the watermark rule applied, if
+// any.  Requests which can't be fulfilled keep the path as requested.  The
+// watermark rule, if one applies, is returned as well: rendering must use the
+// same rule the key names, even if the rules are reloaded in between.
+func (ih *ImageHandler) renderKey(u *iiif.URL, info *iiif.Info, quality int) (string, *watermarkRule) {
+	var key = u.Path
+	var crop, scale, err = img.Dimensions(u, info.Width, info.Height, ih.constraints(info))
+	if err == nil {
+		key = u.CanonicalPath(info.Width, info.Height, crop, scale)
+	}
+	var params []string
+	if quality != 0 {
+		params = append(params, "q="+strconv.Itoa(quality))
+	}
+	var mark, tag = ih.Watermarks.ruleFor(u.ID)
+	if mark != nil && err == nil && mark.applies(image.Pt(info.Width, info.Height), crop, scale) {
+		params = append(params, "wm="+tag)
+	} else {
+		mark = nil
+	}
+	if len(params) > 0 {
+		key += "?" + strings.Join(params, "&")
+	}
+	return key, mark
+}
+
+// canonicalize sends a canonical Link header for image requests if the
+// feature is enabled, and redirects non-canonical requests to the canonical
+// form if CanonicalRedirect is set, so caches converge on one URL per
+// derivative.  Returns true if a redirect was sent.  Requests which can't be
+// fulfilled are left alone so the usual errors are reported.
+func (ih *ImageHandler) canonicalize(w http.ResponseWriter, req *http.Request, u *iiif.URL, info *iiif.Info) bool {
+	var linkHeader = ih.featuresFor(u.ID).CanonicalLinkHeader
+	if !linkHeader && !ih.CanonicalRedirect {
+		return false
+	}
+
+	var crop, scale, err = img.Dimensions(u, info.Width, info.Height, ih.constraints(info))
+	if err != nil {
+		return false
+	}
+	var canonical = u.CanonicalPath(info.Width, info.Height, crop, scale)
+	var canonicalURL = strings.TrimSuffix(info.ID, u.ID.Escaped()) + canonical
+
+	if linkHeader {
+		w.Header().Set("Link", "<"+canonicalURL+`>;rel="canonical"`)
+	}
+	if ih.CanonicalRedirect && requestPath(u) != canonical {
+		// The query (e.g., a JPEG quality override) is kept, as it isn't part of
+		// the IIIF path
+		if req.URL.RawQuery != "" {
+			canonicalURL += "?" + req.URL.RawQuery
+		}
+		http.Redirect(w, req, canonicalURL, http.StatusMovedPermanently)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCanonicalLinkAndRedirect(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.BaseURL, _ = url.Parse("http://example.com")
+	var id = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var canonical = "http://example.com/iiif/" + id + "/0,0,400,200/200,/0/default.jpg"
+
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+id+"/pct:0,0,50,50/200,100/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "non-canonical request without redirects", t)
+	assert.Equal("<"+canonical+`>;rel="canonical"`, w.Header().Get("Link"), "canonical link header", t)
+
+	ih.CanonicalRedirect = true
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+id+"/pct:0,0,50,50/200,100/0/default.jpg", nil))
+	assert.Equal(http.StatusMovedPermanently, w.Code, "non-canonical request is redirected", t)
+	assert.Equal(canonical, w.Header().Get("Location"), "redirect location", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+id+"/0,0,400,200/200,/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "canonical request isn't redirected", t)
+}
+
+func TestRenderKey(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var id = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var info, _ = ih.getInfo(iiif.URLToID(id), ih.getIIIFPath(iiif.URLToID(id)))
+	var key = func(path string, quality int) string {
+		var u, _ = iiif.NewURL(id + path)
+		var key, _ = ih.renderKey(u, info, quality)
+		return key
+	}
+
+	var expected = id + "/0,0,400,200/200,/0/default.jpg"
+	assert.Equal(expected, key("/pct:0,0,50,50/200,100/0/default.jpg", 0), "percent region", t)
+	assert.Equal(expected, key("/0,0,400.0,200/,100/360/default.jpg", 0), "height-only size", t)
+	assert.Equal(expected+"?q=60", key("/0,0,400,200/200,/0/default.jpg", 60), "quality override", t)
+	assert.Equal(id+"/900,0,10,10/full/0/default.jpg", key("/900,0,10,10/full/0/default.jpg", 0), "bad request keeps its path", t)
+}
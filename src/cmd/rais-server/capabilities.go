@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"rais/src/iiif"
+)
+
+// CapabilitiesPath is where, under the IIIF web path, the server reports the
+// capabilities it's running with
+const CapabilitiesPath = "/capabilities"
+
+// capabilities describes what the server currently advertises and allows, so
+// operators can check the effect of the capabilities file and limits.  Zero
+// maximums mean there's no limit.
+type capabilities struct {
+	ComplianceLevel string              `json:"complianceLevel"`
+	Formats         []string            `json:"formats"`
+	Qualities       []string            `json:"qualities"`
+	Features        []string            `json:"features"`
+	MaxWidth        int                 `json:"maxWidth,omitempty"`
+	MaxHeight       int                 `json:"maxHeight,omitempty"`
+	MaxArea         int64               `json:"maxArea,omitempty"`
+	MaxUpscale      float64             `json:"maxUpscale,omitempty"`
+	FormatLimits    map[iiif.Format]int `json:"formatLimits,omitempty"`
+	TileWidth       int                 `json:"tileWidth,omitempty"`
+	TileHeight      int                 `json:"tileHeight,omitempty"`
+	InfoVersions    []int               `json:"infoVersions"`
+}
+
+// capabilities returns the server-wide capabilities.  Sidecar files may still
+// change them for individual images.
+func (ih *ImageHandler) capabilities() *capabilities {
+	var c = &capabilities{
+		ComplianceLevel: ih.FeatureSet.Profile().ConformanceURL,
+		MaxUpscale:      ih.Maximums.MaxUpscale,
+		FormatLimits:    ih.FormatLimits,
+		TileWidth:       ih.TileWidth,
+		TileHeight:      ih.TileHeight,
+		InfoVersions:    ih.InfoVersions,
+	}
+	c.Formats, c.Qualities, c.Features = ih.FeatureSet.Enabled()
+	if len(c.InfoVersions) == 0 {
+		c.InfoVersions = []int{2}
+	}
+
+	if ih.Maximums.Width < math.MaxInt32 {
+		c.MaxWidth = ih.Maximums.Width
+	}
+	if ih.Maximums.Height < math.MaxInt32 {
+		c.MaxHeight = ih.Maximums.Height
+	}
+	if ih.Maximums.Area < math.MaxInt64 {
+		c.MaxArea = ih.Maximums.Area
+	}
+	return c
+}
+
+// CapabilitiesRoute reports the server's capabilities as JSON
+func (ih *ImageHandler) CapabilitiesRoute(w http.ResponseWriter, req *http.Request) {
+	var data, err = json.Marshal(ih.capabilities())
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCapabilitiesRoute(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.FeatureSet = iiif.FeatureSet1()
+	ih.FeatureSet.Png = true
+	ih.Maximums.Width = 2000
+	ih.FormatLimits = map[iiif.Format]int{iiif.FmtPNG: 1000}
+
+	var w = httptest.NewRecorder()
+	ih.CapabilitiesRoute(w, httptest.NewRequest("GET", "/iiif/capabilities", nil))
+	assert.Equal(200, w.Code, "status", t)
+	assert.Equal("application/json", w.Header().Get("Content-Type"), "content type", t)
+
+	var c capabilities
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &c), "valid JSON", t)
+	assert.Equal("http://iiif.io/api/image/2/level1.json", c.ComplianceLevel, "compliance level", t)
+	assert.Equal(2, len(c.Formats), "jpg and png", t)
+	assert.Equal("png", c.Formats[1], "extra format is listed", t)
+	assert.Equal(2000, c.MaxWidth, "max width", t)
+	assert.Equal(0, c.MaxHeight, "unlimited height is omitted", t)
+	assert.Equal(1000, c.FormatLimits[iiif.FmtPNG], "format limits", t)
+	assert.Equal(2, c.InfoVersions[0], "default info version", t)
+
+	var hasRegionByPx bool
+	for _, f := range c.Features {
+		hasRegionByPx = hasRegionByPx || f == "regionByPx"
+	}
+	assert.True(hasRegionByPx, "features include everything enabled, not just extras", t)
+}
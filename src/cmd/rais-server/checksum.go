@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/jp2info"
+	"rais/src/plugins"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// checksumAlgorithms holds the hashes computed for source files, keyed by the
+// name reported to clients.  Adding an algorithm is just a matter of adding
+// its constructor here.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+}
+
+// checksumCache maps source paths to their *sourceChecksums.  Entries are
+// only used if the file's size and modification time haven't changed.
+var checksumCache *lru.Cache
+
+// sourceChecksums holds the fixity data for a single source file
+type sourceChecksums struct {
+	Size      int64
+	ModTime   time.Time
+	Checksums map[string]string
+}
+
+// checksums returns fixity data for the source at path, which may be a local
+// file or anything a source opener handles, such as a remote URL.  Checksums
+// come from a SourceChecksums plugin if one knows them (e.g., from a fixity
+// database or object store metadata); otherwise all checksums are computed
+// in a single pass.  Either way they're cached until the source changes.
+func checksums(path string) (*sourceChecksums, error) {
+	var src, err = img.OpenSource(path)
+	if err != nil {
+		return nil, err
+	}
+	var fi = src.Stat()
+
+	if checksumCache != nil {
+		var cached, ok = checksumCache.Get(path)
+		if ok {
+			var sc = cached.(*sourceChecksums)
+			if sc.Size == fi.Size() && sc.ModTime.Equal(fi.ModTime()) {
+				return sc, nil
+			}
+		}
+	}
+
+	var sc = &sourceChecksums{Size: fi.Size(), ModTime: fi.ModTime()}
+	sc.Checksums = pluginChecksums(path)
+	if sc.Checksums == nil {
+		sc.Checksums, err = computeChecksums(io.NewSectionReader(src, 0, src.Size()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if checksumCache != nil {
+		checksumCache.Add(path, sc)
+	}
+	return sc, nil
+}
+
+// pluginChecksums returns the first checksums a SourceChecksums plugin
+// reports for path, or nil if no plugin handles it.  Plugin errors are
+// logged, and the checksums are computed as usual.
+func pluginChecksums(path string) map[string]string {
+	for _, plug := range sourceChecksumsPlugins {
+		var sums, err = plug(path)
+		if err == plugins.ErrSkipped {
+			continue
+		}
+		if err != nil {
+			Logger.Warnf("Error getting checksums for %q from plugin: %s", path, err)
+			return nil
+		}
+		if len(sums) > 0 {
+			return sums
+		}
+	}
+	return nil
+}
+
+// computeChecksums reads r once, computing every checksum algorithm's value
+func computeChecksums(r io.Reader) (map[string]string, error) {
+	var hashes = make(map[string]hash.Hash)
+	var writers []io.Writer
+	for name, fn := range checksumAlgorithms {
+		hashes[name] = fn()
+		writers = append(writers, hashes[name])
+	}
+	var _, err = io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return nil, err
+	}
+
+	var sums = make(map[string]string)
+	for name, h := range hashes {
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// sourceMetadata is the JSON structure returned by the metadata endpoint.
+// Container is only set for JPEG 2000 sources, as JP2, JPX, JPM, or J2C.
+type sourceMetadata struct {
+	ID        iiif.ID
+	Container jp2info.Container `json:",omitempty"`
+	*sourceChecksums
+}
+
+// sourceContainer returns the JPEG 2000 container of the source at path, or
+// an empty value if it isn't JPEG 2000 or can't be read
+func sourceContainer(path string) jp2info.Container {
+	var src, err = img.OpenSource(path)
+	if err != nil {
+		return jp2info.ContainerUnknown
+	}
+	return jp2info.ReadContainer(io.NewSectionReader(src, 0, src.Size()))
+}
+
+// MetadataRoute reports fixity information for the source image of the IIIF
+// ID given in the "id" query parameter
+func (ih *ImageHandler) MetadataRoute(w http.ResponseWriter, req *http.Request) {
+	var id = iiif.ID(req.URL.Query().Get("id"))
+	if id == "" {
+		http.Error(w, `the "id" parameter is required`, http.StatusBadRequest)
+		return
+	}
+
+	var fp = ih.getIIIFPath(id)
+	var sc, err = checksums(fp)
+	if err == img.ErrDoesNotExist || fp == "" {
+		http.Error(w, "image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		Logger.Errorf("Unable to compute checksums for %q: %s", fp, err)
+		http.Error(w, "unable to read image", http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	data, err = json.Marshal(sourceMetadata{ID: id, Container: sourceContainer(fp), sourceChecksums: sc})
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rais/src/img"
+	"rais/src/jp2info"
+	"rais/src/plugins"
+	"rais/src/rangeio"
+	"strings"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestChecksums(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-checksum-")
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "source.jp2")
+	ioutil.WriteFile(fp, []byte("hello"), 0644)
+
+	var oldCache = checksumCache
+	checksumCache, _ = lru.New(10)
+	defer func() { checksumCache = oldCache }()
+
+	var sc, err = checksums(fp)
+	assert.NilError(err, "checksums", t)
+	assert.Equal("5d41402abc4b2a76b9719d911017c592", sc.Checksums["md5"], "md5", t)
+	assert.Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", sc.Checksums["sha256"], "sha256", t)
+
+	// Changing the file invalidates the cached value
+	ioutil.WriteFile(fp, []byte("hello, world"), 0644)
+	os.Chtimes(fp, time.Now(), time.Now().Add(time.Minute))
+	sc, _ = checksums(fp)
+	assert.Equal(int64(12), sc.Size, "new size", t)
+	assert.Equal("e4d7f1b4ed2e42d15898f4b27b019da4", sc.Checksums["md5"], "md5 is recomputed", t)
+
+	var h = NewImageHandler(dir, "/iiif")
+	var w = httptest.NewRecorder()
+	var req, _ = http.NewRequest("GET", "/admin/metadata?id=source.jp2", nil)
+	h.MetadataRoute(w, req)
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Equal(200, w.Code, "metadata status", t)
+	assert.Equal("source.jp2", data["ID"], "metadata ID", t)
+	assert.Equal(float64(12), data["Size"], "metadata size", t)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/metadata?id=missing.jp2", nil)
+	h.MetadataRoute(w, req)
+	assert.Equal(404, w.Code, "missing source", t)
+}
+
+func TestMetadataContainer(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-container-")
+	defer os.RemoveAll(dir)
+	var jpm = append(append([]byte{}, jp2info.JP2HEADER...), 0, 0, 0, 20)
+	jpm = append(jpm, "ftypjpm \x00\x00\x00\x00jpm "...)
+	ioutil.WriteFile(filepath.Join(dir, "doc.jpm"), jpm, 0644)
+
+	var h = NewImageHandler(dir, "/iiif")
+	var w = httptest.NewRecorder()
+	var req, _ = http.NewRequest("GET", "/admin/metadata?id=doc.jpm", nil)
+	h.MetadataRoute(w, req)
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Equal("JPM", data["Container"], "metadata container", t)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/iiif/doc.jpm/info.json", nil)
+	h.IIIFRoute(w, req)
+	assert.Equal(501, w.Code, "JPM files are reported as unsupported", t)
+	assert.True(strings.Contains(w.Body.String(), "compound documents"), "JPM error message", t)
+}
+
+func TestRemoteChecksums(t *testing.T) {
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/source.jp2" {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, "source.jp2", time.Now(), strings.NewReader("hello"))
+	}))
+	defer srv.Close()
+
+	var oldBlocks = remoteBlocks
+	remoteBlocks, _ = rangeio.NewCache(64, time.Minute)
+	defer func() { remoteBlocks = oldBlocks }()
+
+	var sc, err = checksums(srv.URL + "/source.jp2")
+	assert.NilError(err, "remote checksums", t)
+	assert.Equal("5d41402abc4b2a76b9719d911017c592", sc.Checksums["md5"], "remote md5", t)
+
+	_, err = checksums(srv.URL + "/missing.jp2")
+	assert.Equal(img.ErrDoesNotExist, err, "missing remote source", t)
+}
+
+func TestPluginChecksums(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-checksum-")
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "source.jp2")
+	ioutil.WriteFile(fp, []byte("hello"), 0644)
+
+	defer func() { sourceChecksumsPlugins = nil }()
+	sourceChecksumsPlugins = []func(string) (map[string]string, error){
+		func(path string) (map[string]string, error) {
+			if path != fp {
+				return nil, plugins.ErrSkipped
+			}
+			return map[string]string{"sha512": "known"}, nil
+		},
+	}
+
+	var sc, err = checksums(fp)
+	assert.NilError(err, "plugin checksums", t)
+	assert.Equal("map[sha512:known]", fmt.Sprint(sc.Checksums), "checksums come from the plugin", t)
+
+	var other = filepath.Join(dir, "other.jp2")
+	ioutil.WriteFile(other, []byte("hello"), 0644)
+	sc, _ = checksums(other)
+	assert.Equal("5d41402abc4b2a76b9719d911017c592", sc.Checksums["md5"], "skipped sources are computed", t)
+}
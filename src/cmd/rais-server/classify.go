@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image"
+	"math"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+)
+
+// classifyRequest determines what kind of IIIF request we have, if any
+func classifyRequest(prefix string, req *http.Request) plugins.RequestType {
+	return describeRequest(prefix, req).Type
+}
+
+// describeRequest returns the attributes of a request which can be read from
+// its URL.  The escaped path is used so that IDs with encoded slashes are
+// parsed the same way the image handler would see them.
+func describeRequest(prefix string, req *http.Request) *plugins.RequestInfo {
+	var ri = &plugins.RequestInfo{Type: plugins.ReqNone}
+	var path = req.URL.EscapedPath()
+	if !strings.HasPrefix(path, prefix+"/") {
+		return ri
+	}
+
+	var u, err = iiif.NewURL(path[len(prefix)+1:])
+	if err != nil {
+		return ri
+	}
+
+	ri.Type = urlRequestType(u)
+	ri.ID = string(u.ID)
+	if !u.Info {
+		ri.Region = regionClasses[u.Region.Type]
+		ri.Format = string(u.Format)
+	}
+	return ri
+}
+
+// regionClasses maps parsed region types to the classes reported to plugins
+var regionClasses = map[iiif.RegionType]plugins.RegionClass{
+	iiif.RTFull:    plugins.RegionFull,
+	iiif.RTSquare:  plugins.RegionSquare,
+	iiif.RTPixel:   plugins.RegionPixel,
+	iiif.RTPercent: plugins.RegionPercent,
+}
+
+// setZoomLevel records the zoom level of a request which scales crop to
+// scale: the nearest power of two by which the region is shrunk
+func setZoomLevel(ri *plugins.RequestInfo, crop, scale image.Rectangle) {
+	if scale.Dx() <= 0 || crop.Dx() <= 0 {
+		return
+	}
+	ri.ZoomLevel = int(math.Floor(math.Log2(float64(crop.Dx())/float64(scale.Dx())) + 0.5))
+	ri.HasZoomLevel = true
+}
+
+// urlRequestType classifies a parsed IIIF URL
+func urlRequestType(u *iiif.URL) plugins.RequestType {
+	if u.Info {
+		return plugins.ReqInfo
+	}
+	if u.Region.Type == iiif.RTFull || u.Region.Type == iiif.RTSquare {
+		return plugins.ReqResize
+	}
+	if u.Size.W <= 1024 && u.Size.H <= 1024 {
+		return plugins.ReqTile
+	}
+
+	return plugins.ReqUnknown
+}
+
+// classifyMiddleware returns middleware which stores the request's
+// attributes in its context so that handlers and plugins don't have to parse
+// IIIF URLs themselves
+func classifyMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, plugins.WithRequestInfo(req, describeRequest(prefix, req)))
+		})
+	}
+}
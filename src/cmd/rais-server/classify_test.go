@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rais/src/plugins"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestClassifyRequest(t *testing.T) {
+	var tests = map[string]plugins.RequestType{
+		"/iiif/path%2Fto%2Fimage.jp2/info.json":              plugins.ReqInfo,
+		"/iiif/path%2Fto%2Fimage.jp2/full/max/0/default.jpg": plugins.ReqResize,
+		"/iiif/image.jp2/square/200,/0/default.jpg":          plugins.ReqResize,
+		"/iiif/image.jp2/0,0,1024,1024/512,/0/default.jpg":   plugins.ReqTile,
+		"/iiif/image.jp2/0,0,4096,4096/2048,/0/default.jpg":  plugins.ReqUnknown,
+		"/iiif/image.jp2/bad/512,/0/default.jpg":             plugins.ReqNone,
+		"/other/image.jp2/info.json":                         plugins.ReqNone,
+		"/iiifx/image.jp2/info.json":                         plugins.ReqNone,
+	}
+
+	for path, expected := range tests {
+		var req, _ = http.NewRequest("GET", path, nil)
+		assert.Equal(expected, classifyRequest("/iiif", req), path, t)
+	}
+}
+
+func TestDescribeRequest(t *testing.T) {
+	var req, _ = http.NewRequest("GET", "/iiif/path%2Fto%2Fimage.jp2/pct:0,0,50,50/256,/0/default.png", nil)
+	var ri = describeRequest("/iiif", req)
+	assert.Equal(plugins.ReqTile, ri.Type, "type", t)
+	assert.Equal("path/to/image.jp2", ri.ID, "id", t)
+	assert.Equal(plugins.RegionPercent, ri.Region, "region class", t)
+	assert.Equal("png", ri.Format, "format", t)
+	assert.False(ri.HasZoomLevel, "zoom level isn't known from the URL", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/image.jp2/info.json", nil)
+	ri = describeRequest("/iiif", req)
+	assert.Equal(plugins.RegionClass(""), ri.Region, "info requests have no region", t)
+}
+
+func TestRequestInfoFromHandler(t *testing.T) {
+	var oldCache = tileCache
+	defer func() { tileCache = oldCache }()
+	viper.Set("TileCachePolicy", "lru")
+	defer viper.Reset()
+	tileCache, _ = newTileCache(10)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var get = func(path string) *plugins.RequestInfo {
+		var ri *plugins.RequestInfo
+		var observer = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ih.IIIFRoute(w, req)
+			ri = plugins.GetRequestInfo(req)
+		})
+		classifyMiddleware("/iiif")(observer).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		return ri
+	}
+
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/0,0,400,400/100,/0/default.jpg"
+	var ri = get(path)
+	assert.Equal(plugins.CacheMiss, ri.CacheStatus, "first request misses", t)
+	assert.True(ri.HasZoomLevel, "zoom level is set", t)
+	assert.Equal(2, ri.ZoomLevel, "quarter-size zoom level", t)
+	assert.Equal(plugins.CacheHit, get(path).CacheStatus, "second request hits", t)
+
+	ri = get("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/full/0/default.png")
+	assert.Equal(plugins.CacheBypass, ri.CacheStatus, "uncacheable request", t)
+	assert.Equal(0, ri.ZoomLevel, "full resolution", t)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"net/http"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"time"
+)
+
+// comparison is the result of rendering one IIIF request with two decoders.
+// Differences are computed per channel (red, green, blue, and alpha) on an
+// 8-bit scale.
+type comparison struct {
+	Path    string
+	Message string `json:",omitempty"`
+	A       comparedRender
+	B       comparedRender
+
+	// Pixels is the number of pixels compared, and DifferentPixels the number
+	// in which any channel differs
+	Pixels          int64
+	DifferentPixels int64
+
+	// MaxDiff is the largest difference in any channel of any pixel, while
+	// MeanAbsDiff and RMSE are taken over every channel of every pixel
+	MaxDiff     int
+	MeanAbsDiff float64
+	RMSE        float64
+
+	// PSNR is the peak signal-to-noise ratio in decibels.  It's omitted when
+	// the images are identical, as it would be infinite.
+	PSNR float64 `json:",omitempty"`
+}
+
+// comparedRender describes a single decoder's output
+type comparedRender struct {
+	Decoder  string
+	Width    int
+	Height   int
+	Duration string
+}
+
+// CompareRoute handles "/admin/compare" requests: the IIIF request in the
+// "url" parameter is rendered by the decoders named in the "a" and "b"
+// parameters, and a JSON report of the differences is returned.  This is
+// meant for checking a new decoder against an old one before migrating.
+func (ih *ImageHandler) CompareRoute(w http.ResponseWriter, req *http.Request) {
+	var q = req.URL.Query()
+	var raw, a, b = q.Get("url"), q.Get("a"), q.Get("b")
+	if raw == "" || a == "" || b == "" {
+		http.Error(w, `the "url", "a", and "b" parameters are required; decoders: `+
+			strings.Join(img.DecoderNames(), ", "), http.StatusBadRequest)
+		return
+	}
+
+	var pu, err = url.Parse(raw)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var u *iiif.URL
+	u, err = iiif.NewURL(strings.TrimPrefix(pu.Path, ih.WebPathPrefix+"/"))
+	if err != nil {
+		http.Error(w, "invalid IIIF request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if u.Info {
+		http.Error(w, "info requests can't be compared", http.StatusBadRequest)
+		return
+	}
+
+	var fp = ih.getIIIFPath(u.ID)
+	var info, e = ih.getInfo(u.ID, fp)
+	if e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	var max = ih.constraints(info)
+
+	var c = &comparison{Path: u.Path}
+	var imgA, imgB image.Image
+	imgA, e = renderWith(a, u, fp, max, &c.A)
+	if e == nil {
+		imgB, e = renderWith(b, u, fp, max, &c.B)
+	}
+	if e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	if c.A.Width != c.B.Width || c.A.Height != c.B.Height {
+		c.Message = "output dimensions differ"
+	} else {
+		c.diff(imgA, imgB)
+	}
+
+	var data []byte
+	data, err = json.Marshal(c)
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// renderWith applies the IIIF request to the image using the named decoder,
+// filling in r with the output's description
+func renderWith(decoder string, u *iiif.URL, fp string, max img.Constraint, r *comparedRender) (image.Image, *HandlerError) {
+	r.Decoder = decoder
+	var res, err = img.NewResourceUsing(decoder, u.ID, fp)
+	if err == img.ErrUnknownDecoder {
+		return nil, NewError("unknown decoder "+decoder, http.StatusBadRequest)
+	}
+	if err != nil {
+		return nil, newImageResError(err)
+	}
+
+	var release = decodeLimit.acquire(fp)
+	defer release()
+
+	var start = time.Now()
+	var i image.Image
+	i, err = res.Apply(u, max)
+	if err != nil {
+		Logger.Warnf("Unable to compare %q with decoder %s: %s", u.Path, decoder, err)
+		return nil, newImageResError(err)
+	}
+	r.Duration = time.Since(start).String()
+	r.Width, r.Height = i.Bounds().Dx(), i.Bounds().Dy()
+	return i, nil
+}
+
+// diff computes the difference statistics for two images of the same size
+func (c *comparison) diff(a, b image.Image) {
+	var ba, bb = a.Bounds(), b.Bounds()
+	var sum, sumSq float64
+	for y := 0; y < ba.Dy(); y++ {
+		for x := 0; x < ba.Dx(); x++ {
+			var ca, cb = channels(a, ba.Min.X+x, ba.Min.Y+y), channels(b, bb.Min.X+x, bb.Min.Y+y)
+			var differs bool
+			for i := range ca {
+				var d = ca[i] - cb[i]
+				if d < 0 {
+					d = -d
+				}
+				if d > 0 {
+					differs = true
+				}
+				if d > c.MaxDiff {
+					c.MaxDiff = d
+				}
+				sum += float64(d)
+				sumSq += float64(d * d)
+			}
+			if differs {
+				c.DifferentPixels++
+			}
+		}
+	}
+
+	c.Pixels = int64(ba.Dx()) * int64(ba.Dy())
+	if c.Pixels == 0 {
+		return
+	}
+	var n = float64(c.Pixels * 4)
+	c.MeanAbsDiff = sum / n
+	c.RMSE = math.Sqrt(sumSq / n)
+	if c.RMSE > 0 {
+		c.PSNR = 20 * math.Log10(255/c.RMSE)
+	}
+}
+
+// channels returns the pixel's 8-bit red, green, blue, and alpha values
+func channels(i image.Image, x, y int) [4]int {
+	var r, g, b, a = i.At(x, y).RGBA()
+	return [4]int{int(r >> 8), int(g >> 8), int(b >> 8), int(a >> 8)}
+}
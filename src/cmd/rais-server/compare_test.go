@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"net/http/httptest"
+	"net/url"
+	"rais/src/img"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// fillDecoder wraps another decoder, replacing its output with a solid gray
+// image
+type fillDecoder struct {
+	img.Decoder
+	value uint8
+}
+
+func (d fillDecoder) DecodeImage() (image.Image, error) {
+	var i, err = d.Decoder.DecodeImage()
+	if err != nil {
+		return nil, err
+	}
+	var fill = image.NewGray(i.Bounds())
+	for n := range fill.Pix {
+		fill.Pix[n] = d.value
+	}
+	return fill, nil
+}
+
+func fillDecodeFn(value uint8) img.StreamDecodeFn {
+	return func(src img.Source) (img.Decoder, error) {
+		var d, err = decodeJP2(src)
+		if err != nil {
+			return nil, err
+		}
+		return fillDecoder{d, value}, nil
+	}
+}
+
+func TestCompare(t *testing.T) {
+	img.RegisterStreamDecoder("compare-black", fillDecodeFn(0))
+	img.RegisterStreamDecoder("compare-black2", fillDecodeFn(0))
+	img.RegisterStreamDecoder("compare-white", fillDecodeFn(255))
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var compare = func(a, b string) (*httptest.ResponseRecorder, *comparison) {
+		var q = url.Values{}
+		q.Set("url", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/0,0,100,100/50,/0/default.png")
+		q.Set("a", a)
+		q.Set("b", b)
+		var w = httptest.NewRecorder()
+		ih.CompareRoute(w, httptest.NewRequest("GET", "/admin/compare?"+q.Encode(), nil))
+		var c = new(comparison)
+		json.Unmarshal(w.Body.Bytes(), c)
+		return w, c
+	}
+
+	var w, c = compare("compare-black", "compare-black2")
+	assert.Equal(200, w.Code, "matching decoders", t)
+	assert.Equal(50, c.A.Width, "output width", t)
+	assert.Equal(int64(2500), c.Pixels, "pixels compared", t)
+	assert.Equal(int64(0), c.DifferentPixels, "identical output", t)
+	assert.Equal(0.0, c.PSNR, "no PSNR for identical output", t)
+
+	w, c = compare("compare-black", "compare-white")
+	assert.Equal(200, w.Code, "different decoders", t)
+	assert.Equal(int64(2500), c.DifferentPixels, "every pixel differs", t)
+	assert.Equal(255, c.MaxDiff, "black vs. white", t)
+	assert.Equal(191.25, c.MeanAbsDiff, "color channels differ but alpha doesn't", t)
+
+	w, _ = compare("compare-black", "bogus")
+	assert.Equal(400, w.Code, "unknown decoder", t)
+	w, _ = compare("compare-black", "")
+	assert.Equal(400, w.Code, "missing decoder", t)
+}
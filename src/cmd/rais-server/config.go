@@ -5,7 +5,9 @@
 	"math"
 	"net/url"
 	"os"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
@@ -24,8 +26,39 @@ func parseConf() {
 	viper.SetDefault("Address", defaultAddress)
 	viper.SetDefault("AdminAddress", defaultAdminAddress)
 	viper.SetDefault("InfoCacheLen", defaultInfoCacheLen)
+	viper.SetDefault("InfoCacheBackend", "memory")
+	viper.SetDefault("InfoCacheTTL", "0")
 	viper.SetDefault("LogLevel", defaultLogLevel)
 	viper.SetDefault("Plugins", defaultPlugins)
+	viper.SetDefault("FallbackStatus", 404)
+	viper.SetDefault("ShutdownTimeout", "30s")
+	viper.SetDefault("ChecksumCacheLen", 10000)
+	viper.SetDefault("HeaderCacheTTL", "10s")
+	viper.SetDefault("RemoteBlockCacheLen", 1024)
+	viper.SetDefault("TopIDWindow", "1h")
+	viper.SetDefault("AuthCookieName", "rais-auth")
+	viper.SetDefault("AuthTokenTTL", "1h")
+	viper.SetDefault("PDFMaxPages", 500)
+	viper.SetDefault("BitonalThreshold", 190)
+	viper.SetDefault("SourcePathPolicy", "tilepath")
+	viper.SetDefault("JPEGQuality", 80)
+	viper.SetDefault("PNGCompression", "default")
+	viper.SetDefault("PNGGrayLevels", 256)
+	viper.SetDefault("WebPQuality", 80)
+	viper.SetDefault("TIFFCompression", "deflate")
+	viper.SetDefault("TIFFPredictor", true)
+	viper.SetDefault("JobWorkers", 2)
+	viper.SetDefault("JobQueueLen", 100)
+	viper.SetDefault("AuthCacheLen", 10000)
+	viper.SetDefault("AuthCacheTTL", "5m")
+	viper.SetDefault("JobTTL", "1h")
+	viper.SetDefault("PrerenderDays", 7)
+	viper.SetDefault("PrerenderCount", 1000)
+	viper.SetDefault("PrerenderInterval", "6h")
+	viper.SetDefault("TileCachePolicy", "2q")
+	viper.SetDefault("TileCacheRecentRatio", lru.Default2QRecentRatio)
+	viper.SetDefault("TileCacheGhostRatio", lru.Default2QGhostEntries)
+	viper.SetDefault("JSONErrors", true)
 
 	// Allow all configuration to be in environment variables
 	viper.SetEnvPrefix("RAIS")
@@ -42,6 +75,13 @@ func parseConf() {
 		}
 	}
 
+	// Secrets read from files take precedence over every other source, including
+	// CLI flags
+	if err := readSecrets(os.Environ()); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
 	// CLI flags
 	pflag.String("iiif-base-url", "", "Base URL for RAIS to report in info.json requests "+
 		"(defaults to the requests as they come in, so you probably don't want to set this)")
@@ -67,26 +107,54 @@ func parseConf() {
 	viper.BindPFlag("ImageMaxWidth", pflag.CommandLine.Lookup("image-max-width"))
 	pflag.Int("image-max-height", math.MaxInt32, "Maximum height of images to be served")
 	viper.BindPFlag("ImageMaxHeight", pflag.CommandLine.Lookup("image-max-height"))
+	pflag.Int("tile-width", 0, "Tile width to advertise in info.json (defaults to the source image's tile width)")
+	viper.BindPFlag("TileWidth", pflag.CommandLine.Lookup("tile-width"))
+	pflag.Int("tile-height", 0, "Tile height to advertise in info.json (defaults to tile-width if that's set)")
+	viper.BindPFlag("TileHeight", pflag.CommandLine.Lookup("tile-height"))
 	pflag.String("plugins", defaultPlugins, "comma-separated plugin pattern list, e.g., "+
 		`"s3-images.so,datadog.so,json-tracer.so,/opt/rais/plugins/*.so"`)
 	viper.BindPFlag("Plugins", pflag.CommandLine.Lookup("plugins"))
+	pflag.Bool("read-only", false, "disable all admin endpoints, such as cache purging, "+
+		"regardless of other configuration")
+	viper.BindPFlag("ReadOnly", pflag.CommandLine.Lookup("read-only"))
 
 	pflag.Parse()
 
-	// Make sure required values exist
-	if !viper.IsSet("TilePath") {
-		fmt.Println("ERROR: tile path is required")
+	var level = logger.LogLevelFromString(viper.GetString("LogLevel"))
+	if level == logger.Invalid {
+		fmt.Println("ERROR: Invalid log level (must be DEBUG, INFO, WARN, ERROR, or CRIT)")
 		pflag.Usage()
 		os.Exit(1)
 	}
 
-	var level = logger.LogLevelFromString(viper.GetString("LogLevel"))
-	if level == logger.Invalid {
-		fmt.Println("ERROR: Invalid log level (must be DEBUG, INFO, WARN, ERROR, or CRIT)")
+	if viper.GetInt("TileWidth") < 0 || viper.GetInt("TileHeight") < 0 {
+		fmt.Println("ERROR: tile width and height must not be negative")
+		pflag.Usage()
+		os.Exit(1)
+	}
+	if viper.GetInt("TileHeight") > 0 && viper.GetInt("TileWidth") == 0 {
+		fmt.Println("ERROR: tile height cannot be set without tile width")
 		pflag.Usage()
 		os.Exit(1)
 	}
 
+	var fallbackStatus = viper.GetInt("FallbackStatus")
+	if fallbackStatus != 200 && fallbackStatus != 404 {
+		fmt.Println("ERROR: FallbackStatus must be 200 or 404")
+		os.Exit(1)
+	}
+
+	var timeout = viper.GetDuration("ShutdownTimeout")
+	if timeout <= 0 {
+		fmt.Println("ERROR: ShutdownTimeout must be a positive duration, such as \"30s\"")
+		os.Exit(1)
+	}
+
+	if viper.GetInt("TopIDCount") > 0 && viper.GetDuration("TopIDWindow") < time.Minute {
+		fmt.Println("ERROR: TopIDWindow must be at least one minute")
+		os.Exit(1)
+	}
+
 	var baseIIIFURL = viper.GetString("IIIFBaseURL")
 	if baseIIIFURL != "" {
 		var u, err = url.Parse(baseIIIFURL)
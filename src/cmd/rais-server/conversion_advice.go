@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTrackedSources caps how many distinct source files we keep usage data
+// for; sources seen after the cap is reached are ignored
+const maxTrackedSources = 10000
+
+// adviceMaxDim is the largest dimension we consider "small enough" to decode
+// in full on every request.  It's also the output size we assume a tiled
+// request would need to decode when estimating potential latency.
+const adviceMaxDim = 1024
+
+// sourceUse holds what we've learned about one source file from serving it
+type sourceUse struct {
+	ID                    iiif.ID
+	Width, Height         int
+	TileWidth, TileHeight int
+	Levels                int
+	Requests              int64
+	DecodeTime            time.Duration
+}
+
+// usageTracker records decode timing for every source RAIS renders
+type usageTracker struct {
+	m       sync.Mutex
+	sources map[string]*sourceUse
+}
+
+var sourceUsage = &usageTracker{sources: make(map[string]*sourceUse)}
+
+// record adds a single decode of res, which took d, to the usage data
+func (ut *usageTracker) record(res *img.Resource, d time.Duration) {
+	ut.m.Lock()
+	defer ut.m.Unlock()
+
+	var su = ut.sources[res.FilePath]
+	if su == nil {
+		if len(ut.sources) >= maxTrackedSources {
+			return
+		}
+		var dec = res.Decoder
+		su = &sourceUse{
+			ID:    res.ID,
+			Width: dec.GetWidth(), Height: dec.GetHeight(),
+			TileWidth: dec.GetTileWidth(), TileHeight: dec.GetTileHeight(),
+			Levels: dec.GetLevels(),
+		}
+		ut.sources[res.FilePath] = su
+	}
+	su.Requests++
+	su.DecodeTime += d
+}
+
+// conversionAdvice is a single entry in the advice report
+type conversionAdvice struct {
+	ID       iiif.ID
+	Path     string
+	Width    int
+	Height   int
+	Requests int64
+	Issues   []string
+
+	// CurrentLatency is the observed average decode time, while
+	// PotentialLatency is a rough estimate of the decode time for a tile if
+	// the source were converted to a tiled, multi-resolution JP2
+	CurrentLatency   string
+	PotentialLatency string
+
+	// TimeSaved estimates the total decode time which would have been saved
+	// across all observed requests, and is what the report is sorted by
+	TimeSaved string
+
+	saved time.Duration
+}
+
+// advise returns the problems which make a source slow to serve
+func (su *sourceUse) advise(path string) []string {
+	var issues []string
+	var big = su.Width > adviceMaxDim || su.Height > adviceMaxDim
+	if !big {
+		return nil
+	}
+
+	var ext = strings.ToLower(filepath.Ext(path))
+	var pyramidal bool
+	switch ext {
+	case ".jp2", ".jpf", ".jpx", ".j2c", ".j2k":
+		pyramidal = true
+	case ".png":
+		issues = append(issues, "large PNG: every request decodes the entire image")
+	case ".tif", ".tiff":
+		pyramidal = su.TileWidth > 0
+		if !pyramidal {
+			issues = append(issues, "flat TIFF: every request decodes the entire image")
+		}
+	default:
+		issues = append(issues, fmt.Sprintf("%s sources can't be decoded by region or resolution", ext))
+	}
+
+	var tiled = su.TileWidth > 0 && (su.TileWidth < su.Width || su.TileHeight < su.Height)
+	if !tiled && len(issues) == 0 {
+		issues = append(issues, "untiled: every request decodes the entire image")
+	}
+
+	var needed int
+	for dim := maxInt(su.Width, su.Height); dim > adviceMaxDim; dim >>= 1 {
+		needed++
+	}
+	if pyramidal && su.Levels <= needed {
+		issues = append(issues, fmt.Sprintf("only %d resolution level(s); %d needed for fast zoomed-out views", su.Levels, needed+1))
+	}
+
+	return issues
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// report builds the advice list from the usage data, most beneficial first
+func (ut *usageTracker) report() []*conversionAdvice {
+	ut.m.Lock()
+	defer ut.m.Unlock()
+
+	var list []*conversionAdvice
+	for path, su := range ut.sources {
+		var issues = su.advise(path)
+		if len(issues) == 0 {
+			continue
+		}
+
+		var avg = su.DecodeTime / time.Duration(su.Requests)
+		var potential = avg
+		var area = float64(su.Width) * float64(su.Height)
+		if ratio := adviceMaxDim * adviceMaxDim / area; ratio < 1 {
+			potential = time.Duration(float64(avg) * ratio)
+		}
+		var saved = (avg - potential) * time.Duration(su.Requests)
+
+		list = append(list, &conversionAdvice{
+			ID: su.ID, Path: path, Width: su.Width, Height: su.Height,
+			Requests: su.Requests, Issues: issues,
+			CurrentLatency:   avg.Round(time.Microsecond).String(),
+			PotentialLatency: potential.Round(time.Microsecond).String(),
+			TimeSaved:        saved.Round(time.Millisecond).String(),
+			saved:            saved,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].saved != list[j].saved {
+			return list[i].saved > list[j].saved
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// adminConversionAdvice reports served sources which would be faster to serve
+// if converted to a tiled, multi-resolution format
+func adminConversionAdvice(w http.ResponseWriter, req *http.Request) {
+	var data, err = json.Marshal(sourceUsage.report())
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
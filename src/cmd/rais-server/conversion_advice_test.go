@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestSourceAdvice(t *testing.T) {
+	var good = &sourceUse{Width: 8000, Height: 6000, TileWidth: 512, TileHeight: 512, Levels: 6}
+	assert.Equal(0, len(good.advise("good.jp2")), "tiled JP2 with enough levels", t)
+
+	var untiled = &sourceUse{Width: 8000, Height: 6000, TileWidth: 8000, TileHeight: 6000, Levels: 6}
+	assert.Equal("[untiled: every request decodes the entire image]", fmt.Sprint(untiled.advise("untiled.jp2")), "untiled JP2", t)
+
+	var shallow = &sourceUse{Width: 8000, Height: 6000, TileWidth: 512, TileHeight: 512, Levels: 2}
+	assert.Equal("[only 2 resolution level(s); 4 needed for fast zoomed-out views]", fmt.Sprint(shallow.advise("shallow.jp2")), "JP2 with few levels", t)
+
+	assert.Equal("[only 2 resolution level(s); 4 needed for fast zoomed-out views]", fmt.Sprint(shallow.advise("shallow.tif")), "tiled TIFF with few levels", t)
+	assert.Equal(0, len(good.advise("pyramid.tif")), "pyramidal TIFF", t)
+
+	var png = &sourceUse{Width: 5000, Height: 5000}
+	assert.Equal("[large PNG: every request decodes the entire image]", fmt.Sprint(png.advise("big.PNG")), "huge PNG", t)
+
+	var small = &sourceUse{Width: 1000, Height: 800}
+	assert.Equal(0, len(small.advise("small.png")), "small images are fine in any format", t)
+}
+
+func TestUsageReport(t *testing.T) {
+	var ut = &usageTracker{sources: map[string]*sourceUse{
+		"a.png": {ID: "a.png", Width: 4096, Height: 4096, Requests: 10, DecodeTime: 10 * time.Second},
+		"b.png": {ID: "b.png", Width: 4096, Height: 4096, Requests: 100, DecodeTime: 100 * time.Second},
+		"c.jp2": {ID: "c.jp2", Width: 4096, Height: 4096, TileWidth: 256, TileHeight: 256, Levels: 6, Requests: 5, DecodeTime: time.Second},
+	}}
+
+	var report = ut.report()
+	assert.Equal(2, len(report), "only problem sources are reported", t)
+	assert.Equal("b.png", report[0].Path, "most time saved comes first", t)
+	assert.Equal("1s", report[0].CurrentLatency, "current latency", t)
+	assert.Equal("62.5ms", report[0].PotentialLatency, "potential latency", t)
+	assert.Equal("1m33.75s", report[0].TimeSaved, "time saved", t)
+}
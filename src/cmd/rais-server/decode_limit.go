@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+)
+
+// decodeLimiter restricts the number of concurrent decodes for any single
+// source file so one heavily-requested image can't monopolize the server
+type decodeLimiter struct {
+	m    sync.Mutex
+	max  int
+	sems map[string]*fileSem
+}
+
+// fileSem is a counting semaphore for a single file, tracking how many
+// requests reference it so it can be removed when nobody needs it
+type fileSem struct {
+	ch   chan struct{}
+	refs int
+}
+
+// newDecodeLimiter returns a limiter allowing up to max concurrent decodes per
+// file.  A max of zero or less disables limiting entirely.
+func newDecodeLimiter(max int) *decodeLimiter {
+	return &decodeLimiter{max: max, sems: make(map[string]*fileSem)}
+}
+
+// acquire blocks until a decode slot is available for the given path, and
+// returns a function which must be called to release the slot.  Time spent
+// waiting and holding the slot is tracked in decodeQueue.
+func (dl *decodeLimiter) acquire(path string) func() {
+	var queued = decodeQueue.enqueue()
+	if dl == nil || dl.max <= 0 {
+		decodeQueue.dequeue(queued)
+		return decodeQueue.finish
+	}
+
+	dl.m.Lock()
+	var s = dl.sems[path]
+	if s == nil {
+		s = &fileSem{ch: make(chan struct{}, dl.max)}
+		dl.sems[path] = s
+	}
+	s.refs++
+	dl.m.Unlock()
+
+	s.ch <- struct{}{}
+	decodeQueue.dequeue(queued)
+	return func() {
+		decodeQueue.finish()
+		<-s.ch
+		dl.m.Lock()
+		s.refs--
+		if s.refs == 0 {
+			delete(dl.sems, path)
+		}
+		dl.m.Unlock()
+	}
+}
+
+// renderCall is an in-flight or completed render shared by all requests
+// for the same IIIF URL
+type renderCall struct {
+	wg   sync.WaitGroup
+	data []byte
+	err  *HandlerError
+}
+
+// coalescer ensures identical requests which arrive while a render is in
+// progress wait for that render rather than decoding the image again
+type coalescer struct {
+	m     sync.Mutex
+	calls map[string]*renderCall
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{calls: make(map[string]*renderCall)}
+}
+
+// do runs fn for the given key unless another caller is already running it,
+// in which case we wait for that caller's results
+func (c *coalescer) do(key string, fn func() ([]byte, *HandlerError)) ([]byte, *HandlerError) {
+	c.m.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.m.Unlock()
+		call.wg.Wait()
+		return call.data, call.err
+	}
+
+	var call = new(renderCall)
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.m.Unlock()
+
+	// If fn panics, waiters get a server error instead of blocking forever, and
+	// the key is cleared so the next request can try again
+	call.err = NewError("server error", 500)
+	defer func() {
+		c.m.Lock()
+		delete(c.calls, key)
+		c.m.Unlock()
+		call.wg.Done()
+	}()
+
+	call.data, call.err = fn()
+	return call.data, call.err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDecodeLimiter(t *testing.T) {
+	var dl = newDecodeLimiter(2)
+	var running, peak int32
+	var wg sync.WaitGroup
+	for x := 0; x < 20; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var release = dl.acquire("/path/to/image.jp2")
+			var n = atomic.AddInt32(&running, 1)
+			for {
+				var p = atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 5)
+			atomic.AddInt32(&running, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(int32(2), peak, "no more than two concurrent decodes", t)
+	assert.Equal(0, len(dl.sems), "semaphores are cleaned up", t)
+}
+
+func TestCoalescer(t *testing.T) {
+	var c = newCoalescer()
+	var calls int32
+	var start = make(chan struct{})
+	var wg sync.WaitGroup
+	for x := 0; x < 10; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			var data, _ = c.do("key", func() ([]byte, *HandlerError) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(time.Millisecond * 50)
+				return []byte("data"), nil
+			})
+			assert.Equal("data", string(data), "shared data", t)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(int32(1), calls, "render only ran once", t)
+}
+
+func TestCoalescerPanic(t *testing.T) {
+	var c = newCoalescer()
+	var started = make(chan struct{})
+	var release = make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		c.do("key", func() ([]byte, *HandlerError) {
+			close(started)
+			<-release
+			panic("render failed")
+		})
+	}()
+
+	<-started
+	var done = make(chan *HandlerError)
+	go func() {
+		var _, err = c.do("key", func() ([]byte, *HandlerError) {
+			return []byte("data"), nil
+		})
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+
+	select {
+	case err := <-done:
+		assert.True(err != nil, "waiter gets an error", t)
+		assert.Equal(500, err.Code, "waiter gets a server error", t)
+	case <-time.After(time.Second):
+		t.Fatal("waiter is still blocked after a panic")
+	}
+
+	var data, err = c.do("key", func() ([]byte, *HandlerError) {
+		return []byte("data"), nil
+	})
+	assert.True(err == nil, "key isn't stuck after a panic", t)
+	assert.Equal("data", string(data), "key isn't stuck after a panic", t)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// decodeQueueWindow is how long each bucket of wait times covers.  The
+// average wait is taken over the current and previous buckets, so it reflects
+// roughly the last one to two windows and drops to zero once the server has
+// been idle that long.
+const decodeQueueWindow = time.Minute
+
+// decodeQueue tracks renders waiting for and holding decode slots
+var decodeQueue = &decodeQueueStats{}
+
+// decodeQueueStats counts renders which are waiting to decode or decoding,
+// and how long they waited
+type decodeQueueStats struct {
+	m       sync.Mutex
+	waiting int
+	active  int
+
+	bucketStart time.Time
+	cur, prev   waitBucket
+}
+
+type waitBucket struct {
+	count int64
+	total time.Duration
+}
+
+// decodeQueueSnapshot is the published form of the decode queue.  Values are
+// numeric (wait times in milliseconds) so autoscalers can use them directly.
+type decodeQueueSnapshot struct {
+	Waiting       int
+	Active        int
+	QueuedJobs    int
+	AverageWaitMS float64
+}
+
+// enqueue records a render starting to wait, returning the time it started
+func (q *decodeQueueStats) enqueue() time.Time {
+	q.m.Lock()
+	q.waiting++
+	q.m.Unlock()
+	return time.Now()
+}
+
+// dequeue records a render which started waiting at the given time getting
+// its decode slot
+func (q *decodeQueueStats) dequeue(queued time.Time) {
+	var now = time.Now()
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.waiting--
+	q.active++
+	q.rotate(now)
+	q.cur.count++
+	q.cur.total += now.Sub(queued)
+}
+
+// finish records a render releasing its decode slot
+func (q *decodeQueueStats) finish() {
+	q.m.Lock()
+	q.active--
+	q.m.Unlock()
+}
+
+// rotate moves to a new wait bucket if the current one is over.  If more than
+// one window has passed, the previous bucket is cleared, too.
+func (q *decodeQueueStats) rotate(now time.Time) {
+	var elapsed = now.Sub(q.bucketStart)
+	if elapsed < decodeQueueWindow {
+		return
+	}
+	q.prev = q.cur
+	if elapsed >= decodeQueueWindow*2 {
+		q.prev = waitBucket{}
+	}
+	q.cur = waitBucket{}
+	q.bucketStart = now
+}
+
+// snapshot returns the queue's current state
+func (q *decodeQueueStats) snapshot(now time.Time) decodeQueueSnapshot {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.rotate(now)
+	var s = decodeQueueSnapshot{Waiting: q.waiting, Active: q.active}
+	var count = q.cur.count + q.prev.count
+	if count > 0 {
+		var total = q.cur.total + q.prev.total
+		s.AverageWaitMS = float64(total) / float64(count) / float64(time.Millisecond)
+	}
+	s.QueuedJobs = jobs.queued()
+	return s
+}
+
+// AutoscaleRoute reports the decode queue as JSON, for autoscalers which
+// should add capacity based on backlog rather than CPU alone
+func AutoscaleRoute(w http.ResponseWriter, req *http.Request) {
+	var data, _ = json.Marshal(decodeQueue.snapshot(time.Now()))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
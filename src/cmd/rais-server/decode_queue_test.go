@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDecodeQueue(t *testing.T) {
+	var oldQueue = decodeQueue
+	defer func() { decodeQueue = oldQueue }()
+	decodeQueue = &decodeQueueStats{}
+
+	var dl = newDecodeLimiter(1)
+	var release = dl.acquire("a.jp2")
+	var acquired = make(chan func())
+	go func() { acquired <- dl.acquire("a.jp2") }()
+	for i := 0; i < 100 && decodeQueue.snapshot(time.Now()).Waiting == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	var s = decodeQueue.snapshot(time.Now())
+	assert.Equal(1, s.Waiting, "second decode is waiting", t)
+	assert.Equal(1, s.Active, "first decode is active", t)
+
+	time.Sleep(5 * time.Millisecond)
+	release()
+	var release2 = <-acquired
+	s = decodeQueue.snapshot(time.Now())
+	assert.Equal(0, s.Waiting, "nothing waiting", t)
+	assert.Equal(1, s.Active, "second decode is active", t)
+	assert.True(s.AverageWaitMS >= 2.5, "average includes the wait", t)
+	release2()
+
+	var w = httptest.NewRecorder()
+	AutoscaleRoute(w, httptest.NewRequest("GET", "/autoscale", nil))
+	var s2 decodeQueueSnapshot
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &s2), "autoscale JSON", t)
+	assert.Equal(0, s2.Active, "autoscale reports the queue", t)
+
+	s = decodeQueue.snapshot(time.Now().Add(decodeQueueWindow * 2))
+	assert.Equal(0.0, s.AverageWaitMS, "old waits age out", t)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+)
+
+// derivativePaths returns the paths at which pre-made, lower-resolution
+// copies of the given master file may live.  Each suffix replaces the
+// master's extension, so "foo.jp2" with a suffix of "-access.jpg" gives us
+// "foo-access.jpg".
+func (ih *ImageHandler) derivativePaths(master string) []string {
+	var base = strings.TrimSuffix(master, filepath.Ext(master))
+	var paths = make([]string, len(ih.DerivativeSuffixes))
+	for i, suffix := range ih.DerivativeSuffixes {
+		paths[i] = base + suffix
+	}
+	return paths
+}
+
+// cheapestSource looks for a derivative of res which has enough resolution to
+// satisfy u, returning the smallest such derivative along with a copy of u
+// rewritten to that derivative's coordinates.  If there is no suitable
+// derivative, res and u are returned as-is.
+func (ih *ImageHandler) cheapestSource(u *iiif.URL, res *img.Resource, max img.Constraint) (*img.Resource, *iiif.URL) {
+	if len(ih.DerivativeSuffixes) == 0 {
+		return res, u
+	}
+
+	var w, h = res.Decoder.GetWidth(), res.Decoder.GetHeight()
+	var crop, scale, err = img.Dimensions(u, w, h, max)
+	if err != nil {
+		return res, u
+	}
+
+	var best *img.Resource
+	var bestPixels = int64(w) * int64(h)
+	for _, fp := range ih.derivativePaths(res.FilePath) {
+		if _, err := os.Stat(fp); err != nil {
+			continue
+		}
+		var d, err = img.NewResource(res.ID, fp)
+		if err != nil {
+			Logger.Warnf("Unable to read derivative %q: %s", fp, err)
+			continue
+		}
+
+		var dw, dh = d.Decoder.GetWidth(), d.Decoder.GetHeight()
+		var pixels = int64(dw) * int64(dh)
+		if pixels >= bestPixels || !sameAspect(w, h, dw, dh) {
+			continue
+		}
+		var rx, ry = float64(dw) / float64(w), float64(dh) / float64(h)
+		if float64(crop.Dx())*rx < float64(scale.Dx()) || float64(crop.Dy())*ry < float64(scale.Dy()) {
+			continue
+		}
+		best, bestPixels = d, pixels
+	}
+
+	if best == nil {
+		return res, u
+	}
+
+	var dw, dh = best.Decoder.GetWidth(), best.Decoder.GetHeight()
+	var rx, ry = float64(dw) / float64(w), float64(dh) / float64(h)
+	var dcrop = image.Rect(
+		int(math.Round(float64(crop.Min.X)*rx)),
+		int(math.Round(float64(crop.Min.Y)*ry)),
+		int(math.Round(float64(crop.Max.X)*rx)),
+		int(math.Round(float64(crop.Max.Y)*ry)),
+	).Intersect(image.Rect(0, 0, dw, dh))
+	if dcrop.Empty() {
+		return res, u
+	}
+
+	// Copying the URL keeps rotation, quality, and format intact; region and
+	// size are made explicit so they can't be reinterpreted against the
+	// derivative's dimensions
+	var du = *u
+	du.Region = iiif.Region{
+		Type: iiif.RTPixel,
+		X:    float64(dcrop.Min.X), Y: float64(dcrop.Min.Y),
+		W: float64(dcrop.Dx()), H: float64(dcrop.Dy()),
+	}
+	du.Size = iiif.Size{Type: iiif.STExact, W: scale.Dx(), H: scale.Dy()}
+
+	Logger.Debugf("Using derivative %q for %q", best.FilePath, u.Path)
+	return best, &du
+}
+
+// sameAspect returns true if two images' aspect ratios are within 1% of each
+// other, which allows for the rounding any resize tool will do
+func sameAspect(w1, h1, w2, h2 int) bool {
+	var a1, a2 = float64(w1) / float64(h1), float64(w2) / float64(h2)
+	return math.Abs(a1-a2)/a1 < 0.01
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// sizeDecoder is a fake decoder for ".size" files, which just hold the
+// image's dimensions
+type sizeDecoder struct{ w, h int }
+
+func (d *sizeDecoder) DecodeImage() (image.Image, error) { return nil, nil }
+func (d *sizeDecoder) GetWidth() int                     { return d.w }
+func (d *sizeDecoder) GetHeight() int                    { return d.h }
+func (d *sizeDecoder) GetTileWidth() int                 { return 0 }
+func (d *sizeDecoder) GetTileHeight() int                { return 0 }
+func (d *sizeDecoder) GetLevels() int                    { return 1 }
+func (d *sizeDecoder) SetCrop(image.Rectangle)           {}
+func (d *sizeDecoder) SetResizeWH(int, int)              {}
+
+func init() {
+	img.RegisterDecoder(func(path string) (img.Decoder, error) {
+		if filepath.Ext(path) != ".size" {
+			return nil, img.ErrNotHandled
+		}
+		var d = &sizeDecoder{}
+		var data, _ = ioutil.ReadFile(path)
+		fmt.Sscanf(string(data), "%dx%d", &d.w, &d.h)
+		return d, nil
+	})
+}
+
+func TestCheapestSource(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-derivatives-")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "img.size"), []byte("8000x4000"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "img-access.size"), []byte("2000x1000"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "img-thumb.size"), []byte("400x200"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "img-crop.size"), []byte("400x400"), 0644)
+
+	var h = NewImageHandler(dir, "/iiif")
+	h.DerivativeSuffixes = []string{"-crop.size", "-access.size", "-thumb.size", "-missing.size"}
+	var res, _ = img.NewResource("img.size", filepath.Join(dir, "img.size"))
+	var max = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+
+	var tests = []struct {
+		name   string
+		url    string
+		path   string
+		region string
+	}{
+		{"thumbnail", "img.size/full/300,/0/default.jpg", "img-thumb.size", "0,0,400,200 300x150"},
+		{"medium", "img.size/full/1000,/0/default.jpg", "img-access.size", "0,0,2000,1000 1000x500"},
+		{"low-res crop", "img.size/2000,1000,1000,1000/100,/0/default.jpg", "img-access.size", "500,250,250,250 100x100"},
+		{"full-res crop", "img.size/0,0,1000,1000/1000,/0/default.jpg", "img.size", ""},
+		{"full size", "img.size/full/full/0/default.jpg", "img.size", ""},
+	}
+
+	for _, tc := range tests {
+		var u, _ = iiif.NewURL(tc.url)
+		var src, su = h.cheapestSource(u, res, max)
+		assert.Equal(filepath.Join(dir, tc.path), src.FilePath, tc.name+": source", t)
+		if tc.region == "" {
+			assert.True(su == u, tc.name+": URL is unchanged", t)
+			continue
+		}
+		var r = su.Region
+		var got = fmt.Sprintf("%g,%g,%g,%g %dx%d", r.X, r.Y, r.W, r.H, su.Size.W, su.Size.H)
+		assert.Equal(tc.region, got, tc.name+": rewritten region and size", t)
+	}
+}
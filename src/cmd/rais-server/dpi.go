@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"rais/src/iiif"
+	"rais/src/img"
+)
+
+// dpiDecoder is implemented by decoders which can read an image's capture
+// resolution from its metadata
+type dpiDecoder interface {
+	DPI() float64
+}
+
+// sidecarDPI holds the capture resolution a sidecar file may set.  It's an
+// integer since the TOML decoder won't read "600" into a float.
+type sidecarDPI struct {
+	CaptureDPI int
+}
+
+// captureDPI returns the resolution at which the source image was captured:
+// the most specific sidecar's CaptureDPI, then the image's own metadata, and
+// finally DefaultCaptureDPI.  Zero means the resolution is unknown.
+func (ih *ImageHandler) captureDPI(res *img.Resource) float64 {
+	if dpi := ih.sidecars(res.ID).dpi; dpi > 0 {
+		return float64(dpi)
+	}
+
+	if d, ok := res.Decoder.(dpiDecoder); ok && d.DPI() > 0 {
+		return d.DPI()
+	}
+	return ih.DefaultCaptureDPI
+}
+
+// outputDPI returns the resolution to embed in the output for a request: the
+// capture resolution scaled by the resize factor, so a half-size derivative of
+// a 600 DPI scan prints at the original's physical size at 300 DPI.  Zero
+// means no resolution should be embedded.
+func (ih *ImageHandler) outputDPI(u *iiif.URL, res *img.Resource, max img.Constraint) float64 {
+	if !ih.EmbedDPI {
+		return 0
+	}
+	var capture = ih.captureDPI(res)
+	if capture <= 0 {
+		return 0
+	}
+
+	var crop, scale, err = img.Dimensions(u, res.Decoder.GetWidth(), res.Decoder.GetHeight(), max)
+	if err != nil || crop.Dx() == 0 {
+		return 0
+	}
+	return capture * float64(scale.Dx()) / float64(crop.Dx())
+}
+
+// embedDPI returns the encoded image with its resolution metadata set to the
+// given DPI.  Formats without support, or a DPI of zero, leave the data as-is.
+func embedDPI(data []byte, format iiif.Format, dpi float64) []byte {
+	if dpi <= 0 {
+		return data
+	}
+	var d = uint16(math.Max(1, math.Min(math.Round(dpi), math.MaxUint16)))
+	switch format {
+	case iiif.FmtJPG:
+		return jpegWithDPI(data, d)
+	case iiif.FmtTIF:
+		return tiffWithDPI(data, d)
+	}
+	return data
+}
+
+// jfifDensity is the offset of the density units byte in a JFIF APP0 segment
+// which immediately follows the SOI marker
+const jfifDensity = 13
+
+// jpegWithDPI sets the density in the JPEG's JFIF header, adding the header
+// if the JPEG doesn't have one.  Go's encoder doesn't write JFIF headers.
+func jpegWithDPI(data []byte, dpi uint16) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+
+	if !hasJFIF(data) {
+		var app0 = []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0}
+		var out = make([]byte, 0, len(data)+len(app0))
+		out = append(out, data[:2]...)
+		out = append(out, app0...)
+		data = append(out, data[2:]...)
+	}
+
+	data[jfifDensity] = 1 // dots per inch
+	binary.BigEndian.PutUint16(data[jfifDensity+1:], dpi)
+	binary.BigEndian.PutUint16(data[jfifDensity+3:], dpi)
+	return data
+}
+
+// hasJFIF returns true if the JPEG data starts with a JFIF APP0 segment
+func hasJFIF(data []byte) bool {
+	return len(data) >= jfifDensity+5 && data[2] == 0xFF && data[3] == 0xE0 &&
+		string(data[6:11]) == "JFIF\x00"
+}
+
+// jpegDPI returns the resolution from the JPEG's JFIF header, or zero if it
+// doesn't have one in dots per inch
+func jpegDPI(data []byte) int {
+	if !hasJFIF(data) || data[jfifDensity] != 1 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(data[jfifDensity+1:]))
+}
+
+// TIFF tags and types we need to set a resolution
+const (
+	tiffXResolution    = 282
+	tiffYResolution    = 283
+	tiffResolutionUnit = 296
+	tiffShort          = 3
+	tiffRational       = 5
+	tiffInch           = 2
+)
+
+// tiffWithDPI rewrites the resolution tags in the TIFF's first IFD.  Go's
+// encoder always writes them (as 72 DPI), so there's nothing to add.
+func tiffWithDPI(data []byte, dpi uint16) []byte {
+	if len(data) < 8 {
+		return data
+	}
+	var bo binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return data
+	}
+
+	var ifd = int(bo.Uint32(data[4:]))
+	if ifd+2 > len(data) {
+		return data
+	}
+	var count = int(bo.Uint16(data[ifd:]))
+	for i := 0; i < count; i++ {
+		var entry = ifd + 2 + i*12
+		if entry+12 > len(data) {
+			break
+		}
+		var tag, typ = bo.Uint16(data[entry:]), bo.Uint16(data[entry+2:])
+		switch {
+		case (tag == tiffXResolution || tag == tiffYResolution) && typ == tiffRational:
+			var off = int(bo.Uint32(data[entry+8:]))
+			if off+8 <= len(data) {
+				bo.PutUint32(data[off:], uint32(dpi))
+				bo.PutUint32(data[off+4:], 1)
+			}
+		case tag == tiffResolutionUnit && typ == tiffShort:
+			bo.PutUint16(data[entry+8:], tiffInch)
+		}
+	}
+	return data
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"golang.org/x/image/tiff"
+)
+
+func TestEmbedDPI(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(0, 0, 20, 10))
+	var buf = bytes.NewBuffer(nil)
+	jpeg.Encode(buf, src, nil)
+	assert.Equal(0, jpegDPI(buf.Bytes()), "Go doesn't write a JFIF header", t)
+
+	var data = embedDPI(buf.Bytes(), "jpg", 299.6)
+	assert.Equal(300, jpegDPI(data), "JFIF header added", t)
+	data = embedDPI(data, "jpg", 150)
+	assert.Equal(150, jpegDPI(data), "existing JFIF header rewritten", t)
+	var cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	assert.NilError(err, "JPEG is still valid", t)
+	assert.Equal(20, cfg.Width, "JPEG width", t)
+
+	buf.Reset()
+	tiff.Encode(buf, src, nil)
+	var before = append([]byte(nil), buf.Bytes()...)
+	data = embedDPI(buf.Bytes(), "tif", 200)
+	assert.True(bytes.Contains(data, []byte{200, 0, 0, 0, 1, 0, 0, 0}), "TIFF resolution rational", t)
+	assert.Equal(len(before), len(data), "TIFF is rewritten in place", t)
+	_, err = tiff.Decode(bytes.NewReader(data))
+	assert.NilError(err, "TIFF is still valid", t)
+
+	var orig = append([]byte(nil), before...)
+	assert.True(bytes.Equal(orig, embedDPI(before, "png", 200)), "unsupported formats are untouched", t)
+	assert.True(bytes.Equal(orig, embedDPI(before, "tif", 0)), "zero DPI is untouched", t)
+}
+
+func TestOutputDPI(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-dpi")
+	defer os.RemoveAll(dir)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.SidecarPath = dir
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/400,/0/default.jpg"
+	var get = func() int {
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+		return jpegDPI(w.Body.Bytes())
+	}
+
+	ih.DefaultCaptureDPI = 400
+	assert.Equal(0, get(), "no DPI unless enabled", t)
+
+	ih.EmbedDPI = true
+	assert.Equal(200, get(), "half-size output halves the DPI", t)
+
+	var sidecar = filepath.Join(dir, "docker", "images", "testfile", "test-world-link.jp2-rais.toml")
+	os.MkdirAll(filepath.Dir(sidecar), 0755)
+	ioutil.WriteFile(sidecar, []byte("CaptureDPI = 600\n"), 0644)
+	path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/401,/0/default.jpg"
+	assert.Equal(301, get(), "sidecar capture DPI", t)
+
+	ih.DefaultCaptureDPI = 0
+	os.Remove(sidecar)
+	path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/402,/0/default.jpg"
+	assert.Equal(0, get(), "unknown capture DPI", t)
+}
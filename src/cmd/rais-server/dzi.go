@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strconv"
+	"strings"
+)
+
+// DZIPath is the public server path prefix for Deep Zoom requests: descriptors
+// are at DZIPath+"<id>.dzi", and tiles at DZIPath+"<id>_files/<level>/<col>_<row>.<format>"
+const DZIPath = "/dzi/"
+
+// defaultDZITileSize is used when an image's info doesn't advertise tiles
+const defaultDZITileSize = 256
+
+// dziImage is the Deep Zoom descriptor XML
+type dziImage struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/deepzoom/2008 Image"`
+	TileSize int      `xml:"TileSize,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	Format   string   `xml:"Format,attr"`
+	Size     dziSize  `xml:"Size"`
+}
+
+type dziSize struct {
+	Width  int `xml:"Width,attr"`
+	Height int `xml:"Height,attr"`
+}
+
+// dziKey marks a request's context when it's a Deep Zoom tile forwarded to
+// the IIIF handler
+type dziKey struct{}
+
+// fromDZI returns true if req is a Deep Zoom tile request
+func fromDZI(req *http.Request) bool {
+	var dzi, _ = req.Context().Value(dziKey{}).(bool)
+	return dzi
+}
+
+// dziTileSize returns the tile size used for an image's Deep Zoom tiles: its
+// IIIF tile width, so Deep Zoom and IIIF viewers request the same tiles
+func dziTileSize(info *iiif.Info) int {
+	if len(info.Tiles) > 0 && info.Tiles[0].Width > 0 {
+		return info.Tiles[0].Width
+	}
+	return defaultDZITileSize
+}
+
+// dziMaxLevel returns the Deep Zoom level at which an image is full size.
+// Level 0 is a single pixel, and each level doubles the previous.
+func dziMaxLevel(w, h int) int {
+	var dim = w
+	if h > dim {
+		dim = h
+	}
+	return int(math.Ceil(math.Log2(float64(dim))))
+}
+
+// dziTileRegion returns the source region and output size for a Deep Zoom
+// tile, or an error if the tile doesn't exist
+func dziTileRegion(w, h, tileSize, level, col, row int) (crop, scale image.Rectangle, err error) {
+	var maxLevel = dziMaxLevel(w, h)
+	if level < 0 || level > maxLevel || col < 0 || row < 0 {
+		return crop, scale, fmt.Errorf("no such tile")
+	}
+
+	var factor = 1 << uint(maxLevel-level)
+	var levelW = (w + factor - 1) / factor
+	var levelH = (h + factor - 1) / factor
+	var lx, ly = col * tileSize, row * tileSize
+	if lx >= levelW || ly >= levelH {
+		return crop, scale, fmt.Errorf("no such tile")
+	}
+
+	scale = image.Rect(0, 0, tileSize, tileSize).Intersect(image.Rect(0, 0, levelW-lx, levelH-ly))
+	crop = image.Rect(lx*factor, ly*factor, (lx+scale.Dx())*factor, (ly+scale.Dy())*factor)
+	crop = crop.Intersect(image.Rect(0, 0, w, h))
+	return crop, scale, nil
+}
+
+// parseDZITile splits a tile path ("<level>/<col>_<row>.<format>") into its
+// parts
+func parseDZITile(path string) (level, col, row int, format iiif.Format, err error) {
+	var parts = strings.Split(path, "/")
+	if len(parts) != 2 {
+		return 0, 0, 0, "", fmt.Errorf("invalid tile path %q", path)
+	}
+	var dot = strings.LastIndex(parts[1], ".")
+	var coords = strings.Split(parts[1], "_")
+	if dot < 0 || len(coords) != 2 {
+		return 0, 0, 0, "", fmt.Errorf("invalid tile path %q", path)
+	}
+	format = iiif.Format(parts[1][dot+1:])
+	coords[1] = strings.TrimSuffix(coords[1], "."+string(format))
+
+	level, err = strconv.Atoi(parts[0])
+	if err == nil {
+		col, err = strconv.Atoi(coords[0])
+	}
+	if err == nil {
+		row, err = strconv.Atoi(coords[1])
+	}
+	if err != nil || (format != iiif.FmtJPG && format != iiif.FmtPNG) {
+		return 0, 0, 0, "", fmt.Errorf("invalid tile path %q", path)
+	}
+	return level, col, row, format, nil
+}
+
+// DZIRoute serves Deep Zoom descriptors and tiles.  Tiles are translated to
+// their canonical IIIF requests and handed to the IIIF handler, so a Deep
+// Zoom tile and the matching IIIF tile share decode work and cache entries,
+// and get the same authorization checks and proxying.
+func (ih *ImageHandler) DZIRoute(w http.ResponseWriter, req *http.Request) {
+	var path = strings.TrimPrefix(req.URL.EscapedPath(), DZIPath)
+	var escapedID, tilePath = path, ""
+	var idx = strings.LastIndex(path, "_files/")
+	if idx >= 0 {
+		escapedID, tilePath = path[:idx], path[idx+len("_files/"):]
+	} else if strings.HasSuffix(path, ".dzi") {
+		escapedID = strings.TrimSuffix(path, ".dzi")
+	} else {
+		http.Error(w, fmt.Sprintf("Invalid Deep Zoom request %q", path), http.StatusBadRequest)
+		return
+	}
+
+	var rawID, err = url.PathUnescape(escapedID)
+	if err != nil || rawID == "" {
+		http.Error(w, fmt.Sprintf("Invalid Deep Zoom request %q", path), http.StatusBadRequest)
+		return
+	}
+
+	// IDs go through the same normalization, policy, and alias checks as IIIF
+	// requests, but aliases redirect to the new ID's Deep Zoom path
+	var u = &iiif.URL{ID: iiif.ID(rawID), Path: rawID + "/info.json", Info: true}
+	ih.normalizeID(u)
+	if ih.rejectID(w, req, u.ID) {
+		return
+	}
+	if newID, ok := ih.aliasFor(u.ID); ok {
+		var suffix = ".dzi"
+		if tilePath != "" {
+			suffix = "_files/" + tilePath
+		}
+		http.Redirect(w, req, DZIPath+newID.Escaped()+suffix, http.StatusMovedPermanently)
+		return
+	}
+
+	// Descriptors have no degraded form, so they require full access, which is
+	// checked before anything about the image is looked up
+	if tilePath == "" {
+		if ih.authorize(u.ID, req) != plugins.AuthAllow {
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		var data, e = ih.dziDescriptor(u)
+		if e != nil {
+			http.Error(w, e.Message, e.Code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write(data)
+		return
+	}
+
+	var info, e = ih.dziInfo(u)
+	if e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	var level, col, row, format, perr = parseDZITile(tilePath)
+	if perr != nil {
+		http.Error(w, perr.Error(), http.StatusBadRequest)
+		return
+	}
+	var crop, scale image.Rectangle
+	crop, scale, err = dziTileRegion(info.Width, info.Height, dziTileSize(info), level, col, row)
+	if err != nil {
+		http.Error(w, "Tile does not exist", http.StatusNotFound)
+		return
+	}
+
+	var tile = &iiif.URL{ID: u.ID, Quality: iiif.QDefault, Format: format}
+	var iiifPath = ih.WebPathPrefix + "/" + tile.CanonicalPath(info.Width, info.Height, crop, scale)
+	var r2 = req.WithContext(context.WithValue(req.Context(), dziKey{}, true))
+	r2.URL = &url.URL{RawPath: iiifPath}
+	r2.URL.Path, _ = url.PathUnescape(iiifPath)
+	ih.IIIFRoute(w, r2)
+}
+
+// dziInfo returns the info Deep Zoom responses are built from: the local
+// image's, or for proxied IDs, the upstream server's
+func (ih *ImageHandler) dziInfo(u *iiif.URL) (*iiif.Info, *HandlerError) {
+	if pr := ih.proxyRouteFor(u.ID); pr != nil {
+		return pr.fetchInfo(u)
+	}
+	return ih.getInfo(u.ID, ih.getIIIFPath(u.ID))
+}
+
+// dziDescriptor returns the Deep Zoom descriptor XML for the image an info
+// request names.  Descriptors are info documents, so they're kept in the info
+// document cache when there is one, and are expired and purged with the
+// image.
+func (ih *ImageHandler) dziDescriptor(u *iiif.URL) ([]byte, *HandlerError) {
+	return infoDoc(u.ID, "dzi", func() ([]byte, *HandlerError) {
+		var info, e = ih.dziInfo(u)
+		if e != nil {
+			return nil, e
+		}
+		var data, err = xml.Marshal(dziImage{
+			TileSize: dziTileSize(info),
+			Format:   string(iiif.FmtJPG),
+			Size:     dziSize{Width: info.Width, Height: info.Height},
+		})
+		if err != nil {
+			Logger.Errorf("Unable to marshal Deep Zoom descriptor for %q: %s", u.ID, err)
+			return nil, NewError("server error", http.StatusInternalServerError)
+		}
+		return append([]byte(xml.Header), data...), nil
+	})
+}
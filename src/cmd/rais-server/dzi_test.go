@@ -0,0 +1,157 @@
+package main
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDZITileRegion(t *testing.T) {
+	// A 1000x600 image has levels 0 through 10, with level 10 at full size
+	var crop, scale, err = dziTileRegion(1000, 600, 256, 10, 3, 2)
+	assert.NilError(err, "edge tile at full size", t)
+	assert.Equal(image.Rect(768, 512, 1000, 600), crop, "edge tile region", t)
+	assert.Equal(image.Rect(0, 0, 232, 88), scale, "edge tile size", t)
+
+	crop, scale, err = dziTileRegion(1000, 600, 256, 9, 1, 0)
+	assert.NilError(err, "tile at half size", t)
+	assert.Equal(image.Rect(512, 0, 1000, 512), crop, "half-size tile region", t)
+	assert.Equal(image.Rect(0, 0, 244, 256), scale, "half-size tile size", t)
+
+	_, _, err = dziTileRegion(1000, 600, 256, 9, 2, 0)
+	assert.True(err != nil, "column past the edge", t)
+	_, _, err = dziTileRegion(1000, 600, 256, 11, 0, 0)
+	assert.True(err != nil, "level past full size", t)
+}
+
+func TestDZIRoute(t *testing.T) {
+	var oldCache = tileCache
+	defer func() { tileCache = oldCache }()
+	viper.Set("TileCachePolicy", "lru")
+	defer viper.Reset()
+	tileCache, _ = newTileCache(10)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var id = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+
+	var w = httptest.NewRecorder()
+	ih.DZIRoute(w, httptest.NewRequest("GET", "/dzi/"+id+".dzi", nil))
+	assert.Equal(http.StatusOK, w.Code, "descriptor status", t)
+	assert.True(strings.Contains(w.Body.String(), `<Size Width="800" Height="400">`), "descriptor size", t)
+
+	w = httptest.NewRecorder()
+	ih.DZIRoute(w, httptest.NewRequest("GET", "/dzi/"+id+"_files/9/0_0.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "tile status", t)
+	var _, ok = tileCache.Get(id + "/full/400,/0/default.jpg")
+	assert.True(ok, "tile is cached under its canonical IIIF request", t)
+
+	w = httptest.NewRecorder()
+	ih.DZIRoute(w, httptest.NewRequest("GET", "/dzi/"+id+"_files/9/5_0.jpg", nil))
+	assert.Equal(http.StatusNotFound, w.Code, "nonexistent tile", t)
+
+	w = httptest.NewRecorder()
+	ih.DZIRoute(w, httptest.NewRequest("GET", "/dzi/"+id+"_files/9/0_0.gif", nil))
+	assert.Equal(http.StatusBadRequest, w.Code, "unsupported tile format", t)
+}
+
+func TestDZIDescriptorCache(t *testing.T) {
+	var oldCache = infoDocCache
+	defer func() { infoDocCache = oldCache }()
+	infoDocCache, _ = lru.New(10)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var id = iiif.ID("docker/images/testfile/test-world-link.jp2")
+	var u = &iiif.URL{ID: id, Path: string(id) + "/info.json", Info: true}
+	var first, e = ih.dziDescriptor(u)
+	assert.True(e == nil, "descriptor is generated", t)
+	var key = infoDocKey{id, "dzi"}
+	var v, ok = infoDocCache.Get(key)
+	assert.True(ok, "descriptor is in the info document cache", t)
+	assert.Equal(string(first), string(v.(cachedInfoDoc).data), "cached descriptor", t)
+
+	infoDocCache.Add(key, cachedInfoDoc{data: []byte("cached")})
+	var data, _ = ih.dziDescriptor(u)
+	assert.Equal("cached", string(data), "cached descriptor is served", t)
+
+	expireInfoDocs(id)
+	data, _ = ih.dziDescriptor(u)
+	assert.Equal(string(first), string(data), "expiring the image regenerates the descriptor", t)
+
+	infoDocCache.Add(key, cachedInfoDoc{data: []byte("stale"), expires: time.Now().Add(-time.Second)})
+	data, _ = ih.dziDescriptor(u)
+	assert.Equal(string(first), string(data), "expired documents are regenerated", t)
+}
+
+func TestDZIRouting(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var id = iiif.ID("docker/images/testfile/test-world-link.jp2")
+	var get = func(path string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		ih.DZIRoute(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	ih.CaseInsensitiveIDs = true
+	var w = get("/dzi/" + strings.ToUpper(id.Escaped()) + ".dzi")
+	assert.Equal(http.StatusOK, w.Code, "IDs are folded when case-insensitive", t)
+	ih.CaseInsensitiveIDs = false
+
+	ih.Aliases = map[iiif.ID]iiif.ID{"old.jp2": id}
+	w = get("/dzi/old.jp2_files/9/0_0.jpg")
+	assert.Equal(http.StatusMovedPermanently, w.Code, "aliased tile", t)
+	assert.Equal("/dzi/"+id.Escaped()+"_files/9/0_0.jpg", w.Header().Get("Location"), "aliased tile redirect", t)
+	w = get("/dzi/old.jp2.dzi")
+	assert.Equal("/dzi/"+id.Escaped()+".dzi", w.Header().Get("Location"), "aliased descriptor redirect", t)
+
+	var upstreamPaths []string
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPaths = append(upstreamPaths, r.URL.EscapedPath())
+		if r.URL.Path == "/x.jp2/info.json" {
+			w.Write([]byte(`{"width": 1000, "height": 500, "tiles": [{"width": 512, "scaleFactors": [1, 2]}]}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/info.json") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("image data"))
+	}))
+	defer upstream.Close()
+	var upURL, _ = url.Parse(upstream.URL)
+	ih.ProxyRoutes = []*ProxyRoute{{Prefix: "remote:", Upstream: upURL, StripPrefix: true}}
+
+	w = get("/dzi/remote%3Ax.jp2.dzi")
+	assert.Equal(http.StatusOK, w.Code, "proxied descriptor", t)
+	assert.True(strings.Contains(w.Body.String(), `TileSize="512"`), "proxied descriptor uses upstream tile size", t)
+	assert.True(strings.Contains(w.Body.String(), `<Size Width="1000" Height="500">`), "proxied descriptor size", t)
+
+	w = get("/dzi/remote%3Ax.jp2_files/10/0_0.jpg")
+	assert.Equal("image data", w.Body.String(), "proxied tile", t)
+	assert.Equal("/x.jp2/0,0,512,500/full/0/default.jpg", upstreamPaths[len(upstreamPaths)-1], "tile is proxied as its IIIF request", t)
+
+	w = get("/dzi/remote%3Amissing.jp2.dzi")
+	assert.Equal(http.StatusNotFound, w.Code, "missing upstream image", t)
+
+	defer func() { authPlugins = nil }()
+	authPlugins = []func(iiif.ID, *http.Request) (plugins.AuthDecision, error){
+		func(id iiif.ID, req *http.Request) (plugins.AuthDecision, error) {
+			return plugins.AuthDeny, nil
+		},
+	}
+	w = get("/dzi/missing.jp2.dzi")
+	assert.Equal(http.StatusUnauthorized, w.Code, "authorization is checked before the image is looked up", t)
+	var n = len(upstreamPaths)
+	w = get("/dzi/remote%3Ax.jp2.dzi")
+	assert.Equal(http.StatusUnauthorized, w.Code, "proxied descriptors require authorization", t)
+	assert.Equal(n, len(upstreamPaths), "unauthorized descriptor requests aren't proxied", t)
+}
@@ -2,32 +2,133 @@
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"mime"
+	"net/http"
 	"rais/src/iiif"
-
-	"golang.org/x/image/tiff"
+	"rais/src/openjpeg"
+	"rais/src/tiffenc"
+	"rais/src/turbojpeg"
+	"rais/src/vips"
+	"rais/src/webp"
+	"strconv"
 )
 
 // ErrInvalidEncodeFormat is the error returned when encoding fails due to a
 // file format RAIS doesn't support
 var ErrInvalidEncodeFormat = errors.New("Unable to encode: unsupported format")
 
-// EncodeImage uses the built-in image libs to write an image to the browser
-func EncodeImage(w io.Writer, img image.Image, format iiif.Format) error {
+// JPEGQuality is the quality (1-100) used for JPEG output unless a request
+// overrides it
+var JPEGQuality = 80
+
+// JPEGQualityMin and JPEGQualityMax bound the JPEG quality a request may ask
+// for with the "q" query parameter.  Overrides are ignored when
+// JPEGQualityMax is zero.
+var JPEGQualityMin, JPEGQualityMax int
+
+// WebPQuality is the lossy quality (0-100) used for WebP output
+var WebPQuality float32 = 80
+
+// TIFFCompression is the compression scheme used for TIFF output
+var TIFFCompression = tiffenc.Deflate
+
+// tiffCompressions maps the TIFFCompression setting's names to schemes
+var tiffCompressions = map[string]tiffenc.Compression{
+	"none":    tiffenc.None,
+	"lzw":     tiffenc.LZW,
+	"deflate": tiffenc.Deflate,
+}
+
+// TIFFPredictor turns on the horizontal predictor for compressed TIFFs
+var TIFFPredictor = true
+
+// ProgressiveJPEG turns on progressive encoding for JPEGs larger than a tile,
+// so full-page views render a rough image quickly and sharpen as they load
+var ProgressiveJPEG bool
+
+// VipsBackend turns on the libvips backend: sources it can read are decoded
+// and resized by libvips, and baseline JPEGs are encoded by it.  It's only
+// allowed when RAIS is built with the "vips" tag.
+var VipsBackend bool
+
+func init() {
+	// Older Go versions don't know the WebP mime type, and none know JP2
+	mime.AddExtensionType(".webp", "image/webp")
+	mime.AddExtensionType(".jp2", "image/jp2")
+}
+
+// EncodeImage uses the built-in image libs to write an image to the browser.
+// JPEGs are encoded at the given quality, or JPEGQuality if it's zero.
+func EncodeImage(w io.Writer, img image.Image, format iiif.Format, quality int) error {
 	switch format {
 	case iiif.FmtJPG:
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+		if quality == 0 {
+			quality = JPEGQuality
+		}
+		if ProgressiveJPEG && !isTileSize(img.Bounds()) {
+			return turbojpeg.EncodeProgressive(w, img, quality)
+		}
+		if VipsBackend {
+			return vips.EncodeJPEG(w, img, quality)
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
 	case iiif.FmtPNG:
-		return png.Encode(w, img)
+		var enc = &png.Encoder{CompressionLevel: PNGCompression}
+		return enc.Encode(w, img)
 	case iiif.FmtGIF:
 		return gif.Encode(w, img, &gif.Options{NumColors: 256})
 	case iiif.FmtTIF:
-		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+		return tiffenc.Encode(w, img, &tiffenc.Options{Compression: TIFFCompression, Predictor: TIFFPredictor})
+	case iiif.FmtWEBP:
+		return webp.Encode(w, img, WebPQuality)
+	case iiif.FmtJP2:
+		return openjpeg.Encode(w, img)
 	}
 
 	return ErrInvalidEncodeFormat
 }
+
+// requestedQuality returns the JPEG quality asked for by the request's "q"
+// parameter, clamped to JPEGQualityMin and JPEGQualityMax.  Zero means the
+// default quality applies: the request isn't for a JPEG, overrides are off,
+// or the parameter is absent or matches JPEGQuality.  A value which isn't a
+// number is an error.
+func requestedQuality(req *http.Request, format iiif.Format) (int, error) {
+	var val = req.URL.Query().Get("q")
+	if format != iiif.FmtJPG || JPEGQualityMax == 0 || val == "" {
+		return 0, nil
+	}
+
+	var q, err = strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quality %q", val)
+	}
+	if q < JPEGQualityMin {
+		q = JPEGQualityMin
+	}
+	if q > JPEGQualityMax {
+		q = JPEGQualityMax
+	}
+	if q == JPEGQuality {
+		return 0, nil
+	}
+	return q, nil
+}
+
+// isTileSize returns true if the image is no larger than a cacheable tile
+func isTileSize(r image.Rectangle) bool {
+	return r.Dx() <= tileCacheMaxDim && r.Dy() <= tileCacheMaxDim
+}
+
+// bandable returns true if the format's encoder reads images from top to
+// bottom, so it can be given an image which is decoded a band at a time.
+// Progressive JPEGs and libvips need the whole image at once.
+func bandable(format iiif.Format) bool {
+	return (format == iiif.FmtJPG && !ProgressiveJPEG && !VipsBackend) || format == iiif.FmtPNG || format == iiif.FmtTIF
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultErrorTemplate is used for HTML error pages unless an ErrorTemplate
+// file is configured
+const defaultErrorTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}"><head>
+  <meta charset="utf-8">
+  <title>{{.Status}} {{.StatusText}}</title>
+</head>
+<body>
+  <h1>{{.Status}} {{.StatusText}}</h1>
+  <p>{{.Message}}</p>
+</body>
+</html>
+`
+
+// errorPages renders HTML error pages for browsers in place of RAIS's plain
+// text errors
+type errorPages struct {
+	tmpl *template.Template
+
+	// translations maps lowercased language tags (e.g., "fr" or "pt-br") to
+	// messages keyed by their English text
+	translations map[string]map[string]string
+}
+
+// errorPage is the data given to the error template.  StatusText and Message
+// are translated when possible, and templates can translate their own text
+// with T, e.g., {{.T "Back to the collection"}}.
+type errorPage struct {
+	Status     int
+	StatusText string
+	Message    string
+	Lang       string
+
+	messages map[string]string
+}
+
+// T returns the translation of s in the page's language, or s if there isn't
+// one
+func (p *errorPage) T(s string) string {
+	if t, ok := p.messages[s]; ok {
+		return t
+	}
+	return s
+}
+
+// loadErrorPages reads the error template, or uses the built-in template if
+// tmplPath is empty, and reads every "<language>.toml" file in transPath if
+// it isn't empty.  Translation files are flat tables of English text to the
+// translated text.
+func loadErrorPages(tmplPath, transPath string) (*errorPages, error) {
+	var ep = &errorPages{translations: make(map[string]map[string]string)}
+	var err error
+	if tmplPath == "" {
+		ep.tmpl, err = template.New("error").Parse(defaultErrorTemplate)
+	} else {
+		ep.tmpl, err = template.ParseFiles(tmplPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if transPath == "" {
+		return ep, nil
+	}
+	var files []string
+	files, err = filepath.Glob(filepath.Join(transPath, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		var messages map[string]string
+		_, err = toml.DecodeFile(f, &messages)
+		if err != nil {
+			return nil, err
+		}
+		var lang = strings.ToLower(strings.TrimSuffix(filepath.Base(f), ".toml"))
+		ep.translations[lang] = messages
+	}
+	return ep, nil
+}
+
+// language returns the best language tag from the request's Accept-Language
+// header for which there are translations, or "" if there are none.  A
+// regional tag like "fr-CA" falls back to "fr".
+func (ep *errorPages) language(req *http.Request) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		var fields = strings.Split(part, ";")
+		var tag = strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		var q = 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{tag, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if ep.translations[p.tag] != nil {
+			return p.tag
+		}
+		var base = strings.SplitN(p.tag, "-", 2)[0]
+		if ep.translations[base] != nil {
+			return base
+		}
+	}
+	return ""
+}
+
+// render returns the HTML page for an error
+func (ep *errorPages) render(req *http.Request, status int, message string) ([]byte, error) {
+	var lang = ep.language(req)
+	var p = &errorPage{Status: status, Lang: lang, messages: ep.translations[lang]}
+	if p.Lang == "" {
+		p.Lang = "en"
+	}
+	p.StatusText = p.T(http.StatusText(status))
+	p.Message = p.T(message)
+
+	var buf bytes.Buffer
+	var err = ep.tmpl.Execute(&buf, p)
+	return buf.Bytes(), err
+}
+
+// acceptsHTML returns true if the client explicitly asks for HTML, as
+// browsers do.  Wildcards don't count, so API clients keep getting plain text.
+func acceptsHTML(req *http.Request) bool {
+	for _, val := range req.Header["Accept"] {
+		for _, part := range strings.Split(val, ",") {
+			if strings.TrimSpace(strings.SplitN(part, ";", 2)[0]) == "text/html" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// middleware replaces plain text error responses with HTML pages for
+// browsers.  Errors with any other content type, such as JSON, are left
+// alone.
+func (ep *errorPages) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !acceptsHTML(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		var ew = &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, req)
+		if !ew.intercepted {
+			return
+		}
+
+		var message = strings.TrimSpace(ew.body.String())
+		var page, err = ep.render(req, ew.status, message)
+		if err != nil {
+			Logger.Errorf("Unable to render error page: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(ew.status)
+			w.Write(ew.body.Bytes())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(ew.status)
+		w.Write(page)
+	})
+}
+
+// errorWriter captures plain text error responses so they can be rewritten
+// (as HTML pages or JSON), passing everything else straight through
+type errorWriter struct {
+	http.ResponseWriter
+	status      int
+	intercepted bool
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (ew *errorWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	var ct = ew.Header().Get("Content-Type")
+	if code >= 400 && strings.HasPrefix(ct, "text/plain") {
+		ew.status, ew.intercepted = code, true
+		ew.Header().Del("Content-Length")
+		return
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *errorWriter) Write(data []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.intercepted {
+		return ew.body.Write(data)
+	}
+	return ew.ResponseWriter.Write(data)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestErrorPages(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-error-pages")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "fr.toml"), []byte(`"Not Found" = "Introuvable"
+"Image not found" = "Image introuvable"
+"Home" = "Accueil"
+`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "de.toml"), []byte(`"Not Found" = "Nicht gefunden"`), 0644)
+	var tmpl = filepath.Join(dir, "error.html")
+	ioutil.WriteFile(tmpl, []byte(`{{.Lang}}|{{.Status}}|{{.StatusText}}|{{.Message}}|{{.T "Home"}}`), 0644)
+
+	var ep *errorPages
+	ep, err = loadErrorPages(tmpl, dir)
+	assert.NilError(err, "loading error pages", t)
+
+	var h = ep.middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			http.Error(w, "Image not found", http.StatusNotFound)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	var get = func(path, accept, lang string) *httptest.ResponseRecorder {
+		var req = httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Language", lang)
+		var w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	var browser = "text/html,application/xhtml+xml,*/*;q=0.8"
+	var w = get("/missing", browser, "fr-CA, de;q=0.5")
+	assert.Equal(404, w.Code, "status is kept", t)
+	assert.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"), "HTML content type", t)
+	assert.Equal("fr|404|Introuvable|Image introuvable|Accueil", w.Body.String(), "regional tag falls back", t)
+
+	w = get("/missing", browser, "fr;q=0.2, de")
+	assert.Equal("de|404|Nicht gefunden|Image not found|Home", w.Body.String(), "highest quality wins", t)
+	w = get("/missing", browser, "ja")
+	assert.Equal("en|404|Not Found|Image not found|Home", w.Body.String(), "untranslated language", t)
+
+	w = get("/missing", "*/*", "fr")
+	assert.Equal("Image not found\n", w.Body.String(), "non-browsers get plain text", t)
+	w = get("/json", browser, "fr")
+	assert.Equal(`{"error":"not found"}`, w.Body.String(), "non-text errors are untouched", t)
+	w = get("/", browser, "fr")
+	assert.Equal("ok", w.Body.String(), "successful responses are untouched", t)
+
+	ep, err = loadErrorPages("", "")
+	assert.NilError(err, "loading default template", t)
+	var page, _ = ep.render(httptest.NewRequest("GET", "/", nil), 400, "<bad>")
+	assert.True(strings.Contains(string(page), "<p>&lt;bad&gt;</p>"), "messages are escaped", t)
+}
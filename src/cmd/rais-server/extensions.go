@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// extensionCacheLen is how many ID-to-file resolutions we remember
+const extensionCacheLen = 10000
+
+// extensionCache maps IDs which have no extension to the file they resolved
+// to, so we don't have to stat every candidate on each request.  It's only
+// set up when IDExtensions are configured.
+var extensionCache *lru.Cache
+
+// setupExtensionCache creates the extension cache and hooks it into cache
+// purging and expiration
+func setupExtensionCache() {
+	var err error
+	extensionCache, err = lru.New(extensionCacheLen)
+	if err != nil {
+		Logger.Fatalf("Unable to start extension cache: %s", err)
+	}
+	purgeCachePlugins = append(purgeCachePlugins, extensionCache.Purge)
+	expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { extensionCache.Remove(id) })
+}
+
+// resolveExtension returns the path to the image for an ID with no file
+// extension: the first of the handler's IDExtensions which, appended to fp,
+// names an existing file.  If the ID already has an extension, or nothing
+// matches, fp is returned unchanged.  Misses aren't cached, so images added
+// later are found without a cache purge.
+func (ih *ImageHandler) resolveExtension(id iiif.ID, fp string) string {
+	if len(ih.IDExtensions) == 0 || filepath.Ext(fp) != "" {
+		return fp
+	}
+
+	if extensionCache != nil {
+		var cached, ok = extensionCache.Get(id)
+		if ok {
+			return cached.(string)
+		}
+	}
+
+	for _, ext := range ih.IDExtensions {
+		var candidate = fp + ext
+		var fi, err = os.Stat(candidate)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if extensionCache != nil {
+			extensionCache.Add(id, candidate)
+		}
+		return candidate
+	}
+	return fp
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestResolveExtension(t *testing.T) {
+	var oldCache = extensionCache
+	extensionCache, _ = lru.New(10)
+	defer func() { extensionCache = oldCache }()
+
+	var h = NewImageHandler(rootDir(), "/iiif")
+	var base = rootDir() + "/docker/images/testfile/test-world-link"
+	assert.Equal(base, h.getIIIFPath("docker/images/testfile/test-world-link"), "no extensions configured", t)
+
+	h.IDExtensions = []string{".tif", ".jp2"}
+	assert.Equal(base+".jp2", h.getIIIFPath("docker/images/testfile/test-world-link"), "first existing extension", t)
+	assert.Equal(base+".jp2", h.getIIIFPath("docker/images/testfile/test-world-link.jp2"), "explicit extension", t)
+	assert.Equal(rootDir()+"/nope", h.getIIIFPath("nope"), "no match", t)
+	assert.Equal(1, extensionCache.Len(), "only hits are cached", t)
+
+	var w = httptest.NewRecorder()
+	h.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link/full/80,/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "extensionless ID is served", t)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+)
+
+// fallbackWanted returns true if a failure with the given status code should
+// be replaced by the fallback image: missing sources (404) and sources which
+// can't be read or decoded (500)
+func (ih *ImageHandler) fallbackWanted(code int) bool {
+	return ih.FallbackImage != "" && (code == http.StatusNotFound || code == http.StatusInternalServerError)
+}
+
+// serveFallback renders the configured placeholder image in place of a
+// missing or broken source image.  The placeholder is always rendered in
+// full, scaled to the requested size, since region coordinates only make
+// sense for the original image.  Returns false if the placeholder couldn't be
+// served, in which case the caller should report the original error.
+func (ih *ImageHandler) serveFallback(w http.ResponseWriter, u *iiif.URL) bool {
+	if u.Info || !u.Valid() {
+		return false
+	}
+
+	var res, err = img.NewResource(u.ID, ih.FallbackImage)
+	if err != nil {
+		Logger.Errorf("Unable to read fallback image %q: %s", ih.FallbackImage, err)
+		return false
+	}
+
+	var fu = *u
+	fu.Region = iiif.Region{Type: iiif.RTFull}
+	fu.Rotation = iiif.Rotation{}
+	if fu.Size.Type == iiif.STScalePercent {
+		fu.Size = iiif.Size{Type: iiif.STFull}
+	}
+
+	var i, applyErr = res.Apply(&fu, ih.Maximums)
+	if applyErr != nil {
+		Logger.Errorf("Unable to render fallback image %q: %s", ih.FallbackImage, applyErr)
+		return false
+	}
+
+	var buf = bytes.NewBuffer(nil)
+	err = EncodeImage(buf, i, fu.Format, 0)
+	if err != nil {
+		Logger.Errorf("Unable to encode fallback image to %s: %s", fu.Format, err)
+		return false
+	}
+
+	// The placeholder should never be cached, since the real image will
+	// presumably exist soon
+	w.Header().Set("Content-Type", mime.TypeByExtension("."+string(fu.Format)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(ih.FallbackStatus)
+	w.Write(buf.Bytes())
+	return true
+}
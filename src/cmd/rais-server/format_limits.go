@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+)
+
+// parseFormatLimits reads a comma-separated list of "format:size" pairs, such
+// as "tif:4000,png:8000", into a map of the largest output dimension allowed
+// for each format
+func parseFormatLimits(val string) (map[iiif.Format]int, error) {
+	var limits = make(map[iiif.Format]int)
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		var parts = strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid format limit %q: must be format:size", pair)
+		}
+		var f = iiif.StringToFormat(strings.TrimSpace(parts[0]))
+		if !f.Valid() {
+			return nil, fmt.Errorf("invalid format limit %q: unknown format", pair)
+		}
+		var size, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid format limit %q: size must be a positive integer", pair)
+		}
+		limits[f] = size
+	}
+
+	return limits, nil
+}
+
+// formatLimitError returns an error if the scaled output is too large for
+// the requested format
+func (ih *ImageHandler) formatLimitError(u *iiif.URL, scale image.Rectangle) *HandlerError {
+	var limit = ih.FormatLimits[u.Format]
+	if limit == 0 || (scale.Dx() <= limit && scale.Dy() <= limit) {
+		return nil
+	}
+	return NewError(fmt.Sprintf("%s output is limited to %d pixels in width and height", u.Format, limit), 501)
+}
+
+// applicableFormatLimits returns the format limits which restrict an image of
+// the given size, or nil if there are none
+func (ih *ImageHandler) applicableFormatLimits(w, h int) map[iiif.Format]int {
+	var limits map[iiif.Format]int
+	for f, limit := range ih.FormatLimits {
+		if limit < w || limit < h {
+			if limits == nil {
+				limits = make(map[iiif.Format]int)
+			}
+			limits[f] = limit
+		}
+	}
+	return limits
+}
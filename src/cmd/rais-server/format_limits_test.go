@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseFormatLimits(t *testing.T) {
+	var limits, err = parseFormatLimits("tif:4000, png:8000")
+	assert.NilError(err, "parsing limits", t)
+	assert.Equal(4000, limits["tif"], "tif limit", t)
+	assert.Equal(8000, limits["png"], "png limit", t)
+	assert.Equal(0, limits["jpg"], "no jpg limit", t)
+
+	for _, bad := range []string{"tif", "tif:0", "tif:big", "bmp:100"} {
+		_, err = parseFormatLimits(bad)
+		assert.True(err != nil, bad+" is invalid", t)
+	}
+}
+
+func TestFormatLimits(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.FormatLimits = map[iiif.Format]int{iiif.FmtPNG: 500, iiif.FmtTIF: 1000}
+
+	var get = func(path string) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var req, _ = http.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/"+path, nil)
+		h.IIIFRoute(w, req)
+		return w
+	}
+
+	assert.Equal(501, get("full/full/0/default.png").StatusCode, "full-size PNG is too large", t)
+	assert.Equal(-1, get("full/500,/0/default.png").StatusCode, "PNG within limits", t)
+	assert.Equal(-1, get("full/full/0/default.jpg").StatusCode, "JPG isn't limited", t)
+
+	var w = get("info.json")
+	var info map[string]interface{}
+	json.Unmarshal(w.Output, &info)
+	var limits = info["formatLimits"].(map[string]interface{})
+	assert.Equal(float64(500), limits["png"], "info.json reports the PNG limit", t)
+	assert.Equal(nil, limits["tif"], "limits above the image size aren't reported", t)
+}
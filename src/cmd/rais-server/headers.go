@@ -1,20 +1,38 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
-func sendHeaders(w http.ResponseWriter, req *http.Request, filepath string) error {
-	info, err := os.Stat(filepath)
+// errNotModified is returned by sendHeaders when it has sent a 304
+var errNotModified = errors.New("not modified")
+
+// sendHeaders stats the source file and sends the headers for an image
+// response; see sendFileHeaders.  A 404 is sent if the file can't be read.
+func sendHeaders(w http.ResponseWriter, req *http.Request, filepath, key string) error {
+	info, err := statSource(filepath)
 	if err != nil {
 		http.Error(w, "Unable to access file", 404)
 		return err
 	}
 
+	return sendFileHeaders(w, req, info, key)
+}
+
+// sendFileHeaders sends the headers for an image response generated from the
+// given source file, where key identifies the request (e.g., its IIIF path).
+// If the client already has this response, per either its ETag or the source
+// file's modification time, a 304 is sent and errNotModified returned.
+func sendFileHeaders(w http.ResponseWriter, req *http.Request, info os.FileInfo, key string) error {
 	// Set headers
-	w.Header().Set("Last-Modified", info.ModTime().Format(time.RFC1123))
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Check for forced download parameter
@@ -23,5 +41,71 @@ func sendHeaders(w http.ResponseWriter, req *http.Request, filepath string) erro
 		w.Header().Set("Content-Disposition", "attachment")
 	}
 
+	if notModified(w, req, imageETag(info, key)) {
+		return errNotModified
+	}
+
+	// If-Modified-Since is only considered when there's no If-None-Match
+	// header, since ETags are the more precise check
+	if req.Header.Get("If-None-Match") == "" && notModifiedSince(req, info.ModTime()) {
+		w.WriteHeader(http.StatusNotModified)
+		return errNotModified
+	}
 	return nil
 }
+
+// imageETag returns an ETag for a response rendered from the given source
+// file.  It changes whenever the file is replaced or modified, and differs
+// for each key.
+func imageETag(info os.FileInfo, key string) string {
+	var h = sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d", key, info.Size(), info.ModTime().UnixNano())
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// dataETag returns an ETag for a response body
+func dataETag(data []byte) string {
+	var sum = sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag header, and sends a 304 and returns true if the
+// request's If-None-Match header matches it
+func notModified(w http.ResponseWriter, req *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	if !conditionalMethod(req) {
+		return false
+	}
+
+	for _, val := range req.Header["If-None-Match"] {
+		for _, tag := range strings.Split(val, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notModifiedSince returns true if the request has an If-Modified-Since header
+// no earlier than modTime.  HTTP dates only have one-second precision, so
+// modTime is truncated before comparing.
+func notModifiedSince(req *http.Request, modTime time.Time) bool {
+	var ims = req.Header.Get("If-Modified-Since")
+	if ims == "" || !conditionalMethod(req) {
+		return false
+	}
+	var t, err = http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// conditionalMethod returns true if the request's method allows a 304 response
+func conditionalMethod(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
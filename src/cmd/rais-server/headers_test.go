@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestETags(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var base = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var get = func(path, inm string) *httptest.ResponseRecorder {
+		var req = httptest.NewRequest("GET", path, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, req)
+		return w
+	}
+
+	for _, path := range []string{base + "/full/full/0/default.jpg", base + "/info.json"} {
+		var w = get(path, "")
+		var etag = w.Header().Get("ETag")
+		assert.Equal(200, w.Code, path, t)
+		assert.True(etag != "", "ETag is set for "+path, t)
+		assert.Equal(etag, get(path, "").Header().Get("ETag"), "ETag is stable for "+path, t)
+
+		w = get(path, `"nope", W/`+etag)
+		assert.Equal(http.StatusNotModified, w.Code, "matching If-None-Match for "+path, t)
+		assert.Equal(0, w.Body.Len(), "304 has no body for "+path, t)
+		assert.Equal(etag, w.Header().Get("ETag"), "304 repeats the ETag for "+path, t)
+
+		assert.Equal(200, get(path, `"nope"`).Code, "mismatched If-None-Match for "+path, t)
+		assert.Equal(http.StatusNotModified, get(path, "*").Code, "wildcard If-None-Match for "+path, t)
+	}
+
+	var a = get(base+"/full/full/0/default.jpg", "").Header().Get("ETag")
+	var b = get(base+"/full/100,/0/default.jpg", "").Header().Get("ETag")
+	assert.True(a != b, "different requests get different ETags", t)
+}
+
+func TestLastModified(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/full/0/default.jpg"
+	var fi, err = os.Stat(filepath.Join(rootDir(), "docker/images/testfile/test-world-link.jp2"))
+	assert.NilError(err, "stat test file", t)
+	var get = func(headers map[string]string) *httptest.ResponseRecorder {
+		var req = httptest.NewRequest("GET", path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, req)
+		return w
+	}
+
+	var w = get(nil)
+	assert.Equal(fi.ModTime().UTC().Format(http.TimeFormat), w.Header().Get("Last-Modified"), "Last-Modified", t)
+
+	var later = fi.ModTime().Add(time.Hour).UTC().Format(http.TimeFormat)
+	var earlier = fi.ModTime().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	assert.Equal(http.StatusNotModified, get(map[string]string{"If-Modified-Since": later}).Code, "unchanged since", t)
+	assert.Equal(http.StatusNotModified, get(map[string]string{"If-Modified-Since": w.Header().Get("Last-Modified")}).Code,
+		"exact Last-Modified", t)
+	assert.Equal(200, get(map[string]string{"If-Modified-Since": earlier}).Code, "changed since", t)
+	assert.Equal(200, get(map[string]string{"If-Modified-Since": "yesterday"}).Code, "invalid date", t)
+	assert.Equal(200, get(map[string]string{"If-Modified-Since": later, "If-None-Match": `"nope"`}).Code,
+		"If-None-Match takes precedence", t)
+}
+
+func TestHead(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var base = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var do = func(method, path string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	var get, head = do("GET", base+"/info.json"), do("HEAD", base+"/info.json")
+	assert.Equal(200, head.Code, "info HEAD status", t)
+	assert.Equal(0, head.Body.Len(), "info HEAD has no body", t)
+	assert.Equal(strconv.Itoa(get.Body.Len()), head.Header().Get("Content-Length"), "info Content-Length", t)
+	assert.Equal(get.Header().Get("Content-Type"), head.Header().Get("Content-Type"), "info Content-Type", t)
+	assert.Equal(get.Header().Get("ETag"), head.Header().Get("ETag"), "info ETag", t)
+
+	var path = base + "/full/max/0/default.png"
+	head = do("HEAD", path)
+	assert.Equal(200, head.Code, "image HEAD status", t)
+	assert.Equal(0, head.Body.Len(), "image HEAD has no body", t)
+	assert.Equal("image/png", head.Header().Get("Content-Type"), "image Content-Type", t)
+	assert.True(head.Header().Get("Last-Modified") != "", "image Last-Modified", t)
+	assert.Equal(do("GET", path).Header().Get("ETag"), head.Header().Get("ETag"), "image ETag", t)
+
+	assert.Equal(400, do("HEAD", base+"/900,0,10,10/max/0/default.png").Code, "invalid image request", t)
+	assert.Equal(404, do("HEAD", "/iiif/missing.jp2/full/max/0/default.png").Code, "missing image", t)
+}
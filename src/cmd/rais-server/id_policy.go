@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"rais/src/iiif"
+	"regexp"
+)
+
+// IDPolicy limits which identifiers are looked up at all.  IDs which break
+// the policy are rejected before they reach aliases, proxies, or ID-to-path
+// plugins, which keeps traversal attempts and absurd keys away from them.
+type IDPolicy struct {
+	// MaxLength is the longest ID allowed, in bytes, or zero for no limit
+	MaxLength int
+
+	// Pattern, if set, must match the entire (unescaped) ID
+	Pattern *regexp.Regexp
+}
+
+// compileIDPattern compiles an ID pattern so it has to match the whole ID
+func compileIDPattern(p string) (*regexp.Regexp, error) {
+	if p == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + p + ")$")
+}
+
+// check returns an error describing how id breaks the policy, if it does
+func (p IDPolicy) check(id iiif.ID) error {
+	if p.MaxLength > 0 && len(id) > p.MaxLength {
+		return fmt.Errorf("id is longer than %d bytes", p.MaxLength)
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(string(id)) {
+		return fmt.Errorf("id contains disallowed characters")
+	}
+	return nil
+}
+
+// rejectID sends a 400 and returns true if id breaks the handler's ID
+// policy.  Rejections are logged as warnings, since they're more often
+// probes than typos; absurdly long IDs are truncated in the log.
+func (ih *ImageHandler) rejectID(w http.ResponseWriter, req *http.Request, id iiif.ID) bool {
+	var err = ih.IDPolicy.check(id)
+	if err == nil {
+		return false
+	}
+
+	var logged = string(id)
+	if len(logged) > 100 {
+		logged = logged[:100] + "..."
+	}
+	Logger.Warnf("Rejected request from %s for ID %q: %s", req.RemoteAddr, logged, err)
+	http.Error(w, "Invalid IIIF request: "+err.Error(), http.StatusBadRequest)
+	return true
+}
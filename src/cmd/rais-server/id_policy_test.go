@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestIDPolicyCheck(t *testing.T) {
+	var p = IDPolicy{MaxLength: 20}
+	p.Pattern, _ = compileIDPattern(`[a-z0-9/.]+`)
+	assert.NilError(p.check("foo/bar.jp2"), "valid id", t)
+	assert.True(p.check("foo/bar/baz/quux.jp2!") != nil, "bad characters", t)
+	assert.True(p.check("foo/bar/baz/quux/123.jp2") != nil, "too long", t)
+	assert.True(p.check("FOO.jp2") != nil, "pattern must match the whole id", t)
+	assert.NilError(IDPolicy{}.check(iiif.ID(strings.Repeat("x", 5000))), "no policy", t)
+}
+
+func TestIDPolicyRejection(t *testing.T) {
+	var calls int
+	idToPathPlugins = []func(iiif.ID) (string, error){
+		func(id iiif.ID) (string, error) {
+			calls++
+			return "", plugins.ErrSkipped
+		},
+	}
+	defer func() { idToPathPlugins = nil }()
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.IDPolicy.Pattern, _ = compileIDPattern(`[A-Za-z0-9._/-]+`)
+	ih.IDPolicy.MaxLength = 64
+
+	var get = func(path string) int {
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	assert.Equal(http.StatusBadRequest, get("/iiif/foo%3Bbar.jp2/info.json"), "bad characters", t)
+	assert.Equal(http.StatusBadRequest, get("/iiif/"+strings.Repeat("a", 65)+"/info.json"), "long id", t)
+	assert.Equal(http.StatusBadRequest, get("/iiif/foo%3Bbar.jp2"), "base URI redirect", t)
+	assert.Equal(0, calls, "plugins never see rejected IDs", t)
+
+	assert.Equal(http.StatusOK, get("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json"), "valid id", t)
+	assert.True(calls > 0, "plugins see valid IDs", t)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"rais/src/iiif"
+	"sort"
+	"sync"
+	"time"
+)
+
+// idStatBuckets is how many pieces the sliding window is split into; old data
+// ages out one bucket at a time
+const idStatBuckets = 12
+
+// idStatBucketIDs caps how many distinct IDs a bucket tracks, so floods of
+// one-off IDs can't grow it without bound.  When a bucket is full, the least
+// requested tenth of its IDs is dropped to make room.
+const idStatBucketIDs = 5000
+
+// idCounts holds the request data for one ID within a single bucket
+type idCounts struct {
+	Requests  int64
+	TotalTime time.Duration
+	MaxTime   time.Duration
+}
+
+// idBucket holds all IDs' data for a slice of the window
+type idBucket struct {
+	start time.Time
+	ids   map[iiif.ID]*idCounts
+}
+
+// IDSummary describes a single ID's requests over the whole window
+type IDSummary struct {
+	ID          iiif.ID
+	Requests    int64
+	AverageTime string
+	MaxTime     string
+
+	avg time.Duration
+}
+
+// idTracker keeps per-ID request counts and timing over a sliding window so
+// the stats endpoint can report the busiest and slowest images
+type idTracker struct {
+	m       sync.Mutex
+	window  time.Duration
+	buckets []*idBucket
+}
+
+// idStats is nil unless top-N tracking is enabled, in which case topIDCount
+// is the number of IDs reported in each list
+var idStats *idTracker
+var topIDCount int
+
+func newIDTracker(window time.Duration) *idTracker {
+	return &idTracker{window: window}
+}
+
+// record adds a single request for id, which took d to serve.  This is safe
+// to call on a nil tracker.
+func (t *idTracker) record(id iiif.ID, d time.Duration, now time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	var b = t.currentBucket(now)
+	var c = b.ids[id]
+	if c == nil {
+		if len(b.ids) >= idStatBucketIDs {
+			b.prune(idStatBucketIDs / 10)
+		}
+		c = &idCounts{}
+		b.ids[id] = c
+	}
+	c.Requests++
+	c.TotalTime += d
+	if d > c.MaxTime {
+		c.MaxTime = d
+	}
+}
+
+// prune drops the n least requested IDs from the bucket
+func (b *idBucket) prune(n int) {
+	var ids = make([]iiif.ID, 0, len(b.ids))
+	for id := range b.ids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		var ci, cj = b.ids[ids[i]], b.ids[ids[j]]
+		if ci.Requests != cj.Requests {
+			return ci.Requests < cj.Requests
+		}
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids[:minInt(n, len(ids))] {
+		delete(b.ids, id)
+	}
+}
+
+// currentBucket returns the bucket for the given time, creating it if need be
+// and dropping buckets which have left the window
+func (t *idTracker) currentBucket(now time.Time) *idBucket {
+	var size = t.window / idStatBuckets
+	var start = now.Truncate(size)
+	var n = len(t.buckets)
+	if n > 0 && t.buckets[n-1].start.Equal(start) {
+		return t.buckets[n-1]
+	}
+
+	var b = &idBucket{start: start, ids: make(map[iiif.ID]*idCounts)}
+	t.buckets = append(t.buckets, b)
+	t.expire(now)
+	return b
+}
+
+// expire removes buckets which ended before the window's start
+func (t *idTracker) expire(now time.Time) {
+	var size = t.window / idStatBuckets
+	var cutoff = now.Add(-t.window)
+	var i int
+	for i < len(t.buckets) && !t.buckets[i].start.Add(size).After(cutoff) {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+}
+
+// top returns up to n of the most requested IDs and the n IDs with the
+// slowest average response time within the window
+func (t *idTracker) top(n int, now time.Time) (mostRequested, slowest []IDSummary) {
+	t.m.Lock()
+	t.expire(now)
+	var totals = make(map[iiif.ID]*idCounts)
+	for _, b := range t.buckets {
+		for id, c := range b.ids {
+			var tc = totals[id]
+			if tc == nil {
+				tc = &idCounts{}
+				totals[id] = tc
+			}
+			tc.Requests += c.Requests
+			tc.TotalTime += c.TotalTime
+			if c.MaxTime > tc.MaxTime {
+				tc.MaxTime = c.MaxTime
+			}
+		}
+	}
+	t.m.Unlock()
+
+	var list = make([]IDSummary, 0, len(totals))
+	for id, c := range totals {
+		var avg = c.TotalTime / time.Duration(c.Requests)
+		list = append(list, IDSummary{
+			ID:          id,
+			Requests:    c.Requests,
+			AverageTime: avg.Round(time.Microsecond).String(),
+			MaxTime:     c.MaxTime.Round(time.Microsecond).String(),
+			avg:         avg,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Requests != list[j].Requests {
+			return list[i].Requests > list[j].Requests
+		}
+		return list[i].ID < list[j].ID
+	})
+	mostRequested = append(mostRequested, list[:minInt(n, len(list))]...)
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].avg != list[j].avg {
+			return list[i].avg > list[j].avg
+		}
+		return list[i].ID < list[j].ID
+	})
+	slowest = append(slowest, list[:minInt(n, len(list))]...)
+
+	return mostRequested, slowest
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestIDTracker(t *testing.T) {
+	var tr = newIDTracker(time.Hour)
+	var now = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// "old" is busy but falls out of the window
+	for i := 0; i < 10; i++ {
+		tr.record("old", time.Second, now)
+	}
+	now = now.Add(30 * time.Minute)
+	for i := 0; i < 3; i++ {
+		tr.record("busy", 10*time.Millisecond, now)
+	}
+	tr.record("slow", 2*time.Second, now)
+	tr.record("slow", 4*time.Second, now.Add(time.Minute))
+
+	var most, slowest = tr.top(2, now.Add(time.Minute))
+	assert.Equal("old busy", fmt.Sprint(most[0].ID, " ", most[1].ID), "most requested within the window", t)
+	assert.Equal("slow", string(slowest[0].ID), "slowest ID", t)
+	assert.Equal("3s", slowest[0].AverageTime, "average time", t)
+	assert.Equal("4s", slowest[0].MaxTime, "max time", t)
+
+	most, _ = tr.top(5, now.Add(40*time.Minute))
+	assert.Equal(2, len(most), "old requests expire", t)
+	assert.Equal("busy", string(most[0].ID), "busiest after expiry", t)
+
+	tr = newIDTracker(time.Hour)
+	tr.record("popular", time.Second, now)
+	tr.record("popular", time.Second, now)
+	for i := 0; i < idStatBucketIDs; i++ {
+		tr.record(iiif.ID(fmt.Sprintf("random-%d", i)), time.Second, now)
+	}
+	assert.True(len(tr.buckets[0].ids) <= idStatBucketIDs, "bucket size is capped", t)
+	assert.True(tr.buckets[0].ids["popular"] != nil, "popular IDs survive pruning", t)
+
+	var nilTracker *idTracker
+	nilTracker.record("x", time.Second, now)
+}
+
+func TestIDStatsSkipMissing(t *testing.T) {
+	var old = idStats
+	defer func() { idStats = old }()
+	idStats = newIDTracker(time.Hour)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/no-such-image.jp2/info.json", nil))
+	assert.Equal(http.StatusNotFound, w.Code, "missing ID", t)
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", nil))
+	assert.Equal(http.StatusOK, w.Code, "existing ID", t)
+
+	var most, _ = idStats.top(5, time.Now())
+	assert.Equal(1, len(most), "only the existing ID is tracked", t)
+}
@@ -4,23 +4,25 @@
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
+	"image"
 	"io/ioutil"
 	"math"
 	"mime"
 	"net/http"
 	"net/url"
+	"rais/src/chaos"
 	"rais/src/iiif"
 	"rais/src/img"
 	"rais/src/plugins"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func acceptsLD(req *http.Request) bool {
 	for _, h := range req.Header["Accept"] {
 		for _, accept := range strings.Split(h, ",") {
-			if accept == "application/ld+json" {
+			if strings.TrimSpace(strings.SplitN(accept, ";", 2)[0]) == "application/ld+json" {
 				return true
 			}
 		}
@@ -37,25 +39,142 @@ type ImageHandler struct {
 	FeatureSet    *iiif.FeatureSet
 	TilePath      string
 	Maximums      img.Constraint
+	ScaleFactors  []ScaleFactorRule
+
+	// TileWidth and TileHeight, if set, override the source image's native
+	// tile size in info.json responses.  A zero TileHeight means tiles are
+	// square.
+	TileWidth  int
+	TileHeight int
+
+	// TileRules override the tile size for IDs with a given prefix
+	TileRules []TileRule
+
+	// FallbackImage, if set, is served in place of missing or broken images,
+	// with FallbackStatus as the HTTP status code
+	FallbackImage  string
+	FallbackStatus int
+
+	// Auth, if set, restricts access to some images via the IIIF
+	// Authentication API
+	Auth *AuthService
+
+	// CanonicalRedirect sends a 301 to the canonical form of any image request
+	// which isn't already canonical
+	CanonicalRedirect bool
+
+	// SidecarPath, if set, is the root of a tree of per-image sidecar files
+	// named by ID; otherwise sidecars are looked for next to each image
+	SidecarPath string
+
+	// PDFMaxPages caps the number of images in a single PDF compilation
+	PDFMaxPages int
+
+	// ProxyRoutes lists ID prefixes which are served by remote IIIF servers
+	ProxyRoutes []*ProxyRoute
+
+	// FormatLimits caps the output dimensions for formats which are expensive
+	// to produce
+	FormatLimits map[iiif.Format]int
+
+	// ServerTiming turns on Server-Timing headers for IIIF responses, and
+	// TimingAllowOrigin, if set, is sent so browsers expose timing data to
+	// pages on other origins
+	ServerTiming      bool
+	TimingAllowOrigin string
+
+	// DerivativeSuffixes tells us where to look for pre-made, smaller copies
+	// of a source image which may be cheaper to decode
+	DerivativeSuffixes []string
+
+	// Rights is the attribution, license, and logo advertised for all images,
+	// and RightsRules override it for IDs with a given prefix
+	Rights      Rights
+	RightsRules []RightsRule
+
+	// TrimTrailingSlash ignores trailing slashes on requests, and
+	// CaseInsensitiveIDs folds IDs to lowercase before they're looked up
+	TrimTrailingSlash  bool
+	CaseInsensitiveIDs bool
+
+	// IDPolicy limits the length and characters of IDs
+	IDPolicy IDPolicy
+
+	// SourcePolicy says which source paths must resolve, after following
+	// symlinks, to a file under one of the SourceRoots
+	SourcePolicy SourcePolicy
+	SourceRoots  []string
+
+	// ServiceRules add custom service blocks to info.json
+	ServiceRules []ServiceRule
+
+	// Aliases maps old IDs to their replacements, which requests for the old
+	// IDs are redirected to
+	Aliases map[iiif.ID]iiif.ID
+
+	// Watermarks holds the rules for marking rendered images, if any
+	Watermarks *watermarks
+
+	// IDExtensions are tried, in order, when an ID doesn't include a file
+	// extension, so public URLs needn't expose how images are stored
+	IDExtensions []string
+
+	// InfoVersions lists the Image API versions info.json may be served as, in
+	// order of preference; clients pick one with the Accept header's profile
+	InfoVersions []int
+
+	// EmbedDPI writes resolution metadata into JPEG, TIFF, and PDF output,
+	// scaled from the source's capture resolution.  DefaultCaptureDPI is used
+	// for sources which don't record one.
+	EmbedDPI          bool
+	DefaultCaptureDPI float64
+
+	// BandPixels, if nonzero, is the largest output (in pixels) rendered in a
+	// single pass; larger JPEG, PNG, and TIFF requests are decoded, scaled,
+	// and encoded in horizontal bands of about this size to bound memory use
+	BandPixels int64
 }
 
 // NewImageHandler sets up a base ImageHandler with no features
 func NewImageHandler(tilePath, basePath string) *ImageHandler {
 	return &ImageHandler{
-		WebPathPrefix: basePath,
-		TilePath:      tilePath,
-		Maximums:      img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64},
-		FeatureSet:    iiif.AllFeatures(),
+		WebPathPrefix:  basePath,
+		TilePath:       tilePath,
+		SourcePolicy:   SourcesTilePath,
+		SourceRoots:    []string{tilePath},
+		Maximums:       img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64},
+		FeatureSet:     iiif.AllFeatures(),
+		FallbackStatus: http.StatusNotFound,
 	}
 }
 
-// cacheKey returns a key for caching if a given IIIF URL is cacheable by our
-// current, somewhat restrictive, rules
-func cacheKey(u *iiif.URL) string {
-	if tileCache != nil && u.Format == iiif.FmtJPG && u.Size.W > 0 && u.Size.W <= 1024 && u.Size.H <= 1024 {
-		return u.Path
+// tileCacheMaxDim is the largest width or height a request may have in order
+// to be stored in the tile cache.  It's raised if the advertised tile size is
+// larger than the default.
+var tileCacheMaxDim = 1024
+
+// cacheFor returns the cache partition, its stats, and the given render key
+// if a IIIF URL is cacheable by our current, somewhat restrictive, rules.
+// Deep Zoom tiles (dzi is true) arrive here as IIIF requests; they get their
+// own partition if one is configured, and otherwise share the IIIF
+// partitions.  Resize (thumbnail) requests get their own partition if one is
+// configured, so they can't evict tiles.  If the URL isn't cacheable, the key
+// is empty.
+func cacheFor(u *iiif.URL, key string, dzi bool) (c tileCacher, cs *cacheStats, cacheKey string) {
+	if u.Format != iiif.FmtJPG || u.Size.W <= 0 || u.Size.W > tileCacheMaxDim || u.Size.H > tileCacheMaxDim {
+		return nil, nil, ""
+	}
+
+	if deepZoomCache != nil && dzi {
+		return deepZoomCache, &stats.DeepZoomCache, key
+	}
+	if thumbnailCache != nil && urlRequestType(u) == plugins.ReqResize {
+		return thumbnailCache, &stats.ThumbnailCache, key
+	}
+	if tileCache != nil {
+		return tileCache, &stats.TileCache, key
 	}
-	return ""
+	return nil, nil, ""
 }
 
 // getRequestURL determines the "real" request URL.  Proxies are supported by
@@ -108,69 +227,150 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 	// actual request.  This should always work because a request shouldn't be
 	// able to get here if it didn't have our prefix.
 	var prefix = ih.WebPathPrefix + "/"
-	u.Path = strings.Replace(u.Path, prefix, "", 1)
+	u.Path = ih.normalizePath(strings.Replace(u.Path, prefix, "", 1))
 
 	iiifURL, err := iiif.NewURL(u.Path)
-	// If the iiifURL is invalid, it's possible this is a base URI request.
-	// Let's see if treating the path as an ID gives us any info.
+	// If the iiifURL is invalid, it's possible this is a base URI request
 	if err != nil {
-		if ih.isValidBasePath(u.Path) {
-			http.Redirect(w, req, req.URL.String()+"/info.json", 303)
-		} else {
-			http.Error(w, fmt.Sprintf("Invalid IIIF request %q: %s", iiifURL.Path, err), 400)
+		ih.baseURIRedirect(w, req, u.Path, err)
+		return
+	}
+	if !ih.routeID(w, req, iiifURL) {
+		return
+	}
+
+	var start = time.Now()
+
+	if ih.TimingAllowOrigin != "" {
+		w.Header().Set("Timing-Allow-Origin", ih.TimingAllowOrigin)
+	}
+	var st *serverTiming
+	if ih.ServerTiming {
+		st = newServerTiming()
+		w = &timingWriter{ResponseWriter: w, st: st}
+		req = withServerTiming(req, st)
+	}
+
+	// Make sure the info JSON has the proper asset id, which, for some reason in
+	// the IIIF spec, requires the full URL to the asset, not just its identifier
+	infourl := &url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   ih.WebPathPrefix,
+	}
+
+	// Because of how Go's URL path magic works, we really do have to just
+	// concatenate these two things with a slash manually
+	var infoID = infourl.String() + "/" + iiifURL.ID.Escaped()
+
+	// Requests for IDs handled by another server are proxied before we do any
+	// local lookups, but only after authorization: cached upstream responses
+	// must not reach users who couldn't see the image locally
+	if pr := ih.proxyRouteFor(iiifURL.ID); pr != nil {
+		if ih.authorize(iiifURL.ID, req) != plugins.AuthAllow {
+			var base = &url.URL{Scheme: u.Scheme, Host: u.Host}
+			ih.denyProxied(w, req, iiifURL, infoID, base.String())
+			return
 		}
+		pr.serve(w, req, iiifURL, infoID)
 		return
 	}
 
 	// Handle info.json prior to reading the image, in case of cached info
+	var phase = time.Now()
 	fp := ih.getIIIFPath(iiifURL.ID)
 	info, e := ih.getInfo(iiifURL.ID, fp)
+	st.since("resolve", phase)
 	if e != nil {
 		if e.Code != 404 {
 			Logger.Errorf("Error getting IIIF info.json for resource %s (path %s): %s", iiifURL.ID, fp, e.Message)
 		}
+		if ih.fallbackWanted(e.Code) && ih.serveFallback(w, iiifURL) {
+			return
+		}
 		http.Error(w, e.Message, e.Code)
 		return
 	}
 
-	// Make sure the info JSON has the proper asset id, which, for some reason in
-	// the IIIF spec, requires the full URL to the asset, not just its identifier
-	infourl := &url.URL{
-		Scheme: u.Scheme,
-		Host:   u.Host,
-		Path:   ih.WebPathPrefix,
-	}
+	// Only IDs which exist are tracked, so requests for made-up IDs can't fill
+	// the stats
+	defer func() { idStats.record(iiifURL.ID, time.Since(start), time.Now()) }()
 
-	// Because of how Go's URL path magic works, we really do have to just
-	// concatenate these two things with a slash manually
-	info.ID = infourl.String() + "/" + iiifURL.ID.Escaped()
+	info.ID = infoID
+	ih.addServices(iiifURL.ID, info)
+	var base = &url.URL{Scheme: u.Scheme, Host: u.Host}
+	var decision, ok = ih.authorizeLocal(w, req, iiifURL, info, base.String())
+	if !ok {
+		return
+	}
 
+	var fs = ih.featuresFor(iiifURL.ID)
 	if iiifURL.Info {
-		ih.Info(w, req, info)
+		ih.Info(w, req, iiifURL.ID, info, fs, decision == plugins.AuthDegraded)
+		return
+	}
+
+	// Unsupported requests are rejected before the tile cache is checked, so
+	// turning a feature off takes effect even for derivatives cached earlier
+	// (the disk cache survives restarts)
+	if !fs.Supported(iiifURL) {
+		http.Error(w, "Feature not supported", 501)
+		return
+	}
+
+	if ih.canonicalize(w, req, iiifURL, info) {
+		return
+	}
+
+	var quality, qerr = requestedQuality(req, iiifURL.Format)
+	if qerr != nil {
+		http.Error(w, "Invalid IIIF request: "+qerr.Error(), 400)
 		return
 	}
 
 	// Check the cache before spending the cycles to read in the image.  For now
 	// the cache is very limited to ensure only relatively small requests are
 	// actually cached.
-	if key := cacheKey(iiifURL); key != "" {
-		stats.TileCache.Get()
-		data, ok := tileCache.Get(key)
+	var ri = plugins.GetRequestInfo(req)
+	ri.CacheStatus = plugins.CacheBypass
+	var rkey, _ = ih.renderKey(iiifURL, info, quality)
+	if c, cs, key := cacheFor(iiifURL, rkey, fromDZI(req)); key != "" {
+		ri.CacheStatus = plugins.CacheMiss
+		cs.Get()
+		phase = time.Now()
+		data, ok := c.Get(key)
+		st.since("cache", phase)
+		if ok && chaos.DropCache() {
+			ok = false
+		}
 		if ok {
-			stats.TileCache.Hit()
+			ri.CacheStatus = plugins.CacheHit
+			cs.Hit()
+			if fi, err := statSource(fp); err == nil && sendFileHeaders(w, req, fi, key) != nil {
+				return
+			}
+			var b = data.([]byte)
 			w.Header().Set("Content-Type", mime.TypeByExtension("."+string(iiifURL.Format)))
-			w.Write(data.([]byte))
+			w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			if req.Method != http.MethodHead {
+				w.Write(b)
+			}
 			return
 		}
 	}
 
 	// No info path should mean a full command path - start reading the image
+	phase = time.Now()
 	res, err := img.NewResource(iiifURL.ID, fp)
+	st.since("resolve", phase)
 	if err != nil {
 		e := newImageResError(err)
 		if e.Code != 404 {
 			Logger.Errorf("Error initializing resource %s (path %s): %s", iiifURL.ID, fp, err)
 		}
+		if ih.fallbackWanted(e.Code) && ih.serveFallback(w, iiifURL) {
+			return
+		}
 		http.Error(w, e.Message, e.Code)
 		return
 	}
@@ -182,28 +382,82 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Attempt to run the command
-	ih.Command(w, req, iiifURL, res, info)
+	ih.Command(w, req, iiifURL, quality, res, info, fs)
 }
 
-// isValidBasePath returns true if the given path is simply missing /info.json
-// to function properly
-func (ih *ImageHandler) isValidBasePath(path string) bool {
-	var jsonPath = path + "/info.json"
-	var iiifURL, err = iiif.NewURL(jsonPath)
-	if err != nil {
+// routeID normalizes the request's ID, then checks it against the ID policy
+// and aliases.  If the request is rejected or redirected, a response is
+// written to w and false is returned.
+func (ih *ImageHandler) routeID(w http.ResponseWriter, req *http.Request, u *iiif.URL) bool {
+	ih.normalizeID(u)
+	if ih.rejectID(w, req, u.ID) {
 		return false
 	}
+	return !ih.redirectAlias(w, req, u)
+}
 
-	var fp = ih.getIIIFPath(iiifURL.ID)
-	var e *HandlerError
-	_, e = ih.getInfo(iiifURL.ID, fp)
-	return e == nil
+// authorizeLocal applies the authorization decision for a locally served
+// image.  Restricted images advertise the login service, and require an
+// access token or login cookie unless an authorization plugin says
+// otherwise; degraded requests have info reduced to the degraded tier.  If
+// the request can't be served, a response is written to w and ok is false.
+func (ih *ImageHandler) authorizeLocal(w http.ResponseWriter, req *http.Request, u *iiif.URL, info *iiif.Info, baseURL string) (decision plugins.AuthDecision, ok bool) {
+	if ih.Auth.restricts(u.ID) {
+		info.Service = append(info.Service, ih.Auth.service(baseURL))
+	}
+	decision = ih.authorize(u.ID, req)
+	switch decision {
+	case plugins.AuthDeny:
+		ih.deny(w, req, u, info)
+		return decision, false
+	case plugins.AuthDegraded:
+		return decision, ih.serveDegraded(w, req, u, info)
+	}
+	return decision, true
+}
+
+// baseURIRedirect handles paths which aren't valid IIIF requests, but may be
+// a bare image identifier.  Per the spec's baseUriRedirect feature, those are
+// sent to the image's info.json with a 303.  A single path segment is taken
+// to be an identifier and gets the same error an info request would if it
+// can't be served; anything else is a 400.
+func (ih *ImageHandler) baseURIRedirect(w http.ResponseWriter, req *http.Request, path string, parseErr error) {
+	var infoURL, err = iiif.NewURL(path + "/info.json")
+	if err != nil || !ih.featuresFor(infoURL.ID).BaseURIRedirect {
+		http.Error(w, fmt.Sprintf("Invalid IIIF request %q: %s", path, parseErr), 400)
+		return
+	}
+	ih.normalizeID(infoURL)
+	if ih.rejectID(w, req, infoURL.ID) {
+		return
+	}
+
+	var escaped = ih.normalizePath(strings.TrimPrefix(req.URL.EscapedPath(), ih.WebPathPrefix+"/"))
+	var _, e = ih.getInfo(infoURL.ID, ih.getIIIFPath(infoURL.ID))
+	if e != nil && strings.Contains(escaped, "/") {
+		http.Error(w, fmt.Sprintf("Invalid IIIF request %q: %s", path, parseErr), 400)
+		return
+	}
+	if e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	http.Redirect(w, req, ih.WebPathPrefix+"/"+escaped+"/info.json", 303)
 }
 
 func (ih *ImageHandler) getIIIFPath(id iiif.ID) string {
+	// Routes reject bad IDs up front; this keeps any other caller from handing
+	// one to a plugin
+	if ih.IDPolicy.check(id) != nil {
+		return ""
+	}
+
 	for _, idtopath := range idToPathPlugins {
 		fp, err := idtopath(id)
 		if err == nil {
+			if !ih.confinedSource(id, fp, true) {
+				return ""
+			}
 			return fp
 		}
 		if err == plugins.ErrSkipped {
@@ -211,7 +465,17 @@ func (ih *ImageHandler) getIIIFPath(id iiif.ID) string {
 		}
 		Logger.Warnf("Error trying to use plugin to translate iiif.ID: %s", err)
 	}
-	return ih.TilePath + "/" + string(id)
+
+	// Without a tile path, only plugins can resolve IDs; we mustn't fall back to
+	// a path relative to the filesystem root
+	if ih.TilePath == "" {
+		return ""
+	}
+	var fp = ih.resolveExtension(id, ih.TilePath+"/"+string(id))
+	if !ih.confinedSource(id, fp, false) {
+		return ""
+	}
+	return fp
 }
 
 func convertStrings(s1, s2, s3 string) (i1, i2, i3 int, err error) {
@@ -228,31 +492,47 @@ func convertStrings(s1, s2, s3 string) (i1, i2, i3 int, err error) {
 }
 
 // Info responds to a IIIF info request with appropriate JSON based on the
-// image's data and the handler's capabilities
-func (ih *ImageHandler) Info(w http.ResponseWriter, req *http.Request, info *iiif.Info) {
+// image's data and the handler's capabilities.  The JSON is kept in the info
+// document cache, keyed on everything that can change it for a given ID: the
+// full info ID (which includes the server's URL), the IIIF version, and
+// whether the info was reduced for a degraded authorization.
+func (ih *ImageHandler) Info(w http.ResponseWriter, req *http.Request, id iiif.ID, info *iiif.Info, fs *iiif.FeatureSet, degraded bool) {
 	// Convert info to JSON
-	json, err := marshalInfo(info)
+	var version = ih.infoVersion(req)
+	var variant = fmt.Sprintf("info %d %t %s", version, degraded, info.ID)
+	json, err := infoDoc(id, variant, func() ([]byte, *HandlerError) { return marshalInfo(info, version) })
 	if err != nil {
 		http.Error(w, err.Message, err.Code)
 		return
 	}
 
 	// Set headers - content type is dependent on client
-	ct := "application/json"
-	if acceptsLD(req) {
-		ct = "application/ld+json"
+	w.Header().Set("Content-Type", infoContentType(req, version, fs.JsonldMediaType))
+	if len(ih.InfoVersions) > 1 {
+		w.Header().Add("Vary", "Accept")
+	}
+	if notModified(w, req, dataETag(json)) {
+		return
 	}
-	w.Header().Set("Content-Type", ct)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Write(json)
+	w.Header().Set("Content-Length", strconv.Itoa(len(json)))
+	if req.Method != http.MethodHead {
+		w.Write(json)
+	}
 }
 
 func newImageResError(err error) *HandlerError {
+	if _, ok := err.(img.UnsupportedError); ok {
+		return NewError(err.Error(), 501)
+	}
+
 	switch err {
 	case img.ErrDimensionsExceedLimits:
 		return NewError(err.Error(), 501)
 	case img.ErrDoesNotExist:
 		return NewError("image resource does not exist", 404)
+	case img.ErrRegionOutsideImage, img.ErrSizeTooSmall:
+		return NewError(err.Error(), 400)
 	default:
 		return NewError(err.Error(), 500)
 	}
@@ -287,7 +567,7 @@ func (ih *ImageHandler) loadInfoFromCache(id iiif.ID) *iiif.Info {
 	}
 
 	stats.InfoCache.Hit()
-	return ih.buildInfo(id, data.(ImageInfo))
+	return ih.buildInfo(id, data)
 }
 
 func (ih *ImageHandler) loadInfoOverride(id iiif.ID, fp string) *iiif.Info {
@@ -333,17 +613,34 @@ func (ih *ImageHandler) loadInfoFromImageResource(id iiif.ID, fp string) (*iiif.
 }
 
 func (ih *ImageHandler) buildInfo(id iiif.ID, i ImageInfo) *iiif.Info {
-	info := ih.FeatureSet.Info()
+	var fs, max, rights = ih.overrides(id)
+	info := fs.Info()
 	info.Width = i.Width
 	info.Height = i.Height
+	rights.apply(info)
 
-	if ih.Maximums.SmallerThanAny(i.Width, i.Height) {
-		info.Profile.MaxArea = ih.Maximums.Area
-		info.Profile.MaxWidth = ih.Maximums.Width
-		info.Profile.MaxHeight = ih.Maximums.Height
+	// Limits are only advertised when they affect the image.  IIIF requires
+	// maxWidth whenever maxHeight is given, and defaults maxHeight to maxWidth,
+	// so those two are always advertised together.
+	if max.Width < i.Width || max.Height < i.Height {
+		info.Profile.MaxWidth = max.Width
+		info.Profile.MaxHeight = max.Height
 	}
+	if max.Area < int64(i.Width)*int64(i.Height) {
+		info.Profile.MaxArea = max.Area
+	}
+
+	info.FormatLimits = ih.applicableFormatLimits(i.Width, i.Height)
+	var sfRule = ih.scaleFactorRuleFor(id)
+	info.Sizes = levelSizes(i, max, sfRule)
 
-	// Set up tile sizes
+	// Set up tile sizes, preferring the configured geometry if there is one
+	var tileRule = ih.tileRuleFor(id)
+	if tileRule != nil {
+		i.TileWidth, i.TileHeight = tileRule.Width, tileRule.Height
+	} else if ih.TileWidth > 0 {
+		i.TileWidth, i.TileHeight = ih.TileWidth, ih.TileHeight
+	}
 	if i.TileWidth > 0 {
 		var sf []int
 		scale := 1
@@ -359,6 +656,9 @@ func (ih *ImageHandler) buildInfo(id iiif.ID, i ImageInfo) *iiif.Info {
 			sf = append(sf, scale)
 			scale <<= 1
 		}
+		if sfRule != nil {
+			sf = sfRule.filter(sf)
+		}
 		info.Tiles = make([]iiif.TileSize, 1)
 		info.Tiles[0] = iiif.TileSize{
 			Width:        i.TileWidth,
@@ -372,8 +672,54 @@ func (ih *ImageHandler) buildInfo(id iiif.ID, i ImageInfo) *iiif.Info {
 	return info
 }
 
-func marshalInfo(info *iiif.Info) ([]byte, *HandlerError) {
-	json, err := json.Marshal(info)
+// levelSizes returns the full-image size at each of the source's resolution
+// levels, smallest first.  These can be decoded without any scaling work, so
+// thumbnail consumers can use them to pick cheap sizes.  Sizes larger than
+// max are skipped, as are absurdly small sizes.  If rule isn't nil, only the
+// levels whose scale factors it allows are listed, so sizes don't offer
+// resolutions the tiles are capped at.
+func levelSizes(i ImageInfo, max img.Constraint, rule *ScaleFactorRule) []iiif.ImageSize {
+	if i.Levels < 2 {
+		return nil
+	}
+
+	var scales []int
+	for x := 0; x < i.Levels; x++ {
+		var scale = 1 << uint(x)
+		var w, h = levelSize(i, scale)
+		if w < 16 || h < 16 {
+			break
+		}
+		if max.SmallerThanAny(w, h) {
+			continue
+		}
+		scales = append(scales, scale)
+	}
+	if rule != nil {
+		scales = rule.filter(scales)
+	}
+
+	var sizes []iiif.ImageSize
+	for _, scale := range scales {
+		var w, h = levelSize(i, scale)
+		sizes = append([]iiif.ImageSize{{Width: w, Height: h}}, sizes...)
+	}
+	return sizes
+}
+
+// levelSize returns the full-image size at the given scale factor.
+// Resolution levels round up, per the JPEG 2000 spec.
+func levelSize(i ImageInfo, scale int) (w, h int) {
+	return (i.Width + scale - 1) / scale, (i.Height + scale - 1) / scale
+}
+
+// marshalInfo serializes info for the given Image API version
+func marshalInfo(info *iiif.Info, version int) ([]byte, *HandlerError) {
+	var v interface{} = info
+	if version == 3 {
+		v = info.V3()
+	}
+	json, err := json.Marshal(v)
 	if err != nil {
 		Logger.Errorf("Unable to marshal IIIFInfo response: %s", err)
 		return nil, NewError("server error", 500)
@@ -382,63 +728,194 @@ func marshalInfo(info *iiif.Info) ([]byte, *HandlerError) {
 	return json, nil
 }
 
-// Command handles image processing operations
-func (ih *ImageHandler) Command(w http.ResponseWriter, req *http.Request, u *iiif.URL, res *img.Resource, info *iiif.Info) {
+// constraints returns the size constraints for a request.  If we have an
+// info, we can make use of it for the constraints rather than using the global
+// constraints; this is useful for overridden info.json files.
+func (ih *ImageHandler) constraints(info *iiif.Info) img.Constraint {
+	if info == nil {
+		return ih.Maximums
+	}
+
+	var max = img.Constraint{
+		Width:  info.Profile.MaxWidth,
+		Height: info.Profile.MaxHeight,
+		Area:   info.Profile.MaxArea,
+	}
+	if max.Width == 0 {
+		max.Width = math.MaxInt32
+	}
+	if max.Height == 0 {
+		max.Height = math.MaxInt32
+	}
+	if max.Area == 0 {
+		max.Area = math.MaxInt64
+	}
+	return max
+}
+
+// Command handles image processing operations.  The request must already
+// have been checked against the feature set, fs.  A nonzero quality overrides
+// the default JPEG quality.
+func (ih *ImageHandler) Command(w http.ResponseWriter, req *http.Request, u *iiif.URL, quality int, res *img.Resource, info *iiif.Info, fs *iiif.FeatureSet) {
+	var key, mark = ih.renderKey(u, info, quality)
+	var dzi = fromDZI(req)
+
 	// Send last modified time
-	if err := sendHeaders(w, req, res.FilePath); err != nil {
+	if err := sendHeaders(w, req, res.FilePath, key); err != nil {
 		return
 	}
 
-	// Do we support this request?  If not, return a 501
-	if !ih.FeatureSet.Supported(u) {
-		http.Error(w, "Feature not supported", 501)
+	var max = ih.constraints(info)
+	var crop, scale, err = img.Dimensions(u, info.Width, info.Height, max)
+	if e := ih.dimensionsError(u, info, fs, crop, scale, err); e != nil {
+		http.Error(w, e.Message, e.Code)
 		return
 	}
-
-	var max = ih.Maximums
-
-	// If we have an info, we can make use of it for the constraints rather than
-	// using the global constraints; this is useful for overridden info.json files
-	if info != nil {
-		max = img.Constraint{
-			Width:  info.Profile.MaxWidth,
-			Height: info.Profile.MaxHeight,
-			Area:   info.Profile.MaxArea,
-		}
-		if max.Width == 0 {
-			max.Width = math.MaxInt32
+	if err == nil {
+		setZoomLevel(plugins.GetRequestInfo(req), crop, scale)
+		if e := memMonitor.shed(scale); e != nil {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, e.Message, e.Code)
+			return
 		}
-		if max.Height == 0 {
-			max.Height = math.MaxInt32
+		if jobs.wants(req, scale) {
+			var j, ok = jobs.submit(key, u.Format, "", func() ([]byte, *HandlerError) {
+				// Jobs can sit in the queue a while, so memory is checked again
+				// when they actually run
+				if e := memMonitor.shed(scale); e != nil {
+					return nil, e
+				}
+				return renderRequests.do(key, func() ([]byte, *HandlerError) {
+					return ih.render(u, key, mark, dzi, quality, res, max, nil)
+				})
+			})
+			if !ok {
+				jobs.full(w)
+				return
+			}
+			jobs.accepted(w, j)
+			return
 		}
-		if max.Area == 0 {
-			max.Area = math.MaxInt64
+	}
+
+	// HEAD requests get everything but the image, so we skip the decode; the
+	// size isn't known without encoding, so there's no Content-Length
+	if req.Method == http.MethodHead {
+		if err != nil {
+			var e = newImageResError(err)
+			http.Error(w, e.Message, e.Code)
+			return
 		}
+		w.Header().Set("Content-Type", mime.TypeByExtension("."+string(u.Format)))
+		return
 	}
-	img, err := res.Apply(u, max)
-	if err != nil {
-		e := newImageResError(err)
-		Logger.Errorf("Error applying transorm: %s", err)
+
+	var st = getServerTiming(req)
+	var data, e = renderRequests.do(key, func() ([]byte, *HandlerError) {
+		return ih.render(u, key, mark, dzi, quality, res, max, st)
+	})
+	if e != nil {
+		if ih.fallbackWanted(e.Code) && ih.serveFallback(w, u) {
+			return
+		}
 		http.Error(w, e.Message, e.Code)
 		return
 	}
 
 	w.Header().Set("Content-Type", mime.TypeByExtension("."+string(u.Format)))
+	if _, err := w.Write(data); err != nil {
+		Logger.Errorf("Unable to write %s: %s", u.Format, err)
+		return
+	}
+}
 
+// dimensionsError returns an error if the region and size computed for a
+// request, and the error computing them, mean the request must be refused
+// before rendering.  Errors Command leaves to the render step, such as
+// exceeding the server's limits, return nil.
+func (ih *ImageHandler) dimensionsError(u *iiif.URL, info *iiif.Info, fs *iiif.FeatureSet, crop, scale image.Rectangle, err error) *HandlerError {
+	if err == img.ErrRegionOutsideImage || err == img.ErrSizeTooSmall {
+		return NewError("Invalid IIIF request: "+err.Error(), 400)
+	}
+	if err != nil {
+		return nil
+	}
+	if !fs.SizeAboveFull && (scale.Dx() > crop.Dx() || scale.Dy() > crop.Dy()) {
+		return NewError("Invalid IIIF request: requested size is larger than the region, "+
+			"and upscaling isn't supported", 400)
+	}
+	if e := ih.scaleFactorError(u.ID, info, crop, scale); e != nil {
+		return e
+	}
+	return ih.formatLimitError(u, scale)
+}
+
+// render decodes, transforms, and encodes the resource per the IIIF URL's
+// instructions, storing the result in the tile cache if appropriate.  The
+// number of simultaneous renders for a single source file is constrained by
+// the server's decode limiter.  Decode and encode times are added to st.  A
+// nonzero quality overrides the default JPEG quality, key identifies the
+// output in the tile cache, and mark, if not nil, is the watermark rule the
+// key was built for.  dzi is true for Deep Zoom tiles, which may be cached in
+// their own partition.
+func (ih *ImageHandler) render(u *iiif.URL, key string, mark *watermarkRule, dzi bool, quality int, res *img.Resource, max img.Constraint, st *serverTiming) ([]byte, *HandlerError) {
+	var dpi = ih.outputDPI(u, res, max)
+	var src, su = ih.cheapestSource(u, res, max)
+	var release = decodeLimit.acquire(src.FilePath)
+	defer release()
+
+	// Banded images are decoded as they're encoded, so for those the decode
+	// timing only covers the first band.  Watermarks need the whole image, so
+	// marked images aren't banded.
+	var start = time.Now()
+	chaos.DelayDecode()
+	var img image.Image
+	var err error
+	if bandable(u.Format) && mark == nil {
+		img, err = src.ApplyBanded(su, max, ih.BandPixels)
+	} else {
+		img, err = src.Apply(su, max)
+	}
+	if err != nil {
+		e := newImageResError(err)
+		Logger.Errorf("Error applying transorm: %s", err)
+		return nil, e
+	}
+	sourceUsage.record(src, time.Since(start))
+	if mark != nil {
+		img = mark.apply(img)
+	}
+	st.since("decode", start)
+
+	start = time.Now()
 	cacheBuf := bytes.NewBuffer(nil)
-	if err := EncodeImage(cacheBuf, img, u.Format); err != nil {
-		http.Error(w, "Unable to encode", 500)
+	var out = img
+	if u.Format == iiif.FmtPNG {
+		out = palettize(img, u.Quality)
+	}
+	err = EncodeImage(cacheBuf, out, u.Format, quality)
+	if bi, ok := img.(interface{ Err() error }); ok && err == nil {
+		err = bi.Err()
+	}
+	if err != nil {
 		Logger.Errorf("Unable to encode to %s: %s", u.Format, err)
-		return
+		return nil, NewError("Unable to encode", 500)
 	}
+	var data = embedDPI(cacheBuf.Bytes(), u.Format, dpi)
+	st.since("encode", start)
 
-	if key := cacheKey(u); key != "" {
-		stats.TileCache.Set()
-		tileCache.Add(key, cacheBuf.Bytes())
+	if VerifyEncodes {
+		if err = verifyEncoded(key, src.FilePath, data, u.Format, out); err != nil {
+			Logger.Errorf("Encoded %s for %q failed verification: %s", u.Format, key, err)
+			return nil, NewError("Encoded output failed verification", 500)
+		}
 	}
 
-	if _, err := io.Copy(w, cacheBuf); err != nil {
-		Logger.Errorf("Unable to encode to %s: %s", u.Format, err)
-		return
+	var c, cs, ckey = cacheFor(u, key, dzi)
+	if ckey != "" && (tileCacheMaxBytes == 0 || len(data) <= tileCacheMaxBytes) {
+		cs.Set()
+		c.Add(ckey, data)
 	}
+
+	return data, nil
 }
@@ -4,26 +4,31 @@
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image/png"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"rais/src/fakehttp"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/jp2info"
+	"rais/src/openjpeg"
 	"strings"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/assert"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
-var unlimited = img.Constraint{math.MaxInt32, math.MaxInt32, math.MaxInt64}
+var unlimited = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
 
 func init() {
 	Logger = logger.New(logger.Warn)
-	img.RegisterDecoder(decodeJP2)
+	img.RegisterStreamDecoder("", decodeJP2)
 }
 
 func rootDir() string {
@@ -130,12 +135,19 @@ func TestInfoRedirect(t *testing.T) {
 	locHeader := w.Headers["Location"]
 	assert.Equal(1, len(locHeader), "There's only 1 location header", t)
 	assert.Equal("/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", locHeader[0], "Location header", t)
+
+	w = request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2?foo=bar", t)
+	assert.Equal(303, w.StatusCode, "Base URL with a query redirects", t)
+	assert.Equal("/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", w.Headers.Get("Location"), "Query is dropped", t)
+
+	w = request("docker%2Fimages%2Fnope.jp2", t)
+	assert.Equal(404, w.StatusCode, "Base URL for a missing image is a 404", t)
 }
 
 // TestInfoMaxSize verifies that when the image is bigger than the handler's
 // maximums, values are present in the info profile
 func TestInfoMaxSize(t *testing.T) {
-	w := dorequest("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", false, img.Constraint{60, 80, 480}, t)
+	w := dorequest("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", false, img.Constraint{Width: 60, Height: 80, Area: 480}, t)
 	var data iiif.Info
 	json.Unmarshal(w.Output, &data)
 	assert.Equal(60, data.Profile.MaxWidth, "JSON-decoded max width", t)
@@ -151,7 +163,7 @@ func TestInfoMaxSize(t *testing.T) {
 // TestInfoNoMaxSize verifies that when the image is smaller than the handler's
 // maximums, values are not present in the info profile
 func TestInfoNoMaxSize(t *testing.T) {
-	w := dorequest("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", false, img.Constraint{6000, 8000, 4800000}, t)
+	w := dorequest("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", false, img.Constraint{Width: 6000, Height: 8000, Area: 4800000}, t)
 	var data iiif.Info
 	json.Unmarshal(w.Output, &data)
 	assert.Equal(0, data.Profile.MaxWidth, "JSON-decoded width", t)
@@ -164,6 +176,15 @@ func TestInfoNoMaxSize(t *testing.T) {
 	assert.False(bytes.Contains(w.Output, []byte("maxArea")), "no maxArea", t)
 }
 
+// TestInfoMaxAreaOnly verifies that only the maximums which affect the image
+// are present in the info profile
+func TestInfoMaxAreaOnly(t *testing.T) {
+	w := dorequest("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", false, img.Constraint{Width: 6000, Height: 8000, Area: 4800}, t)
+	assert.False(bytes.Contains(w.Output, []byte("maxWidth")), "no maxWidth", t)
+	assert.False(bytes.Contains(w.Output, []byte("maxHeight")), "no maxHeight", t)
+	assert.True(bytes.Contains(w.Output, []byte(`"maxArea":4800`)), "maxArea", t)
+}
+
 func TestCommandHandler404(t *testing.T) {
 	w := request("identifier/full/full/0/default.jpg", t)
 	assert.Equal(404, w.StatusCode, "Valid command on nonexistent file returns 404", t)
@@ -174,11 +195,38 @@ func TestCommandHandlerInvalidFile(t *testing.T) {
 	assert.Equal(500, w.StatusCode, "Valid command on non-image file returns 500", t)
 }
 
+func TestNoTilePath(t *testing.T) {
+	var h = NewImageHandler("", "/iiif")
+	assert.Equal("", h.getIIIFPath("etc%2Fpasswd"), "IDs don't resolve without a tile path", t)
+
+	var w = fakehttp.NewResponseWriter()
+	var req, _ = http.NewRequest("GET", "/iiif/"+rootDir()[1:]+"%2Fdocker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", nil)
+	h.IIIFRoute(w, req)
+	assert.Equal(404, w.StatusCode, "absolute paths aren't served without a tile path", t)
+}
+
 func TestInvalidRequest(t *testing.T) {
 	w := request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/foo/bar", t)
 	assert.Equal(400, w.StatusCode, "Bad request is reported as such", t)
 }
 
+func TestAbsurdRequests(t *testing.T) {
+	var id = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	w := request(id+"/900,0,10,10/full/0/default.jpg", t)
+	assert.Equal(400, w.StatusCode, "region outside the image", t)
+	w = request(id+"/full/pct:0.01/0/default.jpg", t)
+	assert.Equal(400, w.StatusCode, "size which rounds to nothing", t)
+	w = request(id+"/790,390,20,20/full/0/default.jpg", t)
+	assert.Equal(-1, w.StatusCode, "region past the edge is clipped", t)
+	w = request(id+"/0,399,800,1/100,/0/default.jpg", t)
+	assert.Equal(-1, w.StatusCode, "thin edge region", t)
+
+	w = request(id+"/0,0,10,10/20,/0/default.jpg", t)
+	assert.Equal(400, w.StatusCode, "upscaling without sizeAboveFull", t)
+	w = dorequestGeneric(id+"/0,0,10,10/20,/0/default.jpg", false, unlimited, iiif.AllFeatures(), t)
+	assert.Equal(-1, w.StatusCode, "upscaling with sizeAboveFull", t)
+}
+
 func TestUnsupportedRequest(t *testing.T) {
 	w := request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/pct:10,10,80,80/full/0/default.jpg", t)
 	assert.Equal(501, w.StatusCode, "Unsupported operation gets reported as a 501 (not implemented)", t)
@@ -190,10 +238,10 @@ func TestCommandHandler(t *testing.T) {
 }
 
 func TestCommandHandlerInvalidSize(t *testing.T) {
-	imgid := "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/pct:10,10,80,80/full/0/default.jpg"
-	areaConstraint := img.Constraint{math.MaxInt32, math.MaxInt32, 480}
-	wConstraint := img.Constraint{20, math.MaxInt32, math.MaxInt64}
-	hConstraint := img.Constraint{math.MaxInt32, 20, math.MaxInt64}
+	imgid := "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/pct:10,10,80,80/640,/0/default.jpg"
+	areaConstraint := img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: 480}
+	wConstraint := img.Constraint{Width: 20, Height: math.MaxInt32, Area: math.MaxInt64}
+	hConstraint := img.Constraint{Width: math.MaxInt32, Height: 20, Area: math.MaxInt64}
 
 	// For sanity let's make sure the request has no errors when we don't specify
 	// any constraints
@@ -208,6 +256,12 @@ func TestCommandHandlerInvalidSize(t *testing.T) {
 	assert.Equal(501, w.StatusCode, "Status code when area is too large", t)
 }
 
+func TestCommandHandlerFullClamped(t *testing.T) {
+	imgid := "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/full/0/default.jpg"
+	w := dorequestl2(imgid, false, img.Constraint{Width: 200, Height: math.MaxInt32, Area: math.MaxInt64}, t)
+	assert.Equal(-1, w.StatusCode, "Full size is clamped to the maximums rather than rejected", t)
+}
+
 // BenchmarkRouting does a benchmark against the routing rules to ensure we
 // aren't creating problems when changing how we interpret the incoming URLs.
 func BenchmarkRouting(b *testing.B) {
@@ -243,3 +297,153 @@ func BenchmarkRouting(b *testing.B) {
 		}
 	}
 }
+
+func TestBuildInfoConfiguredTileSize(t *testing.T) {
+	var ih = NewImageHandler("", "/iiif")
+	var i = ImageInfo{Width: 4000, Height: 3000, TileWidth: 4000, TileHeight: 3000, Levels: 3}
+
+	var info = ih.buildInfo("id", i)
+	assert.Equal(4000, info.Tiles[0].Width, "native tile width", t)
+	assert.Equal(3000, info.Tiles[0].Height, "native tile height", t)
+
+	ih.TileWidth = 512
+	info = ih.buildInfo("id", i)
+	assert.Equal(512, info.Tiles[0].Width, "configured tile width", t)
+	assert.Equal(0, info.Tiles[0].Height, "square tiles omit height", t)
+
+	ih.TileHeight = 256
+	info = ih.buildInfo("id", i)
+	assert.Equal(512, info.Tiles[0].Width, "configured tile width", t)
+	assert.Equal(256, info.Tiles[0].Height, "configured tile height", t)
+}
+
+func TestBuildInfoSizes(t *testing.T) {
+	var ih = NewImageHandler("", "/iiif")
+	var i = ImageInfo{Width: 4001, Height: 3001, TileWidth: 512, TileHeight: 512, Levels: 4}
+
+	var info = ih.buildInfo("id", i)
+	assert.Equal("[{501 376} {1001 751} {2001 1501} {4001 3001}]", fmt.Sprint(info.Sizes), "sizes for each level, smallest first", t)
+
+	ih.Maximums.Width = 3000
+	info = ih.buildInfo("id", i)
+	assert.Equal("[{501 376} {1001 751} {2001 1501}]", fmt.Sprint(info.Sizes), "sizes over the maximum are skipped", t)
+
+	i.Levels = 1
+	info = ih.buildInfo("id", i)
+	assert.Equal(0, len(info.Sizes), "no sizes without resolution levels", t)
+}
+
+func fallbackRequest(path string, status int, t *testing.T) *fakehttp.ResponseWriter {
+	w := fakehttp.NewResponseWriter()
+	req, _ := http.NewRequest("GET", "/iiif/"+path, nil)
+	h := NewImageHandler(rootDir(), "/iiif")
+	h.FallbackImage = rootDir() + "/docker/images/testfile/test-world.jp2"
+	h.FallbackStatus = status
+	h.IIIFRoute(w, req)
+	return w
+}
+
+func TestFallbackImage(t *testing.T) {
+	w := fallbackRequest("identifier/full/200,/0/default.png", 404, t)
+	assert.Equal(404, w.StatusCode, "Missing image with fallback still returns 404", t)
+	assert.Equal("image/png", w.Headers.Get("Content-Type"), "Fallback content type", t)
+	assert.Equal("no-store", w.Headers.Get("Cache-Control"), "Fallback isn't cached", t)
+	assert.True(len(w.Output) > 0, "Fallback image is written", t)
+
+	w = fallbackRequest("Makefile/0,0,10,10/full/0/default.jpg", 200, t)
+	assert.Equal(200, w.StatusCode, "Broken image with fallback returns the configured status", t)
+	assert.Equal("image/jpeg", w.Headers.Get("Content-Type"), "Fallback content type", t)
+
+	w = fallbackRequest("identifier/info.json", 200, t)
+	assert.Equal(404, w.StatusCode, "Info requests never use the fallback", t)
+}
+
+func TestUnsupportedCachedRequest(t *testing.T) {
+	var oldCache = tileCache
+	defer func() { tileCache = oldCache }()
+	viper.Set("TileCachePolicy", "lru")
+	defer viper.Reset()
+	tileCache, _ = newTileCache(10)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/200,/90/default.jpg"
+	var get = func() int {
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	assert.Equal(200, get(), "supported request", t)
+	assert.Equal(1, tileCache.Len(), "request was cached", t)
+
+	var fs = *iiif.FeatureSet2()
+	fs.RotationBy90s = false
+	ih.FeatureSet = &fs
+	assert.Equal(501, get(), "cached request is rejected once its feature is disabled", t)
+}
+
+func TestBandedRender(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.BandPixels = 600 * 50
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/600,/0/default.png"
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(http.StatusOK, w.Code, "banded request", t)
+
+	var cfg, err = png.DecodeConfig(w.Body)
+	assert.NilError(err, "banded output is a valid PNG", t)
+	assert.Equal(600, cfg.Width, "width", t)
+	assert.Equal(300, cfg.Height, "height", t)
+}
+
+func TestJP2Output(t *testing.T) {
+	if !openjpeg.Linked {
+		t.Skip("JP2 encoding requires openjpeg")
+	}
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/100,/0/default.jp2"
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(http.StatusOK, w.Code, "JP2 request", t)
+	assert.Equal("image/jp2", w.Header().Get("Content-Type"), "content type", t)
+	assert.True(bytes.HasPrefix(w.Body.Bytes(), jp2info.JP2HEADER), "JP2 signature", t)
+}
+
+func TestJPEGQualityOverride(t *testing.T) {
+	var oldMin, oldMax = JPEGQualityMin, JPEGQualityMax
+	defer func() { JPEGQualityMin, JPEGQualityMax = oldMin, oldMax }()
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/400,/0/default.jpg"
+	var get = func(query string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, httptest.NewRequest("GET", path+query, nil))
+		return w
+	}
+
+	var def = get("").Body.Len()
+	assert.Equal(def, get("?q=10").Body.Len(), "overrides are ignored unless configured", t)
+
+	JPEGQualityMin, JPEGQualityMax = 20, 90
+	var low = get("?q=20")
+	assert.Equal(http.StatusOK, low.Code, "low quality request", t)
+	assert.True(low.Body.Len() < def, "lower quality means a smaller image", t)
+	assert.Equal(low.Body.Len(), get("?q=5").Body.Len(), "quality is clamped to the minimum", t)
+	assert.Equal(http.StatusBadRequest, get("?q=high").Code, "non-numeric quality", t)
+}
+
+func TestRequestedQuality(t *testing.T) {
+	var oldMin, oldMax = JPEGQualityMin, JPEGQualityMax
+	defer func() { JPEGQualityMin, JPEGQualityMax = oldMin, oldMax }()
+	JPEGQualityMin, JPEGQualityMax = 50, 90
+
+	var tests = map[string]int{"": 0, "?q=60": 60, "?q=99": 90, "?q=1": 50, "?q=80": 0}
+	for query, expected := range tests {
+		var q, err = requestedQuality(httptest.NewRequest("GET", "/"+query, nil), iiif.FmtJPG)
+		assert.NilError(err, "requestedQuality("+query+")", t)
+		assert.Equal(expected, q, "requestedQuality("+query+")", t)
+	}
+
+	var q, _ = requestedQuality(httptest.NewRequest("GET", "/?q=60", nil), iiif.FmtPNG)
+	assert.Equal(0, q, "PNGs ignore quality", t)
+}
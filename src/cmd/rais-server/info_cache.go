@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// infoCacher is the interface all info cache backends must implement
+type infoCacher interface {
+	Get(id iiif.ID) (ImageInfo, bool)
+	Add(id iiif.ID, info ImageInfo)
+	Remove(id iiif.ID)
+	Purge()
+	Len() int
+}
+
+// cachedInfo wraps an ImageInfo with its expiration time.  A zero Expires
+// value means the entry never expires.
+type cachedInfo struct {
+	Info    ImageInfo
+	Expires time.Time
+}
+
+func newCachedInfo(info ImageInfo, ttl time.Duration) cachedInfo {
+	var ci = cachedInfo{Info: info}
+	if ttl > 0 {
+		ci.Expires = time.Now().Add(ttl)
+	}
+	return ci
+}
+
+func (ci cachedInfo) expired() bool {
+	return !ci.Expires.IsZero() && time.Now().After(ci.Expires)
+}
+
+// memoryInfoCache is an in-memory LRU info cache
+type memoryInfoCache struct {
+	lru *lru.Cache
+	ttl time.Duration
+}
+
+func newMemoryInfoCache(size int, ttl time.Duration) (*memoryInfoCache, error) {
+	var c, err = lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryInfoCache{lru: c, ttl: ttl}, nil
+}
+
+// Get implements infoCacher
+func (c *memoryInfoCache) Get(id iiif.ID) (ImageInfo, bool) {
+	var data, ok = c.lru.Get(id)
+	if !ok {
+		return ImageInfo{}, false
+	}
+
+	var ci = data.(cachedInfo)
+	if ci.expired() {
+		c.lru.Remove(id)
+		return ImageInfo{}, false
+	}
+	return ci.Info, true
+}
+
+// Add implements infoCacher
+func (c *memoryInfoCache) Add(id iiif.ID, info ImageInfo) {
+	c.lru.Add(id, newCachedInfo(info, c.ttl))
+}
+
+// Remove implements infoCacher
+func (c *memoryInfoCache) Remove(id iiif.ID) {
+	c.lru.Remove(id)
+}
+
+// Purge implements infoCacher
+func (c *memoryInfoCache) Purge() {
+	c.lru.Purge()
+}
+
+// Len implements infoCacher
+func (c *memoryInfoCache) Len() int {
+	return c.lru.Len()
+}
+
+// diskInfoCache stores info data as JSON files in a directory, allowing the
+// cache to survive restarts and be shared by multiple RAIS instances.  Once
+// there are more than size files, the oldest are removed.  The count is kept
+// in memory, and resynchronized with the directory whenever files are
+// evicted, since other instances may be adding files as well.
+type diskInfoCache struct {
+	dir   string
+	ttl   time.Duration
+	size  int
+	m     sync.Mutex
+	count int
+}
+
+func newDiskInfoCache(dir string, size int, ttl time.Duration) (*diskInfoCache, error) {
+	var err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create info cache directory %q: %s", dir, err)
+	}
+	var c = &diskInfoCache{dir: dir, ttl: ttl, size: size}
+	c.count = len(c.files())
+	return c, nil
+}
+
+func (c *diskInfoCache) path(id iiif.ID) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(id))))
+}
+
+// Get implements infoCacher
+func (c *diskInfoCache) Get(id iiif.ID) (ImageInfo, bool) {
+	var data, err = ioutil.ReadFile(c.path(id))
+	if err != nil {
+		return ImageInfo{}, false
+	}
+
+	var ci cachedInfo
+	err = json.Unmarshal(data, &ci)
+	if err != nil {
+		Logger.Warnf("Removing invalid info cache file for %q: %s", id, err)
+		c.Remove(id)
+		return ImageInfo{}, false
+	}
+	if ci.expired() {
+		c.Remove(id)
+		return ImageInfo{}, false
+	}
+
+	return ci.Info, true
+}
+
+// Add implements infoCacher.  The data is written to a temporary file and
+// renamed so concurrent readers never see a partial file.
+func (c *diskInfoCache) Add(id iiif.ID, info ImageInfo) {
+	var data, err = json.Marshal(newCachedInfo(info, c.ttl))
+	if err != nil {
+		Logger.Errorf("Unable to serialize info for %q: %s", id, err)
+		return
+	}
+
+	var f *os.File
+	f, err = ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		Logger.Errorf("Unable to create info cache file for %q: %s", id, err)
+		return
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	var _, statErr = os.Stat(c.path(id))
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		Logger.Errorf("Unable to write info cache file for %q: %s", id, err)
+		return
+	}
+
+	if os.IsNotExist(statErr) {
+		c.m.Lock()
+		c.count++
+		if c.count > c.size {
+			c.evict()
+		}
+		c.m.Unlock()
+	}
+}
+
+// evict removes the oldest cache files until the cache is down to 90% of its
+// size, so we aren't scanning the directory on every Add once it's full.  The
+// caller must hold the lock.
+func (c *diskInfoCache) evict() {
+	var list = c.files()
+	sort.Slice(list, func(i, j int) bool { return list[i].ModTime().Before(list[j].ModTime()) })
+
+	var keep = c.size - c.size/10
+	for len(list) > keep {
+		os.Remove(filepath.Join(c.dir, list[0].Name()))
+		list = list[1:]
+	}
+	c.count = len(list)
+}
+
+// Remove implements infoCacher
+func (c *diskInfoCache) Remove(id iiif.ID) {
+	var err = os.Remove(c.path(id))
+	if err == nil {
+		c.m.Lock()
+		c.count--
+		c.m.Unlock()
+		return
+	}
+	if !os.IsNotExist(err) {
+		Logger.Errorf("Unable to remove info cache file for %q: %s", id, err)
+	}
+}
+
+// files returns the cache files in the cache directory
+func (c *diskInfoCache) files() []os.FileInfo {
+	var infos, err = ioutil.ReadDir(c.dir)
+	if err != nil {
+		Logger.Errorf("Unable to read info cache directory %q: %s", c.dir, err)
+		return nil
+	}
+
+	var list []os.FileInfo
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			list = append(list, info)
+		}
+	}
+	return list
+}
+
+// Purge implements infoCacher
+func (c *diskInfoCache) Purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, f := range c.files() {
+		os.Remove(filepath.Join(c.dir, f.Name()))
+	}
+	c.count = 0
+}
+
+// Len implements infoCacher.  This is the number of files this instance
+// knows of, which may be off if other instances share the directory.
+func (c *diskInfoCache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.count
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func testInfoCacher(c infoCacher, t *testing.T) {
+	var id = iiif.ID("path/to/image.jp2")
+	var _, ok = c.Get(id)
+	assert.False(ok, "empty cache has no data", t)
+
+	c.Add(id, ImageInfo{Width: 800, Height: 400})
+	var info ImageInfo
+	info, ok = c.Get(id)
+	assert.True(ok, "cache has data after Add", t)
+	assert.Equal(800, info.Width, "cached width", t)
+	assert.Equal(1, c.Len(), "cache length", t)
+
+	c.Remove(id)
+	_, ok = c.Get(id)
+	assert.False(ok, "cache has no data after Remove", t)
+
+	c.Add(id, ImageInfo{Width: 800, Height: 400})
+	c.Purge()
+	assert.Equal(0, c.Len(), "cache length after Purge", t)
+}
+
+func testInfoCacherTTL(c infoCacher, t *testing.T) {
+	var id = iiif.ID("expiring")
+	c.Add(id, ImageInfo{Width: 10})
+	var _, ok = c.Get(id)
+	assert.True(ok, "data is cached before TTL", t)
+
+	time.Sleep(time.Millisecond * 20)
+	_, ok = c.Get(id)
+	assert.False(ok, "data is expired after TTL", t)
+}
+
+func TestMemoryInfoCache(t *testing.T) {
+	var c, _ = newMemoryInfoCache(10, 0)
+	testInfoCacher(c, t)
+	c, _ = newMemoryInfoCache(10, time.Millisecond*10)
+	testInfoCacherTTL(c, t)
+}
+
+func TestDiskInfoCache(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-info-cache")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var c *diskInfoCache
+	c, _ = newDiskInfoCache(dir, 10, 0)
+	testInfoCacher(c, t)
+	c, _ = newDiskInfoCache(dir, 10, time.Millisecond*10)
+	testInfoCacherTTL(c, t)
+
+	c, _ = newDiskInfoCache(dir, 10, 0)
+	c.Purge()
+	var old = time.Now().Add(-time.Hour)
+	c.Add("oldest", ImageInfo{Width: 1})
+	os.Chtimes(c.path("oldest"), old, old)
+	for i := 0; i < 10; i++ {
+		var id = iiif.ID(fmt.Sprintf("image-%d", i))
+		c.Add(id, ImageInfo{Width: 1})
+		if i < 9 {
+			var mod = old.Add(time.Minute * time.Duration(i+1))
+			os.Chtimes(c.path(id), mod, mod)
+		}
+	}
+	assert.Equal(9, c.Len(), "cache is trimmed to 90% once it's over its size", t)
+	var _, ok = c.Get("oldest")
+	assert.False(ok, "oldest entry is evicted", t)
+	_, ok = c.Get("image-0")
+	assert.False(ok, "next oldest entry is evicted", t)
+	_, ok = c.Get("image-9")
+	assert.True(ok, "newest entry is kept", t)
+
+	c, _ = newDiskInfoCache(dir, 10, 0)
+	assert.Equal(9, c.Len(), "existing files are counted at startup", t)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+)
+
+// infoContexts maps the Image API versions we can serialize info.json for to
+// their JSON-LD contexts
+var infoContexts = map[int]string{
+	2: iiif.Info2Context,
+	3: iiif.Info3Context,
+}
+
+// parseInfoVersions reads a comma-separated list of Image API versions ("2",
+// "3", or both), in order of preference
+func parseInfoVersions(val string) ([]int, error) {
+	var versions []int
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		var v, err = strconv.Atoi(s)
+		if err != nil || infoContexts[v] == "" {
+			return nil, fmt.Errorf("unsupported Image API version %q", s)
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// acceptedProfiles returns the "profile" parameters of the request's Accept
+// header, in the order they appear
+func acceptedProfiles(req *http.Request) []string {
+	var profiles []string
+	for _, h := range req.Header["Accept"] {
+		for _, accept := range strings.Split(h, ",") {
+			var params = strings.Split(accept, ";")
+			for _, param := range params[1:] {
+				var kv = strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "profile") {
+					profiles = append(profiles, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	return profiles
+}
+
+// requestedInfoVersion returns the Image API version whose context the client
+// names first as an Accept profile, or 0 if it doesn't name one.  Unlike
+// infoVersion, this ignores which versions RAIS serves, since proxied
+// requests are negotiated by the upstream server.
+func requestedInfoVersion(req *http.Request) int {
+	for _, profile := range acceptedProfiles(req) {
+		for v, context := range infoContexts {
+			if profile == context {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// infoVersion picks the Image API version of an info response: the first
+// enabled version whose context the client names as an Accept profile, or
+// else the server's preferred version
+func (ih *ImageHandler) infoVersion(req *http.Request) int {
+	if len(ih.InfoVersions) == 0 {
+		return 2
+	}
+
+	for _, profile := range acceptedProfiles(req) {
+		for _, v := range ih.InfoVersions {
+			if profile == infoContexts[v] {
+				return v
+			}
+		}
+	}
+	return ih.InfoVersions[0]
+}
+
+// infoContentType returns the Content-Type for an info response of the given
+// version.  JSON-LD is only used when the client asks for it and ld (the
+// jsonldMediaType feature) is set, and 3.0 responses then name their context
+// as the profile.
+func infoContentType(req *http.Request, version int, ld bool) string {
+	if !ld || !acceptsLD(req) {
+		return "application/json"
+	}
+	if version == 3 {
+		return fmt.Sprintf(`application/ld+json;profile="%s"`, iiif.Info3Context)
+	}
+	return "application/ld+json"
+}
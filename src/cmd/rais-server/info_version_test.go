@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseInfoVersions(t *testing.T) {
+	var v, err = parseInfoVersions(" 3, 2 ")
+	assert.NilError(err, "valid versions", t)
+	assert.Equal(2, len(v), "two versions", t)
+	assert.Equal(3, v[0], "preferred version first", t)
+
+	_, err = parseInfoVersions("2,4")
+	assert.True(err != nil, "unknown versions are an error", t)
+}
+
+func TestInfoNegotiation(t *testing.T) {
+	// Each version's document is cached separately, so the cache mustn't
+	// serve one version to a client asking for the other
+	var oldDocs = infoDocCache
+	defer func() { infoDocCache = oldDocs }()
+	infoDocCache, _ = lru.New(10)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.FeatureSet = iiif.FeatureSet2()
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json"
+	var get = func(accept string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var req = httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, req)
+		var data map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &data)
+		return w, data
+	}
+	var v3 = `application/ld+json;profile="` + iiif.Info3Context + `"`
+
+	var w, data = get(v3)
+	assert.Equal(iiif.Info2Context, data["@context"], "only 2.x is enabled by default", t)
+	assert.Equal("", w.Header().Get("Vary"), "no Vary header with one version", t)
+
+	ih.InfoVersions = []int{2, 3}
+	w, data = get(v3)
+	assert.Equal(iiif.Info3Context, data["@context"], "3.0 profile gets a 3.0 response", t)
+	assert.Equal("level2", data["profile"], "3.0 profile", t)
+	assert.Equal("ImageService3", data["type"], "3.0 type", t)
+	assert.Equal(v3, w.Header().Get("Content-Type"), "3.0 JSON-LD content type", t)
+	assert.Equal("Accept", w.Header().Get("Vary"), "Vary header with both versions", t)
+
+	w, data = get("application/json")
+	assert.Equal(iiif.Info2Context, data["@context"], "preferred version without a profile", t)
+	assert.Equal("application/json", w.Header().Get("Content-Type"), "plain JSON", t)
+
+	ih.InfoVersions = []int{3, 2}
+	_, data = get(`application/ld+json;profile="` + iiif.Info2Context + `"`)
+	assert.Equal(iiif.Info2Context, data["@context"], "2.x profile gets a 2.x response", t)
+	_, data = get("")
+	assert.Equal(iiif.Info3Context, data["@context"], "preferred version with no Accept header", t)
+	assert.Equal(2, infoDocCache.Len(), "one cached document per version", t)
+}
@@ -4,6 +4,7 @@
 	"context"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,6 +21,17 @@ type Server struct {
 	Name       string
 	Mux        *mux.Router
 	middleware []func(http.Handler) http.Handler
+	active     int64
+}
+
+// ShutdownReport describes how a single server's shutdown went: how many
+// requests were being handled when shutdown began, and how many were still
+// running (and therefore cut off) when the shutdown deadline passed
+type ShutdownReport struct {
+	Name     string
+	InFlight int64
+	CutOff   int64
+	Err      error
 }
 
 // NewServer registers a named server at the given bind address.  If the
@@ -40,9 +52,9 @@ func New(name, addr string) *Server {
 			ReadTimeout:  5 * time.Second,
 			WriteTimeout: 30 * time.Second,
 			Addr:         addr,
-			Handler:      mux,
 		},
 	}
+	s.Server.Handler = s.track(mux)
 
 	servers[addr] = s
 	return s
@@ -76,6 +88,15 @@ func (s *Server) HandlePrefix(prefix string, handler http.Handler) {
 	s.Mux.PathPrefix(prefix).Handler(s.wrapMiddleware(handler))
 }
 
+// track wraps the handler in order to count in-flight requests
+func (s *Server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.active, 1)
+		defer atomic.AddInt64(&s.active, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // run wraps http.Server's ListenAndServe in a background-friendly way, sending
 // any errors to the "done" callback when the server closes
 func (s *Server) run(done func(*Server, error)) {
@@ -86,10 +107,34 @@ func (s *Server) run(done func(*Server, error)) {
 	done(s, err)
 }
 
-// Shutdown stops all registered servers
-func Shutdown(ctx context.Context) {
+// Shutdown stops all registered servers, letting in-flight requests finish
+// until ctx is done.  Any connections still open at that point are closed.
+// A report is returned for each server.
+func Shutdown(ctx context.Context) []ShutdownReport {
+	var reports = make([]ShutdownReport, len(servers))
+	var wg sync.WaitGroup
+	var i int
 	for _, s := range servers {
-		s.Shutdown(ctx)
+		wg.Add(1)
+		go func(s *Server, r *ShutdownReport) {
+			s.drain(ctx, r)
+			wg.Done()
+		}(s, &reports[i])
+		i++
+	}
+	wg.Wait()
+
+	return reports
+}
+
+// drain shuts down a single server, filling in the report as it goes
+func (s *Server) drain(ctx context.Context, r *ShutdownReport) {
+	r.Name = s.Name
+	r.InFlight = atomic.LoadInt64(&s.active)
+	r.Err = s.Server.Shutdown(ctx)
+	if r.Err != nil {
+		r.CutOff = atomic.LoadInt64(&s.active)
+		s.Server.Close()
 	}
 }
 
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"rais/src/iiif"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobPath is the public server path prefix for asynchronous render jobs: a
+// job's status is at JobPath+"<id>", and its image at JobPath+"<id>/result"
+const JobPath = "/jobs/"
+
+// jobExpireInterval is how often finished jobs are checked for expiration
+const jobExpireInterval = time.Minute
+
+// Job states
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// jobs is set up when asynchronous rendering is enabled
+var jobs *jobQueue
+
+// renderJob is a single asynchronous render.  The exported fields are what
+// clients see when polling the job.
+type renderJob struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Result string `json:"result,omitempty"`
+
+	path     string
+	format   iiif.Format
+	filename string
+	file     string
+	finished time.Time
+}
+
+// queuedJob is a job waiting for a worker, along with its render function
+type queuedJob struct {
+	j  *renderJob
+	fn func() ([]byte, *HandlerError)
+}
+
+// jobQueue tracks asynchronous render jobs.  Jobs are run in the background
+// by a fixed number of workers, and their output is written to temporary
+// files which are removed once the job has been finished for the configured
+// TTL.  At most queueLen jobs can wait for a worker; beyond that, new jobs are
+// refused rather than piling up.
+type jobQueue struct {
+	m       sync.Mutex
+	jobs    map[string]*renderJob
+	byPath  map[string]*renderJob
+	pending chan queuedJob
+	minArea int64
+	ttl     time.Duration
+	dir     string
+}
+
+func newJobQueue(workers, queueLen int, minArea int64, ttl time.Duration, dir string) *jobQueue {
+	var jq = &jobQueue{
+		jobs:    make(map[string]*renderJob),
+		byPath:  make(map[string]*renderJob),
+		pending: make(chan queuedJob, queueLen),
+		minArea: minArea,
+		ttl:     ttl,
+		dir:     dir,
+	}
+	for i := 0; i < workers; i++ {
+		go jq.work()
+	}
+	return jq
+}
+
+// prefersAsync returns true if the request has a "Prefer: respond-async"
+// header (RFC 7240)
+func prefersAsync(req *http.Request) bool {
+	for _, val := range req.Header["Prefer"] {
+		for _, pref := range strings.Split(val, ",") {
+			var token = strings.TrimSpace(strings.SplitN(pref, ";", 2)[0])
+			if strings.EqualFold(token, "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wants returns true if the request should be run as a job: the client asked
+// for an asynchronous response and the output is at least the minimum size.
+// HEAD requests never decode, so they're always answered directly.
+func (jq *jobQueue) wants(req *http.Request, scale image.Rectangle) bool {
+	if jq == nil || req.Method == http.MethodHead {
+		return false
+	}
+	return int64(scale.Dx())*int64(scale.Dy()) >= jq.minArea && prefersAsync(req)
+}
+
+// newJobID returns a random, unguessable job ID
+func newJobID() string {
+	var b = make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submit queues a job for the given path unless one is already queued,
+// running, or finished successfully, in which case that job is returned.  If
+// filename is set, the job's output is served as a download with that name.
+// The return is false if the queue is full and the job wasn't started.
+func (jq *jobQueue) submit(path string, format iiif.Format, filename string, fn func() ([]byte, *HandlerError)) (renderJob, bool) {
+	jq.m.Lock()
+	defer jq.m.Unlock()
+
+	if j := jq.byPath[path]; j != nil && j.Status != jobFailed {
+		return *j, true
+	}
+
+	var j = &renderJob{ID: newJobID(), Status: jobQueued, path: path, format: format, filename: filename}
+	select {
+	case jq.pending <- queuedJob{j, fn}:
+	default:
+		return renderJob{}, false
+	}
+	jq.jobs[j.ID] = j
+	jq.byPath[path] = j
+	return *j, true
+}
+
+// work runs queued jobs one at a time, forever
+func (jq *jobQueue) work() {
+	for qj := range jq.pending {
+		jq.run(qj.j, qj.fn)
+	}
+}
+
+// run renders the job and saves its output
+func (jq *jobQueue) run(j *renderJob, fn func() ([]byte, *HandlerError)) {
+	jq.setStatus(j, jobRunning, "", "")
+	var data, e = fn()
+
+	if e != nil {
+		jq.setStatus(j, jobFailed, e.Message, "")
+		return
+	}
+
+	var f, err = ioutil.TempFile(jq.dir, "rais-job-")
+	if err == nil {
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		Logger.Errorf("Unable to save job %s for %q: %s", j.ID, j.path, err)
+		jq.setStatus(j, jobFailed, "unable to save result", "")
+		return
+	}
+	jq.setStatus(j, jobDone, "", f.Name())
+}
+
+// setStatus updates the job's state, recording the finish time for jobs which
+// are done or failed
+func (jq *jobQueue) setStatus(j *renderJob, status, errMsg, file string) {
+	jq.m.Lock()
+	defer jq.m.Unlock()
+
+	j.Status = status
+	j.Error = errMsg
+	j.file = file
+	if status == jobDone {
+		j.Result = JobPath + j.ID + "/result"
+	}
+	if status == jobDone || status == jobFailed {
+		j.finished = time.Now()
+	}
+}
+
+// get returns a copy of the job with the given ID
+func (jq *jobQueue) get(id string) (renderJob, bool) {
+	jq.m.Lock()
+	defer jq.m.Unlock()
+
+	var j, ok = jq.jobs[id]
+	if !ok {
+		return renderJob{}, false
+	}
+	return *j, true
+}
+
+// queued returns the number of jobs waiting to run
+func (jq *jobQueue) queued() int {
+	if jq == nil {
+		return 0
+	}
+	jq.m.Lock()
+	defer jq.m.Unlock()
+
+	var n int
+	for _, j := range jq.jobs {
+		if j.Status == jobQueued {
+			n++
+		}
+	}
+	return n
+}
+
+// expire forgets jobs which finished more than the TTL before now, removing
+// their output
+func (jq *jobQueue) expire(now time.Time) {
+	jq.m.Lock()
+	defer jq.m.Unlock()
+
+	for id, j := range jq.jobs {
+		if j.finished.IsZero() || now.Sub(j.finished) < jq.ttl {
+			continue
+		}
+		if j.file != "" {
+			os.Remove(j.file)
+		}
+		delete(jq.jobs, id)
+		if jq.byPath[j.path] == j {
+			delete(jq.byPath, j.path)
+		}
+	}
+}
+
+// watch expires old jobs until the server shuts down
+func (jq *jobQueue) watch() {
+	for now := range time.Tick(jobExpireInterval) {
+		jq.expire(now)
+	}
+}
+
+// cleanup removes all finished jobs' output
+func (jq *jobQueue) cleanup() {
+	jq.m.Lock()
+	defer jq.m.Unlock()
+
+	for _, j := range jq.jobs {
+		if j.file != "" {
+			os.Remove(j.file)
+		}
+	}
+}
+
+// sendJob writes the job's state as JSON with the given status code.  Jobs
+// which aren't finished tell the client when to poll again.
+func sendJob(w http.ResponseWriter, j renderJob, code int) {
+	var data, _ = json.Marshal(j)
+	if j.Status == jobQueued || j.Status == jobRunning {
+		w.Header().Set("Retry-After", "5")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// accepted responds to a request which has been turned into a job
+func (jq *jobQueue) accepted(w http.ResponseWriter, j renderJob) {
+	w.Header().Set("Location", JobPath+j.ID)
+	sendJob(w, j, http.StatusAccepted)
+}
+
+// full responds to a request which couldn't be turned into a job because the
+// queue is full
+func (jq *jobQueue) full(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Too many jobs are waiting; try again later", http.StatusServiceUnavailable)
+}
+
+// JobRoute reports a job's status, or serves its output once it's done
+func (jq *jobQueue) JobRoute(w http.ResponseWriter, req *http.Request) {
+	var path = strings.TrimPrefix(req.URL.Path, JobPath)
+	var id = strings.TrimSuffix(path, "/result")
+	var j, ok = jq.get(id)
+	if !ok {
+		http.Error(w, "No such job", http.StatusNotFound)
+		return
+	}
+
+	if id == path {
+		sendJob(w, j, http.StatusOK)
+		return
+	}
+
+	if j.Status != jobDone {
+		http.Error(w, "Job is "+j.Status, http.StatusConflict)
+		return
+	}
+	var f, err = os.Open(j.file)
+	if err != nil {
+		Logger.Errorf("Unable to open output for job %s: %s", j.ID, err)
+		http.Error(w, "Unable to read job output", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension("."+string(j.format)))
+	if j.filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, j.filename))
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeContent(w, req, "", j.finished, f)
+}
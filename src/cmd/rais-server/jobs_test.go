@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestPrefersAsync(t *testing.T) {
+	var req, _ = http.NewRequest("GET", "/", nil)
+	assert.False(prefersAsync(req), "no Prefer header", t)
+	req.Header.Set("Prefer", "return=minimal, Respond-Async; wait=10")
+	assert.True(prefersAsync(req), "respond-async among other preferences", t)
+	req.Header.Set("Prefer", "respond-asynchronously")
+	assert.False(prefersAsync(req), "only the exact token counts", t)
+}
+
+func TestJobs(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-jobs")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	defer func() { jobs = nil }()
+	jobs = newJobQueue(1, 10, 10000, time.Hour, dir)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/full/0/default.jpg"
+	var get = func(path string, async bool) *httptest.ResponseRecorder {
+		var req = httptest.NewRequest("GET", path, nil)
+		if async {
+			req.Header.Set("Prefer", "respond-async")
+		}
+		var w = httptest.NewRecorder()
+		ih.IIIFRoute(w, req)
+		return w
+	}
+
+	var w = get(path, false)
+	assert.Equal(200, w.Code, "requests without a preference are synchronous", t)
+	w = get("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/50,/0/default.jpg", true)
+	assert.Equal(200, w.Code, "small requests are synchronous", t)
+
+	var head = httptest.NewRequest("HEAD", path, nil)
+	head.Header.Set("Prefer", "respond-async")
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, head)
+	assert.Equal(200, w.Code, "async HEAD requests are answered directly", t)
+	assert.Equal(0, len(jobs.byPath), "async HEAD requests don't start a job", t)
+
+	w = get(path, true)
+	assert.Equal(http.StatusAccepted, w.Code, "large async request starts a job", t)
+	var j renderJob
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &j), "job JSON", t)
+	assert.Equal(JobPath+j.ID, w.Header().Get("Location"), "Location points to the job", t)
+
+	var jw = get(path, true)
+	var j2 renderJob
+	json.Unmarshal(jw.Body.Bytes(), &j2)
+	assert.Equal(j.ID, j2.ID, "identical requests share a job", t)
+
+	var poll = func(path string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		jobs.JobRoute(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	for i := 0; i < 100 && j.Status != jobDone; i++ {
+		time.Sleep(10 * time.Millisecond)
+		w = poll(JobPath + j.ID)
+		json.Unmarshal(w.Body.Bytes(), &j)
+	}
+	assert.Equal(jobDone, j.Status, "job finishes", t)
+	assert.Equal(JobPath+j.ID+"/result", j.Result, "finished job links to its result", t)
+
+	w = poll(j.Result)
+	assert.Equal(200, w.Code, "result is served", t)
+	assert.Equal("image/jpeg", w.Header().Get("Content-Type"), "result content type", t)
+	assert.True(w.Body.Len() > 0, "result has data", t)
+
+	assert.Equal(404, poll(JobPath+"bogus").Code, "unknown job", t)
+
+	jobs.expire(time.Now().Add(2 * time.Hour))
+	assert.Equal(404, poll(JobPath+j.ID).Code, "expired job is forgotten", t)
+	var files, _ = ioutil.ReadDir(dir)
+	assert.Equal(0, len(files), "expired job's output is removed", t)
+}
+
+func TestJobQueueFull(t *testing.T) {
+	// No workers, so queued jobs stay queued
+	var jq = newJobQueue(0, 1, 1, time.Hour, "")
+	var fn = func() ([]byte, *HandlerError) { return nil, nil }
+
+	var j, ok = jq.submit("a", "jpg", "", fn)
+	assert.True(ok, "first job is queued", t)
+	var j2 renderJob
+	j2, ok = jq.submit("a", "jpg", "", fn)
+	assert.True(ok, "an identical job is shared even when the queue is full", t)
+	assert.Equal(j.ID, j2.ID, "shared job", t)
+	_, ok = jq.submit("b", "jpg", "", fn)
+	assert.False(ok, "new jobs are refused when the queue is full", t)
+	assert.Equal(1, len(jq.jobs), "refused jobs aren't tracked", t)
+
+	var w = httptest.NewRecorder()
+	jq.full(w)
+	assert.Equal(http.StatusServiceUnavailable, w.Code, "full queue status", t)
+	assert.True(w.Header().Get("Retry-After") != "", "full queue asks clients to retry", t)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"rais/src/img"
+	"strings"
+)
+
+// jsonError is the body of an error response for IIIF requests.  Reason is a
+// stable, machine-readable token viewers can act on; Message is for people.
+type jsonError struct {
+	Status  int    `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// errorReasons maps status codes to their default reason
+var errorReasons = map[int]string{
+	http.StatusBadRequest:          "invalidRequest",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "notFound",
+	http.StatusMethodNotAllowed:    "methodNotAllowed",
+	http.StatusTooManyRequests:     "tooManyRequests",
+	http.StatusInternalServerError: "serverError",
+	http.StatusNotImplemented:      "notImplemented",
+	http.StatusBadGateway:          "upstreamError",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// messageReasons refines the reason for errors which have a more specific
+// cause than their status code conveys
+var messageReasons = []struct {
+	message string
+	reason  string
+}{
+	{img.ErrDimensionsExceedLimits.Error(), "sizeExceedsLimits"},
+	{img.ErrRegionOutsideImage.Error(), "regionOutsideImage"},
+	{img.ErrSizeTooSmall.Error(), "sizeTooSmall"},
+	{"upscaling isn't supported", "upscalingNotSupported"},
+	{"Feature not supported", "featureNotSupported"},
+}
+
+// errorReason returns the machine-readable reason for an error
+func errorReason(code int, message string) string {
+	for _, mr := range messageReasons {
+		if strings.Contains(message, mr.message) {
+			return mr.reason
+		}
+	}
+	if r, ok := errorReasons[code]; ok {
+		return r
+	}
+	return "error"
+}
+
+// jsonErrorMiddleware returns middleware which replaces plain text error
+// responses for paths under prefix with JSON bodies
+func jsonErrorMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !strings.HasPrefix(req.URL.Path, prefix) {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			var ew = &errorWriter{ResponseWriter: w}
+			next.ServeHTTP(ew, req)
+			if !ew.intercepted {
+				return
+			}
+
+			var message = strings.TrimSpace(ew.body.String())
+			var data, _ = json.Marshal(jsonError{
+				Status:  ew.status,
+				Reason:  errorReason(ew.status, message),
+				Message: message,
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.WriteHeader(ew.status)
+			w.Write(data)
+		})
+	}
+}
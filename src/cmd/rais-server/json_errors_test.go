@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestJSONErrors(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.FeatureSet = iiif.FeatureSet1()
+	var h = jsonErrorMiddleware("/iiif/")(http.HandlerFunc(ih.IIIFRoute))
+	var get = func(path string) (*httptest.ResponseRecorder, jsonError) {
+		var w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var je jsonError
+		json.Unmarshal(w.Body.Bytes(), &je)
+		return w, je
+	}
+
+	var w, je = get("/iiif/missing.jp2/info.json")
+	assert.Equal(404, w.Code, "missing image status", t)
+	assert.Equal("application/json", w.Header().Get("Content-Type"), "content type", t)
+	assert.Equal(jsonError{Status: 404, Reason: "notFound", Message: je.Message}, je, "missing image body", t)
+	assert.True(je.Message != "", "message is kept", t)
+
+	var base = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	_, je = get(base + "/900,0,10,10/max/0/default.jpg")
+	assert.Equal("regionOutsideImage", je.Reason, "specific reason", t)
+	_, je = get(base + "/full/max/0/default.png")
+	assert.Equal("featureNotSupported", je.Reason, "unsupported feature", t)
+
+	w, _ = get(base + "/info.json")
+	assert.Equal(200, w.Code, "successful responses are untouched", t)
+	assert.Equal("error", errorReason(418, "teapot"), "unknown status", t)
+}
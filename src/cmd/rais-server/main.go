@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/url"
+	"rais/src/chaos"
 	"rais/src/cmd/rais-server/internal/servers"
 	"rais/src/iiif"
 	"rais/src/img"
 	"rais/src/openjpeg"
 	"rais/src/plugins"
 	"rais/src/version"
+	"rais/src/vips"
 	"strings"
 	"sync"
 	"time"
@@ -32,8 +35,35 @@ func main() {
 	parseConf()
 	Logger = logger.New(logger.LogLevelFromString(viper.GetString("LogLevel")))
 	openjpeg.Logger = Logger
+	openjpeg.HeaderCacheTTL = viper.GetDuration("HeaderCacheTTL")
+
+	var remoteBlockCacheLen = viper.GetInt("RemoteBlockCacheLen")
+	if remoteBlockCacheLen < 1 {
+		Logger.Fatalf("RemoteBlockCacheLen must be at least 1")
+	}
+	setupRemoteSources(remoteBlockCacheLen, openjpeg.HeaderCacheTTL)
 
 	setupCaches()
+	var memLimit = viper.GetInt64("MemoryLimit")
+	if memLimit > 0 {
+		memMonitor = newMemoryMonitor(uint64(memLimit))
+	}
+
+	// Tiled TIFFs are registered before plugins so they're read a tile at a
+	// time rather than decoded in full by the ImageMagick plugin.  JPEGs, PNGs,
+	// and GIFs are decoded natively too, leaving ImageMagick only the formats
+	// and variants Go can't read.  With the libvips backend on, it takes the
+	// local files it reads before any of those.
+	VipsBackend = viper.GetBool("VipsBackend")
+	if VipsBackend {
+		if !vips.Enabled {
+			Logger.Fatalf("VipsBackend requires RAIS to be built with libvips support (make TAGS=vips)")
+		}
+		Logger.Infof("Using libvips to decode and resize TIFF, JPEG, and PNG files, and to encode JPEGs")
+		img.RegisterStreamDecoder("vips", decodeVips)
+	}
+	img.RegisterStreamDecoder("ptiff", decodeTIFF)
+	img.RegisterStreamDecoder("stdimage", decodeStdImage)
 
 	var pluginList string
 
@@ -47,13 +77,26 @@ func main() {
 	} else {
 		LoadPlugins(Logger, strings.Split(pluginList, ","))
 	}
+	var acl = viper.GetInt("AuthCacheLen")
+	if len(authPlugins) > 0 && acl > 0 {
+		Logger.Debugf("Caching up to %d authorization decisions", acl)
+		setupAuthCache(acl, viper.GetDuration("AuthCacheTTL"))
+		stats.AuthCache.Enabled = true
+	}
 
 	// Register our JP2 decoder after plugins have been loaded to allow plugins
 	// to handle images - for instance, we might want a pyramidal tiff plugin or
 	// something one day
-	img.RegisterDecoder(decodeJP2)
+	if !openjpeg.Linked {
+		Logger.Warnf("RAIS was built without openjpeg: JP2s will decode slowly, and can't be encoded")
+	}
+	img.RegisterStreamDecoder("openjpeg", decodeJP2)
 
+	// A tile path is only optional if something else can find images
 	tilePath := viper.GetString("TilePath")
+	if tilePath == "" && len(idToPathPlugins) == 0 && viper.GetString("UpstreamURL") == "" {
+		Logger.Fatalf("A tile path is required unless an IDToPath plugin or an upstream IIIF URL is in use")
+	}
 	webPath := viper.GetString("IIIFWebPath")
 	if webPath == "" {
 		webPath = "/iiif"
@@ -65,6 +108,193 @@ func main() {
 	ih.Maximums.Area = viper.GetInt64("ImageMaxArea")
 	ih.Maximums.Width = viper.GetInt("ImageMaxWidth")
 	ih.Maximums.Height = viper.GetInt("ImageMaxHeight")
+	ih.Maximums.MaxUpscale = viper.GetFloat64("ImageMaxUpscale")
+	if ih.Maximums.MaxUpscale < 0 {
+		Logger.Fatalf("ImageMaxUpscale must not be negative")
+	}
+
+	var threshold = viper.GetInt("BitonalThreshold")
+	if threshold < 0 || threshold > 255 {
+		Logger.Fatalf("BitonalThreshold must be between 0 and 255")
+	}
+	img.BitonalThreshold = uint8(threshold)
+	img.BitonalDither = viper.GetBool("BitonalDither")
+	img.PreserveDepth = viper.GetBool("PreserveBitDepth")
+
+	JPEGQuality = viper.GetInt("JPEGQuality")
+	if JPEGQuality < 1 || JPEGQuality > 100 {
+		Logger.Fatalf("JPEGQuality must be between 1 and 100")
+	}
+	JPEGQualityMin = viper.GetInt("JPEGQualityMin")
+	JPEGQualityMax = viper.GetInt("JPEGQualityMax")
+	if JPEGQualityMax != 0 && (JPEGQualityMin < 1 || JPEGQualityMin > JPEGQualityMax || JPEGQualityMax > 100) {
+		Logger.Fatalf("JPEGQualityMin and JPEGQualityMax must be between 1 and 100, and the minimum can't exceed the maximum")
+	}
+
+	var pngLevel, ok = pngCompressionLevels[viper.GetString("PNGCompression")]
+	if !ok {
+		Logger.Fatalf("PNGCompression must be one of default, none, fast, or best")
+	}
+	PNGCompression = pngLevel
+	PNGPalette = viper.GetBool("PNGPalette")
+	PNGGrayLevels = viper.GetInt("PNGGrayLevels")
+	if PNGGrayLevels < 2 || PNGGrayLevels > 256 {
+		Logger.Fatalf("PNGGrayLevels must be between 2 and 256")
+	}
+
+	var webpQuality = viper.GetFloat64("WebPQuality")
+	if webpQuality < 0 || webpQuality > 100 {
+		Logger.Fatalf("WebPQuality must be between 0 and 100")
+	}
+	WebPQuality = float32(webpQuality)
+
+	TIFFCompression, ok = tiffCompressions[viper.GetString("TIFFCompression")]
+	if !ok {
+		Logger.Fatalf("TIFFCompression must be one of none, lzw, or deflate")
+	}
+	TIFFPredictor = viper.GetBool("TIFFPredictor")
+
+	ProgressiveJPEG = viper.GetBool("ProgressiveJPEG")
+
+	VerifyEncodes = viper.GetBool("VerifyEncodes")
+	if VerifyEncodes {
+		Logger.Warnf("Encoder output verification is on; every render is decoded back and checked")
+		setupVerifiedSums()
+	}
+
+	ih.BandPixels = viper.GetInt64("BandPixels")
+	if ih.BandPixels < 0 {
+		Logger.Fatalf("BandPixels must not be negative")
+	}
+
+	ih.EmbedDPI = viper.GetBool("EmbedDPI")
+	ih.DefaultCaptureDPI = viper.GetFloat64("DefaultCaptureDPI")
+	if ih.DefaultCaptureDPI < 0 {
+		Logger.Fatalf("DefaultCaptureDPI must not be negative")
+	}
+
+	var err error
+	ih.TileWidth = viper.GetInt("TileWidth")
+	ih.TileHeight = viper.GetInt("TileHeight")
+	ih.TileRules, err = readTileRules()
+	if err != nil {
+		Logger.Fatalf("Unable to read tile configuration: %s", err)
+	}
+	var tileDims = []int{ih.TileWidth, ih.TileHeight}
+	for _, r := range ih.TileRules {
+		tileDims = append(tileDims, r.Width, r.Height)
+	}
+	for _, dim := range tileDims {
+		if dim > tileCacheMaxDim {
+			tileCacheMaxDim = dim
+		}
+	}
+
+	for _, suffix := range strings.Split(viper.GetString("DerivativeSuffixes"), ",") {
+		suffix = strings.TrimSpace(suffix)
+		if suffix != "" {
+			ih.DerivativeSuffixes = append(ih.DerivativeSuffixes, suffix)
+		}
+	}
+	for _, ext := range strings.Split(viper.GetString("IDExtensions"), ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		if ext[0] != '.' {
+			ext = "." + ext
+		}
+		ih.IDExtensions = append(ih.IDExtensions, ext)
+	}
+	if len(ih.IDExtensions) > 0 {
+		setupExtensionCache()
+	}
+	ih.ServerTiming = viper.GetBool("ServerTiming")
+	ih.TimingAllowOrigin = viper.GetString("TimingAllowOrigin")
+	ih.CanonicalRedirect = viper.GetBool("CanonicalRedirect")
+	ih.SidecarPath = viper.GetString("SidecarPath")
+	ih.TrimTrailingSlash = viper.GetBool("TrimTrailingSlash")
+	ih.CaseInsensitiveIDs = viper.GetBool("CaseInsensitiveIDs")
+	ih.IDPolicy.MaxLength = viper.GetInt("MaxIDLength")
+	ih.IDPolicy.Pattern, err = compileIDPattern(viper.GetString("IDPattern"))
+	if err != nil {
+		Logger.Fatalf("Invalid IDPattern: %s", err)
+	}
+	ih.SourcePolicy = SourcePolicy(viper.GetString("SourcePathPolicy"))
+	switch ih.SourcePolicy {
+	case SourcesTilePath, SourcesAll, SourcesOff:
+	default:
+		Logger.Fatalf("SourcePathPolicy must be one of tilepath, all, or off")
+	}
+	for _, root := range strings.Split(viper.GetString("SourceRoots"), ",") {
+		root = strings.TrimSpace(root)
+		if root != "" {
+			ih.SourceRoots = append(ih.SourceRoots, root)
+		}
+	}
+	ih.Rights = Rights{
+		Attribution: viper.GetString("Attribution"),
+		License:     viper.GetString("License"),
+		Logo:        viper.GetString("Logo"),
+	}
+	ih.RightsRules, err = readRightsRules()
+	if err != nil {
+		Logger.Fatalf("Unable to read rights configuration: %s", err)
+	}
+	var serviceFile = viper.GetString("ServiceFile")
+	if serviceFile != "" {
+		ih.ServiceRules, err = readServiceRules(serviceFile)
+		if err != nil {
+			Logger.Fatalf("Unable to read service file %q: %s", serviceFile, err)
+		}
+	}
+	var aliasFile = viper.GetString("AliasFile")
+	if aliasFile != "" {
+		ih.Aliases, err = readAliases(aliasFile, ih.CaseInsensitiveIDs)
+		if err != nil {
+			Logger.Fatalf("Unable to read alias file %q: %s", aliasFile, err)
+		}
+		Logger.Infof("Loaded %d ID aliases from %q", len(ih.Aliases), aliasFile)
+	}
+	var watermarkFile = viper.GetString("WatermarkFile")
+	if watermarkFile != "" {
+		ih.Watermarks, err = loadWatermarks(watermarkFile)
+		if err != nil {
+			Logger.Fatalf("Unable to read watermark file %q: %s", watermarkFile, err)
+		}
+		Logger.Infof("Loaded %d watermark rules from %q", len(ih.Watermarks.rules), watermarkFile)
+	}
+	ih.InfoVersions, err = parseInfoVersions(viper.GetString("InfoVersions"))
+	if err != nil {
+		Logger.Fatalf("Invalid InfoVersions: %s", err)
+	}
+	ih.FallbackImage = viper.GetString("FallbackImage")
+	ih.FallbackStatus = viper.GetInt("FallbackStatus")
+
+	ih.ScaleFactors, err = readScaleFactorRules()
+	if err != nil {
+		Logger.Fatalf("Unable to read scale factor configuration: %s", err)
+	}
+	ih.FormatLimits, err = parseFormatLimits(viper.GetString("FormatLimits"))
+	if err != nil {
+		Logger.Fatalf("Unable to read format limits: %s", err)
+	}
+	var headerRules []headerRule
+	headerRules, err = readHeaderRules()
+	if err != nil {
+		Logger.Fatalf("Unable to read response header configuration: %s", err)
+	}
+	ih.ProxyRoutes, err = readProxyRoutes()
+	if err != nil {
+		Logger.Fatalf("Unable to read proxy configuration: %s", err)
+	}
+	for _, pr := range ih.ProxyRoutes {
+		Logger.Infof("Proxying IDs starting with %q to %q", pr.Prefix, pr.Upstream)
+	}
+	ih.Auth, err = readAuthService()
+	if err != nil {
+		Logger.Fatalf("Unable to read auth configuration: %s", err)
+	}
 
 	iiifBaseURL := viper.GetString("IIIFBaseURL")
 	if iiifBaseURL != "" {
@@ -84,6 +314,10 @@ func main() {
 	}
 
 	// Setup server info in our stats structure
+	topIDCount = viper.GetInt("TopIDCount")
+	if topIDCount > 0 {
+		idStats = newIDTracker(viper.GetDuration("TopIDWindow"))
+	}
 	stats.ServerStart = time.Now()
 	stats.RAISVersion = version.Version
 	stats.RAISBuild = version.Build
@@ -91,13 +325,96 @@ func main() {
 	// Set up handlers / listeners
 	var pubSrv = servers.New("RAIS", address)
 	pubSrv.AddMiddleware(logMiddleware)
+	pubSrv.AddMiddleware(headerMiddleware(headerRules))
+	pubSrv.AddMiddleware(classifyMiddleware(ih.WebPathPrefix))
+	if viper.GetBool("HTMLErrorPages") {
+		var ep, err = loadErrorPages(viper.GetString("ErrorTemplate"), viper.GetString("TranslationsPath"))
+		if err != nil {
+			Logger.Fatalf("Unable to load error page template or translations: %s", err)
+		}
+		pubSrv.AddMiddleware(ep.middleware)
+	}
+	if viper.GetBool("JSONErrors") {
+		pubSrv.AddMiddleware(jsonErrorMiddleware(ih.WebPathPrefix + "/"))
+	}
+	pubSrv.HandleExact(ih.WebPathPrefix+CapabilitiesPath, http.HandlerFunc(ih.CapabilitiesRoute))
 	handle(pubSrv, ih.WebPathPrefix+"/", http.HandlerFunc(ih.IIIFRoute))
+	if ih.Auth != nil {
+		pubSrv.HandleExact(AuthTokenPath, http.HandlerFunc(ih.Auth.TokenRoute))
+	}
+	if viper.GetBool("OverlayEnabled") {
+		pubSrv.HandlePrefix(OverlayPath, http.HandlerFunc(ih.OverlayRoute))
+	}
+	if viper.GetBool("DZIEnabled") {
+		handle(pubSrv, DZIPath, http.HandlerFunc(ih.DZIRoute))
+	}
+	var jobMinArea = viper.GetInt64("JobMinArea")
+	if jobMinArea > 0 {
+		var workers = viper.GetInt("JobWorkers")
+		if workers < 1 {
+			Logger.Fatalf("JobWorkers must be at least 1")
+		}
+		var queueLen = viper.GetInt("JobQueueLen")
+		if queueLen < 1 {
+			Logger.Fatalf("JobQueueLen must be at least 1")
+		}
+		jobs = newJobQueue(workers, queueLen, jobMinArea, viper.GetDuration("JobTTL"), viper.GetString("JobDir"))
+		go jobs.watch()
+		pubSrv.HandlePrefix(JobPath, http.HandlerFunc(jobs.JobRoute))
+	}
+	if viper.GetBool("PDFEnabled") {
+		if ih.Auth == nil {
+			Logger.Fatalf("PDFEnabled requires the IIIF Auth settings (AuthPrefixes, etc.)")
+		}
+		ih.PDFMaxPages = viper.GetInt("PDFMaxPages")
+		pubSrv.HandleExact(PDFPath, http.HandlerFunc(ih.PDFRoute))
+	}
 	handle(pubSrv, "/", http.NotFoundHandler())
 
+	var traces = viper.GetString("PrerenderTraces")
+	if traces != "" {
+		if tileCache == nil {
+			Logger.Fatalf("PrerenderTraces requires a tile cache (TileCacheLen)")
+		}
+		if tileCacheDisk == nil {
+			Logger.Warnf("Prerendered tiles will only be kept in memory; set TileCacheDiskPath to keep them across restarts")
+		}
+		var p = &prerenderer{
+			traces:   traces,
+			window:   time.Duration(viper.GetInt("PrerenderDays")) * 24 * time.Hour,
+			count:    viper.GetInt("PrerenderCount"),
+			interval: viper.GetDuration("PrerenderInterval"),
+			handler:  http.HandlerFunc(ih.IIIFRoute),
+		}
+		if p.window <= 0 || p.count <= 0 || p.interval < time.Minute {
+			Logger.Fatalf("PrerenderDays and PrerenderCount must be positive, and PrerenderInterval at least one minute")
+		}
+		Logger.Infof("Prerendering the %d most popular tiles of the past %d days every %s", p.count, viper.GetInt("PrerenderDays"), p.interval)
+		go p.watch()
+	}
+
 	var admSrv = servers.New("RAIS Admin", adminAddress)
 	admSrv.AddMiddleware(logMiddleware)
-	admSrv.HandleExact("/admin/stats.json", stats)
-	admSrv.HandlePrefix("/admin/cache/purge", http.HandlerFunc(adminPurgeCache))
+	admSrv.AddMiddleware(headerMiddleware(headerRules))
+	admSrv.HandleExact("/autoscale", http.HandlerFunc(AutoscaleRoute))
+
+	// Read-only replicas never register admin endpoints, so nothing can purge
+	// or refresh their caches no matter what else is configured
+	if viper.GetBool("ReadOnly") {
+		Logger.Infof("Read-only mode: admin endpoints are disabled")
+	} else {
+		admSrv.HandleExact("/admin/stats.json", stats)
+		admSrv.HandlePrefix("/admin/cache/purge", http.HandlerFunc(adminPurgeCache))
+		admSrv.HandlePrefix("/admin/refresh/", http.HandlerFunc(ih.RefreshRoute))
+		admSrv.HandleExact("/admin/validate", http.HandlerFunc(ih.ValidateRoute))
+		admSrv.HandleExact("/admin/metadata", http.HandlerFunc(ih.MetadataRoute))
+		admSrv.HandleExact("/admin/compare", http.HandlerFunc(ih.CompareRoute))
+		admSrv.HandleExact("/admin/conversion-advice", http.HandlerFunc(adminConversionAdvice))
+		if chaos.Enabled {
+			Logger.Warnf("Built with chaos hooks: faults can be injected via /admin/chaos")
+			admSrv.HandleExact("/admin/chaos", http.HandlerFunc(chaos.Route))
+		}
+	}
 
 	interrupts.TrapIntTerm(shutdown)
 
@@ -129,7 +446,22 @@ func handle(srv *servers.Server, pattern string, handler http.Handler) {
 func shutdown() {
 	wait.Add(1)
 	Logger.Infof("Stopping RAIS...")
-	servers.Shutdown(nil)
+
+	var timeout = viper.GetDuration("ShutdownTimeout")
+	var ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	for _, r := range servers.Shutdown(ctx) {
+		if r.Err != nil {
+			Logger.Warnf("%q server did not drain within %s: %d of %d in-flight request(s) cut off",
+				r.Name, timeout, r.CutOff, r.InFlight)
+		} else {
+			Logger.Infof("%q server stopped; %d in-flight request(s) completed", r.Name, r.InFlight)
+		}
+	}
+	cancel()
+
+	if jobs != nil {
+		jobs.cleanup()
+	}
 
 	if len(teardownPlugins) > 0 {
 		Logger.Infof("Tearing down plugins")
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image"
+	"net/http"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+)
+
+// memoryRecoveryRatio is the fraction of the soft limit memory usage must
+// drop below before we stop shedding large requests, so we don't flap on and
+// off right at the limit
+const memoryRecoveryRatio = 0.8
+
+// memMonitor is set up when a soft memory limit is configured
+var memMonitor *memoryMonitor
+
+// memoryMonitor enforces a soft memory limit.  The limit is handed to Go's
+// runtime, which collects garbage more aggressively as usage approaches it.
+// If that isn't enough and the limit is passed anyway, caches are purged and
+// memory handed back to the OS, and requests too large to cache are refused
+// until usage recovers, rather than letting a traffic spike run the server
+// into the kernel's OOM killer.
+type memoryMonitor struct {
+	limit      uint64
+	pressure   int32
+	readMemory func() uint64
+}
+
+// newMemoryMonitor sets the runtime's soft memory limit and returns a monitor
+// which sheds load against it
+func newMemoryMonitor(limit uint64) *memoryMonitor {
+	debug.SetMemoryLimit(int64(limit))
+	return &memoryMonitor{limit: limit, readMemory: memoryInUse}
+}
+
+// memoryMetrics are the runtime metrics for the memory Go's soft limit
+// covers: everything mapped by the runtime, less heap memory released to
+// the OS
+var memoryMetrics = []string{"/memory/classes/total:bytes", "/memory/classes/heap/released:bytes"}
+
+// memoryInUse returns the bytes of memory counted against the soft limit.
+// Unlike runtime.ReadMemStats, reading metrics doesn't stop the world, so
+// this is cheap enough to call on every request.
+func memoryInUse() uint64 {
+	var samples = make([]metrics.Sample, len(memoryMetrics))
+	for i, name := range memoryMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	for _, s := range samples {
+		if s.Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+	}
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// check compares memory usage to the limit, entering or leaving the
+// pressured state as needed
+func (mm *memoryMonitor) check() {
+	var used = mm.readMemory()
+	if used >= mm.limit {
+		if atomic.CompareAndSwapInt32(&mm.pressure, 0, 1) {
+			Logger.Warnf("Memory usage (%d bytes) is over the soft memory limit; purging caches "+
+				"and refusing large requests", used)
+			purgeCaches()
+			debug.FreeOSMemory()
+		}
+		return
+	}
+
+	if used < uint64(float64(mm.limit)*memoryRecoveryRatio) && atomic.CompareAndSwapInt32(&mm.pressure, 1, 0) {
+		Logger.Infof("Memory usage (%d bytes) has recovered; accepting large requests again", used)
+	}
+}
+
+// pressured returns true if the server is over its soft memory limit
+func (mm *memoryMonitor) pressured() bool {
+	return mm != nil && atomic.LoadInt32(&mm.pressure) == 1
+}
+
+// shed returns a 503 error if memory is under pressure and the scaled output
+// is larger than a cacheable tile.  Tiles are cheap to produce, and are what
+// keeps viewers working, so they're always allowed through.
+func (mm *memoryMonitor) shed(scale image.Rectangle) *HandlerError {
+	if mm == nil {
+		return nil
+	}
+	mm.check()
+	if !mm.pressured() || isTileSize(scale) {
+		return nil
+	}
+	return NewError("Server is low on memory; please retry later", http.StatusServiceUnavailable)
+}
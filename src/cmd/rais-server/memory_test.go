@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"net/http"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestMemoryMonitor(t *testing.T) {
+	// The monitor is built by hand so the test doesn't set the runtime's limit
+	var heap uint64
+	var mm = &memoryMonitor{limit: 1000, readMemory: func() uint64 { return heap }}
+	var big, tile = image.Rect(0, 0, 4000, 3000), image.Rect(0, 0, 512, 512)
+
+	heap = 999
+	assert.True(mm.shed(big) == nil, "big requests are allowed", t)
+	assert.False(mm.pressured(), "under the limit", t)
+
+	heap = 1000
+	assert.True(mm.shed(tile) == nil, "tiles are allowed", t)
+	assert.True(mm.pressured(), "usage is checked on every request", t)
+	assert.Equal(http.StatusServiceUnavailable, mm.shed(big).Code, "big requests are refused", t)
+	assert.True(mm.shed(tile) == nil, "tiles are still allowed", t)
+
+	heap = 850
+	mm.check()
+	assert.True(mm.pressured(), "still pressured until usage recovers", t)
+
+	heap = 700
+	mm.check()
+	assert.False(mm.pressured(), "recovered", t)
+
+	assert.True(memoryInUse() > 0, "memory usage is read from the runtime", t)
+
+	var nilMonitor *memoryMonitor
+	assert.True(nilMonitor.shed(big) == nil, "no monitor means no shedding", t)
+}
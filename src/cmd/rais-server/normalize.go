@@ -0,0 +1,32 @@
+package main
+
+import (
+	"rais/src/iiif"
+	"strings"
+)
+
+// normalizePath strips trailing slashes from a IIIF request path when the
+// handler is set to ignore them, so "foo/info.json/" and "foo/" are treated
+// the same as "foo/info.json" and "foo"
+func (ih *ImageHandler) normalizePath(path string) string {
+	if !ih.TrimTrailingSlash {
+		return path
+	}
+	return strings.TrimRight(path, "/")
+}
+
+// normalizeID folds u's ID to lowercase when IDs are case-insensitive.  The
+// path is rewritten to match, since it's the key for cached tiles.
+func (ih *ImageHandler) normalizeID(u *iiif.URL) {
+	if !ih.CaseInsensitiveIDs {
+		return
+	}
+	var id = foldID(u.ID)
+	u.Path = string(id) + strings.TrimPrefix(u.Path, string(u.ID))
+	u.ID = id
+}
+
+// foldID returns the case-insensitive form of id
+func foldID(id iiif.ID) iiif.ID {
+	return iiif.ID(strings.ToLower(string(id)))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestTrailingSlashAndCase(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var id = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var mixed = "docker%2Fimages%2Ftestfile%2FTest-World-Link.jp2"
+
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+id+"/info.json/", nil))
+	assert.Equal(http.StatusBadRequest, w.Code, "trailing slash is invalid by default", t)
+
+	ih.TrimTrailingSlash = true
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+id+"/info.json/", nil))
+	assert.Equal(http.StatusOK, w.Code, "trailing slash is ignored", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+id+"/", nil))
+	assert.Equal(http.StatusSeeOther, w.Code, "base URI with trailing slash", t)
+	assert.Equal("/iiif/"+id+"/info.json", w.Header().Get("Location"), "base URI redirect location", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+mixed+"/full/80,/0/default.jpg", nil))
+	assert.Equal(http.StatusNotFound, w.Code, "IDs are case-sensitive by default", t)
+
+	ih.CaseInsensitiveIDs = true
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+mixed+"/full/80,/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "mixed-case ID is folded", t)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"mime"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/plugins"
+	"strconv"
+	"strings"
+)
+
+// OverlayPath is the public server path prefix for overlay requests.  The
+// rest of the path is a normal IIIF image request.
+const OverlayPath = "/overlay/"
+
+// maxOverlayBoxes caps how many boxes a single request may draw
+const maxOverlayBoxes = 500
+
+// defaultOverlayColor is a translucent yellow, like a highlighter
+var defaultOverlayColor = color.NRGBA{R: 255, G: 255, B: 0, A: 102}
+
+// overlayBox is a rectangle in source image coordinates, drawn as a filled
+// highlight or, if Outline is nonzero, a border that many pixels wide
+type overlayBox struct {
+	X, Y, W, H int
+	Color      string
+	Outline    int
+
+	rgba color.NRGBA
+}
+
+// overlayRequest is the JSON body accepted by POSTed overlay requests
+type overlayRequest struct {
+	Boxes []overlayBox
+}
+
+// parseOverlayBox reads a box from an "x,y,w,h[,color[,outline]]" string
+func parseOverlayBox(val string) (overlayBox, error) {
+	var b overlayBox
+	var parts = strings.Split(val, ",")
+	if len(parts) < 4 || len(parts) > 6 {
+		return b, fmt.Errorf("box %q must be x,y,w,h with optional color and outline", val)
+	}
+
+	var nums = []*int{&b.X, &b.Y, &b.W, &b.H}
+	for i, n := range nums {
+		var err error
+		*n, err = strconv.Atoi(parts[i])
+		if err != nil {
+			return b, fmt.Errorf("box %q has an invalid number %q", val, parts[i])
+		}
+	}
+	if len(parts) > 4 {
+		b.Color = parts[4]
+	}
+	if len(parts) > 5 {
+		var err error
+		b.Outline, err = strconv.Atoi(parts[5])
+		if err != nil {
+			return b, fmt.Errorf("box %q has an invalid outline %q", val, parts[5])
+		}
+	}
+	return b, nil
+}
+
+// parseOverlayColor reads a "rrggbb" or "rrggbbaa" hex color, with an
+// optional leading "#".  An empty string gives the default highlight color.
+func parseOverlayColor(val string) (color.NRGBA, error) {
+	val = strings.TrimPrefix(val, "#")
+	if val == "" {
+		return defaultOverlayColor, nil
+	}
+
+	var data, err = hex.DecodeString(val)
+	if err != nil || (len(data) != 3 && len(data) != 4) {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", val)
+	}
+	var c = color.NRGBA{R: data[0], G: data[1], B: data[2], A: 255}
+	if len(data) == 4 {
+		c.A = data[3]
+	}
+	return c, nil
+}
+
+// readOverlayBoxes pulls boxes from the request: "box" query parameters for
+// GET requests, or a JSON body for POSTs
+func readOverlayBoxes(req *http.Request) ([]overlayBox, error) {
+	var boxes []overlayBox
+	if req.Method == http.MethodPost {
+		var or overlayRequest
+		var err = json.NewDecoder(req.Body).Decode(&or)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %s", err)
+		}
+		boxes = or.Boxes
+	} else {
+		for _, val := range req.URL.Query()["box"] {
+			var b, err = parseOverlayBox(val)
+			if err != nil {
+				return nil, err
+			}
+			boxes = append(boxes, b)
+		}
+	}
+
+	if len(boxes) == 0 {
+		return nil, fmt.Errorf("at least one box is required")
+	}
+	if len(boxes) > maxOverlayBoxes {
+		return nil, fmt.Errorf("no more than %d boxes may be drawn", maxOverlayBoxes)
+	}
+	for i, b := range boxes {
+		if b.W <= 0 || b.H <= 0 || b.Outline < 0 {
+			return nil, fmt.Errorf("box %d,%d,%d,%d must have a positive size", b.X, b.Y, b.W, b.H)
+		}
+		var err error
+		boxes[i].rgba, err = parseOverlayColor(b.Color)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return boxes, nil
+}
+
+// drawOverlays returns a color copy of m with each box drawn on it.  Boxes
+// are mapped from source coordinates to the output via crop and scale.
+func drawOverlays(m image.Image, boxes []overlayBox, crop, scale image.Rectangle) image.Image {
+	var b = m.Bounds()
+	var dst = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), m, b.Min, draw.Src)
+
+	var sx = float64(scale.Dx()) / float64(crop.Dx())
+	var sy = float64(scale.Dy()) / float64(crop.Dy())
+	for _, box := range boxes {
+		var r = image.Rect(
+			int(float64(box.X-crop.Min.X)*sx), int(float64(box.Y-crop.Min.Y)*sy),
+			int(float64(box.X+box.W-crop.Min.X)*sx+0.5), int(float64(box.Y+box.H-crop.Min.Y)*sy+0.5),
+		)
+		var src = image.NewUniform(box.rgba)
+		if box.Outline == 0 {
+			draw.Draw(dst, r, src, image.ZP, draw.Over)
+			continue
+		}
+
+		var o = box.Outline
+		for _, edge := range []image.Rectangle{
+			image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+o),
+			image.Rect(r.Min.X, r.Max.Y-o, r.Max.X, r.Max.Y),
+			image.Rect(r.Min.X, r.Min.Y+o, r.Min.X+o, r.Max.Y-o),
+			image.Rect(r.Max.X-o, r.Min.Y+o, r.Max.X, r.Max.Y-o),
+		} {
+			draw.Draw(dst, edge, src, image.ZP, draw.Over)
+		}
+	}
+
+	return dst
+}
+
+// OverlayRoute serves a IIIF image request with boxes drawn over it, for
+// highlighting areas such as search hits or articles.  Box coordinates are
+// in source image pixels, like IIIF regions.  Overlaid images are never
+// cached, and rotation isn't supported.
+func (ih *ImageHandler) OverlayRoute(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var path = strings.TrimPrefix(req.URL.EscapedPath(), OverlayPath)
+	var u, err = iiif.NewURL(path)
+	if err != nil || u.Info {
+		http.Error(w, fmt.Sprintf("Invalid IIIF image request %q", path), http.StatusBadRequest)
+		return
+	}
+	if u.Rotation.Mirror || u.Rotation.Degrees != 0 {
+		http.Error(w, "Overlays can't be drawn on rotated images", http.StatusBadRequest)
+		return
+	}
+	if !ih.featuresFor(u.ID).Supported(u) {
+		http.Error(w, "Feature not supported", http.StatusNotImplemented)
+		return
+	}
+	if ih.authorize(u.ID, req) != plugins.AuthAllow {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	var boxes []overlayBox
+	boxes, err = readOverlayBoxes(req)
+	if err != nil {
+		http.Error(w, "Invalid overlay: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var fp = ih.getIIIFPath(u.ID)
+	var info, e = ih.getInfo(u.ID, fp)
+	if e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	var max = ih.constraints(info)
+	var crop, scale image.Rectangle
+	crop, scale, err = img.Dimensions(u, info.Width, info.Height, max)
+	if err != nil {
+		e = newImageResError(err)
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	if e = ih.scaleFactorError(u.ID, info, crop, scale); e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	if e = ih.formatLimitError(u, scale); e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	if e = memMonitor.shed(scale); e != nil {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	var res *img.Resource
+	res, err = img.NewResource(u.ID, fp)
+	if err != nil {
+		e = newImageResError(err)
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	var release = decodeLimit.acquire(res.FilePath)
+	var m image.Image
+	m, err = res.Apply(u, max)
+	release()
+	if err != nil {
+		e = newImageResError(err)
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	// Overlays are derivatives like any other, so they carry the ID's
+	// watermark; the boxes are drawn on top so they stay visible
+	var _, mark = ih.renderKey(u, info, 0)
+	if mark != nil {
+		m = mark.apply(m)
+	}
+	m = drawOverlays(m, boxes, crop, scale)
+	var buf = bytes.NewBuffer(nil)
+	if err = EncodeImage(buf, m, u.Format, 0); err != nil {
+		Logger.Errorf("Unable to encode overlay to %s: %s", u.Format, err)
+		http.Error(w, "Unable to encode", 500)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension("."+string(u.Format)))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(buf.Bytes())
+}
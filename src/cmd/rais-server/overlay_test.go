@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseOverlayBox(t *testing.T) {
+	var b, err = parseOverlayBox("10,20,30,40,ff0000,2")
+	assert.NilError(err, "valid box", t)
+	assert.Equal(overlayBox{X: 10, Y: 20, W: 30, H: 40, Color: "ff0000", Outline: 2}, b, "parsed box", t)
+
+	_, err = parseOverlayBox("10,20,30")
+	assert.True(err != nil, "too few values", t)
+	_, err = parseOverlayBox("10,20,x,40")
+	assert.True(err != nil, "invalid number", t)
+}
+
+func TestParseOverlayColor(t *testing.T) {
+	var c, err = parseOverlayColor("#ff000080")
+	assert.NilError(err, "valid color", t)
+	assert.Equal(color.NRGBA{R: 255, A: 128}, c, "RGBA color", t)
+	c, _ = parseOverlayColor("")
+	assert.Equal(defaultOverlayColor, c, "default color", t)
+	_, err = parseOverlayColor("red")
+	assert.True(err != nil, "invalid color", t)
+}
+
+func TestOverlayRoute(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var region = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/0,0,400,200/200,/0/default.png"
+	var path = OverlayPath + region
+
+	var w = httptest.NewRecorder()
+	ih.OverlayRoute(w, httptest.NewRequest("GET", path+"?box=100,100,100,100,ffffff", nil))
+	assert.Equal(http.StatusOK, w.Code, "overlay request", t)
+	var m, err = png.Decode(w.Body)
+	assert.NilError(err, "decoding overlay", t)
+	assert.Equal(image.Rect(0, 0, 200, 100), m.Bounds(), "output size", t)
+
+	var r, _, _, _ = m.At(75, 75).RGBA()
+	assert.Equal(uint32(0xffff), r, "inside the box is white", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/"+region, nil))
+	var plain, _ = png.Decode(w.Body)
+	assert.Equal(plain.At(25, 25), m.At(25, 25), "outside the box is untouched", t)
+
+	var body = `{"Boxes": [{"X": 0, "Y": 0, "W": 50, "H": 50, "Color": "ffffff"}]}`
+	w = httptest.NewRecorder()
+	ih.OverlayRoute(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	assert.Equal(http.StatusOK, w.Code, "POSTed overlay request", t)
+	m, _ = png.Decode(bytes.NewReader(w.Body.Bytes()))
+	r, _, _, _ = m.At(10, 10).RGBA()
+	assert.Equal(uint32(0xffff), r, "inside the POSTed box is white", t)
+
+	w = httptest.NewRecorder()
+	ih.OverlayRoute(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(http.StatusBadRequest, w.Code, "boxes are required", t)
+}
+
+func TestOverlayWatermark(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-watermarks-")
+	defer os.RemoveAll(dir)
+	var red = image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.ZP, draw.Src)
+	var f, _ = os.Create(filepath.Join(dir, "red.png"))
+	png.Encode(f, red)
+	f.Close()
+	var fp = writeWatermarks(t, dir, "[[Watermark]]\nPattern = \"^docker/\"\nImage = \"red.png\"\nScale = 1.0\n", time.Now())
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var err error
+	ih.Watermarks, err = loadWatermarks(fp)
+	assert.NilError(err, "loading watermarks", t)
+
+	var path = OverlayPath + "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/0,0,400,200/200,/0/default.png"
+	var w = httptest.NewRecorder()
+	ih.OverlayRoute(w, httptest.NewRequest("GET", path+"?box=100,100,100,100,ffffff", nil))
+	assert.Equal(http.StatusOK, w.Code, "overlay request", t)
+	var m, _ = png.Decode(w.Body)
+
+	var r, g, _, _ = m.At(25, 25).RGBA()
+	assert.True(r == 0xffff && g == 0, "the watermark is drawn", t)
+	r, g, _, _ = m.At(75, 75).RGBA()
+	assert.True(r == 0xffff && g == 0xffff, "boxes are drawn over the watermark", t)
+}
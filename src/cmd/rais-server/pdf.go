@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/plugins"
+	"strconv"
+	"strings"
+)
+
+// PDFPath is the public server path for PDF compilation requests
+const PDFPath = "/pdf"
+
+// pdfRequest lists the images to compile, in page order, and the IIIF size
+// each should be rendered at
+type pdfRequest struct {
+	IDs      []string
+	Size     string
+	Filename string
+}
+
+// pdfPage is a validated page, ready to be rendered
+type pdfPage struct {
+	u     *iiif.URL
+	key   string
+	mark  *watermarkRule
+	fp    string
+	max   img.Constraint
+	scale image.Rectangle
+}
+
+// readPDFRequest pulls the compilation request from a JSON body for POSTs or
+// "id", "size", and "filename" query parameters for GETs
+func readPDFRequest(req *http.Request) (*pdfRequest, error) {
+	var pr = new(pdfRequest)
+	if req.Method == http.MethodPost {
+		var err = json.NewDecoder(req.Body).Decode(pr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %s", err)
+		}
+	} else {
+		var q = req.URL.Query()
+		pr.IDs = q["id"]
+		pr.Size = q.Get("size")
+		pr.Filename = q.Get("filename")
+	}
+
+	if len(pr.IDs) == 0 {
+		return nil, fmt.Errorf("at least one id is required")
+	}
+	if pr.Size == "" {
+		pr.Size = "max"
+	}
+	if pr.Filename == "" {
+		pr.Filename = "images.pdf"
+	}
+	pr.Filename = strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r < ' ' {
+			return -1
+		}
+		return r
+	}, pr.Filename)
+	return pr, nil
+}
+
+// preparePage validates a single page's IIIF request so that problems are
+// reported before any of the PDF has been sent.  Each page must be fully
+// authorized for req: degraded access isn't enough to compile an image.
+func (ih *ImageHandler) preparePage(req *http.Request, id, size string) (*pdfPage, *HandlerError) {
+	var u, err = iiif.NewURL(iiif.ID(id).Escaped() + "/full/" + size + "/0/default.jpg")
+	if err != nil {
+		return nil, NewError(fmt.Sprintf("invalid request for %q: %s", id, err), http.StatusBadRequest)
+	}
+	if err = ih.IDPolicy.check(u.ID); err != nil {
+		return nil, NewError(fmt.Sprintf("invalid request for %q: %s", id, err), http.StatusBadRequest)
+	}
+	if ih.proxyRouteFor(u.ID) != nil {
+		return nil, NewError(fmt.Sprintf("%q is served by another server", id), http.StatusBadRequest)
+	}
+	if ih.authorize(u.ID, req) != plugins.AuthAllow {
+		return nil, NewError(fmt.Sprintf("%q: authorization required", id), http.StatusUnauthorized)
+	}
+	if !ih.featuresFor(u.ID).Supported(u) {
+		return nil, NewError("feature not supported", http.StatusNotImplemented)
+	}
+
+	var p = &pdfPage{u: u, fp: ih.getIIIFPath(u.ID)}
+	var info, e = ih.getInfo(u.ID, p.fp)
+	if e != nil {
+		return nil, NewError(fmt.Sprintf("%q: %s", id, e.Message), e.Code)
+	}
+	p.max = ih.constraints(info)
+	p.key, p.mark = ih.renderKey(u, info, 0)
+	var crop image.Rectangle
+	crop, p.scale, err = img.Dimensions(u, info.Width, info.Height, p.max)
+	if err != nil {
+		var e = newImageResError(err)
+		return nil, NewError(fmt.Sprintf("%q: %s", id, e.Message), e.Code)
+	}
+	if e = ih.scaleFactorError(u.ID, info, crop, p.scale); e != nil {
+		return nil, NewError(fmt.Sprintf("%q: %s", id, e.Message), e.Code)
+	}
+	if e = ih.formatLimitError(u, p.scale); e != nil {
+		return nil, e
+	}
+	if e = memMonitor.shed(p.scale); e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+// PDFRoute compiles an ordered list of images into a PDF with one page per
+// image, rendering each through the normal pipeline (including the tile
+// cache).  Every page is validated before streaming starts; if rendering
+// fails partway through, the PDF is cut short and the error is logged.
+// Requests require a login via the IIIF Auth service.  When jobs are enabled,
+// clients can send "Prefer: respond-async" to have the PDF compiled as a job.
+func (ih *ImageHandler) PDFRoute(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ih.Auth.authorized(req) {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	var pr, err = readPDFRequest(req)
+	if err != nil {
+		http.Error(w, "Invalid PDF request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ih.PDFMaxPages > 0 && len(pr.IDs) > ih.PDFMaxPages {
+		http.Error(w, fmt.Sprintf("PDFs are limited to %d pages", ih.PDFMaxPages), http.StatusBadRequest)
+		return
+	}
+
+	var pages = make([]*pdfPage, len(pr.IDs))
+	for i, id := range pr.IDs {
+		var e *HandlerError
+		pages[i], e = ih.preparePage(req, id, pr.Size)
+		if e != nil {
+			if e.Code == http.StatusServiceUnavailable {
+				w.Header().Set("Retry-After", "5")
+			}
+			http.Error(w, e.Message, e.Code)
+			return
+		}
+	}
+
+	if jobs != nil && prefersAsync(req) {
+		var keys = make([]string, len(pages))
+		for i, p := range pages {
+			keys[i] = p.key
+		}
+		var path = "pdf|" + pr.Filename + "|" + strings.Join(keys, "|")
+		var j, ok = jobs.submit(path, iiif.FmtPDF, pr.Filename, func() ([]byte, *HandlerError) {
+			var buf bytes.Buffer
+			var e = ih.writePDF(&buf, pages)
+			return buf.Bytes(), e
+		})
+		if !ok {
+			jobs.full(w)
+			return
+		}
+		jobs.accepted(w, j)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, pr.Filename))
+	if e := ih.writePDF(w, pages); e != nil {
+		Logger.Errorf("Unable to compile PDF: %s", e.Message)
+	}
+}
+
+// writePDF renders the pages and writes them out as a PDF.  On error, what's
+// been written so far is an incomplete PDF.
+func (ih *ImageHandler) writePDF(w io.Writer, pages []*pdfPage) *HandlerError {
+	var pw = newPDFWriter(w, len(pages))
+	for _, p := range pages {
+		var data, e = ih.renderPage(p)
+		if e != nil {
+			return NewError(fmt.Sprintf("unable to render page for %q: %s", p.u.ID, e.Message), e.Code)
+		}
+		var err = pw.addJPEG(data)
+		if err != nil {
+			return NewError(fmt.Sprintf("unable to write page for %q: %s", p.u.ID, err), http.StatusInternalServerError)
+		}
+	}
+	var err = pw.finish()
+	if err != nil {
+		return NewError(fmt.Sprintf("unable to finish PDF: %s", err), http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// renderPage runs a prepared page through the normal render pipeline.  Long
+// PDFs can take a while, so memory is checked again for each page.
+func (ih *ImageHandler) renderPage(p *pdfPage) ([]byte, *HandlerError) {
+	if e := memMonitor.shed(p.scale); e != nil {
+		return nil, e
+	}
+	var res, err = img.NewResource(p.u.ID, p.fp)
+	if err != nil {
+		return nil, newImageResError(err)
+	}
+	return renderRequests.do(p.key, func() ([]byte, *HandlerError) {
+		return ih.render(p.u, p.key, p.mark, false, 0, res, p.max, nil)
+	})
+}
+
+// pdfWriter streams a minimal PDF in which each page is a single JPEG image,
+// embedded as-is and sized by the JPEG's resolution, or one point per pixel if
+// it has none.  Object numbers are assigned
+// up front so pages can be written as soon as they're rendered: 1 is the
+// catalog, 2 is the page tree, and each page uses three objects after that
+// (the page, its image, and its content stream).
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int64
+	pages   int
+	written int
+	offsets []int64
+}
+
+func newPDFWriter(w io.Writer, pages int) *pdfWriter {
+	var pw = &pdfWriter{w: bufio.NewWriter(w), pages: pages, offsets: make([]int64, 3+pages*3)}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	return pw
+}
+
+// printf writes to the PDF, tracking the byte offset for the xref table
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	var n, _ = fmt.Fprintf(pw.w, format, args...)
+	pw.n += int64(n)
+}
+
+// startObject records the offset of object num and writes its header
+func (pw *pdfWriter) startObject(num int) {
+	pw.offsets[num] = pw.n
+	pw.printf("%d 0 obj\n", num)
+}
+
+// addJPEG writes the next page using the given JPEG data
+func (pw *pdfWriter) addJPEG(data []byte) error {
+	var cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid JPEG: %s", err)
+	}
+	var colorSpace = "DeviceRGB"
+	if cfg.ColorModel == color.GrayModel {
+		colorSpace = "DeviceGray"
+	}
+
+	// PDF units are points, 72 to the inch
+	var pageW, pageH = float64(cfg.Width), float64(cfg.Height)
+	if dpi := jpegDPI(data); dpi > 0 {
+		pageW, pageH = pageW*72/float64(dpi), pageH*72/float64(dpi)
+	}
+
+	var pageObj = 3 + pw.written*3
+	var imageObj, contentObj = pageObj + 1, pageObj + 2
+	pw.startObject(pageObj)
+	pw.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+		"/Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+		pdfNumber(pageW), pdfNumber(pageH), imageObj, contentObj)
+
+	pw.startObject(imageObj)
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s "+
+		"/BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+		cfg.Width, cfg.Height, colorSpace, len(data))
+	var n, _ = pw.w.Write(data)
+	pw.n += int64(n)
+	pw.printf("\nendstream\nendobj\n")
+
+	var content = fmt.Sprintf("q %s 0 0 %s 0 0 cm /Im0 Do Q", pdfNumber(pageW), pdfNumber(pageH))
+	pw.startObject(contentObj)
+	pw.printf("<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+
+	pw.written++
+	return pw.w.Flush()
+}
+
+// pdfNumber formats a page dimension to two decimal places, dropping any
+// trailing zeros
+func pdfNumber(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// finish writes the page tree, catalog, and cross-reference table
+func (pw *pdfWriter) finish() error {
+	if pw.written != pw.pages {
+		return fmt.Errorf("%d of %d pages written", pw.written, pw.pages)
+	}
+
+	var kids = make([]string, pw.pages)
+	for i := range kids {
+		kids[i] = fmt.Sprintf("%d 0 R", 3+i*3)
+	}
+	pw.startObject(2)
+	pw.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), pw.pages)
+	pw.startObject(1)
+	pw.printf("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	var xref = pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets))
+	for _, off := range pw.offsets[1:] {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets), xref)
+	return pw.w.Flush()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestPDFWriter(t *testing.T) {
+	var jpg = bytes.NewBuffer(nil)
+	jpeg.Encode(jpg, image.NewGray(image.Rect(0, 0, 30, 20)), nil)
+
+	var buf = bytes.NewBuffer(nil)
+	var pw = newPDFWriter(buf, 2)
+	assert.NilError(pw.addJPEG(jpg.Bytes()), "first page", t)
+	assert.NilError(pw.addJPEG(jpg.Bytes()), "second page", t)
+	assert.NilError(pw.finish(), "finish", t)
+
+	var pdf = buf.String()
+	assert.True(strings.HasPrefix(pdf, "%PDF-1.4\n"), "PDF header", t)
+	assert.True(strings.HasSuffix(pdf, "%%EOF\n"), "PDF trailer", t)
+	assert.True(strings.Contains(pdf, "/Kids [3 0 R 6 0 R] /Count 2"), "page tree", t)
+	assert.True(strings.Contains(pdf, "/MediaBox [0 0 30 20]"), "page size", t)
+	assert.True(strings.Contains(pdf, "/ColorSpace /DeviceGray"), "gray color space", t)
+
+	// Every xref entry must point at the start of its object
+	var xref = regexp.MustCompile(`(?m)^(\d{10}) 00000 n $`).FindAllStringSubmatch(pdf, -1)
+	assert.Equal(8, len(xref), "xref entries", t)
+	for i, m := range xref {
+		var off, _ = strconv.Atoi(m[1])
+		assert.True(strings.HasPrefix(pdf[off:], strconv.Itoa(i+1)+" 0 obj"), "xref offset for object "+strconv.Itoa(i+1), t)
+	}
+
+	pw = newPDFWriter(bytes.NewBuffer(nil), 2)
+	pw.addJPEG(jpg.Bytes())
+	assert.True(pw.finish() != nil, "finishing early is an error", t)
+}
+
+func TestPDFRoute(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.Auth = testAuthService()
+	var id = "id=docker/images/testfile/test-world-link.jp2"
+	var cookie = &http.Cookie{Name: "rais-auth", Value: ih.Auth.signAuthToken(time.Now().Add(time.Minute))}
+
+	var req = httptest.NewRequest("GET", PDFPath+"?"+id, nil)
+	var w = httptest.NewRecorder()
+	ih.PDFRoute(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code, "login required", t)
+
+	req = httptest.NewRequest("GET", PDFPath+"?"+id+"&"+id+"&size=200,", nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	ih.PDFRoute(w, req)
+	assert.Equal(http.StatusOK, w.Code, "PDF request", t)
+	assert.Equal("application/pdf", w.Header().Get("Content-Type"), "content type", t)
+	assert.True(strings.Contains(w.Body.String(), "/Count 2"), "two pages", t)
+	assert.True(strings.Contains(w.Body.String(), "/MediaBox [0 0 200 100]"), "page size", t)
+
+	req = httptest.NewRequest("GET", PDFPath+"?"+id+"&id=missing.jp2", nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	ih.PDFRoute(w, req)
+	assert.Equal(http.StatusNotFound, w.Code, "missing images are reported before streaming", t)
+
+	var dir, err = ioutil.TempDir("", "rais-jobs")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	defer func() { jobs = nil }()
+	jobs = newJobQueue(1, 10, 1, time.Hour, dir)
+
+	req = httptest.NewRequest("GET", PDFPath+"?"+id+"&size=200,&filename=world.pdf", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("Prefer", "respond-async")
+	w = httptest.NewRecorder()
+	ih.PDFRoute(w, req)
+	assert.Equal(http.StatusAccepted, w.Code, "async PDF request starts a job", t)
+	var j renderJob
+	json.Unmarshal(w.Body.Bytes(), &j)
+	for i := 0; i < 100 && j.Status != jobDone; i++ {
+		time.Sleep(10 * time.Millisecond)
+		j, _ = jobs.get(j.ID)
+	}
+	assert.Equal(jobDone, j.Status, "PDF job finishes", t)
+	w = httptest.NewRecorder()
+	jobs.JobRoute(w, httptest.NewRequest("GET", j.Result, nil))
+	assert.Equal("application/pdf", w.Header().Get("Content-Type"), "job result content type", t)
+	assert.Equal(`attachment; filename="world.pdf"`, w.Header().Get("Content-Disposition"), "job result filename", t)
+	assert.True(strings.HasSuffix(w.Body.String(), "%%EOF\n"), "job result is a complete PDF", t)
+
+	defer func() { authPlugins = nil }()
+	authPlugins = []func(iiif.ID, *http.Request) (plugins.AuthDecision, error){
+		func(id iiif.ID, req *http.Request) (plugins.AuthDecision, error) {
+			return plugins.AuthDeny, nil
+		},
+	}
+	req = httptest.NewRequest("GET", PDFPath+"?"+id, nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	ih.PDFRoute(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code, "pages denied by a plugin are rejected", t)
+}
+
+func TestPDFPageSize(t *testing.T) {
+	var jpg = bytes.NewBuffer(nil)
+	jpeg.Encode(jpg, image.NewGray(image.Rect(0, 0, 300, 150)), nil)
+
+	var buf = bytes.NewBuffer(nil)
+	var pw = newPDFWriter(buf, 1)
+	assert.NilError(pw.addJPEG(jpegWithDPI(jpg.Bytes(), 200)), "page", t)
+	assert.True(strings.Contains(buf.String(), "/MediaBox [0 0 108 54]"), "page size in points", t)
+	assert.True(strings.Contains(buf.String(), "q 108 0 0 54 0 0 cm"), "image is scaled to the page", t)
+}
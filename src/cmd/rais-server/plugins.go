@@ -9,6 +9,7 @@
 	"plugin"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/plugins"
 	"reflect"
 	"sort"
 	"strings"
@@ -17,10 +18,13 @@
 )
 
 var idToPathPlugins []func(iiif.ID) (string, error)
+var idAliasPlugins []func(iiif.ID) (iiif.ID, error)
+var infoServicesPlugins []func(iiif.ID, string) []interface{}
 var wrapHandlerPlugins []func(string, http.Handler) (http.Handler, error)
 var teardownPlugins []func()
 var purgeCachePlugins []func()
 var expireCachedImagePlugins []func(iiif.ID)
+var sourceChecksumsPlugins []func(string) (map[string]string, error)
 
 // pluginsFor returns a list of all plugin files which matched the given
 // pattern.  Files are sorted by name.
@@ -71,6 +75,13 @@ func LoadPlugins(l *logger.Logger, patterns []string) {
 	for _, file := range plugFiles {
 		l.Infof("Loading plugin %q", file)
 		var err = loadPlugin(file, l)
+		var libErr *missingLibraryError
+		if errors.As(err, &libErr) {
+			l.Warnf("Skipping plugin %q: a shared library it needs isn't installed (%s); "+
+				"images which only it can decode will not be served", file, libErr.err)
+			stats.SkippedPlugins = append(stats.SkippedPlugins, plugStats{Path: file, Error: libErr.Error()})
+			continue
+		}
 		if err != nil {
 			l.Errorf("Unable to load %q: %s", file, err)
 		}
@@ -84,9 +95,30 @@ functions []string
 	errors    []string
 }
 
+// missingLibraryError is returned when a plugin can't be opened because a
+// shared library it links against (e.g., ImageMagick) isn't on the system.
+// This isn't fatal or even an error: RAIS simply runs without the plugin.
+type missingLibraryError struct {
+	err error
+}
+
+func (e *missingLibraryError) Error() string {
+	return e.err.Error()
+}
+
+// isMissingLibrary returns true if the plugin.Open error is the dynamic
+// linker reporting a library it couldn't find
+func isMissingLibrary(err error) bool {
+	var msg = err.Error()
+	return strings.Contains(msg, "cannot open shared object file") || strings.Contains(msg, "Library not loaded")
+}
+
 func newPluginWrapper(path string) (*pluginWrapper, error) {
 	var p, err = plugin.Open(path)
 	if err != nil {
+		if isMissingLibrary(err) {
+			return nil, &missingLibraryError{err}
+		}
 		return nil, fmt.Errorf("cannot load plugin %q: %s", path, err)
 	}
 	return &pluginWrapper{Plugin: p, path: path}, nil
@@ -130,20 +162,32 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 
 	// Simply initialize those functions we only want indexed if they exist
 	var idToPath func(iiif.ID) (string, error)
+	var idAlias func(iiif.ID) (iiif.ID, error)
+	var infoServices func(iiif.ID, string) []interface{}
 	var teardown func()
 	var wrapHandler func(string, http.Handler) (http.Handler, error)
 	var prgCache func()
 	var expCachedImg func(iiif.ID)
 	var imageDecoders func() []img.DecodeFn
+	var streamDecoders func() []img.StreamDecodeFn
+	var openSource func(string) (img.Source, error)
+	var authorizeID func(iiif.ID, *http.Request) (plugins.AuthDecision, error)
+	var sourceChecksums func(string) (map[string]string, error)
 
 	pw.loadPluginFn("SetLogger", &log)
 	pw.loadPluginFn("IDToPath", &idToPath)
+	pw.loadPluginFn("IDAlias", &idAlias)
+	pw.loadPluginFn("InfoServices", &infoServices)
 	pw.loadPluginFn("Initialize", &initialize)
 	pw.loadPluginFn("Teardown", &teardown)
 	pw.loadPluginFn("WrapHandler", &wrapHandler)
 	pw.loadPluginFn("PurgeCaches", &prgCache)
 	pw.loadPluginFn("ExpireCachedImage", &expCachedImg)
 	pw.loadPluginFn("ImageDecoders", &imageDecoders)
+	pw.loadPluginFn("StreamDecoders", &streamDecoders)
+	pw.loadPluginFn("OpenSource", &openSource)
+	pw.loadPluginFn("AuthorizeID", &authorizeID)
+	pw.loadPluginFn("SourceChecksums", &sourceChecksums)
 
 	if len(pw.errors) != 0 {
 		return errors.New(strings.Join(pw.errors, ", "))
@@ -172,17 +216,41 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 		l.Debugf("%q is explicitly enabled", fullpath)
 	}
 
-	// Register image decoder(s) if plugin exposes any
+	// Register image decoder(s) if plugin exposes any.  Decoders are named for
+	// the plugin so they can be chosen explicitly.
+	var base = strings.TrimSuffix(filepath.Base(fullpath), ".so")
+	var decoderCount = 0
+	var decoderName = func() string {
+		decoderCount++
+		if decoderCount == 1 {
+			return base
+		}
+		return fmt.Sprintf("%s-%d", base, decoderCount)
+	}
 	if imageDecoders != nil {
 		for _, fn := range imageDecoders() {
-			img.RegisterDecoder(fn)
+			img.RegisterNamedDecoder(decoderName(), fn)
+		}
+	}
+	if streamDecoders != nil {
+		for _, fn := range streamDecoders() {
+			img.RegisterStreamDecoder(decoderName(), fn)
 		}
 	}
+	if openSource != nil {
+		img.RegisterSourceOpener(openSource)
+	}
 
 	// Index remaining functions
 	if idToPath != nil {
 		idToPathPlugins = append(idToPathPlugins, idToPath)
 	}
+	if idAlias != nil {
+		idAliasPlugins = append(idAliasPlugins, idAlias)
+	}
+	if infoServices != nil {
+		infoServicesPlugins = append(infoServicesPlugins, infoServices)
+	}
 	if teardown != nil {
 		teardownPlugins = append(teardownPlugins, teardown)
 	}
@@ -195,6 +263,12 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 	if expCachedImg != nil {
 		expireCachedImagePlugins = append(expireCachedImagePlugins, expCachedImg)
 	}
+	if authorizeID != nil {
+		authPlugins = append(authPlugins, authorizeID)
+	}
+	if sourceChecksums != nil {
+		sourceChecksumsPlugins = append(sourceChecksumsPlugins, sourceChecksums)
+	}
 
 	// Add info to stats
 	stats.Plugins = append(stats.Plugins, plugStats{
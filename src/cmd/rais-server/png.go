@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"rais/src/iiif"
+)
+
+// PNGCompression is the zlib compression level used for PNG output
+var PNGCompression = png.DefaultCompression
+
+// pngCompressionLevels maps the PNGCompression setting's names to levels
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// PNGPalette turns on paletted output for gray and bitonal PNGs: bitonal
+// images become 1-bit PNGs, and gray images are quantized to PNGGrayLevels
+// evenly spaced levels, which shrinks scanned text dramatically
+var PNGPalette bool
+
+// PNGGrayLevels is the number of gray levels (2-256) in paletted gray PNGs
+var PNGGrayLevels = 256
+
+// palettize returns an image which the PNG encoder writes as a paletted PNG
+// if PNGPalette is on and the quality is gray or bitonal.  Otherwise i is
+// returned as-is.  Gray images keep all 256 levels unless PNGGrayLevels is
+// lower, as an 8-bit palette wouldn't save anything.
+func palettize(i image.Image, q iiif.Quality) image.Image {
+	if !PNGPalette {
+		return i
+	}
+
+	var levels = PNGGrayLevels
+	switch q {
+	case iiif.QBitonal:
+		levels = 2
+	case iiif.QGray:
+		if levels >= 256 {
+			return i
+		}
+	default:
+		return i
+	}
+
+	var p = &grayPaletted{Image: i, pal: make(color.Palette, levels)}
+	for n := range p.pal {
+		p.pal[n] = color.Gray{uint8(n * 255 / (levels - 1))}
+	}
+	return p
+}
+
+// grayPaletted presents a gray image as a paletted one, mapping each pixel to
+// the nearest of a set of evenly spaced gray levels.  Pixels are converted
+// as they're read, so banded images are still decoded a band at a time.
+type grayPaletted struct {
+	image.Image
+	pal color.Palette
+}
+
+// ColorModel returns the palette, which tells the PNG encoder to write a
+// paletted image
+func (p *grayPaletted) ColorModel() color.Model {
+	return p.pal
+}
+
+// ColorIndexAt returns the palette index for the pixel at x, y
+func (p *grayPaletted) ColorIndexAt(x, y int) uint8 {
+	var v uint8
+	if g, ok := p.Image.(*image.Gray); ok {
+		v = g.GrayAt(x, y).Y
+	} else {
+		v = color.GrayModel.Convert(p.Image.At(x, y)).(color.Gray).Y
+	}
+	var n = len(p.pal) - 1
+	return uint8((int(v)*n + 127) / 255)
+}
+
+// At returns the palette color for the pixel at x, y
+func (p *grayPaletted) At(x, y int) color.Color {
+	return p.pal[p.ColorIndexAt(x, y)]
+}
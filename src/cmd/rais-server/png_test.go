@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// pngHeader returns the bit depth and color type from a PNG's IHDR chunk
+func pngHeader(data []byte) (depth, colorType byte) {
+	return data[24], data[25]
+}
+
+func TestPalettize(t *testing.T) {
+	var oldPalette, oldLevels = PNGPalette, PNGGrayLevels
+	defer func() { PNGPalette, PNGGrayLevels = oldPalette, oldLevels }()
+
+	var src = image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	var encode = func(q iiif.Quality) []byte {
+		var buf bytes.Buffer
+		var err = EncodeImage(&buf, palettize(src, q), iiif.FmtPNG, 0)
+		assert.NilError(err, "encoding "+string(q), t)
+		return buf.Bytes()
+	}
+
+	var depth, ct = pngHeader(encode(iiif.QBitonal))
+	assert.Equal(byte(8), depth, "palettes are off by default", t)
+	assert.Equal(byte(0), ct, "gray color type", t)
+
+	PNGPalette = true
+	depth, ct = pngHeader(encode(iiif.QBitonal))
+	assert.Equal(byte(1), depth, "bitonal bit depth", t)
+	assert.Equal(byte(3), ct, "paletted color type", t)
+
+	depth, ct = pngHeader(encode(iiif.QGray))
+	assert.Equal(byte(0), ct, "gray isn't paletted with all 256 levels", t)
+
+	PNGGrayLevels = 16
+	var data = encode(iiif.QGray)
+	depth, ct = pngHeader(data)
+	assert.Equal(byte(4), depth, "16-level bit depth", t)
+	assert.Equal(byte(3), ct, "16-level color type", t)
+	var decoded, err = png.Decode(bytes.NewReader(data))
+	assert.NilError(err, "decoding 16-level PNG", t)
+	assert.Equal(color.Gray{0x11}, color.GrayModel.Convert(decoded.At(17, 0)), "pixels snap to the nearest level", t)
+
+	depth, ct = pngHeader(encode(iiif.QDefault))
+	assert.Equal(byte(0), ct, "color qualities are left alone", t)
+}
+
+func TestPNGCompression(t *testing.T) {
+	var old = PNGCompression
+	defer func() { PNGCompression = old }()
+
+	var src = image.NewGray(image.Rect(0, 0, 256, 256))
+	var size = func(level png.CompressionLevel) int {
+		PNGCompression = level
+		var buf bytes.Buffer
+		EncodeImage(&buf, src, iiif.FmtPNG, 0)
+		return buf.Len()
+	}
+	assert.True(size(png.BestCompression) < size(png.NoCompression), "compression level is used", t)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"rais/src/plugins"
+	"sort"
+	"strings"
+	"time"
+)
+
+// traceEvent holds the parts of a json-tracer event the prerenderer needs
+type traceEvent struct {
+	Path   string
+	Type   string
+	Start  time.Time
+	Status int
+}
+
+// prerenderer keeps the most-requested tiles from json-tracer logs rendered
+// in the tile cache, so the first viewers after a quiet period (or a restart,
+// with a disk tier) aren't stuck waiting on cold renders
+type prerenderer struct {
+	traces   string
+	window   time.Duration
+	count    int
+	interval time.Duration
+	handler  http.Handler
+}
+
+// topPaths reads json-tracer events from r and returns up to count of the
+// paths most often requested successfully in the window before now, most
+// popular first.  Only tile and resize requests for JPEGs are considered,
+// since nothing else goes in the tile cache.  Lines which aren't events are
+// skipped, as a log may be appended to while we read it.
+func (p *prerenderer) topPaths(r io.Reader, now time.Time) ([]string, error) {
+	var counts = make(map[string]int)
+	var since = now.Add(-p.window)
+	var s = bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		var ev traceEvent
+		if json.Unmarshal(s.Bytes(), &ev) != nil {
+			continue
+		}
+		if ev.Status != http.StatusOK || ev.Start.Before(since) || !strings.HasSuffix(ev.Path, ".jpg") {
+			continue
+		}
+		if ev.Type != string(plugins.ReqTile) && ev.Type != string(plugins.ReqResize) {
+			continue
+		}
+		counts[ev.Path]++
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var paths = make([]string, 0, len(counts))
+	for path := range counts {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if counts[paths[i]] != counts[paths[j]] {
+			return counts[paths[i]] > counts[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > p.count {
+		paths = paths[:p.count]
+	}
+	return paths, nil
+}
+
+// run renders the current list of popular paths.  Requests go straight to the
+// handler, skipping middleware, so they aren't traced themselves.  Paths
+// which are already cached cost next to nothing, and images behind auth
+// aren't rendered at all, as these requests carry no credentials.
+func (p *prerenderer) run() {
+	var f, err = os.Open(p.traces)
+	if err != nil {
+		Logger.Errorf("Unable to read traces for prerendering: %s", err)
+		return
+	}
+	var paths []string
+	paths, err = p.topPaths(f, time.Now())
+	f.Close()
+	if err != nil {
+		Logger.Errorf("Unable to read traces for prerendering: %s", err)
+		return
+	}
+
+	var start = time.Now()
+	var failed int
+	for _, path := range paths {
+		var req, err = http.NewRequest("GET", path, nil)
+		if err != nil {
+			failed++
+			continue
+		}
+		var w = &discardWriter{header: make(http.Header), status: http.StatusOK}
+		p.handler.ServeHTTP(w, req)
+		if w.status != http.StatusOK {
+			Logger.Debugf("Prerendering %q failed: status %d", path, w.status)
+			failed++
+		}
+	}
+	Logger.Infof("Prerendered %d popular paths in %s (%d failed)", len(paths), time.Since(start), failed)
+}
+
+// watch prerenders popular paths right away and again every interval
+func (p *prerenderer) watch() {
+	p.run()
+	for range time.Tick(p.interval) {
+		p.run()
+	}
+}
+
+// discardWriter is a response writer which only keeps the status code
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+// Header implements http.ResponseWriter
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *discardWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+// Write implements http.ResponseWriter
+func (w *discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
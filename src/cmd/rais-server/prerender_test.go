@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func traceLine(path, typ string, start time.Time, status int) string {
+	return fmt.Sprintf(`{"Path":%q,"Type":%q,"Start":%q,"Duration":0.1,"Status":%d}`+"\n",
+		path, typ, start.Format(time.RFC3339Nano), status)
+}
+
+func TestPrerenderTopPaths(t *testing.T) {
+	var now = time.Now()
+	var recent, old = now.Add(-time.Hour), now.Add(-10 * 24 * time.Hour)
+	var log = traceLine("/iiif/a/0,0,512,512/512,/0/default.jpg", "Tile", recent, 200) +
+		traceLine("/iiif/a/0,0,512,512/512,/0/default.jpg", "Tile", recent, 200) +
+		traceLine("/iiif/b/full/200,/0/default.jpg", "Resize", recent, 200) +
+		traceLine("/iiif/b/full/200,/0/default.jpg", "Resize", recent, 200) +
+		traceLine("/iiif/b/full/200,/0/default.jpg", "Resize", recent, 200) +
+		traceLine("/iiif/c/0,0,512,512/512,/0/default.jpg", "Tile", recent, 200) +
+		traceLine("/iiif/old/0,0,512,512/512,/0/default.jpg", "Tile", old, 200) +
+		traceLine("/iiif/old/0,0,512,512/512,/0/default.jpg", "Tile", old, 200) +
+		traceLine("/iiif/missing/0,0,512,512/512,/0/default.jpg", "Tile", recent, 404) +
+		traceLine("/iiif/missing/0,0,512,512/512,/0/default.jpg", "Tile", recent, 404) +
+		traceLine("/iiif/a/info.json", "Info", recent, 200) +
+		traceLine("/iiif/a/full/full/0/default.png", "Resize", recent, 200) +
+		"garbage from a partial write\n"
+
+	var p = &prerenderer{window: 7 * 24 * time.Hour, count: 10}
+	var paths, err = p.topPaths(strings.NewReader(log), now)
+	assert.NilError(err, "reading traces", t)
+	assert.Equal(3, len(paths), "only recent, successful JPEG tiles and thumbnails", t)
+	assert.Equal("/iiif/b/full/200,/0/default.jpg", paths[0], "most popular first", t)
+	assert.Equal("/iiif/a/0,0,512,512/512,/0/default.jpg", paths[1], "second most popular", t)
+
+	p.count = 1
+	paths, _ = p.topPaths(strings.NewReader(log), now)
+	assert.Equal(1, len(paths), "count limits the list", t)
+}
+
+func TestPrerenderRun(t *testing.T) {
+	var oldCache = tileCache
+	defer func() { tileCache = oldCache }()
+	viper.Set("TileCachePolicy", "lru")
+	defer viper.Reset()
+	tileCache, _ = newTileCache(10)
+
+	var id = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var f, _ = ioutil.TempFile("", "rais-traces-*.json")
+	defer os.Remove(f.Name())
+	f.WriteString(traceLine("/iiif/"+id+"/0,0,256,256/256,/0/default.jpg", "Tile", time.Now(), 200))
+	f.Close()
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var p = &prerenderer{traces: f.Name(), window: time.Hour, count: 10, handler: http.HandlerFunc(ih.IIIFRoute)}
+	p.run()
+
+	assert.Equal(1, tileCache.Len(), "popular tile is in the cache", t)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ProxyRoute sends requests for any ID starting with Prefix to a remote IIIF
+// server rather than serving them locally.  If StripPrefix is true, the
+// prefix is removed from the ID before the request is sent upstream.
+type ProxyRoute struct {
+	Prefix      string
+	Upstream    *url.URL
+	StripPrefix bool
+}
+
+// readProxyRoutes builds the list of proxy routes from the ProxyRoutes table
+func readProxyRoutes() ([]*ProxyRoute, error) {
+	var rawRoutes []struct {
+		Prefix      string
+		URL         string
+		StripPrefix bool
+	}
+	var err = viper.UnmarshalKey("ProxyRoutes", &rawRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ProxyRoutes: %s", err)
+	}
+
+	var routes []*ProxyRoute
+	for _, raw := range rawRoutes {
+		if raw.Prefix == "" {
+			return nil, fmt.Errorf("ProxyRoutes entries must have a Prefix")
+		}
+		var u *url.URL
+		u, err = parseUpstreamURL(raw.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ProxyRoutes URL %q: %s", raw.URL, err)
+		}
+		routes = append(routes, &ProxyRoute{Prefix: raw.Prefix, Upstream: u, StripPrefix: raw.StripPrefix})
+	}
+
+	// UpstreamURL puts RAIS in front of another IIIF server entirely: any ID not
+	// handled by a more specific route goes there
+	var upstream = viper.GetString("UpstreamURL")
+	if upstream != "" {
+		var u, err = parseUpstreamURL(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UpstreamURL %q: %s", upstream, err)
+		}
+		routes = append(routes, &ProxyRoute{Upstream: u})
+	}
+
+	return routes, nil
+}
+
+// parseUpstreamURL validates a remote IIIF server's base URL
+func parseUpstreamURL(s string) (*url.URL, error) {
+	var u, err = url.Parse(s)
+	if err == nil && (u.Scheme == "" || u.Host == "") {
+		err = fmt.Errorf("scheme and host are required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// proxyRouteFor returns the first proxy route matching the given ID, or nil
+// if the ID should be served locally
+func (ih *ImageHandler) proxyRouteFor(id iiif.ID) *ProxyRoute {
+	for _, pr := range ih.ProxyRoutes {
+		if strings.HasPrefix(string(id), pr.Prefix) {
+			return pr
+		}
+	}
+	return nil
+}
+
+// upstreamPath returns the escaped path the upstream server should receive
+// for the given request
+func (pr *ProxyRoute) upstreamPath(u *iiif.URL) string {
+	var id = string(u.ID)
+	if pr.StripPrefix {
+		id = id[len(pr.Prefix):]
+	}
+
+	var suffix = "info.json"
+	if !u.Info {
+		var parts = strings.Split(u.Path, "/")
+		suffix = strings.Join(parts[len(parts)-4:], "/")
+	}
+
+	return pr.Upstream.EscapedPath() + "/" + url.PathEscape(id) + "/" + suffix
+}
+
+// proxiedResponse holds the parts of an upstream response we keep in the
+// proxy cache
+type proxiedResponse struct {
+	ContentType string
+	Data        []byte
+}
+
+// serve proxies the request upstream.  Info responses have their ID replaced
+// with localID so clients continue to make requests through RAIS.
+func (pr *ProxyRoute) serve(w http.ResponseWriter, req *http.Request, u *iiif.URL, localID string) {
+	var escaped = pr.upstreamPath(u)
+	var unescaped, _ = url.PathUnescape(escaped)
+
+	// Info responses differ by the local ID we put into them, and by the
+	// version and media type the client negotiates with the upstream server,
+	// so those have to be part of the cache key
+	var key = pr.Upstream.Host + escaped
+	if u.Info {
+		key += fmt.Sprintf("|%s|v%d|ld=%t", localID, requestedInfoVersion(req), acceptsLD(req))
+	}
+	if proxyCache != nil {
+		stats.ProxyCache.Get()
+		var cached, ok = proxyCache.Get(key)
+		if ok {
+			stats.ProxyCache.Hit()
+			var pres = cached.(*proxiedResponse)
+			if u.Info {
+				w.Header().Add("Vary", "Accept")
+			}
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Content-Type", pres.ContentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(pres.Data)))
+			if req.Method != "HEAD" {
+				w.Write(pres.Data)
+			}
+			return
+		}
+	}
+
+	var rp = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = pr.Upstream.Scheme
+			r.URL.Host = pr.Upstream.Host
+			r.URL.Path = unescaped
+			r.URL.RawPath = escaped
+			r.URL.RawQuery = ""
+			r.Host = pr.Upstream.Host
+
+			// The client's credentials are for RAIS, not a third-party server
+			r.Header.Del("Cookie")
+			r.Header.Del("Authorization")
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			Logger.Errorf("Unable to proxy %q to %q: %s", u.Path, pr.Upstream, err)
+			http.Error(w, "upstream server error", http.StatusBadGateway)
+		},
+	}
+	rp.ModifyResponse = func(resp *http.Response) error {
+		if u.Info {
+			var err = rewriteInfoID(resp, localID)
+			if err != nil {
+				return err
+			}
+		}
+		return cacheResponse(resp, key)
+	}
+
+	rp.ServeHTTP(w, req)
+}
+
+// proxyInfoClient is used to read upstream info for proxied images
+var proxyInfoClient = &http.Client{Timeout: remoteTimeout}
+
+// fetchInfo reads the upstream server's info for a proxied info request, for
+// routes such as Deep Zoom which need an image's size to build their own
+// responses.  Like proxied responses, upstream info is kept in the proxy
+// cache.
+func (pr *ProxyRoute) fetchInfo(u *iiif.URL) (*iiif.Info, *HandlerError) {
+	var escaped = pr.upstreamPath(u)
+	var key = pr.Upstream.Host + escaped + "|raw"
+
+	var data []byte
+	if proxyCache != nil {
+		stats.ProxyCache.Get()
+		if cached, ok := proxyCache.Get(key); ok {
+			stats.ProxyCache.Hit()
+			data = cached.(*proxiedResponse).Data
+		}
+	}
+
+	if data == nil {
+		var upstream = *pr.Upstream
+		upstream.Path, _ = url.PathUnescape(escaped)
+		upstream.RawPath = escaped
+		upstream.RawQuery = ""
+		var resp, err = proxyInfoClient.Get(upstream.String())
+		if err != nil {
+			Logger.Errorf("Unable to read info for %q from %q: %s", u.ID, pr.Upstream, err)
+			return nil, NewError("upstream server error", http.StatusBadGateway)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, NewError("image resource does not exist", http.StatusNotFound)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, NewError("upstream server returned "+resp.Status, http.StatusBadGateway)
+		}
+		err = cacheResponse(resp, key)
+		if err == nil {
+			data, err = ioutil.ReadAll(resp.Body)
+		}
+		if err != nil {
+			Logger.Errorf("Unable to read info for %q from %q: %s", u.ID, pr.Upstream, err)
+			return nil, NewError("upstream server error", http.StatusBadGateway)
+		}
+	}
+
+	// IIIF 2 and 3 agree on the size and tile fields, which are all we need
+	var upInfo struct {
+		Width  int
+		Height int
+		Tiles  []iiif.TileSize
+	}
+	var err = json.Unmarshal(data, &upInfo)
+	if err != nil || upInfo.Width <= 0 || upInfo.Height <= 0 {
+		Logger.Errorf("Invalid upstream info for %q from %q", u.ID, pr.Upstream)
+		return nil, NewError("upstream server error", http.StatusBadGateway)
+	}
+	return &iiif.Info{Width: upInfo.Width, Height: upInfo.Height, Tiles: upInfo.Tiles}, nil
+}
+
+// rewriteInfoID replaces the "@id" (IIIF 2) or "id" (IIIF 3) value in a
+// successful info.json response
+func rewriteInfoID(resp *http.Response, id string) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var data, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var info map[string]interface{}
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return fmt.Errorf("invalid upstream info.json: %s", err)
+	}
+
+	if _, ok := info["id"]; ok {
+		info["id"] = id
+	} else {
+		info["@id"] = id
+	}
+
+	data, err = json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+// cacheResponse stores a successful upstream response in the proxy cache
+// unless the upstream server has asked us not to
+func cacheResponse(resp *http.Response, key string) error {
+	if proxyCache == nil || resp.StatusCode != http.StatusOK || resp.Request.Method != "GET" {
+		return nil
+	}
+	var cc = resp.Header.Get("Cache-Control")
+	if strings.Contains(cc, "no-store") || strings.Contains(cc, "private") {
+		return nil
+	}
+
+	var data, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	stats.ProxyCache.Set()
+	proxyCache.Add(key, &proxiedResponse{ContentType: resp.Header.Get("Content-Type"), Data: data})
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestProxyRoute(t *testing.T) {
+	var upstreamPaths []string
+	var credentials []string
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPaths = append(upstreamPaths, r.URL.EscapedPath())
+		credentials = append(credentials, r.Header.Get("Cookie")+r.Header.Get("Authorization"))
+		if r.URL.Path == "/images/a/b.jp2/info.json" {
+			w.Write([]byte(`{"@id": "http://upstream/images/a%2Fb.jp2", "width": 10}`))
+			return
+		}
+		w.Write([]byte("image data"))
+	}))
+	defer upstream.Close()
+
+	var upURL, _ = url.Parse(upstream.URL + "/images")
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.BaseURL, _ = url.Parse("http://example.com")
+	h.ProxyRoutes = []*ProxyRoute{{Prefix: "legacy:", Upstream: upURL, StripPrefix: true}}
+
+	var w = httptest.NewRecorder()
+	var req, _ = http.NewRequest("GET", "/iiif/legacy%3Aa%2Fb.jp2/info.json", nil)
+	h.IIIFRoute(w, req)
+	var info map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Equal(200, w.Code, "proxied info status", t)
+	assert.Equal("http://example.com/iiif/legacy%3Aa%2Fb.jp2", info["@id"], "info ID is rewritten", t)
+	assert.Equal(float64(10), info["width"], "info data is kept", t)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/iiif/legacy%3Aa%2Fb.jp2/full/full/0/default.jpg", nil)
+	req.Header.Set("Authorization", "Bearer local-token")
+	req.AddCookie(&http.Cookie{Name: "rais-auth", Value: "login"})
+	h.IIIFRoute(w, req)
+	assert.Equal("image data", w.Body.String(), "proxied image data", t)
+	assert.Equal("", credentials[1], "credentials aren't sent upstream", t)
+	assert.Equal("/images/a%2Fb.jp2/full/full/0/default.jpg", upstreamPaths[1], "upstream image path", t)
+
+	// Non-matching IDs are still served locally
+	var fw = fakehttp.NewResponseWriter()
+	req, _ = http.NewRequest("GET", "/iiif/identifier/info.json", nil)
+	h.IIIFRoute(fw, req)
+	assert.Equal(404, fw.StatusCode, "local IDs aren't proxied", t)
+	assert.Equal(2, len(upstreamPaths), "upstream request count", t)
+}
+
+func TestProxyCache(t *testing.T) {
+	var hits int
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Path == "/iiif/private/full/full/0/default.jpg" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		w.Write([]byte("image data"))
+	}))
+	defer upstream.Close()
+
+	var oldCache = proxyCache
+	proxyCache, _ = lru.New2Q(10)
+	defer func() { proxyCache = oldCache }()
+
+	var upURL, _ = url.Parse(upstream.URL + "/iiif")
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.ProxyRoutes = []*ProxyRoute{{Upstream: upURL}}
+
+	var get = func(path string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var req, _ = http.NewRequest("GET", path, nil)
+		h.IIIFRoute(w, req)
+		return w
+	}
+
+	get("/iiif/public/full/full/0/default.jpg")
+	var w = get("/iiif/public/full/full/0/default.jpg")
+	assert.Equal(1, hits, "second request is served from the cache", t)
+	assert.Equal("image data", w.Body.String(), "cached data", t)
+	assert.Equal("image/jpeg", w.Header().Get("Content-Type"), "cached content type", t)
+
+	assert.Equal("*", w.Header().Get("Access-Control-Allow-Origin"), "cached responses allow CORS", t)
+
+	get("/iiif/private/full/full/0/default.jpg")
+	get("/iiif/private/full/full/0/default.jpg")
+	assert.Equal(3, hits, "private responses aren't cached", t)
+}
+
+func TestProxyCacheInfoVersions(t *testing.T) {
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), iiif.Info3Context) {
+			w.Write([]byte(`{"@context": "` + iiif.Info3Context + `", "id": "x"}`))
+			return
+		}
+		w.Write([]byte(`{"@context": "` + iiif.Info2Context + `", "@id": "x"}`))
+	}))
+	defer upstream.Close()
+
+	var oldCache = proxyCache
+	proxyCache, _ = lru.New2Q(10)
+	defer func() { proxyCache = oldCache }()
+
+	var upURL, _ = url.Parse(upstream.URL + "/iiif")
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.ProxyRoutes = []*ProxyRoute{{Upstream: upURL}}
+
+	var get = func(accept string) map[string]interface{} {
+		var w = httptest.NewRecorder()
+		var req, _ = http.NewRequest("GET", "/iiif/remote.jp2/info.json", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		h.IIIFRoute(w, req)
+		var info map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &info)
+		return info
+	}
+
+	var v3 = `application/ld+json;profile="` + iiif.Info3Context + `"`
+	assert.Equal(iiif.Info2Context, get("")["@context"], "default version", t)
+	assert.Equal(iiif.Info3Context, get(v3)["@context"], "cached 2.x info isn't served to 3.0 clients", t)
+	assert.Equal(iiif.Info2Context, get("")["@context"], "cached 3.0 info isn't served to other clients", t)
+	assert.Equal(iiif.Info3Context, get(v3)["@context"], "cached 3.0 info is served to 3.0 clients", t)
+	assert.Equal(2, proxyCache.Len(), "one cached response per version", t)
+}
+
+func TestProxyRouteAuth(t *testing.T) {
+	var hits int
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("secret image data"))
+	}))
+	defer upstream.Close()
+
+	var oldCache = proxyCache
+	proxyCache, _ = lru.New2Q(10)
+	defer func() { proxyCache = oldCache }()
+
+	var upURL, _ = url.Parse(upstream.URL + "/iiif")
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.BaseURL, _ = url.Parse("http://example.com")
+	h.Auth = testAuthService()
+	h.Auth.Prefixes = []string{"restricted/"}
+	h.ProxyRoutes = []*ProxyRoute{{Upstream: upURL}}
+
+	var path = "/iiif/restricted%2Fa.jp2/full/full/0/default.jpg"
+	var get = func(cookie bool) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var req = httptest.NewRequest("GET", path, nil)
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: "rais-auth", Value: h.Auth.signAuthToken(time.Now().Add(time.Minute))})
+		}
+		h.IIIFRoute(w, req)
+		return w
+	}
+
+	var w = get(false)
+	assert.Equal(http.StatusUnauthorized, w.Code, "restricted proxied ID without a cookie", t)
+	assert.Equal(0, hits, "unauthorized requests aren't proxied", t)
+
+	w = get(true)
+	assert.Equal(http.StatusOK, w.Code, "restricted proxied ID with a cookie", t)
+	assert.Equal(1, hits, "authorized request is proxied", t)
+
+	w = get(false)
+	assert.Equal(http.StatusUnauthorized, w.Code, "cached response isn't served without a cookie", t)
+	assert.False(strings.Contains(w.Body.String(), "secret"), "no cached data in the denial", t)
+
+	w = httptest.NewRecorder()
+	h.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/restricted%2Fa.jp2/info.json", nil))
+	assert.Equal(http.StatusUnauthorized, w.Code, "restricted proxied info without a token", t)
+	assert.True(strings.Contains(w.Body.String(), `"@id":"http://example.com/auth/token"`), "token service advertised", t)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"rais/src/iiif"
+	"strconv"
+	"time"
+)
+
+// redisKeyPrefix namespaces RAIS's keys so a Redis server can be shared
+const redisKeyPrefix = "rais:info:"
+
+// redisTimeout bounds dialing and each command, so a slow or missing Redis
+// server degrades to cache misses instead of hanging requests
+const redisTimeout = time.Second
+
+// redisIdleConns is the most connections kept open between commands
+const redisIdleConns = 16
+
+// redisInfoCache stores info data in Redis, which lets any number of RAIS
+// instances share a cache without shared storage.  Entries expire via Redis
+// TTLs; the cache's size is left to the server's maxmemory policy.  RAIS
+// speaks just enough of the Redis protocol for the handful of commands it
+// needs, rather than pulling in a client library.
+type redisInfoCache struct {
+	addr     string
+	password string
+	db       int
+	ttl      time.Duration
+	idle     chan *redisConn
+}
+
+func newRedisInfoCache(addr, password string, db int, ttl time.Duration) (*redisInfoCache, error) {
+	var c = &redisInfoCache{addr: addr, password: password, db: db, ttl: ttl, idle: make(chan *redisConn, redisIdleConns)}
+	var _, err = c.do("PING")
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach redis at %q: %s", addr, err)
+	}
+	return c, nil
+}
+
+func (c *redisInfoCache) key(id iiif.ID) string {
+	return redisKeyPrefix + string(id)
+}
+
+// Get implements infoCacher
+func (c *redisInfoCache) Get(id iiif.ID) (ImageInfo, bool) {
+	var reply, err = c.do("GET", c.key(id))
+	if err != nil {
+		Logger.Errorf("Unable to read info for %q from redis: %s", id, err)
+		return ImageInfo{}, false
+	}
+	var data, ok = reply.([]byte)
+	if !ok {
+		return ImageInfo{}, false
+	}
+
+	var info ImageInfo
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		Logger.Warnf("Removing invalid redis info data for %q: %s", id, err)
+		c.Remove(id)
+		return ImageInfo{}, false
+	}
+	return info, true
+}
+
+// Add implements infoCacher
+func (c *redisInfoCache) Add(id iiif.ID, info ImageInfo) {
+	var data, err = json.Marshal(info)
+	if err != nil {
+		Logger.Errorf("Unable to serialize info for %q: %s", id, err)
+		return
+	}
+
+	var args = []string{"SET", c.key(id), string(data)}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(c.ttl/time.Millisecond), 10))
+	}
+	_, err = c.do(args...)
+	if err != nil {
+		Logger.Errorf("Unable to store info for %q in redis: %s", id, err)
+	}
+}
+
+// Remove implements infoCacher
+func (c *redisInfoCache) Remove(id iiif.ID) {
+	var _, err = c.do("DEL", c.key(id))
+	if err != nil {
+		Logger.Errorf("Unable to remove info for %q from redis: %s", id, err)
+	}
+}
+
+// Purge implements infoCacher, removing only RAIS's keys
+func (c *redisInfoCache) Purge() {
+	var err = c.scan(func(keys []string) error {
+		var _, err = c.do(append([]string{"DEL"}, keys...)...)
+		return err
+	})
+	if err != nil {
+		Logger.Errorf("Unable to purge redis info cache: %s", err)
+	}
+}
+
+// Len implements infoCacher.  This walks all of RAIS's keys, so it's only
+// meant for occasional use, such as the stats endpoint.
+func (c *redisInfoCache) Len() int {
+	var n int
+	var err = c.scan(func(keys []string) error {
+		n += len(keys)
+		return nil
+	})
+	if err != nil {
+		Logger.Errorf("Unable to count redis info cache keys: %s", err)
+	}
+	return n
+}
+
+// scan calls fn with each non-empty batch of RAIS's keys
+func (c *redisInfoCache) scan(fn func([]string) error) error {
+	var cursor = "0"
+	for {
+		var reply, err = c.do("SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", "1000")
+		if err != nil {
+			return err
+		}
+		var parts, ok = reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return errors.New("unexpected SCAN reply")
+		}
+		var next, _ = parts[0].([]byte)
+		var list, _ = parts[1].([]interface{})
+
+		var keys []string
+		for _, k := range list {
+			if b, ok := k.([]byte); ok {
+				keys = append(keys, string(b))
+			}
+		}
+		if len(keys) > 0 {
+			err = fn(keys)
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// do runs a single command, returning its reply.  Replies are []byte for
+// bulk strings, string for simple strings, int64 for integers, nil for
+// missing values, and []interface{} for arrays.  Error replies are returned
+// as errors.
+func (c *redisInfoCache) do(args ...string) (interface{}, error) {
+	var conn, err = c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	reply, err = conn.do(args)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+	if re, ok := reply.(redisError); ok {
+		return nil, re
+	}
+	return reply, nil
+}
+
+// conn returns an idle connection or dials a new one
+func (c *redisInfoCache) conn() (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	var nc, err = net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var conn = &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		var reply, err = conn.do(args)
+		if re, ok := reply.(redisError); ok && err == nil {
+			err = re
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s failed: %s", args[0], err)
+		}
+	}
+	return conn, nil
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisConn is a single connection to a Redis server
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads its reply.  Error replies are returned as a
+// redisError value, not an error, since the connection is still usable.
+func (rc *redisConn) do(args []string) (interface{}, error) {
+	rc.SetDeadline(time.Now().Add(redisTimeout))
+	var buf = []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	var _, err = rc.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	return readRedisReply(rc.r)
+}
+
+// readRedisReply parses a single RESP value from r
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	var line, err = r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed redis reply")
+	}
+	var kind, val = line[0], line[1 : len(line)-2]
+
+	switch kind {
+	case '+':
+		return val, nil
+	case '-':
+		return redisError(val), nil
+	case ':':
+		return strconv.ParseInt(val, 10, 64)
+	case '$':
+		var n int
+		n, err = strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		var data = make([]byte, n+2)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		var n int
+		n, err = strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		var list = make([]interface{}, n)
+		for i := range list {
+			list[i], err = readRedisReply(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply type %q", kind)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// fakeRedis is a tiny in-memory server speaking the subset of the Redis
+// protocol the info cache uses
+type fakeRedis struct {
+	sync.Mutex
+	ln       net.Listener
+	password string
+	data     map[string]string
+	expires  map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	var f = &fakeRedis{ln: ln, password: password, data: make(map[string]string), expires: make(map[string]time.Time)}
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		var conn, err = f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	var r = bufio.NewReader(conn)
+	var authed = f.password == ""
+	for {
+		var reply, err = readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var list, _ = reply.([]interface{})
+		var args []string
+		for _, a := range list {
+			var b, _ = a.([]byte)
+			args = append(args, string(b))
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		var cmd = strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		if cmd == "AUTH" {
+			authed = args[1] == f.password
+		}
+		conn.Write([]byte(f.run(cmd, args[1:])))
+	}
+}
+
+func (f *fakeRedis) run(cmd string, args []string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	for k, exp := range f.expires {
+		if time.Now().After(exp) {
+			delete(f.data, k)
+			delete(f.expires, k)
+		}
+	}
+
+	switch cmd {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		var v, ok = f.data[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		f.data[args[0]] = args[1]
+		delete(f.expires, args[0])
+		if len(args) == 4 && args[2] == "PX" {
+			var ms, _ = strconv.Atoi(args[3])
+			f.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		var n int
+		for _, k := range args {
+			if _, ok := f.data[k]; ok {
+				n++
+			}
+			delete(f.data, k)
+			delete(f.expires, k)
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SCAN":
+		var prefix = strings.TrimSuffix(args[2], "*")
+		var keys []string
+		for k := range f.data {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, fmt.Sprintf("$%d\r\n%s\r\n", len(k), k))
+			}
+		}
+		return fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n%s", len(keys), strings.Join(keys, ""))
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisInfoCache(t *testing.T) {
+	var f = newFakeRedis(t, "secret")
+	defer f.ln.Close()
+	var addr = f.ln.Addr().String()
+
+	var _, err = newRedisInfoCache(addr, "", 0, 0)
+	assert.True(err != nil, "connecting without the password fails", t)
+
+	var c *redisInfoCache
+	c, err = newRedisInfoCache(addr, "secret", 1, 0)
+	assert.NilError(err, "connecting with the password", t)
+
+	f.data["other-app:key"] = "value"
+	testInfoCacher(c, t)
+	assert.Equal("value", f.data["other-app:key"], "Purge leaves other applications' keys alone", t)
+
+	c, _ = newRedisInfoCache(addr, "secret", 0, time.Millisecond*10)
+	testInfoCacherTTL(c, t)
+
+	f.data[redisKeyPrefix+"broken"] = "{"
+	var _, ok = c.Get("broken")
+	assert.False(ok, "invalid data is a miss", t)
+	_, ok = f.data[redisKeyPrefix+"broken"]
+	assert.False(ok, "invalid data is removed", t)
+
+	f.ln.Close()
+	for len(c.idle) > 0 {
+		(<-c.idle).Close()
+	}
+	_, ok = c.Get("anything")
+	assert.False(ok, "an unreachable server is a miss", t)
+}
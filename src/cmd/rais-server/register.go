@@ -3,12 +3,113 @@
 import (
 	"path/filepath"
 	"rais/src/img"
+	"rais/src/jp2info"
 	"rais/src/openjpeg"
+	"rais/src/ptiff"
+	"rais/src/stdimage"
+	"rais/src/vips"
+	"strings"
 )
 
-func decodeJP2(path string) (img.Decoder, error) {
-	if filepath.Ext(path) == ".jp2" {
-		return openjpeg.NewJP2Image(path)
+// decodeJP2 handles JP2 and JPX files and raw JPEG 2000 codestreams.  JPM
+// files are claimed too, so they get a clear "unsupported" error instead of
+// falling through to other decoders.  Sources which aren't local files, such
+// as remote URLs, are read through an openjpeg stream, so only the parts of
+// the file a request needs are read.
+func decodeJP2(src img.Source) (img.Decoder, error) {
+	switch filepath.Ext(src.Name()) {
+	case ".jp2", ".j2c", ".j2k", ".jpf", ".jpx", ".jpm":
+	default:
+		return nil, img.ErrNotHandled
 	}
-	return nil, img.ErrNotHandled
+
+	var i *openjpeg.JP2Image
+	var err error
+	if f, ok := src.(*img.File); ok {
+		i, err = openjpeg.NewJP2Image(f.Name())
+	} else {
+		i, err = openjpeg.NewJP2ImageReader(sourceKey(src), src)
+	}
+	if ue, ok := err.(*jp2info.UnsupportedError); ok {
+		return nil, img.UnsupportedError(ue.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// sourceKey returns a key identifying this version of src's data, for
+// caching what's read from it
+func sourceKey(src img.Source) string {
+	if k, ok := src.(interface{ Key() string }); ok {
+		return k.Key()
+	}
+	return src.Name()
+}
+
+// decodeTIFF handles tiled TIFFs.  Strip TIFFs and layouts the ptiff package
+// doesn't support are left for plugins such as the ImageMagick decoder.
+func decodeTIFF(src img.Source) (img.Decoder, error) {
+	switch strings.ToLower(filepath.Ext(src.Name())) {
+	case ".tif", ".tiff", ".ptif":
+	default:
+		return nil, img.ErrNotHandled
+	}
+
+	var i, err = ptiff.Open(src)
+	if ptiff.IsNotHandled(err) {
+		return nil, img.ErrNotHandled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// decodeStdImage handles JPEG, PNG, and GIF sources with Go's own decoders,
+// so they can be served without the ImageMagick plugin.  Variants Go can't
+// read are left for plugins.
+func decodeStdImage(src img.Source) (img.Decoder, error) {
+	var i *stdimage.Image
+	var err error
+	switch strings.ToLower(filepath.Ext(src.Name())) {
+	case ".jpg", ".jpeg":
+		i, err = stdimage.OpenJPEG(src)
+	case ".png":
+		i, err = stdimage.OpenPNG(src)
+	case ".gif":
+		i, err = stdimage.OpenGIF(src)
+	default:
+		return nil, img.ErrNotHandled
+	}
+
+	if stdimage.IsNotHandled(err) {
+		return nil, img.ErrNotHandled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// decodeVips handles local TIFF, JPEG, and PNG files with libvips when the
+// VipsBackend setting is on.  Other sources, such as remote URLs, are left to
+// the native decoders, since libvips reads files itself.
+func decodeVips(src img.Source) (img.Decoder, error) {
+	var f, ok = src.(*img.File)
+	if !ok {
+		return nil, img.ErrNotHandled
+	}
+	switch strings.ToLower(filepath.Ext(f.Name())) {
+	case ".tif", ".tiff", ".ptif", ".jpg", ".jpeg", ".png":
+	default:
+		return nil, img.ErrNotHandled
+	}
+
+	var i, err = vips.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"rais/src/img"
+	"rais/src/rangeio"
+	"time"
+)
+
+// remoteTimeout caps each ranged request for a remote source, so a stalled
+// object store can't hold a request open forever
+const remoteTimeout = 30 * time.Second
+
+// remoteClient is used for all reads of remote (http or https) sources
+var remoteClient = &http.Client{Timeout: remoteTimeout}
+
+// remoteBlocks caches blocks and metadata of remote sources.  It's set up by
+// setupRemoteSources, and tests may replace it.
+var remoteBlocks *rangeio.Cache
+
+// setupRemoteSources creates the remote source block cache.  blocks is the
+// number of rangeio.BlockSize blocks held, and ttl is how long a remote
+// file's size and ETag are trusted before it's checked again.
+func setupRemoteSources(blocks int, ttl time.Duration) {
+	var err error
+	remoteBlocks, err = rangeio.NewCache(blocks, ttl)
+	if err != nil {
+		Logger.Fatalf("Unable to start remote source cache: %s", err)
+	}
+	purgeCachePlugins = append(purgeCachePlugins, remoteBlocks.Purge)
+	img.RegisterSourceOpener(openRemote)
+}
+
+// openRemote opens http and https sources, which are read with ranged
+// requests
+func openRemote(path string) (img.Source, error) {
+	if !rangeio.IsURL(path) {
+		return nil, img.ErrNotHandled
+	}
+
+	var f, err = rangeio.Open(remoteClient, path, remoteBlocks)
+	if err == rangeio.ErrNotFound {
+		return nil, img.ErrDoesNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// statSource is os.Stat for image sources, which also handles remote URLs
+// and any other sources a plugin can open
+func statSource(path string) (os.FileInfo, error) {
+	var src, err = img.OpenSource(path)
+	if err != nil {
+		return nil, err
+	}
+	return src.Stat(), nil
+}
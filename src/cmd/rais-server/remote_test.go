@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/rangeio"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func init() {
+	img.RegisterSourceOpener(openRemote)
+}
+
+func TestRemoteJP2(t *testing.T) {
+	var requests int32
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if req.URL.Path != "/test-world.jp2" {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeFile(w, req, rootDir()+"/docker/images/testfile/test-world.jp2")
+	}))
+	defer srv.Close()
+
+	var oldBlocks = remoteBlocks
+	var err error
+	remoteBlocks, err = rangeio.NewCache(64, time.Minute)
+	assert.NilError(err, "creating block cache", t)
+	defer func() { remoteBlocks = oldBlocks }()
+
+	idToPathPlugins = []func(iiif.ID) (string, error){
+		func(id iiif.ID) (string, error) { return srv.URL + "/" + string(id) + "?sig=abc", nil },
+	}
+	defer func() { idToPathPlugins = nil }()
+
+	var w = request("test-world.jp2/info.json", t)
+	assert.Equal(-1, w.StatusCode, "remote info request succeeds", t)
+	var data iiif.Info
+	json.Unmarshal(w.Output, &data)
+	assert.Equal(800, data.Width, "remote width", t)
+	assert.Equal(400, data.Height, "remote height", t)
+
+	// A second look at the same file shouldn't need the server again
+	var seen = atomic.LoadInt32(&requests)
+	var fi, _ = statSource(srv.URL + "/test-world.jp2?sig=def")
+	assert.Equal("test-world.jp2", fi.Name(), "stat of a remote source", t)
+	assert.Equal(seen, atomic.LoadInt32(&requests), "remote metadata is cached", t)
+
+	w = request("missing.jp2/info.json", t)
+	assert.Equal(404, w.StatusCode, "missing remote file is a 404", t)
+}
+
+func TestRemoteStream(t *testing.T) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 60, 40)), nil)
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "test.jpg", time.Time{}, bytes.NewReader(buf.Bytes()))
+	}))
+	defer srv.Close()
+
+	var oldBlocks = remoteBlocks
+	remoteBlocks, _ = rangeio.NewCache(64, time.Minute)
+	defer func() { remoteBlocks = oldBlocks }()
+
+	var src, err = img.OpenSource(srv.URL + "/test.jpg?sig=abc")
+	assert.NilError(err, "opening a remote source", t)
+	assert.Equal(srv.URL+"/test.jpg", src.Name(), "remote names drop the query", t)
+
+	var d img.Decoder
+	d, err = decodeStdImage(src)
+	assert.NilError(err, "stream decoders read remote sources", t)
+	assert.Equal(60, d.GetWidth(), "remote JPEG width", t)
+	var m image.Image
+	m, err = d.DecodeImage()
+	assert.NilError(err, "decoding a remote JPEG", t)
+	assert.Equal(40, m.Bounds().Dy(), "remote JPEG decoded height", t)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// headerRule is a static header added to every response whose path starts
+// with PathPrefix.  An empty PathPrefix matches all paths.
+type headerRule struct {
+	PathPrefix string
+	Name       string
+	Value      string
+}
+
+// readHeaderRules reads the ResponseHeaders table
+func readHeaderRules() ([]headerRule, error) {
+	var rules []headerRule
+	var err = viper.UnmarshalKey("ResponseHeaders", &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ResponseHeaders: %s", err)
+	}
+
+	for _, r := range rules {
+		if r.Name == "" || strings.ContainsAny(r.Name, " :\r\n") {
+			return nil, fmt.Errorf("invalid ResponseHeaders name %q", r.Name)
+		}
+		if strings.ContainsAny(r.Value, "\r\n") {
+			return nil, fmt.Errorf("invalid ResponseHeaders value for %q: must be a single line", r.Name)
+		}
+	}
+	return rules, nil
+}
+
+// headerMiddleware returns middleware which adds the configured static
+// headers to responses.  Headers are set before the handler runs, so a
+// handler's own value for a header takes precedence.
+func headerMiddleware(rules []headerRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			for _, r := range rules {
+				if strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+					w.Header().Set(r.Name, r.Value)
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
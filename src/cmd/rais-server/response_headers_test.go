@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestHeaderMiddleware(t *testing.T) {
+	var rules = []headerRule{
+		{Name: "Timing-Allow-Origin", Value: "*"},
+		{PathPrefix: "/iiif/", Name: "X-Frame-Options", Value: "DENY"},
+		{PathPrefix: "/iiif/", Name: "Content-Type", Value: "text/plain"},
+	}
+	var h = headerMiddleware(rules)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+	}))
+
+	var w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/iiif/foo/info.json", nil))
+	assert.Equal("*", w.Header().Get("Timing-Allow-Origin"), "global header", t)
+	assert.Equal("DENY", w.Header().Get("X-Frame-Options"), "prefixed header", t)
+	assert.Equal("image/jpeg", w.Header().Get("Content-Type"), "handler headers win", t)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/admin/stats.json", nil))
+	assert.Equal("*", w.Header().Get("Timing-Allow-Origin"), "global header on other routes", t)
+	assert.Equal("", w.Header().Get("X-Frame-Options"), "prefixed header is only on its route", t)
+}
+
+func TestReadHeaderRules(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("ResponseHeaders", []map[string]interface{}{{"PathPrefix": "/iiif", "Name": "X-Rights", "Value": "CC0"}})
+	var rules, err = readHeaderRules()
+	assert.NilError(err, "reading rules", t)
+	assert.Equal(headerRule{PathPrefix: "/iiif", Name: "X-Rights", Value: "CC0"}, rules[0], "rule", t)
+
+	viper.Set("ResponseHeaders", []map[string]interface{}{{"Name": "Bad Header", "Value": "x"}})
+	_, err = readHeaderRules()
+	assert.True(err != nil, "invalid header names are rejected", t)
+}
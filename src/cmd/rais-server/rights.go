@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"rais/src/iiif"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Rights holds the attribution, license, and logo advertised in info.json
+type Rights struct {
+	Attribution string
+	License     string
+	Logo        string
+}
+
+// RightsRule sets the rights metadata for images whose ID starts with Prefix
+type RightsRule struct {
+	Prefix string
+	Rights `mapstructure:",squash"`
+}
+
+// merge returns a copy of r with any values set in other replacing r's
+func (r Rights) merge(other Rights) Rights {
+	if other.Attribution != "" {
+		r.Attribution = other.Attribution
+	}
+	if other.License != "" {
+		r.License = other.License
+	}
+	if other.Logo != "" {
+		r.Logo = other.Logo
+	}
+	return r
+}
+
+// apply copies the rights values into info
+func (r Rights) apply(info *iiif.Info) {
+	info.Attribution = r.Attribution
+	info.License = r.License
+	info.Logo = r.Logo
+}
+
+// readRightsRules reads the RightsRules table from the config
+func readRightsRules() ([]RightsRule, error) {
+	var rules []RightsRule
+	var err = viper.UnmarshalKey("RightsRules", &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RightsRules: %s", err)
+	}
+
+	for _, r := range rules {
+		if r.Prefix == "" {
+			return nil, fmt.Errorf("RightsRules entries must have a Prefix")
+		}
+	}
+	return rules, nil
+}
+
+// rightsFor returns the server-wide rights metadata for id, with the first
+// matching RightsRule's values taking precedence over the global values
+func (ih *ImageHandler) rightsFor(id iiif.ID) Rights {
+	for _, rule := range ih.RightsRules {
+		if strings.HasPrefix(string(id), rule.Prefix) {
+			return ih.Rights.merge(rule.Rights)
+		}
+	}
+	return ih.Rights
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestInfoRights(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-rights")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "loans-c.jp2-rais.toml"), []byte(`License = "http://example.org/c"`+"\n"), 0644)
+
+	var ih = NewImageHandler("", "/iiif")
+	ih.SidecarPath = dir
+	ih.Rights = Rights{Attribution: "Example Library", License: "http://example.org/a"}
+	ih.RightsRules = []RightsRule{{Prefix: "loans", Rights: Rights{Attribution: "Lender"}}}
+
+	var info = ih.buildInfo("a.jp2", ImageInfo{Width: 400, Height: 200})
+	assert.Equal("Example Library", info.Attribution, "global attribution", t)
+	assert.Equal("http://example.org/a", info.License, "global license", t)
+	assert.Equal("", info.Logo, "no logo", t)
+
+	info = ih.buildInfo("loans-b.jp2", ImageInfo{Width: 400, Height: 200})
+	assert.Equal("Lender", info.Attribution, "prefix rule attribution", t)
+	assert.Equal("http://example.org/a", info.License, "license falls back to global", t)
+
+	info = ih.buildInfo("loans-c.jp2", ImageInfo{Width: 400, Height: 200})
+	assert.Equal("Lender", info.Attribution, "sidecar leaves prefix rule's attribution", t)
+	assert.Equal("http://example.org/c", info.License, "sidecar license", t)
+}
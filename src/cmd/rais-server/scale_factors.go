@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"rais/src/iiif"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ScaleFactorRule restricts the scale factors advertised for images whose ID
+// matches Pattern.  A nil Pattern matches all images.
+type ScaleFactorRule struct {
+	Pattern      *regexp.Regexp
+	ScaleFactors []int
+}
+
+// matches returns true if the rule applies to the given ID
+func (r ScaleFactorRule) matches(id string) bool {
+	return r.Pattern == nil || r.Pattern.MatchString(id)
+}
+
+// scaleFactorRuleFor returns the first scale factor rule matching id, or nil
+// if no rule applies
+func (ih *ImageHandler) scaleFactorRuleFor(id iiif.ID) *ScaleFactorRule {
+	for i := range ih.ScaleFactors {
+		if ih.ScaleFactors[i].matches(string(id)) {
+			return &ih.ScaleFactors[i]
+		}
+	}
+	return nil
+}
+
+// scaleFactorError returns a 400 error if the request's output resolution is
+// finer than the finest scale factor id's rule allows, so the cap holds for
+// clients that don't read info.json, such as crawlers.  When a rule allows
+// none of the image's levels, the coarsest level is advertised instead, and
+// requests at that resolution are allowed.
+func (ih *ImageHandler) scaleFactorError(id iiif.ID, info *iiif.Info, crop, scale image.Rectangle) *HandlerError {
+	var rule = ih.scaleFactorRuleFor(id)
+	if rule == nil || len(rule.ScaleFactors) == 0 {
+		return nil
+	}
+
+	var finest = rule.ScaleFactors[0]
+	for _, sf := range rule.ScaleFactors {
+		if sf < finest {
+			finest = sf
+		}
+	}
+	for _, ts := range info.Tiles {
+		for _, sf := range ts.ScaleFactors {
+			if sf < finest {
+				finest = sf
+			}
+		}
+	}
+
+	var limit = image.Rect(0, 0, (info.Width+finest-1)/finest, (info.Height+finest-1)/finest)
+	if exceedsDegraded(info, crop, scale, limit) {
+		return NewError(fmt.Sprintf("Invalid IIIF request: resolution is limited to 1/%d of full size", finest), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// filter returns the scale factors in sf which the rule allows.  If none are
+// allowed, the coarsest scale factor is returned so the image is still usable
+// by tiling viewers.
+func (r ScaleFactorRule) filter(sf []int) []int {
+	var allowed = make(map[int]bool)
+	for _, s := range r.ScaleFactors {
+		allowed[s] = true
+	}
+
+	var filtered []int
+	for _, s := range sf {
+		if allowed[s] {
+			filtered = append(filtered, s)
+		}
+	}
+
+	if len(filtered) == 0 && len(sf) > 0 {
+		filtered = sf[len(sf)-1:]
+	}
+	return filtered
+}
+
+// parseScaleFactors converts a comma-separated list of integers into a slice
+func parseScaleFactors(val string) ([]int, error) {
+	var list []int
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		var i, err = strconv.Atoi(s)
+		if err != nil || i < 1 {
+			return nil, fmt.Errorf("invalid scale factor %q", s)
+		}
+		list = append(list, i)
+	}
+	return list, nil
+}
+
+// readScaleFactorRules builds the list of scale factor rules from the
+// ScaleFactorRules table and the global ScaleFactors setting.  Per-pattern
+// rules are checked in order, and the global setting, if present, is last.
+func readScaleFactorRules() ([]ScaleFactorRule, error) {
+	var rawRules []struct {
+		Pattern      string
+		ScaleFactors []int
+	}
+	var err = viper.UnmarshalKey("ScaleFactorRules", &rawRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ScaleFactorRules: %s", err)
+	}
+
+	var rules []ScaleFactorRule
+	for _, raw := range rawRules {
+		var re *regexp.Regexp
+		re, err = regexp.Compile(raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ScaleFactorRules pattern %q: %s", raw.Pattern, err)
+		}
+		rules = append(rules, ScaleFactorRule{Pattern: re, ScaleFactors: raw.ScaleFactors})
+	}
+
+	var global = viper.GetString("ScaleFactors")
+	if global != "" {
+		var sf []int
+		sf, err = parseScaleFactors(global)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ScaleFactors: %s", err)
+		}
+		rules = append(rules, ScaleFactorRule{ScaleFactors: sf})
+	}
+
+	return rules, nil
+}
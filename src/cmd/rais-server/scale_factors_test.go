@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"rais/src/iiif"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestScaleFactorRuleFilter(t *testing.T) {
+	var r = ScaleFactorRule{ScaleFactors: []int{4, 8, 16}}
+	assert.Equal("[4 8]", fmt.Sprint(r.filter([]int{1, 2, 4, 8})), "only allowed scale factors", t)
+	assert.Equal("[2]", fmt.Sprint(r.filter([]int{1, 2})), "coarsest scale factor if none are allowed", t)
+}
+
+func TestBuildInfoScaleFactorRules(t *testing.T) {
+	var ih = NewImageHandler("", "/iiif")
+	ih.ScaleFactors = []ScaleFactorRule{
+		{Pattern: regexp.MustCompile("^low/"), ScaleFactors: []int{8, 16}},
+		{ScaleFactors: []int{2, 4, 8, 16}},
+	}
+	var i = ImageInfo{Width: 4096, Height: 4096, TileWidth: 512, TileHeight: 512, Levels: 6}
+
+	var info = ih.buildInfo("low/image.jp2", i)
+	assert.Equal("[8 16]", fmt.Sprint(info.Tiles[0].ScaleFactors), "pattern rule", t)
+
+	info = ih.buildInfo("other/image.jp2", i)
+	assert.Equal("[2 4 8 16]", fmt.Sprint(info.Tiles[0].ScaleFactors), "global rule", t)
+	assert.Equal("[{256 256} {512 512} {1024 1024} {2048 2048}]", fmt.Sprint(info.Sizes), "sizes follow the global rule", t)
+
+	info = ih.buildInfo("low/image.jp2", i)
+	assert.Equal("[{256 256} {512 512}]", fmt.Sprint(info.Sizes), "sizes follow the pattern rule", t)
+}
+
+func TestBuildInfoTileRules(t *testing.T) {
+	var ih = NewImageHandler("", "/iiif")
+	ih.TileWidth = 256
+	ih.ScaleFactors = []ScaleFactorRule{{ScaleFactors: []int{2, 4}}}
+	ih.TileRules = []TileRule{
+		{Prefix: "maps/", Width: 1024, Height: 512},
+		{Prefix: "sizeonly/", Width: 512},
+	}
+	var i = ImageInfo{Width: 4096, Height: 4096, TileWidth: 128, TileHeight: 128, Levels: 6}
+
+	var info = ih.buildInfo("maps/image.jp2", i)
+	assert.Equal(1024, info.Tiles[0].Width, "rule tile width", t)
+	assert.Equal(512, info.Tiles[0].Height, "rule tile height", t)
+	assert.Equal("[2 4]", fmt.Sprint(info.Tiles[0].ScaleFactors), "scale factors come from ScaleFactorRules", t)
+
+	info = ih.buildInfo("sizeonly/image.jp2", i)
+	assert.Equal(512, info.Tiles[0].Width, "rule tile width", t)
+	assert.Equal("[2 4]", fmt.Sprint(info.Tiles[0].ScaleFactors), "scale factors fall back to global rule", t)
+
+	info = ih.buildInfo("other/image.jp2", i)
+	assert.Equal(256, info.Tiles[0].Width, "server tile width", t)
+}
+
+func TestReadTileRules(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("TileRules", []map[string]interface{}{{"Prefix": "maps/", "Width": 1024}})
+	var rules, err = readTileRules()
+	assert.NilError(err, "reading rules", t)
+	assert.Equal(TileRule{Prefix: "maps/", Width: 1024}, rules[0], "rule", t)
+
+	viper.Set("TileRules", []map[string]interface{}{{"Prefix": "maps/", "Width": 1024, "ScaleFactors": []int{1, 2}}})
+	_, err = readTileRules()
+	assert.True(err != nil, "scale factors are rejected", t)
+}
+
+func TestScaleFactorError(t *testing.T) {
+	var ih = NewImageHandler("", "/iiif")
+	ih.ScaleFactors = []ScaleFactorRule{{Pattern: regexp.MustCompile("^capped/"), ScaleFactors: []int{2, 4}}}
+	var info = &iiif.Info{Width: 4096, Height: 4096, Tiles: []iiif.TileSize{{Width: 512, ScaleFactors: []int{2, 4}}}}
+	var full = image.Rect(0, 0, 4096, 4096)
+	var check = func(id iiif.ID, crop, scale image.Rectangle) *HandlerError {
+		return ih.scaleFactorError(id, info, crop, scale)
+	}
+
+	var e = check("capped/a.jp2", full, full)
+	assert.True(e != nil, "full resolution is refused", t)
+	assert.Equal(http.StatusBadRequest, e.Code, "refusal is a bad request", t)
+	assert.True(check("capped/a.jp2", image.Rect(0, 0, 512, 512), image.Rect(0, 0, 512, 512)) != nil, "full-resolution tiles are refused", t)
+	assert.True(check("capped/a.jp2", full, image.Rect(0, 0, 2048, 2048)) == nil, "the finest allowed scale is served", t)
+	assert.True(check("capped/a.jp2", image.Rect(0, 0, 1024, 1024), image.Rect(0, 0, 512, 512)) == nil, "tiles at an allowed scale are served", t)
+	assert.True(check("capped/a.jp2", full, image.Rect(0, 0, 100, 100)) == nil, "coarser sizes are served", t)
+	assert.True(check("other/a.jp2", full, full) == nil, "other IDs aren't capped", t)
+
+	// When no level is allowed, the coarsest is advertised and can be requested
+	info.Tiles[0].ScaleFactors = []int{1}
+	assert.True(check("capped/a.jp2", full, full) == nil, "the advertised fallback is served", t)
+}
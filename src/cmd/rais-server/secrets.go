@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// awsSecretVars are the environment variables the AWS SDK reads directly,
+// which we can populate from files since the SDK has no "_FILE" support
+var awsSecretVars = []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
+
+// readSecrets pulls sensitive settings from files so they needn't be exposed
+// in the environment or config file.  Any environment variable of the form
+// RAIS_<KEY>_FILE names a file holding the value for <KEY>, and every file in
+// SecretsDir is read as the setting matching its filename.  The AWS
+// credential variables are handled the same way, but are put into the
+// environment for the SDK to use.  Values read from files take precedence
+// over any other source.
+func readSecrets(environ []string) error {
+	var dir = viper.GetString("SecretsDir")
+	if dir != "" {
+		var infos, err = ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("unable to read SecretsDir: %s", err)
+		}
+		for _, info := range infos {
+			if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".") {
+				err = setSecret(info.Name(), filepath.Join(dir, info.Name()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, kv := range environ {
+		var parts = strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasSuffix(parts[0], "_FILE") {
+			continue
+		}
+		var name = strings.TrimSuffix(parts[0], "_FILE")
+		if strings.HasPrefix(name, "RAIS_") {
+			name = strings.TrimPrefix(name, "RAIS_")
+		} else if !isAWSSecret(name) {
+			continue
+		}
+		var err = setSecret(name, parts[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isAWSSecret(name string) bool {
+	for _, v := range awsSecretVars {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// setSecret reads the file at path and stores it in the setting called name
+func setSecret(name, path string) error {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read secret %q: %s", name, err)
+	}
+	var val = strings.TrimRight(string(data), "\r\n")
+
+	if isAWSSecret(name) {
+		return os.Setenv(name, val)
+	}
+	viper.Set(name, val)
+	return nil
+}
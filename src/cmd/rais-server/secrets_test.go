@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestReadSecrets(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-secrets-")
+	defer os.RemoveAll(dir)
+	defer viper.Reset()
+
+	var secretsDir = filepath.Join(dir, "secrets")
+	os.Mkdir(secretsDir, 0700)
+	ioutil.WriteFile(filepath.Join(secretsDir, "S3Zone"), []byte("us-east-1\n"), 0600)
+	ioutil.WriteFile(filepath.Join(secretsDir, "AWS_SECRET_ACCESS_KEY"), []byte("dirsecret"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("s3kr1t\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "keyid"), []byte("keyid"), 0600)
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	viper.Set("SecretsDir", secretsDir)
+	viper.Set("AdminToken", "from-config")
+	var err = readSecrets([]string{
+		"RAIS_ADMINTOKEN_FILE=" + filepath.Join(dir, "token"),
+		"AWS_ACCESS_KEY_ID_FILE=" + filepath.Join(dir, "keyid"),
+		"OTHER_FILE=/nonexistent",
+	})
+	assert.NilError(err, "reading secrets", t)
+	assert.Equal("us-east-1", viper.GetString("S3Zone"), "setting from SecretsDir", t)
+	assert.Equal("s3kr1t", viper.GetString("AdminToken"), "_FILE overrides config", t)
+	assert.Equal("keyid", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS key ID from _FILE", t)
+	assert.Equal("dirsecret", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret from SecretsDir", t)
+
+	err = readSecrets([]string{"RAIS_FOO_FILE=" + filepath.Join(dir, "missing")})
+	assert.True(err != nil, "missing secret files are an error", t)
+}
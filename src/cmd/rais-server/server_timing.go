@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverTiming collects the time spent in each phase of a request so it can
+// be reported in a Server-Timing header.  All methods are safe to call on a
+// nil *serverTiming, which simply records nothing.
+type serverTiming struct {
+	m       sync.Mutex
+	names   []string
+	elapsed map[string]time.Duration
+}
+
+func newServerTiming() *serverTiming {
+	return &serverTiming{elapsed: make(map[string]time.Duration)}
+}
+
+// since adds the time elapsed since start to the named phase
+func (st *serverTiming) since(name string, start time.Time) {
+	if st == nil {
+		return
+	}
+
+	var d = time.Since(start)
+	st.m.Lock()
+	if _, ok := st.elapsed[name]; !ok {
+		st.names = append(st.names, name)
+	}
+	st.elapsed[name] += d
+	st.m.Unlock()
+}
+
+// header returns the Server-Timing header value, with durations in
+// milliseconds as the spec requires
+func (st *serverTiming) header() string {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	var parts = make([]string, len(st.names))
+	for i, name := range st.names {
+		parts[i] = fmt.Sprintf("%s;dur=%.3f", name, float64(st.elapsed[name])/float64(time.Millisecond))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// timingWriter adds the Server-Timing header just before the response
+// headers are sent
+type timingWriter struct {
+	http.ResponseWriter
+	st   *serverTiming
+	sent bool
+}
+
+func (tw *timingWriter) setHeader() {
+	if tw.sent {
+		return
+	}
+	tw.sent = true
+	var h = tw.st.header()
+	if h != "" {
+		tw.Header().Set("Server-Timing", h)
+	}
+}
+
+// WriteHeader implements http.ResponseWriter
+func (tw *timingWriter) WriteHeader(code int) {
+	tw.setHeader()
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (tw *timingWriter) Write(data []byte) (int, error) {
+	tw.setHeader()
+	return tw.ResponseWriter.Write(data)
+}
+
+type timingKey struct{}
+
+// withServerTiming returns a copy of req carrying st
+func withServerTiming(req *http.Request, st *serverTiming) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), timingKey{}, st))
+}
+
+// getServerTiming returns the request's timing collector, or nil if there
+// isn't one
+func getServerTiming(req *http.Request) *serverTiming {
+	var st, _ = req.Context().Value(timingKey{}).(*serverTiming)
+	return st
+}
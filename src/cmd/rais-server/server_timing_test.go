@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestServerTiming(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.ServerTiming = true
+	h.TimingAllowOrigin = "https://viewer.example.org"
+
+	var w = httptest.NewRecorder()
+	var req, _ = http.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/100,/0/default.jpg", nil)
+	h.IIIFRoute(w, req)
+
+	var re = regexp.MustCompile(`^resolve;dur=[0-9.]+, decode;dur=[0-9.]+, encode;dur=[0-9.]+$`)
+	var timing = w.Header().Get("Server-Timing")
+	assert.True(re.MatchString(timing), "Server-Timing has each phase: "+timing, t)
+	assert.Equal("https://viewer.example.org", w.Header().Get("Timing-Allow-Origin"), "Timing-Allow-Origin", t)
+
+	h.ServerTiming = false
+	w = httptest.NewRecorder()
+	h.IIIFRoute(w, req)
+	assert.Equal("", w.Header().Get("Server-Timing"), "no Server-Timing when disabled", t)
+}
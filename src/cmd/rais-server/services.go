@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"rais/src/iiif"
+	"strings"
+)
+
+// ServiceRule adds a service block to info.json for images whose ID starts
+// with Prefix.  An empty prefix matches every image.  The service is copied
+// into info.json as-is, so it can describe any service, e.g., a physical
+// dimensions or search service.
+type ServiceRule struct {
+	Prefix  string
+	Service map[string]interface{}
+}
+
+// readServiceRules reads a JSON list of service rules from fp.  Services live
+// in their own file, rather than the main config, so their keys keep the
+// exact case JSON-LD requires.
+func readServiceRules(fp string) ([]ServiceRule, error) {
+	var data, err = ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ServiceRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+	for i, r := range rules {
+		if len(r.Service) == 0 {
+			return nil, fmt.Errorf("rule %d has no service", i+1)
+		}
+	}
+	return rules, nil
+}
+
+// addServices appends the configured and plugin-provided services for id to
+// info.  Every matching rule applies, not just the first.
+func (ih *ImageHandler) addServices(id iiif.ID, info *iiif.Info) {
+	for _, r := range ih.ServiceRules {
+		if strings.HasPrefix(string(id), r.Prefix) {
+			info.Service = append(info.Service, r.Service)
+		}
+	}
+	for _, fn := range infoServicesPlugins {
+		info.Service = append(info.Service, fn(id, info.ID)...)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestInfoServices(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-services")
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "services.json")
+	ioutil.WriteFile(fp, []byte(`[
+		{"Service": {"@context": "http://iiif.io/api/annex/services/physdim/1/context.json",
+			"profile": "http://iiif.io/api/annex/services/physdim", "physicalScale": 0.0025, "physicalUnits": "in"}},
+		{"Prefix": "maps/", "Service": {"@id": "https://example.org/search", "profile": "http://example.org/search"}}
+	]`), 0644)
+
+	var rules, err = readServiceRules(fp)
+	assert.NilError(err, "reading service rules", t)
+	assert.Equal(2, len(rules), "rule count", t)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.ServiceRules = rules
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", nil))
+
+	var info struct {
+		Service []map[string]interface{} `json:"service"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Equal(1, len(info.Service), "only the unprefixed service applies", t)
+	assert.Equal("in", info.Service[0]["physicalUnits"], "service keys keep their case", t)
+
+	ioutil.WriteFile(fp, []byte(`[{"Prefix": "maps/"}]`), 0644)
+	_, err = readServiceRules(fp)
+	assert.True(err != nil, "a rule without a service is an error", t)
+}
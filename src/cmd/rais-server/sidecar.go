@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// sidecarSuffixes are appended to an image's path (or its ID under
+// SidecarPath) to find its sidecar file, in order of preference
+var sidecarSuffixes = []string{"-rais.toml", "-rais.json"}
+
+// sidecarLimits holds the size limits a sidecar file may set.  Sidecars can
+// only tighten the server's limits, never loosen them.
+type sidecarLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxArea   int64
+}
+
+// dirSidecarNames are the names of sidecar files which apply to every image
+// in their directory and its subdirectories, in order of preference
+var dirSidecarNames = []string{"_rais.toml", "_rais.json"}
+
+// firstExisting returns the first of the given paths which exists, or ""
+func firstExisting(paths ...string) string {
+	for _, fp := range paths {
+		if _, err := os.Stat(fp); err == nil {
+			return fp
+		}
+	}
+	return ""
+}
+
+// sidecarFiles returns the sidecar files which apply to the given image, from
+// least to most specific: directory sidecars from the top of the tree down,
+// then the image's own sidecar.  With SidecarPath set, sidecars live in a
+// parallel tree named by ID; otherwise they sit next to the images, and
+// directories are searched up to TilePath.
+func (ih *ImageHandler) sidecarFiles(id iiif.ID) []string {
+	var base, root = ih.getIIIFPath(id), ih.TilePath
+	if ih.SidecarPath != "" {
+		base = filepath.Join(ih.SidecarPath, filepath.Clean("/"+string(id)))
+		root = ih.SidecarPath
+	}
+	if base == "" {
+		return nil
+	}
+
+	// Walk up from the image's directory, stopping at the root, or after the
+	// image's own directory if the image isn't under the root
+	var dirs []string
+	var dir = filepath.Dir(base)
+	root = filepath.Clean(root)
+	for {
+		dirs = append(dirs, dir)
+		if root == "" || root == "." || dir == root || !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	var files []string
+	for i := len(dirs) - 1; i >= 0; i-- {
+		var paths = make([]string, len(dirSidecarNames))
+		for j, name := range dirSidecarNames {
+			paths[j] = filepath.Join(dirs[i], name)
+		}
+		if fp := firstExisting(paths...); fp != "" {
+			files = append(files, fp)
+		}
+	}
+
+	var paths = make([]string, len(sidecarSuffixes))
+	for i, suffix := range sidecarSuffixes {
+		paths[i] = base + suffix
+	}
+	if fp := firstExisting(paths...); fp != "" {
+		files = append(files, fp)
+	}
+	return files
+}
+
+// decodeSidecar reads the sidecar file into each of the given values, so the
+// same file can hold both capabilities and limits.  Keys not in the file leave
+// the values' existing data alone.
+func decodeSidecar(fp string, vals ...interface{}) error {
+	var data, err = ioutil.ReadFile(fp)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vals {
+		if filepath.Ext(fp) == ".json" {
+			err = json.Unmarshal(data, v)
+		} else {
+			_, err = toml.Decode(string(data), v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sidecarSettings are an image's settings after applying its sidecar files
+type sidecarSettings struct {
+	fs     *iiif.FeatureSet
+	max    img.Constraint
+	rights Rights
+	dpi    int
+}
+
+// cachedSidecarSettings is a resolved set of sidecar settings and its
+// expiration time.  A zero expires value means the settings never expire.
+type cachedSidecarSettings struct {
+	settings sidecarSettings
+	expires  time.Time
+}
+
+// sidecars returns the settings which apply to the given image.  Resolving
+// them means checking every directory up to the root for sidecar files, and
+// they're needed several times per request, so they're kept in the sidecar
+// cache when it's enabled.
+func (ih *ImageHandler) sidecars(id iiif.ID) sidecarSettings {
+	if sidecarCache == nil {
+		return ih.resolveSidecars(id)
+	}
+
+	if v, ok := sidecarCache.Get(id); ok {
+		var c = v.(cachedSidecarSettings)
+		if c.expires.IsZero() || time.Now().Before(c.expires) {
+			return c.settings
+		}
+		sidecarCache.Remove(id)
+	}
+
+	var c = cachedSidecarSettings{settings: ih.resolveSidecars(id)}
+	if sidecarTTL > 0 {
+		c.expires = time.Now().Add(sidecarTTL)
+	}
+	sidecarCache.Add(id, c)
+	return c.settings
+}
+
+// resolveSidecars reads the sidecar files which apply to the given image and
+// combines them with the server's settings.  More specific sidecars override
+// capabilities, rights, and capture DPI set by less specific ones, but limits
+// can only ever be lowered.  A sidecar which can't be read is logged and
+// ignored.
+func (ih *ImageHandler) resolveSidecars(id iiif.ID) sidecarSettings {
+	var s = sidecarSettings{fs: ih.FeatureSet, max: ih.Maximums, rights: ih.rightsFor(id)}
+	var files = ih.sidecarFiles(id)
+	if len(files) == 0 {
+		return s
+	}
+
+	var fs = *ih.FeatureSet
+	var sd sidecarDPI
+	for _, fp := range files {
+		var next = fs
+		var nextDPI = sd
+		var lim sidecarLimits
+		var sr Rights
+		var err = decodeSidecar(fp, &next, &lim, &sr, &nextDPI)
+		if err != nil {
+			Logger.Errorf("Cannot parse sidecar file %q: %s", fp, err)
+			continue
+		}
+
+		fs, sd = next, nextDPI
+		s.rights = s.rights.merge(sr)
+		if lim.MaxWidth > 0 && lim.MaxWidth < s.max.Width {
+			s.max.Width = lim.MaxWidth
+		}
+		if lim.MaxHeight > 0 && lim.MaxHeight < s.max.Height {
+			s.max.Height = lim.MaxHeight
+		}
+		if lim.MaxArea > 0 && lim.MaxArea < s.max.Area {
+			s.max.Area = lim.MaxArea
+		}
+	}
+	s.fs = &fs
+	s.dpi = sd.CaptureDPI
+	return s
+}
+
+// overrides returns the feature set, size limits, and rights metadata for the
+// given image: the server's settings, adjusted by any sidecar files which
+// apply to it
+func (ih *ImageHandler) overrides(id iiif.ID) (*iiif.FeatureSet, img.Constraint, Rights) {
+	var s = ih.sidecars(id)
+	return s.fs, s.max, s.rights
+}
+
+// featuresFor returns the feature set which applies to the given image
+func (ih *ImageHandler) featuresFor(id iiif.ID) *iiif.FeatureSet {
+	var fs, _, _ = ih.overrides(id)
+	return fs
+}
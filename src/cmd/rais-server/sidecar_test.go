@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestSidecarOverrides(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-sidecar")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "special"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "special", "a.jp2-rais.toml"), []byte("Png = false\nMaxWidth = 100\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "special", "b.jp2-rais.json"), []byte(`{"MaxWidth": 99999, "MaxArea": 5000}`), 0644)
+
+	var ih = NewImageHandler("", "/iiif")
+	ih.FeatureSet = iiif.FeatureSet2()
+	ih.Maximums = unlimited
+	ih.Maximums.Width = 1000
+	ih.SidecarPath = dir
+
+	var fs, max, _ = ih.overrides("special/a.jp2")
+	assert.False(fs.Png, "sidecar disables PNG", t)
+	assert.True(fs.Jpg, "sidecar leaves JPG alone", t)
+	assert.True(ih.FeatureSet.Png, "global feature set is untouched", t)
+	assert.Equal(100, max.Width, "sidecar lowers max width", t)
+
+	fs, max, _ = ih.overrides("special/b.jp2")
+	assert.True(fs.Png, "JSON sidecar leaves PNG alone", t)
+	assert.Equal(1000, max.Width, "sidecar can't raise max width", t)
+	assert.Equal(int64(5000), max.Area, "JSON sidecar lowers max area", t)
+
+	_, max, _ = ih.overrides("special/c.jp2")
+	assert.Equal(ih.Maximums, max, "no sidecar", t)
+
+	var info = ih.buildInfo("special/a.jp2", ImageInfo{Width: 400, Height: 200})
+	assert.Equal(100, info.Profile.MaxWidth, "info advertises the sidecar's limit", t)
+}
+
+func TestDirectorySidecars(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-sidecar")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "maps", "large"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "_rais.toml"), []byte("Png = false\nAttribution = \"Library\"\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "maps", "_rais.json"), []byte(`{"MaxWidth": 500, "License": "http://example.org/l"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "maps", "large", "_rais.toml"), []byte("Png = true\nMaxWidth = 800\nMaxHeight = 300\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "maps", "large", "a.jp2-rais.toml"), []byte("Attribution = \"Donor\"\n"), 0644)
+
+	var ih = NewImageHandler(dir, "/iiif")
+	ih.FeatureSet = iiif.FeatureSet2()
+	ih.Maximums = unlimited
+
+	var fs, max, rights = ih.overrides("top.jp2")
+	assert.False(fs.Png, "top-level directory sidecar disables PNG", t)
+	assert.Equal("Library", rights.Attribution, "top-level attribution", t)
+	assert.Equal(unlimited, max, "no limits at the top", t)
+
+	fs, max, rights = ih.overrides("maps/b.jp2")
+	assert.False(fs.Png, "PNG is still disabled in subdirectories", t)
+	assert.Equal(500, max.Width, "maps directory lowers max width", t)
+	assert.Equal("Library", rights.Attribution, "attribution is inherited", t)
+	assert.Equal("http://example.org/l", rights.License, "maps license", t)
+
+	fs, max, rights = ih.overrides("maps/large/a.jp2")
+	assert.True(fs.Png, "nested directory re-enables PNG", t)
+	assert.Equal(500, max.Width, "nested directory can't raise the parent's limit", t)
+	assert.Equal(300, max.Height, "nested directory lowers max height", t)
+	assert.Equal("Donor", rights.Attribution, "image sidecar wins", t)
+	assert.Equal("http://example.org/l", rights.License, "license is inherited", t)
+}
+
+func TestSidecarCache(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-sidecar")
+	defer os.RemoveAll(dir)
+	var sidecar = filepath.Join(dir, "a.jp2-rais.toml")
+	ioutil.WriteFile(sidecar, []byte("MaxWidth = 100\nCaptureDPI = 300\n"), 0644)
+
+	var ih = NewImageHandler("", "/iiif")
+	ih.FeatureSet = iiif.FeatureSet2()
+	ih.Maximums = unlimited
+	ih.SidecarPath = dir
+
+	defer func() { sidecarCache, sidecarTTL = nil, 0 }()
+	sidecarCache, _ = lru.New(10)
+	sidecarTTL = time.Hour
+
+	var s = ih.sidecars("a.jp2")
+	assert.Equal(100, s.max.Width, "sidecar limit", t)
+	assert.Equal(300, s.dpi, "sidecar capture DPI", t)
+
+	ioutil.WriteFile(sidecar, []byte("MaxWidth = 50\n"), 0644)
+	assert.Equal(100, ih.sidecars("a.jp2").max.Width, "settings are cached", t)
+
+	sidecarCache.Remove(iiif.ID("a.jp2"))
+	s = ih.sidecars("a.jp2")
+	assert.Equal(50, s.max.Width, "expired settings are re-read", t)
+	assert.Equal(0, s.dpi, "capture DPI removed from the sidecar", t)
+
+	sidecarTTL = time.Millisecond
+	sidecarCache.Purge()
+	ih.sidecars("a.jp2")
+	time.Sleep(5 * time.Millisecond)
+	ioutil.WriteFile(sidecar, []byte("MaxWidth = 25\n"), 0644)
+	assert.Equal(25, ih.sidecars("a.jp2").max.Width, "settings past the TTL are re-read", t)
+}
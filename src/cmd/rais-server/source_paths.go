@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/rangeio"
+	"strings"
+)
+
+// SourcePolicy says which resolved source paths must be confined to the
+// handler's SourceRoots
+type SourcePolicy string
+
+// Source path policies
+const (
+	// SourcesTilePath confines paths built from TilePath, but trusts paths
+	// returned by IDToPath plugins
+	SourcesTilePath SourcePolicy = "tilepath"
+	// SourcesAll confines plugin paths as well
+	SourcesAll SourcePolicy = "all"
+	// SourcesOff disables the checks
+	SourcesOff SourcePolicy = "off"
+)
+
+// confinedSource returns true if the policy allows reading the source at fp.
+// Symlinks are resolved before fp is compared to the handler's SourceRoots,
+// so neither ".." in an ID nor a link can escape them.  A path that doesn't
+// exist is allowed if it's lexically under a root; opening it fails anyway.
+// Remote URLs can only come from plugins, and aren't files, so they're
+// always allowed.
+func (ih *ImageHandler) confinedSource(id iiif.ID, fp string, fromPlugin bool) bool {
+	if fp == "" || ih.SourcePolicy == SourcesOff || (fromPlugin && ih.SourcePolicy != SourcesAll) {
+		return true
+	}
+	if fromPlugin && rangeio.IsURL(fp) {
+		return true
+	}
+
+	var resolved, err = filepath.EvalSymlinks(fp)
+	if os.IsNotExist(err) {
+		resolved, err = filepath.Abs(fp)
+	}
+	if err != nil {
+		Logger.Warnf("Unable to resolve source path %q for ID %q: %s", fp, id, err)
+		return false
+	}
+
+	for _, root := range ih.SourceRoots {
+		var r, err = filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if isUnder(r, resolved) {
+			return true
+		}
+	}
+
+	Logger.Warnf("Refusing to read %q for ID %q: it's outside the allowed source paths", resolved, id)
+	return false
+}
+
+// isUnder returns true if path is root or inside it
+func isUnder(root, path string) bool {
+	root, _ = filepath.Abs(root)
+	path, _ = filepath.Abs(path)
+	var rel, err = filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
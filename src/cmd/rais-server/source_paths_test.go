@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestConfinedSources(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-sources-")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var root, outside, extra = filepath.Join(dir, "images"), filepath.Join(dir, "secret"), filepath.Join(dir, "extra")
+	for _, d := range []string{root, outside, extra} {
+		os.Mkdir(d, 0755)
+	}
+	ioutil.WriteFile(filepath.Join(root, "ok.jp2"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(outside, "passwd"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(extra, "linked.jp2"), nil, 0644)
+	os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(root, "escape.jp2"))
+	os.Symlink(filepath.Join(extra, "linked.jp2"), filepath.Join(root, "linked.jp2"))
+
+	var ih = NewImageHandler(root, "/iiif")
+	assert.Equal(root+"/ok.jp2", ih.getIIIFPath("ok.jp2"), "plain file", t)
+	assert.Equal(root+"/missing.jp2", ih.getIIIFPath("missing.jp2"), "missing files are left to report a 404", t)
+	assert.Equal("", ih.getIIIFPath("../secret/passwd"), "dot-dot escape", t)
+	assert.Equal("", ih.getIIIFPath("escape.jp2"), "symlink escape", t)
+	assert.Equal("", ih.getIIIFPath("linked.jp2"), "link into an unlisted root", t)
+
+	ih.SourceRoots = append(ih.SourceRoots, extra)
+	assert.Equal(root+"/linked.jp2", ih.getIIIFPath("linked.jp2"), "link into an allowed root", t)
+
+	idToPathPlugins = []func(iiif.ID) (string, error){
+		func(id iiif.ID) (string, error) { return filepath.Join(outside, "passwd"), nil },
+	}
+	defer func() { idToPathPlugins = nil }()
+	assert.Equal(filepath.Join(outside, "passwd"), ih.getIIIFPath("plugin"), "plugins are trusted by default", t)
+	ih.SourcePolicy = SourcesAll
+	assert.Equal("", ih.getIIIFPath("plugin"), "plugins are confined with the all policy", t)
+
+	idToPathPlugins = nil
+	ih.SourcePolicy = SourcesOff
+	assert.Equal(root+"/escape.jp2", ih.getIIIFPath("escape.jp2"), "checks can be turned off", t)
+}
@@ -9,7 +9,8 @@
 
 type plugStats struct {
 	Path      string
-	Functions []string
+	Functions []string `json:",omitempty"`
+	Error     string   `json:",omitempty"`
 }
 
 type cacheStats struct {
@@ -52,14 +53,24 @@ func (cs *cacheStats) Set() {
 // know only one thread can possibly exist!  (e.g., when first setting up the
 // object)
 type serverStats struct {
-	m           sync.Mutex
-	InfoCache   cacheStats
-	TileCache   cacheStats
-	Plugins     []plugStats
-	RAISVersion string
-	RAISBuild   string
-	ServerStart time.Time
-	Uptime      string
+	m              sync.Mutex
+	InfoCache      cacheStats
+	TileCache      cacheStats
+	TileCacheDisk  cacheStats
+	ThumbnailCache cacheStats
+	DeepZoomCache  cacheStats
+	InfoDocCache   cacheStats
+	ProxyCache     cacheStats
+	AuthCache      cacheStats
+	DecodeQueue    decodeQueueSnapshot
+	MostRequested  []IDSummary `json:",omitempty"`
+	Slowest        []IDSummary `json:",omitempty"`
+	Plugins        []plugStats
+	SkippedPlugins []plugStats `json:",omitempty"`
+	RAISVersion    string
+	RAISBuild      string
+	ServerStart    time.Time
+	Uptime         string
 }
 
 // Serialize writes the stats data to w in JSON format
@@ -82,6 +93,35 @@ func (s *serverStats) calculateDerivedStats() {
 		s.TileCache.setHitPercent()
 		s.TileCache.Length = tileCache.Len()
 	}
+	if tileCacheDisk != nil {
+		s.TileCacheDisk.setHitPercent()
+		s.TileCacheDisk.Length = tileCacheDisk.Len()
+	}
+	if thumbnailCache != nil {
+		s.ThumbnailCache.setHitPercent()
+		s.ThumbnailCache.Length = thumbnailCache.Len()
+	}
+	if deepZoomCache != nil {
+		s.DeepZoomCache.setHitPercent()
+		s.DeepZoomCache.Length = deepZoomCache.Len()
+	}
+	if infoDocCache != nil {
+		s.InfoDocCache.setHitPercent()
+		s.InfoDocCache.Length = infoDocCache.Len()
+	}
+	if idStats != nil {
+		s.MostRequested, s.Slowest = idStats.top(topIDCount, time.Now())
+	}
+	if proxyCache != nil {
+		s.ProxyCache.setHitPercent()
+		s.ProxyCache.Length = proxyCache.Len()
+	}
+
+	if authDecisions != nil {
+		s.AuthCache.setHitPercent()
+		s.AuthCache.Length = authDecisions.Len()
+	}
+	s.DecodeQueue = decodeQueue.snapshot(time.Now())
 
 	s.m.Unlock()
 }
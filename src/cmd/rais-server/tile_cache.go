@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/spf13/viper"
+)
+
+// tileCacher is the interface all tile cache policies must implement
+type tileCacher interface {
+	Get(key interface{}) (interface{}, bool)
+	Add(key, value interface{})
+	Purge()
+	Len() int
+}
+
+// lruTileCache adapts a plain LRU cache to the tileCacher interface
+type lruTileCache struct {
+	*lru.Cache
+}
+
+// Add implements tileCacher
+func (c lruTileCache) Add(key, value interface{}) {
+	c.Cache.Add(key, value)
+}
+
+// tileCacheMaxBytes is the largest encoded image we'll put into the tile
+// cache, so a handful of large images can't evict thousands of small tiles.
+// Zero means no limit.
+var tileCacheMaxBytes int
+
+// newTileCache returns a tile cache using the admission policy described by
+// the configuration
+func newTileCache(size int) (tileCacher, error) {
+	var policy = viper.GetString("TileCachePolicy")
+	switch policy {
+	case "2q":
+		var recent = viper.GetFloat64("TileCacheRecentRatio")
+		var ghost = viper.GetFloat64("TileCacheGhostRatio")
+		if recent <= 0 || recent >= 1 || ghost <= 0 || ghost >= 1 {
+			return nil, fmt.Errorf("TileCacheRecentRatio and TileCacheGhostRatio must be between 0 and 1")
+		}
+		Logger.Debugf("Creating a 2Q tile cache (size %d, recent ratio %g, ghost ratio %g)", size, recent, ghost)
+		return lru.New2QParams(size, recent, ghost)
+	case "tinylfu":
+		Logger.Debugf("Creating a TinyLFU tile cache (size %d)", size)
+		return newTinyLFUTileCache(size)
+	case "lru":
+		Logger.Debugf("Creating an LRU tile cache (size %d)", size)
+		var c, err = lru.New(size)
+		if err != nil {
+			return nil, err
+		}
+		return lruTileCache{c}, nil
+	}
+
+	return nil, fmt.Errorf("unknown TileCachePolicy %q", policy)
+}
+
+// diskTileCache stores encoded tiles as files in a directory.  An LRU index of
+// the files keeps the directory from holding more than a fixed number of
+// tiles; evicted tiles are deleted.
+type diskTileCache struct {
+	dir   string
+	index *lru.Cache
+}
+
+// newDiskTileCache sets up a disk cache in dir holding up to size tiles.
+// Tiles already in dir are indexed, oldest first, so the cache survives a
+// restart.
+func newDiskTileCache(dir string, size int) (*diskTileCache, error) {
+	var err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create tile cache directory %q: %s", dir, err)
+	}
+
+	var c = &diskTileCache{dir: dir}
+	c.index, err = lru.NewWithEvict(size, func(name, _ interface{}) {
+		os.Remove(filepath.Join(c.dir, name.(string)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	infos, err = ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tile cache directory %q: %s", dir, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, info := range infos {
+		if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".tmp-") {
+			c.index.Add(info.Name(), nil)
+		}
+	}
+	return c, nil
+}
+
+// name returns the file name used for a given cache key
+func (c *diskTileCache) name(key interface{}) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key.(string))))
+}
+
+// Get implements tileCacher
+func (c *diskTileCache) Get(key interface{}) (interface{}, bool) {
+	var name = c.name(key)
+	if _, ok := c.index.Get(name); !ok {
+		return nil, false
+	}
+
+	var data, err = ioutil.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		c.index.Remove(name)
+		return nil, false
+	}
+	return data, true
+}
+
+// Add implements tileCacher.  The data is written to a temporary file and
+// renamed so concurrent readers never see a partial tile.
+func (c *diskTileCache) Add(key, value interface{}) {
+	var name = c.name(key)
+	var f, err = ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		Logger.Errorf("Unable to create tile cache file: %s", err)
+		return
+	}
+	_, err = f.Write(value.([]byte))
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		Logger.Errorf("Unable to write tile cache file: %s", err)
+		return
+	}
+	c.index.Add(name, nil)
+}
+
+// Purge implements tileCacher
+func (c *diskTileCache) Purge() {
+	c.index.Purge()
+}
+
+// Len implements tileCacher
+func (c *diskTileCache) Len() int {
+	return c.index.Len()
+}
+
+// tieredTileCache puts a small, fast cache in front of a large, slow one.
+// Tiles are written to both tiers, so a tile evicted from the fast tier can
+// still be found in the slow tier, and slow-tier hits are promoted back into
+// the fast tier.  Hits and misses on the slow tier are tracked separately in
+// slowStats.
+type tieredTileCache struct {
+	fast      tileCacher
+	slow      tileCacher
+	slowStats *cacheStats
+}
+
+// Get implements tileCacher
+func (c *tieredTileCache) Get(key interface{}) (interface{}, bool) {
+	var data, ok = c.fast.Get(key)
+	if ok {
+		return data, true
+	}
+
+	c.slowStats.Get()
+	data, ok = c.slow.Get(key)
+	if ok {
+		c.slowStats.Hit()
+		c.fast.Add(key, data)
+	}
+	return data, ok
+}
+
+// Add implements tileCacher
+func (c *tieredTileCache) Add(key, value interface{}) {
+	c.fast.Add(key, value)
+	c.slow.Add(key, value)
+}
+
+// Purge implements tileCacher
+func (c *tieredTileCache) Purge() {
+	c.fast.Purge()
+	c.slow.Purge()
+}
+
+// Len implements tileCacher, returning the size of the fast tier; the slow
+// tier's size is reported separately
+func (c *tieredTileCache) Len() int {
+	return c.fast.Len()
+}
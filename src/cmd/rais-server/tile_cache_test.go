@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"rais/src/fakehttp"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestNewTileCache(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("TileCacheRecentRatio", 0.1)
+	viper.Set("TileCacheGhostRatio", 0.2)
+
+	for _, policy := range []string{"2q", "lru", "tinylfu"} {
+		viper.Set("TileCachePolicy", policy)
+		var c, err = newTileCache(10)
+		assert.NilError(err, policy+" cache", t)
+		c.Add("a", []byte("data"))
+		var _, ok = c.Get("a")
+		assert.True(ok, policy+" cache stores data", t)
+	}
+
+	viper.Set("TileCachePolicy", "lfu")
+	var _, err = newTileCache(10)
+	assert.True(err != nil, "unknown policies are an error", t)
+
+	viper.Set("TileCachePolicy", "2q")
+	viper.Set("TileCacheGhostRatio", 1.5)
+	_, err = newTileCache(10)
+	assert.True(err != nil, "invalid 2Q ratios are an error", t)
+}
+
+func TestTinyLFUTileCache(t *testing.T) {
+	var c, err = newTinyLFUTileCache(100)
+	assert.NilError(err, "creating cache", t)
+
+	c.Add("popular", []byte("p"))
+	for i := 0; i < 5; i++ {
+		c.Get("popular")
+	}
+
+	// A scan of one-off tiles fills the cache, but can't push out a tile
+	// that's requested more often
+	for i := 0; i < 500; i++ {
+		c.Add(fmt.Sprintf("scan-%d", i), []byte("s"))
+	}
+	assert.Equal(100, c.Len(), "cache is full", t)
+	var _, ok = c.Get("popular")
+	assert.True(ok, "popular tile survives the scan", t)
+
+	// A tile requested repeatedly before it's cached is admitted once it
+	// leaves the window
+	for i := 0; i < 3; i++ {
+		c.Get("rising")
+	}
+	c.Add("rising", []byte("r"))
+	c.Add("one-off", []byte("o"))
+	_, ok = c.Get("rising")
+	assert.True(ok, "frequently requested tile is admitted", t)
+	_, ok = c.Get("scan-499")
+	assert.False(ok, "one-off tiles aren't admitted over others", t)
+
+	c.Purge()
+	assert.Equal(0, c.Len(), "purged cache is empty", t)
+}
+
+func TestTileCacheMaxBytes(t *testing.T) {
+	var oldCache, oldMax = tileCache, tileCacheMaxBytes
+	defer func() { tileCache, tileCacheMaxBytes = oldCache, oldMax }()
+	viper.Set("TileCachePolicy", "lru")
+	defer viper.Reset()
+	tileCache, _ = newTileCache(10)
+
+	var h = NewImageHandler(rootDir(), "/iiif")
+	var get = func(size string) {
+		var req, _ = http.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/"+size+",/0/default.jpg", nil)
+		h.IIIFRoute(fakehttp.NewResponseWriter(), req)
+	}
+
+	tileCacheMaxBytes = 10
+	get("100")
+	assert.Equal(0, tileCache.Len(), "large tile isn't cached", t)
+
+	tileCacheMaxBytes = 0
+	get("200")
+	assert.Equal(1, tileCache.Len(), "tile is cached without a size limit", t)
+}
+
+func TestCachePartitions(t *testing.T) {
+	var oldTiles, oldThumbs, oldDZ = tileCache, thumbnailCache, deepZoomCache
+	defer func() { tileCache, thumbnailCache, deepZoomCache = oldTiles, oldThumbs, oldDZ }()
+	viper.Set("TileCachePolicy", "lru")
+	defer viper.Reset()
+	tileCache, _ = newTileCache(10)
+	thumbnailCache, _ = newTileCache(10)
+	deepZoomCache, _ = newTileCache(10)
+
+	var h = NewImageHandler(rootDir(), "/iiif")
+	var get = func(region, size string) {
+		var req, _ = http.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/"+region+"/"+size+"/0/default.jpg", nil)
+		h.IIIFRoute(fakehttp.NewResponseWriter(), req)
+	}
+
+	get("full", "150,")
+	get("0,0,256,256", "256,")
+	get("256,0,256,256", "128,")
+	assert.Equal(1, thumbnailCache.Len(), "thumbnail partition", t)
+	assert.Equal(2, tileCache.Len(), "tile partition", t)
+
+	var dzi = func() {
+		var req = httptest.NewRequest("GET", "/dzi/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2_files/9/0_0.jpg", nil)
+		h.DZIRoute(httptest.NewRecorder(), req)
+	}
+	dzi()
+	assert.Equal(1, deepZoomCache.Len(), "Deep Zoom partition", t)
+	assert.Equal(1, thumbnailCache.Len(), "Deep Zoom tiles don't touch the thumbnail partition", t)
+
+	// Without their own partitions, thumbnails and Deep Zoom tiles share the
+	// tile cache
+	thumbnailCache, deepZoomCache = nil, nil
+	get("full", "100,")
+	assert.Equal(3, tileCache.Len(), "shared tile cache", t)
+	dzi()
+	assert.Equal(4, tileCache.Len(), "Deep Zoom tiles share the tile cache", t)
+}
+
+func TestTieredTileCache(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-tiles")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var disk *diskTileCache
+	disk, err = newDiskTileCache(dir, 3)
+	assert.NilError(err, "creating disk cache", t)
+	var ram, _ = lru.New(1)
+	var st cacheStats
+	var c = &tieredTileCache{fast: lruTileCache{ram}, slow: disk, slowStats: &st}
+
+	c.Add("a", []byte("aaa"))
+	c.Add("b", []byte("bbb"))
+	assert.Equal(1, c.Len(), "fast tier only holds one tile", t)
+	assert.Equal(2, disk.Len(), "both tiles are on disk", t)
+
+	var data, ok = c.Get("a")
+	assert.True(ok, "evicted tile is found on disk", t)
+	assert.Equal("aaa", string(data.([]byte)), "disk data", t)
+	assert.Equal(uint64(1), st.GetHits, "disk hit is counted", t)
+	_, ok = ram.Get("a")
+	assert.True(ok, "disk hit is promoted to the fast tier", t)
+
+	c.Add("c", []byte("ccc"))
+	c.Add("d", []byte("ddd"))
+	_, ok = disk.Get("b")
+	assert.False(ok, "oldest tile is evicted from disk", t)
+	var files, _ = ioutil.ReadDir(dir)
+	assert.Equal(3, len(files), "evicted tile's file is removed", t)
+
+	disk, err = newDiskTileCache(dir, 3)
+	assert.NilError(err, "reopening disk cache", t)
+	assert.Equal(3, disk.Len(), "existing tiles are indexed at startup", t)
+	data, ok = disk.Get("d")
+	assert.True(ok, "reopened cache finds existing tiles", t)
+	assert.Equal("ddd", string(data.([]byte)), "reopened disk data", t)
+
+	c.slow = disk
+	c.Purge()
+	files, _ = ioutil.ReadDir(dir)
+	assert.Equal(0, len(files), "purging removes all tile files", t)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"rais/src/iiif"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// TileRule overrides the tile size advertised in info.json for images whose
+// ID starts with Prefix.  Scale factors are left to the ScaleFactorRules.
+type TileRule struct {
+	Prefix string
+	Width  int
+	Height int
+}
+
+// readTileRules reads the TileRules table from the config
+func readTileRules() ([]TileRule, error) {
+	var raw []struct {
+		Prefix       string
+		Width        int
+		Height       int
+		ScaleFactors []int
+	}
+	var err = viper.UnmarshalKey("TileRules", &raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TileRules: %s", err)
+	}
+
+	var rules []TileRule
+	for _, r := range raw {
+		if r.Prefix == "" {
+			return nil, fmt.Errorf("TileRules entries must have a Prefix")
+		}
+		if len(r.ScaleFactors) > 0 {
+			return nil, fmt.Errorf("TileRules entry %q sets ScaleFactors, which belong in ScaleFactorRules", r.Prefix)
+		}
+		if r.Width < 0 || r.Height < 0 {
+			return nil, fmt.Errorf("TileRules entry %q has a negative tile size", r.Prefix)
+		}
+		if r.Width == 0 {
+			return nil, fmt.Errorf("TileRules entry %q has no Width", r.Prefix)
+		}
+		rules = append(rules, TileRule{Prefix: r.Prefix, Width: r.Width, Height: r.Height})
+	}
+
+	return rules, nil
+}
+
+// tileRuleFor returns the first tile rule whose prefix matches id, or nil if
+// none match
+func (ih *ImageHandler) tileRuleFor(id iiif.ID) *TileRule {
+	for i := range ih.TileRules {
+		if strings.HasPrefix(string(id), ih.TileRules[i].Prefix) {
+			return &ih.TileRules[i]
+		}
+	}
+	return nil
+}
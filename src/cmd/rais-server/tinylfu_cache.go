@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// tinyLFUTileCache is a W-TinyLFU cache: new tiles go into a small LRU
+// window, and tiles pushed out of the window only take the place of the main
+// cache's least recently used tile if they've been requested more often.
+// Request frequencies are estimated with a count-min sketch which is halved
+// periodically, so tiles which were popular long ago don't stay forever.
+// This keeps popular tiles cached through scans of one-off requests, such as
+// a crawler walking every tile of every image.  The mutex guards the window,
+// main cache, and sketch together, so they use the non-locking LRU type.
+type tinyLFUTileCache struct {
+	m        sync.Mutex
+	window   *simplelru.LRU
+	main     *simplelru.LRU
+	mainSize int
+	sketch   *countMinSketch
+}
+
+// tinyLFUWindowRatio is the share of a TinyLFU cache given to the window
+const tinyLFUWindowRatio = 0.01
+
+func newTinyLFUTileCache(size int) (*tinyLFUTileCache, error) {
+	var windowSize = int(float64(size) * tinyLFUWindowRatio)
+	if windowSize < 1 {
+		windowSize = 1
+	}
+	var mainSize = size - windowSize
+	if mainSize < 1 {
+		mainSize = 1
+	}
+
+	var c = &tinyLFUTileCache{mainSize: mainSize, sketch: newCountMinSketch(size)}
+	var err error
+	c.main, err = simplelru.NewLRU(mainSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.window, err = simplelru.NewLRU(windowSize, c.admit)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// admit is called with tiles pushed out of the window.  The main cache takes
+// them if it has room, or if they're requested more often than its victim.
+func (c *tinyLFUTileCache) admit(key, value interface{}) {
+	if c.main.Len() < c.mainSize {
+		c.main.Add(key, value)
+		return
+	}
+	var victim, _, ok = c.main.GetOldest()
+	if !ok || c.sketch.estimate(key) > c.sketch.estimate(victim) {
+		c.main.Add(key, value)
+	}
+}
+
+// Get implements tileCacher
+func (c *tinyLFUTileCache) Get(key interface{}) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.sketch.increment(key)
+	if v, ok := c.window.Get(key); ok {
+		return v, true
+	}
+	return c.main.Get(key)
+}
+
+// Add implements tileCacher
+func (c *tinyLFUTileCache) Add(key, value interface{}) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.main.Contains(key) {
+		c.main.Add(key, value)
+		return
+	}
+	c.window.Add(key, value)
+}
+
+// Purge implements tileCacher.  Frequencies are kept, since they describe
+// requests rather than what's cached.
+func (c *tinyLFUTileCache) Purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.window.Purge()
+	c.main.Purge()
+}
+
+// Len implements tileCacher
+func (c *tinyLFUTileCache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.window.Len() + c.main.Len()
+}
+
+// countMinSketch estimates how often keys have been seen using a few rows of
+// small saturating counters, each row indexed by a different hash of the key
+type countMinSketch struct {
+	size      int
+	rows      [4][]uint8
+	additions int
+}
+
+// sketchMaxCount is where counters saturate; TinyLFU only needs to tell
+// popular tiles from unpopular ones, not exact counts
+const sketchMaxCount = 15
+
+func newCountMinSketch(size int) *countMinSketch {
+	var s = &countMinSketch{size: size}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size*2)
+	}
+	return s
+}
+
+// indexes returns the key's counter in each row
+func (s *countMinSketch) indexes(key interface{}) [4]int {
+	var h = fnv.New64a()
+	if str, ok := key.(string); ok {
+		h.Write([]byte(str))
+	} else {
+		fmt.Fprint(h, key)
+	}
+	var sum = h.Sum64()
+	var lo, hi = uint32(sum), uint32(sum >> 32)
+
+	var idx [4]int
+	for i := range idx {
+		idx[i] = int((lo + uint32(i)*hi) % uint32(len(s.rows[i])))
+	}
+	return idx
+}
+
+// increment records a request for key.  Once there have been ten times as
+// many requests as the cache holds, all counters are halved.
+func (s *countMinSketch) increment(key interface{}) {
+	for i, n := range s.indexes(key) {
+		if s.rows[i][n] < sketchMaxCount {
+			s.rows[i][n]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.size*10 {
+		s.additions = 0
+		for _, row := range s.rows {
+			for n := range row {
+				row[n] >>= 1
+			}
+		}
+	}
+}
+
+// estimate returns the approximate number of requests seen for key
+func (s *countMinSketch) estimate(key interface{}) uint8 {
+	var min uint8 = sketchMaxCount
+	for i, n := range s.indexes(key) {
+		if s.rows[i][n] < min {
+			min = s.rows[i][n]
+		}
+	}
+	return min
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/plugins"
+	"rais/src/transform"
+	"strings"
+)
+
+// validation describes what RAIS would do with a IIIF request, without
+// actually decoding any images
+type validation struct {
+	Path         string
+	Status       int
+	Message      string `json:",omitempty"`
+	ID           iiif.ID
+	SourceWidth  int
+	SourceHeight int
+	Region       image.Rectangle
+	OutputWidth  int
+	OutputHeight int
+	CanonicalURL string
+
+	// DecodeLevel and DecodePixels estimate the cost of a request: the number
+	// of times the resolution can be halved before decoding, and the number of
+	// pixels which would be decoded at that resolution
+	DecodeLevel  int
+	DecodePixels int64
+}
+
+// ValidateRoute handles dry-run requests: the "url" query parameter is
+// validated against the feature set and image dimensions, and a JSON
+// description of the response RAIS would generate is returned.  The value can
+// be a full URL or just a path, and should include the IIIF web path prefix.
+func (ih *ImageHandler) ValidateRoute(w http.ResponseWriter, req *http.Request) {
+	var raw = req.URL.Query().Get("url")
+	if raw == "" {
+		http.Error(w, `the "url" parameter is required`, http.StatusBadRequest)
+		return
+	}
+
+	var u, err = url.Parse(raw)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var v = ih.validate(req, strings.TrimPrefix(u.Path, ih.WebPathPrefix+"/"))
+	if v.CanonicalURL != "" {
+		var base = ih.BaseURL
+		if base == nil {
+			base = getRequestURL(req)
+		}
+		v.CanonicalURL = base.String() + ih.WebPathPrefix + "/" + v.CanonicalURL
+	}
+
+	var data []byte
+	data, err = json.Marshal(v)
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// validate runs a request through the same steps IIIFRoute and Command
+// would, stopping short of decoding the image or contacting proxy upstreams.
+// Steps which respond to the client are given a discardWriter so their
+// status can be reported.  CanonicalURL is set to the canonical path only (no
+// server or prefix) when the request would succeed.
+func (ih *ImageHandler) validate(req *http.Request, path string) *validation {
+	var v = &validation{Path: path, Status: http.StatusOK}
+	var u, err = iiif.NewURL(ih.normalizePath(path))
+	if err != nil {
+		v.Status, v.Message = http.StatusBadRequest, "invalid IIIF request: "+err.Error()
+		return v
+	}
+
+	var sink = &discardWriter{header: make(http.Header)}
+	var responded = func() *validation {
+		v.Status, v.Message = sink.status, http.StatusText(sink.status)
+		if loc := sink.header.Get("Location"); loc != "" {
+			v.Message += ": " + loc
+		}
+		return v
+	}
+
+	if !ih.routeID(sink, req, u) {
+		return responded()
+	}
+	v.ID = u.ID
+
+	if pr := ih.proxyRouteFor(u.ID); pr != nil {
+		if ih.authorize(u.ID, req) != plugins.AuthAllow {
+			v.Status, v.Message = http.StatusUnauthorized, "authorization required"
+			return v
+		}
+		v.Message = "proxied to " + pr.Upstream.String()
+		return v
+	}
+
+	var info, e = ih.getInfo(u.ID, ih.getIIIFPath(u.ID))
+	if e != nil {
+		v.Status, v.Message = e.Code, e.Message
+		return v
+	}
+	var base = ih.BaseURL
+	if base == nil {
+		base = getRequestURL(req)
+	}
+	if _, ok := ih.authorizeLocal(sink, req, u, info, base.String()); !ok {
+		return responded()
+	}
+	v.SourceWidth, v.SourceHeight = info.Width, info.Height
+
+	if u.Info {
+		v.CanonicalURL = u.CanonicalPath(info.Width, info.Height, image.ZR, image.ZR)
+		return v
+	}
+
+	var fs = ih.featuresFor(u.ID)
+	if !fs.Supported(u) {
+		v.Status, v.Message = http.StatusNotImplemented, "feature not supported"
+		return v
+	}
+
+	var crop, scale image.Rectangle
+	crop, scale, err = img.Dimensions(u, info.Width, info.Height, ih.constraints(info))
+	v.Region = crop
+	v.OutputWidth, v.OutputHeight = scale.Dx(), scale.Dy()
+	if u.Rotation.Degrees != 0 {
+		v.OutputWidth, v.OutputHeight = transform.RotatedSize(scale.Dx(), scale.Dy(), u.Rotation.Degrees)
+	}
+	if e := ih.dimensionsError(u, info, fs, crop, scale, err); e != nil {
+		v.Status, v.Message = e.Code, e.Message
+		return v
+	}
+	if err != nil {
+		v.Status, v.Message = newImageResError(err).Code, err.Error()
+		return v
+	}
+
+	v.DecodeLevel = decodeLevel(info, crop, scale)
+	var dw, dh = int64(crop.Dx() >> uint(v.DecodeLevel)), int64(crop.Dy() >> uint(v.DecodeLevel))
+	v.DecodePixels = dw * dh
+	v.CanonicalURL = u.CanonicalPath(info.Width, info.Height, crop, scale)
+
+	return v
+}
+
+// decodeLevel estimates how many times the source can be halved before
+// decoding, based on the image's largest advertised scale factor and the
+// ratio of the crop to the output size.  The largest factor is used rather
+// than the number of factors, since scale factor rules can leave gaps.
+func decodeLevel(info *iiif.Info, crop, scale image.Rectangle) int {
+	var maxFactor = 1
+	if len(info.Tiles) > 0 {
+		for _, sf := range info.Tiles[0].ScaleFactors {
+			if sf > maxFactor {
+				maxFactor = sf
+			}
+		}
+	}
+	var maxLevel = bits.Len(uint(maxFactor)) - 1
+
+	var level int
+	for level < maxLevel && crop.Dx()>>uint(level+1) >= scale.Dx() && crop.Dy()>>uint(level+1) >= scale.Dy() {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/url"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func validateRequest(iiifURL string) *validation {
+	return validateWith(NewImageHandler(rootDir(), "/iiif"), iiifURL)
+}
+
+func validateWith(h *ImageHandler, iiifURL string) *validation {
+	var w = fakehttp.NewResponseWriter()
+	var req, _ = http.NewRequest("GET", "/admin/validate", nil)
+	var q = req.URL.Query()
+	q.Set("url", iiifURL)
+	req.URL.RawQuery = q.Encode()
+	req.Host = "example.com"
+
+	h.ValidateRoute(w, req)
+
+	var v = new(validation)
+	json.Unmarshal(w.Output, v)
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	var v = validateRequest("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/pct:0,0,50,100/200,/0/native.jpg")
+	assert.Equal(200, v.Status, "status", t)
+	assert.Equal(800, v.SourceWidth, "source width", t)
+	assert.Equal(400, v.Region.Dx(), "region width", t)
+	assert.Equal(200, v.OutputWidth, "output width", t)
+	assert.Equal(200, v.OutputHeight, "output height", t)
+	assert.Equal(1, v.DecodeLevel, "decode level", t)
+	assert.Equal(int64(200*200), v.DecodePixels, "decode pixels", t)
+	assert.Equal("http://example.com/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/0,0,400,400/200,/0/default.jpg",
+		v.CanonicalURL, "canonical URL", t)
+}
+
+func TestValidateErrors(t *testing.T) {
+	var v = validateRequest("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/full/full/0/bad.jpg")
+	assert.Equal(400, v.Status, "invalid request status", t)
+
+	v = validateRequest("/iiif/missing.jp2/full/full/0/default.jpg")
+	assert.Equal(404, v.Status, "missing image status", t)
+	assert.Equal("", v.CanonicalURL, "no canonical URL on errors", t)
+
+	v = validateRequest("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/full/full/0/default.gif")
+	assert.Equal(501, v.Status, "unsupported feature status", t)
+}
+
+func TestValidateSharedSteps(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/iiif")
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/"
+
+	h.IDPolicy.MaxLength = 10
+	var v = validateWith(h, path+"full/full/0/default.jpg")
+	assert.Equal(400, v.Status, "ID policy is applied", t)
+	h.IDPolicy.MaxLength = 0
+
+	h.Aliases = map[iiif.ID]iiif.ID{"old.jp2": "docker/images/testfile/test-world.jp2"}
+	v = validateWith(h, "/iiif/old.jp2/full/full/0/default.jpg")
+	assert.Equal(http.StatusMovedPermanently, v.Status, "aliases are redirected", t)
+	assert.True(strings.Contains(v.Message, "test-world.jp2/full/full/0/default.jpg"), "redirect location is reported", t)
+
+	var upstream, _ = url.Parse("http://upstream.example.com/iiif")
+	h.ProxyRoutes = []*ProxyRoute{{Prefix: "remote/", Upstream: upstream}}
+	v = validateWith(h, "/iiif/remote%2Fimage.jp2/full/full/0/default.jpg")
+	assert.Equal(200, v.Status, "proxied requests aren't looked up locally", t)
+	assert.Equal("proxied to http://upstream.example.com/iiif", v.Message, "proxy is reported", t)
+	h.ProxyRoutes = nil
+
+	h.Auth = testAuthService()
+	v = validateWith(h, path+"full/full/0/default.jpg")
+	assert.Equal(http.StatusUnauthorized, v.Status, "authorization is checked", t)
+	h.Auth = nil
+
+	var fs = iiif.AllFeatures()
+	fs.SizeAboveFull = false
+	h.FeatureSet = fs
+	v = validateWith(h, path+"0,0,100,100/200,/0/default.jpg")
+	assert.Equal(400, v.Status, "upscaling is refused without SizeAboveFull", t)
+
+	v = validateWith(h, path+"0,0,200,200/full/45/default.jpg")
+	assert.Equal(200, v.Status, "arbitrary rotation", t)
+	assert.Equal(283, v.OutputWidth, "rotated output width", t)
+	assert.Equal(283, v.OutputHeight, "rotated output height", t)
+}
+
+func TestDecodeLevel(t *testing.T) {
+	var info = &iiif.Info{Tiles: []iiif.TileSize{{ScaleFactors: []int{1, 4, 16}}}}
+	var crop = image.Rect(0, 0, 1600, 1600)
+	assert.Equal(4, decodeLevel(info, crop, image.Rect(0, 0, 100, 100)), "level comes from the largest factor", t)
+	assert.Equal(2, decodeLevel(info, crop, image.Rect(0, 0, 400, 400)), "level comes from the size ratio", t)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"rais/src/iiif"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// VerifyEncodes turns on encoder output verification: every rendered image is
+// decoded back and checked before it's cached or sent.  This is meant for
+// staging servers, as it roughly doubles the cost of each render.
+var VerifyEncodes bool
+
+// verifySumsLen is how many render checksums are remembered for comparison
+// with later renders of the same request
+const verifySumsLen = 10000
+
+// verifiedSums maps render keys to the renderSum of their first verified
+// output
+var verifiedSums *lru.Cache
+
+// renderSum is a render's checksum and its source file's modification time,
+// so replacing a source doesn't look like an encoder regression
+type renderSum struct {
+	sum     [sha256.Size]byte
+	modTime time.Time
+}
+
+// setupVerifiedSums creates the render checksum cache and hooks it into cache
+// purging, since purges often follow changes which alter output
+func setupVerifiedSums() {
+	var err error
+	verifiedSums, err = lru.New(verifySumsLen)
+	if err != nil {
+		Logger.Fatalf("Unable to start encode verification cache: %s", err)
+	}
+	purgeCachePlugins = append(purgeCachePlugins, verifiedSums.Purge)
+	expireCachedImagePlugins = append(expireCachedImagePlugins, func(iiif.ID) { verifiedSums.Purge() })
+}
+
+// verifyDecoders holds the decoders used to read output back.  JP2 output
+// can only be decoded from a file, so it only gets the checksum check.
+var verifyDecoders = map[iiif.Format]func(io.Reader) (image.Image, error){
+	iiif.FmtJPG:  jpeg.Decode,
+	iiif.FmtPNG:  png.Decode,
+	iiif.FmtGIF:  gif.Decode,
+	iiif.FmtTIF:  tiff.Decode,
+	iiif.FmtWEBP: webp.Decode,
+}
+
+// verifySamples is the number of points across and down an image which are
+// compared to decide if it's blank
+const verifySamples = 8
+
+// Output whose sample points are all within blankSpread of each other is
+// considered blank, but it's only an error if the source's sample points
+// differ by more than contentSpread.  The gap leaves room for lossy encoders
+// to flatten faint texture.  Both are in 16-bit color units.
+const (
+	blankSpread   = 2 * 0x101
+	contentSpread = 32 * 0x101
+)
+
+// verifyEncoded checks encoded output against the image it was encoded from:
+// the output must decode, match the source's dimensions, and not be blank
+// unless the source was.  Output must also be byte-for-byte the same as the
+// last render of the same request from the same source file, since encoders
+// should be deterministic.
+func verifyEncoded(key, srcPath string, data []byte, format iiif.Format, src image.Image) error {
+	if decode, ok := verifyDecoders[format]; ok {
+		var m, err = decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("output doesn't decode: %s", err)
+		}
+		if m.Bounds().Size() != src.Bounds().Size() {
+			return fmt.Errorf("output is %s, expected %s", m.Bounds().Size(), src.Bounds().Size())
+		}
+		// The source is only checked if the output looks blank, as reading it
+		// again can mean decoding banded images a second time
+		if spread(m) <= blankSpread && spread(src) > contentSpread {
+			return errors.New("output is blank, but its source isn't")
+		}
+	}
+
+	if verifiedSums == nil || key == "" {
+		return nil
+	}
+	var fi, err = statSource(srcPath)
+	if err != nil {
+		return nil
+	}
+	var rs = renderSum{sum: sha256.Sum256(data), modTime: fi.ModTime()}
+	if prev, ok := verifiedSums.Get(key); ok {
+		var p = prev.(renderSum)
+		if p.modTime.Equal(rs.modTime) && p.sum != rs.sum {
+			return errors.New("output differs from an earlier render of the same request")
+		}
+	}
+	verifiedSums.Add(key, rs)
+	return nil
+}
+
+// spread returns the largest difference in any channel between a grid of
+// sample points across m and its top-left pixel.  Points are read a row at a
+// time, top to bottom, so banded images decode each band at most once.
+func spread(m image.Image) uint32 {
+	var b = m.Bounds()
+	var first [4]uint32
+	first[0], first[1], first[2], first[3] = m.At(b.Min.X, b.Min.Y).RGBA()
+
+	var max uint32
+	for sy := 0; sy < verifySamples; sy++ {
+		var y = b.Min.Y + sy*(b.Dy()-1)/(verifySamples-1)
+		for sx := 0; sx < verifySamples; sx++ {
+			var x = b.Min.X + sx*(b.Dx()-1)/(verifySamples-1)
+			var c [4]uint32
+			c[0], c[1], c[2], c[3] = m.At(x, y).RGBA()
+			for i := range c {
+				var d = c[i] - first[i]
+				if c[i] < first[i] {
+					d = first[i] - c[i]
+				}
+				if d > max {
+					max = d
+				}
+			}
+		}
+	}
+	return max
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// stripes returns a gray image with alternating black and white columns
+func stripes(w, h int) *image.Gray {
+	var m = image.NewGray(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x += 2 {
+		for y := 0; y < h; y++ {
+			m.SetGray(x, y, color.Gray{255})
+		}
+	}
+	return m
+}
+
+func encoded(m image.Image, format iiif.Format) []byte {
+	var buf = bytes.NewBuffer(nil)
+	EncodeImage(buf, m, format, 0)
+	return buf.Bytes()
+}
+
+func TestVerifyEncoded(t *testing.T) {
+	var src = stripes(64, 32)
+	for _, f := range []iiif.Format{iiif.FmtJPG, iiif.FmtPNG, iiif.FmtGIF, iiif.FmtTIF} {
+		assert.NilError(verifyEncoded("", "", encoded(src, f), f, src), "valid "+string(f), t)
+	}
+
+	var err = verifyEncoded("", "", []byte("garbage"), iiif.FmtPNG, src)
+	assert.True(err != nil && strings.Contains(err.Error(), "doesn't decode"), "garbage output", t)
+
+	err = verifyEncoded("", "", encoded(stripes(32, 32), iiif.FmtPNG), iiif.FmtPNG, src)
+	assert.True(err != nil && strings.Contains(err.Error(), "expected"), "wrong size", t)
+
+	var blank = image.NewGray(src.Bounds())
+	err = verifyEncoded("", "", encoded(blank, iiif.FmtPNG), iiif.FmtPNG, src)
+	assert.True(err != nil && strings.Contains(err.Error(), "blank"), "blank output from a non-blank source", t)
+	assert.NilError(verifyEncoded("", "", encoded(blank, iiif.FmtPNG), iiif.FmtPNG, blank), "blank source", t)
+}
+
+func TestVerifyEncodedStability(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-verify-")
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "source.jp2")
+	ioutil.WriteFile(fp, []byte("source"), 0644)
+
+	var oldSums = verifiedSums
+	verifiedSums, _ = lru.New(10)
+	defer func() { verifiedSums = oldSums }()
+
+	var src = stripes(64, 32)
+	var data = encoded(src, iiif.FmtPNG)
+	assert.NilError(verifyEncoded("key", fp, data, iiif.FmtPNG, src), "first render", t)
+	assert.NilError(verifyEncoded("key", fp, data, iiif.FmtPNG, src), "identical render", t)
+
+	var other = encoded(src, iiif.FmtTIF)
+	var err = verifyEncoded("key", fp, other, iiif.FmtTIF, src)
+	assert.True(err != nil && strings.Contains(err.Error(), "differs"), "changed output", t)
+
+	// A replaced source may legitimately change the output
+	os.Chtimes(fp, time.Now(), time.Now().Add(time.Minute))
+	assert.NilError(verifyEncoded("key", fp, other, iiif.FmtTIF, src), "changed source", t)
+}
+
+func TestVerifyEncodesRender(t *testing.T) {
+	VerifyEncodes = true
+	defer func() { VerifyEncodes = false }()
+
+	var w = request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/full/200,/0/default.jpg", t)
+	assert.Equal(-1, w.StatusCode, "verified render doesn't set an error status", t)
+	assert.True(len(w.Output) > 0, "verified render is sent", t)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nfnt/resize"
+)
+
+// watermarkCheckInterval is how often the watermark file is checked for
+// changes
+const watermarkCheckInterval = 5 * time.Second
+
+// watermarkPositions maps a rule's Position to where its mark is drawn, as
+// fractions of the space around the mark
+var watermarkPositions = map[string][2]float64{
+	"center":      {0.5, 0.5},
+	"topleft":     {0, 0},
+	"topright":    {1, 0},
+	"bottomleft":  {0, 1},
+	"bottomright": {1, 1},
+}
+
+// watermarkRule marks images whose ID matches pattern with an overlay image.
+// Outputs rendered at an effective resolution under minSize aren't marked, so
+// thumbnails can stay clean.  A nonzero scale sizes the mark to that fraction
+// of the output's width.
+type watermarkRule struct {
+	pattern  *regexp.Regexp
+	mark     image.Image
+	minSize  int
+	opacity  float64
+	position [2]float64
+	scale    float64
+}
+
+// watermarkFile is the TOML layout of a watermark file
+type watermarkFile struct {
+	Watermark []struct {
+		Pattern  string
+		Image    string
+		MinSize  int
+		Opacity  *float64
+		Position string
+		Scale    float64
+	}
+}
+
+// readWatermarkRules reads the rules in fp, along with their overlay images.
+// Image paths are relative to the watermark file's directory.
+func readWatermarkRules(fp string) ([]*watermarkRule, error) {
+	var raw watermarkFile
+	var _, err = toml.DecodeFile(fp, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOML: %s", err)
+	}
+
+	var rules []*watermarkRule
+	for i, w := range raw.Watermark {
+		var r = &watermarkRule{minSize: w.MinSize, opacity: 1, scale: w.Scale}
+		r.pattern, err = regexp.Compile(w.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d has an invalid pattern %q: %s", i+1, w.Pattern, err)
+		}
+		if w.Opacity != nil {
+			r.opacity = *w.Opacity
+		}
+		if r.opacity < 0 || r.opacity > 1 {
+			return nil, fmt.Errorf("rule %d: Opacity must be between 0 and 1", i+1)
+		}
+		if r.scale < 0 || r.scale > 1 {
+			return nil, fmt.Errorf("rule %d: Scale must be between 0 and 1", i+1)
+		}
+		if w.Position == "" {
+			w.Position = "center"
+		}
+		var ok bool
+		r.position, ok = watermarkPositions[w.Position]
+		if !ok {
+			return nil, fmt.Errorf("rule %d has an unknown position %q", i+1, w.Position)
+		}
+
+		if w.Image == "" {
+			return nil, fmt.Errorf("rule %d has no image", i+1)
+		}
+		var imgPath = w.Image
+		if !filepath.IsAbs(imgPath) {
+			imgPath = filepath.Join(filepath.Dir(fp), imgPath)
+		}
+		r.mark, err = readWatermarkImage(imgPath)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: unable to read image %q: %s", i+1, imgPath, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// readWatermarkImage decodes an overlay image; PNGs with transparency work
+// best
+func readWatermarkImage(fp string) (image.Image, error) {
+	var f, err = os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m image.Image
+	m, _, err = image.Decode(f)
+	return m, err
+}
+
+// applies returns true if the rule marks an output which scales the crop of
+// a full-size image to scale.  The threshold is the resolution the whole image
+// would have at that scale, not the output's size, so tiles of a zoomed-in
+// view are marked just like a large full-image render.
+func (r *watermarkRule) applies(full image.Point, crop, scale image.Rectangle) bool {
+	if crop.Dx() == 0 || crop.Dy() == 0 {
+		return false
+	}
+	var rw = int64(scale.Dx()) * int64(full.X) / int64(crop.Dx())
+	var rh = int64(scale.Dy()) * int64(full.Y) / int64(crop.Dy())
+	return rw >= int64(r.minSize) || rh >= int64(r.minSize)
+}
+
+// apply returns a copy of m with the rule's mark drawn over it.  Grayscale
+// images stay grayscale, so gray and bitonal requests aren't turned into
+// color, and 16-bit images stay 16-bit.
+func (r *watermarkRule) apply(m image.Image) image.Image {
+	var b = m.Bounds()
+	var mark = r.mark
+	if r.scale > 0 {
+		var w = uint(float64(b.Dx()) * r.scale)
+		if w < 1 {
+			w = 1
+		}
+		mark = resize.Resize(w, 0, mark, resize.Bilinear)
+	}
+
+	var out draw.Image
+	switch m.(type) {
+	case *image.Gray:
+		out = image.NewGray(b)
+	case *image.Gray16:
+		out = image.NewGray16(b)
+	case *image.RGBA64, *image.NRGBA64:
+		out = image.NewRGBA64(b)
+	default:
+		out = image.NewRGBA(b)
+	}
+	draw.Draw(out, b, m, b.Min, draw.Src)
+
+	var ms = mark.Bounds().Size()
+	var at = image.Pt(
+		b.Min.X+int(float64(b.Dx()-ms.X)*r.position[0]),
+		b.Min.Y+int(float64(b.Dy()-ms.Y)*r.position[1]),
+	)
+	var alpha = image.NewUniform(color.Alpha{A: uint8(r.opacity*255 + 0.5)})
+	draw.DrawMask(out, image.Rectangle{Min: at, Max: at.Add(ms)}, mark, mark.Bounds().Min, alpha, image.ZP, draw.Over)
+	return out
+}
+
+// watermarks holds the rules read from a watermark file.  The file is
+// checked for changes at most every watermarkCheckInterval, and reread if it
+// has changed, so rules can be edited without restarting RAIS.  If a changed
+// file can't be read, the error is logged and the previous rules are kept.
+type watermarks struct {
+	sync.Mutex
+	path    string
+	rules   []*watermarkRule
+	modTime time.Time
+	checked time.Time
+}
+
+// loadWatermarks reads the watermark file at fp
+func loadWatermarks(fp string) (*watermarks, error) {
+	var info, err = os.Stat(fp)
+	if err != nil {
+		return nil, err
+	}
+	var w = &watermarks{path: fp, modTime: info.ModTime(), checked: time.Now()}
+	w.rules, err = readWatermarkRules(fp)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// current returns the rules and the modification time of the file they were
+// read from, rereading the file first if it's time to check it
+func (w *watermarks) current() ([]*watermarkRule, time.Time) {
+	w.Lock()
+	defer w.Unlock()
+
+	if time.Since(w.checked) < watermarkCheckInterval {
+		return w.rules, w.modTime
+	}
+	w.checked = time.Now()
+
+	var info, err = os.Stat(w.path)
+	if err != nil {
+		Logger.Errorf("Unable to check watermark file %q: %s", w.path, err)
+		return w.rules, w.modTime
+	}
+	if info.ModTime().Equal(w.modTime) {
+		return w.rules, w.modTime
+	}
+
+	var rules []*watermarkRule
+	rules, err = readWatermarkRules(w.path)
+	if err != nil {
+		Logger.Errorf("Unable to reload watermark file %q; keeping the previous rules: %s", w.path, err)
+		return w.rules, w.modTime
+	}
+	Logger.Infof("Reloaded %d watermark rules from %q", len(rules), w.path)
+	w.rules, w.modTime = rules, info.ModTime()
+	return w.rules, w.modTime
+}
+
+// ruleFor returns the first rule whose pattern matches id, or nil if none
+// do, along with a tag identifying the rule and the version of the file it
+// came from.  The tag goes into render keys, so cached output and ETags
+// change when the rules do.
+func (w *watermarks) ruleFor(id iiif.ID) (*watermarkRule, string) {
+	if w == nil {
+		return nil, ""
+	}
+
+	var rules, modTime = w.current()
+	for i, r := range rules {
+		if r.pattern.MatchString(string(id)) {
+			return r, strconv.FormatInt(modTime.UnixNano(), 36) + "." + strconv.Itoa(i)
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func writeWatermarks(t *testing.T, dir, rules string, modTime time.Time) string {
+	var fp = filepath.Join(dir, "watermarks.toml")
+	assert.NilError(ioutil.WriteFile(fp, []byte(rules), 0644), "writing watermark file", t)
+	os.Chtimes(fp, modTime, modTime)
+	return fp
+}
+
+func TestWatermarks(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-watermarks-")
+	defer os.RemoveAll(dir)
+
+	var red = image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.ZP, draw.Src)
+	var f, _ = os.Create(filepath.Join(dir, "red.png"))
+	png.Encode(f, red)
+	f.Close()
+
+	var fp = writeWatermarks(t, dir, `
+[[Watermark]]
+Pattern = "^donor-a/"
+Image = "red.png"
+MinSize = 50
+Opacity = 0.5
+Position = "bottomright"
+
+[[Watermark]]
+Pattern = "^donor-"
+Image = "red.png"
+Scale = 0.5
+`, time.Now().Add(-time.Hour))
+	var w, err = loadWatermarks(fp)
+	assert.NilError(err, "loading watermarks", t)
+
+	var r, tag = w.ruleFor("donor-a/foo.jp2")
+	assert.True(r != nil, "first rule matches", t)
+	assert.True(strings.HasSuffix(tag, ".0"), "tag names the rule", t)
+	var full = image.Pt(400, 200)
+	var crop = image.Rect(0, 0, 400, 200)
+	assert.False(r.applies(full, crop, image.Rect(0, 0, 40, 20)), "small outputs aren't marked", t)
+	assert.True(r.applies(full, crop, image.Rect(0, 0, 50, 25)), "either side may reach MinSize", t)
+	assert.True(r.applies(full, image.Rect(0, 0, 20, 20), image.Rect(0, 0, 20, 20)), "small full-resolution tiles are marked", t)
+
+	var white = image.NewRGBA(image.Rect(0, 0, 100, 50))
+	draw.Draw(white, white.Bounds(), image.White, image.ZP, draw.Src)
+	var out = r.apply(white)
+	var cr, cg, _, _ = out.At(95, 45).RGBA()
+	assert.Equal(uint32(0xFFFF), cr, "mark is drawn in the corner", t)
+	assert.True(cg > 0x7000 && cg < 0x9000, "mark is half transparent", t)
+	cr, cg, _, _ = out.At(85, 35).RGBA()
+	assert.Equal(cr, cg, "outside the mark is untouched", t)
+
+	var gray = image.NewGray(image.Rect(0, 0, 100, 50))
+	var _, isGray = r.apply(gray).(*image.Gray)
+	assert.True(isGray, "gray images stay gray", t)
+	var _, isGray16 = r.apply(image.NewGray16(gray.Rect)).(*image.Gray16)
+	assert.True(isGray16, "16-bit gray images stay 16-bit", t)
+	var _, isRGBA64 = r.apply(image.NewRGBA64(gray.Rect)).(*image.RGBA64)
+	assert.True(isRGBA64, "16-bit color images stay 16-bit", t)
+
+	r, _ = w.ruleFor("donor-b/foo.jp2")
+	out = r.apply(white)
+	cr, cg, _, _ = out.At(26, 25).RGBA()
+	assert.True(cr == 0xFFFF && cg == 0, "scaled mark is centered", t)
+	cr, cg, _, _ = out.At(24, 25).RGBA()
+	assert.Equal(cr, cg, "scaled mark is half the output width", t)
+
+	r, _ = w.ruleFor("other/foo.jp2")
+	assert.True(r == nil, "unmatched IDs aren't marked", t)
+
+	// Changes are picked up once the check interval has passed, and broken
+	// files leave the old rules in place
+	writeWatermarks(t, dir, "[[Watermark]]\nPattern = \"^other/\"\nImage = \"red.png\"\n", time.Now())
+	r, _ = w.ruleFor("other/foo.jp2")
+	assert.True(r == nil, "file isn't rechecked until the interval passes", t)
+	w.checked = time.Time{}
+	r, _ = w.ruleFor("other/foo.jp2")
+	assert.True(r != nil, "changed file is reloaded", t)
+
+	writeWatermarks(t, dir, "[[Watermark]]\nPattern = \"(\"\n", time.Now().Add(time.Minute))
+	w.checked = time.Time{}
+	r, _ = w.ruleFor("other/foo.jp2")
+	assert.True(r != nil, "invalid file keeps the previous rules", t)
+}
+
+func TestWatermarkRenderKey(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-watermarks-")
+	defer os.RemoveAll(dir)
+	var f, _ = os.Create(filepath.Join(dir, "mark.png"))
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	f.Close()
+	var fp = writeWatermarks(t, dir, "[[Watermark]]\nPattern = \"^marked/\"\nImage = \"mark.png\"\nMinSize = 500\n", time.Now())
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var err error
+	ih.Watermarks, err = loadWatermarks(fp)
+	assert.NilError(err, "loading watermarks", t)
+
+	var info = &iiif.Info{Width: 2000, Height: 1000}
+	var key = func(path string) string {
+		var u, _ = iiif.NewURL(path)
+		var key, _ = ih.renderKey(u, info, 0)
+		return key
+	}
+	assert.True(strings.Contains(key("marked/a.jp2/full/full/0/default.jpg"), "?wm="), "marked renders have their own key", t)
+	assert.False(strings.Contains(key("marked/a.jp2/full/200,/0/default.jpg"), "wm="), "small renders aren't marked", t)
+	assert.True(strings.Contains(key("marked/a.jp2/0,0,256,256/256,/0/default.jpg"), "wm="), "full-resolution tiles are marked", t)
+	assert.False(strings.Contains(key("marked/a.jp2/0,0,1024,1024/128,/0/default.jpg"), "wm="), "low-resolution tiles aren't marked", t)
+
+	var u, _ = iiif.NewURL("marked/a.jp2/full/full/0/default.jpg")
+	var _, mark = ih.renderKey(u, info, 0)
+	assert.True(mark != nil, "the rule named in the key is returned", t)
+	u, _ = iiif.NewURL("marked/a.jp2/full/200,/0/default.jpg")
+	_, mark = ih.renderKey(u, info, 0)
+	assert.True(mark == nil, "no rule is returned for unmarked renders", t)
+	assert.False(strings.Contains(key("plain/a.jp2/full/full/0/default.jpg"), "wm="), "other images aren't marked", t)
+}
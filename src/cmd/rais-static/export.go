@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+)
+
+// checksumFile is written into each image's directory so later exports can
+// tell whether the source has changed
+const checksumFile = ".rais-checksum"
+
+var unconstrained = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+
+// exporter holds the settings for a static export run
+type exporter struct {
+	Output      string
+	BaseURL     string
+	TileSize    int
+	Manifests   bool
+	ChangedOnly bool
+}
+
+// export writes all static files for the image at path.  If ChangedOnly is
+// set and the image hasn't changed since its last export, nothing is written
+// and false is returned.
+func (e *exporter) export(path string) (bool, error) {
+	var id = iiif.ID(filepath.Base(path))
+	var dir = filepath.Join(e.Output, string(id))
+
+	var sum, err = checksum(path)
+	if err != nil {
+		return false, err
+	}
+	if e.ChangedOnly {
+		var old, _ = ioutil.ReadFile(filepath.Join(dir, checksumFile))
+		if strings.TrimSpace(string(old)) == sum {
+			return false, nil
+		}
+	}
+
+	var res *img.Resource
+	res, err = img.NewResource(id, path)
+	if err != nil {
+		return false, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return false, err
+	}
+
+	var w, h = res.Decoder.GetWidth(), res.Decoder.GetHeight()
+	var info = iiif.FeatureSet0().Info()
+	info.ID = e.BaseURL + "/" + id.Escaped()
+	info.Width, info.Height = w, h
+
+	var scaleFactors = levelScaleFactors(w, h, res.Decoder.GetLevels())
+	info.Tiles = []iiif.TileSize{{Width: e.TileSize, Height: e.TileSize, ScaleFactors: scaleFactors}}
+
+	for _, tile := range tilePaths(w, h, e.TileSize, scaleFactors) {
+		err = e.writeTile(res, dir, tile)
+		if err != nil {
+			return false, fmt.Errorf("writing tile %q: %s", tile, err)
+		}
+	}
+
+	err = writeJSON(filepath.Join(dir, "info.json"), info)
+	if err == nil && e.Manifests {
+		err = writeJSON(filepath.Join(dir, "manifest.json"), newManifest(info, id))
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, checksumFile), []byte(sum+"\n"), 0644)
+	}
+
+	return err == nil, err
+}
+
+// writeTile renders a single tile, given its IIIF path relative to the image
+// directory
+func (e *exporter) writeTile(res *img.Resource, dir, tile string) error {
+	var u, err = iiif.NewURL(string(res.ID) + "/" + tile)
+	if err != nil {
+		return err
+	}
+
+	var i, applyErr = res.Apply(u, unconstrained)
+	if applyErr != nil {
+		return applyErr
+	}
+
+	var fullpath = filepath.Join(dir, filepath.FromSlash(tile))
+	err = os.MkdirAll(filepath.Dir(fullpath), 0755)
+	if err != nil {
+		return err
+	}
+
+	var f *os.File
+	f, err = os.Create(fullpath)
+	if err != nil {
+		return err
+	}
+
+	var buf = bufio.NewWriter(f)
+	err = jpeg.Encode(buf, i, &jpeg.Options{Quality: 80})
+	if err == nil {
+		err = buf.Flush()
+	}
+	var closeErr = f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// levelScaleFactors mirrors the scale factors RAIS advertises for an image:
+// one per resolution level, stopping before either dimension drops below 16
+// pixels.  Images without resolution levels still get a single level.
+func levelScaleFactors(w, h, levels int) []int {
+	var sf = []int{1}
+	for scale := 2; len(sf) < levels; scale <<= 1 {
+		if w/scale < 16 || h/scale < 16 {
+			break
+		}
+		sf = append(sf, scale)
+	}
+	return sf
+}
+
+// tilePaths returns the path, relative to an image's directory, of every tile
+// a level-0 client may request.  These match the URLs OpenSeadragon and other
+// IIIF viewers build from the info.json tiles block, in the same canonical
+// form RAIS uses for its Link headers and cache keys.
+func tilePaths(w, h, size int, scaleFactors []int) []string {
+	var paths []string
+	for _, sf := range scaleFactors {
+		var regionSize = size * sf
+		for y := 0; y < h; y += regionSize {
+			for x := 0; x < w; x += regionSize {
+				var rw, rh = minInt(regionSize, w-x), minInt(regionSize, h-y)
+				var crop = image.Rect(x, y, x+rw, y+rh)
+				var size = iiif.Size{Type: iiif.STScaleToWidth, W: (rw + sf - 1) / sf}
+				paths = append(paths, iiif.CanonicalRegion(w, h, crop)+"/"+size.Canonical(crop)+"/0/default.jpg")
+			}
+		}
+	}
+	return paths
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// checksum returns the hex-encoded SHA-256 sum of the file at path
+func checksum(path string) (string, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var h = sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeJSON marshals v into the file at path
+func writeJSON(path string, v interface{}) error {
+	var data, err = json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
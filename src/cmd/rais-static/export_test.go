@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestLevelScaleFactors(t *testing.T) {
+	assert.Equal("[1 2 4 8 16 32]", fmt.Sprint(levelScaleFactors(2000, 1000, 10)), "stops before height drops below 16", t)
+	assert.Equal("[1 2 4]", fmt.Sprint(levelScaleFactors(2000, 1000, 3)), "limited by levels", t)
+	assert.Equal("[1]", fmt.Sprint(levelScaleFactors(2000, 1000, 0)), "no levels", t)
+}
+
+func TestTilePaths(t *testing.T) {
+	var paths = tilePaths(1000, 600, 512, []int{1, 2})
+	var expected = []string{
+		"0,0,512,512/full/0/default.jpg",
+		"512,0,488,512/full/0/default.jpg",
+		"0,512,512,88/full/0/default.jpg",
+		"512,512,488,88/full/0/default.jpg",
+		"full/500,/0/default.jpg",
+	}
+	assert.Equal(strings.Join(expected, "\n"), strings.Join(paths, "\n"), "tile paths", t)
+}
+
+func TestTilePathsCanonical(t *testing.T) {
+	assert.Equal("full/full/0/default.jpg", strings.Join(tilePaths(400, 300, 512, []int{1}), "\n"), "untiled image", t)
+}
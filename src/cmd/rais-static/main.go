@@ -0,0 +1,78 @@
+// rais-static generates a static, level-0 IIIF image service for one or more
+// images: tiles, info.json, and optionally a Presentation manifest stub for
+// each image.  The output directory can be copied as-is to S3 or any other
+// static web host.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"rais/src/img"
+	"rais/src/openjpeg"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var opts struct {
+	Output      string `short:"o" long:"output" description:"directory to write the static files into" required:"true"`
+	BaseURL     string `short:"b" long:"base-url" description:"URL the output directory will be served from, e.g., https://example.org/iiif" required:"true"`
+	TileSize    int    `short:"t" long:"tile-size" description:"width and height of generated tiles" default:"512"`
+	Manifests   bool   `short:"m" long:"manifests" description:"write a Presentation manifest stub for each image"`
+	ChangedOnly bool   `short:"c" long:"changed-only" description:"skip images whose checksum matches the last export"`
+}
+
+func decodeJP2(path string) (img.Decoder, error) {
+	switch filepath.Ext(path) {
+	case ".jp2", ".j2c", ".j2k", ".jpf", ".jpx":
+		return openjpeg.NewJP2Image(path)
+	}
+	return nil, img.ErrNotHandled
+}
+
+func main() {
+	var args []string
+	var err error
+
+	var parser = flags.NewParser(&opts, flags.Default)
+	parser.Usage = "filename [filename...] [OPTIONS]"
+	args, err = parser.Parse()
+
+	if err != nil || len(args) < 1 || opts.TileSize < 16 {
+		parser.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	var l = logger.New(logger.Info)
+	openjpeg.Logger = l
+	img.RegisterDecoder(decodeJP2)
+
+	var e = &exporter{
+		Output:      opts.Output,
+		BaseURL:     strings.TrimRight(opts.BaseURL, "/"),
+		TileSize:    opts.TileSize,
+		Manifests:   opts.Manifests,
+		ChangedOnly: opts.ChangedOnly,
+	}
+
+	var failed int
+	for _, arg := range args {
+		var written, err = e.export(arg)
+		switch {
+		case err != nil:
+			l.Errorf("Unable to export %q: %s", arg, err)
+			failed++
+		case written:
+			l.Infof("Exported %q", arg)
+		default:
+			l.Infof("Skipping %q: unchanged since the last export", arg)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d image(s) failed to export\n", failed, len(args))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"rais/src/iiif"
+	"strings"
+)
+
+// The manifest types below hold just enough of the IIIF Presentation 2.1 API
+// to describe a single image; they're meant as stubs for people to fill in
+// with real metadata.
+
+type manifest struct {
+	Context   string     `json:"@context"`
+	ID        string     `json:"@id"`
+	Type      string     `json:"@type"`
+	Label     string     `json:"label"`
+	Sequences []sequence `json:"sequences"`
+}
+
+type sequence struct {
+	Type     string   `json:"@type"`
+	Canvases []canvas `json:"canvases"`
+}
+
+type canvas struct {
+	ID     string       `json:"@id"`
+	Type   string       `json:"@type"`
+	Label  string       `json:"label"`
+	Width  int          `json:"width"`
+	Height int          `json:"height"`
+	Images []annotation `json:"images"`
+}
+
+type annotation struct {
+	Type       string   `json:"@type"`
+	Motivation string   `json:"motivation"`
+	On         string   `json:"on"`
+	Resource   resource `json:"resource"`
+}
+
+type resource struct {
+	ID      string  `json:"@id"`
+	Type    string  `json:"@type"`
+	Format  string  `json:"format"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Service service `json:"service"`
+}
+
+type service struct {
+	Context string `json:"@context"`
+	ID      string `json:"@id"`
+	Profile string `json:"profile"`
+}
+
+// newManifest returns a single-canvas manifest for the image described by info
+func newManifest(info *iiif.Info, id iiif.ID) *manifest {
+	var canvasID = info.ID + "/canvas/1"
+
+	// Level-0 exports only have tiles, so we point at the largest tile covering
+	// the whole image rather than a "full/full" image which doesn't exist
+	var imageURL = info.ID
+	for _, tile := range tilePaths(info.Width, info.Height, info.Tiles[0].Width, info.Tiles[0].ScaleFactors) {
+		if strings.HasPrefix(tile, "full/") {
+			imageURL = info.ID + "/" + tile
+			break
+		}
+	}
+
+	return &manifest{
+		Context: "http://iiif.io/api/presentation/2/context.json",
+		ID:      info.ID + "/manifest.json",
+		Type:    "sc:Manifest",
+		Label:   string(id),
+		Sequences: []sequence{{
+			Type: "sc:Sequence",
+			Canvases: []canvas{{
+				ID:     canvasID,
+				Type:   "sc:Canvas",
+				Label:  string(id),
+				Width:  info.Width,
+				Height: info.Height,
+				Images: []annotation{{
+					Type:       "oa:Annotation",
+					Motivation: "sc:painting",
+					On:         canvasID,
+					Resource: resource{
+						ID:     imageURL,
+						Type:   "dctypes:Image",
+						Format: "image/jpeg",
+						Width:  info.Width,
+						Height: info.Height,
+						Service: service{
+							Context: info.Context,
+							ID:      info.ID,
+							Profile: info.Profile.ConformanceURL,
+						},
+					},
+				}},
+			}},
+		}},
+	}
+}
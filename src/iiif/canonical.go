@@ -0,0 +1,79 @@
+package iiif
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+)
+
+// CanonicalPath returns the IIIF 2.1 canonical form of the request (ID and
+// parameters, without a server or prefix) for an image of width w and height
+// h.  crop and scale must be the region and output size the server will use
+// to fulfill the request; this keeps the server's interpretation of "max",
+// percentages, and best-fit sizes in one place.
+//
+// Info requests simply return the ID with "/info.json" appended.
+func (u *URL) CanonicalPath(w, h int, crop, scale image.Rectangle) string {
+	if u.Info {
+		return u.ID.Escaped() + "/info.json"
+	}
+
+	var region = CanonicalRegion(w, h, crop)
+	var size = CanonicalSize(crop, scale)
+	var rotation = u.Rotation.Canonical()
+
+	var quality = u.Quality
+	if quality == QNative {
+		quality = QDefault
+	}
+
+	return fmt.Sprintf("%s/%s/%s/%s/%s.%s", u.ID.Escaped(), region, size, rotation, quality, u.Format)
+}
+
+// CanonicalRegion returns the canonical region string for crop within an
+// image of width w and height h: "full" if it covers the whole image, and
+// pixel coordinates otherwise
+func CanonicalRegion(w, h int, crop image.Rectangle) string {
+	if crop == image.Rect(0, 0, w, h) {
+		return "full"
+	}
+	return fmt.Sprintf("%d,%d,%d,%d", crop.Min.X, crop.Min.Y, crop.Dx(), crop.Dy())
+}
+
+// CanonicalSize returns the canonical size string for scaling crop to scale:
+// "full" if there's no scaling, "w," if the aspect ratio is preserved, and
+// "w,h" otherwise
+func CanonicalSize(crop, scale image.Rectangle) string {
+	var sw, sh = scale.Dx(), scale.Dy()
+	if sw == crop.Dx() && sh == crop.Dy() {
+		return "full"
+	}
+
+	// If the aspect ratio isn't preserved, width alone can't describe the size
+	if (Size{Type: STScaleToWidth, W: sw}).GetResize(crop).Dy() != sh {
+		return fmt.Sprintf("%d,%d", sw, sh)
+	}
+	return fmt.Sprintf("%d,", sw)
+}
+
+// Canonical returns the canonical form of the region for an image of width w
+// and height h.  The region is clipped to the image, as it is when rendered.
+func (r Region) Canonical(w, h int) string {
+	return CanonicalRegion(w, h, r.GetCrop(w, h).Intersect(image.Rect(0, 0, w, h)))
+}
+
+// Canonical returns the canonical form of the size when applied to crop.
+// "max" is treated as "full", since only the server knows its limits.
+func (s Size) Canonical(crop image.Rectangle) string {
+	return CanonicalSize(crop, s.GetResize(crop))
+}
+
+// Canonical returns the canonical form of the rotation: the degrees without
+// trailing zeros, preceded by "!" if the image is mirrored
+func (r Rotation) Canonical() string {
+	var rotation = strconv.FormatFloat(r.Degrees, 'f', -1, 64)
+	if r.Mirror {
+		return "!" + rotation
+	}
+	return rotation
+}
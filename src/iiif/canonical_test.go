@@ -0,0 +1,63 @@
+package iiif
+
+import (
+	"image"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	var u, _ = NewURL("some%2Fid/full/full/0/native.jpg")
+	var full = image.Rect(0, 0, 800, 400)
+	assert.Equal("some%2Fid/full/full/0/default.jpg", u.CanonicalPath(800, 400, full, full), "full image", t)
+
+	u, _ = NewURL("some%2Fid/pct:50,0,50,100/pct:50/90.0/gray.png")
+	var crop = u.Region.GetCrop(800, 400)
+	var scale = u.Size.GetResize(crop)
+	assert.Equal("some%2Fid/400,0,400,400/200,/90/gray.png", u.CanonicalPath(800, 400, crop, scale), "pct region and size", t)
+
+	u, _ = NewURL("id/full/100,100/!180/default.jpg")
+	scale = u.Size.GetResize(full)
+	assert.Equal("id/full/100,100/!180/default.jpg", u.CanonicalPath(800, 400, full, scale), "distorted size", t)
+
+	u, _ = NewURL("id/info.json")
+	assert.Equal("id/info.json", u.CanonicalPath(800, 400, full, full), "info request", t)
+}
+
+func TestCanonicalParts(t *testing.T) {
+	var regions = map[string]string{
+		"full":             "full",
+		"square":           "200,0,400,400",
+		"0,0,800,400":      "full",
+		"10.0,20,30.50,40": "10,20,30,40",
+		"700,300,500,500":  "700,300,100,100",
+		"pct:25,0,50,100":  "200,0,400,400",
+	}
+	for in, expected := range regions {
+		var got = StringToRegion(in).Canonical(800, 400)
+		assert.Equal(expected, got, "region "+in, t)
+		assert.Equal(got, StringToRegion(got).Canonical(800, 400), "region "+in+" round trip", t)
+	}
+
+	var crop = image.Rect(0, 0, 800, 400)
+	var sizes = map[string]string{
+		"full":     "full",
+		"max":      "full",
+		"800,":     "full",
+		"400,":     "400,",
+		",200":     "400,",
+		"pct:50":   "400,",
+		"!400,400": "400,",
+		"400,400":  "400,400",
+	}
+	for in, expected := range sizes {
+		var got = StringToSize(in).Canonical(crop)
+		assert.Equal(expected, got, "size "+in, t)
+		assert.Equal(got, StringToSize(got).Canonical(crop), "size "+in+" round trip", t)
+	}
+
+	assert.Equal("90", StringToRotation("90.00").Canonical(), "rotation trailing zeros", t)
+	assert.Equal("!22.5", StringToRotation("!22.50").Canonical(), "mirrored rotation", t)
+	assert.Equal("0", StringToRotation("360").Canonical(), "full rotation", t)
+}
@@ -37,6 +37,10 @@ func (fs *FeatureSet) SupportsRegion(r Region) bool {
 
 // SupportsSize just verifies a given size type is supported
 func (fs *FeatureSet) SupportsSize(s Size) bool {
+	if s.Upscale && !fs.SizeAboveFull {
+		return false
+	}
+
 	switch s.Type {
 	case STScaleToWidth:
 		return fs.SizeByW
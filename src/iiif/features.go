@@ -12,6 +12,14 @@ type TileSize struct {
 	ScaleFactors []int `json:"scaleFactors"`
 }
 
+// ImageSize is a full-image size a server prefers clients request, such as
+// those which can be decoded cheaply.  Like TileSize, this is serialized in
+// an info request.
+type ImageSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
 // FeatureSet represents possible IIIF 2.1 features.  The boolean fields are
 // the same as the string to report features, except that the first character
 // should be lowercased.
@@ -73,14 +73,30 @@ type Info struct {
 	Protocol string         `json:"protocol"`
 	Width    int            `json:"width"`
 	Height   int            `json:"height"`
+	Sizes    []ImageSize    `json:"sizes,omitempty"`
 	Tiles    []TileSize     `json:"tiles,omitempty"`
 	Profile  ProfileWrapper `json:"profile"`
+
+	// Service holds the services associated with the image: usually Service
+	// values, but anything which marshals to a JSON object is allowed, so
+	// services RAIS doesn't model can be included as-is
+	Service []interface{} `json:"service,omitempty"`
+
+	// Attribution, License, and Logo are the rights metadata IIIF allows on
+	// info responses
+	Attribution string `json:"attribution,omitempty"`
+	License     string `json:"license,omitempty"`
+	Logo        string `json:"logo,omitempty"`
+
+	// FormatLimits is a RAIS extension telling clients the largest width or
+	// height the server will produce for a given format
+	FormatLimits map[Format]int `json:"formatLimits,omitempty"`
 }
 
 // NewInfo returns the static *Info data that's the same for any info response
 func NewInfo() *Info {
 	return &Info{
-		Context:  "http://iiif.io/api/image/2/context.json",
+		Context:  Info2Context,
 		Protocol: "http://iiif.io/api/image",
 	}
 }
@@ -125,6 +141,19 @@ func (fs *FeatureSet) Profile() ProfileWrapper {
 	return p
 }
 
+// Enabled returns the names of every feature the set supports, split into
+// formats, qualities, and other features as a profile would list them
+func (fs *FeatureSet) Enabled() (formats, qualities, supports []string) {
+	var fm = make(FeaturesMap)
+	for name, enabled := range fs.toMap() {
+		if enabled {
+			fm[name] = true
+		}
+	}
+	var p = extraProfileFromFeaturesMap(fm)
+	return p.Formats, p.Qualities, p.Supports
+}
+
 func extraProfileFromFeaturesMap(fm FeaturesMap) profileElement2 {
 	p := profileElement2{
 		Formats:   make([]string, 0),
@@ -0,0 +1,87 @@
+package iiif
+
+import (
+	"path"
+	"strings"
+)
+
+// Info2Context and Info3Context are the JSON-LD contexts for Image API 2.x
+// and 3.0 info responses
+const (
+	Info2Context = "http://iiif.io/api/image/2/context.json"
+	Info3Context = "http://iiif.io/api/image/3/context.json"
+)
+
+// Info3 is the Image API 3.0 serialization of an info response.  It's built
+// from an Info rather than directly, so the server only has one info
+// structure to fill in, cache, and override.
+type Info3 struct {
+	Context        string        `json:"@context"`
+	ID             string        `json:"id"`
+	Type           string        `json:"type"`
+	Protocol       string        `json:"protocol"`
+	Profile        string        `json:"profile"`
+	Width          int           `json:"width"`
+	Height         int           `json:"height"`
+	MaxWidth       int           `json:"maxWidth,omitempty"`
+	MaxHeight      int           `json:"maxHeight,omitempty"`
+	MaxArea        int64         `json:"maxArea,omitempty"`
+	Sizes          []ImageSize   `json:"sizes,omitempty"`
+	Tiles          []TileSize    `json:"tiles,omitempty"`
+	ExtraQualities []string      `json:"extraQualities,omitempty"`
+	ExtraFormats   []string      `json:"extraFormats,omitempty"`
+	ExtraFeatures  []string      `json:"extraFeatures,omitempty"`
+	Service        []interface{} `json:"service,omitempty"`
+	Rights         string        `json:"rights,omitempty"`
+
+	// FormatLimits is the same RAIS extension as in Info
+	FormatLimits map[Format]int `json:"formatLimits,omitempty"`
+}
+
+// features3 maps Image API 2.1 feature names which were renamed or dropped in
+// 3.0 to their 3.0 names, or "" for features 3.0 doesn't have
+var features3 = map[string]string{
+	"sizeAboveFull":     "sizeUpscaling",
+	"sizeByWhListed":    "",
+	"sizeByForcedWh":    "",
+	"sizeByDistortedWh": "",
+}
+
+// V3 returns the Image API 3.0 form of the info.  The compliance level and
+// extra features are carried over from the 2.1 profile, with feature names
+// translated; attribution and logo have no place in a 3.0 info response, and
+// the license becomes "rights".
+func (i *Info) V3() *Info3 {
+	var i3 = &Info3{
+		Context:        Info3Context,
+		ID:             i.ID,
+		Type:           "ImageService3",
+		Protocol:       i.Protocol,
+		Profile:        strings.TrimSuffix(path.Base(i.Profile.ConformanceURL), ".json"),
+		Width:          i.Width,
+		Height:         i.Height,
+		MaxWidth:       i.Profile.MaxWidth,
+		MaxHeight:      i.Profile.MaxHeight,
+		MaxArea:        i.Profile.MaxArea,
+		Sizes:          i.Sizes,
+		Tiles:          i.Tiles,
+		ExtraQualities: i.Profile.Qualities,
+		ExtraFormats:   i.Profile.Formats,
+		Service:        i.Service,
+		Rights:         i.License,
+		FormatLimits:   i.FormatLimits,
+	}
+	if !strings.HasPrefix(i3.Profile, "level") {
+		i3.Profile = "level0"
+	}
+
+	for _, f := range i.Profile.Supports {
+		if name, ok := features3[f]; ok {
+			f = name
+		}
+		if f != "" {
+			i3.ExtraFeatures = append(i3.ExtraFeatures, f)
+		}
+	}
+	return i3
+}
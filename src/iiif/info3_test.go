@@ -0,0 +1,35 @@
+package iiif
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestInfoV3(t *testing.T) {
+	var fs = FeatureSet2()
+	fs.SizeAboveFull = true
+	fs.SizeByWhListed = true
+	fs.Mirroring = true
+	var i = fs.Info()
+	i.ID = "http://example.com/iiif/foo"
+	i.Width, i.Height = 100, 50
+	i.License = "http://rightsstatements.org/vocab/InC/1.0/"
+	i.Profile.MaxWidth = 80
+
+	var i3 = i.V3()
+	assert.Equal(Info3Context, i3.Context, "context", t)
+	assert.Equal(i.ID, i3.ID, "id", t)
+	assert.Equal("level2", i3.Profile, "profile", t)
+	assert.Equal(80, i3.MaxWidth, "maxWidth moves out of the profile", t)
+	assert.Equal(i.License, i3.Rights, "license becomes rights", t)
+
+	var features = make(map[string]bool)
+	for _, f := range i3.ExtraFeatures {
+		features[f] = true
+	}
+	assert.True(features["mirroring"], "unchanged features are kept", t)
+	assert.True(features["sizeUpscaling"], "renamed features are translated", t)
+	assert.False(features["sizeAboveFull"], "2.1 names aren't used", t)
+	assert.False(features["sizeByWhListed"], "features 3.0 doesn't have are dropped", t)
+}
@@ -61,11 +61,15 @@ func TestAllFeaturesEnabled(t *testing.T) {
 	assert.Equal("http://iiif.io/api/image/2/level2.json", i.Profile.ConformanceURL, "Profile conformance level", t)
 
 	extra := i.Profile.profileElement2
-	assert.Equal(5, len(extra.Supports), "THERE... ARE... FOUR... (plus one) EXTRA... FEATURES!", t)
+	assert.Equal(7, len(extra.Supports), "THERE... ARE... FOUR... (plus three) EXTRA... FEATURES!", t)
 	assert.Equal(0, len(extra.Qualities), "There are 0 extra qualities", t)
-	assert.Equal(1, len(extra.Formats), "There is 1 extra format", t)
+	assert.Equal(3, len(extra.Formats), "There are 3 extra formats", t)
 	assert.IncludesString("regionSquare", extra.Supports, "Custom FS support", t)
 	assert.IncludesString("sizeAboveFull", extra.Supports, "Custom FS support", t)
 	assert.IncludesString("mirroring", extra.Supports, "Custom FS support", t)
+	assert.IncludesString("canonicalLinkHeader", extra.Supports, "Custom FS support", t)
+	assert.IncludesString("rotationArbitrary", extra.Supports, "Custom FS support", t)
 	assert.IncludesString("tif", extra.Formats, "Custom FS support", t)
+	assert.IncludesString("jp2", extra.Formats, "Custom FS support", t)
+	assert.IncludesString("webp", extra.Formats, "Custom FS support", t)
 }
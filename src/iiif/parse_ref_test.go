@@ -0,0 +1,148 @@
+package iiif
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// This file holds the original split-based parsers.  They're kept only as a
+// reference for the fuzz tests, which check that the allocation-free parsers
+// agree with them on every input.
+
+func refNewURL(path string) *URL {
+	var u = &URL{Path: path}
+	if strings.HasSuffix(path, "info.json") {
+		u.Info = true
+		u.ID = URLToID(strings.Replace(path, "/info.json", "", -1))
+		return u
+	}
+
+	var parts = strings.Split(path, "/")
+	var pop = func() string {
+		var retval string
+		if len(parts) > 0 {
+			retval, parts = parts[len(parts)-1], parts[:len(parts)-1]
+		}
+		return retval
+	}
+	var qfParts = strings.SplitN(pop(), ".", 2)
+	if len(qfParts) == 2 {
+		u.Format = StringToFormat(qfParts[1])
+		u.Quality = StringToQuality(qfParts[0])
+	}
+	u.Rotation = refRotation(pop())
+	u.Size = refSize(pop())
+	u.Region = refRegion(pop())
+	u.ID = URLToID(strings.Join(parts, "/"))
+	return u
+}
+
+func refRegion(p string) Region {
+	if p == "full" {
+		return Region{Type: RTFull}
+	}
+	if p == "square" {
+		return Region{Type: RTSquare}
+	}
+
+	r := Region{Type: RTPixel}
+	if len(p) > 4 && p[0:4] == "pct:" {
+		r.Type = RTPercent
+		p = p[4:]
+	}
+
+	vals := strings.Split(p, ",")
+	if len(vals) != 4 {
+		return Region{Type: RTNone}
+	}
+
+	for i, dst := range []*float64{&r.X, &r.Y, &r.W, &r.H} {
+		var err error
+		*dst, err = strconv.ParseFloat(vals[i], 64)
+		if err != nil || math.IsNaN(*dst) || math.IsInf(*dst, 0) {
+			return Region{Type: RTNone}
+		}
+	}
+
+	return r
+}
+
+func refSize(p string) Size {
+	if p == "" {
+		return Size{}
+	}
+
+	s := Size{Type: STNone}
+	if p[0] == '^' {
+		s.Upscale = true
+		p = p[1:]
+		if p == "" || p == "full" {
+			return s
+		}
+	}
+
+	if p == "full" {
+		return Size{Type: STFull}
+	}
+	if p == "max" {
+		s.Type = STMax
+		return s
+	}
+
+	if len(p) > 4 && p[0:4] == "pct:" {
+		s.Type = STScalePercent
+		s.Percent, _ = strconv.ParseFloat(p[4:], 64)
+		if math.IsNaN(s.Percent) || math.IsInf(s.Percent, 0) {
+			s.Percent = 0
+		}
+		return s
+	}
+
+	if p[0:1] == "!" {
+		s.Type = STBestFit
+		p = p[1:]
+	}
+
+	vals := strings.Split(p, ",")
+	if len(vals) != 2 {
+		return s
+	}
+	s.W, _ = strconv.Atoi(vals[0])
+	s.H, _ = strconv.Atoi(vals[1])
+
+	if s.Type == STNone {
+		if vals[0] == "" {
+			s.Type = STScaleToHeight
+		} else if vals[1] == "" {
+			s.Type = STScaleToWidth
+		} else {
+			s.Type = STExact
+		}
+	}
+
+	return s
+}
+
+func refRotation(p string) Rotation {
+	r := Rotation{}
+	if p == "" {
+		return r
+	}
+	if p[0:1] == "!" {
+		r.Mirror = true
+		p = p[1:]
+	}
+
+	var err error
+	r.Degrees, err = strconv.ParseFloat(p, 64)
+	if err != nil || strings.ContainsAny(p, "eEinfINFxXpP_+") {
+		r.Degrees = -1
+		return r
+	}
+	if r.Degrees == 360 {
+		r.Degrees = 0
+	}
+
+	return r
+}
@@ -0,0 +1,91 @@
+package iiif
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// parseSeeds are the fuzz seeds: common request shapes plus the edge cases
+// the parsers have had to handle.  More are in testdata/fuzz/FuzzNewURL.
+var parseSeeds = []string{
+	"image.jp2/0,0,1024,1024/512,/0/default.jpg",
+	"path%2Fto%2Fimage.jp2/full/max/0/default.jpg",
+	"path/to/image.jp2/pct:10,10,50.5,50/!300,300/!90/gray.png",
+	"image.jp2/square/^max/0/color.webp",
+	"image.jp2/full/^pct:150/360/bitonal.tif",
+	"image.jp2/full/,256/0/default.jpg",
+	"image.jp2/info.json",
+	"image.jp2",
+	"/full/full/0/default.jpg",
+	"image.jp2/1,2,3/4,5,6/x/a.b.c",
+	"image.jp2/1e3,0,10,10/^/1e2/default.jpg",
+	"image.jp2/NaN,0,10,10/pct:Inf/+90/default.jpg",
+	"a//b///.",
+	"",
+}
+
+func TestParseAllocations(t *testing.T) {
+	var allocs = testing.AllocsPerRun(100, func() {
+		StringToRegion("1024,2048,1024,1024")
+		StringToRegion("pct:10,10,50,50")
+		StringToSize("512,")
+		StringToSize("!300,300")
+		StringToRotation("!90")
+	})
+	assert.Equal(0.0, allocs, "region, size, and rotation parsing don't allocate", t)
+
+	allocs = testing.AllocsPerRun(100, func() {
+		NewURL("image.jp2/1024,2048,1024,1024/512,/0/default.jpg")
+	})
+	assert.Equal(1.0, allocs, "a tile URL only allocates the URL itself", t)
+}
+
+// FuzzNewURL checks that NewURL agrees with the original split-based parser
+// on every input
+func FuzzNewURL(f *testing.F) {
+	for _, seed := range parseSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		var u, _ = NewURL(path)
+		var want = refNewURL(path)
+		if !reflect.DeepEqual(u, want) {
+			t.Fatalf("NewURL(%q) = %#v; reference parser gives %#v", path, u, want)
+		}
+	})
+}
+
+// FuzzParams checks the region, size, and rotation parsers directly, since
+// NewURL never gives them values containing a slash
+func FuzzParams(f *testing.F) {
+	for _, seed := range []string{"0,0,10,10", "pct:1,2,3,4", ",,,", "512,", ",512", "!1,2", "^pct:50", "!90", "a/b,c"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		if r, want := StringToRegion(p), refRegion(p); r != want {
+			t.Fatalf("StringToRegion(%q) = %#v; want %#v", p, r, want)
+		}
+		if s, want := StringToSize(p), refSize(p); s != want {
+			t.Fatalf("StringToSize(%q) = %#v; want %#v", p, s, want)
+		}
+		if r, want := StringToRotation(p), refRotation(p); r != want {
+			t.Fatalf("StringToRotation(%q) = %#v; want %#v", p, r, want)
+		}
+	})
+}
+
+func BenchmarkNewURLTile(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewURL("path%2Fto%2Fimage.jp2/1024,2048,1024,1024/512,/0/default.jpg")
+	}
+}
+
+func BenchmarkNewURLInfo(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewURL("path%2Fto%2Fimage.jp2/info.json")
+	}
+}
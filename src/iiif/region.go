@@ -2,8 +2,8 @@
 
 import (
 	"image"
+	"math"
 	"strconv"
-	"strings"
 )
 
 // A RegionType tells us what a Region is representing so we know how to apply
@@ -46,15 +46,20 @@ func StringToRegion(p string) Region {
 		p = p[4:]
 	}
 
-	vals := strings.Split(p, ",")
-	if len(vals) < 4 {
+	var vals [4]string
+	if !splitComma(p, vals[:]) {
 		return Region{Type: RTNone}
 	}
 
-	r.X, _ = strconv.ParseFloat(vals[0], 64)
-	r.Y, _ = strconv.ParseFloat(vals[1], 64)
-	r.W, _ = strconv.ParseFloat(vals[2], 64)
-	r.H, _ = strconv.ParseFloat(vals[3], 64)
+	var nums [4]float64
+	for i, val := range vals {
+		var err error
+		nums[i], err = strconv.ParseFloat(val, 64)
+		if err != nil || math.IsNaN(nums[i]) || math.IsInf(nums[i], 0) {
+			return Region{Type: RTNone}
+		}
+	}
+	r.X, r.Y, r.W, r.H = nums[0], nums[1], nums[2], nums[3]
 
 	return r
 }
@@ -82,7 +87,8 @@ func (r Region) Valid() bool {
 }
 
 // GetCrop determines the cropped area that this region represents given an
-// image width and height
+// image width and height.  The area isn't clamped to the image, so callers
+// can tell when a region falls outside it.
 func (r Region) GetCrop(w, h int) image.Rectangle {
 	crop := image.Rect(0, 0, w, h)
 
@@ -43,3 +43,9 @@ func TestRegionTypeSquare(t *testing.T) {
 	r := StringToRegion("square")
 	assert.True(r.Type == RTSquare, "r.Type == RTSquare", t)
 }
+
+func TestMalformedRegion(t *testing.T) {
+	for _, s := range []string{"10,10,40,70,5", "a,10,40,70", "10,10,40,", "NaN,0,10,10", "0,0,Inf,10", "pct:"} {
+		assert.False(StringToRegion(s).Valid(), s+" is invalid", t)
+	}
+}
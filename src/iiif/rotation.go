@@ -2,6 +2,7 @@
 
 import (
 	"strconv"
+	"strings"
 )
 
 // Rotation represents the degrees of rotation and whether or not an image is
@@ -12,9 +13,10 @@ type Rotation struct {
 	Degrees float64
 }
 
-// StringToRotation creates a Rotation from a string as seen in a IIIF URL.
-// An invalid string would result in a 0-degree rotation as opposed to an error
-// condition.  This is a known issue which needs to be fixed.
+// StringToRotation creates a Rotation from a string as seen in a IIIF URL: a
+// number of degrees, optionally preceded by "!" to mirror the image before
+// rotating it.  A string which isn't a plain number results in an invalid
+// rotation.
 func StringToRotation(p string) Rotation {
 	r := Rotation{}
 	if p == "" {
@@ -25,7 +27,12 @@ func StringToRotation(p string) Rotation {
 		p = p[1:]
 	}
 
-	r.Degrees, _ = strconv.ParseFloat(p, 64)
+	var err error
+	r.Degrees, err = strconv.ParseFloat(p, 64)
+	if err != nil || strings.ContainsAny(p, "eEinfINFxXpP_+") {
+		r.Degrees = -1
+		return r
+	}
 
 	// This isn't actually to spec, but it makes way more sense than only
 	// allowing 360 for compliance level 2 (and in fact *requiring* it there)
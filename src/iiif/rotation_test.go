@@ -33,4 +33,9 @@ func TestInvalidRotation(t *testing.T) {
 	assert.True(!r.Valid(), "!r.Valid", t)
 	r = StringToRotation("!360.1")
 	assert.True(!r.Valid(), "!r.Valid", t)
+
+	for _, bad := range []string{"!", "!!90", "abc", "90!", "1e1", "NaN", "+90"} {
+		r = StringToRotation(bad)
+		assert.True(!r.Valid(), bad+" is invalid", t)
+	}
 }
@@ -0,0 +1,25 @@
+package iiif
+
+// Service describes a service associated with an image, such as the IIIF
+// Authentication API's login, token, and logout services.  Services can be
+// nested: a login service lists its token and logout services.
+type Service struct {
+	Context            string    `json:"@context,omitempty"`
+	ID                 string    `json:"@id"`
+	Profile            string    `json:"profile"`
+	Label              string    `json:"label,omitempty"`
+	Header             string    `json:"header,omitempty"`
+	Description        string    `json:"description,omitempty"`
+	ConfirmLabel       string    `json:"confirmLabel,omitempty"`
+	FailureHeader      string    `json:"failureHeader,omitempty"`
+	FailureDescription string    `json:"failureDescription,omitempty"`
+	Service            []Service `json:"service,omitempty"`
+}
+
+// IIIF Authentication API 1.0 context and profile URIs
+const (
+	AuthContext       = "http://iiif.io/api/auth/1/context.json"
+	AuthLoginProfile  = "http://iiif.io/api/auth/1/login"
+	AuthTokenProfile  = "http://iiif.io/api/auth/1/token"
+	AuthLogoutProfile = "http://iiif.io/api/auth/1/logout"
+)
@@ -4,7 +4,6 @@
 	"image"
 	"math"
 	"strconv"
-	"strings"
 )
 
 // SizeType represents the type of scaling which will be performed
@@ -40,26 +39,43 @@ type Size struct {
 	Type    SizeType
 	Percent float64
 	W, H    int
+
+	// Upscale is set when the size had a "^" prefix, explicitly allowing the
+	// output to be larger than the requested region
+	Upscale bool
 }
 
-// StringToSize creates a Size from a string as seen in a IIIF URL.
+// StringToSize creates a Size from a string as seen in a IIIF URL.  A "^"
+// prefix, from IIIF 3.0, sets Upscale; "^full" isn't valid since "full" can
+// never be an upscale.
 func StringToSize(p string) Size {
 	if p == "" {
 		return Size{}
 	}
 
+	s := Size{Type: STNone}
+	if p[0] == '^' {
+		s.Upscale = true
+		p = p[1:]
+		if p == "" || p == "full" {
+			return s
+		}
+	}
+
 	if p == "full" {
 		return Size{Type: STFull}
 	}
 	if p == "max" {
-		return Size{Type: STMax}
+		s.Type = STMax
+		return s
 	}
 
-	s := Size{Type: STNone}
-
 	if len(p) > 4 && p[0:4] == "pct:" {
 		s.Type = STScalePercent
 		s.Percent, _ = strconv.ParseFloat(p[4:], 64)
+		if math.IsNaN(s.Percent) || math.IsInf(s.Percent, 0) {
+			s.Percent = 0
+		}
 		return s
 	}
 
@@ -68,12 +84,12 @@ func StringToSize(p string) Size {
 		p = p[1:]
 	}
 
-	vals := strings.Split(p, ",")
-	if len(vals) != 2 {
+	var vals [2]string
+	if !splitComma(p, vals[:]) {
 		return s
 	}
-	s.W, _ = strconv.Atoi(vals[0])
-	s.H, _ = strconv.Atoi(vals[1])
+	s.W = atoi(vals[0])
+	s.H = atoi(vals[1])
 
 	if s.Type == STNone {
 		if vals[0] == "" {
@@ -88,6 +104,17 @@ func StringToSize(p string) Size {
 	return s
 }
 
+// atoi returns the integer in p, or zero if p isn't one.  Empty values, as in
+// "512,", are common enough that they skip strconv, which allocates an error
+// for them.
+func atoi(p string) int {
+	if p == "" {
+		return 0
+	}
+	var n, _ = strconv.Atoi(p)
+	return n
+}
+
 // Valid returns whether the size has a valid type, and if so, whether the
 // parameters are valid for that type
 func (s Size) Valid() bool {
@@ -134,12 +161,21 @@ func (s Size) GetResize(region image.Rectangle) image.Rectangle {
 
 // getBestFit preserves the aspect ratio while determining the proper scaling
 // factor to get width and height adjusted to fit within the width and height
-// of the desired size operation
+// of the desired size operation.  Neither dimension is allowed to round down
+// to zero, so very thin regions (such as edge tiles at deep zoom levels) are
+// still at least a pixel wide.
 func (s Size) getBestFit(w, h int) (int, int) {
 	fW, fH, fsW, fsH := float64(w), float64(h), float64(s.W), float64(s.H)
 	sf := fsW / fW
 	if sf*fH > fsH {
 		sf = fsH / fH
 	}
-	return int(sf * fW), int(sf * fH)
+	w, h = int(sf*fW), int(sf*fH)
+	if sf > 0 && w < 1 {
+		w = 1
+	}
+	if sf > 0 && h < 1 {
+		h = 1
+	}
+	return w, h
 }
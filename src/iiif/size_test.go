@@ -52,6 +52,33 @@ func TestSizeTypeBestFit(t *testing.T) {
 	assert.Equal(50, s.H, "s.H", t)
 }
 
+func TestSizeUpscale(t *testing.T) {
+	s := StringToSize("^!25,50")
+	assert.True(s.Valid(), "s.Valid()", t)
+	assert.True(s.Upscale, "s.Upscale", t)
+	assert.Equal(STBestFit, s.Type, "s.Type == STBestFit", t)
+	assert.Equal(25, s.W, "s.W", t)
+
+	s = StringToSize("^max")
+	assert.True(s.Valid(), "^max is valid", t)
+	assert.True(s.Upscale, "^max upscales", t)
+	assert.Equal(STMax, s.Type, "s.Type == STMax", t)
+
+	s = StringToSize("^pct:150")
+	assert.True(s.Valid(), "^pct is valid", t)
+	assert.Equal(150.0, s.Percent, "s.Percent", t)
+
+	assert.False(StringToSize("max").Upscale, "no ^ means no upscale flag", t)
+	assert.False(StringToSize("^full").Valid(), "^full is invalid", t)
+	assert.False(StringToSize("^").Valid(), "a lone ^ is invalid", t)
+	assert.False(StringToSize("^^max").Valid(), "only one ^ is allowed", t)
+
+	var fs = &FeatureSet{SizeByWh: true}
+	assert.False(fs.SupportsSize(StringToSize("^!25,50")), "^ requires sizeAboveFull", t)
+	fs.SizeAboveFull = true
+	assert.True(fs.SupportsSize(StringToSize("^!25,50")), "^ with sizeAboveFull", t)
+}
+
 func TestInvalidSizes(t *testing.T) {
 	s := Size{}
 	assert.True(!s.Valid(), "!s.Valid()", t)
@@ -108,3 +135,12 @@ func TestGetResize(t *testing.T) {
 	assert.Equal(scale.Dx(), 50, "scale-to-pct Dx", t)
 	assert.Equal(scale.Dy(), 100, "scale-to-pct Dy", t)
 }
+
+func TestSizeNeverRoundsToZero(t *testing.T) {
+	var scale = StringToSize("100,").GetResize(image.Rect(0, 0, 10000, 10))
+	assert.Equal(100, scale.Dx(), "width", t)
+	assert.Equal(1, scale.Dy(), "height is at least a pixel", t)
+
+	assert.False(StringToSize("pct:Inf").Valid(), "infinite percent", t)
+	assert.False(StringToSize("pct:NaN").Valid(), "NaN percent", t)
+}
@@ -33,26 +33,33 @@ type URL struct {
 	Info            bool
 }
 
-type pathParts struct {
-	data []string
-}
-
-func pathify(pth string) *pathParts {
-	return &pathParts{data: strings.Split(pth, "/")}
-}
-
-// pop implements a hacky but fast and effective "pop" operation that just
-// returns a blank string when there's nothing left to pop
-func (p *pathParts) pop() string {
-	var retval string
-	if len(p.data) > 0 {
-		retval, p.data = p.data[len(p.data)-1], p.data[:len(p.data)-1]
+// cutLast splits pth at its last slash, returning what comes before and after
+// it.  If there's no slash, the whole path is the last segment.
+func cutLast(pth string) (rest, last string) {
+	var i = strings.LastIndexByte(pth, '/')
+	if i < 0 {
+		return "", pth
 	}
-	return retval
+	return pth[:i], pth[i+1:]
 }
 
-func (p *pathParts) rejoin() string {
-	return strings.Join(p.data, "/")
+// splitComma splits p on commas into fields, returning false if p doesn't
+// have exactly len(fields) values.  Unlike strings.Split, it doesn't
+// allocate, so parsing the common tile request shapes is allocation-free.
+func splitComma(p string, fields []string) bool {
+	var n = len(fields) - 1
+	for i := 0; i < n; i++ {
+		var c = strings.IndexByte(p, ',')
+		if c < 0 {
+			return false
+		}
+		fields[i], p = p[:c], p[c+1:]
+	}
+	if strings.IndexByte(p, ',') >= 0 {
+		return false
+	}
+	fields[n] = p
+	return true
 }
 
 // NewURL takes a path string (no scheme, server, or prefix, just the IIIF
@@ -82,19 +89,21 @@ func NewURL(path string) (*URL, error) {
 
 	// Parse in reverse order to deal with the continuing problem of slashes not
 	// being escaped properly in all situations
-	var parts = pathify(path)
-	var qualityFormat = parts.pop()
-	var qfParts = strings.SplitN(qualityFormat, ".", 2)
-	if len(qfParts) == 2 {
-		u.Format = StringToFormat(qfParts[1])
-		u.Quality = StringToQuality(qfParts[0])
+	var rest, qualityFormat = cutLast(path)
+	if dot := strings.IndexByte(qualityFormat, '.'); dot >= 0 {
+		u.Format = StringToFormat(qualityFormat[dot+1:])
+		u.Quality = StringToQuality(qualityFormat[:dot])
 	}
-	u.Rotation = StringToRotation(parts.pop())
-	u.Size = StringToSize(parts.pop())
-	u.Region = StringToRegion(parts.pop())
+	var rotation, size, region string
+	rest, rotation = cutLast(rest)
+	rest, size = cutLast(rest)
+	rest, region = cutLast(rest)
+	u.Rotation = StringToRotation(rotation)
+	u.Size = StringToSize(size)
+	u.Region = StringToRegion(region)
 
 	// The remainder of the path has to be the ID
-	u.ID = URLToID(parts.rejoin())
+	u.ID = URLToID(rest)
 
 	// Invalid may or may not actually mean invalid, but we just let the caller
 	// try to figure it out....
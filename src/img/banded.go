@@ -0,0 +1,159 @@
+package img
+
+import (
+	"image"
+	"image/color"
+	"rais/src/iiif"
+)
+
+// bandAlign is the row multiple bands are rounded up to.  JPEG encoders work
+// in strips of up to 16 rows, so aligned bands are never split mid-strip.
+const bandAlign = 16
+
+// BandedImage is an image which is decoded one horizontal band at a time as
+// it's read, so huge outputs needn't be held in memory all at once.  Reads
+// should proceed roughly top to bottom, as encoders do: the two most recently
+// read bands are kept, and any other band is decoded again if it's needed.
+//
+// Since image reads can't fail, a band which can't be decoded reads as
+// transparent pixels; callers must check Err after encoding.
+type BandedImage struct {
+	res  *Resource
+	u    iiif.URL
+	max  Constraint
+	crop image.Rectangle
+	size image.Rectangle
+	rows int
+
+	// bands holds the most recently read band first
+	bands [2]*band
+	err   error
+}
+
+type band struct {
+	top int
+	img image.Image
+}
+
+// ApplyBanded is like Apply, but if the output would be larger than
+// bandPixels, a BandedImage is returned which decodes the request in bands of
+// roughly bandPixels as it's read.  Rotation, mirroring, and dithering need
+// the whole image at once, so requests using them are always applied in full.
+func (res *Resource) ApplyBanded(u *iiif.URL, max Constraint, bandPixels int64) (image.Image, error) {
+	var crop, scale, err = Dimensions(u, res.Decoder.GetWidth(), res.Decoder.GetHeight(), max)
+	if err != nil {
+		return nil, err
+	}
+	var area = int64(scale.Dx()) * int64(scale.Dy())
+	if bandPixels <= 0 || area <= bandPixels || u.Rotation.Degrees != 0 || u.Rotation.Mirror ||
+		(u.Quality == iiif.QBitonal && BitonalDither) {
+		return res.Apply(u, max)
+	}
+
+	var rows = int(bandPixels / int64(scale.Dx()))
+	rows = (rows + bandAlign - 1) / bandAlign * bandAlign
+	if rows < bandAlign {
+		rows = bandAlign
+	}
+	var bi = &BandedImage{res: res, u: *u, max: max, crop: crop, size: scale, rows: rows}
+
+	// Decoding the first band up front surfaces most errors before anything
+	// is sent to the client
+	bi.band(0)
+	if bi.err != nil {
+		return nil, bi.err
+	}
+	return bi, nil
+}
+
+// Err returns the first error encountered decoding a band
+func (bi *BandedImage) Err() error {
+	return bi.err
+}
+
+// Bands returns the number of bands the image is split into
+func (bi *BandedImage) Bands() int {
+	return (bi.size.Dy() + bi.rows - 1) / bi.rows
+}
+
+// ColorModel returns the first band's color model
+func (bi *BandedImage) ColorModel() color.Model {
+	var b = bi.band(0)
+	if b == nil {
+		return color.RGBAModel
+	}
+	return b.img.ColorModel()
+}
+
+// Bounds returns the output image's bounds, which always start at 0,0
+func (bi *BandedImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, bi.size.Dx(), bi.size.Dy())
+}
+
+// Opaque returns true if the color model can't represent transparency.  This
+// keeps encoders from scanning every pixel (and decoding every band) just to
+// find out.
+func (bi *BandedImage) Opaque() bool {
+	switch bi.ColorModel() {
+	case color.GrayModel, color.Gray16Model, color.YCbCrModel:
+		return true
+	}
+	return false
+}
+
+// At returns the color of the pixel at x, y, decoding its band if necessary
+func (bi *BandedImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(bi.Bounds())) {
+		return color.Transparent
+	}
+	var b = bi.band(y / bi.rows)
+	if b == nil {
+		return color.Transparent
+	}
+	var min = b.img.Bounds().Min
+	return b.img.At(min.X+x, min.Y+y-b.top)
+}
+
+// band returns the nth band, decoding it if it isn't one of the two most
+// recently read bands.  Nil is returned if the band can't be decoded.
+func (bi *BandedImage) band(n int) *band {
+	var top = n * bi.rows
+	for i, b := range bi.bands {
+		if b != nil && b.top == top {
+			if i != 0 {
+				bi.bands[0], bi.bands[1] = bi.bands[1], bi.bands[0]
+			}
+			return b
+		}
+	}
+	if bi.err != nil {
+		return nil
+	}
+
+	// Each band's source rows are those which scale to the band's output rows,
+	// rounded outward so no source data is lost at the edges
+	var bottom = top + bi.rows
+	if bottom > bi.size.Dy() {
+		bottom = bi.size.Dy()
+	}
+	var h, ch = bi.size.Dy(), bi.crop.Dy()
+	var sy0 = bi.crop.Min.Y + top*ch/h
+	var sy1 = bi.crop.Min.Y + (bottom*ch+h-1)/h
+	if sy1 <= sy0 {
+		sy1 = sy0 + 1
+	}
+
+	var u = bi.u
+	u.Region = iiif.Region{Type: iiif.RTPixel,
+		X: float64(bi.crop.Min.X), Y: float64(sy0), W: float64(bi.crop.Dx()), H: float64(sy1 - sy0)}
+	u.Size = iiif.Size{Type: iiif.STExact, W: bi.size.Dx(), H: bottom - top, Upscale: bi.u.Size.Upscale}
+	var i, err = bi.res.Apply(&u, bi.max)
+	if err != nil {
+		bi.err = err
+		return nil
+	}
+
+	var b = &band{top: top, img: i}
+	bi.bands[0], bi.bands[1] = b, bi.bands[0]
+	return b
+}
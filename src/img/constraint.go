@@ -1,10 +1,16 @@
 package img
 
+import "math"
+
 // Constraint holds maximums the server is willing to return in image dimensions
 type Constraint struct {
 	Width  int
 	Height int
 	Area   int64
+
+	// MaxUpscale caps how much larger than the requested region an image may
+	// be made, as a multiple of the region's size.  Zero means no cap.
+	MaxUpscale float64
 }
 
 // SmallerThanAny returns true if the constraint's maximums are exceeded by the
@@ -12,3 +18,12 @@ type Constraint struct {
 func (c Constraint) SmallerThanAny(w, h int) bool {
 	return w > c.Width || h > c.Height || int64(w)*int64(h) > c.Area
 }
+
+// upscaleLimit returns the largest width and height an image cropped to w x h
+// may be scaled to under MaxUpscale
+func (c Constraint) upscaleLimit(w, h int) (int, int) {
+	if c.MaxUpscale <= 0 {
+		return math.MaxInt32, math.MaxInt32
+	}
+	return int(math.Ceil(float64(w) * c.MaxUpscale)), int(math.Ceil(float64(h) * c.MaxUpscale))
+}
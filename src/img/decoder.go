@@ -1,6 +1,7 @@
 package img
 
 import (
+	"fmt"
 	"image"
 )
 
@@ -18,21 +19,91 @@ type Decoder interface {
 	SetResizeWH(int, int)
 }
 
+// GrayDecoder is an optional Decoder extension for decoders which can produce
+// grayscale images more cheaply than decoding color and converting it
+// afterward, such as by decoding only a luma component
+type GrayDecoder interface {
+	SetGray(bool)
+}
+
+// DeepDecoder is an optional Decoder extension for decoders which can keep
+// more than eight bits per sample.  When deep output is requested, sources
+// with higher precision decode to 16-bit images (image.Gray16 or
+// image.RGBA64); 8-bit sources are unaffected.
+type DeepDecoder interface {
+	SetDeep(bool)
+}
+
 // DecodeFn is a function which takes a file path and returns a Decoder and
 // optionally an error.  If the error is ErrNotHandled, the decode function is
 // stating that the filetype (or some other data inferred from the id) can't be
 // handled by this decoder.
 //
-// TODO: this needs to be changed systemically to allow for a iiif ID rather
-// than a path.  ID-to-stream lookups need to be implemented, not ID-to-path.
+// Path decoders can only read local files; see StreamDecodeFn.
 type DecodeFn func(string) (Decoder, error)
 
+// StreamDecodeFn is like DecodeFn, but reads the image from a Source, so it
+// can decode images which aren't local files
+type StreamDecodeFn func(Source) (Decoder, error)
+
+// registeredDecoder is a DecodeFn or StreamDecodeFn along with the name of
+// the backend providing it
+type registeredDecoder struct {
+	name   string
+	fn     DecodeFn
+	stream StreamDecodeFn
+}
+
+// decode runs the decoder against src.  Path decoders are given local files'
+// paths, and skip everything else.
+func (rd registeredDecoder) decode(src Source) (Decoder, error) {
+	if rd.stream != nil {
+		return rd.stream(src)
+	}
+	if f, ok := src.(*File); ok {
+		return rd.fn(f.Name())
+	}
+	return nil, ErrNotHandled
+}
+
 // fns is our internal list of registered decoder functions
-var fns []DecodeFn
+var fns []registeredDecoder
 
 // RegisterDecoder adds a decoder to the internal list of registered decoders.
 // Images we want to decode will be run through each DecodeFn until one returns
-// a Decoder and nil error.
+// a Decoder and nil error.  The decoder is given a generic name; see
+// RegisterNamedDecoder.
 func RegisterDecoder(fn DecodeFn) {
-	fns = append(fns, fn)
+	RegisterNamedDecoder("", fn)
+}
+
+// RegisterNamedDecoder adds a decoder just as RegisterDecoder does, but
+// names it so it can be chosen explicitly with NewResourceUsing.  An empty
+// name is replaced with "decoder<n>", n being the decoder's position in the
+// list.
+func RegisterNamedDecoder(name string, fn DecodeFn) {
+	register(registeredDecoder{name: name, fn: fn})
+}
+
+// RegisterStreamDecoder adds a stream decoder to the list of registered
+// decoders.  It's named just as RegisterNamedDecoder names path decoders.
+func RegisterStreamDecoder(name string, fn StreamDecodeFn) {
+	register(registeredDecoder{name: name, stream: fn})
+}
+
+func register(rd registeredDecoder) {
+	if rd.name == "" {
+		rd.name = fmt.Sprintf("decoder%d", len(fns)+1)
+	}
+	fns = append(fns, rd)
+}
+
+// DecoderNames returns the names of all registered decoders in the order
+// they're tried
+func DecoderNames() []string {
+	var names = make([]string, len(fns))
+	for i, d := range fns {
+		names[i] = d.name
+	}
+	return names
 }
@@ -13,4 +13,16 @@ func (re imgError) Error() string {
 	ErrInvalidFiletype        imgError = "invalid or unknown file type"
 	ErrDimensionsExceedLimits imgError = "requested image size exceeds server maximums"
 	ErrNotHandled             imgError = "image not handled by this decoder"
+	ErrRegionOutsideImage     imgError = "requested region is entirely outside the image"
+	ErrSizeTooSmall           imgError = "requested size is less than one pixel wide or tall"
+	ErrUnknownDecoder         imgError = "no decoder is registered with that name"
 )
+
+// UnsupportedError is returned by decoders for files they recognize but can't
+// decode because of a feature the file uses, so callers can tell these apart
+// from damaged or unreadable files
+type UnsupportedError string
+
+func (ue UnsupportedError) Error() string {
+	return string(ue)
+}
@@ -6,7 +6,6 @@
 	"image/color"
 	"image/draw"
 	"math"
-	"os"
 	"rais/src/iiif"
 	"rais/src/transform"
 )
@@ -16,6 +15,7 @@ type Resource struct {
 	Decoder  Decoder
 	ID       iiif.ID
 	FilePath string
+	Source   Source
 }
 
 // NewResource initializes and returns an Resource for the given id
@@ -24,17 +24,43 @@ type Resource struct {
 // determined by extension, so images will need standard extensions in order to
 // work.
 func NewResource(id iiif.ID, filepath string) (*Resource, error) {
-	var err error
+	return newResource(id, filepath, fns)
+}
 
+// NewResourceUsing is like NewResource, but only the named decoder is tried.
+// This allows comparing the output of two backends which can both handle an
+// image.  ErrUnknownDecoder is returned if no decoder has the given name.
+func NewResourceUsing(name string, id iiif.ID, filepath string) (*Resource, error) {
+	for _, d := range fns {
+		if d.name == name {
+			return newResource(id, filepath, []registeredDecoder{d})
+		}
+	}
+	return nil, ErrUnknownDecoder
+}
+
+// NewResourceFromSource is like NewResource, but decodes an already-open
+// Source.  Only stream decoders and, for local files, path decoders are
+// tried.
+func NewResourceFromSource(id iiif.ID, src Source) (*Resource, error) {
+	return decodeResource(id, src.Name(), src, fns)
+}
+
+func newResource(id iiif.ID, filepath string, decoders []registeredDecoder) (*Resource, error) {
 	// First, does the file exist?
-	if _, err = os.Stat(filepath); err != nil {
-		return nil, ErrDoesNotExist
+	var src, err = OpenSource(filepath)
+	if err != nil {
+		return nil, err
 	}
+	return decodeResource(id, filepath, src, decoders)
+}
 
+func decodeResource(id iiif.ID, filepath string, src Source, decoders []registeredDecoder) (*Resource, error) {
 	// File exists - is a decoder registered for it?
 	var d Decoder
-	for _, decodeFn := range fns {
-		d, err = decodeFn(filepath)
+	var err error
+	for _, rd := range decoders {
+		d, err = rd.decode(src)
 		if err == nil && d != nil {
 			break
 		}
@@ -48,23 +74,29 @@ func NewResource(id iiif.ID, filepath string) (*Resource, error) {
 		return nil, ErrInvalidFiletype
 	}
 
-	img := &Resource{ID: id, Decoder: d, FilePath: filepath}
+	img := &Resource{ID: id, Decoder: d, FilePath: filepath, Source: src}
 	return img, nil
 }
 
 // getResizeWithConstraints returns a scaled rectangle, computing the best fit
-// for the given dimensions combined with our local constraints
-func getResizeWithConstraints(crop image.Rectangle, max Constraint) image.Rectangle {
+// for the given dimensions combined with our local constraints.  Unless
+// upscale is true, the result is never larger than crop.
+func getResizeWithConstraints(crop image.Rectangle, max Constraint, upscale bool) image.Rectangle {
 	// First figure out the ideal width and height within our max width and height
 	cx := crop.Dx()
 	cy := crop.Dy()
 
-	// Sanity - we don't actually want any upscaling
-	if max.Width > cx {
-		max.Width = cx
+	// Sanity - we don't actually want any upscaling unless it was explicitly
+	// requested, and then only as far as the constraint allows
+	var limitW, limitH = cx, cy
+	if upscale {
+		limitW, limitH = max.upscaleLimit(cx, cy)
+	}
+	if max.Width > limitW {
+		max.Width = limitW
 	}
-	if max.Height > cy {
-		max.Height = cy
+	if max.Height > limitH {
+		max.Height = limitH
 	}
 
 	s := iiif.Size{Type: iiif.STBestFit, W: max.Width, H: max.Height}
@@ -85,42 +117,96 @@ func getResizeWithConstraints(crop image.Rectangle, max Constraint) image.Rectan
 	return image.Rect(0, 0, int(xf), int(yf))
 }
 
-// Apply runs all image manipulation operations described by the IIIF URL, and
-// returns an image.Image ready for encoding to the client
-func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
-	// Crop and resize have to be prepared before we can decode
-	w, h := res.Decoder.GetWidth(), res.Decoder.GetHeight()
-	crop := u.Region.GetCrop(w, h)
+// Dimensions computes the crop rectangle and scaled output size for applying
+// the IIIF URL to an image of the given width and height.  Regions which
+// extend past the image are clipped to it.  If the final image would be
+// larger than the constraint allows, ErrDimensionsExceedLimits is returned;
+// regions entirely outside the image and sizes which round to nothing are
+// also errors.
+func Dimensions(u *iiif.URL, w, h int, max Constraint) (crop, scale image.Rectangle, err error) {
+	crop = u.Region.GetCrop(w, h).Intersect(image.Rect(0, 0, w, h))
+	if crop.Empty() {
+		return crop, scale, ErrRegionOutsideImage
+	}
+
+	// Region and size are computed on the source, before rotation, so only the
+	// region itself is ever decoded.  Our constraints apply to the rotated
+	// output, though, so quarter turns need them swapped.
+	var quarterTurn = u.Rotation.Degrees == 90 || u.Rotation.Degrees == 270
+	var arbitrary = isArbitrary(u.Rotation)
 
 	// If size is "max", we actually want the "best fit" size type, but with our
-	// constraints used instead of a user-supplied value.
-	var scale image.Rectangle
-	if u.Size.Type == iiif.STMax {
-		scale = getResizeWithConstraints(crop, max)
+	// constraints used instead of a user-supplied value.  "full" is treated the
+	// same way, so it's clamped to our constraints rather than rejected.
+	if u.Size.Type == iiif.STMax || u.Size.Type == iiif.STFull {
+		var srcMax = max
+		if quarterTurn {
+			srcMax.Width, srcMax.Height = max.Height, max.Width
+		}
+		scale = getResizeWithConstraints(crop, srcMax, u.Size.Upscale)
+		if arbitrary {
+			scale = fitRotated(scale, u.Rotation.Degrees, max)
+		}
 	} else {
 		scale = u.Size.GetResize(crop)
+		var limitW, limitH = max.upscaleLimit(crop.Dx(), crop.Dy())
+		if scale.Dx() > limitW || scale.Dy() > limitH {
+			return crop, scale, ErrDimensionsExceedLimits
+		}
+	}
+
+	if scale.Dx() < 1 || scale.Dy() < 1 {
+		return crop, scale, ErrSizeTooSmall
 	}
 
 	// Determine the final image output dimensions to test size constraints
 	sw, sh := scale.Dx(), scale.Dy()
-	if u.Rotation.Degrees == 90 || u.Rotation.Degrees == 270 {
+	if quarterTurn {
 		sw, sh = sh, sw
 	}
+	if arbitrary {
+		sw, sh = transform.RotatedSize(sw, sh, u.Rotation.Degrees)
+	}
 	if max.SmallerThanAny(sw, sh) {
-		return nil, ErrDimensionsExceedLimits
+		return crop, scale, ErrDimensionsExceedLimits
+	}
+
+	return crop, scale, nil
+}
+
+// Apply runs all image manipulation operations described by the IIIF URL, and
+// returns an image.Image ready for encoding to the client
+func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
+	// Crop and resize have to be prepared before we can decode
+	crop, scale, err := Dimensions(u, res.Decoder.GetWidth(), res.Decoder.GetHeight(), max)
+	if err != nil {
+		return nil, err
 	}
 
 	res.Decoder.SetCrop(crop)
 	res.Decoder.SetResizeWH(scale.Dx(), scale.Dy())
 
+	// Gray and bitonal output only need luma, which some decoders can produce
+	// at the native resolution level without decoding and resizing full color
+	if gd, ok := res.Decoder.(GrayDecoder); ok {
+		gd.SetGray(u.Quality == iiif.QGray || u.Quality == iiif.QBitonal)
+	}
+	if dd, ok := res.Decoder.(DeepDecoder); ok {
+		dd.SetDeep(deepOutput(u))
+	}
+
 	img, err := res.Decoder.DecodeImage()
 	if err != nil {
 		return nil, errors.New("unable to decode image: " + err.Error())
 	}
 
-	if u.Rotation.Mirror || u.Rotation.Degrees != 0 {
+	var arbitrary = isArbitrary(u.Rotation)
+	if u.Rotation.Mirror || (u.Rotation.Degrees != 0 && !arbitrary) {
 		img = rotate(img, u.Rotation)
 	}
+	if arbitrary {
+		img = transform.RotateArbitrary(img, u.Rotation.Degrees, rotationBackground(u))
+	}
 
 	// Unless I'm missing something, QColor doesn't actually change an image -
 	// e.g., if it's already color, nothing happens.  If it's grayscale, there's
@@ -135,6 +221,63 @@ func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 	return img, nil
 }
 
+// PreserveDepth turns on 16-bit output for sources with more than eight bits
+// per sample, for the formats which can store it (PNG and TIFF)
+var PreserveDepth bool
+
+// deepOutput returns true if the request should keep its source's full bit
+// depth.  Bitonal output is one bit no matter what we decode.
+func deepOutput(u *iiif.URL) bool {
+	if !PreserveDepth || u.Quality == iiif.QBitonal {
+		return false
+	}
+	return u.Format == iiif.FmtPNG || u.Format == iiif.FmtTIF
+}
+
+// isArbitrary returns true if the rotation isn't a multiple of 90 degrees
+func isArbitrary(rot iiif.Rotation) bool {
+	return math.Mod(rot.Degrees, 90) != 0
+}
+
+// fitRotated shrinks scale, if necessary, so that the canvas needed to hold
+// it after an arbitrary rotation fits within max
+func fitRotated(scale image.Rectangle, degrees float64, max Constraint) image.Rectangle {
+	var sw, sh = scale.Dx(), scale.Dy()
+	var rw, rh = transform.RotatedSize(sw, sh, degrees)
+	if !max.SmallerThanAny(rw, rh) {
+		return scale
+	}
+
+	var mult = math.Min(float64(max.Width)/float64(rw), float64(max.Height)/float64(rh))
+	mult = math.Min(mult, math.Sqrt(float64(max.Area)/(float64(rw)*float64(rh))))
+	for mult > 0 {
+		var w, h = int(mult * float64(sw)), int(mult * float64(sh))
+		if w < 1 || h < 1 {
+			break
+		}
+		rw, rh = transform.RotatedSize(w, h, degrees)
+		if !max.SmallerThanAny(rw, rh) {
+			return image.Rect(0, 0, w, h)
+		}
+		mult *= 0.99
+	}
+	return scale
+}
+
+// rotationBackground returns the color used to fill the corners exposed by an
+// arbitrary rotation: transparent where the output can show it, otherwise
+// white
+func rotationBackground(u *iiif.URL) color.Color {
+	if u.Format == iiif.FmtPNG && u.Quality != iiif.QGray && u.Quality != iiif.QBitonal {
+		return color.Transparent
+	}
+	return color.White
+}
+
+// rotate mirrors the image if requested, and then applies any quarter-turn
+// rotation.  The transforms only work on gray and RGBA images (8- or 16-bit),
+// so anything else (e.g., YCbCr from a JPEG decoder) is converted to RGBA
+// first.
 func rotate(img image.Image, rot iiif.Rotation) image.Image {
 	var r transform.Rotator
 	switch img0 := img.(type) {
@@ -142,6 +285,15 @@ func rotate(img image.Image, rot iiif.Rotation) image.Image {
 		r = &transform.GrayRotator{Img: img0}
 	case *image.RGBA:
 		r = &transform.RGBARotator{Img: img0}
+	case *image.Gray16:
+		r = &transform.Gray16Rotator{Img: img0}
+	case *image.RGBA64:
+		r = &transform.RGBA64Rotator{Img: img0}
+	default:
+		var b = img.Bounds()
+		var rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+		r = &transform.RGBARotator{Img: rgba}
 	}
 
 	if rot.Mirror {
@@ -167,21 +319,76 @@ func grayscale(img image.Image) image.Image {
 	}
 
 	b := img.Bounds()
+	if cm == color.RGBA64Model {
+		dst := image.NewGray16(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+		return dst
+	}
 	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
 	draw.Draw(dst, b, img, b.Min, draw.Src)
 	return dst
 }
 
+// BitonalThreshold is the gray level above which bitonal output is white
+var BitonalThreshold uint8 = 190
+
+// BitonalDither turns on Floyd-Steinberg dithering for bitonal output, which
+// spreads each pixel's rounding error to its neighbors rather than cutting at
+// the threshold.  Halftones and faint text on scans survive much better.
+var BitonalDither bool
+
 func bitonal(img image.Image) image.Image {
 	// First turn the image into 8-bit grayscale for easier manipulation
-	imgGray := grayscale(img).(*image.Gray)
+	imgGray, ok := grayscale(img).(*image.Gray)
+	if !ok {
+		b := img.Bounds()
+		imgGray = image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(imgGray, imgGray.Bounds(), img, b.Min, draw.Src)
+	}
 	b := imgGray.Bounds()
 	imgBitonal := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
-	for i, pixel := range imgGray.Pix {
-		if pixel > 190 {
-			imgBitonal.Pix[i] = 255
+	if BitonalDither {
+		dither(imgGray, imgBitonal, BitonalThreshold)
+		return imgBitonal
+	}
+
+	for y := 0; y < b.Dy(); y++ {
+		src := imgGray.Pix[y*imgGray.Stride : y*imgGray.Stride+b.Dx()]
+		dst := imgBitonal.Pix[y*imgBitonal.Stride:]
+		for x, pixel := range src {
+			if pixel > BitonalThreshold {
+				dst[x] = 255
+			}
 		}
 	}
 
 	return imgBitonal
 }
+
+// dither writes a Floyd-Steinberg dithered copy of src into dst, which must
+// be the same size and all black.  Errors for the current and next row are
+// kept in two buffers with a pixel of padding on each side.
+func dither(src, dst *image.Gray, threshold uint8) {
+	var w, h = src.Bounds().Dx(), src.Bounds().Dy()
+	var cur, next = make([]int, w+2), make([]int, w+2)
+	for y := 0; y < h; y++ {
+		var row = src.Pix[y*src.Stride:]
+		var out = dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			var val = int(row[x]) + cur[x+1]/16
+			var e = val
+			if val > int(threshold) {
+				out[x] = 255
+				e = val - 255
+			}
+			cur[x+2] += e * 7
+			next[x] += e * 3
+			next[x+1] += e * 5
+			next[x+2] += e
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = 0
+		}
+	}
+}
@@ -2,6 +2,7 @@
 
 import (
 	"image"
+	"image/color"
 	"math"
 	"rais/src/iiif"
 	"testing"
@@ -9,7 +10,7 @@
 	"github.com/uoregon-libraries/gopkg/assert"
 )
 
-var unlimited = Constraint{math.MaxInt32, math.MaxInt32, math.MaxInt64}
+var unlimited = Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
 
 type fakeDecoder struct {
 	// Fake image dimensions and other metadata
@@ -21,6 +22,17 @@ type fakeDecoder struct {
 	crop    image.Rectangle
 	resizeW int
 	resizeH int
+	gray    bool
+}
+
+// grayDecoder is a fakeDecoder which can decode grayscale images directly
+type grayDecoder struct {
+	fakeDecoder
+}
+
+func (d *grayDecoder) SetGray(gray bool) { d.gray = gray }
+func (d *grayDecoder) DecodeImage() (image.Image, error) {
+	return image.NewGray(image.Rect(0, 0, d.resizeW, d.resizeH)), nil
 }
 
 func (d *fakeDecoder) DecodeImage() (image.Image, error) { return nil, nil }
@@ -115,3 +127,289 @@ func TestMaxSizeConstrainArea(t *testing.T) {
 	assert.Equal(500, d.resizeW, "resize width", t)
 	assert.Equal(75, d.resizeH, "resize height", t)
 }
+
+func TestApplyGrayDecoder(t *testing.T) {
+	var d = &grayDecoder{fakeDecoder{w: 400, h: 400, l: 1}}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/full/full/0/bitonal.png")
+	res.Apply(url, unlimited)
+	assert.True(d.gray, "bitonal requests ask for gray decoding", t)
+
+	url, _ = iiif.NewURL("identifier/full/full/0/default.png")
+	res.Apply(url, unlimited)
+	assert.False(d.gray, "default requests don't ask for gray decoding", t)
+}
+
+func TestRotatedRegionOfInterest(t *testing.T) {
+	var d = &grayDecoder{fakeDecoder{w: 1000, h: 4000, l: 4}}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/0,2000,500,1000/250,/90/default.jpg")
+	var _, err = res.Apply(url, unlimited)
+	assert.True(err == nil, "rotated Apply should not have errors", t)
+	assert.Equal(image.Rect(0, 2000, 500, 3000), d.crop, "only the source region is decoded", t)
+	assert.Equal(250, d.resizeW, "pre-rotation width", t)
+	assert.Equal(500, d.resizeH, "pre-rotation height", t)
+}
+
+func TestRotatedMaxSize(t *testing.T) {
+	var d = &grayDecoder{fakeDecoder{w: 1000, h: 4000, l: 4}}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/full/max/90/default.jpg")
+	var _, err = res.Apply(url, Constraint{Width: 2000, Height: 500, Area: math.MaxInt64})
+	assert.True(err == nil, "rotated max should fit the constraints", t)
+	assert.Equal(500, d.resizeW, "pre-rotation width fits the max height", t)
+	assert.Equal(2000, d.resizeH, "pre-rotation height fits the max width", t)
+}
+
+func TestArbitraryRotation(t *testing.T) {
+	var d = &grayDecoder{fakeDecoder{w: 100, h: 100, l: 1}}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/full/full/22.5/default.jpg")
+	var m, err = res.Apply(url, unlimited)
+	assert.True(err == nil, "arbitrary rotation should not have errors", t)
+	assert.Equal(image.Point{131, 131}, m.Bounds().Size(), "canvas grows to fit the rotated image", t)
+	assert.Equal(color.Gray{255}, m.At(0, 0), "jpg corners are white", t)
+	assert.Equal(color.Gray{0}, m.At(65, 65), "center is the (black) image", t)
+
+	url, _ = iiif.NewURL("identifier/full/full/45/default.png")
+	m, err = res.Apply(url, unlimited)
+	assert.True(err == nil, "arbitrary rotation should not have errors", t)
+	var _, _, _, a = m.At(0, 0).RGBA()
+	assert.Equal(uint32(0), a, "png corners are transparent", t)
+}
+
+func TestArbitraryRotationMaxSize(t *testing.T) {
+	var d = &grayDecoder{fakeDecoder{w: 1000, h: 1000, l: 1}}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/full/max/45/default.jpg")
+	var m, err = res.Apply(url, Constraint{Width: 500, Height: 500, Area: math.MaxInt64})
+	assert.True(err == nil, "rotated max should fit the constraints", t)
+	assert.True(m.Bounds().Dx() <= 500 && m.Bounds().Dy() <= 500, "rotated canvas fits", t)
+	assert.True(d.resizeW > 340, "image isn't shrunk more than necessary", t)
+
+	url, _ = iiif.NewURL("identifier/full/500,/45/default.jpg")
+	_, err = res.Apply(url, Constraint{Width: 500, Height: 500, Area: math.MaxInt64})
+	assert.Equal(ErrDimensionsExceedLimits, err, "explicit size too big once rotated", t)
+}
+
+func TestUpscale(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 200, l: 1}
+	var c = Constraint{Width: 2000, Height: 2000, Area: math.MaxInt64, MaxUpscale: 2}
+
+	var url, _ = iiif.NewURL("identifier/full/^max/0/default.jpg")
+	var _, scale, err = Dimensions(url, d.w, d.h, c)
+	assert.NilError(err, "^max should upscale", t)
+	assert.Equal(image.Point{800, 400}, scale.Size(), "^max upscales as far as MaxUpscale allows", t)
+
+	url, _ = iiif.NewURL("identifier/full/max/0/default.jpg")
+	_, scale, _ = Dimensions(url, d.w, d.h, c)
+	assert.Equal(image.Point{400, 200}, scale.Size(), "max never upscales", t)
+
+	url, _ = iiif.NewURL("identifier/0,0,100,100/^200,/0/default.jpg")
+	_, scale, err = Dimensions(url, d.w, d.h, c)
+	assert.NilError(err, "explicit upscale within the limit", t)
+	assert.Equal(image.Point{200, 200}, scale.Size(), "explicit upscale size", t)
+
+	url, _ = iiif.NewURL("identifier/0,0,100,100/^250,/0/default.jpg")
+	_, _, err = Dimensions(url, d.w, d.h, c)
+	assert.Equal(ErrDimensionsExceedLimits, err, "explicit upscale beyond the limit", t)
+
+	c.MaxUpscale = 0
+	c.Width = 1000
+	url, _ = iiif.NewURL("identifier/full/^max/0/default.jpg")
+	_, scale, _ = Dimensions(url, d.w, d.h, c)
+	assert.Equal(image.Point{1000, 500}, scale.Size(), "^max without a cap is limited by the max width", t)
+}
+
+func TestAbsurdDimensions(t *testing.T) {
+	var url, _ = iiif.NewURL("identifier/350,100,100,100/full/0/default.jpg")
+	var crop, _, err = Dimensions(url, 400, 200, unlimited)
+	assert.NilError(err, "region past the edge is clipped", t)
+	assert.Equal(image.Rect(350, 100, 400, 200), crop, "clipped region", t)
+
+	url, _ = iiif.NewURL("identifier/400,0,100,100/full/0/default.jpg")
+	_, _, err = Dimensions(url, 400, 200, unlimited)
+	assert.Equal(ErrRegionOutsideImage, err, "region outside the image", t)
+
+	url, _ = iiif.NewURL("identifier/full/pct:0.1/0/default.jpg")
+	_, _, err = Dimensions(url, 400, 200, unlimited)
+	assert.Equal(ErrSizeTooSmall, err, "size which rounds to nothing", t)
+}
+
+// pixelDecoder returns a copy of its image, ignoring crop and resize
+type pixelDecoder struct {
+	fakeDecoder
+	img image.Image
+}
+
+func (d *pixelDecoder) DecodeImage() (image.Image, error) { return d.img, nil }
+
+// deepDecoder is a fakeDecoder which decodes 16-bit images on request
+type deepDecoder struct {
+	fakeDecoder
+	deep bool
+}
+
+func (d *deepDecoder) SetDeep(deep bool) { d.deep = deep }
+func (d *deepDecoder) DecodeImage() (image.Image, error) {
+	var r = image.Rect(0, 0, d.resizeW, d.resizeH)
+	if !d.deep {
+		return image.NewRGBA(r), nil
+	}
+	var m = image.NewRGBA64(r)
+	m.SetRGBA64(0, 0, color.RGBA64{0x1234, 0x5678, 0x9abc, 0xffff})
+	return m, nil
+}
+
+func TestApplyDeepDecoder(t *testing.T) {
+	PreserveDepth = true
+	defer func() { PreserveDepth = false }()
+
+	var d = &deepDecoder{fakeDecoder: fakeDecoder{w: 3, h: 2, l: 1}}
+	var res = &Resource{Decoder: d}
+	for path, deep := range map[string]bool{
+		"identifier/full/full/0/default.png": true,
+		"identifier/full/full/0/default.tif": true,
+		"identifier/full/full/0/default.jpg": false,
+		"identifier/full/full/0/bitonal.png": false,
+	} {
+		var url, _ = iiif.NewURL(path)
+		res.Apply(url, unlimited)
+		assert.Equal(deep, d.deep, path, t)
+	}
+
+	// Rotation and gray conversion keep all 16 bits
+	var url, _ = iiif.NewURL("identifier/full/full/90/default.png")
+	var m, _ = res.Apply(url, unlimited)
+	assert.Equal(color.RGBA64{0x1234, 0x5678, 0x9abc, 0xffff}, m.At(1, 0), "rotated 16-bit pixel", t)
+	url, _ = iiif.NewURL("identifier/full/full/0/gray.png")
+	m, _ = res.Apply(url, unlimited)
+	assert.Equal(color.Gray16Model, m.ColorModel(), "gray output stays 16-bit", t)
+
+	PreserveDepth = false
+	url, _ = iiif.NewURL("identifier/full/full/0/default.png")
+	res.Apply(url, unlimited)
+	assert.False(d.deep, "deep decoding is off unless PreserveDepth is set", t)
+}
+
+func TestMirroring(t *testing.T) {
+	var src = image.NewYCbCr(image.Rect(0, 0, 2, 1), image.YCbCrSubsampleRatio444)
+	src.Y[0], src.Y[1] = 10, 200
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 128, 128
+	}
+	var res = &Resource{Decoder: &pixelDecoder{fakeDecoder{w: 2, h: 1, l: 1}, src}}
+	var luma = func(m image.Image, x, y int) uint8 {
+		return color.GrayModel.Convert(m.At(x, y)).(color.Gray).Y
+	}
+
+	var url, _ = iiif.NewURL("identifier/full/full/!0/default.png")
+	var m, err = res.Apply(url, unlimited)
+	assert.True(err == nil, "mirroring a YCbCr image should not have errors", t)
+	assert.Equal(uint8(200), luma(m, 0, 0), "mirrored left pixel", t)
+	assert.Equal(uint8(10), luma(m, 1, 0), "mirrored right pixel", t)
+
+	// Mirroring happens before rotation: the right-hand pixel ends up on top
+	url, _ = iiif.NewURL("identifier/full/full/!90/default.png")
+	m, _ = res.Apply(url, unlimited)
+	assert.Equal(image.Point{1, 2}, m.Bounds().Size(), "rotated size", t)
+	assert.Equal(uint8(200), luma(m, 0, 0), "top pixel", t)
+	assert.Equal(uint8(10), luma(m, 0, 1), "bottom pixel", t)
+}
+
+func TestBitonal(t *testing.T) {
+	defer func() { BitonalThreshold, BitonalDither = 190, false }()
+
+	var src = image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+	var countWhite = func(m image.Image) int {
+		var n int
+		for _, p := range m.(*image.Gray).Pix {
+			if p == 255 {
+				n++
+			} else if p != 0 {
+				t.Fatalf("bitonal output has gray value %d", p)
+			}
+		}
+		return n
+	}
+
+	assert.Equal(0, countWhite(bitonal(src)), "mid-gray is black with the default threshold", t)
+	BitonalThreshold = 100
+	assert.Equal(10000, countWhite(bitonal(src)), "mid-gray is white with a lower threshold", t)
+
+	BitonalThreshold = 127
+	BitonalDither = true
+	var n = countWhite(bitonal(src))
+	assert.True(n > 4900 && n < 5100, "dithered mid-gray is about half white", t)
+
+	var rgba = image.NewRGBA(image.Rect(0, 0, 10, 10))
+	assert.Equal(0, countWhite(bitonal(rgba)), "color images are converted", t)
+	var g16 = image.NewGray16(image.Rect(0, 0, 10, 10))
+	assert.Equal(0, countWhite(bitonal(g16)), "16-bit gray images are converted", t)
+}
+
+// rowDecoder is a fakeDecoder which returns images whose pixels encode their
+// output row, so bands can be checked for placement
+type rowDecoder struct {
+	fakeDecoder
+	decodes int
+}
+
+func (d *rowDecoder) DecodeImage() (image.Image, error) {
+	d.decodes++
+	var i = image.NewGray(image.Rect(0, 0, d.resizeW, d.resizeH))
+	for y := 0; y < d.resizeH; y++ {
+		// The source row at the top of this output row, scaled to 0-255
+		var sy = d.crop.Min.Y + y*d.crop.Dy()/d.resizeH
+		for x := 0; x < d.resizeW; x++ {
+			i.SetGray(x, y, color.Gray{uint8(sy * 256 / d.h)})
+		}
+	}
+	return i, nil
+}
+
+func TestApplyBanded(t *testing.T) {
+	var d = &rowDecoder{fakeDecoder: fakeDecoder{w: 1000, h: 1000}}
+	var res = &Resource{Decoder: d}
+	var u, _ = iiif.NewURL("identifier/full/500,/0/default.jpg")
+
+	var i, err = res.ApplyBanded(u, unlimited, 0)
+	assert.NilError(err, "unbanded apply", t)
+	var _, banded = i.(*BandedImage)
+	assert.False(banded, "no band size means no banding", t)
+
+	i, err = res.ApplyBanded(u, unlimited, 500*500)
+	assert.NilError(err, "small apply", t)
+	_, banded = i.(*BandedImage)
+	assert.False(banded, "output within the band size isn't banded", t)
+
+	d.decodes = 0
+	i, err = res.ApplyBanded(u, unlimited, 500*40)
+	assert.NilError(err, "banded apply", t)
+	var bi, ok = i.(*BandedImage)
+	assert.True(ok, "large output is banded", t)
+	assert.Equal(11, bi.Bands(), "40-row bands are rounded up to 48 rows", t)
+	assert.Equal(image.Rect(0, 0, 500, 500), bi.Bounds(), "bounds", t)
+	assert.True(bi.Opaque(), "gray bands are opaque", t)
+	assert.Equal(1, d.decodes, "first band is decoded up front", t)
+
+	for y := 0; y < 500; y++ {
+		var g = bi.At(250, y).(color.Gray).Y
+		if g != uint8(y*2*256/1000) {
+			t.Fatalf("row %d has value %d", y, g)
+		}
+	}
+	assert.Equal(11, d.decodes, "each band is decoded once when read in order", t)
+	bi.At(0, 490)
+	bi.At(0, 470)
+	assert.Equal(11, d.decodes, "the previous band is kept", t)
+	assert.NilError(bi.Err(), "no band errors", t)
+
+	u, _ = iiif.NewURL("identifier/full/500,/90/default.jpg")
+	i, _ = res.ApplyBanded(u, unlimited, 500*40)
+	_, banded = i.(*BandedImage)
+	assert.False(banded, "rotated output isn't banded", t)
+}
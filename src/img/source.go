@@ -0,0 +1,96 @@
+package img
+
+import (
+	"io"
+	"os"
+)
+
+// Source is an image's data.  Stream decoders read images through a Source
+// rather than a path, so an image needn't be a local file: it can be an S3
+// object, a remote URL, or anything else which can be read at arbitrary
+// offsets.
+type Source interface {
+	io.ReaderAt
+
+	// Size returns the length of the source's data in bytes
+	Size() int64
+
+	// Name returns the source's path or URL.  Decoders use its extension to
+	// decide whether they handle the source, so it shouldn't include a
+	// query string.
+	Name() string
+
+	// Stat returns the source's size and modification time
+	Stat() os.FileInfo
+}
+
+// SourceFn opens the source at a path or URL.  If the error is
+// ErrNotHandled, the function is stating that it doesn't open that kind of
+// path, and the next is tried.
+type SourceFn func(string) (Source, error)
+
+// sourceFns is our internal list of registered source openers
+var sourceFns []SourceFn
+
+// RegisterSourceOpener adds a function for opening sources which aren't
+// local files, such as remote URLs.  Openers are tried in the order they're
+// registered; paths none of them handle are opened as local files.
+func RegisterSourceOpener(fn SourceFn) {
+	sourceFns = append(sourceFns, fn)
+}
+
+// OpenSource returns the source at path using the registered openers,
+// falling back to OpenFile
+func OpenSource(path string) (Source, error) {
+	for _, fn := range sourceFns {
+		var s, err = fn(path)
+		if err == ErrNotHandled {
+			continue
+		}
+		return s, err
+	}
+	return OpenFile(path)
+}
+
+// File is a local file Source.  The file is opened for each read rather than
+// held open, so a File never needs closing, but decoders should read in
+// sizable chunks.
+type File struct {
+	path string
+	info os.FileInfo
+}
+
+// OpenFile returns the local file at path as a Source, or ErrDoesNotExist if
+// it can't be read
+func OpenFile(path string) (*File, error) {
+	var info, err = os.Stat(path)
+	if err != nil {
+		return nil, ErrDoesNotExist
+	}
+	return &File{path: path, info: info}, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	var fh, err = os.Open(f.path)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	return fh.ReadAt(p, off)
+}
+
+// Size returns the file's size as of when it was opened
+func (f *File) Size() int64 {
+	return f.info.Size()
+}
+
+// Name returns the file's path
+func (f *File) Name() string {
+	return f.path
+}
+
+// Stat returns the file's info as of when it was opened
+func (f *File) Stat() os.FileInfo {
+	return f.info
+}
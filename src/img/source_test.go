@@ -0,0 +1,68 @@
+package img
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// memSource is an in-memory Source standing in for a remote one
+type memSource struct {
+	*bytes.Reader
+	name string
+}
+
+func (s memSource) Name() string      { return s.name }
+func (s memSource) Stat() os.FileInfo { return nil }
+
+func TestDecoderSources(t *testing.T) {
+	var f, _ = ioutil.TempFile("", "rais-source-*.fake")
+	f.WriteString("local data")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var oldFns, oldSources = fns, sourceFns
+	defer func() { fns, sourceFns = oldFns, oldSources }()
+	fns, sourceFns = nil, nil
+
+	RegisterSourceOpener(func(path string) (Source, error) {
+		if !strings.HasPrefix(path, "mem:") {
+			return nil, ErrNotHandled
+		}
+		return memSource{bytes.NewReader([]byte("remote data")), path}, nil
+	})
+
+	var pathCalls int
+	RegisterNamedDecoder("path", func(path string) (Decoder, error) {
+		pathCalls++
+		return nil, ErrNotHandled
+	})
+	var read string
+	RegisterStreamDecoder("", func(src Source) (Decoder, error) {
+		var buf = make([]byte, src.Size())
+		src.ReadAt(buf, 0)
+		read = string(buf)
+		return &fakeDecoder{w: 10, h: 10}, nil
+	})
+	assert.Equal("path,decoder2", strings.Join(DecoderNames(), ","), "decoder names", t)
+
+	var res, err = NewResource("local", f.Name())
+	assert.NilError(err, "local resource", t)
+	assert.Equal(1, pathCalls, "path decoders see local files", t)
+	assert.Equal("local data", read, "stream decoders read local files", t)
+	assert.Equal(f.Name(), res.Source.Name(), "local source name", t)
+	assert.True(res.Source.Stat().ModTime().After(time.Time{}), "local source stat", t)
+
+	res, err = NewResource("remote", "mem:foo")
+	assert.NilError(err, "opened resource", t)
+	assert.Equal(1, pathCalls, "path decoders skip sources which aren't files", t)
+	assert.Equal("remote data", read, "stream decoders read opened sources", t)
+
+	_, err = NewResource("missing", f.Name()+".missing")
+	assert.Equal(ErrDoesNotExist, err, "missing local files", t)
+}
@@ -0,0 +1,69 @@
+package jp2info
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Container tells us what kind of JPEG 2000 file we have
+type Container string
+
+// Known containers.  JPX (Part 2) files are decoded from their first
+// codestream, as long as it's stored in one piece.  JPM (Part 6) files are
+// compound documents of pages and layers, which RAIS can't render.
+const (
+	ContainerUnknown    Container = ""
+	ContainerJP2        Container = "JP2"
+	ContainerJPX        Container = "JPX"
+	ContainerJPM        Container = "JPM"
+	ContainerCodestream Container = "J2C"
+)
+
+// FTYP is the file type box, which must immediately follow the JP2 signature
+var FTYP = []byte{0x66, 0x74, 0x79, 0x70} // "ftyp"
+
+// identifyLen is how much of a file identify needs: the signature box, plus
+// the file type box's length, type, and brand
+const identifyLen = 24
+
+// identify returns the container for a file starting with the given bytes.
+// JP2 signatures with unknown brands are treated as JP2 files, as they always
+// have been.
+func identify(header []byte) Container {
+	if bytes.HasPrefix(header, SOCSIZ) {
+		return ContainerCodestream
+	}
+	if !bytes.HasPrefix(header, JP2HEADER) {
+		return ContainerUnknown
+	}
+	if len(header) < identifyLen || !bytes.Equal(header[16:20], FTYP) {
+		return ContainerJP2
+	}
+	switch string(header[20:24]) {
+	case "jpx ":
+		return ContainerJPX
+	case "jpm ":
+		return ContainerJPM
+	}
+	return ContainerJP2
+}
+
+// ReadContainer reads just enough of r to identify its container.  Data which
+// isn't JPEG 2000 returns ContainerUnknown.
+func ReadContainer(r io.Reader) Container {
+	var header = make([]byte, identifyLen)
+	var n, _ = io.ReadFull(r, header)
+	return identify(header[:n])
+}
+
+// UnsupportedError is returned when a file is JPEG 2000, but uses features we
+// can't decode
+type UnsupportedError struct {
+	Container Container
+	Reason    string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported %s file: %s", e.Container, e.Reason)
+}
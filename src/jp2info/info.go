@@ -22,6 +22,9 @@
 
 // Info stores a variety of data we can easily scan from a jpeg2000 header
 type Info struct {
+	// Container is the file's format: a JP2 or JPX file, or a raw codestream
+	Container Container
+
 	// Main header info
 	Width, Height uint32
 	Comps         uint16
@@ -32,6 +35,11 @@ type Info struct {
 	ColorSpace   ColorSpace
 	Prec, Approx uint8
 
+	// Vertical and horizontal resolution in grid points per meter, from the
+	// capture resolution box if present, otherwise the display resolution box.
+	// These are zero if the file has neither.
+	VRes, HRes float64
+
 	// From SIZ box - this data can replace the main header data and
 	// some of the colorspace data if necessary
 	LSiz, RSiz     uint16
@@ -46,6 +54,54 @@ type Info struct {
 	SCod   uint8
 	SGCod  uint32
 	Levels uint8
+
+	// Pointer marker data: TLM and PLM come from the main header, while PLT is
+	// only noted if it's in the first tile-part header.  TileParts is only
+	// populated when TLM markers are present, and is what lets us seek to a
+	// tile.  Packet lengths are only reported here; openjpeg reads those
+	// itself once it's positioned at a tile.
+	TLM       bool
+	PLM       bool
+	PLT       bool
+	TileParts []TilePart
+
+	// Byte offsets of the codestream's SOC marker and the first tile-part's
+	// SOT marker, and where the main header's TLM segments are.  Along with
+	// TileParts, these let a decoder seek straight to a tile's data.
+	// TilePartOffset is zero if the first tile-part wasn't found.
+	CodestreamOffset int64
+	TilePartOffset   int64
+	TLMSegments      []Segment
+}
+
+// TilePart holds the data from a single TLM entry: which tile the tile-part
+// belongs to and the tile-part's length in bytes
+type TilePart struct {
+	Tile   uint16
+	Length uint32
+}
+
+// Segment is a range of bytes within the file
+type Segment struct {
+	Offset int64
+	Length int64
+}
+
+// MCT returns true if the codestream applies a multiple component transform
+// to its first three components
+func (i *Info) MCT() bool {
+	return i.SGCod&0xFF != 0
+}
+
+// LumaComponent returns true if the first component can be decoded on its own
+// as the image's luma: either the colorspace is YCC, or RGB data is stored
+// with a multiple component transform, which openjpeg can skip.  Images with
+// ICC profiles or other colorspaces have to be fully decoded to get gray data.
+func (i *Info) LumaComponent() bool {
+	if i.Comps < 3 {
+		return false
+	}
+	return (i.ColorSpace == CSYCC && !i.MCT()) || (i.ColorSpace == CSRGB && i.MCT())
 }
 
 // TileWidth computes width of tiles
@@ -58,6 +114,53 @@ func (i *Info) TileHeight() uint32 {
 	return i.YTSiz - i.YTOSiz
 }
 
+// TilesAcross returns the number of tiles in each row of the image
+func (i *Info) TilesAcross() uint32 {
+	var tw = i.XTSiz
+	if tw == 0 {
+		return 0
+	}
+	return (i.XSiz - i.XTOSiz + tw - 1) / tw
+}
+
+// HasTileIndex returns true if the codestream has pointer markers which allow
+// a decoder to seek directly to a given tile's data
+func (i *Info) HasTileIndex() bool {
+	return i.TLM && len(i.TileParts) > 0
+}
+
+// TileSegments returns the location of each of the given tile's tile-parts
+// in the file, as computed from the TLM data, or nil if they can't be
+// determined.  A zero length means a tile-part runs to the end of the
+// codestream, which only the last one may do.
+func (i *Info) TileSegments(tile int) []Segment {
+	if !i.HasTileIndex() || i.TilePartOffset == 0 {
+		return nil
+	}
+
+	var segs []Segment
+	var off = i.TilePartOffset
+	for n, tp := range i.TileParts {
+		if tp.Length == 0 && n != len(i.TileParts)-1 {
+			return nil
+		}
+		if int(tp.Tile) == tile {
+			if tp.Length == 0 {
+				return nil
+			}
+			segs = append(segs, Segment{off, int64(tp.Length)})
+		}
+		off += int64(tp.Length)
+	}
+	return segs
+}
+
+// DPI returns the image's horizontal resolution in dots per inch, or zero if
+// the file doesn't record a resolution
+func (i *Info) DPI() float64 {
+	return i.HRes * 0.0254
+}
+
 // String reports the ColorSpace in a human-readable way
 func (cs ColorSpace) String() string {
 	switch cs {
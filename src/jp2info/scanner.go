@@ -6,6 +6,7 @@
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"os"
 )
 
@@ -21,41 +22,119 @@
 var (
 	IHDR   = []byte{0x69, 0x68, 0x64, 0x72} // "ihdr"
 	COLR   = []byte{0x63, 0x6f, 0x6c, 0x72} // "colr"
+	RESC   = []byte{0x72, 0x65, 0x73, 0x63} // "resc"
+	RESD   = []byte{0x72, 0x65, 0x73, 0x64} // "resd"
 	SOCSIZ = []byte{0xFF, 0x4F, 0xFF, 0x51}
 	COD    = []byte{0xFF, 0x52}
 )
 
+// Marker codes we look for after the COD segment
+const (
+	markerTLM = 0xFF55
+	markerPLM = 0xFF57
+	markerPLT = 0xFF58
+	markerSOT = 0xFF90
+	markerSOD = 0xFF93
+)
+
+// resWindow is how far past the colr box we look for a resolution box.  Files
+// with very large ICC profiles may put it out of reach, in which case the
+// resolution is simply left unset.
+const resWindow = 1 << 16
+
 // Scanner reads a Jpeg2000 header and parsing its data into an Info structure
 type Scanner struct {
 	r *bufio.Reader
+	c *countingReader
 	e error
 	i *Info
 }
 
+// countingReader tracks how many bytes have been read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	var n, err = c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// offset returns the position in the file of the next byte the scanner
+// will read
+func (s *Scanner) offset() int64 {
+	return s.c.n - int64(s.r.Buffered())
+}
+
 // Scan reads the file and populates an Info pointer
 func (s *Scanner) Scan(filename string) (*Info, error) {
 	var f, err = os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	s.readInfo(f)
+	return s.ScanReader(f)
+}
+
+// ScanReader is like Scan, but reads the image data from r
+func (s *Scanner) ScanReader(r io.Reader) (*Info, error) {
+	s.readInfo(r)
 	return s.i, s.e
 }
 
 func (s *Scanner) readInfo(ior io.Reader) {
 	s.i = &Info{}
-	s.r = bufio.NewReader(ior)
+	s.c = &countingReader{r: ior}
+	s.r = bufio.NewReaderSize(s.c, resWindow)
 
 	// Make sure the header bytes are legit - this doesn't cover all types of
-	// JP2, but it works for what RAIS needs
-	var header = make([]byte, 12)
-	_, s.e = s.r.Read(header)
-	if !bytes.Equal(header, JP2HEADER) {
+	// JP2, but it works for what RAIS needs.  Raw codestreams (.j2c/.j2k) have
+	// no boxes, so all their info comes from the SIZ segment.
+	var header, _ = s.r.Peek(identifyLen)
+	s.i.Container = identify(header)
+	switch s.i.Container {
+	case ContainerJP2, ContainerJPX:
+		s.r.Discard(len(JP2HEADER))
+		s.readBoxes()
+		s.scanUntil(SOCSIZ)
+		s.i.CodestreamOffset = s.offset() - int64(len(SOCSIZ))
+		s.readSIZ()
+	case ContainerCodestream:
+		s.r.Discard(len(SOCSIZ))
+		s.readSIZ()
+		s.readCodestreamInfo()
+	case ContainerJPM:
+		s.e = &UnsupportedError{ContainerJPM, "compound documents can't be decoded"}
+		return
+	default:
 		s.e = fmt.Errorf("unknown file format")
 		return
 	}
 
+	// JPX files without a JP2 header or a contiguous codestream (e.g., those
+	// using fragment tables) run out of data before we find what we need
+	if s.i.Container == ContainerJPX && s.e == io.EOF {
+		s.e = &UnsupportedError{ContainerJPX, "no JP2-compatible header and codestream"}
+		return
+	}
+
+	// Find COD, primarily to get resolution levels
+	s.scanUntil(COD)
+	s.readBE(&s.i.LCod, &s.i.SCod, &s.i.SGCod, &s.i.Levels)
+
+	// Read the rest of the main header and first tile-part header for pointer
+	// markers.  These are optional, so failures here aren't considered errors.
+	if s.e == nil && s.i.LCod >= 8 {
+		s.r.Discard(int(s.i.LCod) - 8)
+		s.readMarkers()
+	}
+}
+
+// readBoxes reads the image and color data from the JP2 header boxes
+func (s *Scanner) readBoxes() {
 	// Find IHDR for basic information
 	s.scanUntil(IHDR)
 	s.readBE(&s.i.Height, &s.i.Width, &s.i.Comps, &s.i.BPC)
@@ -64,14 +143,111 @@ func (s *Scanner) readInfo(ior io.Reader) {
 	s.scanUntil(COLR)
 	s.readColor()
 
-	// Find various SIZ data
-	s.scanUntil(SOCSIZ)
+	// Look ahead for the optional resolution box, which follows colr in the
+	// JP2 header
+	s.readResolution()
+}
+
+// readSIZ reads the SIZ segment, which must immediately follow the SOC and
+// SIZ markers
+func (s *Scanner) readSIZ() {
 	s.readBE(&s.i.LSiz, &s.i.RSiz, &s.i.XSiz, &s.i.YSiz, &s.i.XOSiz,
 		&s.i.YOSiz, &s.i.XTSiz, &s.i.YTSiz, &s.i.XTOSiz, &s.i.YTOSiz, &s.i.CSiz)
+}
 
-	// Find COD, primarily to get resolution levels
-	s.scanUntil(COD)
-	s.readBE(&s.i.LCod, &s.i.SCod, &s.i.SGCod, &s.i.Levels)
+// readCodestreamInfo fills in the data a JP2's ihdr and colr boxes would have
+// given us.  The first component's Ssiz holds its bit depth, less one, just as
+// the ihdr box's BPC does.  A codestream has no colorspace, so we assume RGB
+// for three or more components, as openjpeg does, and grayscale otherwise.
+func (s *Scanner) readCodestreamInfo() {
+	var ssiz uint8
+	s.readBE(&ssiz)
+	s.i.Width = s.i.XSiz - s.i.XOSiz
+	s.i.Height = s.i.YSiz - s.i.YOSiz
+	s.i.Comps = s.i.CSiz
+	s.i.BPC = ssiz
+	s.i.ColorSpace = CSGrayScale
+	if s.i.Comps >= 3 {
+		s.i.ColorSpace = CSRGB
+	}
+}
+
+// readMarkers walks marker segments until the first SOD, noting any pointer
+// markers, and where they and the first tile-part are, along the way.
+// Parsing stops silently if anything unexpected is found, since the data is
+// purely an optimization.
+func (s *Scanner) readMarkers() {
+	for {
+		var start = s.offset()
+		var marker, length uint16
+		var err = binary.Read(s.r, binary.BigEndian, &marker)
+		if err != nil || marker>>8 != 0xFF || marker == markerSOD {
+			return
+		}
+		if marker == markerSOT && s.i.TilePartOffset == 0 {
+			s.i.TilePartOffset = start
+		}
+		err = binary.Read(s.r, binary.BigEndian, &length)
+		if err != nil || length < 2 {
+			return
+		}
+
+		var data = make([]byte, length-2)
+		_, err = io.ReadFull(s.r, data)
+		if err != nil {
+			return
+		}
+
+		switch marker {
+		case markerTLM:
+			s.i.TLM = true
+			s.i.TLMSegments = append(s.i.TLMSegments, Segment{start, int64(length) + 2})
+			s.readTLM(data)
+		case markerPLM:
+			s.i.PLM = true
+		case markerPLT:
+			s.i.PLT = true
+		}
+	}
+}
+
+// readTLM parses a TLM segment's tile-part lengths.  The segment starts with
+// Ztlm (index) and Stlm (sizes of the tile and length fields), followed by the
+// tile-part entries.
+func (s *Scanner) readTLM(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+
+	var st = int(data[1]>>4) & 0x3
+	var sp = 2
+	if data[1]&0x40 != 0 {
+		sp = 4
+	}
+	if st == 3 {
+		return
+	}
+
+	var entries = data[2:]
+	var entrySize = st + sp
+	for off := 0; off+entrySize <= len(entries); off += entrySize {
+		var tp TilePart
+		switch st {
+		case 0:
+			tp.Tile = uint16(len(s.i.TileParts))
+		case 1:
+			tp.Tile = uint16(entries[off])
+		case 2:
+			tp.Tile = binary.BigEndian.Uint16(entries[off:])
+		}
+
+		if sp == 2 {
+			tp.Length = uint32(binary.BigEndian.Uint16(entries[off+st:]))
+		} else {
+			tp.Length = binary.BigEndian.Uint32(entries[off+st:])
+		}
+		s.i.TileParts = append(s.i.TileParts, tp)
+	}
 }
 
 func (s *Scanner) readColor() {
@@ -105,6 +281,39 @@ func (s *Scanner) readColorProfile() {
 	s.i.ColorSpace = CSUnknown
 }
 
+// readResolution peeks ahead for a capture resolution box, or a display
+// resolution box if there's no capture resolution, without consuming any data
+func (s *Scanner) readResolution() {
+	if s.e != nil {
+		return
+	}
+
+	var window, _ = s.r.Peek(resWindow)
+	if idx := bytes.Index(window, SOCSIZ); idx >= 0 {
+		window = window[:idx]
+	}
+	for _, token := range [][]byte{RESC, RESD} {
+		var idx = bytes.Index(window, token)
+		if idx < 4 || idx+14 > len(window) || binary.BigEndian.Uint32(window[idx-4:]) != 18 {
+			continue
+		}
+		var data = window[idx+4 : idx+14]
+		s.i.VRes = resolution(data[0:2], data[2:4], int8(data[8]))
+		s.i.HRes = resolution(data[4:6], data[6:8], int8(data[9]))
+		return
+	}
+}
+
+// resolution computes a res box's grid points per meter from its big-endian
+// numerator and denominator and its exponent
+func resolution(num, den []byte, exp int8) float64 {
+	var n, d = binary.BigEndian.Uint16(num), binary.BigEndian.Uint16(den)
+	if n == 0 || d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d) * math.Pow10(int(exp))
+}
+
 // scanUntil reads until the given token has been found and fully read
 // in, leaving the io pointer exactly one byte past the token
 func (s *Scanner) scanUntil(token []byte) {
@@ -0,0 +1,163 @@
+package jp2info
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// fakeJP2 returns the minimal set of boxes and markers the scanner needs,
+// followed by the given main header marker segments and a tile-part header
+func fakeJP2(mainHeader, tileHeader []byte) []byte {
+	var b = bytes.NewBuffer(nil)
+	b.Write(JP2HEADER)
+	b.Write(IHDR)
+	b.Write([]byte{0, 0, 0, 100, 0, 0, 0, 200, 0, 1, 7})
+	b.Write(COLR)
+	b.Write([]byte{1, 0, 0, 0, 0, 0, 17})
+	b.Write(SOCSIZ)
+	b.Write([]byte{0, 41, 0, 0})
+	b.Write([]byte{0, 0, 0, 200, 0, 0, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0})
+	b.Write([]byte{0, 0, 0, 64, 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, 0})
+	b.Write([]byte{0, 1, 7, 1, 1})
+	b.Write(COD)
+	b.Write([]byte{0, 12, 0, 0, 0, 1, 0, 2, 4, 4, 0, 0})
+	b.Write(mainHeader)
+	b.Write([]byte{0xFF, 0x90, 0, 10, 0, 0, 0, 0, 0, 100, 0, 1})
+	b.Write(tileHeader)
+	b.Write([]byte{0xFF, 0x93})
+	return b.Bytes()
+}
+
+func scan(data []byte) *Info {
+	var s = new(Scanner)
+	s.readInfo(bytes.NewReader(data))
+	return s.i
+}
+
+func TestScanNoPointerMarkers(t *testing.T) {
+	var i = scan(fakeJP2(nil, nil))
+	assert.Equal(uint32(200), i.Width, "width", t)
+	assert.Equal(uint8(2), i.Levels, "levels", t)
+	assert.False(i.TLM, "no TLM", t)
+	assert.False(i.PLT, "no PLT", t)
+	assert.False(i.HasTileIndex(), "no tile index", t)
+	assert.Equal(uint32(4), i.TilesAcross(), "tiles across", t)
+}
+
+func TestScanTLM(t *testing.T) {
+	// TLM with 8-bit tile indices and 32-bit lengths: ST=1, SP=1
+	var tlm = []byte{0xFF, 0x55, 0, 14, 0, 0x50, 0, 0, 0, 0, 100, 3, 0, 0, 1, 0}
+	var i = scan(fakeJP2(tlm, []byte{0xFF, 0x58, 0, 3, 0}))
+	assert.True(i.TLM, "TLM", t)
+	assert.True(i.PLT, "PLT", t)
+	assert.True(i.HasTileIndex(), "tile index", t)
+	assert.Equal(2, len(i.TileParts), "tile-part count", t)
+	assert.Equal(TilePart{Tile: 0, Length: 100}, i.TileParts[0], "first tile-part", t)
+	assert.Equal(TilePart{Tile: 3, Length: 256}, i.TileParts[1], "second tile-part", t)
+
+	// The fake JP2 has a 12-byte signature, ihdr and colr (with their 4-byte
+	// names) of 15 and 11 bytes, then the codestream
+	assert.Equal(int64(38), i.CodestreamOffset, "codestream offset", t)
+	assert.Equal(1, len(i.TLMSegments), "TLM segment count", t)
+	var tlmOffset = i.CodestreamOffset + 4 + 41 + 2 + 12
+	assert.Equal(Segment{tlmOffset, 16}, i.TLMSegments[0], "TLM segment", t)
+	assert.Equal(tlmOffset+16, i.TilePartOffset, "first tile-part offset", t)
+
+	assert.Equal(fmt.Sprint([]Segment{{i.TilePartOffset, 100}}), fmt.Sprint(i.TileSegments(0)), "first tile's data", t)
+	assert.Equal(fmt.Sprint([]Segment{{i.TilePartOffset + 100, 256}}), fmt.Sprint(i.TileSegments(3)), "fourth tile's data", t)
+	assert.Equal(0, len(i.TileSegments(1)), "tile without data", t)
+}
+
+func TestLumaComponent(t *testing.T) {
+	var i = scan(fakeJP2(nil, nil))
+	assert.False(i.MCT(), "no MCT", t)
+	assert.False(i.LumaComponent(), "grayscale images have no separate luma", t)
+
+	i.Comps, i.ColorSpace = 3, CSRGB
+	assert.False(i.LumaComponent(), "RGB without MCT", t)
+	i.SGCod |= 1
+	assert.True(i.LumaComponent(), "RGB with MCT", t)
+	i.ColorSpace = CSUnknown
+	assert.False(i.LumaComponent(), "ICC profile", t)
+	i.ColorSpace, i.SGCod = CSYCC, 0
+	assert.True(i.LumaComponent(), "YCC", t)
+}
+
+// withResolution inserts a res superbox holding the given resolution box just
+// ahead of the codestream
+func withResolution(data []byte, token []byte, vn, hn uint16, exp byte) []byte {
+	var box = []byte{0, 0, 0, 26, 'r', 'e', 's', ' ', 0, 0, 0, 18}
+	box = append(box, token...)
+	box = append(box, byte(vn>>8), byte(vn), 0, 1, byte(hn>>8), byte(hn), 0, 1, exp, exp)
+	var idx = bytes.Index(data, SOCSIZ)
+	return append(append(append([]byte{}, data[:idx]...), box...), data[idx:]...)
+}
+
+func TestScanResolution(t *testing.T) {
+	var i = scan(fakeJP2(nil, nil))
+	assert.Equal(0.0, i.DPI(), "no res box", t)
+
+	i = scan(withResolution(fakeJP2(nil, nil), RESC, 11811, 11811, 0))
+	assert.Equal(300.0, math.Round(i.DPI()), "capture resolution", t)
+	assert.Equal(uint8(2), i.Levels, "codestream is still read", t)
+
+	i = scan(withResolution(fakeJP2(nil, nil), RESD, 2835, 2835, 1))
+	assert.Equal(720.0, math.Round(i.DPI()), "display resolution with exponent", t)
+}
+
+func TestScanCodestream(t *testing.T) {
+	var data = fakeJP2(nil, nil)
+	var s = new(Scanner)
+	s.readInfo(bytes.NewReader(data[bytes.Index(data, SOCSIZ):]))
+	assert.NilError(s.e, "codestream scan", t)
+	var i = s.i
+	assert.Equal(ContainerCodestream, i.Container, "container", t)
+	assert.Equal(uint32(200), i.Width, "width", t)
+	assert.Equal(uint32(100), i.Height, "height", t)
+	assert.Equal(uint16(1), i.Comps, "components", t)
+	assert.Equal(uint8(7), i.BPC, "bits per component", t)
+	assert.Equal(CSGrayScale, i.ColorSpace, "color space", t)
+	assert.Equal(uint8(2), i.Levels, "levels", t)
+
+	s = new(Scanner)
+	s.readInfo(bytes.NewReader([]byte("GIF89a and more")))
+	assert.True(s.e != nil, "unknown formats are rejected", t)
+}
+
+// withBrand inserts a file type box with the given brand after the signature
+func withBrand(data []byte, brand string) []byte {
+	var box = append([]byte{0, 0, 0, 20}, FTYP...)
+	box = append(box, brand...)
+	box = append(box, 0, 0, 0, 0)
+	box = append(box, brand...)
+	return append(append(append([]byte{}, JP2HEADER...), box...), data[len(JP2HEADER):]...)
+}
+
+func TestScanContainers(t *testing.T) {
+	var i = scan(withBrand(fakeJP2(nil, nil), "jp2 "))
+	assert.Equal(ContainerJP2, i.Container, "JP2", t)
+	assert.Equal(uint32(200), i.Width, "JP2 width", t)
+
+	i = scan(withBrand(fakeJP2(nil, nil), "jpx "))
+	assert.Equal(ContainerJPX, i.Container, "JPX", t)
+	assert.Equal(uint8(2), i.Levels, "JPX codestream is read", t)
+
+	var s = new(Scanner)
+	s.readInfo(bytes.NewReader(withBrand(JP2HEADER, "jpx ")))
+	var ue, ok = s.e.(*UnsupportedError)
+	assert.True(ok, "JPX without a codestream is unsupported", t)
+	assert.Equal(ContainerJPX, ue.Container, "JPX error container", t)
+
+	s = new(Scanner)
+	s.readInfo(bytes.NewReader(withBrand(fakeJP2(nil, nil), "jpm ")))
+	ue, ok = s.e.(*UnsupportedError)
+	assert.True(ok, "JPM is unsupported", t)
+	assert.Equal(ContainerJPM, ue.Container, "JPM error container", t)
+
+	assert.Equal(ContainerJPM, ReadContainer(bytes.NewReader(withBrand(JP2HEADER, "jpm "))), "read JPM", t)
+	assert.Equal(ContainerUnknown, ReadContainer(bytes.NewReader([]byte("GIF89a"))), "read non-JPEG 2000", t)
+}
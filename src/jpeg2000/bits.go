@@ -0,0 +1,139 @@
+package jpeg2000
+
+// bitReader reads packet header bits, skipping the stuffed bit which follows
+// every 0xFF byte
+type bitReader struct {
+	data []byte
+	pos  int
+	buf  uint32
+	ct   uint
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (b *bitReader) byteIn() {
+	b.buf = (b.buf << 8) & 0xFFFF
+	b.ct = 8
+	if b.buf == 0xFF00 {
+		b.ct = 7
+	}
+	if b.pos < len(b.data) {
+		b.buf |= uint32(b.data[b.pos])
+	}
+	b.pos++
+}
+
+func (b *bitReader) bit() uint32 {
+	if b.ct == 0 {
+		b.byteIn()
+	}
+	b.ct--
+	return (b.buf >> b.ct) & 1
+}
+
+func (b *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := n; i > 0; i-- {
+		v |= b.bit() << (i - 1)
+	}
+	return v
+}
+
+// align skips to the end of the current byte, and past the next one if the
+// current byte is 0xFF, since a packet header can't end on 0xFF
+func (b *bitReader) align() {
+	if b.buf&0xFF == 0xFF {
+		b.byteIn()
+	}
+	b.ct = 0
+}
+
+// bytesRead returns the number of bytes consumed so far
+func (b *bitReader) bytesRead() int {
+	return b.pos
+}
+
+// overrun returns true if reads went past the end of the data
+func (b *bitReader) overrun() bool {
+	return b.pos > len(b.data)
+}
+
+// tagTree decodes the quad-tree coded values used for code-block inclusion
+// and zero bit-planes
+type tagTree struct {
+	parent []int
+	value  []int
+	low    []int
+	stack  []int
+}
+
+// newTagTree returns a tag tree for a w by h grid of leaves
+func newTagTree(w, h int) *tagTree {
+	var t = &tagTree{}
+	var n = 0
+	var widths, heights []int
+	for lw, lh := w, h; ; lw, lh = (lw+1)/2, (lh+1)/2 {
+		widths = append(widths, lw)
+		heights = append(heights, lh)
+		n += lw * lh
+		if lw*lh <= 1 {
+			break
+		}
+	}
+
+	t.parent = make([]int, n)
+	t.value = make([]int, n)
+	t.low = make([]int, n)
+	var start = 0
+	for l := range widths {
+		var next = start + widths[l]*heights[l]
+		for j := 0; j < heights[l]; j++ {
+			for i := 0; i < widths[l]; i++ {
+				var node = start + j*widths[l] + i
+				t.value[node] = 999
+				t.parent[node] = -1
+				if l+1 < len(widths) {
+					t.parent[node] = next + (j/2)*widths[l+1] + i/2
+				}
+			}
+		}
+		start = next
+	}
+	return t
+}
+
+// decode reads bits until it's known whether leaf's value is below
+// threshold, returning true if it is
+func (t *tagTree) decode(b *bitReader, leaf, threshold int) bool {
+	t.stack = t.stack[:0]
+	var node = leaf
+	for t.parent[node] >= 0 {
+		t.stack = append(t.stack, node)
+		node = t.parent[node]
+	}
+
+	var low = 0
+	for {
+		if low > t.low[node] {
+			t.low[node] = low
+		} else {
+			low = t.low[node]
+		}
+		for low < threshold && low < t.value[node] {
+			if b.bit() == 1 {
+				t.value[node] = low
+			} else {
+				low++
+			}
+		}
+		t.low[node] = low
+		if len(t.stack) == 0 {
+			break
+		}
+		node = t.stack[len(t.stack)-1]
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+	return t.value[node] < threshold
+}
@@ -0,0 +1,653 @@
+package jpeg2000
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Codestream markers.  Only those the decoder acts on are listed; all other
+// marker segments are skipped.
+const (
+	markerSOC = 0xFF4F
+	markerSIZ = 0xFF51
+	markerCOD = 0xFF52
+	markerCOC = 0xFF53
+	markerQCD = 0xFF5C
+	markerQCC = 0xFF5D
+	markerRGN = 0xFF5E
+	markerPOC = 0xFF5F
+	markerPPM = 0xFF60
+	markerPPT = 0xFF61
+	markerSOT = 0xFF90
+	markerSOP = 0xFF91
+	markerEPH = 0xFF92
+	markerSOD = 0xFF93
+	markerEOC = 0xFFD9
+)
+
+// Code-block style flags from COD and COC segments
+const (
+	cblkBypass  = 0x01
+	cblkReset   = 0x02
+	cblkTermAll = 0x04
+	cblkVSC     = 0x08
+	cblkPTerm   = 0x10
+	cblkSegSym  = 0x20
+	cblkHT      = 0x40
+)
+
+// Progression orders
+const (
+	orderLRCP = iota
+	orderRLCP
+	orderRPCL
+	orderPCRL
+	orderCPRL
+)
+
+// Quantization styles
+const (
+	quantNone = iota
+	quantDerived
+	quantExpounded
+)
+
+// maxLevels is the most decomposition levels the standard allows
+const maxLevels = 32
+
+var errTruncated = errors.New("codestream is truncated")
+
+// component is a component's SIZ data
+type component struct {
+	prec   uint
+	signed bool
+	dx, dy int
+}
+
+// coding holds a tile-component's COD/COC parameters
+type coding struct {
+	levels     int
+	cbw, cbh   uint // code-block size exponents
+	style      byte
+	reversible bool
+	ppx, ppy   [maxLevels + 1]uint
+}
+
+// quant holds a tile-component's QCD/QCC parameters.  Steps are indexed as
+// bands appear in the codestream: LL, then HL, LH, and HH for each
+// resolution.
+type quant struct {
+	style  int
+	guard  uint
+	expn   []uint
+	mantis []uint
+}
+
+// step returns the exponent and mantissa for band index b
+func (q *quant) step(b int) (uint, uint) {
+	if q.style == quantDerived {
+		var e = int(q.expn[0]) - (b-1)/3
+		if b == 0 {
+			e = int(q.expn[0])
+		}
+		if e < 0 {
+			e = 0
+		}
+		return uint(e), q.mantis[0]
+	}
+	if b >= len(q.expn) {
+		return 0, 0
+	}
+	return q.expn[b], q.mantis[b]
+}
+
+// progression is one POC entry, or the COD progression when there's no POC
+type progression struct {
+	order        int
+	resS, resE   int
+	compS, compE int
+	layE         int
+}
+
+// params are the coding parameters in effect for a tile: the main header's,
+// with any tile-part header overrides applied
+type params struct {
+	order  int
+	layers int
+	mct    bool
+	sop    bool
+	eph    bool
+	coding []coding
+	quant  []quant
+	roi    []uint
+	pocs   []progression
+
+	// fromCOC and fromQCC note which components have component-specific
+	// parameters, which a COD or QCD in a tile header doesn't override
+	fromCOC []bool
+	fromQCC []bool
+}
+
+// clone returns a copy of p whose slices can be modified independently
+func (p *params) clone() *params {
+	var c = *p
+	c.coding = append([]coding(nil), p.coding...)
+	c.quant = append([]quant(nil), p.quant...)
+	c.roi = append([]uint(nil), p.roi...)
+	c.pocs = append([]progression(nil), p.pocs...)
+	c.fromCOC = make([]bool, len(p.fromCOC))
+	c.fromQCC = make([]bool, len(p.fromQCC))
+	return &c
+}
+
+// tilePart is one tile-part's location in the codestream
+type tilePart struct {
+	tile   int
+	header int64 // first marker after SOT
+	data   int64 // first byte after SOD
+	end    int64
+	ppm    []byte
+}
+
+// codestream holds a codestream's main header and the locations of its
+// tile-parts
+type codestream struct {
+	r     io.ReaderAt
+	start int64
+	end   int64
+
+	// SIZ data, in reference grid coordinates
+	x0, y0, x1, y1   int
+	tx0, ty0, tw, th int
+	tilesX, tilesY   int
+	comps            []component
+
+	main  *params
+	parts []tilePart
+}
+
+// segment is a marker segment's marker and contents, without the length
+type segment struct {
+	marker uint16
+	data   []byte
+}
+
+// segmentReader reads marker segments from a codestream
+type segmentReader struct {
+	r   io.ReaderAt
+	pos int64
+	end int64
+}
+
+// next reads the marker at the current position, and its segment if it has
+// one.  SOD is returned without data, since it has no length field.
+func (sr *segmentReader) next() (segment, error) {
+	var buf [4]byte
+	if sr.pos+2 > sr.end {
+		return segment{}, errTruncated
+	}
+	if _, err := sr.r.ReadAt(buf[:2], sr.pos); err != nil {
+		return segment{}, errTruncated
+	}
+	var m = binary.BigEndian.Uint16(buf[:2])
+	if m>>8 != 0xFF {
+		return segment{}, fmt.Errorf("expected a marker at offset %d", sr.pos)
+	}
+	sr.pos += 2
+	if m == markerSOD || m == markerSOC || m == markerEOC {
+		return segment{marker: m}, nil
+	}
+
+	if _, err := sr.r.ReadAt(buf[2:], sr.pos); err != nil {
+		return segment{}, errTruncated
+	}
+	var l = int64(binary.BigEndian.Uint16(buf[2:]))
+	if l < 2 || sr.pos+l > sr.end {
+		return segment{}, errTruncated
+	}
+	var data = make([]byte, l-2)
+	if _, err := sr.r.ReadAt(data, sr.pos+2); err != nil {
+		return segment{}, errTruncated
+	}
+	sr.pos += l
+	return segment{marker: m, data: data}, nil
+}
+
+// readCodestream parses the main header of the codestream in r between start
+// and end, then finds its tile-parts
+func readCodestream(r io.ReaderAt, start, end int64) (*codestream, error) {
+	var cs = &codestream{r: r, start: start, end: end}
+	var sr = &segmentReader{r: r, pos: start, end: end}
+
+	var s, err = sr.next()
+	if err != nil || s.marker != markerSOC {
+		return nil, errors.New("missing SOC marker")
+	}
+	s, err = sr.next()
+	if err != nil || s.marker != markerSIZ {
+		return nil, errors.New("missing SIZ marker")
+	}
+	err = cs.readSIZ(s.data)
+	if err != nil {
+		return nil, err
+	}
+
+	var p = &params{
+		coding:  make([]coding, len(cs.comps)),
+		quant:   make([]quant, len(cs.comps)),
+		roi:     make([]uint, len(cs.comps)),
+		fromCOC: make([]bool, len(cs.comps)),
+		fromQCC: make([]bool, len(cs.comps)),
+	}
+	var ppm [][]byte
+	var haveCOD, haveQCD bool
+	for {
+		s, err = sr.next()
+		if err != nil {
+			return nil, err
+		}
+		if s.marker == markerSOT {
+			break
+		}
+		switch s.marker {
+		case markerCOD:
+			haveCOD = true
+		case markerQCD:
+			haveQCD = true
+		case markerPPM:
+			ppm = append(ppm, s.data)
+			continue
+		}
+		err = cs.applySegment(p, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !haveCOD || !haveQCD {
+		return nil, errors.New("main header is missing COD or QCD")
+	}
+	cs.main = p
+
+	// sr has just read the first SOT marker segment
+	var firstSOT = sr.pos - int64(len(s.data)) - 4
+	err = cs.findTileParts(firstSOT)
+	if err != nil {
+		return nil, err
+	}
+	if len(ppm) > 0 {
+		err = cs.assignPPM(ppm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+// readSIZ parses the image and tile size segment
+func (cs *codestream) readSIZ(d []byte) error {
+	if len(d) < 36 {
+		return errors.New("invalid SIZ segment")
+	}
+	var u32 = func(o int) int { return int(binary.BigEndian.Uint32(d[o:])) }
+	cs.x1, cs.y1 = u32(2), u32(6)
+	cs.x0, cs.y0 = u32(10), u32(14)
+	cs.tw, cs.th = u32(18), u32(22)
+	cs.tx0, cs.ty0 = u32(26), u32(30)
+	var n = int(binary.BigEndian.Uint16(d[34:]))
+	if n == 0 || len(d) < 36+3*n {
+		return errors.New("invalid SIZ segment")
+	}
+	if cs.x0 >= cs.x1 || cs.y0 >= cs.y1 || cs.tw == 0 || cs.th == 0 || cs.tx0 > cs.x0 || cs.ty0 > cs.y0 ||
+		cs.tx0+cs.tw <= cs.x0 || cs.ty0+cs.th <= cs.y0 {
+		return errors.New("invalid image or tile geometry")
+	}
+	cs.tilesX = ceilDiv(cs.x1-cs.tx0, cs.tw)
+	cs.tilesY = ceilDiv(cs.y1-cs.ty0, cs.th)
+	if cs.tilesX*cs.tilesY > 65535 {
+		return errors.New("too many tiles")
+	}
+
+	cs.comps = make([]component, n)
+	for i := range cs.comps {
+		var ssiz, xr, yr = d[36+3*i], d[37+3*i], d[38+3*i]
+		if xr == 0 || yr == 0 {
+			return errors.New("invalid component subsampling")
+		}
+		cs.comps[i] = component{prec: uint(ssiz&0x7F) + 1, signed: ssiz&0x80 != 0, dx: int(xr), dy: int(yr)}
+		if cs.comps[i].prec > 16 {
+			return unsupported("components deeper than 16 bits")
+		}
+	}
+	return nil
+}
+
+// compIndex reads a component index, which is one byte unless there are
+// more than 256 components
+func (cs *codestream) compIndex(d []byte) (int, []byte, error) {
+	var c int
+	if len(cs.comps) > 256 {
+		if len(d) < 2 {
+			return 0, nil, errTruncated
+		}
+		c, d = int(binary.BigEndian.Uint16(d)), d[2:]
+	} else {
+		if len(d) < 1 {
+			return 0, nil, errTruncated
+		}
+		c, d = int(d[0]), d[1:]
+	}
+	if c >= len(cs.comps) {
+		return 0, nil, errors.New("invalid component index")
+	}
+	return c, d, nil
+}
+
+// applySegment updates p with a COD, COC, QCD, QCC, RGN, or POC segment.
+// Other segments are ignored.
+func (cs *codestream) applySegment(p *params, s segment) error {
+	var d = s.data
+	switch s.marker {
+	case markerCOD:
+		if len(d) < 10 {
+			return errors.New("invalid COD segment")
+		}
+		var scod = d[0]
+		p.order = int(d[1])
+		p.layers = int(binary.BigEndian.Uint16(d[2:]))
+		p.mct = d[4] != 0
+		p.sop = scod&0x02 != 0
+		p.eph = scod&0x04 != 0
+		if p.order > orderCPRL || p.layers == 0 {
+			return errors.New("invalid COD segment")
+		}
+		var c, err = readCoding(d[5:], scod&0x01 != 0)
+		if err != nil {
+			return err
+		}
+		for i := range p.coding {
+			if !p.fromCOC[i] {
+				p.coding[i] = c
+			}
+		}
+
+	case markerCOC:
+		var i, rest, err = cs.compIndex(d)
+		if err != nil || len(rest) < 1 {
+			return errors.New("invalid COC segment")
+		}
+		p.coding[i], err = readCoding(rest[1:], rest[0]&0x01 != 0)
+		if err != nil {
+			return err
+		}
+		p.fromCOC[i] = true
+
+	case markerQCD:
+		var q, err = readQuant(d)
+		if err != nil {
+			return err
+		}
+		for i := range p.quant {
+			if !p.fromQCC[i] {
+				p.quant[i] = q
+			}
+		}
+
+	case markerQCC:
+		var i, rest, err = cs.compIndex(d)
+		if err != nil {
+			return errors.New("invalid QCC segment")
+		}
+		p.quant[i], err = readQuant(rest)
+		if err != nil {
+			return err
+		}
+		p.fromQCC[i] = true
+
+	case markerRGN:
+		var i, rest, err = cs.compIndex(d)
+		if err != nil || len(rest) < 2 || rest[0] != 0 {
+			return errors.New("invalid RGN segment")
+		}
+		p.roi[i] = uint(rest[1])
+
+	case markerPOC:
+		var cl = 1
+		if len(cs.comps) > 256 {
+			cl = 2
+		}
+		var n = 5 + 2*cl
+		if len(d)%n != 0 {
+			return errors.New("invalid POC segment")
+		}
+		for ; len(d) > 0; d = d[n:] {
+			var pr = progression{resS: int(d[0])}
+			var o = 1
+			pr.compS, o = int(d[o]), o+cl
+			if cl == 2 {
+				pr.compS = int(binary.BigEndian.Uint16(d[1:]))
+			}
+			pr.layE = int(binary.BigEndian.Uint16(d[o:]))
+			pr.resE = int(d[o+2])
+			if cl == 2 {
+				pr.compE = int(binary.BigEndian.Uint16(d[o+3:]))
+			} else {
+				pr.compE = int(d[o+3])
+			}
+			if pr.compE == 0 {
+				pr.compE = 256
+			}
+			pr.order = int(d[o+3+cl])
+			if pr.order > orderCPRL {
+				return errors.New("invalid POC segment")
+			}
+			p.pocs = append(p.pocs, pr)
+		}
+	}
+	return nil
+}
+
+// readCoding parses the SPcod or SPcoc parameters
+func readCoding(d []byte, customPrecincts bool) (coding, error) {
+	var c coding
+	if len(d) < 5 {
+		return c, errors.New("invalid coding style segment")
+	}
+	c.levels = int(d[0])
+	c.cbw, c.cbh = uint(d[1])+2, uint(d[2])+2
+	c.style = d[3]
+	c.reversible = d[4] == 1
+	if c.levels > maxLevels || c.cbw > 10 || c.cbh > 10 || c.cbw+c.cbh > 12 || d[4] > 1 {
+		return c, errors.New("invalid coding style segment")
+	}
+	if c.style&cblkHT != 0 {
+		return c, unsupported("HTJ2K (Part 15) code-blocks")
+	}
+	for r := 0; r <= c.levels; r++ {
+		c.ppx[r], c.ppy[r] = 15, 15
+		if customPrecincts {
+			if len(d) < 6+r {
+				return c, errors.New("invalid coding style segment")
+			}
+			c.ppx[r], c.ppy[r] = uint(d[5+r]&0x0F), uint(d[5+r]>>4)
+			if r > 0 && (c.ppx[r] == 0 || c.ppy[r] == 0) {
+				return c, errors.New("invalid precinct size")
+			}
+		}
+	}
+	return c, nil
+}
+
+// readQuant parses the Sqcd and SPqcd (or Sqcc and SPqcc) parameters
+func readQuant(d []byte) (quant, error) {
+	var q quant
+	if len(d) < 1 {
+		return q, errors.New("invalid quantization segment")
+	}
+	q.style = int(d[0] & 0x1F)
+	q.guard = uint(d[0] >> 5)
+	d = d[1:]
+	switch q.style {
+	case quantNone:
+		for _, b := range d {
+			q.expn = append(q.expn, uint(b>>3))
+			q.mantis = append(q.mantis, 0)
+		}
+	case quantDerived, quantExpounded:
+		for ; len(d) >= 2; d = d[2:] {
+			var v = binary.BigEndian.Uint16(d)
+			q.expn = append(q.expn, uint(v>>11))
+			q.mantis = append(q.mantis, uint(v&0x7FF))
+		}
+	default:
+		return q, errors.New("invalid quantization style")
+	}
+	if len(q.expn) == 0 {
+		return q, errors.New("invalid quantization segment")
+	}
+	return q, nil
+}
+
+// findTileParts locates every tile-part by walking the SOT markers.  A
+// tile-part length of zero means the tile-part runs to the end of the
+// codestream.
+func (cs *codestream) findTileParts(pos int64) error {
+	for pos+12 <= cs.end {
+		var buf [12]byte
+		if _, err := cs.r.ReadAt(buf[:], pos); err != nil {
+			return errTruncated
+		}
+		var m = binary.BigEndian.Uint16(buf[:])
+		if m == markerEOC {
+			break
+		}
+		if m != markerSOT || binary.BigEndian.Uint16(buf[2:]) != 10 {
+			// Trailing garbage after the last tile-part is tolerated
+			if len(cs.parts) > 0 {
+				break
+			}
+			return errors.New("missing SOT marker")
+		}
+		var tile = int(binary.BigEndian.Uint16(buf[4:]))
+		var psot = int64(binary.BigEndian.Uint32(buf[6:]))
+		if tile >= cs.tilesX*cs.tilesY {
+			return errors.New("invalid tile index")
+		}
+
+		var end = pos + psot
+		if psot == 0 || end > cs.end {
+			end = cs.end
+		}
+		if psot != 0 && psot < 14 {
+			return errors.New("invalid tile-part length")
+		}
+		cs.parts = append(cs.parts, tilePart{tile: tile, header: pos + 12, end: end})
+		pos = end
+	}
+	if len(cs.parts) == 0 {
+		return errors.New("codestream has no tiles")
+	}
+	return nil
+}
+
+// assignPPM splits the packed packet headers from the main header's PPM
+// segments among the tile-parts, in codestream order
+func (cs *codestream) assignPPM(ppm [][]byte) error {
+	sort.SliceStable(ppm, func(a, b int) bool { return ppm[a][0] < ppm[b][0] })
+	var all []byte
+	for _, d := range ppm {
+		if len(d) < 1 {
+			return errors.New("invalid PPM segment")
+		}
+		all = append(all, d[1:]...)
+	}
+	for i := range cs.parts {
+		if len(all) < 4 {
+			return errors.New("PPM data is shorter than the tile-parts need")
+		}
+		var n = int(binary.BigEndian.Uint32(all))
+		all = all[4:]
+		if n > len(all) {
+			return errors.New("PPM data is shorter than the tile-parts need")
+		}
+		cs.parts[i].ppm, all = all[:n], all[n:]
+	}
+	return nil
+}
+
+// tileParams reads tile's tile-part headers, returning the tile's coding
+// parameters, the packed packet headers from any PPT segments, and the
+// tile's tile-parts
+func (cs *codestream) tileParams(tile int) (*params, []byte, []tilePart, error) {
+	var p = cs.main.clone()
+	var ppt [][]byte
+	var parts []tilePart
+	var tilePOC bool
+	for _, tp := range cs.parts {
+		if tp.tile != tile {
+			continue
+		}
+		var first = len(parts) == 0
+		var sr = &segmentReader{r: cs.r, pos: tp.header, end: tp.end}
+		for {
+			var s, err = sr.next()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if s.marker == markerSOD {
+				break
+			}
+			if s.marker == markerPPT {
+				ppt = append(ppt, s.data)
+				continue
+			}
+			if s.marker == markerPOC && !tilePOC {
+				// A tile's POCs replace the main header's
+				p.pocs = nil
+				tilePOC = true
+			}
+			if !first && s.marker != markerPOC {
+				continue
+			}
+			err = cs.applySegment(p, s)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		tp.data = sr.pos
+		parts = append(parts, tp)
+	}
+
+	sort.SliceStable(ppt, func(a, b int) bool { return ppt[a][0] < ppt[b][0] })
+	var headers []byte
+	for _, d := range ppt {
+		if len(d) > 0 {
+			headers = append(headers, d[1:]...)
+		}
+	}
+	for _, tp := range parts {
+		headers = append(headers, tp.ppm...)
+	}
+	return p, headers, parts, nil
+}
+
+// readTileData returns the concatenated data of the given tile-parts
+func (cs *codestream) readTileData(parts []tilePart) ([]byte, error) {
+	var n int64
+	for _, tp := range parts {
+		n += tp.end - tp.data
+	}
+	var data = make([]byte, n)
+	var off int64
+	for _, tp := range parts {
+		var l = tp.end - tp.data
+		var read, err = cs.r.ReadAt(data[off:off+l], tp.data)
+		if err != nil && !(err == io.EOF && int64(read) == l) {
+			return nil, err
+		}
+		off += l
+	}
+	return data, nil
+}
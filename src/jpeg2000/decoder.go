@@ -0,0 +1,260 @@
+// Package jpeg2000 is a pure-Go JPEG 2000 (Part 1) decoder.  It's much slower
+// than openjpeg, and exists so RAIS can be built without cgo: the openjpeg
+// package falls back to it when libopenjp2 isn't linked.
+//
+// The decoder reads JP2 files and raw codestreams, and supports everything
+// in Part 1 that RAIS has a use for: any progression order, multiple tiles
+// and tile-parts, precincts, quality layers, both wavelet transforms,
+// multiple component transforms, code-block style options, region of
+// interest shifts, and packed packet headers.  Decoding can be restricted to
+// an area of the image and to a lower resolution, in which case only the
+// code-blocks which contribute to the result are decoded.
+package jpeg2000
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// UnsupportedError is returned for valid images using features this decoder
+// doesn't implement
+type UnsupportedError struct {
+	Feature string
+}
+
+func (e *UnsupportedError) Error() string {
+	return "jpeg2000: unsupported feature: " + e.Feature
+}
+
+func unsupported(feature string) error {
+	return &UnsupportedError{Feature: feature}
+}
+
+// maxSamples limits the size of a decoded component
+const maxSamples = 1 << 30
+
+// Options restrict what part of an image is decoded
+type Options struct {
+	// Area is the part of the image to decode, in full-resolution pixels.  The
+	// zero rectangle decodes the whole image.
+	Area image.Rectangle
+
+	// Reduce is the number of resolution levels to discard: each halves the
+	// decoded image's dimensions
+	Reduce int
+
+	// FirstComponentOnly decodes only the first component, skipping any
+	// multiple component transform, for getting luma from YCbCr images
+	FirstComponentOnly bool
+}
+
+// Component is one decoded image component.  Samples are stored in rows,
+// with unsigned samples ranging from 0 to 2^Prec-1 and signed samples
+// centered on zero.
+type Component struct {
+	Data   []int32
+	Width  int
+	Height int
+	Prec   int
+	Signed bool
+}
+
+// Decoder reads a JPEG 2000 image's header, and decodes its pixels on demand
+type Decoder struct {
+	cs *codestream
+}
+
+// NewDecoder reads the header of the JP2 file or raw codestream in r, which
+// is size bytes long
+func NewDecoder(r io.ReaderAt, size int64) (*Decoder, error) {
+	var start, end, err = findCodestream(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var cs *codestream
+	cs, err = readCodestream(r, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{cs: cs}, nil
+}
+
+// Bounds returns the image's dimensions
+func (d *Decoder) Bounds() image.Rectangle {
+	return image.Rect(0, 0, d.cs.x1-d.cs.x0, d.cs.y1-d.cs.y0)
+}
+
+// Levels returns the number of decomposition levels every tile-component has,
+// which is the most Options.Reduce can be
+func (d *Decoder) Levels() int {
+	var levels = maxLevels
+	for _, c := range d.cs.main.coding {
+		if c.levels < levels {
+			levels = c.levels
+		}
+	}
+	return levels
+}
+
+// Decode decodes the image, or part of it, returning its components
+func (d *Decoder) Decode(opts Options) ([]Component, error) {
+	var area = opts.Area
+	if area == image.ZR {
+		area = d.Bounds()
+	}
+	area = area.Intersect(d.Bounds())
+	if area.Empty() {
+		return nil, errors.New("decode area is outside the image")
+	}
+	if opts.Reduce < 0 || opts.Reduce > d.Levels() {
+		return nil, errors.New("invalid resolution reduction")
+	}
+
+	var cs = d.cs
+	var ncomps = len(cs.comps)
+	if opts.FirstComponentOnly {
+		ncomps = 1
+	}
+
+	// The area moves onto the reference grid, then each component's
+	// reduced-resolution grid
+	area = area.Add(image.Pt(cs.x0, cs.y0))
+	var out = make([]Component, ncomps)
+	var regions = make([]image.Rectangle, ncomps)
+	for c := range out {
+		var comp = cs.comps[c]
+		var r = componentRect(area, comp.dx, comp.dy, uint(opts.Reduce))
+		if r.Dy() > 0 && r.Dx() > maxSamples/r.Dy() {
+			return nil, unsupported("decoding more than a billion pixels at once")
+		}
+		regions[c] = r
+		out[c] = Component{
+			Data:   make([]int32, r.Dx()*r.Dy()),
+			Width:  r.Dx(),
+			Height: r.Dy(),
+			Prec:   int(comp.prec),
+			Signed: comp.signed,
+		}
+	}
+
+	for ty := 0; ty < cs.tilesY; ty++ {
+		for tx := 0; tx < cs.tilesX; tx++ {
+			var tr = cs.tileRect(tx, ty)
+			if !tr.Overlaps(area) {
+				continue
+			}
+			var err = d.decodeTile(ty*cs.tilesX+tx, tr, out, regions, opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// decodeTile decodes one tile and copies the parts of it within each
+// component's region into out
+func (d *Decoder) decodeTile(index int, tr image.Rectangle, out []Component, regions []image.Rectangle, opts Options) error {
+	var t, err = newTile(d.cs, index, tr, len(out), uint(opts.Reduce))
+	if err != nil {
+		return err
+	}
+	for c := range out {
+		t.comps[c].setWindow(regions[c])
+	}
+	err = t.readPackets()
+	if err != nil {
+		return err
+	}
+	var samples [][]int32
+	samples, err = t.decode(opts.FirstComponentOnly)
+	if err != nil {
+		return err
+	}
+
+	for c, data := range samples {
+		var w = t.comps[c].window
+		var dst = out[c]
+		var rgn = regions[c]
+		for y := w.Min.Y; y < w.Max.Y; y++ {
+			var src = data[(y-w.Min.Y)*w.Dx():][:w.Dx()]
+			copy(dst.Data[(y-rgn.Min.Y)*dst.Width+w.Min.X-rgn.Min.X:], src)
+		}
+	}
+	return nil
+}
+
+// tileRect returns the tile's rectangle on the reference grid
+func (cs *codestream) tileRect(tx, ty int) image.Rectangle {
+	var r = image.Rect(cs.tx0+tx*cs.tw, cs.ty0+ty*cs.th, cs.tx0+(tx+1)*cs.tw, cs.ty0+(ty+1)*cs.th)
+	return r.Intersect(image.Rect(cs.x0, cs.y0, cs.x1, cs.y1))
+}
+
+// componentRect maps a reference grid rectangle onto a component's grid with
+// the given subsampling, reduced by the given number of levels
+func componentRect(r image.Rectangle, dx, dy int, reduce uint) image.Rectangle {
+	return image.Rect(
+		ceilDivPow2(ceilDiv(r.Min.X, dx), reduce),
+		ceilDivPow2(ceilDiv(r.Min.Y, dy), reduce),
+		ceilDivPow2(ceilDiv(r.Max.X, dx), reduce),
+		ceilDivPow2(ceilDiv(r.Max.Y, dy), reduce),
+	)
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+func ceilDivPow2(a int, b uint) int {
+	return (a + 1<<b - 1) >> b
+}
+
+func floorDivPow2(a int, b uint) int {
+	return a >> b
+}
+
+// findCodestream returns the start and end of the codestream in r: either
+// all of r, or the contents of a JP2 file's first contiguous codestream box
+func findCodestream(r io.ReaderAt, size int64) (int64, int64, error) {
+	var buf [16]byte
+	if _, err := r.ReadAt(buf[:2], 0); err != nil {
+		return 0, 0, errTruncated
+	}
+	if binary.BigEndian.Uint16(buf[:]) == markerSOC {
+		return 0, size, nil
+	}
+
+	var pos int64
+	for pos+8 <= size {
+		if _, err := r.ReadAt(buf[:8], pos); err != nil {
+			return 0, 0, errTruncated
+		}
+		var l = int64(binary.BigEndian.Uint32(buf[:]))
+		var typ = string(buf[4:8])
+		var header int64 = 8
+		switch l {
+		case 0:
+			l = size - pos
+		case 1:
+			if _, err := r.ReadAt(buf[8:16], pos+8); err != nil {
+				return 0, 0, errTruncated
+			}
+			l = int64(binary.BigEndian.Uint64(buf[8:]))
+			header = 16
+		}
+		if l < header {
+			return 0, 0, errors.New("invalid JP2 box length")
+		}
+		if typ == "jp2c" {
+			var end = pos + l
+			if end > size {
+				end = size
+			}
+			return pos + header, end, nil
+		}
+		pos += l
+	}
+	return 0, 0, errors.New("no codestream found")
+}
@@ -0,0 +1,230 @@
+package jpeg2000
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// world.jp2 is a single-tile 800x400 RGB image: 9/7 wavelet, one
+// decomposition level, three layers, and the irreversible color transform
+const worldFile = "testdata/world.jp2"
+
+// newspaperFile is a 4971x7320 grayscale image in 1024-pixel tiles: 5/3
+// wavelet, five decomposition levels
+const newspaperFile = "../../docker/images/jp2tests/sn00063609-19091231.jp2"
+
+func readTestFile(fname string, t testing.TB) []byte {
+	var data, err = ioutil.ReadFile(fname)
+	if err != nil {
+		t.Skipf("unable to read %s: %s", fname, err)
+	}
+	return data
+}
+
+func decode(data []byte, opts Options, t testing.TB) []Component {
+	var d, err = NewDecoder(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unable to read header: %s", err)
+	}
+	var comps []Component
+	comps, err = d.Decode(opts)
+	if err != nil {
+		t.Fatalf("unable to decode %#v: %s", opts, err)
+	}
+	return comps
+}
+
+// crop returns the samples of c within r
+func crop(c Component, r image.Rectangle) []int32 {
+	var out []int32
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		out = append(out, c.Data[y*c.Width+r.Min.X:y*c.Width+r.Max.X]...)
+	}
+	return out
+}
+
+func equalSamples(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecodeWorld(t *testing.T) {
+	var data = readTestFile(worldFile, t)
+	var comps = decode(data, Options{}, t)
+	assert.Equal(3, len(comps), "component count", t)
+	assert.Equal(800, comps[0].Width, "width", t)
+	assert.Equal(400, comps[0].Height, "height", t)
+	assert.Equal(8, comps[0].Prec, "precision", t)
+
+	var at = func(x, y int) (int32, int32, int32) {
+		var i = y*800 + x
+		return comps[0].Data[i], comps[1].Data[i], comps[2].Data[i]
+	}
+	var r, g, b = at(100, 300)
+	assert.True(b > r && b > g && r < 64, "the south Pacific is dark blue", t)
+	r, g, b = at(400, 395)
+	assert.True(r > 200 && g > 200 && b > 200, "Antarctica is white", t)
+	r, g, b = at(420, 150)
+	assert.True(r > b+40 && g > b+40, "the Sahara is yellow", t)
+}
+
+func TestDecodeArea(t *testing.T) {
+	var data = readTestFile(worldFile, t)
+	var full = decode(data, Options{}, t)
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 800, 400),
+		image.Rect(123, 45, 567, 389),
+		image.Rect(799, 399, 800, 400),
+		image.Rect(10, 10, 13, 11),
+	} {
+		var comps = decode(data, Options{Area: r}, t)
+		assert.Equal(r.Dx(), comps[0].Width, "area width", t)
+		for c := range comps {
+			assert.True(equalSamples(crop(full[c], r), comps[c].Data), "area matches full decode: "+r.String(), t)
+		}
+	}
+
+	var luma = decode(data, Options{Area: image.Rect(0, 0, 100, 100), FirstComponentOnly: true}, t)
+	assert.Equal(1, len(luma), "only the first component is decoded", t)
+}
+
+func TestDecodeTiledArea(t *testing.T) {
+	var data = readTestFile(newspaperFile, t)
+	var half = decode(data, Options{Reduce: 1}, t)
+	assert.Equal(2486, half[0].Width, "reduced width rounds up", t)
+	assert.Equal(3660, half[0].Height, "reduced height", t)
+
+	// This crosses tile boundaries at both levels
+	var r = image.Rect(1900, 900, 2300, 1200)
+	var part = decode(data, Options{Area: r, Reduce: 1}, t)
+	var want = image.Rect(950, 450, 1150, 600)
+	assert.Equal(want.Dx(), part[0].Width, "reduced area width", t)
+	assert.True(equalSamples(crop(half[0], want), part[0].Data), "reduced area matches reduced full decode", t)
+
+	var thumb = decode(data, Options{Reduce: 5}, t)
+	assert.Equal(156, thumb[0].Width, "thumbnail width", t)
+	assert.Equal(229, thumb[0].Height, "thumbnail height", t)
+}
+
+// reorder rewrites a single-tile codestream's packets in another progression
+// order, which must decode to the same image
+func reorder(data []byte, order int, t *testing.T) []byte {
+	var start, end, err = findCodestream(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cs *codestream
+	cs, err = readCodestream(bytes.NewReader(data), start, end)
+	if err != nil || len(cs.parts) != 1 {
+		t.Fatalf("test image must have one tile-part (err: %v)", err)
+	}
+	var tl *tile
+	tl, err = newTile(cs, 0, cs.tileRect(0, 0), len(cs.comps), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Find each packet's bytes: headers are inline, so a packet is its header
+	// followed by its body
+	var tileData, _ = cs.readTileData(tl.parts)
+	var packets = make(map[packet][]byte)
+	var pos = 0
+	for _, pk := range tl.packetOrder() {
+		var n, err = tl.readPacketHeader(pk, tileData[pos:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var next = tl.readPacketBody(pk, tileData, pos+n)
+		packets[pk] = tileData[pos:next]
+		pos = next
+	}
+
+	// Copy the main header, changing COD's progression order
+	var sr = &segmentReader{r: bytes.NewReader(data), pos: start, end: end}
+	var out = append([]byte(nil), data[start:tl.parts[0].header-12]...)
+	for {
+		var s, err = sr.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.marker == markerSOT {
+			break
+		}
+		if s.marker == markerCOD {
+			out[sr.pos-start-int64(len(s.data))+1] = byte(order)
+		}
+	}
+
+	tl.p.order = order
+	var body []byte
+	for _, pk := range tl.packetOrder() {
+		body = append(body, packets[pk]...)
+	}
+	var sot = []byte{0xFF, 0x90, 0, 10, 0, 0, 0, 0, 0, 0, 0, 1, 0xFF, 0x93}
+	binary.BigEndian.PutUint32(sot[6:], uint32(len(sot)+len(body)))
+	out = append(out, sot...)
+	out = append(out, body...)
+	return append(out, 0xFF, 0xD9)
+}
+
+func TestProgressionOrders(t *testing.T) {
+	var data = readTestFile(worldFile, t)
+	var want = decode(data, Options{}, t)
+	for order := orderLRCP; order <= orderCPRL; order++ {
+		var comps = decode(reorder(data, order, t), Options{}, t)
+		for c := range comps {
+			assert.True(equalSamples(want[c].Data, comps[c].Data), "reordered packets decode the same", t)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var data = readTestFile(worldFile, t)
+	var full = decode(data, Options{}, t)
+
+	// Losing the end of the data only loses quality
+	var comps = decode(data[:len(data)*3/4], Options{}, t)
+	var diff int64
+	for i, v := range comps[0].Data {
+		var d = int64(v - full[0].Data[i])
+		diff += d * d
+	}
+	assert.True(diff/int64(len(comps[0].Data)) < 100, "truncated image is close to the original", t)
+
+	for n := 0; n < 400; n += 7 {
+		var d, err = NewDecoder(bytes.NewReader(data[:n]), int64(n))
+		if err == nil {
+			d.Decode(Options{})
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	var data, err = ioutil.ReadFile(worldFile)
+	if err != nil {
+		f.Skip(err)
+	}
+	f.Add(data[:2000], 0)
+	f.Fuzz(func(t *testing.T, data []byte, reduce int) {
+		var d, err = NewDecoder(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		if b := d.Bounds(); b.Dx()*b.Dy() > 1<<20 {
+			return
+		}
+		d.Decode(Options{Area: image.Rect(0, 0, 64, 64), Reduce: reduce & 3})
+	})
+}
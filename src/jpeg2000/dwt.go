@@ -0,0 +1,189 @@
+package jpeg2000
+
+import "image"
+
+// 9/7 lifting parameters (Table F.4)
+const (
+	alpha97 = -1.586134342059924
+	beta97  = -0.052980118572961
+	gamma97 = 0.882911075530934
+	delta97 = 0.443506852043971
+	k97     = 1.230174104914001
+)
+
+// plane is a rectangle of samples or coefficients, stored as integers for the
+// reversible path and floats for the irreversible one
+type plane struct {
+	rect image.Rectangle
+	ints []int32
+	flts []float32
+}
+
+func newPlane(r image.Rectangle, reversible bool) *plane {
+	var p = &plane{rect: r}
+	if reversible {
+		p.ints = make([]int32, r.Dx()*r.Dy())
+	} else {
+		p.flts = make([]float32, r.Dx()*r.Dy())
+	}
+	return p
+}
+
+// offset returns the index of x, y, which must be within the plane
+func (p *plane) offset(x, y int) int {
+	return (y-p.rect.Min.Y)*p.rect.Dx() + x - p.rect.Min.X
+}
+
+// synthesize performs one level of inverse wavelet transform.  The bands are
+// interleaved over the area x, on the higher resolution's grid, which is
+// then transformed; the part in w, which doesn't depend on what's outside
+// x, is returned.
+func synthesize(ll, hl, lh, hh *plane, x, w image.Rectangle, reversible bool) *plane {
+	var p = newPlane(x, reversible)
+	var bands = [4]*plane{ll, hl, lh, hh}
+	for v := x.Min.Y; v < x.Max.Y; v++ {
+		for u := x.Min.X; u < x.Max.X; u++ {
+			var b = bands[u&1|(v&1)<<1]
+			var pt = image.Pt(u>>1, v>>1)
+			if !pt.In(b.rect) {
+				continue
+			}
+			var i, o = p.offset(u, v), b.offset(pt.X, pt.Y)
+			if reversible {
+				p.ints[i] = b.ints[o]
+			} else {
+				p.flts[i] = b.flts[o]
+			}
+		}
+	}
+
+	var width, height = x.Dx(), x.Dy()
+	var px, py = x.Min.X & 1, x.Min.Y & 1
+	if reversible {
+		for y := 0; y < height; y++ {
+			lift53(p.ints[y*width:(y+1)*width], px)
+		}
+		var col = make([]int32, height)
+		for i := 0; i < width; i++ {
+			for y := range col {
+				col[y] = p.ints[y*width+i]
+			}
+			lift53(col, py)
+			for y, v := range col {
+				p.ints[y*width+i] = v
+			}
+		}
+	} else {
+		for y := 0; y < height; y++ {
+			lift97(p.flts[y*width:(y+1)*width], px)
+		}
+		var col = make([]float32, height)
+		for i := 0; i < width; i++ {
+			for y := range col {
+				col[y] = p.flts[y*width+i]
+			}
+			lift97(col, py)
+			for y, v := range col {
+				p.flts[y*width+i] = v
+			}
+		}
+	}
+
+	return p.crop(w)
+}
+
+// crop returns the part of p within r
+func (p *plane) crop(r image.Rectangle) *plane {
+	if r == p.rect {
+		return p
+	}
+	var c = newPlane(r, p.ints != nil)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		var src, dst = p.offset(r.Min.X, y), c.offset(r.Min.X, y)
+		if p.ints != nil {
+			copy(c.ints[dst:dst+r.Dx()], p.ints[src:])
+		} else {
+			copy(c.flts[dst:dst+r.Dx()], p.flts[src:])
+		}
+	}
+	return c
+}
+
+// lift53 applies the reversible 5/3 synthesis to interleaved samples, where
+// parity is 1 if the first sample is high-pass.  Edges are extended
+// symmetrically.
+func lift53(s []int32, parity int) {
+	var n = len(s)
+	if n == 1 {
+		if parity == 1 {
+			s[0] /= 2
+		}
+		return
+	}
+	for i := parity; i < n; i += 2 {
+		var l, r = neighbors32(s, i)
+		s[i] -= (l + r + 2) >> 2
+	}
+	for i := 1 - parity; i < n; i += 2 {
+		var l, r = neighbors32(s, i)
+		s[i] += (l + r) >> 1
+	}
+}
+
+func neighbors32(s []int32, i int) (int32, int32) {
+	var l, r int32
+	if i > 0 {
+		l = s[i-1]
+	} else {
+		l = s[i+1]
+	}
+	if i+1 < len(s) {
+		r = s[i+1]
+	} else {
+		r = s[i-1]
+	}
+	return l, r
+}
+
+// lift97 applies the irreversible 9/7 synthesis to interleaved coefficients,
+// where parity is 1 if the first coefficient is high-pass
+func lift97(s []float32, parity int) {
+	var n = len(s)
+	if n == 1 {
+		if parity == 1 {
+			s[0] /= 2
+		}
+		return
+	}
+	var lo, hi = parity, 1 - parity
+	for i := lo; i < n; i += 2 {
+		s[i] *= k97
+	}
+	for i := hi; i < n; i += 2 {
+		s[i] *= 1 / k97
+	}
+	liftStep(s, lo, delta97)
+	liftStep(s, hi, gamma97)
+	liftStep(s, lo, beta97)
+	liftStep(s, hi, alpha97)
+}
+
+// liftStep subtracts c times the sum of each sample's neighbors from every
+// other sample, starting at first
+func liftStep(s []float32, first int, c float32) {
+	var n = len(s)
+	for i := first; i < n; i += 2 {
+		var l, r float32
+		if i > 0 {
+			l = s[i-1]
+		} else {
+			l = s[i+1]
+		}
+		if i+1 < n {
+			r = s[i+1]
+		} else {
+			r = s[i-1]
+		}
+		s[i] -= c * (l + r)
+	}
+}
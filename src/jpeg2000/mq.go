@@ -0,0 +1,185 @@
+package jpeg2000
+
+// mqState is one row of the MQ coder's probability estimation table
+type mqState struct {
+	qe         uint32
+	nmps, nlps uint8
+	swtch      bool
+}
+
+var mqStates = [47]mqState{
+	{0x5601, 1, 1, true}, {0x3401, 2, 6, false}, {0x1801, 3, 9, false},
+	{0x0AC1, 4, 12, false}, {0x0521, 5, 29, false}, {0x0221, 38, 33, false},
+	{0x5601, 7, 6, true}, {0x5401, 8, 14, false}, {0x4801, 9, 14, false},
+	{0x3801, 10, 14, false}, {0x3001, 11, 17, false}, {0x2401, 12, 18, false},
+	{0x1C01, 13, 20, false}, {0x1601, 29, 21, false}, {0x5601, 15, 14, true},
+	{0x5401, 16, 14, false}, {0x5101, 17, 15, false}, {0x4801, 18, 16, false},
+	{0x3801, 19, 17, false}, {0x3401, 20, 18, false}, {0x3001, 21, 19, false},
+	{0x2801, 22, 19, false}, {0x2401, 23, 20, false}, {0x2201, 24, 21, false},
+	{0x1C01, 25, 22, false}, {0x1801, 26, 23, false}, {0x1601, 27, 24, false},
+	{0x1401, 28, 25, false}, {0x1201, 29, 26, false}, {0x1101, 30, 27, false},
+	{0x0AC1, 31, 28, false}, {0x09C1, 32, 29, false}, {0x08A1, 33, 30, false},
+	{0x0521, 34, 31, false}, {0x0441, 35, 32, false}, {0x02A1, 36, 33, false},
+	{0x0221, 37, 34, false}, {0x0141, 38, 35, false}, {0x0111, 39, 36, false},
+	{0x0085, 40, 37, false}, {0x0049, 41, 38, false}, {0x0025, 42, 39, false},
+	{0x0015, 43, 40, false}, {0x0009, 44, 41, false}, {0x0005, 45, 42, false},
+	{0x0001, 45, 43, false}, {0x5601, 46, 46, false},
+}
+
+// Contexts used by tier-1 decoding: nine for significance, five for signs,
+// three for refinement, then run-length and uniform
+const (
+	ctxZC   = 0
+	ctxSC   = 9
+	ctxMR   = 14
+	ctxRL   = 17
+	ctxUNI  = 18
+	numCtxs = 19
+)
+
+// mqDecoder is the arithmetic decoder of Annex C.  Reading past the end of
+// the data supplies 0xFF bytes, which the decoder treats as a marker.
+type mqDecoder struct {
+	data  []byte
+	pos   int
+	a, c  uint32
+	ct    uint
+	state [numCtxs]uint8
+	mps   [numCtxs]uint8
+}
+
+// resetContexts puts every context in its initial state
+func (m *mqDecoder) resetContexts() {
+	for i := range m.state {
+		m.state[i], m.mps[i] = 0, 0
+	}
+	m.state[ctxZC] = 4
+	m.state[ctxRL] = 3
+	m.state[ctxUNI] = 46
+}
+
+func (m *mqDecoder) byteAt(i int) uint32 {
+	if i < len(m.data) {
+		return uint32(m.data[i])
+	}
+	return 0xFF
+}
+
+// init starts decoding a new segment, keeping the contexts' states
+func (m *mqDecoder) init(data []byte) {
+	m.data = data
+	m.pos = 0
+	m.c = m.byteAt(0) << 16
+	m.byteIn()
+	m.c <<= 7
+	m.ct -= 7
+	m.a = 0x8000
+}
+
+func (m *mqDecoder) byteIn() {
+	if m.byteAt(m.pos) == 0xFF {
+		if m.byteAt(m.pos+1) > 0x8F {
+			m.c += 0xFF00
+			m.ct = 8
+		} else {
+			m.pos++
+			m.c += m.byteAt(m.pos) << 9
+			m.ct = 7
+		}
+	} else {
+		m.pos++
+		m.c += m.byteAt(m.pos) << 8
+		m.ct = 8
+	}
+}
+
+func (m *mqDecoder) renorm() {
+	for {
+		if m.ct == 0 {
+			m.byteIn()
+		}
+		m.a <<= 1
+		m.c <<= 1
+		m.ct--
+		if m.a&0x8000 != 0 {
+			return
+		}
+	}
+}
+
+// decode returns the next decision in context cx
+func (m *mqDecoder) decode(cx int) int {
+	var s = &mqStates[m.state[cx]]
+	var d int
+	m.a -= s.qe
+	if m.c>>16 < s.qe {
+		if m.a < s.qe {
+			m.a = s.qe
+			d = int(m.mps[cx])
+			m.state[cx] = s.nmps
+		} else {
+			m.a = s.qe
+			d = 1 - int(m.mps[cx])
+			if s.swtch {
+				m.mps[cx] = 1 - m.mps[cx]
+			}
+			m.state[cx] = s.nlps
+		}
+		m.renorm()
+		return d
+	}
+
+	m.c -= s.qe << 16
+	if m.a&0x8000 != 0 {
+		return int(m.mps[cx])
+	}
+	if m.a < s.qe {
+		d = 1 - int(m.mps[cx])
+		if s.swtch {
+			m.mps[cx] = 1 - m.mps[cx]
+		}
+		m.state[cx] = s.nlps
+	} else {
+		d = int(m.mps[cx])
+		m.state[cx] = s.nmps
+	}
+	m.renorm()
+	return d
+}
+
+// rawDecoder reads the uncoded bits of bypass mode passes
+type rawDecoder struct {
+	data []byte
+	pos  int
+	c    uint32
+	ct   uint
+}
+
+func (r *rawDecoder) init(data []byte) {
+	*r = rawDecoder{data: data}
+}
+
+func (r *rawDecoder) decode() int {
+	if r.ct == 0 {
+		var b = uint32(0xFF)
+		if r.pos < len(r.data) {
+			b = uint32(r.data[r.pos])
+		}
+		if r.c == 0xFF {
+			if b > 0x8F {
+				r.c = 0xFF
+				r.ct = 8
+			} else {
+				r.c = b
+				r.pos++
+				r.ct = 7
+			}
+		} else {
+			r.c = b
+			r.pos++
+			r.ct = 8
+		}
+	}
+	r.ct--
+	return int(r.c>>r.ct) & 1
+}
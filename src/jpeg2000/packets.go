@@ -0,0 +1,402 @@
+package jpeg2000
+
+import "encoding/binary"
+
+// packet identifies one packet of a tile
+type packet struct {
+	layer, res, comp, prec int
+}
+
+// progressions returns the tile's progression order changes, or a single
+// progression covering everything in the COD order if there are none
+func (t *tile) progressions() []progression {
+	var maxRes = 0
+	for _, tc := range t.comps {
+		if len(tc.res) > maxRes {
+			maxRes = len(tc.res)
+		}
+	}
+	if len(t.p.pocs) == 0 {
+		return []progression{{order: t.p.order, resE: maxRes, compE: len(t.comps), layE: t.p.layers}}
+	}
+
+	var pocs = make([]progression, len(t.p.pocs))
+	for i, poc := range t.p.pocs {
+		if poc.resE > maxRes {
+			poc.resE = maxRes
+		}
+		if poc.compE > len(t.comps) {
+			poc.compE = len(t.comps)
+		}
+		if poc.layE > t.p.layers {
+			poc.layE = t.p.layers
+		}
+		pocs[i] = poc
+	}
+	return pocs
+}
+
+// packetOrder returns the tile's packets in the order they appear in the
+// codestream, following openjpeg's progression iterators.  Packets listed by
+// more than one progression order change appear only the first time.
+func (t *tile) packetOrder() []packet {
+	var seen = make([][][]bool, len(t.comps))
+	for c, tc := range t.comps {
+		seen[c] = make([][]bool, len(tc.res))
+		for r, res := range tc.res {
+			seen[c][r] = make([]bool, t.p.layers*res.pw*res.ph)
+		}
+	}
+
+	var packets []packet
+	var add = func(l, r, c, p int) {
+		var n = t.comps[c].res[r].pw * t.comps[c].res[r].ph
+		if !seen[c][r][l*n+p] {
+			seen[c][r][l*n+p] = true
+			packets = append(packets, packet{layer: l, res: r, comp: c, prec: p})
+		}
+	}
+
+	for _, poc := range t.progressions() {
+		switch poc.order {
+		case orderLRCP:
+			for l := 0; l < poc.layE; l++ {
+				for r := poc.resS; r < poc.resE; r++ {
+					for c := poc.compS; c < poc.compE; c++ {
+						t.eachPrecinct(c, r, func(p int) { add(l, r, c, p) })
+					}
+				}
+			}
+		case orderRLCP:
+			for r := poc.resS; r < poc.resE; r++ {
+				for l := 0; l < poc.layE; l++ {
+					for c := poc.compS; c < poc.compE; c++ {
+						t.eachPrecinct(c, r, func(p int) { add(l, r, c, p) })
+					}
+				}
+			}
+		case orderRPCL:
+			var dx, dy = t.positionSteps(0, len(t.comps))
+			for r := poc.resS; r < poc.resE; r++ {
+				t.eachPosition(dx, dy, func(x, y int) {
+					for c := poc.compS; c < poc.compE; c++ {
+						if p, ok := t.precinctAt(c, r, x, y); ok {
+							for l := 0; l < poc.layE; l++ {
+								add(l, r, c, p)
+							}
+						}
+					}
+				})
+			}
+		case orderPCRL:
+			var dx, dy = t.positionSteps(0, len(t.comps))
+			t.eachPosition(dx, dy, func(x, y int) {
+				for c := poc.compS; c < poc.compE; c++ {
+					for r := poc.resS; r < poc.resE; r++ {
+						if p, ok := t.precinctAt(c, r, x, y); ok {
+							for l := 0; l < poc.layE; l++ {
+								add(l, r, c, p)
+							}
+						}
+					}
+				}
+			})
+		case orderCPRL:
+			for c := poc.compS; c < poc.compE; c++ {
+				var dx, dy = t.positionSteps(c, c+1)
+				t.eachPosition(dx, dy, func(x, y int) {
+					for r := poc.resS; r < poc.resE; r++ {
+						if p, ok := t.precinctAt(c, r, x, y); ok {
+							for l := 0; l < poc.layE; l++ {
+								add(l, r, c, p)
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+	return packets
+}
+
+// eachPrecinct calls fn for each precinct of component c's resolution r, if
+// it has one
+func (t *tile) eachPrecinct(c, r int, fn func(int)) {
+	if r >= len(t.comps[c].res) {
+		return
+	}
+	var res = t.comps[c].res[r]
+	for p := 0; p < res.pw*res.ph; p++ {
+		fn(p)
+	}
+}
+
+// positionSteps returns the smallest precinct size, on the reference grid,
+// of the given components' resolutions
+func (t *tile) positionSteps(c0, c1 int) (int, int) {
+	var dx, dy int
+	for c := c0; c < c1; c++ {
+		var tc = t.comps[c]
+		for r, res := range tc.res {
+			var levelno = uint(len(tc.res) - 1 - r)
+			var sx = tc.dx << (res.ppx + levelno)
+			var sy = tc.dy << (res.ppy + levelno)
+			if dx == 0 || sx < dx {
+				dx = sx
+			}
+			if dy == 0 || sy < dy {
+				dy = sy
+			}
+		}
+	}
+	return dx, dy
+}
+
+// eachPosition calls fn for each position on the reference grid which may
+// start a precinct, stepping by dx and dy
+func (t *tile) eachPosition(dx, dy int, fn func(x, y int)) {
+	if dx <= 0 || dy <= 0 {
+		return
+	}
+	for y := t.rect.Min.Y; y < t.rect.Max.Y; y += dy - y%dy {
+		for x := t.rect.Min.X; x < t.rect.Max.X; x += dx - x%dx {
+			fn(x, y)
+		}
+	}
+}
+
+// precinctAt returns the index of component c's resolution r precinct which
+// starts at reference grid position x, y, if there is one
+func (t *tile) precinctAt(c, r, x, y int) (int, bool) {
+	var tc = t.comps[c]
+	if r >= len(tc.res) {
+		return 0, false
+	}
+	var res = tc.res[r]
+	var levelno = uint(len(tc.res) - 1 - r)
+	var rpx, rpy = res.ppx + levelno, res.ppy + levelno
+	var trx0 = ceilDiv(t.rect.Min.X, tc.dx<<levelno)
+	var try0 = ceilDiv(t.rect.Min.Y, tc.dy<<levelno)
+	var trx1 = ceilDiv(t.rect.Max.X, tc.dx<<levelno)
+	var try1 = ceilDiv(t.rect.Max.Y, tc.dy<<levelno)
+
+	if !(y%(tc.dy<<rpy) == 0 || (y == t.rect.Min.Y && (try0<<levelno)%(1<<rpy) != 0)) {
+		return 0, false
+	}
+	if !(x%(tc.dx<<rpx) == 0 || (x == t.rect.Min.X && (trx0<<levelno)%(1<<rpx) != 0)) {
+		return 0, false
+	}
+	if res.pw == 0 || res.ph == 0 || trx0 == trx1 || try0 == try1 {
+		return 0, false
+	}
+
+	var pi = floorDivPow2(ceilDiv(x, tc.dx<<levelno), res.ppx) - floorDivPow2(trx0, res.ppx)
+	var pj = floorDivPow2(ceilDiv(y, tc.dy<<levelno), res.ppy) - floorDivPow2(try0, res.ppy)
+	var p = pi + pj*res.pw
+	if pi < 0 || pj < 0 || pi >= res.pw || pj >= res.ph {
+		return 0, false
+	}
+	return p, true
+}
+
+// readPackets parses every packet in the tile, keeping the compressed data
+// of the code-blocks marked as needed
+func (t *tile) readPackets() error {
+	var data, err = t.cs.readTileData(t.parts)
+	if err != nil {
+		return err
+	}
+
+	// Packet headers are in the packet data unless PPM or PPT moved them
+	var headers = data
+	var hpos, dpos = 0, 0
+	var packed = len(t.headers) > 0
+	if packed {
+		headers = t.headers
+	}
+
+	for _, pk := range t.packetOrder() {
+		if dpos >= len(data) {
+			// Truncated codestreams decode with whatever data is present
+			break
+		}
+
+		if t.p.sop && dpos+6 <= len(data) && hasMarker(data[dpos:], markerSOP) {
+			dpos += 6
+		}
+		if !packed {
+			hpos = dpos
+		}
+
+		var n, err = t.readPacketHeader(pk, headers[hpos:])
+		if err != nil {
+			break
+		}
+		hpos += n
+		if !packed {
+			dpos = hpos
+		}
+		dpos = t.readPacketBody(pk, data, dpos)
+	}
+	return nil
+}
+
+// readPacketHeader decodes a packet header from h, returning its length
+func (t *tile) readPacketHeader(pk packet, h []byte) (int, error) {
+	var res = &t.comps[pk.comp].res[pk.res]
+	var style = t.comps[pk.comp].coding.style
+	var br = newBitReader(h)
+
+	if br.bit() == 1 {
+		for bi := range res.bands {
+			var b = &res.bands[bi]
+			if b.rect.Empty() {
+				continue
+			}
+			var p = &b.precincts[pk.prec]
+			for i := range p.blocks {
+				readBlockHeader(br, p, i, b.numbps, pk.layer, style)
+			}
+		}
+	}
+	br.align()
+	if br.overrun() {
+		return 0, errTruncated
+	}
+
+	var n = br.bytesRead()
+	if t.p.eph && hasMarker(h[n:], markerEPH) {
+		n += 2
+	}
+	return n, nil
+}
+
+// hasMarker returns true if b starts with marker m
+func hasMarker(b []byte, m uint16) bool {
+	return len(b) >= 2 && binary.BigEndian.Uint16(b) == m
+}
+
+// readBlockHeader reads one code-block's part of a packet header
+func readBlockHeader(br *bitReader, p *precinct, i int, bandNumbps int, layer int, style byte) {
+	var cb = &p.blocks[i]
+	cb.pending = 0
+
+	var included bool
+	if !cb.included {
+		included = p.incl.decode(br, i, layer+1)
+	} else {
+		included = br.bit() == 1
+	}
+	if !included {
+		return
+	}
+
+	if !cb.included {
+		var zero = 0
+		for !p.imsb.decode(br, i, zero+1) {
+			zero++
+		}
+		cb.numbps = bandNumbps - zero
+		cb.lblock = 3
+		cb.included = true
+		cb.segs = append(cb.segs, newPassSegment(style, nil))
+	} else if last := &cb.segs[len(cb.segs)-1]; last.passes == last.maxPasses {
+		cb.segs = append(cb.segs, newPassSegment(style, last))
+	}
+
+	var passes = numPasses(br)
+	for br.bit() == 1 {
+		cb.lblock++
+	}
+
+	for passes > 0 {
+		var seg = &cb.segs[len(cb.segs)-1]
+		var n = seg.maxPasses - seg.passes
+		if n > passes {
+			n = passes
+		}
+		var l = int(br.read(cb.lblock + floorLog2(n)))
+		seg.passes += n
+		seg.length += l
+		cb.pending += l
+		passes -= n
+		if passes > 0 {
+			cb.segs = append(cb.segs, newPassSegment(style, seg))
+		}
+	}
+}
+
+// newPassSegment returns the segment which follows prev, or the first
+// segment if prev is nil
+func newPassSegment(style byte, prev *passSegment) passSegment {
+	var s passSegment
+	switch {
+	case style&cblkTermAll != 0:
+		s.maxPasses = 1
+	case style&cblkBypass != 0:
+		s.maxPasses = 1
+		if prev == nil {
+			s.maxPasses = 10
+		} else if prev.maxPasses == 1 || prev.maxPasses == 10 {
+			s.maxPasses = 2
+		}
+	default:
+		s.maxPasses = 109
+	}
+	return s
+}
+
+// numPasses reads the number of new coding passes in a code-block
+func numPasses(br *bitReader) int {
+	if br.bit() == 0 {
+		return 1
+	}
+	if br.bit() == 0 {
+		return 2
+	}
+	if n := int(br.read(2)); n != 3 {
+		return 3 + n
+	}
+	if n := int(br.read(5)); n != 31 {
+		return 6 + n
+	}
+	return 37 + int(br.read(7))
+}
+
+func floorLog2(n int) uint {
+	var l uint
+	for n > 1 {
+		n >>= 1
+		l++
+	}
+	return l
+}
+
+// readPacketBody copies the packet's code-block data, which starts at pos,
+// to the code-blocks which need it, returning the position after the packet
+func (t *tile) readPacketBody(pk packet, data []byte, pos int) int {
+	var res = &t.comps[pk.comp].res[pk.res]
+	for bi := range res.bands {
+		var b = &res.bands[bi]
+		if b.rect.Empty() {
+			continue
+		}
+		var p = &b.precincts[pk.prec]
+		for i := range p.blocks {
+			var cb = &p.blocks[i]
+			if cb.pending == 0 {
+				continue
+			}
+			var end = pos + cb.pending
+			if end > len(data) {
+				end = len(data)
+			}
+			if cb.needed {
+				cb.data = append(cb.data, data[pos:end]...)
+			}
+			cb.pending = 0
+			pos = end
+		}
+	}
+	return pos
+}
@@ -0,0 +1,376 @@
+package jpeg2000
+
+import (
+	"errors"
+)
+
+// Per-sample tier-1 state
+const (
+	flagSig     = 1 << iota // significant
+	flagNeg                 // negative, once significant
+	flagVisit               // coded in this bit-plane's significance pass
+	flagRefined             // refined at least once
+)
+
+// t1Decoder decodes code-blocks' coding passes.  Samples are decoded at
+// twice their magnitude, with half the current bit-plane added as a
+// reconstruction point, so a truncated code-block decodes to the middle of
+// each sample's possible range; the caller halves the results.
+type t1Decoder struct {
+	mq    mqDecoder
+	raw   rawDecoder
+	w, h  int
+	data  []int32
+	flags []uint8
+
+	orient int
+	vsc    bool
+}
+
+// index returns the flags index of sample x, y: the flags have a one-sample
+// border so neighbors never need bounds checks
+func (t *t1Decoder) index(x, y int) int {
+	return (y+1)*(t.w+2) + x + 1
+}
+
+// decodeBlock decodes cb's passes, returning its samples
+func (t *t1Decoder) decodeBlock(cb *codeBlock, orient int, style byte, roishift uint) ([]int32, error) {
+	t.w, t.h = cb.rect.Dx(), cb.rect.Dy()
+	t.orient = orient
+	t.vsc = style&cblkVSC != 0
+	t.data = make([]int32, t.w*t.h)
+	var n = (t.w + 2) * (t.h + 2)
+	if cap(t.flags) < n {
+		t.flags = make([]uint8, n)
+	}
+	t.flags = t.flags[:n]
+	for i := range t.flags {
+		t.flags[i] = 0
+	}
+
+	var bpno = int(roishift) + cb.numbps
+	if bpno >= 31 {
+		return nil, errors.New("code-block has too many bit-planes")
+	}
+	if len(cb.segs) == 0 || bpno < 1 {
+		return t.data, nil
+	}
+
+	t.mq.resetContexts()
+	var passType = 2
+	var pass = 0
+	var offset = 0
+	for _, seg := range cb.segs {
+		var end = offset + seg.length
+		if end > len(cb.data) {
+			end = len(cb.data)
+		}
+		var segData []byte
+		if offset < end {
+			segData = cb.data[offset:end]
+		}
+		offset += seg.length
+
+		var raw = style&cblkBypass != 0 && pass >= 10 && passType < 2
+		if raw {
+			t.raw.init(segData)
+		} else {
+			t.mq.init(segData)
+		}
+
+		for p := 0; p < seg.passes && bpno >= 1; p++ {
+			switch passType {
+			case 0:
+				t.significancePass(bpno, raw)
+			case 1:
+				t.refinementPass(bpno, raw)
+			case 2:
+				t.cleanupPass(bpno)
+				if style&cblkSegSym != 0 {
+					for i := 0; i < 4; i++ {
+						t.mq.decode(ctxUNI)
+					}
+				}
+			}
+			if style&cblkReset != 0 && !raw {
+				t.mq.resetContexts()
+			}
+			pass++
+			passType++
+			if passType == 3 {
+				passType = 0
+				bpno--
+			}
+		}
+	}
+
+	if roishift > 0 {
+		var thresh = int32(1) << roishift
+		for i, v := range t.data {
+			var mag = v
+			if mag < 0 {
+				mag = -mag
+			}
+			if mag >= thresh {
+				mag >>= roishift
+				if v < 0 {
+					mag = -mag
+				}
+				t.data[i] = mag
+			}
+		}
+	}
+	return t.data, nil
+}
+
+// neighbors returns the number of significant horizontal, vertical, and
+// diagonal neighbors of the sample at flags index i.  With vertically causal
+// context formation, the bottom row of a stripe ignores the stripe below.
+func (t *t1Decoder) neighbors(i int, stripeEnd bool) (h, v, d int) {
+	var f = t.flags
+	var stride = t.w + 2
+	h = int(f[i-1]&flagSig) + int(f[i+1]&flagSig)
+	v = int(f[i-stride] & flagSig)
+	d = int(f[i-stride-1]&flagSig) + int(f[i-stride+1]&flagSig)
+	if !stripeEnd || !t.vsc {
+		v += int(f[i+stride] & flagSig)
+		d += int(f[i+stride-1]&flagSig) + int(f[i+stride+1]&flagSig)
+	}
+	return h, v, d
+}
+
+// zeroContext returns the significance coding context (Table D.1)
+func (t *t1Decoder) zeroContext(h, v, d int) int {
+	switch t.orient {
+	case bandHL:
+		h, v = v, h
+		fallthrough
+	case bandLL, bandLH:
+		switch {
+		case h == 2:
+			return 8
+		case h == 1 && v >= 1:
+			return 7
+		case h == 1 && d >= 1:
+			return 6
+		case h == 1:
+			return 5
+		case v == 2:
+			return 4
+		case v == 1:
+			return 3
+		case d >= 2:
+			return 2
+		case d == 1:
+			return 1
+		}
+		return 0
+	}
+
+	var hv = h + v
+	switch {
+	case d >= 3:
+		return 8
+	case d == 2 && hv >= 1:
+		return 7
+	case d == 2:
+		return 6
+	case d == 1 && hv >= 2:
+		return 5
+	case d == 1 && hv == 1:
+		return 4
+	case d == 1:
+		return 3
+	case hv >= 2:
+		return 2
+	case hv == 1:
+		return 1
+	}
+	return 0
+}
+
+// contribution returns a neighbor's sign contribution: 1 if it's
+// significant and positive, -1 if significant and negative, else 0
+func contribution(f uint8) int {
+	if f&flagSig == 0 {
+		return 0
+	}
+	if f&flagNeg != 0 {
+		return -1
+	}
+	return 1
+}
+
+func clampUnit(n int) int {
+	if n > 1 {
+		return 1
+	}
+	if n < -1 {
+		return -1
+	}
+	return n
+}
+
+// decodeSign decodes the sign of the sample at flags index i (Table D.3),
+// returning true if it's negative
+func (t *t1Decoder) decodeSign(i int, stripeEnd bool) bool {
+	var f = t.flags
+	var stride = t.w + 2
+	var h = clampUnit(contribution(f[i-1]) + contribution(f[i+1]))
+	var vd = 0
+	if !stripeEnd || !t.vsc {
+		vd = contribution(f[i+stride])
+	}
+	var v = clampUnit(contribution(f[i-stride]) + vd)
+
+	var ctx, xor int
+	switch h {
+	case 1:
+		ctx = 12 + v
+	case 0:
+		ctx, xor = 9, 0
+		if v != 0 {
+			ctx = 10
+		}
+		if v < 0 {
+			xor = 1
+		}
+	case -1:
+		ctx, xor = 12-v, 1
+	}
+	return t.mq.decode(ctx)^xor == 1
+}
+
+// setSignificant marks sample x, y significant with the value for bit-plane
+// bpno
+func (t *t1Decoder) setSignificant(x, y, i int, bpno int, neg bool) {
+	var v = int32(3) << uint(bpno-1)
+	t.flags[i] |= flagSig
+	if neg {
+		t.flags[i] |= flagNeg
+		v = -v
+	}
+	t.data[y*t.w+x] = v
+}
+
+func (t *t1Decoder) significancePass(bpno int, raw bool) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				var i = t.index(x, y)
+				if t.flags[i]&flagSig != 0 {
+					continue
+				}
+				var stripeEnd = y == y0+3
+				var h, v, d = t.neighbors(i, stripeEnd)
+				if h+v+d == 0 {
+					continue
+				}
+				var bit int
+				if raw {
+					bit = t.raw.decode()
+				} else {
+					bit = t.mq.decode(ctxZC + t.zeroContext(h, v, d))
+				}
+				if bit == 1 {
+					var neg bool
+					if raw {
+						neg = t.raw.decode() == 1
+					} else {
+						neg = t.decodeSign(i, stripeEnd)
+					}
+					t.setSignificant(x, y, i, bpno, neg)
+				}
+				t.flags[i] |= flagVisit
+			}
+		}
+	}
+}
+
+func (t *t1Decoder) refinementPass(bpno int, raw bool) {
+	var half = int32(1) << uint(bpno-1)
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				var i = t.index(x, y)
+				if t.flags[i]&(flagSig|flagVisit) != flagSig {
+					continue
+				}
+				var bit int
+				if raw {
+					bit = t.raw.decode()
+				} else {
+					var ctx = ctxMR + 2
+					if t.flags[i]&flagRefined == 0 {
+						ctx = ctxMR
+						if h, v, d := t.neighbors(i, y == y0+3); h+v+d > 0 {
+							ctx = ctxMR + 1
+						}
+					}
+					bit = t.mq.decode(ctx)
+				}
+
+				var delta = half
+				if bit == 0 {
+					delta = -half
+				}
+				var p = &t.data[y*t.w+x]
+				if *p < 0 {
+					*p -= delta
+				} else {
+					*p += delta
+				}
+				t.flags[i] |= flagRefined
+			}
+		}
+	}
+}
+
+func (t *t1Decoder) cleanupPass(bpno int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			var start = y0
+			if y0+3 < t.h && t.runLengthEligible(x, y0) {
+				if t.mq.decode(ctxRL) == 0 {
+					continue
+				}
+				var r = t.mq.decode(ctxUNI)<<1 | t.mq.decode(ctxUNI)
+				var y = y0 + r
+				var i = t.index(x, y)
+				t.setSignificant(x, y, i, bpno, t.decodeSign(i, y == y0+3))
+				start = y + 1
+			}
+
+			for y := start; y < y0+4 && y < t.h; y++ {
+				var i = t.index(x, y)
+				if t.flags[i]&(flagSig|flagVisit) != 0 {
+					continue
+				}
+				var stripeEnd = y == y0+3
+				var h, v, d = t.neighbors(i, stripeEnd)
+				if t.mq.decode(ctxZC+t.zeroContext(h, v, d)) == 1 {
+					t.setSignificant(x, y, i, bpno, t.decodeSign(i, stripeEnd))
+				}
+			}
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				t.flags[t.index(x, y)] &^= flagVisit
+			}
+		}
+	}
+}
+
+// runLengthEligible returns true if the four samples in column x of the
+// stripe at y0 are all insignificant, uncoded, and have no significant
+// neighbors, so the cleanup pass codes them in run-length mode
+func (t *t1Decoder) runLengthEligible(x, y0 int) bool {
+	for y := y0; y < y0+4; y++ {
+		var i = t.index(x, y)
+		if t.flags[i]&(flagSig|flagVisit) != 0 {
+			return false
+		}
+		if h, v, d := t.neighbors(i, y == y0+3); h+v+d > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,485 @@
+package jpeg2000
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// Band orientations, in codestream order
+const (
+	bandLL = iota
+	bandHL
+	bandLH
+	bandHH
+)
+
+// maxBlocks limits the code-blocks in a tile-component, so a corrupt header
+// can't make the decoder allocate unbounded amounts of memory
+const maxBlocks = 1 << 22
+
+// Margin, in samples, around each wavelet synthesis window.  The 9/7 filter's
+// lifting steps reach four samples either side, so results more than four
+// samples from a window's edge don't depend on what lies beyond it.
+const windowMargin = 4
+
+// passSegment is a run of coding passes terminated together
+type passSegment struct {
+	length    int
+	passes    int
+	maxPasses int
+}
+
+// codeBlock holds a code-block's tier-2 state and compressed data
+type codeBlock struct {
+	rect     image.Rectangle
+	included bool
+	numbps   int
+	lblock   uint
+	segs     []passSegment
+	data     []byte
+	pending  int
+	needed   bool
+}
+
+// precinct is one band's share of a precinct: a grid of code-blocks with the
+// tag trees that code their inclusion and zero bit-planes
+type precinct struct {
+	cw, ch int
+	blocks []codeBlock
+	incl   *tagTree
+	imsb   *tagTree
+}
+
+type band struct {
+	orient    int
+	rect      image.Rectangle
+	numbps    int
+	step      float32
+	cbw, cbh  uint
+	precincts []precinct
+	window    image.Rectangle
+}
+
+type resolution struct {
+	rect     image.Rectangle
+	pw, ph   int
+	ppx, ppy uint
+	bands    []band
+
+	// window is the area which must be reconstructed exactly, and expanded is
+	// the area synthesized to get it
+	window   image.Rectangle
+	expanded image.Rectangle
+}
+
+type tileComp struct {
+	rect   image.Rectangle
+	dx, dy int
+	coding *coding
+	quant  *quant
+	roi    uint
+	reduce uint
+	res    []resolution
+
+	// window is the part of the tile-component's reduced resolution being
+	// decoded
+	window image.Rectangle
+}
+
+// tile holds a tile's coding parameters, geometry, and compressed data
+type tile struct {
+	cs      *codestream
+	p       *params
+	headers []byte
+	parts   []tilePart
+	rect    image.Rectangle
+	comps   []tileComp
+	reduce  uint
+}
+
+// newTile reads the tile's headers and sets up its geometry.  Geometry is
+// built for every component, since all components' packets must be parsed,
+// but only the first ncomps will be decoded.
+func newTile(cs *codestream, index int, r image.Rectangle, ncomps int, reduce uint) (*tile, error) {
+	var p, headers, parts, err = cs.tileParams(index)
+	if err != nil {
+		return nil, err
+	}
+	var t = &tile{cs: cs, p: p, headers: headers, parts: parts, rect: r, reduce: reduce}
+	if len(parts) == 0 {
+		return nil, errors.New("tile has no data")
+	}
+
+	t.comps = make([]tileComp, len(cs.comps))
+	for c := range t.comps {
+		var comp = cs.comps[c]
+		var tc = &t.comps[c]
+		tc.dx, tc.dy = comp.dx, comp.dy
+		tc.rect = componentRect(r, comp.dx, comp.dy, 0)
+		tc.coding = &p.coding[c]
+		tc.quant = &p.quant[c]
+		tc.roi = p.roi[c]
+		tc.reduce = reduce
+		if c < ncomps && int(reduce) > tc.coding.levels {
+			return nil, errors.New("resolution reduction exceeds a tile's decomposition levels")
+		}
+		err = tc.init(comp.prec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// init computes the resolutions, bands, precincts, and code-blocks of the
+// tile-component
+func (tc *tileComp) init(prec uint) error {
+	var cod = tc.coding
+	var levels = cod.levels
+	var blocks = 0
+	tc.res = make([]resolution, levels+1)
+	for r := range tc.res {
+		var res = &tc.res[r]
+		var levelno = uint(levels - r)
+		res.rect = image.Rect(
+			ceilDivPow2(tc.rect.Min.X, levelno), ceilDivPow2(tc.rect.Min.Y, levelno),
+			ceilDivPow2(tc.rect.Max.X, levelno), ceilDivPow2(tc.rect.Max.Y, levelno),
+		)
+		res.ppx, res.ppy = cod.ppx[r], cod.ppy[r]
+
+		var prcX0 = floorDivPow2(res.rect.Min.X, res.ppx) << res.ppx
+		var prcY0 = floorDivPow2(res.rect.Min.Y, res.ppy) << res.ppy
+		if res.rect.Dx() > 0 {
+			res.pw = (ceilDivPow2(res.rect.Max.X, res.ppx)<<res.ppx - prcX0) >> res.ppx
+		}
+		if res.rect.Dy() > 0 {
+			res.ph = (ceilDivPow2(res.rect.Max.Y, res.ppy)<<res.ppy - prcY0) >> res.ppy
+		}
+		if res.pw*res.ph > 1<<20 {
+			return errors.New("too many precincts")
+		}
+
+		// Code-block groups live in band coordinates, which halve the resolution's
+		// except at the lowest resolution
+		var cbgX0, cbgY0 = prcX0, prcY0
+		var cbgw, cbgh = res.ppx, res.ppy
+		if r > 0 {
+			cbgX0, cbgY0 = ceilDivPow2(prcX0, 1), ceilDivPow2(prcY0, 1)
+			cbgw, cbgh = cbgw-1, cbgh-1
+		}
+
+		var orients = []int{bandLL}
+		if r > 0 {
+			orients = []int{bandHL, bandLH, bandHH}
+		}
+		res.bands = make([]band, len(orients))
+		for bi, o := range orients {
+			var b = &res.bands[bi]
+			b.orient = o
+			if r == 0 {
+				b.rect = res.rect
+			} else {
+				var x0b, y0b = o & 1, o >> 1
+				b.rect = image.Rect(
+					ceilDivPow2(tc.rect.Min.X-x0b<<levelno, levelno+1),
+					ceilDivPow2(tc.rect.Min.Y-y0b<<levelno, levelno+1),
+					ceilDivPow2(tc.rect.Max.X-x0b<<levelno, levelno+1),
+					ceilDivPow2(tc.rect.Max.Y-y0b<<levelno, levelno+1),
+				)
+			}
+
+			var qi = 0
+			if r > 0 {
+				qi = 3*(r-1) + o
+			}
+			var expn, mant = tc.quant.step(qi)
+			b.numbps = int(expn) + int(tc.quant.guard) - 1
+			var gain = []uint{0, 1, 1, 2}[o]
+			b.step = float32(1+float64(mant)/2048) * pow2(int(prec+gain)-int(expn))
+
+			b.cbw, b.cbh = min(cod.cbw, cbgw), min(cod.cbh, cbgh)
+			b.precincts = make([]precinct, res.pw*res.ph)
+			for pi := range b.precincts {
+				var gx = cbgX0 + (pi%res.pw)<<cbgw
+				var gy = cbgY0 + (pi/res.pw)<<cbgh
+				var pr = image.Rect(gx, gy, gx+1<<cbgw, gy+1<<cbgh).Intersect(b.rect)
+				var cw, ch = blockGrid(pr, b.cbw, b.cbh)
+				blocks += cw * ch
+				if blocks > maxBlocks {
+					return errors.New("too many code-blocks")
+				}
+				b.precincts[pi] = newPrecinct(pr, b.cbw, b.cbh)
+			}
+		}
+	}
+	return nil
+}
+
+// blockGrid returns the number of code-block columns and rows covering r
+func blockGrid(r image.Rectangle, cbw, cbh uint) (int, int) {
+	if r.Empty() {
+		return 0, 0
+	}
+	var cw = (ceilDivPow2(r.Max.X, cbw)<<cbw - floorDivPow2(r.Min.X, cbw)<<cbw) >> cbw
+	var ch = (ceilDivPow2(r.Max.Y, cbh)<<cbh - floorDivPow2(r.Min.Y, cbh)<<cbh) >> cbh
+	return cw, ch
+}
+
+// newPrecinct partitions a precinct's area within a band into code-blocks
+func newPrecinct(r image.Rectangle, cbw, cbh uint) precinct {
+	var p precinct
+	if r.Empty() {
+		return p
+	}
+	var x0 = floorDivPow2(r.Min.X, cbw) << cbw
+	var y0 = floorDivPow2(r.Min.Y, cbh) << cbh
+	p.cw, p.ch = blockGrid(r, cbw, cbh)
+	p.blocks = make([]codeBlock, p.cw*p.ch)
+	for i := range p.blocks {
+		var bx = x0 + (i%p.cw)<<cbw
+		var by = y0 + (i/p.cw)<<cbh
+		p.blocks[i].rect = image.Rect(bx, by, bx+1<<cbw, by+1<<cbh).Intersect(r)
+	}
+	p.incl = newTagTree(p.cw, p.ch)
+	p.imsb = newTagTree(p.cw, p.ch)
+	return p
+}
+
+func pow2(e int) float32 {
+	var v = float32(1)
+	for ; e > 0; e-- {
+		v *= 2
+	}
+	for ; e < 0; e++ {
+		v /= 2
+	}
+	return v
+}
+
+func min(a, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// setWindow restricts decoding to the part of region, on the component's
+// reduced grid, which this tile covers.  It works out what must be
+// synthesized at each resolution, then marks the code-blocks covering it.
+func (tc *tileComp) setWindow(region image.Rectangle) {
+	var top = len(tc.res) - 1 - int(tc.reduce)
+	tc.window = region.Intersect(tc.res[top].rect)
+	if tc.window.Empty() {
+		tc.window = image.ZR
+		return
+	}
+
+	var w = tc.window
+	for r := top; r >= 0; r-- {
+		var res = &tc.res[r]
+		res.window = w
+		if r == 0 {
+			var b = &res.bands[0]
+			b.window = w
+			b.markNeeded()
+			break
+		}
+
+		var x = w.Inset(-windowMargin).Intersect(res.rect)
+		res.expanded = x
+		var lx0, lx1 = ceilDivPow2(x.Min.X, 1), ceilDivPow2(x.Max.X, 1)
+		var ly0, ly1 = ceilDivPow2(x.Min.Y, 1), ceilDivPow2(x.Max.Y, 1)
+		var hx0, hy0 = floorDivPow2(x.Min.X, 1), floorDivPow2(x.Min.Y, 1)
+		for bi := range res.bands {
+			var b = &res.bands[bi]
+			var bw = image.Rect(lx0, ly0, lx1, ly1)
+			if b.orient&1 != 0 {
+				bw.Min.X, bw.Max.X = hx0, lx1
+			}
+			if b.orient&2 != 0 {
+				bw.Min.Y, bw.Max.Y = hy0, ly1
+			}
+			b.window = bw.Intersect(b.rect)
+			b.markNeeded()
+		}
+		w = image.Rect(lx0, ly0, lx1, ly1).Intersect(tc.res[r-1].rect)
+	}
+}
+
+// markNeeded flags the band's code-blocks which overlap its window
+func (b *band) markNeeded() {
+	if b.window.Empty() {
+		return
+	}
+	for pi := range b.precincts {
+		var p = &b.precincts[pi]
+		for i := range p.blocks {
+			if p.blocks[i].rect.Overlaps(b.window) {
+				p.blocks[i].needed = true
+			}
+		}
+	}
+}
+
+// decode decodes the needed code-blocks, then inverts the wavelet and
+// component transforms, returning the samples of each decoded component's
+// window.  The first component alone is decoded if firstOnly is true.
+func (t *tile) decode(firstOnly bool) ([][]int32, error) {
+	var ncomps = len(t.comps)
+	if firstOnly {
+		ncomps = 1
+	}
+
+	var planes = make([]*plane, ncomps)
+	var t1 = &t1Decoder{}
+	for c := range planes {
+		var tc = &t.comps[c]
+		if tc.window.Empty() {
+			continue
+		}
+		var p, err = tc.reconstruct(t1)
+		if err != nil {
+			return nil, err
+		}
+		planes[c] = p
+	}
+
+	var mct = t.p.mct && ncomps >= 3 && planes[0] != nil
+	if mct {
+		for c := 1; c < 3; c++ {
+			if planes[c] == nil || planes[c].rect != planes[0].rect || t.comps[c].coding.reversible != t.comps[0].coding.reversible {
+				return nil, unsupported("component transform on mismatched components")
+			}
+		}
+		if t.comps[0].coding.reversible {
+			inverseRCT(planes[0].ints, planes[1].ints, planes[2].ints)
+		} else {
+			inverseICT(planes[0].flts, planes[1].flts, planes[2].flts)
+		}
+	}
+
+	var out = make([][]int32, ncomps)
+	for c, p := range planes {
+		if p != nil {
+			out[c] = levelShift(p, t.cs.comps[c])
+		}
+	}
+	return out, nil
+}
+
+// reconstruct decodes the tile-component's window
+func (tc *tileComp) reconstruct(t1 *t1Decoder) (*plane, error) {
+	var top = len(tc.res) - 1 - int(tc.reduce)
+	var reversible = tc.coding.reversible
+	var ll, err = tc.bandPlane(&tc.res[0].bands[0], t1)
+	if err != nil {
+		return nil, err
+	}
+	for r := 1; r <= top; r++ {
+		var res = &tc.res[r]
+		var hp [3]*plane
+		for i := range hp {
+			hp[i], err = tc.bandPlane(&res.bands[i], t1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ll = synthesize(ll, hp[0], hp[1], hp[2], res.expanded, res.window, reversible)
+	}
+	return ll, nil
+}
+
+// bandPlane decodes the band's code-blocks within its window, and
+// dequantizes them
+func (tc *tileComp) bandPlane(b *band, t1 *t1Decoder) (*plane, error) {
+	var reversible = tc.coding.reversible
+	var p = newPlane(b.window, reversible)
+	if b.window.Empty() {
+		return p, nil
+	}
+
+	var scale = b.step / 2
+	for pi := range b.precincts {
+		var pr = &b.precincts[pi]
+		for i := range pr.blocks {
+			var cb = &pr.blocks[i]
+			if !cb.needed || len(cb.data) == 0 {
+				continue
+			}
+			var data, err = t1.decodeBlock(cb, b.orient, tc.coding.style, tc.roi)
+			if err != nil {
+				return nil, err
+			}
+
+			var r = cb.rect.Intersect(b.window)
+			var w = cb.rect.Dx()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				var src = data[(y-cb.rect.Min.Y)*w+r.Min.X-cb.rect.Min.X:][:r.Dx()]
+				var dst = p.offset(r.Min.X, y)
+				for x, v := range src {
+					if reversible {
+						p.ints[dst+x] = v / 2
+					} else {
+						p.flts[dst+x] = float32(v) * scale
+					}
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// inverseRCT applies the reversible component transform
+func inverseRCT(y, cb, cr []int32) {
+	for i := range y {
+		var g = y[i] - (cb[i]+cr[i])>>2
+		y[i], cb[i], cr[i] = cr[i]+g, g, cb[i]+g
+	}
+}
+
+// inverseICT applies the irreversible component transform
+func inverseICT(y, cb, cr []float32) {
+	for i := range y {
+		var yy, u, v = y[i], cb[i], cr[i]
+		y[i] = yy + 1.402*v
+		cb[i] = yy - 0.34413*u - 0.71414*v
+		cr[i] = yy + 1.772*u
+	}
+}
+
+// levelShift undoes the DC level shift of unsigned components, and clamps
+// samples to the component's range
+func levelShift(p *plane, comp component) []int32 {
+	var lo, hi = int32(0), int32(1)<<comp.prec - 1
+	var shift = int32(1) << (comp.prec - 1)
+	if comp.signed {
+		lo, hi, shift = -shift, shift-1, 0
+	}
+
+	if p.ints == nil {
+		var out = make([]int32, len(p.flts))
+		for i, v := range p.flts {
+			var f = math.RoundToEven(float64(v)) + float64(shift)
+			if !(f >= float64(lo)) {
+				f = float64(lo)
+			} else if f > float64(hi) {
+				f = float64(hi)
+			}
+			out[i] = int32(f)
+		}
+		return out
+	}
+
+	var out = p.ints
+	for i, v := range out {
+		v += shift
+		if v < lo {
+			v = lo
+		} else if v > hi {
+			v = hi
+		}
+		out[i] = v
+	}
+	return out
+}
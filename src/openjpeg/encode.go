@@ -0,0 +1,154 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package openjpeg
+
+// #cgo pkg-config: libopenjp2
+// #include <openjpeg.h>
+// #include <stdlib.h>
+// #include "handlers.h"
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"unsafe"
+)
+
+// encodeTileSize is the tile size used for encoded images too large to be a
+// single tile
+const encodeTileSize = 1024
+
+// Encode writes img to w as a losslessly compressed JP2.  Gray images are
+// encoded with a single component, and everything else as RGB; alpha is
+// dropped.  16-bit gray and RGBA64 images keep 16 bits per sample.  Images larger than encodeTileSize are tiled, and all images use
+// RPCL progression so RAIS can serve them efficiently.
+func Encode(w io.Writer, img image.Image) error {
+	var b = img.Bounds()
+	if b.Empty() {
+		return fmt.Errorf("cannot encode an empty image")
+	}
+	var width, height = b.Dx(), b.Dy()
+
+	var numcomps = 3
+	var colorSpace = C.OPJ_CLRSPC_SRGB
+	var prec = 8
+	switch img.ColorModel() {
+	case color.GrayModel:
+		numcomps, colorSpace = 1, C.OPJ_CLRSPC_GRAY
+	case color.Gray16Model:
+		numcomps, colorSpace, prec = 1, C.OPJ_CLRSPC_GRAY, 16
+	case color.RGBA64Model:
+		prec = 16
+	}
+
+	var cparams = make([]C.opj_image_cmptparm_t, numcomps)
+	for i := range cparams {
+		cparams[i].dx, cparams[i].dy = 1, 1
+		cparams[i].w, cparams[i].h = C.OPJ_UINT32(width), C.OPJ_UINT32(height)
+		cparams[i].prec = C.OPJ_UINT32(prec)
+	}
+	var jp2 = C.opj_image_create(C.OPJ_UINT32(numcomps), &cparams[0], C.OPJ_COLOR_SPACE(colorSpace))
+	if jp2 == nil {
+		return fmt.Errorf("unable to allocate image")
+	}
+	defer C.opj_image_destroy(jp2)
+	jp2.x0, jp2.y0 = 0, 0
+	jp2.x1, jp2.y1 = C.OPJ_UINT32(width), C.OPJ_UINT32(height)
+	fillComponents(jp2, img)
+
+	var parameters C.opj_cparameters_t
+	C.opj_set_default_encoder_parameters(&parameters)
+	parameters.tcp_numlayers = 1
+	parameters.tcp_rates[0] = 0
+	parameters.cp_disto_alloc = 1
+	parameters.prog_order = C.OPJ_RPCL
+	if numcomps == 3 {
+		parameters.tcp_mct = 1
+	}
+	var tw, th = width, height
+	if width > encodeTileSize || height > encodeTileSize {
+		parameters.tile_size_on = C.OPJ_TRUE
+		parameters.cp_tdx, parameters.cp_tdy = encodeTileSize, encodeTileSize
+		tw, th = min(width, encodeTileSize), min(height, encodeTileSize)
+	}
+	parameters.numresolution = C.int(resolutionLevels(tw, th))
+
+	var codec = C.opj_create_compress(C.OPJ_CODEC_JP2)
+	defer C.opj_destroy_codec(codec)
+	C.set_handlers(codec)
+	if C.opj_setup_encoder(codec, &parameters, jp2) == C.OPJ_FALSE {
+		return fmt.Errorf("unable to setup encoder")
+	}
+
+	// OpenJPEG needs a seekable stream, so we encode to a temporary file
+	var f, err = ioutil.TempFile("", "rais-encode-*.jp2")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var cFilename = C.CString(f.Name())
+	defer C.free(unsafe.Pointer(cFilename))
+	var stream = C.opj_stream_create_default_file_stream(cFilename, C.OPJ_FALSE)
+	if stream == nil {
+		return fmt.Errorf("failed to create stream in %#v", f.Name())
+	}
+	var ok = C.opj_start_compress(codec, jp2, stream) != C.OPJ_FALSE &&
+		C.opj_encode(codec, stream) != C.OPJ_FALSE &&
+		C.opj_end_compress(codec, stream) != C.OPJ_FALSE
+	// Destroying the stream flushes and closes the file
+	C.opj_stream_destroy(stream)
+	if !ok {
+		return fmt.Errorf("failed to encode image")
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// fillComponents copies the image's pixels into the JP2 image's components
+func fillComponents(jp2 *C.opj_image_t, img image.Image) {
+	var comps []C.opj_image_comp_t
+	compsSlice := (*reflect.SliceHeader)((unsafe.Pointer(&comps)))
+	compsSlice.Cap = int(jp2.numcomps)
+	compsSlice.Len = int(jp2.numcomps)
+	compsSlice.Data = uintptr(unsafe.Pointer(jp2.comps))
+
+	var data = make([][]int32, len(comps))
+	for i, comp := range comps {
+		dataSlice := (*reflect.SliceHeader)((unsafe.Pointer(&data[i])))
+		dataSlice.Cap = int(comp.w) * int(comp.h)
+		dataSlice.Len = int(comp.w) * int(comp.h)
+		dataSlice.Data = uintptr(unsafe.Pointer(comp.data))
+	}
+
+	var shift uint = 8
+	if comps[0].prec == 16 {
+		shift = 0
+	}
+	var b = img.Bounds()
+	var n int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if len(data) == 1 {
+				data[0][n] = int32(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y >> shift)
+			} else {
+				var r, g, bl, _ = img.At(x, y).RGBA()
+				data[0][n], data[1][n], data[2][n] = int32(r>>shift), int32(g>>shift), int32(bl>>shift)
+			}
+			n++
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package openjpeg
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// ErrEncodeNotBuilt is returned by Encode when RAIS is built without openjpeg
+var ErrEncodeNotBuilt = errors.New("JP2 encoding requires openjpeg, which this build doesn't include")
+
+// Encode would write img to w as a JP2, but the pure-Go decoder has no
+// encoder counterpart
+func Encode(w io.Writer, img image.Image) error {
+	return ErrEncodeNotBuilt
+}
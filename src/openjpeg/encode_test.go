@@ -0,0 +1,79 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package openjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(0, 0, 96, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 96; x++ {
+			src.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 6), 200, 255})
+		}
+	}
+
+	var buf = bytes.NewBuffer(nil)
+	assert.NilError(Encode(buf, src), "encoding", t)
+	var f, _ = ioutil.TempFile("", "rais-encode-test-*.jp2")
+	defer os.Remove(f.Name())
+	f.Write(buf.Bytes())
+	f.Close()
+
+	var jp2, err = NewJP2Image(f.Name())
+	assert.NilError(err, "reading the encoded JP2", t)
+	assert.Equal(96, jp2.GetWidth(), "width", t)
+	assert.Equal(40, jp2.GetHeight(), "height", t)
+
+	jp2.SetCrop(image.Rect(0, 0, 96, 40))
+	jp2.SetResizeWH(96, 40)
+	var out image.Image
+	out, err = jp2.DecodeImage()
+	assert.NilError(err, "decoding the encoded JP2", t)
+	assert.Equal(src.At(50, 30), color.RGBAModel.Convert(out.At(50, 30)), "lossless pixel data", t)
+}
+
+func TestEncodeDeepRoundTrip(t *testing.T) {
+	var src = image.NewGray16(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			src.SetGray16(x, y, color.Gray16{uint16(x*1021 + y*7)})
+		}
+	}
+
+	var buf = bytes.NewBuffer(nil)
+	assert.NilError(Encode(buf, src), "encoding", t)
+	var f, _ = ioutil.TempFile("", "rais-encode-test-*.jp2")
+	defer os.Remove(f.Name())
+	f.Write(buf.Bytes())
+	f.Close()
+
+	var jp2, err = NewJP2Image(f.Name())
+	assert.NilError(err, "reading the encoded JP2", t)
+	jp2.SetDeep(true)
+	var out image.Image
+	out, err = jp2.DecodeImage()
+	assert.NilError(err, "decoding the encoded JP2", t)
+	assert.Equal(src.At(50, 30), out.At(50, 30), "16-bit samples survive", t)
+
+	jp2.SetDeep(false)
+	out, err = jp2.DecodeImage()
+	assert.NilError(err, "decoding the encoded JP2", t)
+	var want = uint8(src.Gray16At(50, 30).Y >> 8)
+	assert.Equal(color.Gray{want}, out.At(50, 30), "8-bit output keeps the high bits", t)
+}
+
+func TestResolutionLevels(t *testing.T) {
+	assert.Equal(6, resolutionLevels(1024, 1024), "large tiles get every level", t)
+	assert.Equal(4, resolutionLevels(300, 8), "small dimensions limit levels", t)
+	assert.Equal(1, resolutionLevels(1, 1), "a single pixel has one level", t)
+}
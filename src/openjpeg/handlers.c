@@ -1,4 +1,8 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 #include <stdio.h>
+#include <stdint.h>
 #include <openjpeg.h>
 #include "handlers.h"
 #include "_cgo_export.h"
@@ -15,3 +19,28 @@ void set_handlers(opj_codec_t* p_codec) {
 	opj_set_warning_handler(p_codec, warning_callback, 00);
 	opj_set_error_handler(p_codec, error_callback, 00);
 }
+
+static OPJ_SIZE_T stream_read(void *buf, OPJ_SIZE_T n, void *data) {
+	return goStreamRead(buf, n, (uintptr_t)data);
+}
+
+static OPJ_OFF_T stream_skip(OPJ_OFF_T n, void *data) {
+	return goStreamSkip(n, (uintptr_t)data);
+}
+
+static OPJ_BOOL stream_seek(OPJ_OFF_T n, void *data) {
+	return goStreamSeek(n, (uintptr_t)data);
+}
+
+opj_stream_t* new_reader_stream(uintptr_t handle, OPJ_UINT64 length) {
+	opj_stream_t *stream = opj_stream_create(OPJ_J2K_STREAM_CHUNK_SIZE, OPJ_TRUE);
+	if (!stream) {
+		return NULL;
+	}
+	opj_stream_set_user_data(stream, (void *)handle, NULL);
+	opj_stream_set_user_data_length(stream, length);
+	opj_stream_set_read_function(stream, stream_read);
+	opj_stream_set_skip_function(stream, stream_skip);
+	opj_stream_set_seek_function(stream, stream_seek);
+	return stream;
+}
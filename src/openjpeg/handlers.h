@@ -1,5 +1,7 @@
 #include <stdio.h>
+#include <stdint.h>
 #include <openjpeg.h>
 
 extern void set_handlers(opj_codec_t * p_codec);
+extern opj_stream_t* new_reader_stream(uintptr_t handle, OPJ_UINT64 length);
 extern void GoLog(int level, char *message);
@@ -0,0 +1,73 @@
+package openjpeg
+
+import (
+	"io"
+	"rais/src/jp2info"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// HeaderCacheTTL is how long a JP2's parsed header is reused before the file
+// is scanned again.  This lets an info request and the tile requests which
+// follow it (or several concurrent tile requests) share a single header
+// parse.  Zero disables the cache.
+var HeaderCacheTTL time.Duration
+
+// headerCacheSize is the number of parsed headers kept at once
+const headerCacheSize = 1024
+
+var headerCache, _ = lru.New(headerCacheSize)
+
+// cachedHeader holds a parsed header and when it stops being valid
+type cachedHeader struct {
+	info    *jp2info.Info
+	expires time.Time
+}
+
+// scanHeader returns the parsed header for filename, from the cache if a
+// fresh copy is available.  The returned structure is shared and must not be
+// modified.
+func scanHeader(filename string) (*jp2info.Info, error) {
+	return cachedScan(filename, func() (*jp2info.Info, error) {
+		return new(jp2info.Scanner).Scan(filename)
+	})
+}
+
+// scanReaderHeader is like scanHeader, but for image data read from r.  The
+// key identifies the data in the cache.
+func scanReaderHeader(key string, r ReaderAtSizer) (*jp2info.Info, error) {
+	return cachedScan(key, func() (*jp2info.Info, error) {
+		return new(jp2info.Scanner).ScanReader(io.NewSectionReader(r, 0, r.Size()))
+	})
+}
+
+// cachedScan returns the cached header for key, calling scan if there isn't
+// a fresh copy
+func cachedScan(key string, scan func() (*jp2info.Info, error)) (*jp2info.Info, error) {
+	if HeaderCacheTTL <= 0 {
+		return scan()
+	}
+
+	var now = time.Now()
+	if v, ok := headerCache.Get(key); ok {
+		var ch = v.(*cachedHeader)
+		if now.Before(ch.expires) {
+			return ch.info, nil
+		}
+		headerCache.Remove(key)
+	}
+
+	var info, err = scan()
+	if err != nil {
+		return nil, err
+	}
+	headerCache.Add(key, &cachedHeader{info: info, expires: now.Add(HeaderCacheTTL)})
+	return info, nil
+}
+
+// PurgeHeaderCache removes all cached headers, forcing the next request for
+// any image to re-read its header
+func PurgeHeaderCache() {
+	headerCache.Purge()
+}
@@ -0,0 +1,40 @@
+package openjpeg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestScanHeaderCache(t *testing.T) {
+	var dir, _ = ioutil.TempDir("", "rais-header-")
+	defer os.RemoveAll(dir)
+	var src, _ = ioutil.ReadFile("../../docker/images/testfile/test-world.jp2")
+	var fp = filepath.Join(dir, "test.jp2")
+	ioutil.WriteFile(fp, src, 0644)
+
+	defer func(ttl time.Duration) { HeaderCacheTTL = ttl }(HeaderCacheTTL)
+	HeaderCacheTTL = 50 * time.Millisecond
+
+	var first, err = scanHeader(fp)
+	assert.NilError(err, "scanning header", t)
+	assert.Equal(uint32(800), first.Width, "width", t)
+
+	// Once cached, the file isn't read again until the TTL passes
+	os.Remove(fp)
+	var second, _ = scanHeader(fp)
+	assert.True(first == second, "cached header is reused", t)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = scanHeader(fp)
+	assert.True(err != nil, "expired header is rescanned", t)
+
+	HeaderCacheTTL = 0
+	headerCache.Purge()
+	_, err = scanHeader(fp)
+	assert.True(err != nil, "no caching without a TTL", t)
+}
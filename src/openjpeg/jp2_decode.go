@@ -0,0 +1,227 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package openjpeg
+
+// #cgo pkg-config: libopenjp2
+// #include <openjpeg.h>
+import "C"
+
+import (
+	"image"
+	"reflect"
+	"unsafe"
+
+	"github.com/nfnt/resize"
+)
+
+// Linked is true when openjpeg does the decoding and encoding
+const Linked = true
+
+// DecodeImage returns an image.Image that holds the decoded image data,
+// resized and cropped if resizing or cropping was requested.  Both cropping
+// and resizing happen here due to the nature of openjpeg, so SetScale,
+// SetResizeWH, and SetCrop must be called before this function.
+func (i *JP2Image) DecodeImage() (img image.Image, err error) {
+	i.computeDecodeParameters()
+	var level = i.computeProgressionLevel()
+
+	// Requests made of whole tiles decode each tile directly when the
+	// codestream says where each tile is
+	var tiles, ok = i.areaTiles()
+	switch {
+	case ok && len(tiles) == 1:
+		img, err = i.decode(i.decodeArea, level, tiles[0], true)
+	case ok:
+		img, err = i.decodeTiles(tiles, level)
+	default:
+		img, err = i.decode(i.decodeArea, level, 0, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy() {
+		img = resize.Resize(uint(i.decodeWidth), uint(i.decodeHeight), img, resize.Bilinear)
+	}
+
+	return img, nil
+}
+
+// decodeTiles decodes each of the given tiles at the given progression level
+// and stitches them into a single image of the decode area
+func (i *JP2Image) decodeTiles(tiles []int, level int) (image.Image, error) {
+	Logger.Debugf("Decoding %d tiles directly", len(tiles))
+	var rects = make([]image.Rectangle, len(tiles))
+	var imgs = make([]image.Image, len(tiles))
+	for n, tile := range tiles {
+		rects[n] = i.tileRect(tile)
+		var img, err = i.decode(rects[n], level, tile, true)
+		if err != nil {
+			return nil, err
+		}
+		imgs[n] = img
+	}
+	return stitchTiles(i.decodeArea, level, rects, imgs), nil
+}
+
+// decode returns the given area of the image, decoded at the given
+// progression level.  If single is true, the area must be exactly the given
+// tile, which is decoded directly.
+func (i *JP2Image) decode(area image.Rectangle, level, tile int, single bool) (img image.Image, err error) {
+	var jp2 *C.opj_image_t
+	jp2, err = i.rawDecode(area, level, tile, single)
+	// We have to clean up the jp2 memory even if we had an error due to how the
+	// openjpeg APIs work
+	defer C.opj_image_destroy(jp2)
+	if err != nil {
+		return nil, err
+	}
+
+	var comps []C.opj_image_comp_t
+	compsSlice := (*reflect.SliceHeader)((unsafe.Pointer(&comps)))
+	compsSlice.Cap = int(jp2.numcomps)
+	compsSlice.Len = int(jp2.numcomps)
+	compsSlice.Data = uintptr(unsafe.Pointer(jp2.comps))
+
+	width := int(comps[0].w)
+	height := int(comps[0].h)
+	bounds := image.Rect(0, 0, width, height)
+
+	// We assume grayscale if we don't have at least 3 components, because it's
+	// probably the safest default.  If only luma was decoded, the first
+	// component is all we need.
+	if i.deep && comps[0].prec > 8 {
+		img = i.deepImage(comps, bounds)
+	} else if len(comps) < 3 || i.lumaOnly() {
+		img = &image.Gray{Pix: JP2ComponentData(comps[0]), Stride: width, Rect: bounds}
+	} else if i.gray {
+		// Reduce color to gray before resizing so we only resize one channel
+		img = &image.Gray{Pix: lumaData(comps[0], comps[1], comps[2]), Stride: width, Rect: bounds}
+	} else {
+		// If we have 3+ components, we only care about the first three - I have no
+		// idea what else we might have other than alpha, and as a tile server, we
+		// don't care about the *source* image's alpha.  It's worth noting that
+		// this will almost certainly blow up on any JP2 that isn't using RGB.
+
+		area := width * height
+		bytes := area << 2
+		realData := make([]uint8, bytes)
+
+		red := JP2ComponentData(comps[0])
+		green := JP2ComponentData(comps[1])
+		blue := JP2ComponentData(comps[2])
+
+		offset := 0
+		for i := 0; i < area; i++ {
+			realData[offset] = red[i]
+			offset++
+			realData[offset] = green[i]
+			offset++
+			realData[offset] = blue[i]
+			offset++
+			realData[offset] = 255
+			offset++
+		}
+
+		img = &image.RGBA{Pix: realData, Stride: width << 2, Rect: bounds}
+	}
+
+	return img, nil
+}
+
+// deepImage returns a 16-bit gray or RGB image built from the components
+func (i *JP2Image) deepImage(comps []C.opj_image_comp_t, bounds image.Rectangle) image.Image {
+	var width = bounds.Dx()
+	if len(comps) < 3 || i.lumaOnly() {
+		return &image.Gray16{Pix: componentData16(comps[0]), Stride: width << 1, Rect: bounds}
+	}
+
+	var red, green, blue = componentData16(comps[0]), componentData16(comps[1]), componentData16(comps[2])
+	if i.gray {
+		for n := 0; n < len(red); n += 2 {
+			var r = uint64(red[n])<<8 | uint64(red[n+1])
+			var g = uint64(green[n])<<8 | uint64(green[n+1])
+			var b = uint64(blue[n])<<8 | uint64(blue[n+1])
+			var y = (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+			red[n], red[n+1] = uint8(y>>8), uint8(y)
+		}
+		return &image.Gray16{Pix: red, Stride: width << 1, Rect: bounds}
+	}
+
+	var realData = make([]uint8, len(red)<<2)
+	for n, offset := 0, 0; n < len(red); n, offset = n+2, offset+8 {
+		copy(realData[offset:], red[n:n+2])
+		copy(realData[offset+2:], green[n:n+2])
+		copy(realData[offset+4:], blue[n:n+2])
+		realData[offset+6], realData[offset+7] = 255, 255
+	}
+	return &image.RGBA64{Pix: realData, Stride: width << 3, Rect: bounds}
+}
+
+// lumaData returns the 8-bit luma for each pixel of the given RGB components,
+// using the same weights as Go's color.GrayModel
+func lumaData(r, g, b C.struct_opj_image_comp) []uint8 {
+	var red, green, blue = JP2ComponentData(r), JP2ComponentData(g), JP2ComponentData(b)
+	var realData = make([]uint8, len(red))
+	for i := range realData {
+		var y = 19595*uint32(red[i]) + 38470*uint32(green[i]) + 7471*uint32(blue[i]) + 1<<15
+		realData[i] = uint8(y >> 16)
+	}
+	return realData
+}
+
+// JP2ComponentData returns a slice of Image-usable uint8s from the JP2 raw
+// data in the given component struct
+func JP2ComponentData(comp C.struct_opj_image_comp) []uint8 {
+	var data []int32
+	dataSlice := (*reflect.SliceHeader)((unsafe.Pointer(&data)))
+	size := int(comp.w) * int(comp.h)
+	dataSlice.Cap = size
+	dataSlice.Len = size
+	dataSlice.Data = uintptr(unsafe.Pointer(comp.data))
+
+	// Higher-precision samples are reduced to their top eight bits
+	var shift uint
+	if comp.prec > 8 {
+		shift = uint(comp.prec) - 8
+	}
+	realData := make([]uint8, len(data))
+	for index, point := range data {
+		realData[index] = uint8(point >> shift)
+	}
+
+	return realData
+}
+
+// componentData16 returns the component's samples scaled to 16 bits, in the
+// big-endian order image.Gray16 and image.RGBA64 use.  Signed samples are
+// offset so their minimum is zero.
+func componentData16(comp C.struct_opj_image_comp) []uint8 {
+	var data []int32
+	dataSlice := (*reflect.SliceHeader)((unsafe.Pointer(&data)))
+	size := int(comp.w) * int(comp.h)
+	dataSlice.Cap = size
+	dataSlice.Len = size
+	dataSlice.Data = uintptr(unsafe.Pointer(comp.data))
+
+	var prec = uint(comp.prec)
+	var max = int64(1)<<prec - 1
+	var offset int64
+	if comp.sgnd != 0 {
+		offset = int64(1) << (prec - 1)
+	}
+	realData := make([]uint8, len(data)<<1)
+	for index, point := range data {
+		var v = int64(point) + offset
+		if v < 0 {
+			v = 0
+		} else if v > max {
+			v = max
+		}
+		var v16 = uint16(v * 0xffff / max)
+		realData[index<<1], realData[index<<1+1] = uint8(v16>>8), uint8(v16)
+	}
+
+	return realData
+}
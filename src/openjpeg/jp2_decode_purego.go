@@ -0,0 +1,176 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package openjpeg
+
+import (
+	"errors"
+	"image"
+	"os"
+	"rais/src/jpeg2000"
+
+	"github.com/nfnt/resize"
+)
+
+// Linked is false when RAIS is built without cgo or with the "purego" tag
+const Linked = false
+
+// DecodeImage returns an image.Image that holds the decoded image data,
+// resized and cropped if resizing or cropping was requested.  This build
+// doesn't link openjpeg, so images are decoded by the much slower pure-Go
+// jpeg2000 package.
+func (i *JP2Image) DecodeImage() (img image.Image, err error) {
+	i.computeDecodeParameters()
+
+	var r = i.reader
+	if r == nil {
+		var f *os.File
+		f, err = os.Open(i.filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		r = &fileSource{f, fi.Size()}
+	}
+
+	var d *jpeg2000.Decoder
+	d, err = jpeg2000.NewDecoder(r, r.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var level = i.computeProgressionLevel()
+	if level > d.Levels() {
+		level = d.Levels()
+	}
+	var comps []jpeg2000.Component
+	comps, err = d.Decode(jpeg2000.Options{Area: i.decodeArea, Reduce: level, FirstComponentOnly: i.lumaOnly()})
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike openjpeg, we don't upsample subsampled components, so we can only
+	// combine components which are the same size
+	var width, height = comps[0].Width, comps[0].Height
+	for _, c := range comps[1:] {
+		if c.Width != width || c.Height != height {
+			return nil, errors.New("subsampled components are not supported without openjpeg")
+		}
+	}
+	var bounds = image.Rect(0, 0, width, height)
+
+	// As with openjpeg, we assume grayscale if we don't have at least 3
+	// components, and ignore anything past the third
+	if i.deep && comps[0].Prec > 8 {
+		img = i.deepImage(comps, bounds)
+	} else if len(comps) < 3 || i.lumaOnly() {
+		img = &image.Gray{Pix: componentData(comps[0]), Stride: width, Rect: bounds}
+	} else if i.gray {
+		var red, green, blue = componentData(comps[0]), componentData(comps[1]), componentData(comps[2])
+		for n := range red {
+			var y = 19595*uint32(red[n]) + 38470*uint32(green[n]) + 7471*uint32(blue[n]) + 1<<15
+			red[n] = uint8(y >> 16)
+		}
+		img = &image.Gray{Pix: red, Stride: width, Rect: bounds}
+	} else {
+		var red, green, blue = componentData(comps[0]), componentData(comps[1]), componentData(comps[2])
+		var realData = make([]uint8, len(red)<<2)
+		for n, offset := 0, 0; n < len(red); n, offset = n+1, offset+4 {
+			realData[offset] = red[n]
+			realData[offset+1] = green[n]
+			realData[offset+2] = blue[n]
+			realData[offset+3] = 255
+		}
+		img = &image.RGBA{Pix: realData, Stride: width << 2, Rect: bounds}
+	}
+
+	if i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy() {
+		img = resize.Resize(uint(i.decodeWidth), uint(i.decodeHeight), img, resize.Bilinear)
+	}
+
+	return img, nil
+}
+
+// fileSource adapts an open file to ReaderAtSizer
+type fileSource struct {
+	*os.File
+	size int64
+}
+
+// Size returns the file's size when it was opened
+func (f *fileSource) Size() int64 {
+	return f.size
+}
+
+// deepImage returns a 16-bit gray or RGB image built from the components
+func (i *JP2Image) deepImage(comps []jpeg2000.Component, bounds image.Rectangle) image.Image {
+	var width = bounds.Dx()
+	if len(comps) < 3 || i.lumaOnly() {
+		return &image.Gray16{Pix: componentData16(comps[0]), Stride: width << 1, Rect: bounds}
+	}
+
+	var red, green, blue = componentData16(comps[0]), componentData16(comps[1]), componentData16(comps[2])
+	if i.gray {
+		for n := 0; n < len(red); n += 2 {
+			var r = uint64(red[n])<<8 | uint64(red[n+1])
+			var g = uint64(green[n])<<8 | uint64(green[n+1])
+			var b = uint64(blue[n])<<8 | uint64(blue[n+1])
+			var y = (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+			red[n], red[n+1] = uint8(y>>8), uint8(y)
+		}
+		return &image.Gray16{Pix: red, Stride: width << 1, Rect: bounds}
+	}
+
+	var realData = make([]uint8, len(red)<<2)
+	for n, offset := 0, 0; n < len(red); n, offset = n+2, offset+8 {
+		copy(realData[offset:], red[n:n+2])
+		copy(realData[offset+2:], green[n:n+2])
+		copy(realData[offset+4:], blue[n:n+2])
+		realData[offset+6], realData[offset+7] = 255, 255
+	}
+	return &image.RGBA64{Pix: realData, Stride: width << 3, Rect: bounds}
+}
+
+// componentData returns the component's samples as uint8s.  Higher-precision
+// samples are reduced to their top eight bits.
+func componentData(c jpeg2000.Component) []uint8 {
+	var shift uint
+	if c.Prec > 8 {
+		shift = uint(c.Prec) - 8
+	}
+	var realData = make([]uint8, len(c.Data))
+	for index, point := range c.Data {
+		realData[index] = uint8(point >> shift)
+	}
+	return realData
+}
+
+// componentData16 returns the component's samples scaled to 16 bits, in the
+// big-endian order image.Gray16 and image.RGBA64 use.  Signed samples are
+// offset so their minimum is zero.
+func componentData16(c jpeg2000.Component) []uint8 {
+	var prec = uint(c.Prec)
+	var max = int64(1)<<prec - 1
+	var offset int64
+	if c.Signed {
+		offset = int64(1) << (prec - 1)
+	}
+	var realData = make([]uint8, len(c.Data)<<1)
+	for index, point := range c.Data {
+		var v = int64(point) + offset
+		if v < 0 {
+			v = 0
+		} else if v > max {
+			v = max
+		}
+		var v16 = uint16(v * 0xffff / max)
+		realData[index<<1], realData[index<<1+1] = uint8(v16>>8), uint8(v16)
+	}
+	return realData
+}
@@ -1,26 +1,28 @@
 package openjpeg
 
-// #cgo pkg-config: libopenjp2
-// #include <openjpeg.h>
-import "C"
-
 import (
 	"image"
 	"rais/src/jp2info"
-	"reflect"
-	"unsafe"
 
-	"github.com/nfnt/resize"
+	"github.com/uoregon-libraries/gopkg/logger"
 )
 
+// Logger defaults to use a default implementation of the uoregon-libraries
+// logging mechanism, but can be overridden (as is the case with the main RAIS
+// command)
+var Logger = logger.Named("rais/openjpeg", logger.Debug)
+
 // JP2Image is a container for our simple JP2 operations
 type JP2Image struct {
 	filename     string
+	reader       ReaderAtSizer
 	info         *jp2info.Info
 	decodeWidth  int
 	decodeHeight int
 	decodeArea   image.Rectangle
 	srcRect      image.Rectangle
+	gray         bool
+	deep         bool
 }
 
 // NewJP2Image reads basic information about a file and returns a decode-ready
@@ -35,9 +37,26 @@ func NewJP2Image(filename string) (*JP2Image, error) {
 	return i, nil
 }
 
+// NewJP2ImageReader is like NewJP2Image, but the image data is read from r
+// rather than a file.  The key identifies the data for header caching, and
+// must change if the data does.
+func NewJP2ImageReader(key string, r ReaderAtSizer) (*JP2Image, error) {
+	i := &JP2Image{filename: key, reader: r}
+
+	if err := i.readInfo(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
 func (i *JP2Image) readInfo() error {
 	var err error
-	i.info, err = new(jp2info.Scanner).Scan(i.filename)
+	if i.reader != nil {
+		i.info, err = scanReaderHeader(i.filename, i.reader)
+	} else {
+		i.info, err = scanHeader(i.filename)
+	}
 	return err
 }
 
@@ -49,75 +68,22 @@ func (i *JP2Image) SetResizeWH(width, height int) {
 	i.decodeHeight = height
 }
 
-// SetCrop sets the image crop area for decoding an image
-func (i *JP2Image) SetCrop(r image.Rectangle) {
-	i.decodeArea = r
+// SetGray requests grayscale output.  When the image stores luma as its first
+// component, only that component is decoded; otherwise color data is reduced
+// to gray before any resizing.
+func (i *JP2Image) SetGray(gray bool) {
+	i.gray = gray
 }
 
-// DecodeImage returns an image.Image that holds the decoded image data,
-// resized and cropped if resizing or cropping was requested.  Both cropping
-// and resizing happen here due to the nature of openjpeg, so SetScale,
-// SetResizeWH, and SetCrop must be called before this function.
-func (i *JP2Image) DecodeImage() (img image.Image, err error) {
-	i.computeDecodeParameters()
-
-	var jp2 *C.opj_image_t
-	jp2, err = i.rawDecode()
-	// We have to clean up the jp2 memory even if we had an error due to how the
-	// openjpeg APIs work
-	defer C.opj_image_destroy(jp2)
-	if err != nil {
-		return nil, err
-	}
-
-	var comps []C.opj_image_comp_t
-	compsSlice := (*reflect.SliceHeader)((unsafe.Pointer(&comps)))
-	compsSlice.Cap = int(jp2.numcomps)
-	compsSlice.Len = int(jp2.numcomps)
-	compsSlice.Data = uintptr(unsafe.Pointer(jp2.comps))
-
-	width := int(comps[0].w)
-	height := int(comps[0].h)
-	bounds := image.Rect(0, 0, width, height)
-
-	// We assume grayscale if we don't have at least 3 components, because it's
-	// probably the safest default
-	if len(comps) < 3 {
-		img = &image.Gray{Pix: JP2ComponentData(comps[0]), Stride: width, Rect: bounds}
-	} else {
-		// If we have 3+ components, we only care about the first three - I have no
-		// idea what else we might have other than alpha, and as a tile server, we
-		// don't care about the *source* image's alpha.  It's worth noting that
-		// this will almost certainly blow up on any JP2 that isn't using RGB.
-
-		area := width * height
-		bytes := area << 2
-		realData := make([]uint8, bytes)
-
-		red := JP2ComponentData(comps[0])
-		green := JP2ComponentData(comps[1])
-		blue := JP2ComponentData(comps[2])
-
-		offset := 0
-		for i := 0; i < area; i++ {
-			realData[offset] = red[i]
-			offset++
-			realData[offset] = green[i]
-			offset++
-			realData[offset] = blue[i]
-			offset++
-			realData[offset] = 255
-			offset++
-		}
-
-		img = &image.RGBA{Pix: realData, Stride: width << 2, Rect: bounds}
-	}
-
-	if i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy() {
-		img = resize.Resize(uint(i.decodeWidth), uint(i.decodeHeight), img, resize.Bilinear)
-	}
+// SetDeep requests 16-bit output when the image has more than eight bits per
+// sample.  Images with eight or fewer bits decode as usual.
+func (i *JP2Image) SetDeep(deep bool) {
+	i.deep = deep
+}
 
-	return img, nil
+// SetCrop sets the image crop area for decoding an image
+func (i *JP2Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
 }
 
 // GetWidth returns the image width
@@ -140,6 +106,12 @@ func (i *JP2Image) GetTileHeight() int {
 	return int(i.info.TileHeight())
 }
 
+// DPI returns the resolution recorded in the JP2 header, or zero if there
+// isn't one
+func (i *JP2Image) DPI() float64 {
+	return i.info.DPI()
+}
+
 // GetLevels returns the number of resolution levels
 func (i *JP2Image) GetLevels() int {
 	return int(i.info.Levels)
@@ -174,20 +146,8 @@ func (i *JP2Image) computeProgressionLevel() int {
 	return level
 }
 
-// JP2ComponentData returns a slice of Image-usable uint8s from the JP2 raw
-// data in the given component struct
-func JP2ComponentData(comp C.struct_opj_image_comp) []uint8 {
-	var data []int32
-	dataSlice := (*reflect.SliceHeader)((unsafe.Pointer(&data)))
-	size := int(comp.w) * int(comp.h)
-	dataSlice.Cap = size
-	dataSlice.Len = size
-	dataSlice.Data = uintptr(unsafe.Pointer(comp.data))
-
-	realData := make([]uint8, len(data))
-	for index, point := range data {
-		realData[index] = uint8(point)
-	}
-
-	return realData
+// lumaOnly returns true if grayscale output was requested and the first
+// component can be decoded on its own to get it
+func (i *JP2Image) lumaOnly() bool {
+	return i.gray && i.info.LumaComponent()
 }
@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 package openjpeg
 
 // #cgo pkg-config: libopenjp2
@@ -8,28 +11,43 @@
 
 import (
 	"fmt"
+	"image"
+	"io"
+	"os"
+	"rais/src/jp2info"
 	"unsafe"
 )
 
 // rawDecode runs the low-level operations necessary to actually get the
-// desired tile/resized image
-func (i *JP2Image) rawDecode() (jp2 *C.opj_image_t, err error) {
+// given area at the given progression level.  If single is true, the area is
+// exactly the given tile, and just that tile is decoded.
+func (i *JP2Image) rawDecode(area image.Rectangle, level, tile int, single bool) (jp2 *C.opj_image_t, err error) {
 	// Setup the parameters for decode
 	var parameters C.opj_dparameters_t
 	C.opj_set_default_decoder_parameters(&parameters)
 
 	// Calculate cp_reduce - this seems smarter to put in a parameter than to call an extra function
-	parameters.cp_reduce = C.OPJ_UINT32(i.computeProgressionLevel())
+	parameters.cp_reduce = C.OPJ_UINT32(level)
 
-	// Setup file stream
-	stream, err := initializeStream(i.filename)
+	// Setup the stream: just the tile's data for single-tile decodes when the
+	// codestream says where each tile is, otherwise the whole file, reading
+	// from our reader if we have one
+	var stream *C.opj_stream_t
+	var release func()
+	stream, release, err = i.openStream(tile, single)
 	if err != nil {
 		return jp2, err
 	}
+	defer release()
 	defer C.opj_stream_destroy(stream)
 
-	// Create codec
-	codec := C.opj_create_decompress(C.OPJ_CODEC_JP2)
+	// Create codec: raw codestreams need the J2K codec, as the JP2 codec
+	// expects a box structure and fails to read their headers
+	var format C.OPJ_CODEC_FORMAT = C.OPJ_CODEC_JP2
+	if i.info.Container == jp2info.ContainerCodestream {
+		format = C.OPJ_CODEC_J2K
+	}
+	codec := C.opj_create_decompress(format)
 	defer C.opj_destroy_codec(codec)
 
 	// Connect our info/warning/error handlers
@@ -45,9 +63,28 @@ func (i *JP2Image) rawDecode() (jp2 *C.opj_image_t, err error) {
 		return jp2, fmt.Errorf("failed to read the header")
 	}
 
+	// For grayscale requests on images which store luma separately, decode
+	// only the first component and skip the inverse color transform
+	if i.lumaOnly() {
+		var luma = C.OPJ_UINT32(0)
+		if C.opj_set_decoded_components(codec, 1, &luma, C.OPJ_FALSE) == C.OPJ_FALSE {
+			return jp2, fmt.Errorf("failed to restrict decoding to the luma component")
+		}
+	}
+
+	// If the area is exactly one tile and the codestream has tile-part length
+	// markers, we decode just that tile
+	if single {
+		Logger.Debugf("Decoding tile %d directly", tile)
+		if C.opj_get_decoded_tile(codec, stream, jp2, C.OPJ_UINT32(tile)) == C.OPJ_FALSE {
+			return jp2, fmt.Errorf("failed to decode tile %d", tile)
+		}
+		return jp2, nil
+	}
+
 	// Set the decode area if it isn't the full image
-	if i.decodeArea != i.srcRect {
-		r := i.decodeArea
+	if area != i.srcRect {
+		r := area
 		if C.opj_set_decode_area(codec, jp2, C.OPJ_INT32(r.Min.X), C.OPJ_INT32(r.Min.Y), C.OPJ_INT32(r.Max.X), C.OPJ_INT32(r.Max.Y)) == C.OPJ_FALSE {
 			return jp2, fmt.Errorf("failed to set the decoded area")
 		}
@@ -61,6 +98,38 @@ func (i *JP2Image) rawDecode() (jp2 *C.opj_image_t, err error) {
 	return jp2, nil
 }
 
+// openStream returns an openjpeg stream for the image and a function to call
+// once the stream has been destroyed.  If single is true and the header tells
+// us where the tile's tile-parts are, the stream skips straight to them.
+func (i *JP2Image) openStream(tile int, single bool) (*C.opj_stream_t, func(), error) {
+	var src io.ReaderAt = i.reader
+	var closeSrc = func() {}
+	if single && i.reader == nil {
+		var f, err = os.Open(i.filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		src, closeSrc = f, func() { f.Close() }
+	}
+	if single {
+		if ts := newTileStream(src, i.info, tile); ts != nil {
+			var stream, release, err = initializeReaderStream(ts)
+			if err != nil {
+				closeSrc()
+				return nil, nil, err
+			}
+			return stream, func() { release(); closeSrc() }, nil
+		}
+	}
+	closeSrc()
+
+	if i.reader != nil {
+		return initializeReaderStream(i.reader)
+	}
+	var stream, err = initializeStream(i.filename)
+	return stream, func() {}, err
+}
+
 func initializeStream(filename string) (*C.opj_stream_t, error) {
 	cFilename := C.CString(filename)
 	defer C.free(unsafe.Pointer(cFilename))
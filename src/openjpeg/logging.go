@@ -1,19 +1,13 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 package openjpeg
 
 // #cgo pkg-config: libopenjp2
 // #include "handlers.h"
 import "C"
 
-import (
-	"strings"
-
-	"github.com/uoregon-libraries/gopkg/logger"
-)
-
-// Logger defaults to use a default implementation of the uoregon-libraries
-// logging mechanism, but can be overridden (as is the case with the main RAIS
-// command)
-var Logger = logger.Named("rais/openjpeg", logger.Debug)
+import "strings"
 
 // GoLogWarning bridges the openjpeg logging with our internal logger
 //export GoLogWarning
@@ -8,6 +8,10 @@
 // MaxProgressionLevel represents the maximum resolution factor for a JP2
 const MaxProgressionLevel = 32
 
+// encodeLevels is the most resolution levels an encoded image gets; smaller
+// images get fewer so the lowest level is at least one pixel across
+const encodeLevels = 6
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -53,3 +57,13 @@ func desiredProgressionLevel(r image.Rectangle, width, height int) int {
 	level := min(scaleX, scaleY)
 	return min(MaxProgressionLevel, level)
 }
+
+// resolutionLevels returns the number of resolution levels to encode for
+// tiles of the given size
+func resolutionLevels(w, h int) int {
+	var levels = encodeLevels
+	for levels > 1 && min(w, h)>>uint(levels-1) == 0 {
+		levels--
+	}
+	return levels
+}
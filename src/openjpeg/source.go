@@ -0,0 +1,85 @@
+package openjpeg
+
+import (
+	"io"
+	"sync"
+)
+
+// ReaderAtSizer is image data which can be read at any offset, such as a
+// remote file read with ranged requests
+type ReaderAtSizer interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// readerStream tracks an openjpeg stream's position in its source
+type readerStream struct {
+	r    ReaderAtSizer
+	pos  int64
+	size int64
+}
+
+// read fills p from the current position, returning the number of bytes read
+// or -1 at the end of the data or on error, as openjpeg expects
+func (s *readerStream) read(p []byte) int64 {
+	if s.pos >= s.size {
+		return -1
+	}
+	var n, err = s.r.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if n == 0 && err != nil {
+		Logger.Errorf("Unable to read JP2 data at offset %d: %s", s.pos, err)
+		return -1
+	}
+	return int64(n)
+}
+
+// skip moves the position by n bytes, returning n, or -1 if that would move
+// before the start of the data
+func (s *readerStream) skip(n int64) int64 {
+	if s.pos+n < 0 {
+		return -1
+	}
+	s.pos += n
+	return n
+}
+
+// seek moves to an absolute position, returning false if it's out of range
+func (s *readerStream) seek(pos int64) bool {
+	if pos < 0 || pos > s.size {
+		return false
+	}
+	s.pos = pos
+	return true
+}
+
+// C code can't hold Go pointers, so openjpeg is given a handle to each
+// stream's data rather than the stream itself
+var streams = struct {
+	sync.Mutex
+	m    map[uintptr]*readerStream
+	next uintptr
+}{m: make(map[uintptr]*readerStream)}
+
+// registerStream stores a new stream for r and returns its handle
+func registerStream(r ReaderAtSizer) uintptr {
+	streams.Lock()
+	defer streams.Unlock()
+	streams.next++
+	streams.m[streams.next] = &readerStream{r: r, size: r.Size()}
+	return streams.next
+}
+
+// lookupStream returns the stream with the given handle, or nil
+func lookupStream(h uintptr) *readerStream {
+	streams.Lock()
+	defer streams.Unlock()
+	return streams.m[h]
+}
+
+// releaseStream forgets the stream with the given handle
+func releaseStream(h uintptr) {
+	streams.Lock()
+	defer streams.Unlock()
+	delete(streams.m, h)
+}
@@ -0,0 +1,70 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package openjpeg
+
+// #cgo pkg-config: libopenjp2
+// #include <stdint.h>
+// #include <openjpeg.h>
+// #include "handlers.h"
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// initializeReaderStream returns an openjpeg stream which reads from r via
+// the Go callbacks below, and a function to call once the stream has been
+// destroyed
+func initializeReaderStream(r ReaderAtSizer) (*C.opj_stream_t, func(), error) {
+	var h = registerStream(r)
+	var stream = C.new_reader_stream(C.uintptr_t(h), C.OPJ_UINT64(r.Size()))
+	if stream == nil {
+		releaseStream(h)
+		return nil, nil, fmt.Errorf("failed to create reader stream")
+	}
+	return stream, func() { releaseStream(h) }, nil
+}
+
+// goStreamRead is openjpeg's read callback for reader streams
+//export goStreamRead
+func goStreamRead(buf unsafe.Pointer, n C.OPJ_SIZE_T, handle C.uintptr_t) C.OPJ_SIZE_T {
+	var s = lookupStream(uintptr(handle))
+	if s == nil {
+		return C.OPJ_SIZE_T(^uintptr(0))
+	}
+
+	var p []byte
+	var pSlice = (*reflect.SliceHeader)(unsafe.Pointer(&p))
+	pSlice.Cap = int(n)
+	pSlice.Len = int(n)
+	pSlice.Data = uintptr(buf)
+
+	var read = s.read(p)
+	if read < 0 {
+		return C.OPJ_SIZE_T(^uintptr(0))
+	}
+	return C.OPJ_SIZE_T(read)
+}
+
+// goStreamSkip is openjpeg's skip callback for reader streams
+//export goStreamSkip
+func goStreamSkip(n C.OPJ_OFF_T, handle C.uintptr_t) C.OPJ_OFF_T {
+	var s = lookupStream(uintptr(handle))
+	if s == nil {
+		return -1
+	}
+	return C.OPJ_OFF_T(s.skip(int64(n)))
+}
+
+// goStreamSeek is openjpeg's seek callback for reader streams
+//export goStreamSeek
+func goStreamSeek(n C.OPJ_OFF_T, handle C.uintptr_t) C.OPJ_BOOL {
+	var s = lookupStream(uintptr(handle))
+	if s == nil || !s.seek(int64(n)) {
+		return C.OPJ_FALSE
+	}
+	return C.OPJ_TRUE
+}
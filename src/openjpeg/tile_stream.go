@@ -0,0 +1,110 @@
+package openjpeg
+
+import (
+	"io"
+	"rais/src/jp2info"
+)
+
+// eoc is the end of codestream marker
+var eoc = []byte{0xFF, 0xD9}
+
+// tileStream presents a JPEG2000 file holding only one tile's data: the
+// boxes and main header, the tile's tile-parts, and an EOC marker.  The TLM
+// segments are left out, as their lengths no longer describe the data.
+// Decoding from a tileStream reads just the bytes the tile needs instead of
+// walking every tile-part ahead of it, which matters most for remote images.
+type tileStream struct {
+	src    io.ReaderAt
+	pieces []streamPiece
+	size   int64
+}
+
+// streamPiece is a part of a tileStream: either a segment of the source or,
+// if data is set, literal bytes
+type streamPiece struct {
+	seg  jp2info.Segment
+	data []byte
+}
+
+func (p streamPiece) length() int64 {
+	if p.data != nil {
+		return int64(len(p.data))
+	}
+	return p.seg.Length
+}
+
+// newTileStream returns a tileStream for the given tile of src, whose header
+// is described by inf.  If the header doesn't say where the tile's data is,
+// the return is nil.
+func newTileStream(src io.ReaderAt, inf *jp2info.Info, tile int) *tileStream {
+	var segs = inf.TileSegments(tile)
+	if len(segs) == 0 {
+		return nil
+	}
+
+	var ts = &tileStream{src: src}
+	var off int64
+	for _, tlm := range inf.TLMSegments {
+		ts.add(streamPiece{seg: jp2info.Segment{Offset: off, Length: tlm.Offset - off}})
+		off = tlm.Offset + tlm.Length
+	}
+	ts.add(streamPiece{seg: jp2info.Segment{Offset: off, Length: inf.TilePartOffset - off}})
+	for _, seg := range segs {
+		ts.add(streamPiece{seg: seg})
+	}
+	ts.add(streamPiece{data: eoc})
+	return ts
+}
+
+func (ts *tileStream) add(p streamPiece) {
+	if p.length() <= 0 {
+		return
+	}
+	ts.pieces = append(ts.pieces, p)
+	ts.size += p.length()
+}
+
+// Size returns the length of the stream in bytes
+func (ts *tileStream) Size() int64 {
+	return ts.size
+}
+
+// ReadAt implements io.ReaderAt, reading across pieces as necessary
+func (ts *tileStream) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	var start int64
+	for _, piece := range ts.pieces {
+		var l = piece.length()
+		if len(p) == n {
+			break
+		}
+		if off+int64(n) >= start+l {
+			start += l
+			continue
+		}
+
+		var pos = off + int64(n) - start
+		var want = p[n:]
+		if int64(len(want)) > l-pos {
+			want = want[:l-pos]
+		}
+		if piece.data != nil {
+			n += copy(want, piece.data[pos:])
+		} else {
+			var read, err = ts.src.ReadAt(want, piece.seg.Offset+pos)
+			n += read
+			if read < len(want) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+		}
+		start += l
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,44 @@
+package openjpeg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"rais/src/jp2info"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestTileStream(t *testing.T) {
+	// "HHHH" is the header, "LL" a TLM segment, and "aaa", "bb", "cccc" are
+	// tile-parts for tiles 0, 1, and 0
+	var src = bytes.NewReader([]byte("HHLLHHaaabbcccc"))
+	var inf = &jp2info.Info{
+		TLM:            true,
+		TileParts:      []jp2info.TilePart{{Tile: 0, Length: 3}, {Tile: 1, Length: 2}, {Tile: 0, Length: 4}},
+		TilePartOffset: 6,
+		TLMSegments:    []jp2info.Segment{{Offset: 2, Length: 2}},
+	}
+
+	var ts = newTileStream(src, inf, 0)
+	assert.True(ts != nil, "tile stream is built", t)
+	var data, err = ioutil.ReadAll(io.NewSectionReader(ts, 0, ts.Size()))
+	assert.NilError(err, "reading the stream", t)
+	assert.Equal("HHHHaaacccc\xFF\xD9", string(data), "stream holds only the tile's data", t)
+
+	var p = make([]byte, 4)
+	var n, _ = ts.ReadAt(p, 3)
+	assert.Equal("Haaa", string(p[:n]), "reads across pieces", t)
+	n, err = ts.ReadAt(p, 10)
+	assert.Equal("c\xFF\xD9", string(p[:n]), "short read at the end", t)
+	assert.Equal(io.EOF, err, "end of stream", t)
+
+	ts = newTileStream(src, inf, 1)
+	data, _ = ioutil.ReadAll(io.NewSectionReader(ts, 0, ts.Size()))
+	assert.Equal("HHHHbb\xFF\xD9", string(data), "second tile", t)
+
+	assert.True(newTileStream(src, inf, 2) == nil, "no stream for a tile without data", t)
+	inf.TilePartOffset = 0
+	assert.True(newTileStream(src, inf, 0) == nil, "no stream without the tile-part offset", t)
+}
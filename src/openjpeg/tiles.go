@@ -0,0 +1,105 @@
+package openjpeg
+
+import (
+	"image"
+	"image/draw"
+)
+
+// maxAreaTiles is the most tiles we'll decode one at a time for a single
+// request.  Each tile needs its own codec and header read, so beyond this,
+// one region decode is cheaper.
+const maxAreaTiles = 64
+
+// areaTiles returns the indexes of the tiles which exactly cover the decode
+// area, and true if the area is made of whole tiles and the image has
+// tile-part length markers.  For these requests, we hand openjpeg a stream
+// holding only each tile's data (see tileStream), located via the TLM
+// lengths, and ask it for the tiles directly, rather than having it work
+// through the whole codestream.  This is what makes advertising a tile size
+// which is a multiple of the images' native tile size cheap: every IIIF tile
+// is then a block of whole native tiles.
+//
+// We only handle images whose origin and tile grid start at 0,0, which is
+// the case for nearly all JP2s.
+func (i *JP2Image) areaTiles() ([]int, bool) {
+	var inf = i.info
+	if !inf.HasTileIndex() || inf.XOSiz != 0 || inf.YOSiz != 0 || inf.XTOSiz != 0 || inf.YTOSiz != 0 {
+		return nil, false
+	}
+
+	var tw, th = int(inf.XTSiz), int(inf.YTSiz)
+	if tw == 0 || th == 0 {
+		return nil, false
+	}
+
+	// The area has to start on a tile boundary and end on one or at the edge
+	// of the image
+	var w, h = int(inf.Width), int(inf.Height)
+	var r = i.decodeArea
+	if r.Empty() || r.Min.X%tw != 0 || r.Min.Y%th != 0 {
+		return nil, false
+	}
+	if (r.Max.X%tw != 0 && r.Max.X != w) || (r.Max.Y%th != 0 && r.Max.Y != h) {
+		return nil, false
+	}
+
+	var x0, y0 = r.Min.X / tw, r.Min.Y / th
+	var x1, y1 = (r.Max.X + tw - 1) / tw, (r.Max.Y + th - 1) / th
+	if (x1-x0)*(y1-y0) > maxAreaTiles {
+		return nil, false
+	}
+
+	var across = int(inf.TilesAcross())
+	var tiles []int
+	for ty := y0; ty < y1; ty++ {
+		for tx := x0; tx < x1; tx++ {
+			tiles = append(tiles, ty*across+tx)
+		}
+	}
+	return tiles, true
+}
+
+// tileRect returns the part of the image covered by the given tile
+func (i *JP2Image) tileRect(tile int) image.Rectangle {
+	var inf = i.info
+	var tw, th = int(inf.XTSiz), int(inf.YTSiz)
+	var across = int(inf.TilesAcross())
+	var x, y = tile % across * tw, tile / across * th
+	var r = image.Rect(x, y, x+tw, y+th)
+	return r.Intersect(image.Rect(0, 0, int(inf.Width), int(inf.Height)))
+}
+
+// reduceRect returns r as it's positioned in an image decoded at the given
+// progression level.  As in openjpeg, coordinates are divided by two for
+// each level, rounding up, so adjacent tiles stay adjacent.
+func reduceRect(r image.Rectangle, level int) image.Rectangle {
+	var div = 1 << uint(level)
+	var ceil = func(n int) int { return (n + div - 1) / div }
+	return image.Rect(ceil(r.Min.X), ceil(r.Min.Y), ceil(r.Max.X), ceil(r.Max.Y))
+}
+
+// stitchTiles returns a single image of the given area, decoded at level,
+// from images of each of its tiles.  The tile images must all be the same
+// type, which is used for the result.
+func stitchTiles(area image.Rectangle, level int, rects []image.Rectangle, imgs []image.Image) image.Image {
+	var out = reduceRect(area, level)
+	var bounds = image.Rect(0, 0, out.Dx(), out.Dy())
+
+	var dst draw.Image
+	switch imgs[0].(type) {
+	case *image.Gray:
+		dst = image.NewGray(bounds)
+	case *image.Gray16:
+		dst = image.NewGray16(bounds)
+	case *image.RGBA64:
+		dst = image.NewRGBA64(bounds)
+	default:
+		dst = image.NewRGBA(bounds)
+	}
+
+	for n, img := range imgs {
+		var pos = reduceRect(rects[n], level).Min.Sub(out.Min)
+		draw.Draw(dst, img.Bounds().Sub(img.Bounds().Min).Add(pos), img, img.Bounds().Min, draw.Src)
+	}
+	return dst
+}
@@ -0,0 +1,89 @@
+package openjpeg
+
+import (
+	"fmt"
+	"image"
+	"rais/src/jp2info"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func tiledJP2(tlm bool) *JP2Image {
+	var inf = &jp2info.Info{Width: 2500, Height: 1500, XSiz: 2500, YSiz: 1500, XTSiz: 1024, YTSiz: 1024}
+	if tlm {
+		inf.TLM = true
+		inf.TileParts = []jp2info.TilePart{{Tile: 0, Length: 1}}
+	}
+	return &JP2Image{info: inf}
+}
+
+func TestAreaTiles(t *testing.T) {
+	var i = tiledJP2(true)
+
+	i.SetCrop(image.Rect(1024, 0, 2048, 1024))
+	var tiles, ok = i.areaTiles()
+	assert.True(ok, "full tile is decoded directly", t)
+	assert.Equal("[1]", fmt.Sprint(tiles), "second tile in first row", t)
+
+	i.SetCrop(image.Rect(2048, 1024, 2500, 1500))
+	tiles, ok = i.areaTiles()
+	assert.True(ok, "edge tile is decoded directly", t)
+	assert.Equal("[5]", fmt.Sprint(tiles), "last tile in second row", t)
+
+	i.SetCrop(image.Rect(0, 0, 512, 512))
+	_, ok = i.areaTiles()
+	assert.False(ok, "partial tile isn't decoded directly", t)
+
+	i.SetCrop(image.Rect(0, 0, 2048, 1500))
+	tiles, ok = i.areaTiles()
+	assert.True(ok, "block of whole tiles is decoded directly", t)
+	assert.Equal("[0 1 3 4]", fmt.Sprint(tiles), "tiles in the block", t)
+
+	i.SetCrop(image.Rect(0, 0, 2048, 1200))
+	_, ok = i.areaTiles()
+	assert.False(ok, "block ending mid-tile isn't decoded directly", t)
+
+	i.info.XTSiz, i.info.YTSiz = 128, 128
+	i.SetCrop(image.Rect(0, 0, 2500, 1500))
+	_, ok = i.areaTiles()
+	assert.False(ok, "too many tiles aren't decoded one at a time", t)
+}
+
+func TestAreaTilesNoTLM(t *testing.T) {
+	var i = tiledJP2(false)
+	i.SetCrop(image.Rect(0, 0, 1024, 1024))
+	var _, ok = i.areaTiles()
+	assert.False(ok, "no tile index without TLM", t)
+}
+
+func TestTileRect(t *testing.T) {
+	var i = tiledJP2(true)
+	assert.Equal(image.Rect(1024, 0, 2048, 1024), i.tileRect(1), "interior tile", t)
+	assert.Equal(image.Rect(2048, 1024, 2500, 1500), i.tileRect(5), "edge tile", t)
+}
+
+func TestStitchTiles(t *testing.T) {
+	// A 5x3 area of 2x2 tiles decoded at level 1 is 3x2, made of tiles which
+	// are 1x1, except the last column, which rounds up to 1 pixel wide
+	var area = image.Rect(0, 0, 5, 3)
+	var rects = []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(2, 0, 4, 2), image.Rect(4, 0, 5, 2), image.Rect(0, 2, 2, 3)}
+	var imgs []image.Image
+	for n := range rects {
+		var g = image.NewGray(image.Rect(0, 0, 1, 1))
+		g.Pix[0] = uint8(n + 1)
+		imgs = append(imgs, g)
+	}
+	var out = stitchTiles(area, 1, rects, imgs)
+	var g, ok = out.(*image.Gray)
+	assert.True(ok, "output type matches the tiles", t)
+	assert.Equal(image.Rect(0, 0, 3, 2), g.Bounds(), "output is the reduced area", t)
+	assert.Equal("[1 2 3 4 0 0]", fmt.Sprint(g.Pix), "tiles are placed by their reduced position", t)
+
+	// Areas not at the origin are positioned relative to their reduced corner
+	var rgba = image.NewRGBA(image.Rect(0, 0, 2, 1))
+	rgba.Pix[0], rgba.Pix[4] = 7, 9
+	out = stitchTiles(image.Rect(4, 2, 8, 4), 1, []image.Rectangle{image.Rect(4, 2, 8, 4)}, []image.Image{rgba})
+	assert.Equal(image.Rect(0, 0, 2, 1), out.Bounds(), "offset area bounds", t)
+	assert.Equal(uint8(9), out.(*image.RGBA).Pix[4], "offset area data", t)
+}
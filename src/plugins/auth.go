@@ -0,0 +1,16 @@
+package plugins
+
+// AuthDecision is an authorization plugin's verdict on a request for an image
+type AuthDecision string
+
+// All decisions an authorization plugin may return
+const (
+	// AuthAllow serves the request normally
+	AuthAllow AuthDecision = "allow"
+	// AuthDeny refuses the request with a 401
+	AuthDeny AuthDecision = "deny"
+	// AuthDegraded limits the request to the degraded resolution configured
+	// for the ID (see AuthDegraded in rais-example.toml); IDs without a
+	// degraded tier are denied
+	AuthDegraded AuthDecision = "degraded"
+)
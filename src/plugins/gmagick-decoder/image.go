@@ -0,0 +1,238 @@
+package main
+
+/*
+#cgo pkg-config: GraphicsMagick
+#include <stdlib.h>
+#include <string.h>
+#include <magick/api.h>
+
+// read_image reads only the first frame of filename, or only its header if
+// ping is nonzero
+static Image *read_image(const char *filename, int ping, ExceptionInfo *exception) {
+	ImageInfo *info = CloneImageInfo((ImageInfo *) NULL);
+	Image *image;
+
+	(void) strncpy(info->filename, filename, MaxTextExtent - 1);
+	info->subimage = 0;
+	info->subrange = 1;
+	image = ping ? PingImage(info, exception) : ReadImage(info, exception);
+	DestroyImageInfo(info);
+	return image;
+}
+
+static RectangleInfo make_rectangle(long x, long y, unsigned long w, unsigned long h) {
+	RectangleInfo ri;
+	ri.x = x;
+	ri.y = y;
+	ri.width = w;
+	ri.height = h;
+	return ri;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// Image implements img.Decoder for the formats GraphicsMagick reads.
+// Requires the GraphicsMagick development files to be installed.
+//
+// Only the header is read when the Image is created.  The whole image is
+// read in DecodeImage, then cropped and resized by GraphicsMagick and copied
+// into a Go image, so no C memory outlives a decode.
+type Image struct {
+	filename     string
+	width        int
+	height       int
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+}
+
+// gmError turns a GraphicsMagick exception into a Go error
+func gmError(e *C.ExceptionInfo) error {
+	if e.reason == nil {
+		return errors.New("unknown GraphicsMagick error")
+	}
+	if e.description == nil {
+		return errors.New(C.GoString(e.reason))
+	}
+	return fmt.Errorf("%s (%s)", C.GoString(e.reason), C.GoString(e.description))
+}
+
+// failed returns true if the exception is an error rather than a warning
+func failed(e *C.ExceptionInfo) bool {
+	return e.severity >= C.ErrorException
+}
+
+// readImage reads the file's first frame, or just its header if ping is true.
+// The caller must destroy the returned image.
+func (i *Image) readImage(ping bool) (*C.Image, error) {
+	var exception C.ExceptionInfo
+	C.GetExceptionInfo(&exception)
+	defer C.DestroyExceptionInfo(&exception)
+
+	var cFilename = C.CString(i.filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	var p C.int
+	if ping {
+		p = 1
+	}
+	var gm = C.read_image(cFilename, p, &exception)
+	if gm == nil || failed(&exception) {
+		if gm != nil {
+			C.DestroyImageList(gm)
+		}
+		return nil, gmError(&exception)
+	}
+	return gm, nil
+}
+
+// NewImage reads the file's header to get its dimensions
+func NewImage(filename string) (*Image, error) {
+	var i = &Image{filename: filename}
+	var gm, err = i.readImage(true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %s", filename, err)
+	}
+	defer C.DestroyImageList(gm)
+
+	i.width, i.height = int(gm.columns), int(gm.rows)
+	return i, nil
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image to crop to the given rectangle
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// GetWidth returns the width of the image in pixels
+func (i *Image) GetWidth() int {
+	return i.width
+}
+
+// GetHeight returns the height of the image in pixels
+func (i *Image) GetHeight() int {
+	return i.height
+}
+
+// GetTileWidth returns 0 since images read by this plugin have no tiles
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0 since images read by this plugin have no tiles
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1 since images read by this plugin have a single
+// resolution
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// computeDecodeParameters sets up decode area, decode width, and decode
+// height, filling in a zero dimension from the crop's aspect ratio
+func (i *Image) computeDecodeParameters() {
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, i.width, i.height)
+	}
+	i.decodeArea = i.decodeArea.Intersect(image.Rect(0, 0, i.width, i.height))
+
+	var a = i.decodeArea
+	switch {
+	case i.decodeWidth == 0 && i.decodeHeight == 0:
+		i.decodeWidth, i.decodeHeight = a.Dx(), a.Dy()
+	case i.decodeWidth == 0 && a.Dy() > 0:
+		i.decodeWidth = (a.Dx()*i.decodeHeight + a.Dy()/2) / a.Dy()
+	case i.decodeHeight == 0 && a.Dx() > 0:
+		i.decodeHeight = (a.Dy()*i.decodeWidth + a.Dx()/2) / a.Dx()
+	}
+}
+
+// DecodeImage reads the image, then crops and resizes it as requested.
+// Grayscale images decode to image.Gray, images with transparency to
+// image.NRGBA, and everything else to image.RGBA.
+func (i *Image) DecodeImage() (image.Image, error) {
+	i.computeDecodeParameters()
+	if i.decodeArea.Empty() || i.decodeWidth < 1 || i.decodeHeight < 1 {
+		return nil, errors.New("crop area is outside the image")
+	}
+
+	var gm, err = i.readImage(false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { C.DestroyImageList(gm) }()
+
+	var exception C.ExceptionInfo
+	C.GetExceptionInfo(&exception)
+	defer C.DestroyExceptionInfo(&exception)
+
+	var a = i.decodeArea
+	if a != image.Rect(0, 0, i.width, i.height) {
+		var ri = C.make_rectangle(C.long(a.Min.X), C.long(a.Min.Y), C.ulong(a.Dx()), C.ulong(a.Dy()))
+		var cropped = C.CropImage(gm, &ri, &exception)
+		if cropped == nil || failed(&exception) {
+			return nil, gmError(&exception)
+		}
+		C.DestroyImageList(gm)
+		gm = cropped
+	}
+
+	if i.decodeWidth != a.Dx() || i.decodeHeight != a.Dy() {
+		var resized = C.ResizeImage(gm, C.ulong(i.decodeWidth), C.ulong(i.decodeHeight), C.TriangleFilter, 1.0, &exception)
+		if resized == nil || failed(&exception) {
+			return nil, gmError(&exception)
+		}
+		C.DestroyImageList(gm)
+		gm = resized
+	}
+
+	return export(gm, &exception)
+}
+
+// export copies gm's pixels into a Go image
+func export(gm *C.Image, exception *C.ExceptionInfo) (image.Image, error) {
+	var w, h = int(gm.columns), int(gm.rows)
+	var r = image.Rect(0, 0, w, h)
+
+	var out image.Image
+	var pix []byte
+	var pixMap string
+	switch {
+	case C.IsGrayImage(gm, exception) != 0 && gm.matte == 0:
+		var g = image.NewGray(r)
+		out, pix, pixMap = g, g.Pix, "I"
+	case gm.matte != 0:
+		var n = image.NewNRGBA(r)
+		out, pix, pixMap = n, n.Pix, "RGBA"
+	default:
+		var rgba = image.NewRGBA(r)
+		out, pix, pixMap = rgba, rgba.Pix, "RGBA"
+	}
+	if len(pix) == 0 {
+		return nil, errors.New("GraphicsMagick returned an empty image")
+	}
+
+	var cMap = C.CString(pixMap)
+	defer C.free(unsafe.Pointer(cMap))
+	var ok = C.DispatchImage(gm, 0, 0, C.ulong(w), C.ulong(h), cMap, C.CharPixel, unsafe.Pointer(&pix[0]), exception)
+	if ok == C.MagickFail || failed(exception) {
+		return nil, gmError(exception)
+	}
+	return out, nil
+}
@@ -0,0 +1,77 @@
+// This file is an example of a decoder plugin which uses GraphicsMagick, for
+// sites where ImageMagick isn't available or its security policy forbids the
+// formats RAIS needs.  It decodes the same formats as the ImageMagick plugin,
+// and is meant to replace it, not run alongside it: list one or the other in
+// the Plugins setting.  It requires the GraphicsMagick development files, so
+// it isn't built by default:
+//
+//	make bin/plugins/gmagick-decoder.so
+//
+// The extensions handled can be changed with "GMagickExtensions" in the RAIS
+// toml file or RAIS_GMAGICKEXTENSIONS in the environment: a comma-separated
+// list which defaults to ".tif,.tiff,.png,.jpg,.jpeg,.gif".  Since RAIS
+// decodes tiled TIFFs, JPEGs, PNGs, and GIFs natively before plugins get a
+// chance, this plugin mostly sees the variants Go can't read, such as strip
+// TIFFs and CMYK JPEGs, plus anything else added to the list.
+package main
+
+/*
+#cgo pkg-config: GraphicsMagick
+#include <stdlib.h>
+#include <magick/api.h>
+*/
+import "C"
+import (
+	"os"
+	"path/filepath"
+	"rais/src/img"
+	"strings"
+	"unsafe"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l = logger.Named("rais/gmagick-decoder", logger.Debug)
+
+// extensions is the set of lowercased file extensions we decode
+var extensions = make(map[string]bool)
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// Initialize starts GraphicsMagick and reads the list of extensions to handle
+func Initialize() {
+	var path, _ = os.Getwd()
+	var cPath = C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	C.InitializeMagick(cPath)
+
+	viper.SetDefault("GMagickExtensions", ".tif,.tiff,.png,.jpg,.jpeg,.gif")
+	for _, ext := range strings.Split(viper.GetString("GMagickExtensions"), ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if ext[0] != '.' {
+			ext = "." + ext
+		}
+		extensions[ext] = true
+	}
+	l.Debugf("gmagick-decoder plugin: handling %d file extensions", len(extensions))
+}
+
+// ImageDecoders returns our list of one: the GraphicsMagick decoder
+func ImageDecoders() []img.DecodeFn {
+	return []img.DecodeFn{decodeCommonFile}
+}
+
+func decodeCommonFile(path string) (img.Decoder, error) {
+	if !extensions[strings.ToLower(filepath.Ext(path))] {
+		return nil, img.ErrNotHandled
+	}
+	return NewImage(path)
+}
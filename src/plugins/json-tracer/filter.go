@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// sampleRate is the fraction of requests (0 to 1) which get recorded
+var sampleRate float64
+
+// minDuration is the shortest request duration we bother recording
+var minDuration time.Duration
+
+// recordTypes holds the request types we record; if empty, all types are
+// recorded
+var recordTypes map[string]bool
+
+// excludePrefixes holds path prefixes which are never recorded, such as
+// health checks
+var excludePrefixes []string
+
+// splitList turns a comma-separated config value into a list of non-empty,
+// whitespace-trimmed strings
+func splitList(val string) []string {
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// readFilterConfig pulls the sampling and filtering options from viper,
+// returning an error if any are invalid
+func readFilterConfig() error {
+	viper.SetDefault("TracerSampleRate", 1.0)
+	viper.SetDefault("TracerMinDuration", "0")
+
+	sampleRate = viper.GetFloat64("TracerSampleRate")
+	if sampleRate < 0 || sampleRate > 1 {
+		return fmt.Errorf("TracerSampleRate (%g) must be between 0 and 1", sampleRate)
+	}
+
+	var durString = viper.GetString("TracerMinDuration")
+	var err error
+	minDuration, err = time.ParseDuration(durString)
+	if err != nil {
+		return fmt.Errorf("malformed TracerMinDuration (%q): %s", durString, err)
+	}
+
+	recordTypes = make(map[string]bool)
+	for _, t := range splitList(viper.GetString("TracerTypes")) {
+		recordTypes[t] = true
+	}
+	excludePrefixes = splitList(viper.GetString("TracerExcludePaths"))
+
+	return nil
+}
+
+// sampled returns true if a request for the given path should be timed and
+// considered for recording.  This is checked before the request is served so
+// excluded and unsampled requests cost nearly nothing.
+func sampled(path string) bool {
+	for _, prefix := range excludePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	return sampleRate >= 1 || rand.Float64() < sampleRate
+}
+
+// wanted returns true if the event passes the duration and type filters
+func wanted(ev event) bool {
+	if ev.Duration < minDuration.Seconds() {
+		return false
+	}
+
+	return len(recordTypes) == 0 || recordTypes[ev.Type]
+}
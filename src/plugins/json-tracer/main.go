@@ -10,6 +10,18 @@
 // environment:
 //   - RAIS_TRACEROUT=/tmp/rais-traces.json
 //   - RAIS_TRACERFLUSHSECONDS=10
+//
+// At high traffic, recording every request can produce an overwhelming number
+// of events.  These optional settings reduce what's recorded:
+//
+// - TracerSampleRate / RAIS_TRACERSAMPLERATE: fraction of requests (0 to 1)
+//   which are recorded; defaults to 1 (every request)
+// - TracerMinDuration / RAIS_TRACERMINDURATION: only record requests which
+//   take at least this long, e.g., "250ms"; defaults to "0"
+// - TracerTypes / RAIS_TRACERTYPES: comma-separated list of request types to
+//   record, e.g., "Tile,Resize"; defaults to all types
+// - TracerExcludePaths / RAIS_TRACEREXCLUDEPATHS: comma-separated list of path
+//   prefixes which are never recorded, e.g., "/healthcheck"
 
 package main
 
@@ -43,6 +55,11 @@ func Initialize() {
 		return
 	}
 
+	var err = readFilterConfig()
+	if err != nil {
+		l.Fatalf("json-tracer plugin failure: %s", err)
+	}
+
 	reg = new(registry)
 
 	Disabled = false
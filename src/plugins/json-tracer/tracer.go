@@ -2,8 +2,7 @@
 
 import (
 	"net/http"
-	"rais/src/iiif"
-	"strings"
+	"rais/src/plugins"
 	"sync"
 	"time"
 )
@@ -11,6 +10,11 @@
 type event struct {
 	Path     string
 	Type     string
+	ID       string `json:",omitempty"`
+	Region   string `json:",omitempty"`
+	Format   string `json:",omitempty"`
+	Zoom     *int   `json:",omitempty"`
+	Cache    string `json:",omitempty"`
 	Start    time.Time
 	Duration float64
 	Status   int
@@ -29,9 +33,11 @@ type tracer struct {
 // timing data locally.
 func (t *tracer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var sr = statusRecorder{w, 200}
-	var path = req.URL.RawPath
-	if path == "" {
-		path = req.URL.Path
+	var path = req.URL.EscapedPath()
+
+	if !sampled(path) {
+		t.handler.ServeHTTP(w, req)
+		return
 	}
 
 	var start = time.Now()
@@ -40,57 +46,32 @@ func (t *tracer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	// To avoid blocking when the events are being processed, we send the event
 	// to the tracer's list asynchronously
-	go t.appendEvent(path, start, finish, sr.status)
+	go t.appendEvent(path, *plugins.GetRequestInfo(req), start, finish, sr.status)
 }
 
-// getReqType is a bit ugly and hacky, but attempts to determine what kind of
-// IIIF request we have, if any.  Determining type isn't as easy without
-// peeking at RAIS's config, which seems like it could break one day (say the
-// IIIF URL config changes but we forget to update this plugin).  Instead, we
-// just do what we can by pulling the URL apart as necessary to make pretty
-// good guesses.
-func getReqType(path string) string {
-	if len(path) < 9 {
-		return "None"
-	}
-
-	if path[len(path)-9:] == "info.json" {
-		return "Info"
-	}
-
-	var parts = strings.Split(path, "/")
-	if len(parts) < 5 {
-		return "None"
+func (t *tracer) appendEvent(path string, ri plugins.RequestInfo, start, finish time.Time, status int) {
+	var ev = event{
+		Path:     path,
+		Type:     string(ri.Type),
+		ID:       ri.ID,
+		Region:   string(ri.Region),
+		Format:   ri.Format,
+		Cache:    string(ri.CacheStatus),
+		Start:    start,
+		Duration: finish.Sub(start).Seconds(),
+		Status:   status,
 	}
-
-	var iiifPath = strings.Join(parts[len(parts)-5:], "/")
-	var u, err = iiif.NewURL(iiifPath)
-	if err != nil || !u.Valid() {
-		return "None"
+	if ri.HasZoomLevel {
+		ev.Zoom = &ri.ZoomLevel
 	}
-
-	if err == nil {
-		if u.Region.Type == iiif.RTFull || u.Region.Type == iiif.RTSquare {
-			return "Resize"
-		} else if u.Size.W <= 1024 && u.Size.H <= 1024 {
-			return "Tile"
-		}
+	if !wanted(ev) {
+		return
 	}
 
-	return "Unknown"
-}
-
-func (t *tracer) appendEvent(path string, start, finish time.Time, status int) {
 	t.Lock()
 	defer t.Unlock()
 
-	t.events = append(t.events, event{
-		Path:     path,
-		Type:     getReqType(path),
-		Start:    start,
-		Duration: finish.Sub(start).Seconds(),
-		Status:   status,
-	})
+	t.events = append(t.events, ev)
 }
 
 // loop checks regularly for the last flush having been long enough ago to
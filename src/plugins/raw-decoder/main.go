@@ -0,0 +1,69 @@
+//go:build cgo
+// +build cgo
+
+// This file is an example of a decoder plugin for camera RAW files (DNG, NEF,
+// CR2, and the many other formats LibRaw understands), so photographers'
+// masters can be served directly instead of being exported to TIFF or JP2
+// first.  It requires the LibRaw development files, so like the ImageMagick
+// plugin it isn't built by default:
+//
+//	make bin/plugins/raw-decoder.so
+//
+// RAW files have no tiles or resolution levels, and demosaicing is expensive,
+// so every request processes the whole frame.  This is fine for occasional
+// access to masters, but for heavy use the files should still be converted
+// to tiled JP2s; the plugin can feed that conversion, since anything RAIS
+// can decode can be served as a full-size TIFF for an encoder to consume.
+//
+// The extensions handled can be changed with "RAWExtensions" in the RAIS
+// toml file or RAIS_RAWEXTENSIONS in the environment: a comma-separated list
+// which defaults to ".dng,.nef,.cr2,.cr3,.arw,.orf,.raf,.rw2,.pef".
+package main
+
+import (
+	"path/filepath"
+	"rais/src/img"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l = logger.Named("rais/raw-decoder", logger.Debug)
+
+// extensions is the set of lowercased file extensions we decode
+var extensions = make(map[string]bool)
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// Initialize reads the list of RAW extensions to handle
+func Initialize() {
+	viper.SetDefault("RAWExtensions", ".dng,.nef,.cr2,.cr3,.arw,.orf,.raf,.rw2,.pef")
+	for _, ext := range strings.Split(viper.GetString("RAWExtensions"), ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if ext[0] != '.' {
+			ext = "." + ext
+		}
+		extensions[ext] = true
+	}
+	l.Debugf("raw-decoder plugin: handling %d RAW file extensions", len(extensions))
+}
+
+// ImageDecoders returns our list of one: the LibRaw decoder
+func ImageDecoders() []img.DecodeFn {
+	return []img.DecodeFn{decodeRAW}
+}
+
+func decodeRAW(path string) (img.Decoder, error) {
+	if !extensions[strings.ToLower(filepath.Ext(path))] {
+		return nil, img.ErrNotHandled
+	}
+	return NewImage(path)
+}
@@ -0,0 +1,106 @@
+package plugins
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestType describes the kind of IIIF request RAIS is handling
+type RequestType string
+
+// All request types RAIS reports
+const (
+	// ReqNone is used for requests that aren't IIIF requests at all
+	ReqNone RequestType = "None"
+	// ReqInfo is used for info.json requests
+	ReqInfo RequestType = "Info"
+	// ReqResize is used for requests of the full (or square) region, typically
+	// thumbnails or full-image downloads
+	ReqResize RequestType = "Resize"
+	// ReqTile is used for requests of a small region of an image
+	ReqTile RequestType = "Tile"
+	// ReqUnknown is used for valid IIIF requests which don't fit any other type,
+	// such as large regions at large sizes
+	ReqUnknown RequestType = "Unknown"
+)
+
+// RegionClass describes a request's region parameter
+type RegionClass string
+
+// All region classes RAIS reports
+const (
+	RegionFull    RegionClass = "full"
+	RegionSquare  RegionClass = "square"
+	RegionPixel   RegionClass = "pixel"
+	RegionPercent RegionClass = "percent"
+)
+
+// CacheStatus describes whether a request was served from RAIS's tile or
+// thumbnail cache
+type CacheStatus string
+
+// All cache statuses RAIS reports
+const (
+	// CacheHit means the response came from the cache
+	CacheHit CacheStatus = "hit"
+	// CacheMiss means the response was cacheable, but had to be rendered
+	CacheMiss CacheStatus = "miss"
+	// CacheBypass means the response can't be cached, such as a large region
+	// or a non-JPEG format
+	CacheBypass CacheStatus = "bypass"
+)
+
+// RequestInfo holds the attributes RAIS determines for each request, so
+// tracer and metrics plugins can report on them without parsing IIIF URLs
+// themselves.  Type, ID, Region, and Format are set before any handler runs.
+// The image handler fills in ZoomLevel and CacheStatus as it works, so those
+// should only be read after the wrapped handler returns.
+type RequestInfo struct {
+	Type   RequestType
+	ID     string
+	Region RegionClass
+	Format string
+
+	// ZoomLevel is the power of two by which the region was scaled down: 0 at
+	// full resolution, 1 at half, and so on.  It's negative for upscaled
+	// requests, and only meaningful if HasZoomLevel is true.
+	ZoomLevel    int
+	HasZoomLevel bool
+
+	// CacheStatus is empty for requests which never reached the cache check,
+	// such as info requests or errors
+	CacheStatus CacheStatus
+}
+
+type ctxKey int
+
+const reqInfoKey ctxKey = iota
+
+// WithRequestInfo returns a shallow copy of req with the given request info
+// stored in its context
+func WithRequestInfo(req *http.Request, ri *RequestInfo) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), reqInfoKey, ri))
+}
+
+// GetRequestInfo returns the request info RAIS stored in the request's
+// context.  If RAIS didn't classify the request, an empty RequestInfo with a
+// type of ReqNone is returned, so the result is never nil.
+func GetRequestInfo(req *http.Request) *RequestInfo {
+	var ri, ok = req.Context().Value(reqInfoKey).(*RequestInfo)
+	if !ok {
+		return &RequestInfo{Type: ReqNone}
+	}
+	return ri
+}
+
+// WithRequestType returns a shallow copy of req with the given request type
+// stored in its context
+func WithRequestType(req *http.Request, t RequestType) *http.Request {
+	return WithRequestInfo(req, &RequestInfo{Type: t})
+}
+
+// GetRequestType returns the request type RAIS stored in the request's
+// context.  If RAIS didn't classify the request, ReqNone is returned.
+func GetRequestType(req *http.Request) RequestType {
+	return GetRequestInfo(req).Type
+}
@@ -90,8 +90,17 @@ func (a *asset) valid() bool {
 
 func (a *asset) download() error {
 	// If the file has already been cached, we can just return here
-	var _, err = os.Stat(a.path)
-	if err == nil {
+	if a.cached() {
+		return nil
+	}
+
+	// Other processes sharing the cache may be downloading the same asset
+	var unlock, err = a.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if a.cached() {
 		return nil
 	}
 
@@ -99,6 +108,12 @@ func (a *asset) download() error {
 	return a.downloader(a)
 }
 
+// cached returns true if the asset's file exists locally
+func (a *asset) cached() bool {
+	var _, err = os.Stat(a.path)
+	return err == nil
+}
+
 // tryFLock attempts to lock for file writing in a non-blocking way.  If the
 // lock can be acquired, the return is true, otherwise false.
 func (a *asset) tryFLock() bool {
@@ -1,28 +1,100 @@
 package main
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"rais/src/chaos"
+	"regexp"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/uoregon-libraries/gopkg/fileutil"
 )
 
-func (a *asset) setupTempFile() (*fileutil.SafeFile, error) {
+// md5ETag matches S3 ETags which are the MD5 of the object's data.  Objects
+// uploaded in parts, or encrypted with KMS keys, have other ETags we can't
+// verify locally.
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// setupTempFile creates a temporary file alongside the asset's cache path,
+// so that it can be renamed into place atomically once it's complete
+func (a *asset) setupTempFile() (*os.File, error) {
 	var parentDir = filepath.Dir(a.path)
 	var err = os.MkdirAll(parentDir, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create cached file path %q: %s", parentDir, err)
 	}
 
-	return fileutil.NewSafeFile(a.path), nil
+	var f *os.File
+	f, err = ioutil.TempFile(parentDir, "."+filepath.Base(a.path)+".dl-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp file in %q: %s", parentDir, err)
+	}
+	return f, nil
 }
 
-func fetchS3(a *asset) error {
+// commit closes the temp file, verifies it against the expected size and
+// ETag, and renames it to the asset's cache path.  A negative size or empty
+// ETag skips that check.  The temp file is removed if anything fails, so
+// the cache never holds a partial file.
+func (a *asset) commit(f *os.File, size int64, etag string) error {
+	var err = f.Close()
+	if err == nil {
+		err = verifyDownload(f.Name(), size, etag)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), a.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to save item %q: %s", a.key, err)
+	}
+	return nil
+}
+
+// verifyDownload checks that the file at path has the given size and, if the
+// ETag is a plain MD5 sum, that its data matches it
+func verifyDownload(path string, size int64, etag string) error {
+	var f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var info os.FileInfo
+	info, err = f.Stat()
+	if err != nil {
+		return err
+	}
+	if size >= 0 && info.Size() != size {
+		return fmt.Errorf("downloaded %d bytes, expected %d", info.Size(), size)
+	}
+
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+	if !md5ETag.MatchString(etag) {
+		return nil
+	}
+	var h = md5.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	var sum = hex.EncodeToString(h.Sum(nil))
+	if sum != etag {
+		return fmt.Errorf("downloaded data's MD5 (%s) doesn't match ETag (%s)", sum, etag)
+	}
+	return nil
+}
+
+// newSession returns an AWS session for the configured zone and endpoint
+func newSession() (*session.Session, error) {
 	var conf = &aws.Config{
 		Region:           aws.String(s3zone),
 		Endpoint:         aws.String(s3endpoint),
@@ -30,15 +102,42 @@ func fetchS3(a *asset) error {
 	}
 	var sess, err = session.NewSession(conf)
 	if err != nil {
-		return fmt.Errorf("unable to set up AWS session: %s", err)
+		return nil, fmt.Errorf("unable to set up AWS session: %s", err)
 	}
+	return sess, nil
+}
 
-	var obj = &s3.GetObjectInput{
+func fetchS3(a *asset) error {
+	var err = chaos.FailS3()
+	if err != nil {
+		return fmt.Errorf("unable to download item %q: %s", a.key, err)
+	}
+
+	var sess *session.Session
+	sess, err = newSession()
+	if err != nil {
+		return err
+	}
+
+	// We need the size and ETag to verify the download, and requiring the ETag
+	// to match keeps the downloader's ranged requests from mixing data from
+	// two versions of an object that's replaced mid-download
+	var head *s3.HeadObjectOutput
+	head, err = s3.New(sess).HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(a.bucket),
 		Key:    aws.String(a.key),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read metadata for item %q: %s", a.key, err)
 	}
 
-	var tmpfile *fileutil.SafeFile
+	var obj = &s3.GetObjectInput{
+		Bucket:  aws.String(a.bucket),
+		Key:     aws.String(a.key),
+		IfMatch: head.ETag,
+	}
+
+	var tmpfile *os.File
 	tmpfile, err = a.setupTempFile()
 	if err != nil {
 		return err
@@ -47,11 +146,16 @@ func fetchS3(a *asset) error {
 	var dl = s3manager.NewDownloader(sess)
 	_, err = dl.Download(tmpfile, obj)
 	if err != nil {
-		tmpfile.Cancel()
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
 		return fmt.Errorf("unable to download item %q: %s", a.key, err)
 	}
 
-	return tmpfile.Close()
+	var size int64 = -1
+	if head.ContentLength != nil {
+		size = *head.ContentLength
+	}
+	return a.commit(tmpfile, size, aws.StringValue(head.ETag))
 }
 
 func fetchNil(a *asset) error {
@@ -59,5 +163,5 @@ func fetchNil(a *asset) error {
 	if err != nil {
 		return err
 	}
-	return tmpfile.Close()
+	return a.commit(tmpfile, 0, "")
 }
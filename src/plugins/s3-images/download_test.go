@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCommit(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-s3-download")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var a = &asset{key: "key.jp2", path: filepath.Join(dir, "bucket", "key.jp2")}
+	var save = func(data string, size int64, etag string) error {
+		var f, err = a.setupTempFile()
+		assert.NilError(err, "creating temp file", t)
+		f.WriteString(data)
+		return a.commit(f, size, etag)
+	}
+	var files = func() int {
+		var infos, _ = ioutil.ReadDir(filepath.Dir(a.path))
+		return len(infos)
+	}
+
+	// md5("hello") = 5d41402abc4b2a76b9719d911017c592
+	assert.True(save("hell", 5, "") != nil, "truncated download", t)
+	assert.True(save("hellp", 5, `"5d41402abc4b2a76b9719d911017c592"`) != nil, "corrupt download", t)
+	assert.Equal(0, files(), "failed downloads leave nothing behind", t)
+
+	assert.NilError(save("hello", 5, `"5D41402ABC4B2A76B9719D911017C592"`), "verified download", t)
+	var data, _ = ioutil.ReadFile(a.path)
+	assert.Equal("hello", string(data), "file is in place", t)
+	assert.Equal(1, files(), "temp file is gone", t)
+
+	os.Remove(a.path)
+	assert.NilError(save("hello", 5, `"0123456789abcdef0123456789abcdef-2"`), "multipart ETags aren't checked", t)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Cross-process download locking: every RAIS instance sharing a cache
+// directory uses the same lock file for a given asset, so only one of them
+// downloads it at a time.  The in-process locks on the asset itself still
+// keep a single RAIS from hitting the filesystem over and over.
+//
+// Large downloads can legitimately take minutes, so the lock holder touches
+// the lock file every lockHeartbeat.  Waiters wait as long as that heartbeat
+// continues, and only treat a lock as abandoned once it stops.
+var (
+	// lockHeartbeat is how often the lock holder updates the lock file's
+	// modification time
+	lockHeartbeat = time.Second * 10
+
+	// lockStale is how long a lock file's heartbeat has to be missing before we
+	// assume the process which created it died mid-download and remove it
+	lockStale = time.Minute
+
+	// lockPoll is how often we check on another process's download
+	lockPoll = time.Millisecond * 250
+)
+
+func (a *asset) lockPath() string {
+	return a.path + ".lock"
+}
+
+// lockFile creates the asset's lock file using O_EXCL, which is atomic even
+// across processes.  If another process holds the lock, we wait until it's
+// released, goes stale, or the asset shows up in the cache, in which case the
+// returned unlock function does nothing and the caller should find the cached
+// file.
+func (a *asset) lockFile() (unlock func(), err error) {
+	var lp = a.lockPath()
+	err = os.MkdirAll(filepath.Dir(lp), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cached file path %q: %s", filepath.Dir(lp), err)
+	}
+
+	for {
+		var f *os.File
+		f, err = os.OpenFile(lp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return heartbeat(lp), nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to create lock file %q: %s", lp, err)
+		}
+
+		if a.cached() {
+			return func() {}, nil
+		}
+		var info, serr = os.Stat(lp)
+		if serr == nil && time.Since(info.ModTime()) > lockStale {
+			removeStaleLock(lp)
+			continue
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// heartbeat keeps the lock file at lp fresh until the returned unlock
+// function is called, which stops the heartbeat and removes the lock
+func heartbeat(lp string) func() {
+	var done = make(chan struct{})
+	go func() {
+		var t = time.NewTicker(lockHeartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				var now = time.Now()
+				os.Chtimes(lp, now, now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		os.Remove(lp)
+	}
+}
+
+// removeStaleLock removes the abandoned lock file at lp.  Another waiter may
+// have replaced the stale lock with a fresh one since we checked it, so
+// rather than deleting whatever is at lp, we move it aside atomically and
+// make sure what we moved is still stale.  If it isn't, the fresh lock is put
+// back.
+func removeStaleLock(lp string) {
+	var aside = lp + ".stale-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if os.Rename(lp, aside) != nil {
+		// Someone else got to it first
+		return
+	}
+
+	var moved, err = os.Stat(aside)
+	if err == nil && time.Since(moved.ModTime()) <= lockStale {
+		// Link fails rather than replacing a lock created in the meantime
+		os.Link(aside, lp)
+	} else {
+		l.Warnf("s3-images plugin: removed stale lock file %q", lp)
+	}
+	os.Remove(aside)
+}
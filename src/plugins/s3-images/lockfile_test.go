@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-s3-lock")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var oldBeat, oldStale, oldPoll = lockHeartbeat, lockStale, lockPoll
+	defer func() { lockHeartbeat, lockStale, lockPoll = oldBeat, oldStale, oldPoll }()
+	lockHeartbeat, lockStale, lockPoll = time.Millisecond*10, time.Millisecond*100, time.Millisecond*5
+
+	var downloads int
+	var a = &asset{path: filepath.Join(dir, "a", "key.jp2")}
+	a.downloader = func(a *asset) error {
+		downloads++
+		return ioutil.WriteFile(a.path, []byte("data"), 0644)
+	}
+
+	// Another process holds the lock and finishes while we wait
+	os.MkdirAll(filepath.Dir(a.path), 0755)
+	ioutil.WriteFile(a.lockPath(), nil, 0644)
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		ioutil.WriteFile(a.path, []byte("data"), 0644)
+		os.Remove(a.lockPath())
+	}()
+	assert.NilError(a.download(), "waiting on another process", t)
+	assert.Equal(0, downloads, "the other process's download is used", t)
+
+	// A holder whose download outlasts lockStale keeps its lock alive
+	os.Remove(a.path)
+	var other = &asset{path: a.path}
+	var unlock, _ = other.lockFile()
+	var start = time.Now()
+	go func() {
+		time.Sleep(lockStale * 3)
+		ioutil.WriteFile(a.path, []byte("data"), 0644)
+		unlock()
+	}()
+	assert.NilError(a.download(), "waiting on a long download", t)
+	assert.True(time.Since(start) >= lockStale*3, "the lock wasn't taken over while its heartbeat continued", t)
+	assert.Equal(0, downloads, "the long download is used", t)
+
+	// The lock's heartbeat has stopped, so it's considered abandoned
+	os.Remove(a.path)
+	ioutil.WriteFile(a.lockPath(), nil, 0644)
+	var old = time.Now().Add(-lockStale * 2)
+	os.Chtimes(a.lockPath(), old, old)
+	assert.NilError(a.download(), "stale lock is taken over", t)
+	assert.Equal(1, downloads, "asset is downloaded", t)
+	var _, serr = os.Stat(a.lockPath())
+	assert.True(os.IsNotExist(serr), "lock file is removed after download", t)
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-s3-lock")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var lp = filepath.Join(dir, "key.jp2.lock")
+
+	// Another waiter removed the stale lock and created a fresh one before we
+	// got to it
+	ioutil.WriteFile(lp, []byte("fresh"), 0644)
+	removeStaleLock(lp)
+	var data, _ = ioutil.ReadFile(lp)
+	assert.Equal("fresh", string(data), "a fresh lock isn't removed", t)
+
+	var old = time.Now().Add(-lockStale * 2)
+	os.Chtimes(lp, old, old)
+	removeStaleLock(lp)
+	_, err = os.Stat(lp)
+	assert.True(os.IsNotExist(err), "a stale lock is removed", t)
+	var files, _ = ioutil.ReadDir(dir)
+	assert.Equal(0, len(files), "nothing is left behind", t)
+}
@@ -3,7 +3,9 @@
 // the more general but dangerous "external-images" plugin).  This requires you
 // to put your AWS access key information into the environment per AWS's
 // standard credential management: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
-// You may also put access keys in $HOME/.aws/credentials (or
+// To keep keys out of the environment, RAIS also reads these from files named
+// by AWS_ACCESS_KEY_ID_FILE and AWS_SECRET_ACCESS_KEY_FILE, or from its
+// SecretsDir.  You may also put access keys in $HOME/.aws/credentials (or
 // docker/s3credentials if you're using the docker-compose example override
 // setup).  See docker/s3credentials.example for an example credentials file.
 //
@@ -19,6 +21,15 @@
 // toml file or by setting `RAIS_S3CACHE` in the environment, and defaults to
 // `/var/cache/rais-s3`.
 //
+// With `S3Stream` set to true, JPEG 2000, JPEG, PNG, and GIF assets aren't
+// downloaded at all.  IDToPath instead returns a short-lived presigned URL,
+// and RAIS reads the file with ranged requests; for JP2s, that's just the
+// parts each request needs.  Other formats are still downloaded to the cache.
+//
+// Several RAIS instances may share one cache directory: each download is
+// coordinated through a "<cached file>.lock" file so an asset is only fetched
+// by one process at a time.
+//
 // Expiration of cached files must be managed externally (to avoid
 // over-complicating this plugin).  A simple approach could be a cron job that
 // wipes out all cached data if it hasn't been accessed in the past 24 hours:
@@ -59,6 +70,7 @@ func Initialize() {
 	s3cache = viper.GetString("S3Cache")
 	s3zone = viper.GetString("S3Zone")
 	s3endpoint = viper.GetString("S3Endpoint")
+	s3stream = viper.GetBool("S3Stream")
 
 	if s3zone == "" {
 		l.Infof("S3 plugin will not be enabled: S3Zone must be set in rais.toml or RAIS_S3ZONE must be set in the environment")
@@ -77,6 +89,9 @@ func Initialize() {
 
 	l.Debugf("Setting S3 cache location to %q", s3cache)
 	l.Debugf("Setting S3 zone to %q", s3zone)
+	if s3stream {
+		l.Debugf("Streaming supported assets from S3 rather than downloading them")
+	}
 	if cacheLifetime > time.Duration(0) {
 		l.Debugf("Setting S3 cache expiration to %s", cacheLifetime)
 		go purgeLoop()
@@ -104,6 +119,9 @@ func IDToPath(id iiif.ID) (path string, err error) {
 	if a.key == "" {
 		return "", plugins.ErrSkipped
 	}
+	if a.streamable() {
+		return a.streamURL()
+	}
 
 	// See if this file is currently being downloaded; if so we need to wait
 	var timeout = time.Now().Add(time.Second * 10)
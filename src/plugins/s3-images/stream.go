@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3stream is true if assets RAIS can stream are read remotely instead of
+// being downloaded
+var s3stream bool
+
+// streamURLLifetime is how long a presigned stream URL stays valid.  RAIS
+// asks for a new URL on every request, so this only needs to outlast one
+// request's reads.
+const streamURLLifetime = 15 * time.Minute
+
+// streamExts holds the extensions of files RAIS's stream decoders can read
+// remotely.  TIFFs aren't streamed, since strip TIFFs need a plugin decoder,
+// and those only read local files.
+var streamExts = map[string]bool{
+	".jp2":  true,
+	".j2c":  true,
+	".j2k":  true,
+	".jpf":  true,
+	".jpx":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// streamable returns true if streaming is on and a is an S3 asset RAIS can
+// read remotely
+func (a *asset) streamable() bool {
+	return s3stream && strings.HasPrefix(string(a.id), "s3://") && streamExts[strings.ToLower(path.Ext(a.key))]
+}
+
+// streamURL returns a presigned URL for reading a directly from S3
+func (a *asset) streamURL() (string, error) {
+	var sess, err = newSession()
+	if err != nil {
+		return "", err
+	}
+
+	var req, _ = s3.New(sess).GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.key),
+	})
+	var u string
+	u, err = req.Presign(streamURLLifetime)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign URL for item %q: %s", a.key, err)
+	}
+	return u, nil
+}
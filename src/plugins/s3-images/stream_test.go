@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/url"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestStreamable(t *testing.T) {
+	var asset = func(id string) *asset {
+		var u, _ = url.Parse(id)
+		return newAsset(iiif.ID(id), u)
+	}
+
+	s3stream = false
+	assert.False(asset("s3://bucket/foo.jp2").streamable(), "nothing streams when S3Stream is off", t)
+
+	s3stream = true
+	defer func() { s3stream = false }()
+	assert.True(asset("s3://bucket/foo.jp2").streamable(), "JP2s stream", t)
+	assert.True(asset("s3://bucket/dir/foo.JPX").streamable(), "extensions are case-insensitive", t)
+	assert.True(asset("s3://bucket/foo.png").streamable(), "PNGs stream", t)
+	assert.False(asset("s3://bucket/foo.tif").streamable(), "TIFFs are downloaded", t)
+	assert.False(asset("nil://bucket/foo.jp2").streamable(), "only S3 assets stream", t)
+}
@@ -0,0 +1,189 @@
+package ptiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TIFF field types we need to read
+const (
+	dtByte      = 1
+	dtASCII     = 2
+	dtShort     = 3
+	dtLong      = 4
+	dtRational  = 5
+	dtUndefined = 7
+	dtIFD       = 13
+	dtLong8     = 16
+	dtIFD8      = 18
+)
+
+// typeSizes holds the size of a single value of each field type
+var typeSizes = map[uint16]uint64{
+	dtByte:      1,
+	dtASCII:     1,
+	dtShort:     2,
+	dtLong:      4,
+	dtRational:  8,
+	dtUndefined: 1,
+	dtIFD:       4,
+	dtLong8:     8,
+	dtIFD8:      8,
+}
+
+// maxFieldSize caps how much data a single field may hold, so a corrupt
+// count can't make us allocate gigabytes
+const maxFieldSize = 64 << 20
+
+// maxIFDs caps how many IFDs we'll read from one file, which also stops
+// malicious IFD loops
+const maxIFDs = 256
+
+// field is a single IFD entry's raw data
+type field struct {
+	typ  uint16
+	data []byte
+}
+
+// ifd maps tags to their fields
+type ifd map[uint16]field
+
+// header describes how a file's IFDs are laid out
+type header struct {
+	bo      binary.ByteOrder
+	bigTIFF bool
+	first   uint64
+}
+
+// readHeader reads the byte order, version, and first IFD offset
+func readHeader(r io.ReaderAt) (*header, error) {
+	var buf = make([]byte, 16)
+	if _, err := r.ReadAt(buf[:8], 0); err != nil {
+		return nil, errNotTIFF
+	}
+
+	var h = &header{}
+	switch string(buf[:2]) {
+	case "II":
+		h.bo = binary.LittleEndian
+	case "MM":
+		h.bo = binary.BigEndian
+	default:
+		return nil, errNotTIFF
+	}
+
+	switch h.bo.Uint16(buf[2:]) {
+	case 42:
+		h.first = uint64(h.bo.Uint32(buf[4:]))
+	case 43:
+		if _, err := r.ReadAt(buf, 0); err != nil {
+			return nil, errNotTIFF
+		}
+		if h.bo.Uint16(buf[4:]) != 8 {
+			return nil, errNotTIFF
+		}
+		h.bigTIFF = true
+		h.first = h.bo.Uint64(buf[8:])
+	default:
+		return nil, errNotTIFF
+	}
+
+	return h, nil
+}
+
+// readIFD reads the IFD at off, returning it and the offset of the next IFD
+func (h *header) readIFD(r io.ReaderAt, off uint64) (ifd, uint64, error) {
+	var countSize, entrySize, offSize uint64 = 2, 12, 4
+	if h.bigTIFF {
+		countSize, entrySize, offSize = 8, 20, 8
+	}
+
+	var buf = make([]byte, countSize)
+	if _, err := r.ReadAt(buf, int64(off)); err != nil {
+		return nil, 0, fmt.Errorf("unable to read IFD at %d: %s", off, err)
+	}
+	var n = h.uint(buf)
+	if n*entrySize > maxFieldSize {
+		return nil, 0, fmt.Errorf("IFD at %d has too many entries (%d)", off, n)
+	}
+
+	buf = make([]byte, n*entrySize+offSize)
+	if _, err := r.ReadAt(buf, int64(off+countSize)); err != nil {
+		return nil, 0, fmt.Errorf("unable to read IFD at %d: %s", off, err)
+	}
+
+	var d = make(ifd)
+	for i := uint64(0); i < n; i++ {
+		var e = buf[i*entrySize : (i+1)*entrySize]
+		var tag, typ = h.bo.Uint16(e), h.bo.Uint16(e[2:])
+		var size, ok = typeSizes[typ]
+		if !ok {
+			continue
+		}
+		var count = h.uint(e[4 : 4+offSize])
+		var value = e[4+offSize:]
+		if count > maxFieldSize/size {
+			return nil, 0, fmt.Errorf("tag %d at IFD %d is too large", tag, off)
+		}
+
+		var f = field{typ: typ, data: make([]byte, count*size)}
+		if count*size <= offSize {
+			copy(f.data, value)
+		} else if _, err := r.ReadAt(f.data, int64(h.uint(value))); err != nil {
+			return nil, 0, fmt.Errorf("unable to read tag %d at IFD %d: %s", tag, off, err)
+		}
+		d[tag] = f
+	}
+
+	return d, h.uint(buf[n*entrySize:]), nil
+}
+
+// uint reads a 2-, 4-, or 8-byte unsigned integer
+func (h *header) uint(b []byte) uint64 {
+	switch len(b) {
+	case 2:
+		return uint64(h.bo.Uint16(b))
+	case 4:
+		return uint64(h.bo.Uint32(b))
+	}
+	return h.bo.Uint64(b)
+}
+
+// errMissingTag is returned when a required tag isn't present
+var errMissingTag = errors.New("missing required tag")
+
+// values returns a field's integer values.  Rationals return their
+// numerators and denominators in turn.
+func (h *header) values(d ifd, tag uint16) ([]uint64, error) {
+	var f, ok = d[tag]
+	if !ok {
+		return nil, errMissingTag
+	}
+
+	var size = typeSizes[f.typ]
+	if f.typ == dtRational {
+		size = 4
+	}
+	var vals = make([]uint64, len(f.data)/int(size))
+	for i := range vals {
+		var b = f.data[i*int(size) : (i+1)*int(size)]
+		if size == 1 {
+			vals[i] = uint64(b[0])
+		} else {
+			vals[i] = h.uint(b)
+		}
+	}
+	return vals, nil
+}
+
+// value returns a field's first integer value, or def if the tag isn't
+// present
+func (h *header) value(d ifd, tag uint16, def uint64) uint64 {
+	var vals, err = h.values(d, tag)
+	if err != nil || len(vals) == 0 {
+		return def
+	}
+	return vals[0]
+}
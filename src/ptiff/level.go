@@ -0,0 +1,270 @@
+package ptiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+// Tags we read
+const (
+	tNewSubfileType            = 254
+	tImageWidth                = 256
+	tImageLength               = 257
+	tBitsPerSample             = 258
+	tCompression               = 259
+	tPhotometricInterpretation = 262
+	tSamplesPerPixel           = 277
+	tXResolution               = 282
+	tPlanarConfiguration       = 284
+	tResolutionUnit            = 296
+	tPredictor                 = 317
+	tTileWidth                 = 322
+	tTileLength                = 323
+	tTileOffsets               = 324
+	tTileByteCounts            = 325
+	tSubIFDs                   = 330
+	tJPEGTables                = 347
+)
+
+// Tag values we support
+const (
+	cNone        = 1
+	cLZW         = 5
+	cJPEG        = 7
+	cDeflate     = 8
+	cDeflateOld  = 32946
+	pWhiteIsZero = 0
+	pBlackIsZero = 1
+	pRGB         = 2
+	pYCbCr       = 6
+	prNone       = 1
+	prHorizontal = 2
+	resInch      = 2
+	resCM        = 3
+	subfileMask  = 4
+)
+
+// level is a single resolution of a pyramidal TIFF: one tiled IFD
+type level struct {
+	width, height int
+	tileW, tileH  int
+	offsets       []uint64
+	counts        []uint64
+	compression   uint64
+	photometric   uint64
+	samples       int
+	predictor     uint64
+	jpegTables    []byte
+}
+
+// parseLevel reads the level described by d, returning errNotTiled or
+// errUnsupported if it isn't a tiled image we can decode ourselves
+func (h *header) parseLevel(d ifd) (*level, error) {
+	var l = &level{
+		width:       int(h.value(d, tImageWidth, 0)),
+		height:      int(h.value(d, tImageLength, 0)),
+		tileW:       int(h.value(d, tTileWidth, 0)),
+		tileH:       int(h.value(d, tTileLength, 0)),
+		compression: h.value(d, tCompression, cNone),
+		photometric: h.value(d, tPhotometricInterpretation, pBlackIsZero),
+		samples:     int(h.value(d, tSamplesPerPixel, 1)),
+		predictor:   h.value(d, tPredictor, prNone),
+	}
+	if l.tileW <= 0 || l.tileH <= 0 {
+		return nil, errNotTiled
+	}
+	if l.width <= 0 || l.height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", l.width, l.height)
+	}
+
+	var bits, _ = h.values(d, tBitsPerSample)
+	for _, b := range bits {
+		if b != 8 {
+			return nil, errUnsupported
+		}
+	}
+	if h.value(d, tPlanarConfiguration, 1) != 1 || l.samples < 1 || l.samples > 4 || l.samples == 2 {
+		return nil, errUnsupported
+	}
+
+	switch l.photometric {
+	case pWhiteIsZero, pBlackIsZero:
+		if l.samples != 1 {
+			return nil, errUnsupported
+		}
+	case pRGB:
+		if l.samples < 3 {
+			return nil, errUnsupported
+		}
+	case pYCbCr:
+		// Only JPEG-compressed YCbCr is common, and the JPEG decoder converts it
+		if l.compression != cJPEG {
+			return nil, errUnsupported
+		}
+	default:
+		return nil, errUnsupported
+	}
+
+	switch l.compression {
+	case cNone, cLZW, cDeflate, cDeflateOld:
+	case cJPEG:
+		if f, ok := d[tJPEGTables]; ok {
+			l.jpegTables = f.data
+		}
+	default:
+		return nil, errUnsupported
+	}
+	if l.predictor != prNone && l.predictor != prHorizontal {
+		return nil, errUnsupported
+	}
+
+	var err error
+	l.offsets, err = h.values(d, tTileOffsets)
+	if err != nil {
+		return nil, err
+	}
+	l.counts, err = h.values(d, tTileByteCounts)
+	if err != nil {
+		return nil, err
+	}
+	var tiles = l.tilesAcross() * ((l.height + l.tileH - 1) / l.tileH)
+	if len(l.offsets) < tiles || len(l.counts) < tiles {
+		return nil, fmt.Errorf("expected %d tiles, found %d", tiles, len(l.offsets))
+	}
+
+	return l, nil
+}
+
+// tilesAcross returns the number of tiles in each row
+func (l *level) tilesAcross() int {
+	return (l.width + l.tileW - 1) / l.tileW
+}
+
+// bounds returns the level's full image rectangle
+func (l *level) bounds() image.Rectangle {
+	return image.Rect(0, 0, l.width, l.height)
+}
+
+// newCanvas returns an image of the given size for this level's tiles to be
+// drawn into: gray for single-sample images, RGBA otherwise
+func (l *level) newCanvas(w, h int) image.Image {
+	if l.samples == 1 {
+		return image.NewGray(image.Rect(0, 0, w, h))
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+// readTile reads and decodes the tile at column tx and row ty.  The returned
+// image's bounds start at 0,0, and edge tiles are returned at the full tile
+// size.
+func (l *level) readTile(r io.ReaderAt, tx, ty int) (image.Image, error) {
+	var idx = ty*l.tilesAcross() + tx
+	var rect = image.Rect(0, 0, l.tileW, l.tileH)
+	var count = l.counts[idx]
+	if count == 0 {
+		// Sparse files may omit tiles entirely
+		return l.newCanvas(l.tileW, l.tileH), nil
+	}
+	if count > maxFieldSize {
+		return nil, fmt.Errorf("tile %d is too large (%d bytes)", idx, count)
+	}
+
+	var data = make([]byte, count)
+	if _, err := r.ReadAt(data, int64(l.offsets[idx])); err != nil {
+		return nil, fmt.Errorf("unable to read tile %d: %s", idx, err)
+	}
+
+	if l.compression == cJPEG {
+		var m, err = jpeg.Decode(bytes.NewReader(l.jpegStream(data)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode JPEG tile %d: %s", idx, err)
+		}
+		return m, nil
+	}
+
+	var pix, err = l.decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress tile %d: %s", idx, err)
+	}
+	if l.predictor == prHorizontal {
+		l.undoPredictor(pix)
+	}
+
+	var rowLen = l.tileW * l.samples
+	if l.samples == 1 {
+		if l.photometric == pWhiteIsZero {
+			for i := range pix {
+				pix[i] = 255 - pix[i]
+			}
+		}
+		return &image.Gray{Pix: pix, Stride: rowLen, Rect: rect}, nil
+	}
+
+	var m = &image.RGBA{Pix: make([]byte, l.tileW*l.tileH*4), Stride: l.tileW * 4, Rect: rect}
+	for i, o := 0, 0; o < len(m.Pix); i, o = i+l.samples, o+4 {
+		m.Pix[o], m.Pix[o+1], m.Pix[o+2], m.Pix[o+3] = pix[i], pix[i+1], pix[i+2], 255
+	}
+	return m, nil
+}
+
+// decompress returns a tile's raw samples.  Short tiles are zero-padded to
+// the full tile size rather than treated as errors, as some writers truncate
+// edge tiles.
+func (l *level) decompress(data []byte) ([]byte, error) {
+	var size = l.tileW * l.tileH * l.samples
+	var rdr io.Reader
+	switch l.compression {
+	case cNone:
+		rdr = bytes.NewReader(data)
+	case cLZW:
+		var lr = lzw.NewReader(bytes.NewReader(data), lzw.MSB, 8)
+		defer lr.Close()
+		rdr = lr
+	case cDeflate, cDeflateOld:
+		var zr, err = zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		rdr = zr
+	}
+
+	var pix, err = ioutil.ReadAll(io.LimitReader(rdr, int64(size)))
+	if err != nil && len(pix) == 0 {
+		return nil, err
+	}
+	if len(pix) < size {
+		pix = append(pix, make([]byte, size-len(pix))...)
+	}
+	return pix, nil
+}
+
+// undoPredictor reverses horizontal differencing in place
+func (l *level) undoPredictor(pix []byte) {
+	var rowLen = l.tileW * l.samples
+	for row := 0; row+rowLen <= len(pix); row += rowLen {
+		for i := row + l.samples; i < row+rowLen; i++ {
+			pix[i] += pix[i-l.samples]
+		}
+	}
+}
+
+// jpegStream returns a complete JPEG stream for a tile, merging in the
+// level's shared tables if it has them.  The tables are their own JPEG
+// "abbreviated" stream, so we drop its EOI and the tile's SOI.
+func (l *level) jpegStream(data []byte) []byte {
+	var n = len(l.jpegTables)
+	if n < 4 || len(data) < 2 {
+		return data
+	}
+	var merged = make([]byte, 0, n-2+len(data)-2)
+	merged = append(merged, l.jpegTables[:n-2]...)
+	return append(merged, data[2:]...)
+}
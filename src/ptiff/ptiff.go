@@ -0,0 +1,268 @@
+// Package ptiff decodes tiled, pyramidal TIFFs a region at a time.  Like the
+// JP2 decoder, it reads only the tiles a request needs, from the smallest
+// resolution level that can satisfy it, so tiles from huge TIFFs are about as
+// cheap as tiles from JP2s.
+//
+// Only the common layouts are handled: 8-bit gray, RGB, or RGBA, stored in
+// chunky tiles which are uncompressed or compressed with LZW, Deflate, or
+// JPEG.  Reduced-resolution levels may be further IFDs in the main chain (as
+// libvips writes them) or SubIFDs of the first image.  Anything else is
+// reported as unsupported so another decoder can try the file.
+package ptiff
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/nfnt/resize"
+)
+
+// Errors returned by Open for files this package won't decode.  Callers
+// should let another decoder try these.
+var (
+	errNotTIFF     = errors.New("not a TIFF file")
+	errNotTiled    = errors.New("TIFF isn't tiled")
+	errUnsupported = errors.New("unsupported TIFF layout")
+)
+
+// IsNotHandled returns true if err means the file isn't a TIFF this package
+// can decode, as opposed to a TIFF which is damaged or unreadable
+func IsNotHandled(err error) bool {
+	return err == errNotTIFF || err == errNotTiled || err == errUnsupported
+}
+
+// Image is a tiled TIFF, set up for decoding a region at a time
+type Image struct {
+	r            io.ReaderAt
+	levels       []*level
+	dpi          float64
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+}
+
+// Open reads the TIFF's structure and returns a decode-ready Image.  Tiles
+// are read from r as they're needed, so it must stay readable for as long as
+// the Image is used.
+func Open(r io.ReaderAt) (*Image, error) {
+	var h, err = readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the main IFD chain, then the first image's SubIFDs
+	var ifds []ifd
+	var seen = make(map[uint64]bool)
+	for off := h.first; off != 0 && !seen[off] && len(ifds) < maxIFDs; {
+		seen[off] = true
+		var d ifd
+		d, off, err = h.readIFD(r, off)
+		if err != nil {
+			return nil, err
+		}
+		ifds = append(ifds, d)
+	}
+	if len(ifds) == 0 {
+		return nil, errNotTIFF
+	}
+	var subs, _ = h.values(ifds[0], tSubIFDs)
+	for _, off := range subs {
+		if seen[off] || len(ifds) >= maxIFDs {
+			continue
+		}
+		seen[off] = true
+		var d, _, err = h.readIFD(r, off)
+		if err != nil {
+			return nil, err
+		}
+		ifds = append(ifds, d)
+	}
+
+	var main *level
+	main, err = h.parseLevel(ifds[0])
+	if err != nil {
+		return nil, err
+	}
+	var i = &Image{r: r, levels: []*level{main}, dpi: h.dpi(ifds[0])}
+	// Any other tiled IFD which is a smaller copy of the main image is taken
+	// as a level.  Not every writer marks levels as reduced-resolution images
+	// (slide scanners generally don't), but masks are skipped.
+	for _, d := range ifds[1:] {
+		if h.value(d, tNewSubfileType, 0)&subfileMask != 0 {
+			continue
+		}
+		var l, err = h.parseLevel(d)
+		if err == nil && l.samples == main.samples && l.width < main.width && sameAspect(main, l) {
+			i.levels = append(i.levels, l)
+		}
+	}
+	sort.SliceStable(i.levels, func(a, b int) bool { return i.levels[a].width > i.levels[b].width })
+
+	return i, nil
+}
+
+// sameAspect returns true if l is a reduction of main rather than some other
+// image, such as the label and macro images in slide scans.  Writers round
+// reduced dimensions differently, so there's a little slack.
+func sameAspect(main, l *level) bool {
+	var want = float64(main.height) * float64(l.width) / float64(main.width)
+	return math.Abs(want-float64(l.height)) <= math.Max(2, want/100)
+}
+
+// dpi reads the image's horizontal resolution, returning zero if it isn't
+// set or has no unit
+func (h *header) dpi(d ifd) float64 {
+	var res, err = h.values(d, tXResolution)
+	if err != nil || len(res) < 2 || res[1] == 0 {
+		return 0
+	}
+	var v = float64(res[0]) / float64(res[1])
+	switch h.value(d, tResolutionUnit, resInch) {
+	case resInch:
+		return v
+	case resCM:
+		return v * 2.54
+	}
+	return 0
+}
+
+// GetWidth returns the full-resolution image width
+func (i *Image) GetWidth() int {
+	return i.levels[0].width
+}
+
+// GetHeight returns the full-resolution image height
+func (i *Image) GetHeight() int {
+	return i.levels[0].height
+}
+
+// GetTileWidth returns the full-resolution level's tile width
+func (i *Image) GetTileWidth() int {
+	return i.levels[0].tileW
+}
+
+// GetTileHeight returns the full-resolution level's tile height
+func (i *Image) GetTileHeight() int {
+	return i.levels[0].tileH
+}
+
+// GetLevels returns the number of resolution levels which halve the one
+// before, as JP2 levels do.  Other levels are still used for decoding, but
+// they can't be advertised as IIIF sizes.
+func (i *Image) GetLevels() int {
+	var n = 1
+	for ; n < len(i.levels); n++ {
+		var scale = 1 << uint(n)
+		var w = (i.levels[0].width + scale - 1) / scale
+		if i.levels[n].width < w-1 || i.levels[n].width > w+1 {
+			break
+		}
+	}
+	return n
+}
+
+// DPI returns the resolution recorded in the TIFF, or zero if there isn't one
+func (i *Image) DPI() float64 {
+	return i.dpi
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image crop area for decoding an image
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// computeDecodeParameters sets up decode area, decode width, and decode height
+// based on the image's info
+func (i *Image) computeDecodeParameters() {
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, i.GetWidth(), i.GetHeight())
+	}
+
+	if i.decodeWidth == 0 && i.decodeHeight == 0 {
+		i.decodeWidth = i.decodeArea.Dx()
+		i.decodeHeight = i.decodeArea.Dy()
+	}
+}
+
+// chooseLevel returns the smallest level which still has at least as many
+// pixels in the decode area as the output needs
+func (i *Image) chooseLevel() *level {
+	for n := len(i.levels) - 1; n > 0; n-- {
+		var l = i.levels[n]
+		var sx = float64(l.width) / float64(i.GetWidth())
+		var sy = float64(l.height) / float64(i.GetHeight())
+		if float64(i.decodeArea.Dx())*sx >= float64(i.decodeWidth) && float64(i.decodeArea.Dy())*sy >= float64(i.decodeHeight) {
+			return l
+		}
+	}
+	return i.levels[0]
+}
+
+// DecodeImage returns an image.Image that holds the decoded image data,
+// cropped and resized as requested.  SetResizeWH and SetCrop must be called
+// before this function.
+func (i *Image) DecodeImage() (image.Image, error) {
+	i.computeDecodeParameters()
+	var l = i.chooseLevel()
+
+	// Map the crop onto the chosen level, rounding outward
+	var sx = float64(l.width) / float64(i.GetWidth())
+	var sy = float64(l.height) / float64(i.GetHeight())
+	var a = i.decodeArea
+	var r = image.Rect(
+		int(math.Floor(float64(a.Min.X)*sx)),
+		int(math.Floor(float64(a.Min.Y)*sy)),
+		int(math.Ceil(float64(a.Max.X)*sx)),
+		int(math.Ceil(float64(a.Max.Y)*sy)),
+	).Intersect(l.bounds())
+	if r.Empty() {
+		return nil, errors.New("crop area is outside the image")
+	}
+
+	var canvas = l.newCanvas(r.Dx(), r.Dy())
+	for ty := r.Min.Y / l.tileH; ty <= (r.Max.Y-1)/l.tileH; ty++ {
+		for tx := r.Min.X / l.tileW; tx <= (r.Max.X-1)/l.tileW; tx++ {
+			var tile, err = l.readTile(i.r, tx, ty)
+			if err != nil {
+				return nil, err
+			}
+			var origin = image.Pt(tx*l.tileW, ty*l.tileH)
+			var tr = tile.Bounds().Sub(tile.Bounds().Min).Add(origin).Intersect(r)
+			paste(canvas, tr.Sub(r.Min), tile, tr.Min.Sub(origin).Add(tile.Bounds().Min))
+		}
+	}
+
+	var img = canvas
+	if i.decodeWidth != r.Dx() || i.decodeHeight != r.Dy() {
+		img = resize.Resize(uint(i.decodeWidth), uint(i.decodeHeight), img, resize.Bilinear)
+	}
+	return img, nil
+}
+
+// paste copies src, starting at sp, into the dr rectangle of dst.  Gray tiles
+// are copied row by row, since the draw package has no fast path for them.
+func paste(dst image.Image, dr image.Rectangle, src image.Image, sp image.Point) {
+	var dg, dok = dst.(*image.Gray)
+	var sg, sok = src.(*image.Gray)
+	if dok && sok {
+		for y := 0; y < dr.Dy(); y++ {
+			var d = dg.Pix[dg.PixOffset(dr.Min.X, dr.Min.Y+y):]
+			var s = sg.Pix[sg.PixOffset(sp.X, sp.Y+y):]
+			copy(d[:dr.Dx()], s[:dr.Dx()])
+		}
+		return
+	}
+	draw.Draw(dst.(draw.Image), dr, src, sp, draw.Src)
+}
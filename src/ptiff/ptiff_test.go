@@ -0,0 +1,316 @@
+package ptiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"golang.org/x/image/tiff"
+)
+
+type entry struct {
+	tag  uint16
+	typ  uint16
+	vals []uint64
+}
+
+// layout describes a test TIFF to build
+type layout struct {
+	big         bool
+	subIFDs     bool
+	compression uint64
+	predictor   bool
+	gray        bool
+	tile        int
+}
+
+// encodeTile returns the stored bytes for one tile of m at origin
+func (lo layout) encodeTile(m image.Image, origin image.Point) []byte {
+	var samples = 3
+	if lo.gray {
+		samples = 1
+	}
+	if lo.compression == cJPEG {
+		var sub = image.NewRGBA(image.Rect(0, 0, lo.tile, lo.tile))
+		for y := 0; y < lo.tile; y++ {
+			for x := 0; x < lo.tile; x++ {
+				sub.Set(x, y, m.At(origin.X+x, origin.Y+y))
+			}
+		}
+		var buf = new(bytes.Buffer)
+		jpeg.Encode(buf, sub, &jpeg.Options{Quality: 100})
+		return buf.Bytes()
+	}
+
+	var raw []byte
+	for y := 0; y < lo.tile; y++ {
+		var row = make([]byte, lo.tile*samples)
+		for x := 0; x < lo.tile; x++ {
+			var c = color.RGBAModel.Convert(m.At(origin.X+x, origin.Y+y)).(color.RGBA)
+			if lo.gray {
+				row[x] = c.R
+			} else {
+				row[x*3], row[x*3+1], row[x*3+2] = c.R, c.G, c.B
+			}
+		}
+		if lo.predictor {
+			for i := len(row) - 1; i >= samples; i-- {
+				row[i] -= row[i-samples]
+			}
+		}
+		raw = append(raw, row...)
+	}
+	if lo.compression == cDeflate {
+		var buf = new(bytes.Buffer)
+		var zw = zlib.NewWriter(buf)
+		zw.Write(raw)
+		zw.Close()
+		return buf.Bytes()
+	}
+	return raw
+}
+
+// build writes a little-endian tiled TIFF with one level per image
+func (lo layout) build(levels []image.Image) []byte {
+	var bo = binary.LittleEndian
+	var out = new(bytes.Buffer)
+	var headerLen = 8
+	if lo.big {
+		headerLen = 16
+	}
+	out.Write(make([]byte, headerLen))
+
+	// Tile data goes first, so its offsets are known when the IFDs are built
+	var ifds [][]entry
+	for n, m := range levels {
+		var b = m.Bounds()
+		var offsets, counts []uint64
+		for y := 0; y < b.Dy(); y += lo.tile {
+			for x := 0; x < b.Dx(); x += lo.tile {
+				var data = lo.encodeTile(m, image.Pt(x, y))
+				offsets = append(offsets, uint64(out.Len()))
+				counts = append(counts, uint64(len(data)))
+				out.Write(data)
+				if out.Len()%2 == 1 {
+					out.WriteByte(0)
+				}
+			}
+		}
+
+		var photometric, bits = uint64(pRGB), []uint64{8, 8, 8}
+		if lo.gray {
+			photometric, bits = pBlackIsZero, []uint64{8}
+		}
+		if lo.compression == cJPEG && !lo.gray {
+			photometric = pYCbCr
+		}
+		var subfile uint64
+		if n > 0 {
+			subfile = 1
+		}
+		var predictor uint64 = prNone
+		if lo.predictor {
+			predictor = prHorizontal
+		}
+		ifds = append(ifds, []entry{
+			{tNewSubfileType, dtLong, []uint64{subfile}},
+			{tImageWidth, dtLong, []uint64{uint64(b.Dx())}},
+			{tImageLength, dtLong, []uint64{uint64(b.Dy())}},
+			{tBitsPerSample, dtShort, bits},
+			{tCompression, dtShort, []uint64{lo.compression}},
+			{tPhotometricInterpretation, dtShort, []uint64{photometric}},
+			{tSamplesPerPixel, dtShort, []uint64{uint64(len(bits))}},
+			{tXResolution, dtRational, []uint64{300, 1}},
+			{tResolutionUnit, dtShort, []uint64{resInch}},
+			{tPredictor, dtShort, []uint64{predictor}},
+			{tTileWidth, dtLong, []uint64{uint64(lo.tile)}},
+			{tTileLength, dtLong, []uint64{uint64(lo.tile)}},
+			{tTileOffsets, dtLong, offsets},
+			{tTileByteCounts, dtLong, counts},
+		})
+	}
+	if lo.subIFDs && len(ifds) > 1 {
+		ifds[0] = append(ifds[0], entry{tSubIFDs, dtIFD, make([]uint64, len(ifds)-1)})
+	}
+
+	// IFD sizes don't depend on the values in them, so positions can be
+	// assigned before serializing
+	var positions = make([]uint64, len(ifds))
+	var pos = uint64(out.Len())
+	for n, d := range ifds {
+		positions[n] = pos
+		pos += uint64(len(lo.serialize(bo, d, pos, 0)))
+	}
+	if lo.subIFDs && len(ifds) > 1 {
+		copy(ifds[0][len(ifds[0])-1].vals, positions[1:])
+	}
+	for n, d := range ifds {
+		var next uint64
+		if n+1 < len(ifds) && !lo.subIFDs {
+			next = positions[n+1]
+		}
+		out.Write(lo.serialize(bo, d, positions[n], next))
+	}
+
+	var data = out.Bytes()
+	copy(data, "II")
+	if lo.big {
+		bo.PutUint16(data[2:], 43)
+		bo.PutUint16(data[4:], 8)
+		bo.PutUint64(data[8:], positions[0])
+	} else {
+		bo.PutUint16(data[2:], 42)
+		bo.PutUint32(data[4:], uint32(positions[0]))
+	}
+	return data
+}
+
+// serialize returns an IFD written at pos, followed by its out-of-line data
+func (lo layout) serialize(bo binary.ByteOrder, d []entry, pos, next uint64) []byte {
+	var countSize, entrySize, offSize = 2, 12, 4
+	if lo.big {
+		countSize, entrySize, offSize = 8, 20, 8
+	}
+	var put = func(b []byte, v uint64, size int) {
+		switch size {
+		case 2:
+			bo.PutUint16(b, uint16(v))
+		case 4:
+			bo.PutUint32(b, uint32(v))
+		case 8:
+			bo.PutUint64(b, v)
+		}
+	}
+
+	var dir = make([]byte, countSize+entrySize*len(d)+offSize)
+	put(dir, uint64(len(d)), countSize)
+	var extra []byte
+	for i, e := range d {
+		var p = dir[countSize+entrySize*i:]
+		var size = int(typeSizes[e.typ])
+		var count = len(e.vals)
+		var valSize = size
+		if e.typ == dtRational {
+			count /= 2
+			valSize = 4
+		}
+		bo.PutUint16(p, e.tag)
+		bo.PutUint16(p[2:], e.typ)
+		put(p[4:], uint64(count), offSize)
+
+		var data = make([]byte, valSize*len(e.vals))
+		for j, v := range e.vals {
+			put(data[j*valSize:], v, valSize)
+		}
+		if len(data) <= offSize {
+			copy(p[4+offSize:], data)
+			continue
+		}
+		put(p[4+offSize:], pos+uint64(len(dir)+len(extra)), offSize)
+		extra = append(extra, data...)
+	}
+	put(dir[len(dir)-offSize:], next, offSize)
+	return append(dir, extra...)
+}
+
+// testLevels returns a 500x300 image and two halvings of it.  Each level's
+// blue channel is a different constant so tests can tell which level was
+// decoded.
+func testLevels() []image.Image {
+	var levels []image.Image
+	for n, w := range []int{500, 250, 125} {
+		var h = w * 3 / 5
+		var m = image.NewRGBA(image.Rect(0, 0, w, h))
+		var scale = 500 / w
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				m.SetRGBA(x, y, color.RGBA{uint8(x * scale / 2), uint8(y * scale / 2), uint8(n * 100), 255})
+			}
+		}
+		levels = append(levels, m)
+	}
+	return levels
+}
+
+func TestLayouts(t *testing.T) {
+	var levels = testLevels()
+	for name, lo := range map[string]layout{
+		"none":      {compression: cNone, tile: 64},
+		"deflate":   {compression: cDeflate, predictor: true, tile: 64},
+		"bigtiff":   {big: true, compression: cNone, tile: 128},
+		"subifds":   {subIFDs: true, compression: cDeflate, tile: 64},
+		"big+sub":   {big: true, subIFDs: true, compression: cNone, tile: 32},
+		"jpeg":      {compression: cJPEG, tile: 64},
+		"gray":      {compression: cDeflate, predictor: true, gray: true, tile: 64},
+		"odd tiles": {compression: cNone, tile: 48},
+	} {
+		var i, err = Open(bytes.NewReader(lo.build(levels)))
+		assert.NilError(err, name+": opening", t)
+		assert.Equal(500, i.GetWidth(), name+": width", t)
+		assert.Equal(300, i.GetHeight(), name+": height", t)
+		assert.Equal(lo.tile, i.GetTileWidth(), name+": tile width", t)
+		assert.Equal(3, i.GetLevels(), name+": levels", t)
+		assert.Equal(300.0, i.DPI(), name+": DPI", t)
+
+		// A full-resolution region spanning several tiles
+		i.SetCrop(image.Rect(100, 50, 300, 170))
+		i.SetResizeWH(200, 120)
+		var m image.Image
+		m, err = i.DecodeImage()
+		assert.NilError(err, name+": decoding a region", t)
+		assert.Equal(image.Pt(200, 120), m.Bounds().Size(), name+": region size", t)
+		var want = levels[0].At(250, 160)
+		if lo.gray {
+			want = color.Gray{levels[0].(*image.RGBA).RGBAAt(250, 160).R}
+		}
+		var wr, wg, wb, _ = want.RGBA()
+		var gr, gg, gb, _ = m.At(150, 110).RGBA()
+		var close = func(a, b uint32) bool { return a>>8 <= b>>8+3 && b>>8 <= a>>8+3 }
+		assert.True(close(wr, gr) && close(wg, gg) && close(wb, gb), name+": region pixel", t)
+
+		// Scaling the whole image down should read the smallest level
+		i.SetCrop(image.Rect(0, 0, 500, 300))
+		i.SetResizeWH(125, 75)
+		m, err = i.DecodeImage()
+		assert.NilError(err, name+": decoding a reduced image", t)
+		assert.Equal(image.Pt(125, 75), m.Bounds().Size(), name+": reduced size", t)
+		if !lo.gray {
+			var _, _, b, _ = m.At(10, 10).RGBA()
+			assert.True(close(b, 200<<8), name+": reduced image comes from the smallest level", t)
+		}
+
+		// A size between levels reads the next level up and resizes
+		i.SetResizeWH(200, 120)
+		m, err = i.DecodeImage()
+		assert.NilError(err, name+": decoding an in-between size", t)
+		if !lo.gray {
+			var _, _, b, _ = m.At(10, 10).RGBA()
+			assert.True(close(b, 100<<8), name+": in-between size comes from the middle level", t)
+		}
+	}
+}
+
+func TestNotHandled(t *testing.T) {
+	var buf = new(bytes.Buffer)
+	tiff.Encode(buf, image.NewGray(image.Rect(0, 0, 50, 50)), nil)
+	var _, err = Open(bytes.NewReader(buf.Bytes()))
+	assert.True(IsNotHandled(err), "strip TIFFs aren't handled", t)
+
+	_, err = Open(bytes.NewReader([]byte("not a tiff at all")))
+	assert.True(IsNotHandled(err), "non-TIFFs aren't handled", t)
+}
+
+func TestJPEGTables(t *testing.T) {
+	var l = &level{jpegTables: []byte{0xff, 0xd8, 1, 2, 3, 0xff, 0xd9}}
+	var got = l.jpegStream([]byte{0xff, 0xd8, 9, 9, 0xff, 0xd9})
+	assert.True(bytes.Equal([]byte{0xff, 0xd8, 1, 2, 3, 9, 9, 0xff, 0xd9}, got), "tables are merged into the tile's stream", t)
+
+	l.jpegTables = nil
+	got = l.jpegStream([]byte{0xff, 0xd8, 9, 9, 0xff, 0xd9})
+	assert.True(bytes.Equal([]byte{0xff, 0xd8, 9, 9, 0xff, 0xd9}, got), "tiles without shared tables are left alone", t)
+}
@@ -0,0 +1,91 @@
+package rangeio
+
+import (
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Cache holds recently read blocks of remote files, along with the files'
+// metadata so that opening a recently read file needn't make a request
+type Cache struct {
+	blocks  *lru.Cache
+	metas   *lru.Cache
+	metaTTL time.Duration
+}
+
+// meta is a remote file's cached metadata
+type meta struct {
+	size    int64
+	modTime time.Time
+	etag    string
+	expires time.Time
+}
+
+// metaCacheLen is the number of remote files whose metadata is kept
+const metaCacheLen = 1024
+
+// NewCache returns a cache holding up to blocks blocks of remote data.
+// File metadata is reused for metaTTL, so changes to a remote file may go
+// unnoticed that long; a zero TTL checks the file every time it's opened.
+func NewCache(blocks int, metaTTL time.Duration) (*Cache, error) {
+	var c = &Cache{metaTTL: metaTTL}
+	var err error
+	c.blocks, err = lru.New(blocks)
+	if err != nil {
+		return nil, err
+	}
+	c.metas, err = lru.New(metaCacheLen)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Purge empties the cache
+func (c *Cache) Purge() {
+	c.blocks.Purge()
+	c.metas.Purge()
+}
+
+// Len returns the number of cached blocks
+func (c *Cache) Len() int {
+	return c.blocks.Len()
+}
+
+func (c *Cache) block(key string) ([]byte, bool) {
+	var v, ok = c.blocks.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+func (c *Cache) addBlock(key string, data []byte) {
+	c.blocks.Add(key, data)
+}
+
+func (c *Cache) meta(key string) (meta, bool) {
+	var v, ok = c.metas.Get(key)
+	if !ok {
+		return meta{}, false
+	}
+	var m = v.(meta)
+	if time.Now().After(m.expires) {
+		c.metas.Remove(key)
+		return meta{}, false
+	}
+	return m, true
+}
+
+func (c *Cache) addMeta(key string, m meta) {
+	if c.metaTTL <= 0 {
+		return
+	}
+	m.expires = time.Now().Add(c.metaTTL)
+	c.metas.Add(key, m)
+}
+
+func (c *Cache) removeMeta(key string) {
+	c.metas.Remove(key)
+}
@@ -0,0 +1,281 @@
+// Package rangeio reads remote files over HTTP with ranged requests, keeping
+// recently read blocks in a shared cache.  Decoders which only need part of a
+// file, such as a JP2 tile's headers and codeblocks, can then read a remote
+// source without it ever being downloaded in full.
+package rangeio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockSize is the unit remote data is fetched and cached in
+const BlockSize = 64 << 10
+
+// Errors returned when a remote file can't be read with ranged requests
+var (
+	ErrNotFound    = errors.New("remote file not found")
+	ErrNoRanges    = errors.New("remote server doesn't support range requests")
+	ErrChanged     = errors.New("remote file changed while it was being read")
+	errBadResponse = errors.New("invalid range response")
+)
+
+// IsURL returns true if path is an HTTP or HTTPS URL rather than a local path
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// Ext returns the file extension of a URL's path, ignoring any query string
+// (e.g., a presigned URL's signature)
+func Ext(rawurl string) string {
+	var u, err = url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return path.Ext(u.Path)
+}
+
+// File is a remote file read with ranged requests.  It implements io.ReaderAt
+// and is safe for concurrent use.
+type File struct {
+	client  *http.Client
+	url     string
+	key     string
+	size    int64
+	modTime time.Time
+	etag    string
+	cache   *Cache
+}
+
+// Open returns the remote file at rawurl, using client for requests and
+// cache for blocks and metadata.  Unless the file's metadata is already
+// cached, its first block is requested to learn its size and confirm range
+// support, and that block is cached for the reads which follow.
+func Open(client *http.Client, rawurl string, cache *Cache) (*File, error) {
+	var f = &File{client: client, url: rawurl, key: cacheKey(rawurl), cache: cache}
+	if m, ok := cache.meta(f.key); ok {
+		f.size, f.modTime, f.etag = m.size, m.modTime, m.etag
+		return f, nil
+	}
+
+	var resp, err = f.get(0, BlockSize-1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var total int64
+	_, _, total, err = contentRange(resp)
+	if err != nil {
+		return nil, err
+	}
+	f.size = total
+	f.etag = resp.Header.Get("ETag")
+	f.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	var data []byte
+	data, err = ioutil.ReadAll(io.LimitReader(resp.Body, BlockSize))
+	if err != nil {
+		return nil, err
+	}
+	cache.addBlock(f.blockKey(0), data)
+	cache.addMeta(f.key, meta{size: f.size, modTime: f.modTime, etag: f.etag})
+	return f, nil
+}
+
+// cacheKey identifies a remote file regardless of its query string, so
+// presigned URLs for one object share cached data
+func cacheKey(rawurl string) string {
+	if i := strings.IndexByte(rawurl, '?'); i >= 0 {
+		return rawurl[:i]
+	}
+	return rawurl
+}
+
+// get requests the inclusive byte range first-last, returning the response
+// if the server sent the range
+func (f *File) get(first, last int64) (*http.Response, error) {
+	var req, err = http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+
+	var resp *http.Response
+	resp, err = f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, nil
+	case http.StatusOK:
+		err = ErrNoRanges
+	case http.StatusNotFound, http.StatusForbidden:
+		err = ErrNotFound
+	default:
+		err = fmt.Errorf("unexpected status reading remote file: %s", resp.Status)
+	}
+	resp.Body.Close()
+	return nil, err
+}
+
+// contentRange parses a ranged response's Content-Range header
+func contentRange(resp *http.Response) (first, last, total int64, err error) {
+	var cr = resp.Header.Get("Content-Range")
+	var n, _ = fmt.Sscanf(cr, "bytes %d-%d/%d", &first, &last, &total)
+	if n != 3 || first > last || last >= total {
+		return 0, 0, 0, errBadResponse
+	}
+	return first, last, total, nil
+}
+
+// Size returns the file's size in bytes
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// Name returns the file's URL without its query string
+func (f *File) Name() string {
+	return f.key
+}
+
+// Key returns a string identifying this version of the remote file, for
+// callers which cache data derived from it
+func (f *File) Key() string {
+	return f.key + "#" + f.etag
+}
+
+// Stat returns the file's size and modification time as an os.FileInfo
+func (f *File) Stat() os.FileInfo {
+	return fileInfo{name: path.Base(f.key), size: f.size, modTime: f.modTime}
+}
+
+// blockKey returns the cache key for block n of this version of the file
+func (f *File) blockKey(n int64) string {
+	return f.Key() + "@" + strconv.FormatInt(n, 10)
+}
+
+// ReadAt implements io.ReaderAt.  Blocks which aren't cached are requested,
+// with each run of adjacent missing blocks fetched in a single request.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	var end = off + int64(len(p))
+	var short = end > f.size
+	if short {
+		end = f.size
+	}
+	if end == off {
+		return 0, nil
+	}
+
+	var first, last = off / BlockSize, (end - 1) / BlockSize
+	var blocks = make([][]byte, last-first+1)
+	for n := first; n <= last; n++ {
+		var b, ok = f.cache.block(f.blockKey(n))
+		if ok {
+			blocks[n-first] = b
+		}
+	}
+	for n := first; n <= last; n++ {
+		if blocks[n-first] != nil {
+			continue
+		}
+		var run = n
+		for run < last && blocks[run+1-first] == nil {
+			run++
+		}
+		var fetched, err = f.fetch(n, run)
+		if err != nil {
+			return 0, err
+		}
+		copy(blocks[n-first:], fetched)
+		n = run
+	}
+
+	var copied int
+	for i, b := range blocks {
+		var start int64
+		if i == 0 {
+			start = off - first*BlockSize
+		}
+		var stop = int64(len(b))
+		if limit := end - (first+int64(i))*BlockSize; limit < stop {
+			stop = limit
+		}
+		if start > stop {
+			return copied, errBadResponse
+		}
+		copied += copy(p[copied:], b[start:stop])
+	}
+	if short {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+// fetch requests blocks first through last, caching and returning them
+func (f *File) fetch(first, last int64) ([][]byte, error) {
+	var lastByte = (last+1)*BlockSize - 1
+	if lastByte >= f.size {
+		lastByte = f.size - 1
+	}
+	var resp, err = f.get(first*BlockSize, lastByte)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if etag := resp.Header.Get("ETag"); etag != "" && f.etag != "" && etag != f.etag {
+		f.cache.removeMeta(f.key)
+		return nil, ErrChanged
+	}
+	var rfirst, rlast int64
+	rfirst, rlast, _, err = contentRange(resp)
+	if err != nil || rfirst != first*BlockSize || rlast != lastByte {
+		return nil, errBadResponse
+	}
+
+	var blocks = make([][]byte, last-first+1)
+	for i := range blocks {
+		var size = int64(BlockSize)
+		if remaining := lastByte + 1 - (first+int64(i))*BlockSize; remaining < size {
+			size = remaining
+		}
+		blocks[i] = make([]byte, size)
+		if _, err = io.ReadFull(resp.Body, blocks[i]); err != nil {
+			return nil, err
+		}
+		f.cache.addBlock(f.blockKey(first+int64(i)), blocks[i])
+	}
+	return blocks, nil
+}
+
+// fileInfo is a minimal os.FileInfo for remote files
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,117 @@
+package rangeio
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// testData returns n bytes of non-repeating data
+func testData(n int) []byte {
+	var data = make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	return data
+}
+
+// server serves data with range support, counting requests
+func server(data []byte, etag string, requests *int32) *httptest.Server {
+	var mod = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if req.URL.Path != "/img.jp2" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, req, "img.jp2", mod, bytes.NewReader(data))
+	}))
+}
+
+func TestReadAt(t *testing.T) {
+	var data = testData(BlockSize*3 + 100)
+	var requests int32
+	var s = server(data, `"v1"`, &requests)
+	defer s.Close()
+
+	var c, _ = NewCache(16, time.Minute)
+	var f, err = Open(s.Client(), s.URL+"/img.jp2?sig=abc", c)
+	assert.NilError(err, "open", t)
+	assert.Equal(int64(len(data)), f.Size(), "size", t)
+	assert.Equal(2020, f.Stat().ModTime().Year(), "mod time", t)
+	assert.Equal(int32(1), requests, "open requests the first block", t)
+
+	var buf = make([]byte, 10)
+	var n int
+	n, err = f.ReadAt(buf, 5)
+	assert.NilError(err, "read from the first block", t)
+	assert.True(bytes.Equal(data[5:15], buf[:n]), "first block data", t)
+	assert.Equal(int32(1), requests, "first block is cached", t)
+
+	// Spanning the second, third, and partial fourth block takes one request
+	buf = make([]byte, BlockSize*2+50)
+	n, err = f.ReadAt(buf, BlockSize+20)
+	assert.NilError(err, "spanning read", t)
+	assert.True(bytes.Equal(data[BlockSize+20:BlockSize*3+70], buf[:n]), "spanning data", t)
+	assert.Equal(int32(2), requests, "missing blocks are fetched together", t)
+
+	n, err = f.ReadAt(make([]byte, 200), int64(len(data)-50))
+	assert.Equal(io.EOF, err, "reads past the end are short", t)
+	assert.Equal(50, n, "short read length", t)
+	assert.Equal(int32(2), requests, "last block was already cached", t)
+
+	// Reopening with a different query string uses the cached metadata
+	f, err = Open(s.Client(), s.URL+"/img.jp2?sig=def", c)
+	assert.NilError(err, "reopen", t)
+	f.ReadAt(buf, BlockSize+20)
+	assert.Equal(int32(2), requests, "reopened file reads from cache", t)
+}
+
+func TestOpenErrors(t *testing.T) {
+	var requests int32
+	var s = server(testData(10), `"v1"`, &requests)
+	defer s.Close()
+	var c, _ = NewCache(16, 0)
+
+	var _, err = Open(s.Client(), s.URL+"/missing.jp2", c)
+	assert.Equal(ErrNotFound, err, "missing file", t)
+
+	var noRanges = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("whole file"))
+	}))
+	defer noRanges.Close()
+	_, err = Open(noRanges.Client(), noRanges.URL+"/img.jp2", c)
+	assert.Equal(ErrNoRanges, err, "server without range support", t)
+}
+
+func TestChanged(t *testing.T) {
+	var data = testData(BlockSize * 2)
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", etag.Load().(string))
+		http.ServeContent(w, req, "img.jp2", time.Time{}, bytes.NewReader(data))
+	}))
+	defer s.Close()
+
+	var c, _ = NewCache(16, time.Minute)
+	var f, _ = Open(s.Client(), s.URL+"/img.jp2", c)
+	etag.Store(`"v2"`)
+	var _, err = f.ReadAt(make([]byte, 10), BlockSize)
+	assert.Equal(ErrChanged, err, "changed file", t)
+	var _, ok = c.meta(f.key)
+	assert.False(ok, "changed file's metadata is dropped", t)
+}
+
+func TestExt(t *testing.T) {
+	assert.Equal(".jp2", Ext("https://bucket.example.com/path/img.jp2?X-Amz-Signature=abc.def"), "presigned URL", t)
+	assert.True(IsURL("https://example.com/img.jp2"), "https URL", t)
+	assert.False(IsURL("/var/local/images/img.jp2"), "local path", t)
+}
@@ -0,0 +1,37 @@
+package stdimage
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// OpenGIF reads a GIF's header and returns a decode-ready Image.  Only the
+// first frame of an animated GIF is served.
+func OpenGIF(r io.ReaderAt) (*Image, error) {
+	var cfg, err = gif.DecodeConfig(newReader(r))
+	if err != nil {
+		return nil, err
+	}
+	return &Image{r: r, decode: decodeGIF, width: cfg.Width, height: cfg.Height}, nil
+}
+
+// decodeGIF returns a GIF's first frame.  Frames needn't cover the whole
+// logical screen the header's dimensions describe, so a smaller frame is
+// placed on a transparent canvas of the full size.
+func decodeGIF(r io.Reader) (image.Image, error) {
+	var g, err = gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var frame = g.Image[0]
+	var screen = image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if frame.Bounds() == screen {
+		return frame, nil
+	}
+	var m = image.NewRGBA(screen)
+	draw.Draw(m, frame.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	return m, nil
+}
@@ -0,0 +1,52 @@
+package stdimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"io"
+)
+
+// jfifID is the identifier which starts a JFIF APP0 segment's data
+var jfifID = []byte("JFIF\x00")
+
+// OpenJPEG reads a JPEG's header and returns a decode-ready Image.  Go's JPEG
+// decoder has no DCT scaling, so the full image is decoded for every request;
+// large JPEG masters are much better served converted to JP2 or tiled TIFF.
+func OpenJPEG(r io.ReaderAt) (*Image, error) {
+	var br = newReader(r)
+	var header, _ = br.Peek(20)
+	var cfg, err = jpeg.DecodeConfig(br)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		r:      r,
+		decode: jpeg.Decode,
+		width:  cfg.Width,
+		height: cfg.Height,
+		dpi:    jfifDPI(header),
+	}, nil
+}
+
+// jfifDPI reads the density from a JFIF APP0 segment immediately following
+// the SOI marker, returning zero if there isn't one or it has no units
+func jfifDPI(header []byte) float64 {
+	// SOI, APP0 marker and length, identifier, version, units, X density
+	if len(header) < 16 || header[0] != 0xFF || header[1] != 0xD8 || header[2] != 0xFF || header[3] != 0xE0 {
+		return 0
+	}
+	if !bytes.Equal(header[6:11], jfifID) {
+		return 0
+	}
+
+	var density = float64(binary.BigEndian.Uint16(header[14:]))
+	switch header[13] {
+	case 1:
+		return density
+	case 2:
+		return density * 2.54
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package stdimage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// testJPEG encodes a 200x100 image, left half black and right half white,
+// with an optional JFIF segment holding the given units and density
+func testJPEG(units byte, density uint16) *bytes.Reader {
+	var m = image.NewGray(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			m.SetGray(x, y, color.Gray{255})
+		}
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, m, &jpeg.Options{Quality: 95})
+
+	var data = buf.Bytes()
+	if units != 0 {
+		var app0 = []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 2, units,
+			byte(density >> 8), byte(density), byte(density >> 8), byte(density), 0, 0}
+		data = append(append(append([]byte{}, data[:2]...), app0...), data[2:]...)
+	}
+	return bytes.NewReader(data)
+}
+
+func TestOpenJPEG(t *testing.T) {
+	var i, err = OpenJPEG(testJPEG(0, 0))
+	assert.NilError(err, "open", t)
+	assert.Equal(200, i.GetWidth(), "width", t)
+	assert.Equal(100, i.GetHeight(), "height", t)
+	assert.Equal(0.0, i.DPI(), "no JFIF density", t)
+
+	i, _ = OpenJPEG(testJPEG(1, 300))
+	assert.Equal(300.0, i.DPI(), "dots per inch", t)
+	i, _ = OpenJPEG(testJPEG(2, 100))
+	assert.Equal(254.0, i.DPI(), "dots per centimeter", t)
+	i, _ = OpenJPEG(testJPEG(3, 72))
+	assert.Equal(0.0, i.DPI(), "aspect ratio only", t)
+
+	_, err = OpenJPEG(bytes.NewReader([]byte("not a jpeg")))
+	assert.True(err != nil, "invalid JPEG", t)
+}
+
+func TestDecodeCropResize(t *testing.T) {
+	var i, _ = OpenJPEG(testJPEG(0, 0))
+
+	var m, err = i.DecodeImage()
+	assert.NilError(err, "full decode", t)
+	assert.Equal(image.Rect(0, 0, 200, 100), m.Bounds(), "full size", t)
+
+	i, _ = OpenJPEG(testJPEG(0, 0))
+	i.SetCrop(image.Rect(120, 0, 200, 100))
+	i.SetResizeWH(40, 50)
+	m, err = i.DecodeImage()
+	assert.NilError(err, "crop and resize", t)
+	assert.Equal(40, m.Bounds().Dx(), "resized width", t)
+	assert.Equal(50, m.Bounds().Dy(), "resized height", t)
+	var r, _, _, _ = m.At(m.Bounds().Min.X+20, m.Bounds().Min.Y+25).RGBA()
+	assert.True(r > 0xF000, "cropped region is from the white half", t)
+}
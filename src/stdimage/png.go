@@ -0,0 +1,57 @@
+package stdimage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image/png"
+	"io"
+)
+
+// pngSignature starts every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// OpenPNG reads a PNG's header and returns a decode-ready Image
+func OpenPNG(r io.ReaderAt) (*Image, error) {
+	var cfg, err = png.DecodeConfig(newReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	var i = &Image{r: r, decode: png.Decode, width: cfg.Width, height: cfg.Height}
+	i.dpi = pngDPI(newReader(r))
+	return i, nil
+}
+
+// pngDPI walks the PNG's chunks up to the image data looking for a pHYs
+// chunk, returning its horizontal resolution in dots per inch, or zero if
+// there isn't one or its unit is unknown
+func pngDPI(r *bufio.Reader) float64 {
+	if _, err := r.Discard(len(pngSignature)); err != nil {
+		return 0
+	}
+
+	var head = make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			return 0
+		}
+		var length = int(binary.BigEndian.Uint32(head))
+		switch string(head[4:]) {
+		case "IDAT", "IEND":
+			return 0
+		case "pHYs":
+			var data = make([]byte, 9)
+			if length != len(data) {
+				return 0
+			}
+			if _, err := io.ReadFull(r, data); err != nil || data[8] != 1 {
+				return 0
+			}
+			return float64(binary.BigEndian.Uint32(data)) * 0.0254
+		}
+		// Skip the chunk's data and CRC
+		if _, err := r.Discard(length + 4); err != nil {
+			return 0
+		}
+	}
+}
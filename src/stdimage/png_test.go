@@ -0,0 +1,87 @@
+package stdimage
+
+import (
+	"bytes"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// withPHYs inserts a pHYs chunk after a PNG's IHDR chunk
+func withPHYs(data []byte, ppu uint32, unit byte) []byte {
+	var chunk = []byte{0, 0, 0, 9, 'p', 'H', 'Y', 's',
+		byte(ppu >> 24), byte(ppu >> 16), byte(ppu >> 8), byte(ppu),
+		byte(ppu >> 24), byte(ppu >> 16), byte(ppu >> 8), byte(ppu), unit}
+	var crc = crc32.ChecksumIEEE(chunk[4:])
+	chunk = append(chunk, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	// Signature (8) plus IHDR's length, type, data, and CRC (25)
+	var at = len(pngSignature) + 25
+	return append(append(append([]byte{}, data[:at]...), chunk...), data[at:]...)
+}
+
+func TestOpenPNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 30, 20)))
+	var i, err = OpenPNG(bytes.NewReader(buf.Bytes()))
+	assert.NilError(err, "open", t)
+	assert.Equal(30, i.GetWidth(), "width", t)
+	assert.Equal(20, i.GetHeight(), "height", t)
+	assert.Equal(0.0, i.DPI(), "no pHYs", t)
+
+	i, err = OpenPNG(bytes.NewReader(withPHYs(buf.Bytes(), 11811, 1)))
+	assert.NilError(err, "open with pHYs", t)
+	assert.Equal(300, int(i.DPI()+0.5), "pixels per meter", t)
+	var m image.Image
+	m, err = i.DecodeImage()
+	assert.NilError(err, "decode with pHYs", t)
+	assert.Equal(image.Rect(0, 0, 30, 20), m.Bounds(), "decoded size", t)
+
+	i, _ = OpenPNG(bytes.NewReader(withPHYs(buf.Bytes(), 11811, 0)))
+	assert.Equal(0.0, i.DPI(), "aspect ratio only", t)
+}
+
+func TestOpenGIF(t *testing.T) {
+	// A 40x30 screen with a single 10x10 red frame at 20,10
+	var pal = color.Palette{color.Black, color.RGBA{255, 0, 0, 255}}
+	var frame = image.NewPaletted(image.Rect(20, 10, 30, 20), pal)
+	for i := range frame.Pix {
+		frame.Pix[i] = 1
+	}
+	var g = &gif.GIF{
+		Image:  []*image.Paletted{frame},
+		Delay:  []int{0},
+		Config: image.Config{ColorModel: pal, Width: 40, Height: 30},
+	}
+	var buf bytes.Buffer
+	gif.EncodeAll(&buf, g)
+	var i, err = OpenGIF(bytes.NewReader(buf.Bytes()))
+	assert.NilError(err, "open", t)
+	assert.Equal(40, i.GetWidth(), "width", t)
+	assert.Equal(30, i.GetHeight(), "height", t)
+
+	i.SetCrop(image.Rect(20, 10, 40, 30))
+	var m image.Image
+	m, err = i.DecodeImage()
+	assert.NilError(err, "decode", t)
+	assert.Equal(20, m.Bounds().Dx(), "cropped width", t)
+	var r, _, _, a = m.At(m.Bounds().Min.X, m.Bounds().Min.Y).RGBA()
+	assert.Equal(uint32(0xFFFF), r, "frame is placed on the screen", t)
+	_, _, _, a = m.At(m.Bounds().Max.X-1, m.Bounds().Max.Y-1).RGBA()
+	assert.Equal(uint32(0), a, "area outside the frame is transparent", t)
+}
+
+func TestIsNotHandled(t *testing.T) {
+	// SOI, then a baseline SOF with 12-bit precision
+	var deep = []byte{0xFF, 0xD8, 0xFF, 0xC0, 0, 11, 12, 0, 8, 0, 8, 1, 1, 0x11, 0}
+	var _, err = OpenJPEG(bytes.NewReader(deep))
+	assert.True(IsNotHandled(err), "12-bit JPEGs are left for other decoders", t)
+
+	_, err = OpenPNG(bytes.NewReader([]byte("not a png")))
+	assert.True(err != nil && !IsNotHandled(err), "invalid PNGs are errors", t)
+}
@@ -0,0 +1,128 @@
+// Package stdimage serves sources with Go's own image decoders, so common
+// formats don't require ImageMagick or any other cgo dependency.  These
+// formats can't be decoded by region or resolution: every request decodes the
+// whole image, then crops and resizes it.
+package stdimage
+
+import (
+	"bufio"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// IsNotHandled returns true if err means the file uses a variant of its
+// format the standard decoders don't support, such as 12-bit or arithmetic
+// coded JPEGs, so another decoder should try it
+func IsNotHandled(err error) bool {
+	switch err.(type) {
+	case jpeg.UnsupportedError, png.UnsupportedError:
+		return true
+	}
+	return false
+}
+
+// decodeFunc reads a full image from a stream
+type decodeFunc func(io.Reader) (image.Image, error)
+
+// readBufferSize is how much of a source is read at a time.  Sources may be
+// remote, or reopened for each read, so small reads are costly.
+const readBufferSize = 64 << 10
+
+// newReader returns a buffered stream of r's data from the start
+func newReader(r io.ReaderAt) *bufio.Reader {
+	return bufio.NewReaderSize(io.NewSectionReader(r, 0, math.MaxInt64), readBufferSize)
+}
+
+// Image is a source set up for decoding by a standard library decoder.  The
+// source is read again for each decode, so it must stay readable for as long
+// as the Image is used.
+type Image struct {
+	r            io.ReaderAt
+	decode       decodeFunc
+	width        int
+	height       int
+	dpi          float64
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+}
+
+// GetWidth returns the image width
+func (i *Image) GetWidth() int {
+	return i.width
+}
+
+// GetHeight returns the image height
+func (i *Image) GetHeight() int {
+	return i.height
+}
+
+// GetTileWidth returns 0 since these formats have no tiles
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0 since these formats have no tiles
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1 since these formats have a single resolution
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// DPI returns the resolution recorded in the file, or zero if there isn't one
+func (i *Image) DPI() float64 {
+	return i.dpi
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image crop area for decoding an image
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// subImager is implemented by all the standard library's image types
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// DecodeImage decodes the full image, then crops and resizes it as requested.
+// SetResizeWH and SetCrop must be called before this function.
+func (i *Image) DecodeImage() (image.Image, error) {
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, i.width, i.height)
+	}
+	if i.decodeWidth == 0 && i.decodeHeight == 0 {
+		i.decodeWidth, i.decodeHeight = i.decodeArea.Dx(), i.decodeArea.Dy()
+	}
+
+	var m, err = i.decode(newReader(i.r))
+	if err != nil {
+		return nil, err
+	}
+
+	var crop = i.decodeArea.Add(m.Bounds().Min).Intersect(m.Bounds())
+	if crop != m.Bounds() {
+		if si, ok := m.(subImager); ok {
+			m = si.SubImage(crop)
+		}
+	}
+	if m.Bounds().Dx() != i.decodeWidth || m.Bounds().Dy() != i.decodeHeight {
+		m = resize.Resize(uint(i.decodeWidth), uint(i.decodeHeight), m, resize.Bilinear)
+	}
+	return m, nil
+}
@@ -0,0 +1,134 @@
+package tiffenc
+
+import (
+	"bufio"
+	"io"
+)
+
+// TIFF's LZW uses 8-bit literals, MSB-first code packing, and, unlike GIF's
+// (and Go's compress/lzw), widens codes one code early
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwMinWidth = 9
+	lzwMaxWidth = 12
+	lzwTableMax = 4094
+)
+
+// lzwWriter compresses a single strip with TIFF's variant of LZW
+type lzwWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint
+
+	width    uint
+	hi       int
+	overflow int
+	code     int
+	started  bool
+	table    map[int]int
+}
+
+func newLZWWriter(w io.Writer) *lzwWriter {
+	var lw = &lzwWriter{w: bufio.NewWriter(w)}
+	lw.reset()
+	return lw
+}
+
+// reset clears the string table
+func (lw *lzwWriter) reset() {
+	lw.width = lzwMinWidth
+	lw.hi = lzwEOI
+	lw.overflow = 1 << lzwMinWidth
+	lw.table = make(map[int]int)
+}
+
+// put packs a code into the output at the current width
+func (lw *lzwWriter) put(code int) error {
+	lw.bits |= uint32(code) << (32 - lw.width - lw.nBits)
+	lw.nBits += lw.width
+	for lw.nBits >= 8 {
+		if err := lw.w.WriteByte(byte(lw.bits >> 24)); err != nil {
+			return err
+		}
+		lw.bits <<= 8
+		lw.nBits -= 8
+	}
+	return nil
+}
+
+// next advances to the next free code, returning false if the table was
+// full and had to be cleared instead
+func (lw *lzwWriter) next() (bool, error) {
+	lw.hi++
+	if lw.hi == lzwTableMax {
+		var err = lw.put(lzwClear)
+		lw.reset()
+		return false, err
+	}
+	if lw.hi >= lw.overflow-1 && lw.width < lzwMaxWidth {
+		lw.width++
+		lw.overflow <<= 1
+	}
+	return true, nil
+}
+
+// Write implements io.Writer
+func (lw *lzwWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n = len(p)
+	if !lw.started {
+		lw.started = true
+		if err := lw.put(lzwClear); err != nil {
+			return 0, err
+		}
+		lw.code = int(p[0])
+		p = p[1:]
+	}
+
+	for _, b := range p {
+		var key = lw.code<<8 | int(b)
+		if c, ok := lw.table[key]; ok {
+			lw.code = c
+			continue
+		}
+		if err := lw.put(lw.code); err != nil {
+			return 0, err
+		}
+		lw.code = int(b)
+		var ok, err = lw.next()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lw.table[key] = lw.hi
+		}
+	}
+	return n, nil
+}
+
+// Close writes the final code and the end-of-information code, and flushes
+// the output
+func (lw *lzwWriter) Close() error {
+	if lw.started {
+		if err := lw.put(lw.code); err != nil {
+			return err
+		}
+		if _, err := lw.next(); err != nil {
+			return err
+		}
+	} else if err := lw.put(lzwClear); err != nil {
+		return err
+	}
+	if err := lw.put(lzwEOI); err != nil {
+		return err
+	}
+	if lw.nBits > 0 {
+		if err := lw.w.WriteByte(byte(lw.bits >> 24)); err != nil {
+			return err
+		}
+	}
+	return lw.w.Flush()
+}
@@ -0,0 +1,49 @@
+package tiffenc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"golang.org/x/image/tiff/lzw"
+)
+
+func roundTrip(t *testing.T, data []byte) []byte {
+	var buf = new(bytes.Buffer)
+	var lw = newLZWWriter(buf)
+	var _, err = lw.Write(data)
+	assert.NilError(err, "writing", t)
+	assert.NilError(lw.Close(), "closing", t)
+
+	var r = lzw.NewReader(buf, lzw.MSB, 8)
+	var out, rerr = ioutil.ReadAll(r)
+	assert.NilError(rerr, "reading", t)
+	return out
+}
+
+func TestLZWRoundTrip(t *testing.T) {
+	var random = make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var zeroes = make([]byte, 100000)
+	var repeated = bytes.Repeat([]byte("the quick brown fox "), 5000)
+
+	for name, data := range map[string][]byte{
+		"empty":    {},
+		"single":   {42},
+		"random":   random,
+		"zeroes":   zeroes,
+		"repeated": repeated,
+	} {
+		assert.True(bytes.Equal(data, roundTrip(t, data)), name, t)
+	}
+}
+
+func TestLZWCompresses(t *testing.T) {
+	var buf = new(bytes.Buffer)
+	var lw = newLZWWriter(buf)
+	lw.Write(bytes.Repeat([]byte("abcd"), 10000))
+	lw.Close()
+	assert.True(buf.Len() < 4000, "repetitive data shrinks", t)
+}
@@ -0,0 +1,333 @@
+// Package tiffenc writes baseline TIFF images with a choice of compression.
+// golang.org/x/image/tiff can't write LZW, writes the predictor tag only for
+// LZW, and claims 72 DPI for every image, so RAIS uses this for TIFF output.
+package tiffenc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Compression is a TIFF compression scheme
+type Compression int
+
+// Supported compression schemes
+const (
+	None Compression = iota
+	LZW
+	Deflate
+)
+
+// specValue returns the TIFF Compression tag's value for the scheme
+func (c Compression) specValue() uint32 {
+	switch c {
+	case LZW:
+		return 5
+	case Deflate:
+		return 8
+	}
+	return 1
+}
+
+// Options control how an image is encoded
+type Options struct {
+	Compression Compression
+
+	// Predictor turns on horizontal differencing, which usually helps LZW and
+	// Deflate compress photographs.  It's ignored for uncompressed images.
+	Predictor bool
+}
+
+// TIFF tags, field types, and values we write
+const (
+	tImageWidth                = 256
+	tImageLength               = 257
+	tBitsPerSample             = 258
+	tCompression               = 259
+	tPhotometricInterpretation = 262
+	tStripOffsets              = 273
+	tSamplesPerPixel           = 277
+	tRowsPerStrip              = 278
+	tStripByteCounts           = 279
+	tXResolution               = 282
+	tYResolution               = 283
+	tPlanarConfiguration       = 284
+	tResolutionUnit            = 296
+	tPredictor                 = 317
+	tExtraSamples              = 338
+
+	dtShort    = 3
+	dtLong     = 4
+	dtRational = 5
+
+	pBlackIsZero = 1
+	pRGB         = 2
+
+	resNone = 1
+
+	prHorizontal = 2
+
+	// unassociatedAlpha is the ExtraSamples value for non-premultiplied alpha
+	unassociatedAlpha = 2
+)
+
+// stripSize is the approximate size, in uncompressed bytes, of each strip
+const stripSize = 64 << 10
+
+// layout describes how an image's pixels are stored
+type layout struct {
+	samples     int
+	bits        int
+	photometric uint32
+	alpha       bool
+}
+
+// layoutFor picks the smallest lossless layout for the image: gray, RGB for
+// opaque images, and RGBA otherwise, with 16 bits per sample for 16-bit
+// color models
+func layoutFor(m image.Image) layout {
+	var bits = 8
+	switch m.ColorModel() {
+	case color.GrayModel:
+		return layout{samples: 1, bits: 8, photometric: pBlackIsZero}
+	case color.Gray16Model:
+		return layout{samples: 1, bits: 16, photometric: pBlackIsZero}
+	case color.RGBA64Model, color.NRGBA64Model:
+		bits = 16
+	}
+	if o, ok := m.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return layout{samples: 3, bits: bits, photometric: pRGB}
+	}
+	return layout{samples: 4, bits: bits, photometric: pRGB, alpha: true}
+}
+
+// readRow fills row with the pixels of row y in the given layout
+func readRow(m image.Image, l layout, y int, row []byte) {
+	var b = m.Bounds()
+	switch {
+	case l.samples == 1 && l.bits == 8:
+		if g, ok := m.(*image.Gray); ok {
+			copy(row, g.Pix[g.PixOffset(b.Min.X, y):])
+			return
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row[x-b.Min.X] = color.GrayModel.Convert(m.At(x, y)).(color.Gray).Y
+		}
+	case l.samples == 1:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var v = color.Gray16Model.Convert(m.At(x, y)).(color.Gray16).Y
+			binary.LittleEndian.PutUint16(row[(x-b.Min.X)*2:], v)
+		}
+	case l.bits == 16:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c = color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+			var i = (x - b.Min.X) * l.samples * 2
+			binary.LittleEndian.PutUint16(row[i:], c.R)
+			binary.LittleEndian.PutUint16(row[i+2:], c.G)
+			binary.LittleEndian.PutUint16(row[i+4:], c.B)
+			if l.alpha {
+				binary.LittleEndian.PutUint16(row[i+6:], c.A)
+			}
+		}
+	case !l.alpha:
+		if rgba, ok := m.(*image.RGBA); ok {
+			var pix = rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+			for i := 0; i < b.Dx(); i++ {
+				copy(row[i*3:i*3+3], pix[i*4:i*4+3])
+			}
+			return
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var r, g, bl, _ = m.At(x, y).RGBA()
+			var i = (x - b.Min.X) * 3
+			row[i], row[i+1], row[i+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+		}
+	default:
+		if n, ok := m.(*image.NRGBA); ok {
+			copy(row, n.Pix[n.PixOffset(b.Min.X, y):])
+			return
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c = color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			var i = (x - b.Min.X) * 4
+			row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// difference applies the horizontal predictor to a row, replacing each
+// sample with its difference from the same sample in the previous pixel
+func difference(row []byte, l layout) {
+	if l.bits == 16 {
+		for i := len(row) - 2; i >= 2*l.samples; i -= 2 {
+			var v = binary.LittleEndian.Uint16(row[i:]) - binary.LittleEndian.Uint16(row[i-2*l.samples:])
+			binary.LittleEndian.PutUint16(row[i:], v)
+		}
+		return
+	}
+	for i := len(row) - 1; i >= l.samples; i-- {
+		row[i] -= row[i-l.samples]
+	}
+}
+
+// Encode writes m to w as a little-endian TIFF.  Rows are read from the top
+// down, so images which decode in bands can be encoded without holding all
+// their pixels.
+func Encode(w io.Writer, m image.Image, opt *Options) error {
+	if opt == nil {
+		opt = &Options{}
+	}
+	var b = m.Bounds()
+	var l = layoutFor(m)
+	var rowBytes = b.Dx() * l.samples * l.bits / 8
+	var rowsPerStrip = 1
+	if rowBytes > 0 && rowBytes < stripSize {
+		rowsPerStrip = stripSize / rowBytes
+	}
+	var predictor = opt.Predictor && opt.Compression != None
+
+	// Strips are compressed into memory first, as the IFD needs their sizes
+	var data bytes.Buffer
+	var offsets, counts []uint32
+	var row = make([]byte, rowBytes)
+	for y := b.Min.Y; y < b.Max.Y; y += rowsPerStrip {
+		var start = data.Len()
+		var sw io.WriteCloser
+		switch opt.Compression {
+		case LZW:
+			sw = newLZWWriter(&data)
+		case Deflate:
+			sw = zlib.NewWriter(&data)
+		default:
+			sw = nopCloser{&data}
+		}
+		for sy := y; sy < y+rowsPerStrip && sy < b.Max.Y; sy++ {
+			readRow(m, l, sy, row)
+			if predictor {
+				difference(row, l)
+			}
+			if _, err := sw.Write(row); err != nil {
+				return err
+			}
+		}
+		if err := sw.Close(); err != nil {
+			return err
+		}
+		offsets = append(offsets, uint32(8+start))
+		counts = append(counts, uint32(data.Len()-start))
+	}
+	if data.Len()%2 == 1 {
+		data.WriteByte(0)
+	}
+
+	var bits = make([]uint32, l.samples)
+	for i := range bits {
+		bits[i] = uint32(l.bits)
+	}
+	// The resolution isn't known here, so the unit is "none" rather than the
+	// 72 DPI most writers claim; RAIS patches in a real DPI when there is one
+	var res = []uint32{1, 1}
+	var entries = []ifdEntry{
+		{tImageWidth, dtLong, []uint32{uint32(b.Dx())}},
+		{tImageLength, dtLong, []uint32{uint32(b.Dy())}},
+		{tBitsPerSample, dtShort, bits},
+		{tCompression, dtShort, []uint32{opt.Compression.specValue()}},
+		{tPhotometricInterpretation, dtShort, []uint32{l.photometric}},
+		{tStripOffsets, dtLong, offsets},
+		{tSamplesPerPixel, dtShort, []uint32{uint32(l.samples)}},
+		{tRowsPerStrip, dtLong, []uint32{uint32(rowsPerStrip)}},
+		{tStripByteCounts, dtLong, counts},
+		{tXResolution, dtRational, res},
+		{tYResolution, dtRational, res},
+		{tPlanarConfiguration, dtShort, []uint32{1}},
+		{tResolutionUnit, dtShort, []uint32{resNone}},
+	}
+	if predictor {
+		entries = append(entries, ifdEntry{tPredictor, dtShort, []uint32{prHorizontal}})
+	}
+	if l.alpha {
+		entries = append(entries, ifdEntry{tExtraSamples, dtShort, []uint32{unassociatedAlpha}})
+	}
+
+	var ifdOffset = uint32(8 + data.Len())
+	var header = []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[4:], ifdOffset)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := data.WriteTo(w); err != nil {
+		return err
+	}
+	_, err := w.Write(ifd(entries, ifdOffset))
+	return err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// ifdEntry is a single tag in an image file directory
+type ifdEntry struct {
+	tag      uint16
+	datatype uint16
+	data     []uint32
+}
+
+// size returns the number of bytes the entry's data takes.  Rationals are
+// stored as numerator/denominator pairs, so they're four bytes per value
+// like longs.
+func (e ifdEntry) size() int {
+	if e.datatype == dtShort {
+		return 2 * len(e.data)
+	}
+	return 4 * len(e.data)
+}
+
+// put writes the entry's data to p
+func (e ifdEntry) put(p []byte) {
+	for i, v := range e.data {
+		if e.datatype == dtShort {
+			binary.LittleEndian.PutUint16(p[2*i:], uint16(v))
+		} else {
+			binary.LittleEndian.PutUint32(p[4*i:], v)
+		}
+	}
+}
+
+// ifd serializes the entries, which must be sorted by tag, as the only IFD
+// in a file, followed by any data too large to fit in the entries
+// themselves.  offset is where the IFD will be written in the file.
+func ifd(entries []ifdEntry, offset uint32) []byte {
+	var dirLen = 2 + 12*len(entries) + 4
+	var out = make([]byte, dirLen)
+	binary.LittleEndian.PutUint16(out, uint16(len(entries)))
+	for i, e := range entries {
+		var p = out[2+12*i:]
+		binary.LittleEndian.PutUint16(p, e.tag)
+		binary.LittleEndian.PutUint16(p[2:], e.datatype)
+		var count = len(e.data)
+		if e.datatype == dtRational {
+			count /= 2
+		}
+		binary.LittleEndian.PutUint32(p[4:], uint32(count))
+
+		if e.size() <= 4 {
+			e.put(p[8:12])
+			continue
+		}
+		binary.LittleEndian.PutUint32(p[8:], offset+uint32(len(out)))
+		var extra = make([]byte, e.size())
+		e.put(extra)
+		out = append(out, extra...)
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package tiffenc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"golang.org/x/image/tiff"
+)
+
+func testImages() map[string]image.Image {
+	var r = image.Rect(0, 0, 300, 250)
+	var gray, gray16 = image.NewGray(r), image.NewGray16(r)
+	var rgba, nrgba = image.NewRGBA(r), image.NewNRGBA(r)
+	var rgba64, nrgba64 = image.NewRGBA64(r), image.NewNRGBA64(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			gray.SetGray(x, y, color.Gray{uint8(x ^ y)})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x*y*7 + x)})
+			rgba.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x * y), uint8(y * 3)})
+			rgba64.SetRGBA64(x, y, color.RGBA64{uint16(x * 211), uint16(y * 257), uint16(x*y + 3), 0xffff})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 211), uint16(y * 257), uint16(x * y), uint16(y * 259)})
+		}
+	}
+	return map[string]image.Image{
+		"gray":    gray,
+		"gray16":  gray16,
+		"rgba":    rgba,
+		"nrgba":   nrgba,
+		"rgba64":  rgba64,
+		"nrgba64": nrgba64,
+		"sub":     rgba.SubImage(image.Rect(10, 20, 110, 70)),
+	}
+}
+
+func samePixels(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	var ao, bo = a.Bounds().Min, b.Bounds().Min
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			var r1, g1, b1, a1 = a.At(ao.X+x, ao.Y+y).RGBA()
+			var r2, g2, b2, a2 = b.At(bo.X+x, bo.Y+y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestEncodeDecodes(t *testing.T) {
+	for name, img := range testImages() {
+		for _, c := range []Compression{None, LZW, Deflate} {
+			for _, p := range []bool{false, true} {
+				var label = fmt.Sprintf("%s, compression %d, predictor %v", name, c, p)
+				var buf = new(bytes.Buffer)
+				assert.NilError(Encode(buf, img, &Options{Compression: c, Predictor: p}), label, t)
+
+				var out, err = tiff.Decode(buf)
+				assert.NilError(err, label, t)
+				assert.True(samePixels(img, out), label, t)
+			}
+		}
+	}
+}
+
+func TestEncodeCompresses(t *testing.T) {
+	var img = testImages()["rgba"]
+	var sizes = make(map[Compression]int)
+	for _, c := range []Compression{None, LZW, Deflate} {
+		var buf = new(bytes.Buffer)
+		Encode(buf, img, &Options{Compression: c, Predictor: true})
+		sizes[c] = buf.Len()
+	}
+	assert.True(sizes[LZW] < sizes[None], "LZW is smaller than none", t)
+	assert.True(sizes[Deflate] < sizes[None], "deflate is smaller than none", t)
+}
+
+func TestEncodeResolutionUnit(t *testing.T) {
+	var buf = new(bytes.Buffer)
+	Encode(buf, testImages()["gray"], nil)
+	var data = buf.Bytes()
+
+	var unit = -1
+	var off = int(data[4]) | int(data[5])<<8 | int(data[6])<<16 | int(data[7])<<24
+	var n = int(data[off]) | int(data[off+1])<<8
+	for i := 0; i < n; i++ {
+		var e = data[off+2+12*i:]
+		if int(e[0])|int(e[1])<<8 == tResolutionUnit {
+			unit = int(e[8]) | int(e[9])<<8
+		}
+	}
+	assert.Equal(resNone, unit, "resolution unit", t)
+}
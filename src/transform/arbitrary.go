@@ -0,0 +1,90 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// RotatedSize returns the dimensions of the smallest canvas which can hold a
+// w x h image rotated by the given number of degrees
+func RotatedSize(w, h int, degrees float64) (int, int) {
+	var sin, cos = math.Sincos(degrees * math.Pi / 180)
+	sin, cos = math.Abs(sin), math.Abs(cos)
+	var fw = float64(w)*cos + float64(h)*sin
+	var fh = float64(w)*sin + float64(h)*cos
+
+	// Tiny floating-point errors shouldn't add a row or column of background
+	return int(math.Ceil(fw - 1e-6)), int(math.Ceil(fh - 1e-6))
+}
+
+// RotateArbitrary returns a copy of src rotated clockwise by any number of
+// degrees.  The canvas grows to hold the entire rotated image, and the
+// corners it exposes are filled with bg.  Pixels are sampled bilinearly, so
+// the image's edges blend smoothly into the background.
+//
+// Grayscale images stay grayscale if bg is opaque; anything else is rotated
+// as RGBA.
+func RotateArbitrary(src image.Image, degrees float64, bg color.Color) image.Image {
+	var b = src.Bounds()
+	var dw, dh = RotatedSize(b.Dx(), b.Dy(), degrees)
+
+	var _, _, _, a = bg.RGBA()
+	if gray, ok := src.(*image.Gray); ok && a == 0xffff {
+		var dst = image.NewGray(image.Rect(0, 0, dw, dh))
+		var g = color.GrayModel.Convert(bg).(color.Gray)
+		rotatePix(gray.Pix, gray.Stride, b.Dx(), b.Dy(), dst.Pix, dst.Stride, dw, dh, []uint8{g.Y}, degrees)
+		return dst
+	}
+
+	var rgba, ok = src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	}
+	var dst = image.NewRGBA(image.Rect(0, 0, dw, dh))
+	var c = color.RGBAModel.Convert(bg).(color.RGBA)
+	rotatePix(rgba.Pix, rgba.Stride, b.Dx(), b.Dy(), dst.Pix, dst.Stride, dw, dh, []uint8{c.R, c.G, c.B, c.A}, degrees)
+	return dst
+}
+
+// rotatePix fills dst by mapping each destination pixel's center back into
+// the source and interpolating between the four nearest source pixels.
+// Samples which fall outside the source use bg, which also tells us how many
+// channels each pixel has.  Pixel data must be premultiplied (or opaque) for
+// the blending to be correct.
+func rotatePix(src []uint8, srcStride, sw, sh int, dst []uint8, dstStride, dw, dh int, bg []uint8, degrees float64) {
+	var channels = len(bg)
+	var sin, cos = math.Sincos(degrees * math.Pi / 180)
+	var scx, scy = float64(sw) / 2, float64(sh) / 2
+	var dcx, dcy = float64(dw) / 2, float64(dh) / 2
+
+	var sample = func(x, y, ch int) float64 {
+		if x < 0 || y < 0 || x >= sw || y >= sh {
+			return float64(bg[ch])
+		}
+		return float64(src[y*srcStride+x*channels+ch])
+	}
+
+	for y := 0; y < dh; y++ {
+		var dy = float64(y) + 0.5 - dcy
+		for x := 0; x < dw; x++ {
+			var dx = float64(x) + 0.5 - dcx
+
+			// Inverse rotation takes us to the source coordinate; subtracting 0.5
+			// makes pixel centers land on integers for interpolation
+			var fx = dx*cos + dy*sin + scx - 0.5
+			var fy = -dx*sin + dy*cos + scy - 0.5
+			var x0, y0 = int(math.Floor(fx)), int(math.Floor(fy))
+			var wx, wy = fx - float64(x0), fy - float64(y0)
+
+			var idx = y*dstStride + x*channels
+			for ch := 0; ch < channels; ch++ {
+				var top = sample(x0, y0, ch)*(1-wx) + sample(x0+1, y0, ch)*wx
+				var bottom = sample(x0, y0+1, ch)*(1-wx) + sample(x0+1, y0+1, ch)*wx
+				dst[idx+ch] = uint8(top*(1-wy) + bottom*wy + 0.5)
+			}
+		}
+	}
+}
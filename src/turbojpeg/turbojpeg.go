@@ -0,0 +1,64 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+// Package turbojpeg encodes progressive JPEGs via libjpeg-turbo's TurboJPEG
+// API, since Go's JPEG encoder only writes baseline images
+package turbojpeg
+
+// #cgo LDFLAGS: -lturbojpeg
+// #include <stdlib.h>
+// #include <turbojpeg.h>
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// EncodeProgressive writes img to w as a progressive JPEG at the given
+// quality (1-100).  Gray images are encoded as grayscale JPEGs, and all others
+// as 4:2:0 color.
+func EncodeProgressive(w io.Writer, img image.Image, quality int) error {
+	var b = img.Bounds()
+	if b.Empty() {
+		return errors.New("cannot encode an empty image")
+	}
+
+	var pix []byte
+	var stride int
+	var pf, samp C.int = C.TJPF_RGBA, C.TJSAMP_420
+	switch i := img.(type) {
+	case *image.Gray:
+		pix, stride = i.Pix[i.PixOffset(b.Min.X, b.Min.Y):], i.Stride
+		pf, samp = C.TJPF_GRAY, C.TJSAMP_GRAY
+	case *image.RGBA:
+		pix, stride = i.Pix[i.PixOffset(b.Min.X, b.Min.Y):], i.Stride
+	default:
+		var rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+		pix, stride = rgba.Pix, rgba.Stride
+	}
+
+	var handle = C.tjInitCompress()
+	if handle == nil {
+		return errors.New("unable to initialize TurboJPEG")
+	}
+	defer C.tjDestroy(handle)
+
+	var out *C.uchar
+	var size C.ulong
+	var rv = C.tjCompress2(handle, (*C.uchar)(unsafe.Pointer(&pix[0])), C.int(b.Dx()), C.int(stride),
+		C.int(b.Dy()), pf, &out, &size, samp, C.int(quality), C.TJFLAG_PROGRESSIVE)
+	if out != nil {
+		defer C.tjFree(out)
+	}
+	if rv != 0 {
+		return errors.New("unable to encode JPEG: " + C.GoString(C.tjGetErrorStr2(handle)))
+	}
+
+	var _, err = w.Write(C.GoBytes(unsafe.Pointer(out), C.int(size)))
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package turbojpeg
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// EncodeProgressive writes img to w as a JPEG at the given quality (1-100).
+// Without libjpeg-turbo, this falls back to Go's encoder, so the JPEG is
+// baseline rather than progressive.
+func EncodeProgressive(w io.Writer, img image.Image, quality int) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
@@ -0,0 +1,42 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package turbojpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// isProgressive returns true if the JPEG data has a progressive (SOF2) frame
+func isProgressive(data []byte) bool {
+	return bytes.Contains(data, []byte{0xFF, 0xC2})
+}
+
+func TestEncodeProgressive(t *testing.T) {
+	var src = image.NewNRGBA(image.Rect(5, 5, 105, 55))
+	for y := 5; y < 55; y++ {
+		for x := 5; x < 105; x++ {
+			src.Set(x, y, color.NRGBA{uint8(x), uint8(y * 4), 90, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.NilError(EncodeProgressive(&buf, src, 80), "color", t)
+	assert.True(isProgressive(buf.Bytes()), "color JPEG is progressive", t)
+	var cfg, err = jpeg.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	assert.NilError(err, "decoding color", t)
+	assert.Equal(100, cfg.Width, "width", t)
+	assert.Equal(50, cfg.Height, "height", t)
+
+	buf.Reset()
+	assert.NilError(EncodeProgressive(&buf, image.NewGray(image.Rect(0, 0, 40, 30)), 80), "gray", t)
+	cfg, err = jpeg.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	assert.NilError(err, "decoding gray", t)
+	assert.Equal(color.GrayModel, cfg.ColorModel, "gray JPEG", t)
+}
@@ -0,0 +1,21 @@
+//go:build !vips || !cgo || purego
+// +build !vips !cgo purego
+
+package vips
+
+import "image"
+
+// Enabled is false unless RAIS is built with the "vips" tag and cgo
+const Enabled = false
+
+func readHeader(string) (int, int, error) {
+	return 0, 0, ErrNotBuilt
+}
+
+func decodeRegion(string, int, image.Rectangle, int, int) (image.Image, error) {
+	return nil, ErrNotBuilt
+}
+
+func encodeJPEG([]byte, int, int, int, int) ([]byte, error) {
+	return nil, ErrNotBuilt
+}
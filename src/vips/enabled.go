@@ -0,0 +1,212 @@
+//go:build vips && cgo && !purego
+// +build vips,cgo,!purego
+
+package vips
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// rais_vips_init starts libvips.  Its operation cache is disabled, since it
+// could serve stale pixels for files replaced on disk, and RAIS has its own
+// caches anyway.
+static int rais_vips_init() {
+	if (VIPS_INIT("rais")) {
+		return -1;
+	}
+	vips_cache_set_max(0);
+	return 0;
+}
+
+// rais_vips_header reads filename's dimensions without decoding any pixels
+static int rais_vips_header(const char *filename, int *width, int *height) {
+	VipsImage *in = vips_image_new_from_file(filename, NULL);
+	if (in == NULL) {
+		return -1;
+	}
+	*width = vips_image_get_width(in);
+	*height = vips_image_get_height(in);
+	g_object_unref(in);
+	return 0;
+}
+
+// rais_vips_decode loads filename, shrinking it on load if shrink is more
+// than one, then crops, resizes, and converts it to 8-bit gray or sRGB with
+// no alpha.  On success the pixels are in *buf, which the caller must free
+// with g_free.
+static int rais_vips_decode(const char *filename, int shrink,
+	int left, int top, int width, int height, int out_w, int out_h,
+	void **buf, size_t *len, int *res_w, int *res_h, int *bands)
+{
+	VipsObject *base = VIPS_OBJECT(vips_image_new());
+	VipsImage **t = (VipsImage **) vips_object_local_array(base, 6);
+	VipsImage *in;
+	VipsInterpretation space;
+
+	if (shrink > 1) {
+		t[0] = vips_image_new_from_file(filename, "shrink", shrink, "access", VIPS_ACCESS_RANDOM, NULL);
+	} else {
+		t[0] = vips_image_new_from_file(filename, "access", VIPS_ACCESS_RANDOM, NULL);
+	}
+	if (t[0] == NULL) {
+		goto fail;
+	}
+	in = t[0];
+
+	if (vips_extract_area(in, &t[1], left, top, width, height, NULL)) {
+		goto fail;
+	}
+	in = t[1];
+
+	if (out_w != width || out_h != height) {
+		if (vips_resize(in, &t[2], (double) out_w / width, "vscale", (double) out_h / height, NULL)) {
+			goto fail;
+		}
+		in = t[2];
+	}
+
+	if (vips_image_hasalpha(in)) {
+		if (vips_flatten(in, &t[3], NULL)) {
+			goto fail;
+		}
+		in = t[3];
+	}
+
+	space = vips_image_get_bands(in) < 3 ? VIPS_INTERPRETATION_B_W : VIPS_INTERPRETATION_sRGB;
+	if (vips_colourspace(in, &t[4], space, NULL)) {
+		goto fail;
+	}
+	if (vips_cast_uchar(t[4], &t[5], NULL)) {
+		goto fail;
+	}
+	in = t[5];
+
+	*buf = vips_image_write_to_memory(in, len);
+	if (*buf == NULL) {
+		goto fail;
+	}
+	*res_w = vips_image_get_width(in);
+	*res_h = vips_image_get_height(in);
+	*bands = vips_image_get_bands(in);
+	g_object_unref(base);
+	return 0;
+
+fail:
+	g_object_unref(base);
+	return -1;
+}
+
+// rais_vips_jpeg encodes 8-bit gray or RGB pixels as a JPEG.  On success the
+// data is in *buf, which the caller must free with g_free.
+static int rais_vips_jpeg(void *pix, int width, int height, int bands,
+	int quality, void **buf, size_t *len)
+{
+	VipsImage *in = vips_image_new_from_memory(pix, (size_t) width * height * bands,
+		width, height, bands, VIPS_FORMAT_UCHAR);
+	int err;
+
+	if (in == NULL) {
+		return -1;
+	}
+	err = vips_jpegsave_buffer(in, buf, len, "Q", quality, NULL);
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+import (
+	"errors"
+	"image"
+	"sync"
+	"unsafe"
+)
+
+// Enabled is true when RAIS is built with the "vips" tag
+const Enabled = true
+
+var initOnce sync.Once
+var initErr error
+
+// start initializes libvips the first time it's needed
+func start() error {
+	initOnce.Do(func() {
+		if C.rais_vips_init() != 0 {
+			initErr = lastError()
+		}
+	})
+	return initErr
+}
+
+// lastError returns and clears libvips's error buffer
+func lastError() error {
+	var msg = C.GoString(C.vips_error_buffer())
+	C.vips_error_clear()
+	if msg == "" {
+		msg = "unknown libvips error"
+	}
+	return errors.New(msg)
+}
+
+// readHeader returns the dimensions of the image at path
+func readHeader(path string) (int, int, error) {
+	var err = start()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var cPath = C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var w, h C.int
+	if C.rais_vips_header(cPath, &w, &h) != 0 {
+		return 0, 0, lastError()
+	}
+	return int(w), int(h), nil
+}
+
+// decodeRegion decodes the r area of the image at path, after shrinking by
+// shrink on load, and resizes it to w x h
+func decodeRegion(path string, shrink int, r image.Rectangle, w, h int) (image.Image, error) {
+	var err = start()
+	if err != nil {
+		return nil, err
+	}
+
+	var cPath = C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var buf unsafe.Pointer
+	var length C.size_t
+	var resW, resH, bands C.int
+	var rv = C.rais_vips_decode(cPath, C.int(shrink),
+		C.int(r.Min.X), C.int(r.Min.Y), C.int(r.Dx()), C.int(r.Dy()), C.int(w), C.int(h),
+		&buf, &length, &resW, &resH, &bands)
+	if rv != 0 {
+		return nil, lastError()
+	}
+	defer C.g_free(C.gpointer(buf))
+
+	return pixelsImage(C.GoBytes(buf, C.int(length)), int(resW), int(resH), int(bands))
+}
+
+// encodeJPEG compresses packed 8-bit pixels with one or three bands
+func encodeJPEG(pix []byte, w, h, bands, quality int) ([]byte, error) {
+	var err = start()
+	if err != nil {
+		return nil, err
+	}
+	if len(pix) == 0 {
+		return nil, errors.New("cannot encode an empty image")
+	}
+
+	var buf unsafe.Pointer
+	var length C.size_t
+	var rv = C.rais_vips_jpeg(unsafe.Pointer(&pix[0]), C.int(w), C.int(h), C.int(bands), C.int(quality), &buf, &length)
+	if rv != 0 {
+		return nil, lastError()
+	}
+	defer C.g_free(C.gpointer(buf))
+
+	return C.GoBytes(buf, C.int(length)), nil
+}
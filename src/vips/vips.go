@@ -0,0 +1,195 @@
+// Package vips decodes, crops, and resizes images with libvips, which is
+// substantially faster than RAIS's own resize path for large TIFFs and JPEGs,
+// and can encode JPEGs.  It's only functional when RAIS is built with the
+// "vips" tag, e.g., "make TAGS=vips", which requires the libvips development
+// files.  Otherwise Enabled is false and Open always fails.
+package vips
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotBuilt is returned by everything in this package when RAIS wasn't
+// built with the "vips" tag
+var ErrNotBuilt = errors.New("RAIS was not built with libvips support")
+
+// Image is a local image file, set up for decoding through libvips
+type Image struct {
+	path         string
+	jpeg         bool
+	width        int
+	height       int
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+}
+
+// Open reads the image's dimensions and returns a decode-ready Image.  Pixel
+// data isn't read until DecodeImage is called.
+func Open(path string) (*Image, error) {
+	var w, h, err = readHeader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ext = strings.ToLower(filepath.Ext(path))
+	return &Image{path: path, jpeg: ext == ".jpg" || ext == ".jpeg", width: w, height: h}, nil
+}
+
+// GetWidth returns the image width
+func (i *Image) GetWidth() int {
+	return i.width
+}
+
+// GetHeight returns the image height
+func (i *Image) GetHeight() int {
+	return i.height
+}
+
+// GetTileWidth returns 0; libvips reads whatever the request needs, so tiles
+// aren't advertised
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0; libvips reads whatever the request needs, so tiles
+// aren't advertised
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1, as pyramid levels aren't advertised
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image crop area for decoding an image
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// computeDecodeParameters sets up decode area, decode width, and decode
+// height based on the image's info, filling in a zero dimension from the
+// crop's aspect ratio
+func (i *Image) computeDecodeParameters() {
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, i.width, i.height)
+	}
+	i.decodeArea = i.decodeArea.Intersect(image.Rect(0, 0, i.width, i.height))
+
+	var a = i.decodeArea
+	switch {
+	case i.decodeWidth == 0 && i.decodeHeight == 0:
+		i.decodeWidth, i.decodeHeight = a.Dx(), a.Dy()
+	case i.decodeWidth == 0 && a.Dy() > 0:
+		i.decodeWidth = (a.Dx()*i.decodeHeight + a.Dy()/2) / a.Dy()
+	case i.decodeHeight == 0 && a.Dx() > 0:
+		i.decodeHeight = (a.Dy()*i.decodeWidth + a.Dx()/2) / a.Dx()
+	}
+	if i.decodeWidth < 1 {
+		i.decodeWidth = 1
+	}
+	if i.decodeHeight < 1 {
+		i.decodeHeight = 1
+	}
+}
+
+// shrinkOnLoad returns how much libvips's JPEG loader should shrink the image
+// while decoding it: the largest of 1, 2, 4, or 8 which still leaves at least
+// as many pixels as the output needs.  Other formats always load at full
+// size.
+func (i *Image) shrinkOnLoad() int {
+	if !i.jpeg {
+		return 1
+	}
+
+	var s = 8
+	for s > 1 && (i.decodeArea.Dx()/s < i.decodeWidth || i.decodeArea.Dy()/s < i.decodeHeight) {
+		s /= 2
+	}
+	return s
+}
+
+// DecodeImage returns an image.Image that holds the decoded image data,
+// cropped and resized as requested.  Images with one or two bands decode to
+// image.Gray; all others are converted to sRGB and decode to image.RGBA.  Any
+// alpha channel is flattened.
+func (i *Image) DecodeImage() (image.Image, error) {
+	i.computeDecodeParameters()
+	if i.decodeArea.Empty() {
+		return nil, errors.New("crop area is outside the image")
+	}
+
+	// The JPEG loader shrinks by whole factors, rounding up, so the crop is
+	// mapped onto the shrunken image and clamped to it
+	var s = i.shrinkOnLoad()
+	var a = i.decodeArea
+	var r = image.Rect(a.Min.X/s, a.Min.Y/s, (a.Max.X+s-1)/s, (a.Max.Y+s-1)/s)
+	r = r.Intersect(image.Rect(0, 0, (i.width+s-1)/s, (i.height+s-1)/s))
+
+	return decodeRegion(i.path, s, r, i.decodeWidth, i.decodeHeight)
+}
+
+// pixelsImage wraps decoded 8-bit pixels with one band (gray), three (RGB),
+// or four (RGBA) as a Go image
+func pixelsImage(pix []byte, w, h, bands int) (image.Image, error) {
+	if len(pix) < w*h*bands {
+		return nil, errors.New("libvips returned too little pixel data")
+	}
+
+	var r = image.Rect(0, 0, w, h)
+	switch bands {
+	case 1:
+		return &image.Gray{Pix: pix, Stride: w, Rect: r}, nil
+	case 3:
+		var m = image.NewRGBA(r)
+		for s, d := 0, 0; d < len(m.Pix); s, d = s+3, d+4 {
+			m.Pix[d], m.Pix[d+1], m.Pix[d+2], m.Pix[d+3] = pix[s], pix[s+1], pix[s+2], 255
+		}
+		return m, nil
+	case 4:
+		return &image.RGBA{Pix: pix, Stride: w * 4, Rect: r}, nil
+	}
+	return nil, errors.New("libvips returned an unsupported number of bands")
+}
+
+// EncodeJPEG writes m to w as a JPEG at the given quality.  Grayscale images
+// are encoded with a single channel; everything else is encoded as RGB.
+func EncodeJPEG(w io.Writer, m image.Image, quality int) error {
+	var b = m.Bounds()
+	var pix []byte
+	var bands int
+	switch m.(type) {
+	case *image.Gray, *image.Gray16:
+		var g = image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(g, g.Rect, m, b.Min, draw.Src)
+		pix, bands = g.Pix, 1
+	default:
+		var rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, m, b.Min, draw.Src)
+		pix, bands = make([]byte, b.Dx()*b.Dy()*3), 3
+		for s, d := 0, 0; s < len(rgba.Pix); s, d = s+4, d+3 {
+			pix[d], pix[d+1], pix[d+2] = rgba.Pix[s], rgba.Pix[s+1], rgba.Pix[s+2]
+		}
+	}
+
+	var data, err = encodeJPEG(pix, b.Dx(), b.Dy(), bands, quality)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,62 @@
+package vips
+
+import (
+	"image"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestComputeDecodeParameters(t *testing.T) {
+	var i = &Image{width: 4000, height: 3000}
+	i.SetResizeWH(400, 0)
+	i.computeDecodeParameters()
+	assert.Equal(image.Rect(0, 0, 4000, 3000), i.decodeArea, "full image", t)
+	assert.Equal(300, i.decodeHeight, "height follows the aspect ratio", t)
+
+	i = &Image{width: 4000, height: 3000}
+	i.SetCrop(image.Rect(3000, 2000, 5000, 4000))
+	i.computeDecodeParameters()
+	assert.Equal(image.Rect(3000, 2000, 4000, 3000), i.decodeArea, "crop is clamped", t)
+	assert.Equal(1000, i.decodeWidth, "unscaled width", t)
+	assert.Equal(1000, i.decodeHeight, "unscaled height", t)
+}
+
+func TestShrinkOnLoad(t *testing.T) {
+	var i = &Image{width: 4000, height: 3000, jpeg: true}
+	i.SetResizeWH(500, 0)
+	i.computeDecodeParameters()
+	assert.Equal(8, i.shrinkOnLoad(), "thumbnail shrinks fully", t)
+
+	i = &Image{width: 4000, height: 3000, jpeg: true}
+	i.SetResizeWH(1500, 0)
+	i.computeDecodeParameters()
+	assert.Equal(2, i.shrinkOnLoad(), "shrink leaves enough pixels", t)
+
+	i = &Image{width: 4000, height: 3000}
+	i.SetResizeWH(500, 0)
+	i.computeDecodeParameters()
+	assert.Equal(1, i.shrinkOnLoad(), "only JPEGs shrink on load", t)
+}
+
+func TestPixelsImage(t *testing.T) {
+	var m, err = pixelsImage([]byte{1, 2, 3, 4, 5, 6}, 2, 1, 3)
+	assert.NilError(err, "RGB pixels", t)
+	var rgba = m.(*image.RGBA)
+	assert.Equal("\x01\x02\x03\xff\x04\x05\x06\xff", string(rgba.Pix), "RGB is expanded to RGBA", t)
+
+	m, err = pixelsImage([]byte{7, 8}, 2, 1, 1)
+	assert.NilError(err, "gray pixels", t)
+	assert.Equal("\x07\x08", string(m.(*image.Gray).Pix), "gray is used as-is", t)
+
+	_, err = pixelsImage([]byte{1}, 2, 1, 1)
+	assert.True(err != nil, "short data is an error", t)
+}
+
+func TestDisabled(t *testing.T) {
+	if Enabled {
+		t.Skip("built with libvips")
+	}
+	var _, err = Open("testfile.jpg")
+	assert.Equal(ErrNotBuilt, err, "Open fails without libvips", t)
+}
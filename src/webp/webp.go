@@ -0,0 +1,69 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+// Package webp encodes images to WebP via libwebp, which the Go extended
+// image library can only decode
+package webp
+
+// #cgo pkg-config: libwebp
+// #include <stdlib.h>
+// #include <webp/encode.h>
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// ErrEncodeFailed is returned when libwebp is unable to encode an image
+var ErrEncodeFailed = errors.New("unable to encode WebP image")
+
+// opaque is implemented by image types which can report whether they have
+// any transparency
+type opaque interface {
+	Opaque() bool
+}
+
+// Encode writes img to w as a lossy WebP with the given quality (0-100).  The
+// alpha channel is only encoded if the image has any transparency.
+func Encode(w io.Writer, img image.Image, quality float32) error {
+	var b = img.Bounds()
+	if b.Empty() {
+		return ErrEncodeFailed
+	}
+
+	var rgba, ok = img.(*image.NRGBA)
+	if !ok || rgba.Rect.Min != image.ZP {
+		rgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	}
+
+	var out *C.uint8_t
+	var size C.size_t
+	var pix = (*C.uint8_t)(unsafe.Pointer(&rgba.Pix[0]))
+	var width, height, stride = C.int(b.Dx()), C.int(b.Dy()), C.int(rgba.Stride)
+	if o, ok := img.(opaque); ok && o.Opaque() {
+		// libwebp wants packed RGB for opaque images, so we strip the alpha
+		var rgb = make([]byte, 0, b.Dx()*b.Dy()*3)
+		for y := 0; y < b.Dy(); y++ {
+			var row = rgba.Pix[y*rgba.Stride : y*rgba.Stride+b.Dx()*4]
+			for x := 0; x < len(row); x += 4 {
+				rgb = append(rgb, row[x], row[x+1], row[x+2])
+			}
+		}
+		pix = (*C.uint8_t)(unsafe.Pointer(&rgb[0]))
+		size = C.WebPEncodeRGB(pix, width, height, width*3, C.float(quality), &out)
+	} else {
+		size = C.WebPEncodeRGBA(pix, width, height, stride, C.float(quality), &out)
+	}
+	if size == 0 || out == nil {
+		return ErrEncodeFailed
+	}
+	defer C.WebPFree(unsafe.Pointer(out))
+
+	var _, err = w.Write(C.GoBytes(unsafe.Pointer(out), C.int(size)))
+	return err
+}
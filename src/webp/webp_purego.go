@@ -0,0 +1,19 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package webp
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// ErrNotBuilt is returned by Encode when RAIS is built without cgo, and
+// therefore without libwebp
+var ErrNotBuilt = errors.New("WebP encoding requires libwebp, which this build doesn't include")
+
+// Encode always fails, as there's no pure-Go WebP encoder
+func Encode(w io.Writer, img image.Image, quality float32) error {
+	return ErrNotBuilt
+}
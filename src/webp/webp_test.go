@@ -0,0 +1,32 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestEncode(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(10, 10, 74, 42))
+	for y := 10; y < 42; y++ {
+		for x := 10; x < 74; x++ {
+			src.Set(x, y, color.RGBA{uint8(x * 3), uint8(y * 5), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.NilError(Encode(&buf, src, 80), "encoding", t)
+	var cfg, err = webp.DecodeConfig(&buf)
+	assert.NilError(err, "decoding", t)
+	assert.Equal(64, cfg.Width, "width", t)
+	assert.Equal(32, cfg.Height, "height", t)
+
+	assert.True(Encode(&buf, image.NewRGBA(image.Rect(0, 0, 0, 0)), 80) != nil, "empty image", t)
+}